@@ -0,0 +1,61 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) GetBFDSessions(c *gin.Context) {
+	if h.BFD == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "bfd disabled"})
+		return
+	}
+	type bfdSessionView struct {
+		Peer         string `json:"peer"`
+		Interface    string `json:"interface,omitempty"`
+		State        string `json:"state"`
+		RemoteState  string `json:"remote_state,omitempty"`
+		Diag         string `json:"diag,omitempty"`
+		RemoteDiag   string `json:"remote_diag,omitempty"`
+		LocalDiscr   uint32 `json:"local_discriminator"`
+		RemoteDiscr  uint32 `json:"remote_discriminator"`
+		MinTxMillis  int64  `json:"min_tx_ms"`
+		MinRxMillis  int64  `json:"min_rx_ms"`
+		Multiplier   int    `json:"multiplier"`
+		TxMillis     int64  `json:"tx_interval_ms"`
+		DetectMillis int64  `json:"detect_time_ms"`
+		UpSince      string `json:"up_since,omitempty"`
+		LastReceived string `json:"last_received,omitempty"`
+		Transitions  int    `json:"transitions"`
+	}
+	sessions := h.BFD.Sessions()
+	out := make([]bfdSessionView, 0, len(sessions))
+	for _, s := range sessions {
+		view := bfdSessionView{
+			Peer:         s.Peer,
+			Interface:    s.Interface,
+			State:        s.State,
+			RemoteState:  s.RemoteState,
+			Diag:         s.Diag,
+			RemoteDiag:   s.RemoteDiag,
+			LocalDiscr:   s.LocalDiscr,
+			RemoteDiscr:  s.RemoteDiscr,
+			MinTxMillis:  s.DesiredMinTx.Milliseconds(),
+			MinRxMillis:  s.RequiredMinRx.Milliseconds(),
+			Multiplier:   s.DetectMult,
+			TxMillis:     s.TxInterval.Milliseconds(),
+			DetectMillis: s.DetectTime.Milliseconds(),
+			Transitions:  s.Transitions,
+		}
+		if !s.UpSince.IsZero() {
+			view.UpSince = s.UpSince.UTC().Format(time.RFC3339)
+		}
+		if !s.LastReceived.IsZero() {
+			view.LastReceived = s.LastReceived.UTC().Format(time.RFC3339)
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) GetBGPNeighbors(c *gin.Context) {
+	if h.BGP == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "bgp disabled"})
+		return
+	}
+	type neighborView struct {
+		Address       string   `json:"address"`
+		Port          int      `json:"port"`
+		RemoteASN     uint32   `json:"remote_asn"`
+		Description   string   `json:"description,omitempty"`
+		State         string   `json:"state"`
+		RemoteID      string   `json:"remote_id,omitempty"`
+		EstablishedAt string   `json:"established_at,omitempty"`
+		UptimeSeconds int64    `json:"uptime_seconds"`
+		Families      []string `json:"families"`
+		Received      int      `json:"received_prefixes"`
+		Accepted      int      `json:"accepted_prefixes"`
+		Advertised    int      `json:"advertised_prefixes"`
+		MaxPrefixes   int      `json:"max_prefixes,omitempty"`
+		Flaps         int      `json:"flaps"`
+		LastError     string   `json:"last_error,omitempty"`
+	}
+	neighbors := h.BGP.Neighbors()
+	out := make([]neighborView, 0, len(neighbors))
+	for _, n := range neighbors {
+		view := neighborView{
+			Address:     n.Address,
+			Port:        n.Port,
+			RemoteASN:   n.RemoteASN,
+			Description: n.Description,
+			State:       n.State,
+			RemoteID:    n.RemoteID,
+			Families:    append([]string{}, n.Families...),
+			Received:    n.Received,
+			Accepted:    n.Accepted,
+			Advertised:  n.Advertised,
+			MaxPrefixes: n.MaxPrefixes,
+			Flaps:       n.Flaps,
+			LastError:   n.LastError,
+		}
+		if !n.EstablishedAt.IsZero() {
+			view.EstablishedAt = n.EstablishedAt.UTC().Format(time.RFC3339)
+			view.UptimeSeconds = int64(time.Since(n.EstablishedAt).Seconds())
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) GetBGPRIB(c *gin.Context) {
+	if h.BGP == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "bgp disabled"})
+		return
+	}
+	type ribView struct {
+		Prefix    string   `json:"prefix"`
+		NextHop   string   `json:"next_hop"`
+		ASPath    []uint32 `json:"as_path"`
+		Origin    string   `json:"origin"`
+		LocalPref uint32   `json:"local_pref"`
+		MED       uint32   `json:"med"`
+		Peer      string   `json:"peer"`
+		External  bool     `json:"external"`
+		Best      bool     `json:"best"`
+	}
+	origins := map[uint8]string{0: "igp", 1: "egp", 2: "incomplete"}
+	entries := h.BGP.RIB()
+	out := make([]ribView, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, ribView{
+			Prefix:    e.Prefix.String(),
+			NextHop:   e.NextHop.String(),
+			ASPath:    append([]uint32{}, e.ASPath...),
+			Origin:    origins[e.Origin],
+			LocalPref: e.LocalPref,
+			MED:       e.MED,
+			Peer:      e.Peer,
+			External:  e.External,
+			Best:      e.Best,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
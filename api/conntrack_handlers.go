@@ -0,0 +1,101 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"router-go/pkg/conntrack"
+
+	"github.com/gin-gonic/gin"
+)
+
+type conntrackTupleView struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	SrcPort int    `json:"src_port,omitempty"`
+	DstPort int    `json:"dst_port,omitempty"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+type conntrackEntryView struct {
+	Zone      string             `json:"zone,omitempty"`
+	Protocol  string             `json:"protocol"`
+	State     string             `json:"state,omitempty"`
+	Status    string             `json:"status"`
+	Original  conntrackTupleView `json:"original"`
+	Reply     conntrackTupleView `json:"reply"`
+	CreatedAt time.Time          `json:"created_at"`
+	LastSeen  time.Time          `json:"last_seen"`
+	ExpiresIn int                `json:"expires_in_seconds"`
+}
+
+// GetConntrack lists tracked connections with per-direction counters.
+// Optional ?zone= and ?protocol= narrow the list.
+func (h *Handlers) GetConntrack(c *gin.Context) {
+	if h.Conntrack == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "conntrack disabled"})
+		return
+	}
+	zone := c.Query("zone")
+	protocol := c.Query("protocol")
+	now := time.Now()
+	entries := h.Conntrack.Entries()
+	out := make([]conntrackEntryView, 0, len(entries))
+	for _, e := range entries {
+		if zone != "" && e.Zone != zone {
+			continue
+		}
+		if protocol != "" && !strings.EqualFold(e.Protocol, protocol) {
+			continue
+		}
+		out = append(out, conntrackEntryViewFrom(e, now))
+	}
+	stats := h.Conntrack.Stats()
+	c.JSON(http.StatusOK, gin.H{
+		"count":    stats.Entries,
+		"max":      stats.Max,
+		"inserted": stats.Inserted,
+		"expired":  stats.Expired,
+		"invalid":  stats.Invalid,
+		"full":     stats.Full,
+		"entries":  out,
+	})
+}
+
+func conntrackEntryViewFrom(e conntrack.Entry, now time.Time) conntrackEntryView {
+	status := "unreplied"
+	if e.SeenReply {
+		status = "replied"
+	}
+	expires := int(e.Expires.Sub(now).Seconds())
+	if expires < 0 {
+		expires = 0
+	}
+	return conntrackEntryView{
+		Zone:     e.Zone,
+		Protocol: e.Protocol,
+		State:    e.State,
+		Status:   status,
+		Original: conntrackTupleView{
+			Src:     e.Src.String(),
+			Dst:     e.Dst.String(),
+			SrcPort: e.SrcPort,
+			DstPort: e.DstPort,
+			Packets: e.OrigPackets,
+			Bytes:   e.OrigBytes,
+		},
+		Reply: conntrackTupleView{
+			Src:     e.ReplySrc.String(),
+			Dst:     e.ReplyDst.String(),
+			SrcPort: e.ReplySrcPort,
+			DstPort: e.ReplyDstPort,
+			Packets: e.ReplyPackets,
+			Bytes:   e.ReplyBytes,
+		},
+		CreatedAt: e.Created,
+		LastSeen:  e.LastSeen,
+		ExpiresIn: expires,
+	}
+}
@@ -3,13 +3,16 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"router-go/internal/metrics"
+	"router-go/pkg/conntrack"
 	"router-go/pkg/firewall"
 	"router-go/pkg/nat"
+	"router-go/pkg/network"
 	"router-go/pkg/qos"
 	"router-go/pkg/routing"
 
@@ -92,3 +95,137 @@ func TestResetFirewallStats(t *testing.T) {
 		t.Fatalf("expected 200, got %d", resetW.Code)
 	}
 }
+
+func TestFirewallRuleCTStateAndConntrack(t *testing.T) {
+	router := setupFirewallRouter()
+	body := []byte(`{"chain":"FORWARD","action":"ACCEPT","ct_state":"established,related"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/firewall", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/firewall", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"ct_state":"established,related"`)) {
+		t.Fatalf("expected ct_state in rules: %s", w.Body.String())
+	}
+	body = []byte(`{"chain":"FORWARD","action":"ACCEPT","ct_state":"bogus"}`)
+	req = httptest.NewRequest(http.MethodPost, "/api/firewall", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid ct_state, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/conntrack", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without conntrack, got %d", w.Code)
+	}
+
+	table := conntrack.NewTable(0, conntrack.Timeouts{})
+	pkt := network.Packet{Metadata: network.PacketMetadata{
+		SrcIP: net.ParseIP("10.0.0.2"), DstIP: net.ParseIP("1.1.1.1"),
+		Protocol: "UDP", SrcPort: 5000, DstPort: 53, Length: 80,
+	}}
+	pkt.CTState = table.Track("", pkt)
+	table.Confirm("", pkt, pkt)
+	h := &Handlers{Conntrack: table}
+	ctRouter := gin.New()
+	RegisterRoutes(ctRouter, h)
+	req = httptest.NewRequest(http.MethodGet, "/api/conntrack?protocol=udp", nil)
+	w = httptest.NewRecorder()
+	ctRouter.ServeHTTP(w, req)
+	var resp struct {
+		Count   int                  `json:"count"`
+		Entries []conntrackEntryView `json:"entries"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Count != 1 || len(resp.Entries) != 1 || resp.Entries[0].Original.Bytes != 80 || resp.Entries[0].Status != "unreplied" {
+		t.Fatalf("unexpected conntrack response: %s", w.Body.String())
+	}
+}
+
+func TestFirewallRulePortListsAndNegation(t *testing.T) {
+	router := setupFirewallRouter()
+	body := []byte(`{"chain":"INPUT","action":"ACCEPT","protocol":"TCP","src_ip":"!10.0.0.0/8","dst_ports":"80,443,8000-8080"}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/firewall", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	req = httptest.NewRequest(http.MethodGet, "/api/firewall", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"src_ip":"!10.0.0.0/8"`)) || !bytes.Contains(w.Body.Bytes(), []byte(`"dst_ports":"80,443,8000-8080"`)) {
+		t.Fatalf("expected matchers in rules: %s", w.Body.String())
+	}
+
+	body = []byte(`{"old_chain":"INPUT","old_action":"ACCEPT","old_protocol":"TCP","old_src_ip":"!10.0.0.0/8","old_dst_ports":"80,443,8000-8080","chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22}`)
+	req = httptest.NewRequest(http.MethodPut, "/api/firewall", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected update by matcher identity, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, body := range []string{
+		`{"chain":"INPUT","action":"ACCEPT","dst_ports":"1000-10"}`,
+		`{"chain":"INPUT","action":"ACCEPT","src_ip":"10.0.0.0/8,bogus"}`,
+	} {
+		req = httptest.NewRequest(http.MethodPost, "/api/firewall", bytes.NewReader([]byte(body)))
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestFirewallZonesEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := firewall.NewEngineWithDefaults(nil, map[string]firewall.Action{"FORWARD": firewall.ActionDrop})
+	engine.SetZones([]firewall.Zone{
+		{Name: "lan", Interfaces: []string{"eth1"}, Forward: firewall.ActionAccept},
+		{Name: "guest", Interfaces: []string{"wlan0"}, Input: firewall.ActionDrop},
+	}, []firewall.ZonePolicy{{From: "guest", To: "lan", Action: firewall.ActionReject}})
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	req := httptest.NewRequest(http.MethodGet, "/api/firewall/zones", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"name":"guest","interfaces":["wlan0"],"input":"DROP"`)) ||
+		!bytes.Contains(w.Body.Bytes(), []byte(`{"from":"guest","to":"lan","action":"REJECT"}`)) {
+		t.Fatalf("unexpected zones response: %s", w.Body.String())
+	}
+
+	body := []byte(`{"from":"lan","to":"guest","action":"DROP"}`)
+	req = httptest.NewRequest(http.MethodPost, "/api/firewall", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	rules := engine.Rules()
+	if len(rules) != 1 || rules[0].Chain != "FORWARD" || rules[0].FromZone != "lan" || rules[0].ToZone != "guest" {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	body = []byte(`{"from":"dmz","action":"DROP"}`)
+	req = httptest.NewRequest(http.MethodPost, "/api/firewall", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown zone, got %d", w.Code)
+	}
+}
@@ -21,7 +21,7 @@ func (h *Handlers) GetHAState(c *gin.Context) {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ha disabled"})
 		return
 	}
-	state := ha.BuildState(h.Firewall, h.NAT, h.QoS, h.Routes)
+	state := ha.BuildStateWithPolicy(h.Firewall, h.NAT, h.QoS, h.Routes, h.RoutePolicy)
 	c.JSON(http.StatusOK, state)
 }
 
@@ -35,7 +35,7 @@ func (h *Handlers) ApplyHAState(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
-	ha.ApplyState(h.Firewall, h.NAT, h.QoS, h.Routes, state)
+	ha.ApplyStateWithPolicy(h.Firewall, h.NAT, h.QoS, h.Routes, h.RoutePolicy, state)
 	if h.HA != nil {
 		h.HA.ApplyState(state)
 	}
@@ -22,16 +22,26 @@ import (
 	"router-go/internal/metrics"
 	"router-go/internal/observability"
 	"router-go/internal/presets"
+	"router-go/pkg/bfd"
+	"router-go/pkg/bgp"
+	"router-go/pkg/conntrack"
 	"router-go/pkg/enrich"
 	"router-go/pkg/firewall"
 	"router-go/pkg/flow"
 	"router-go/pkg/ha"
 	"router-go/pkg/ids"
+	"router-go/pkg/ipset"
 	"router-go/pkg/nat"
+	"router-go/pkg/network"
+	"router-go/pkg/nftables"
+	"router-go/pkg/ospf"
 	"router-go/pkg/p2p"
 	"router-go/pkg/proxy"
 	"router-go/pkg/qos"
+	"router-go/pkg/rip"
 	"router-go/pkg/routing"
+	"router-go/pkg/schedule"
+	"router-go/pkg/vrf"
 
 	"github.com/gin-gonic/gin"
 	"go.yaml.in/yaml/v3"
@@ -48,9 +58,20 @@ type apiKeyView struct {
 
 type Handlers struct {
 	Routes           *routing.Table
+	RoutePolicy      *routing.Policy
+	RouteFilters     *routing.RouteFilters
+	VRFs             *vrf.Manager
+	RouteTracker     *routing.Tracker
+	BGP              *bgp.Speaker
+	OSPF             *ospf.Router
+	RIP              *rip.Router
+	BFD              *bfd.Manager
 	Firewall         *firewall.Engine
 	IDS              *ids.Engine
 	NAT              *nat.Table
+	Conntrack        *conntrack.Table
+	IPSets           *ipset.Registry
+	Schedules        *schedule.Registry
 	QoS              *qos.QueueManager
 	Flow             *flow.Engine
 	P2P              *p2p.Engine
@@ -65,6 +86,7 @@ type Handlers struct {
 	Observability    *observability.Store
 	Alerts           *observability.AlertStore
 	Presets          *presets.Store
+	NFTables         *nftables.Backend
 	vpnMu            sync.Mutex
 	vpnPeers         []VPNPeer
 	dhcpMu           sync.Mutex
@@ -122,37 +144,169 @@ type PolicyBundle struct {
 	Routes           []config.RouteConfig          `json:"routes"`
 	Firewall         []config.FirewallRuleConfig   `json:"firewall"`
 	FirewallDefaults config.FirewallDefaultsConfig `json:"firewall_defaults"`
+	FirewallZones    []config.FirewallZoneConfig   `json:"firewall_zones,omitempty"`
+	ZonePolicies     []config.ZonePolicyConfig     `json:"firewall_zone_policies,omitempty"`
+	IPSets           []config.IPSetConfig          `json:"ip_sets,omitempty"`
+	Schedules        []config.ScheduleConfig       `json:"schedules,omitempty"`
 	NAT              []config.NATRuleConfig        `json:"nat"`
 	QoS              []config.QoSClassConfig       `json:"qos"`
 	IDS              config.IDSConfig              `json:"ids"`
 }
 
 func (h *Handlers) GetRoutes(c *gin.Context) {
+	type nextHopView struct {
+		Gateway   string `json:"gateway"`
+		Interface string `json:"interface"`
+		Weight    int    `json:"weight"`
+		Probe     string `json:"probe,omitempty"`
+		Up        bool   `json:"up"`
+		Packets   uint64 `json:"packets"`
+	}
 	type routeView struct {
-		Destination string `json:"destination"`
-		Gateway     string `json:"gateway"`
-		Interface   string `json:"interface"`
-		Metric      int    `json:"metric"`
+		Destination string        `json:"destination"`
+		Gateway     string        `json:"gateway"`
+		Interface   string        `json:"interface"`
+		Metric      int           `json:"metric"`
+		Distance    int           `json:"distance"`
+		Source      string        `json:"source,omitempty"`
+		Tag         uint32        `json:"tag,omitempty"`
+		Selected    bool          `json:"selected"`
+		NextHops    []nextHopView `json:"next_hops"`
+	}
+	table, ok := h.routeTable(c)
+	if !ok {
+		return
 	}
-	routes := h.Routes.Routes()
+	routes := table.RIB()
 	out := make([]routeView, 0, len(routes))
 	for _, r := range routes {
+		hops := make([]nextHopView, 0, len(r.NextHops))
+		for _, stat := range table.PathStats(r.Route) {
+			hops = append(hops, nextHopView{
+				Gateway:   stat.Gateway.String(),
+				Interface: stat.Interface,
+				Weight:    stat.Weight,
+				Probe:     stat.Probe,
+				Up:        stat.Up,
+				Packets:   stat.Packets,
+			})
+		}
 		out = append(out, routeView{
 			Destination: r.Destination.String(),
 			Gateway:     r.Gateway.String(),
 			Interface:   r.Interface,
 			Metric:      r.Metric,
+			Distance:    r.Distance,
+			Source:      r.Source,
+			Tag:         r.Tag,
+			Selected:    r.Selected,
+			NextHops:    hops,
 		})
 	}
 	c.JSON(http.StatusOK, out)
 }
 
+func (h *Handlers) routeTable(c *gin.Context) (*routing.Table, bool) {
+	mainTable, policy := h.Routes, h.RoutePolicy
+	if inst, scoped, ok := h.vrfFor(c); !ok {
+		return nil, false
+	} else if scoped {
+		mainTable, policy = inst.Routes, inst.Policy
+	}
+	name := strings.TrimSpace(c.Query("table"))
+	if name == "" || name == routing.MainTable {
+		return mainTable, true
+	}
+	if policy != nil {
+		if table, ok := policy.Table(name); ok {
+			return table, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "routing table not found"})
+	return nil, false
+}
+
+func (h *Handlers) vrfFor(c *gin.Context) (*vrf.Instance, bool, bool) {
+	name := strings.TrimSpace(c.Query("vrf"))
+	if name == "" || name == vrf.Default {
+		return nil, false, true
+	}
+	if h.VRFs != nil {
+		if inst, ok := h.VRFs.Get(name); ok {
+			return inst, true, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "vrf not found"})
+	return nil, false, false
+}
+
+func (h *Handlers) firewallFor(c *gin.Context) (*firewall.Engine, bool) {
+	inst, scoped, ok := h.vrfFor(c)
+	if !ok {
+		return nil, false
+	}
+	if scoped {
+		return inst.Firewall, true
+	}
+	return h.Firewall, true
+}
+
+func (h *Handlers) natFor(c *gin.Context) (*nat.Table, bool) {
+	inst, scoped, ok := h.vrfFor(c)
+	if !ok {
+		return nil, false
+	}
+	if scoped {
+		return inst.NAT, true
+	}
+	return h.NAT, true
+}
+
+type nextHopRequest struct {
+	Gateway   string `json:"gateway"`
+	Interface string `json:"interface"`
+	Weight    int    `json:"weight"`
+	Probe     string `json:"probe"`
+}
+
+func parseNextHops(reqs []nextHopRequest) ([]routing.NextHop, error) {
+	var hops []routing.NextHop
+	for _, req := range reqs {
+		var gw net.IP
+		if strings.TrimSpace(req.Gateway) != "" {
+			gw = net.ParseIP(req.Gateway)
+			if gw == nil {
+				return nil, fmt.Errorf("invalid next hop gateway")
+			}
+		}
+		if gw == nil && req.Interface == "" {
+			return nil, fmt.Errorf("next hop requires gateway or interface")
+		}
+		if req.Weight < 0 {
+			return nil, fmt.Errorf("invalid next hop weight")
+		}
+		hops = append(hops, routing.NextHop{
+			Gateway:   gw,
+			Interface: req.Interface,
+			Weight:    req.Weight,
+			Probe:     req.Probe,
+		})
+	}
+	return hops, nil
+}
+
 func (h *Handlers) AddRoute(c *gin.Context) {
+	table, ok := h.routeTable(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		Destination string `json:"destination"`
-		Gateway     string `json:"gateway"`
-		Interface   string `json:"interface"`
-		Metric      int    `json:"metric"`
+		Destination string           `json:"destination"`
+		Gateway     string           `json:"gateway"`
+		Interface   string           `json:"interface"`
+		Metric      int              `json:"metric"`
+		Distance    int              `json:"distance"`
+		NextHops    []nextHopRequest `json:"next_hops"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
@@ -175,21 +329,42 @@ func (h *Handlers) AddRoute(c *gin.Context) {
 			return
 		}
 	}
-	h.Routes.Add(routing.Route{
+	hops, err := parseNextHops(req.NextHops)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Distance < 0 || req.Distance > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid distance"})
+		return
+	}
+	if req.Distance == 0 {
+		req.Distance = routing.DefaultDistance(routing.SourceAPI)
+	}
+	table.Add(routing.Route{
 		Destination: *dst,
 		Gateway:     gw,
 		Interface:   req.Interface,
 		Metric:      req.Metric,
+		Distance:    req.Distance,
+		Source:      routing.SourceAPI,
+		NextHops:    hops,
 	})
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
 func (h *Handlers) DeleteRoute(c *gin.Context) {
+	table, ok := h.routeTable(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		Destination string `json:"destination"`
-		Gateway     string `json:"gateway"`
-		Interface   string `json:"interface"`
-		Metric      int    `json:"metric"`
+		Destination string           `json:"destination"`
+		Gateway     string           `json:"gateway"`
+		Interface   string           `json:"interface"`
+		Metric      int              `json:"metric"`
+		Distance    int              `json:"distance"`
+		NextHops    []nextHopRequest `json:"next_hops"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
@@ -212,13 +387,20 @@ func (h *Handlers) DeleteRoute(c *gin.Context) {
 			return
 		}
 	}
-	ok := h.Routes.RemoveRoute(routing.Route{
+	hops, err := parseNextHops(req.NextHops)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	route, ok := findConfiguredRoute(table, routing.Route{
 		Destination: *dst,
 		Gateway:     gw,
 		Interface:   req.Interface,
 		Metric:      req.Metric,
+		Distance:    req.Distance,
+		NextHops:    hops,
 	})
-	if !ok {
+	if !ok || !table.RemoveRoute(route) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
 		return
 	}
@@ -226,15 +408,23 @@ func (h *Handlers) DeleteRoute(c *gin.Context) {
 }
 
 func (h *Handlers) UpdateRoute(c *gin.Context) {
+	table, ok := h.routeTable(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		OldDestination string `json:"old_destination"`
-		OldGateway     string `json:"old_gateway"`
-		OldInterface   string `json:"old_interface"`
-		OldMetric      int    `json:"old_metric"`
-		Destination    string `json:"destination"`
-		Gateway        string `json:"gateway"`
-		Interface      string `json:"interface"`
-		Metric         int    `json:"metric"`
+		OldDestination string           `json:"old_destination"`
+		OldGateway     string           `json:"old_gateway"`
+		OldInterface   string           `json:"old_interface"`
+		OldMetric      int              `json:"old_metric"`
+		OldDistance    int              `json:"old_distance"`
+		OldNextHops    []nextHopRequest `json:"old_next_hops"`
+		Destination    string           `json:"destination"`
+		Gateway        string           `json:"gateway"`
+		Interface      string           `json:"interface"`
+		Metric         int              `json:"metric"`
+		Distance       int              `json:"distance"`
+		NextHops       []nextHopRequest `json:"next_hops"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
@@ -274,20 +464,44 @@ func (h *Handlers) UpdateRoute(c *gin.Context) {
 			return
 		}
 	}
-	ok := h.Routes.UpdateRoute(
-		routing.Route{
-			Destination: *oldDst,
-			Gateway:     oldGw,
-			Interface:   req.OldInterface,
-			Metric:      req.OldMetric,
-		},
-		routing.Route{
-			Destination: *dst,
-			Gateway:     gw,
-			Interface:   req.Interface,
-			Metric:      req.Metric,
-		},
-	)
+	oldHops, err := parseNextHops(req.OldNextHops)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hops, err := parseNextHops(req.NextHops)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Distance < 0 || req.Distance > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid distance"})
+		return
+	}
+	old, ok := findConfiguredRoute(table, routing.Route{
+		Destination: *oldDst,
+		Gateway:     oldGw,
+		Interface:   req.OldInterface,
+		Metric:      req.OldMetric,
+		Distance:    req.OldDistance,
+		NextHops:    oldHops,
+	})
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
+		return
+	}
+	if req.Distance == 0 {
+		req.Distance = old.Distance
+	}
+	ok = table.UpdateRoute(old, routing.Route{
+		Destination: *dst,
+		Gateway:     gw,
+		Interface:   req.Interface,
+		Metric:      req.Metric,
+		Distance:    req.Distance,
+		Source:      old.Source,
+		NextHops:    hops,
+	})
 	if !ok {
 		c.JSON(http.StatusNotFound, gin.H{"error": "route not found"})
 		return
@@ -295,6 +509,17 @@ func (h *Handlers) UpdateRoute(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+// findConfiguredRoute resolves a route described by an API request. Routes
+// owned by routing protocols or interfaces cannot be changed through the API.
+func findConfiguredRoute(table *routing.Table, want routing.Route) (routing.Route, bool) {
+	for _, route := range table.Routes() {
+		if route.Configured() && route.Matches(want) {
+			return route, true
+		}
+	}
+	return routing.Route{}, false
+}
+
 func (h *Handlers) GetInterfaces(c *gin.Context) {
 	if h.ConfigMgr == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "config manager unavailable"})
@@ -318,104 +543,245 @@ func (h *Handlers) GetInterfaces(c *gin.Context) {
 }
 
 func (h *Handlers) AddFirewallRule(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
+		ID           uint64 `json:"id"`
+		Description  string `json:"description"`
+		Disabled     bool   `json:"disabled"`
 		Chain        string `json:"chain"`
 		Action       string `json:"action"`
+		Target       string `json:"target"`
 		Protocol     string `json:"protocol"`
 		SrcIP        string `json:"src_ip"`
 		DstIP        string `json:"dst_ip"`
 		SrcPort      int    `json:"src_port"`
 		DstPort      int    `json:"dst_port"`
+		SrcPorts     string `json:"src_ports"`
+		DstPorts     string `json:"dst_ports"`
+		SrcSet       string `json:"src_set"`
+		DstSet       string `json:"dst_set"`
 		InInterface  string `json:"in_interface"`
 		OutInterface string `json:"out_interface"`
+		From         string `json:"from"`
+		To           string `json:"to"`
+		CTState      string `json:"ct_state"`
+		Schedule     string `json:"schedule"`
+		Log          bool   `json:"log"`
+		LogRate      int    `json:"log_rate"`
+		RateLimit    int    `json:"rate_limit"`
+		RateBurst    int    `json:"rate_burst"`
+		ConnLimit    int    `json:"conn_limit"`
+		LimitPrefix  int    `json:"limit_prefix"`
+		SrcCountry   string `json:"src_country"`
+		DstCountry   string `json:"dst_country"`
+		SrcASN       string `json:"src_asn"`
+		placementRequest
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
+	ctState, err := conntrack.ParseStates(req.CTState)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ct_state"})
+		return
+	}
 
-	var srcNet *net.IPNet
-	if req.SrcIP != "" {
-		_, parsed, err := net.ParseCIDR(req.SrcIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
-			return
-		}
-		srcNet = parsed
+	srcAddrs, err := network.ParseAddrMatch(req.SrcIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
+		return
+	}
+	dstAddrs, err := network.ParseAddrMatch(req.DstIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
+		return
+	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	geo, ok := parseGeoMatch(c, "", req.SrcCountry, req.DstCountry, req.SrcASN)
+	if !ok {
+		return
 	}
 
-	var dstNet *net.IPNet
-	if req.DstIP != "" {
-		_, parsed, err := net.ParseCIDR(req.DstIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
-			return
-		}
-		dstNet = parsed
+	if !zonesDefined(engine, req.From, req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown zone"})
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
+	if !h.scheduleDefined(req.Schedule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown schedule"})
+		return
+	}
+	if req.LogRate < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid log_rate"})
+		return
+	}
+	if req.RateLimit < 0 || req.RateBurst < 0 || req.ConnLimit < 0 || req.LimitPrefix < 0 || req.LimitPrefix > 128 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if h.nftablesRejects(c, engine, geo, req.Disabled) {
+		return
+	}
+	if !req.placementRequest.valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return
 	}
 
 	rule := firewall.Rule{
+		ID:           req.ID,
+		Description:  req.Description,
+		Disabled:     req.Disabled,
 		Chain:        req.Chain,
 		Action:       firewall.Action(req.Action),
+		Target:       req.Target,
 		Protocol:     req.Protocol,
-		SrcNet:       srcNet,
-		DstNet:       dstNet,
+		SrcAddrs:     srcAddrs,
+		DstAddrs:     dstAddrs,
 		SrcPort:      req.SrcPort,
 		DstPort:      req.DstPort,
+		SrcPorts:     srcPorts,
+		DstPorts:     dstPorts,
+		SrcSet:       req.SrcSet,
+		DstSet:       req.DstSet,
 		InInterface:  req.InInterface,
 		OutInterface: req.OutInterface,
+		FromZone:     req.From,
+		ToZone:       req.To,
+		CTState:      ctState,
+		Schedule:     req.Schedule,
+		Log:          req.Log,
+		LogRate:      req.LogRate,
+		RateLimit:    req.RateLimit,
+		RateBurst:    req.RateBurst,
+		ConnLimit:    req.ConnLimit,
+		LimitPrefix:  req.LimitPrefix,
+		SrcCountry:   geo.srcCountry,
+		DstCountry:   geo.dstCountry,
+		SrcASN:       geo.srcASN,
+	}
+	if err := engine.CheckRule(rule, nil); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	h.Firewall.AddRule(rule)
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	id, ok := engine.InsertRule(rule, firewall.Placement(req.placementRequest))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id})
 }
 
 func (h *Handlers) DeleteFirewallRule(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
+		ID           uint64 `json:"id"`
 		Chain        string `json:"chain"`
 		Action       string `json:"action"`
+		Target       string `json:"target"`
 		Protocol     string `json:"protocol"`
 		SrcIP        string `json:"src_ip"`
 		DstIP        string `json:"dst_ip"`
 		SrcPort      int    `json:"src_port"`
 		DstPort      int    `json:"dst_port"`
+		SrcPorts     string `json:"src_ports"`
+		DstPorts     string `json:"dst_ports"`
+		SrcSet       string `json:"src_set"`
+		DstSet       string `json:"dst_set"`
 		InInterface  string `json:"in_interface"`
 		OutInterface string `json:"out_interface"`
+		From         string `json:"from"`
+		To           string `json:"to"`
+		CTState      string `json:"ct_state"`
+		Schedule     string `json:"schedule"`
+		RateLimit    int    `json:"rate_limit"`
+		RateBurst    int    `json:"rate_burst"`
+		ConnLimit    int    `json:"conn_limit"`
+		LimitPrefix  int    `json:"limit_prefix"`
+		SrcCountry   string `json:"src_country"`
+		DstCountry   string `json:"dst_country"`
+		SrcASN       string `json:"src_asn"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
-
-	var srcNet *net.IPNet
-	if req.SrcIP != "" {
-		_, parsed, err := net.ParseCIDR(req.SrcIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
-			return
-		}
-		srcNet = parsed
+	ctState, err := conntrack.ParseStates(req.CTState)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ct_state"})
+		return
 	}
 
-	var dstNet *net.IPNet
-	if req.DstIP != "" {
-		_, parsed, err := net.ParseCIDR(req.DstIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
-			return
-		}
-		dstNet = parsed
+	srcAddrs, err := network.ParseAddrMatch(req.SrcIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
+		return
+	}
+	dstAddrs, err := network.ParseAddrMatch(req.DstIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
+		return
+	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	geo, ok := parseGeoMatch(c, "", req.SrcCountry, req.DstCountry, req.SrcASN)
+	if !ok {
+		return
 	}
 
-	ok := h.Firewall.RemoveRule(firewall.Rule{
+	ok = engine.RemoveRule(firewall.Rule{
+		ID:           req.ID,
 		Chain:        req.Chain,
 		Action:       firewall.Action(req.Action),
+		Target:       req.Target,
 		Protocol:     req.Protocol,
-		SrcNet:       srcNet,
-		DstNet:       dstNet,
+		SrcAddrs:     srcAddrs,
+		DstAddrs:     dstAddrs,
 		SrcPort:      req.SrcPort,
 		DstPort:      req.DstPort,
+		SrcPorts:     srcPorts,
+		DstPorts:     dstPorts,
+		SrcSet:       req.SrcSet,
+		DstSet:       req.DstSet,
 		InInterface:  req.InInterface,
 		OutInterface: req.OutInterface,
+		FromZone:     req.From,
+		ToZone:       req.To,
+		CTState:      ctState,
+		Schedule:     req.Schedule,
+		RateLimit:    req.RateLimit,
+		RateBurst:    req.RateBurst,
+		ConnLimit:    req.ConnLimit,
+		LimitPrefix:  req.LimitPrefix,
+		SrcCountry:   geo.srcCountry,
+		DstCountry:   geo.dstCountry,
+		SrcASN:       geo.srcASN,
 	})
 	if !ok {
 		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
@@ -425,87 +791,219 @@ func (h *Handlers) DeleteFirewallRule(c *gin.Context) {
 }
 
 func (h *Handlers) UpdateFirewallRule(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
+		ID              uint64 `json:"id"`
 		OldChain        string `json:"old_chain"`
 		OldAction       string `json:"old_action"`
+		OldTarget       string `json:"old_target"`
 		OldProtocol     string `json:"old_protocol"`
 		OldSrcIP        string `json:"old_src_ip"`
 		OldDstIP        string `json:"old_dst_ip"`
 		OldSrcPort      int    `json:"old_src_port"`
 		OldDstPort      int    `json:"old_dst_port"`
+		OldSrcPorts     string `json:"old_src_ports"`
+		OldDstPorts     string `json:"old_dst_ports"`
+		OldSrcSet       string `json:"old_src_set"`
+		OldDstSet       string `json:"old_dst_set"`
 		OldInInterface  string `json:"old_in_interface"`
 		OldOutInterface string `json:"old_out_interface"`
+		OldFrom         string `json:"old_from"`
+		OldTo           string `json:"old_to"`
+		OldCTState      string `json:"old_ct_state"`
+		OldSchedule     string `json:"old_schedule"`
+		OldRateLimit    int    `json:"old_rate_limit"`
+		OldRateBurst    int    `json:"old_rate_burst"`
+		OldConnLimit    int    `json:"old_conn_limit"`
+		OldLimitPrefix  int    `json:"old_limit_prefix"`
+		OldSrcCountry   string `json:"old_src_country"`
+		OldDstCountry   string `json:"old_dst_country"`
+		OldSrcASN       string `json:"old_src_asn"`
+		Description     string `json:"description"`
+		Disabled        bool   `json:"disabled"`
 		Chain           string `json:"chain"`
 		Action          string `json:"action"`
+		Target          string `json:"target"`
 		Protocol        string `json:"protocol"`
 		SrcIP           string `json:"src_ip"`
 		DstIP           string `json:"dst_ip"`
 		SrcPort         int    `json:"src_port"`
 		DstPort         int    `json:"dst_port"`
+		SrcPorts        string `json:"src_ports"`
+		DstPorts        string `json:"dst_ports"`
+		SrcSet          string `json:"src_set"`
+		DstSet          string `json:"dst_set"`
 		InInterface     string `json:"in_interface"`
 		OutInterface    string `json:"out_interface"`
+		From            string `json:"from"`
+		To              string `json:"to"`
+		CTState         string `json:"ct_state"`
+		Schedule        string `json:"schedule"`
+		Log             bool   `json:"log"`
+		LogRate         int    `json:"log_rate"`
+		RateLimit       int    `json:"rate_limit"`
+		RateBurst       int    `json:"rate_burst"`
+		ConnLimit       int    `json:"conn_limit"`
+		LimitPrefix     int    `json:"limit_prefix"`
+		SrcCountry      string `json:"src_country"`
+		DstCountry      string `json:"dst_country"`
+		SrcASN          string `json:"src_asn"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
 
-	parseNet := func(value string) (*net.IPNet, error) {
-		if strings.TrimSpace(value) == "" {
-			return nil, nil
-		}
-		_, parsed, err := net.ParseCIDR(value)
-		if err != nil {
-			return nil, err
-		}
-		return parsed, nil
-	}
-
-	oldSrc, err := parseNet(req.OldSrcIP)
+	oldSrc, err := network.ParseAddrMatch(req.OldSrcIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_src_ip"})
 		return
 	}
-	oldDst, err := parseNet(req.OldDstIP)
+	oldDst, err := network.ParseAddrMatch(req.OldDstIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_dst_ip"})
 		return
 	}
-	src, err := parseNet(req.SrcIP)
+	oldSrcPorts, err := network.ParsePortMatch(req.OldSrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_src_ports"})
+		return
+	}
+	oldDstPorts, err := network.ParsePortMatch(req.OldDstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_dst_ports"})
+		return
+	}
+	src, err := network.ParseAddrMatch(req.SrcIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
 		return
 	}
-	dst, err := parseNet(req.DstIP)
+	dst, err := network.ParseAddrMatch(req.DstIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
 		return
 	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	oldGeo, ok := parseGeoMatch(c, "old_", req.OldSrcCountry, req.OldDstCountry, req.OldSrcASN)
+	if !ok {
+		return
+	}
+	geo, ok := parseGeoMatch(c, "", req.SrcCountry, req.DstCountry, req.SrcASN)
+	if !ok {
+		return
+	}
+	oldCTState, err := conntrack.ParseStates(req.OldCTState)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_ct_state"})
+		return
+	}
+	ctState, err := conntrack.ParseStates(req.CTState)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ct_state"})
+		return
+	}
 
-	ok := h.Firewall.UpdateRule(
-		firewall.Rule{
-			Chain:        req.OldChain,
-			Action:       firewall.Action(req.OldAction),
-			Protocol:     req.OldProtocol,
-			SrcNet:       oldSrc,
-			DstNet:       oldDst,
-			SrcPort:      req.OldSrcPort,
-			DstPort:      req.OldDstPort,
-			InInterface:  req.OldInInterface,
-			OutInterface: req.OldOutInterface,
-		},
-		firewall.Rule{
-			Chain:        req.Chain,
-			Action:       firewall.Action(req.Action),
-			Protocol:     req.Protocol,
-			SrcNet:       src,
-			DstNet:       dst,
-			SrcPort:      req.SrcPort,
-			DstPort:      req.DstPort,
-			InInterface:  req.InInterface,
-			OutInterface: req.OutInterface,
-		},
-	)
+	if !zonesDefined(engine, req.From, req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown zone"})
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
+	if !h.scheduleDefined(req.Schedule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown schedule"})
+		return
+	}
+	if req.LogRate < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid log_rate"})
+		return
+	}
+	if req.RateLimit < 0 || req.RateBurst < 0 || req.ConnLimit < 0 || req.LimitPrefix < 0 || req.LimitPrefix > 128 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	if h.nftablesRejects(c, engine, geo, req.Disabled) {
+		return
+	}
+
+	old := firewall.Rule{
+		ID:           req.ID,
+		Chain:        req.OldChain,
+		Action:       firewall.Action(req.OldAction),
+		Target:       req.OldTarget,
+		Protocol:     req.OldProtocol,
+		SrcAddrs:     oldSrc,
+		DstAddrs:     oldDst,
+		SrcPort:      req.OldSrcPort,
+		DstPort:      req.OldDstPort,
+		SrcPorts:     oldSrcPorts,
+		DstPorts:     oldDstPorts,
+		SrcSet:       req.OldSrcSet,
+		DstSet:       req.OldDstSet,
+		InInterface:  req.OldInInterface,
+		OutInterface: req.OldOutInterface,
+		FromZone:     req.OldFrom,
+		ToZone:       req.OldTo,
+		CTState:      oldCTState,
+		Schedule:     req.OldSchedule,
+		RateLimit:    req.OldRateLimit,
+		RateBurst:    req.OldRateBurst,
+		ConnLimit:    req.OldConnLimit,
+		LimitPrefix:  req.OldLimitPrefix,
+		SrcCountry:   oldGeo.srcCountry,
+		DstCountry:   oldGeo.dstCountry,
+		SrcASN:       oldGeo.srcASN,
+	}
+	updated := firewall.Rule{
+		Description:  req.Description,
+		Disabled:     req.Disabled,
+		Chain:        req.Chain,
+		Action:       firewall.Action(req.Action),
+		Target:       req.Target,
+		Protocol:     req.Protocol,
+		SrcAddrs:     src,
+		DstAddrs:     dst,
+		SrcPort:      req.SrcPort,
+		DstPort:      req.DstPort,
+		SrcPorts:     srcPorts,
+		DstPorts:     dstPorts,
+		SrcSet:       req.SrcSet,
+		DstSet:       req.DstSet,
+		InInterface:  req.InInterface,
+		OutInterface: req.OutInterface,
+		FromZone:     req.From,
+		ToZone:       req.To,
+		CTState:      ctState,
+		Schedule:     req.Schedule,
+		Log:          req.Log,
+		LogRate:      req.LogRate,
+		RateLimit:    req.RateLimit,
+		RateBurst:    req.RateBurst,
+		ConnLimit:    req.ConnLimit,
+		LimitPrefix:  req.LimitPrefix,
+		SrcCountry:   geo.srcCountry,
+		DstCountry:   geo.dstCountry,
+		SrcASN:       geo.srcASN,
+	}
+	if err := engine.CheckRule(updated, &old); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ok = engine.UpdateRule(old, updated)
 	if !ok {
 		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
 		return
@@ -513,6 +1011,15 @@ func (h *Handlers) UpdateFirewallRule(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
+func zonesDefined(engine *firewall.Engine, names ...string) bool {
+	for _, name := range names {
+		if name != "" && !engine.HasZone(name) {
+			return false
+		}
+	}
+	return true
+}
+
 func (h *Handlers) GetStats(c *gin.Context) {
 	snapshot := h.Metrics.Snapshot()
 	c.JSON(http.StatusOK, gin.H{
@@ -542,45 +1049,183 @@ func (h *Handlers) GetStats(c *gin.Context) {
 }
 
 func (h *Handlers) GetFirewallRules(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
 	type ruleView struct {
+		ID           uint64 `json:"id"`
+		Position     int    `json:"position"`
+		Description  string `json:"description,omitempty"`
+		Disabled     bool   `json:"disabled,omitempty"`
 		Chain        string `json:"chain"`
 		Action       string `json:"action"`
+		Target       string `json:"target,omitempty"`
 		Protocol     string `json:"protocol,omitempty"`
 		SrcIP        string `json:"src_ip,omitempty"`
 		DstIP        string `json:"dst_ip,omitempty"`
 		SrcPort      int    `json:"src_port,omitempty"`
 		DstPort      int    `json:"dst_port,omitempty"`
+		SrcPorts     string `json:"src_ports,omitempty"`
+		DstPorts     string `json:"dst_ports,omitempty"`
+		SrcSet       string `json:"src_set,omitempty"`
+		DstSet       string `json:"dst_set,omitempty"`
 		InInterface  string `json:"in_interface,omitempty"`
 		OutInterface string `json:"out_interface,omitempty"`
+		From         string `json:"from,omitempty"`
+		To           string `json:"to,omitempty"`
+		CTState      string `json:"ct_state,omitempty"`
+		Schedule     string `json:"schedule,omitempty"`
+		Log          bool   `json:"log,omitempty"`
+		LogRate      int    `json:"log_rate,omitempty"`
+		RateLimit    int    `json:"rate_limit,omitempty"`
+		RateBurst    int    `json:"rate_burst,omitempty"`
+		ConnLimit    int    `json:"conn_limit,omitempty"`
+		LimitPrefix  int    `json:"limit_prefix,omitempty"`
+		SrcCountry   string `json:"src_country,omitempty"`
+		DstCountry   string `json:"dst_country,omitempty"`
+		SrcASN       string `json:"src_asn,omitempty"`
+		Active       bool   `json:"active"`
 		Hits         uint64 `json:"hits"`
 	}
-	stats := h.Firewall.RulesWithStats()
+	stats := engine.RulesWithStats()
 	out := make([]ruleView, 0, len(stats))
-	for _, stat := range stats {
+	for i, stat := range stats {
 		r := stat.Rule
 		view := ruleView{
+			ID:           r.ID,
+			Position:     i + 1,
+			Description:  r.Description,
+			Disabled:     r.Disabled,
 			Chain:        r.Chain,
 			Action:       string(r.Action),
+			Target:       r.Target,
 			Protocol:     r.Protocol,
+			SrcIP:        r.SrcAddrs.String(),
+			DstIP:        r.DstAddrs.String(),
 			SrcPort:      r.SrcPort,
 			DstPort:      r.DstPort,
+			SrcPorts:     portListView(r.SrcPorts),
+			DstPorts:     portListView(r.DstPorts),
+			SrcSet:       r.SrcSet,
+			DstSet:       r.DstSet,
 			InInterface:  r.InInterface,
 			OutInterface: r.OutInterface,
+			From:         r.FromZone,
+			To:           r.ToZone,
+			CTState:      conntrack.FormatStates(r.CTState),
+			Schedule:     r.Schedule,
+			Log:          r.Log,
+			LogRate:      r.LogRate,
+			RateLimit:    r.RateLimit,
+			RateBurst:    r.RateBurst,
+			ConnLimit:    r.ConnLimit,
+			LimitPrefix:  r.LimitPrefix,
+			SrcCountry:   r.SrcCountry.String(),
+			DstCountry:   r.DstCountry.String(),
+			SrcASN:       r.SrcASN.String(),
+			Active:       stat.Active,
 			Hits:         stat.Hits,
 		}
-		if r.SrcNet != nil {
-			view.SrcIP = r.SrcNet.String()
-		}
-		if r.DstNet != nil {
-			view.DstIP = r.DstNet.String()
-		}
 		out = append(out, view)
 	}
 	c.JSON(http.StatusOK, out)
 }
 
+// portListView returns the list form of a port match for rule views; single
+// ports are reported through the src_port/dst_port fields instead.
+func portListView(m network.PortMatch) string {
+	if _, ok := m.Single(); ok {
+		return ""
+	}
+	return m.String()
+}
+
+type geoMatch struct {
+	srcCountry network.CountryMatch
+	dstCountry network.CountryMatch
+	srcASN     network.ASNMatch
+}
+
+// parseGeoMatch parses the country and ASN fields shared by firewall and IDS
+// rule requests, answering 400 when one is invalid; prefix is prepended to
+// the field names in the error, as for the old_ fields of an update.
+func parseGeoMatch(c *gin.Context, prefix, srcCountry, dstCountry, srcASN string) (geoMatch, bool) {
+	var m geoMatch
+	var err error
+	if m.srcCountry, err = network.ParseCountryMatch(srcCountry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + prefix + "src_country"})
+		return geoMatch{}, false
+	}
+	if m.dstCountry, err = network.ParseCountryMatch(dstCountry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + prefix + "dst_country"})
+		return geoMatch{}, false
+	}
+	if m.srcASN, err = network.ParseASNMatch(srcASN); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid " + prefix + "src_asn"})
+		return geoMatch{}, false
+	}
+	return m, true
+}
+
+func (m geoMatch) isZero() bool {
+	return m.srcCountry.IsZero() && m.dstCountry.IsZero() && m.srcASN.IsZero()
+}
+
+// nftablesRejects answers 400 when an enabled rule with country or ASN
+// matches would go to the nftables backend, which cannot enforce them.
+func (h *Handlers) nftablesRejects(c *gin.Context, engine *firewall.Engine, geo geoMatch, disabled bool) bool {
+	if h.NFTables == nil || engine != h.Firewall || disabled || geo.isZero() {
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": nftables.ErrUnsupportedRules.Error()})
+	return true
+}
+
+// GetFirewallZones lists zones with their interfaces and defaults, and the
+// zone pair policies.
+func (h *Handlers) GetFirewallZones(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	type zoneView struct {
+		Name       string   `json:"name"`
+		Interfaces []string `json:"interfaces"`
+		Input      string   `json:"input,omitempty"`
+		Output     string   `json:"output,omitempty"`
+		Forward    string   `json:"forward,omitempty"`
+	}
+	type policyView struct {
+		From   string `json:"from"`
+		To     string `json:"to"`
+		Action string `json:"action"`
+	}
+	zones := engine.Zones()
+	zoneOut := make([]zoneView, 0, len(zones))
+	for _, zone := range zones {
+		zoneOut = append(zoneOut, zoneView{
+			Name:       zone.Name,
+			Interfaces: append([]string{}, zone.Interfaces...),
+			Input:      string(zone.Input),
+			Output:     string(zone.Output),
+			Forward:    string(zone.Forward),
+		})
+	}
+	policies := engine.ZonePolicies()
+	policyOut := make([]policyView, 0, len(policies))
+	for _, policy := range policies {
+		policyOut = append(policyOut, policyView{From: policy.From, To: policy.To, Action: string(policy.Action)})
+	}
+	c.JSON(http.StatusOK, gin.H{"zones": zoneOut, "policies": policyOut})
+}
+
 func (h *Handlers) GetFirewallDefaults(c *gin.Context) {
-	defaults := h.Firewall.DefaultPolicies()
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	defaults := engine.DefaultPolicies()
 	out := map[string]string{}
 	for k, v := range defaults {
 		out[k] = string(v)
@@ -589,17 +1234,29 @@ func (h *Handlers) GetFirewallDefaults(c *gin.Context) {
 }
 
 func (h *Handlers) GetFirewallStats(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
-		"chain_hits": h.Firewall.ChainHits(),
+		"chain_hits": engine.ChainHits(),
 	})
 }
 
 func (h *Handlers) ResetFirewallStats(c *gin.Context) {
-	h.Firewall.ResetStats()
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	engine.ResetStats()
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
 func (h *Handlers) SetFirewallDefault(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
 		Chain  string `json:"chain"`
 		Action string `json:"action"`
@@ -622,7 +1279,7 @@ func (h *Handlers) SetFirewallDefault(c *gin.Context) {
 		return
 	}
 
-	h.Firewall.SetDefaultPolicy(chain, firewall.Action(action))
+	engine.SetDefaultPolicy(chain, firewall.Action(action))
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
@@ -639,6 +1296,13 @@ func (h *Handlers) GetIDSRules(c *gin.Context) {
 		DstCIDR         string `json:"dst_cidr,omitempty"`
 		SrcPort         int    `json:"src_port,omitempty"`
 		DstPort         int    `json:"dst_port,omitempty"`
+		SrcPorts        string `json:"src_ports,omitempty"`
+		DstPorts        string `json:"dst_ports,omitempty"`
+		SrcSet          string `json:"src_set,omitempty"`
+		DstSet          string `json:"dst_set,omitempty"`
+		SrcCountry      string `json:"src_country,omitempty"`
+		DstCountry      string `json:"dst_country,omitempty"`
+		SrcASN          string `json:"src_asn,omitempty"`
 		PayloadContains string `json:"payload_contains,omitempty"`
 		Priority        int    `json:"priority"`
 		Enabled         bool   `json:"enabled"`
@@ -652,19 +1316,22 @@ func (h *Handlers) GetIDSRules(c *gin.Context) {
 			Name:            r.Name,
 			Action:          string(r.Action),
 			Protocol:        r.Protocol,
+			SrcCIDR:         r.SrcAddrs.String(),
+			DstCIDR:         r.DstAddrs.String(),
 			SrcPort:         r.SrcPort,
 			DstPort:         r.DstPort,
+			SrcPorts:        portListView(r.SrcPorts),
+			DstPorts:        portListView(r.DstPorts),
+			SrcSet:          r.SrcSet,
+			DstSet:          r.DstSet,
+			SrcCountry:      r.SrcCountry.String(),
+			DstCountry:      r.DstCountry.String(),
+			SrcASN:          r.SrcASN.String(),
 			PayloadContains: r.PayloadContains,
 			Priority:        r.Priority,
 			Enabled:         r.Enabled,
 			Hits:            entry.Hits,
 		}
-		if r.SrcNet != nil {
-			view.SrcCIDR = r.SrcNet.String()
-		}
-		if r.DstNet != nil {
-			view.DstCIDR = r.DstNet.String()
-		}
 		out = append(out, view)
 	}
 	c.JSON(http.StatusOK, out)
@@ -683,6 +1350,13 @@ func (h *Handlers) AddIDSRule(c *gin.Context) {
 		DstCIDR         string `json:"dst_cidr"`
 		SrcPort         int    `json:"src_port"`
 		DstPort         int    `json:"dst_port"`
+		SrcPorts        string `json:"src_ports"`
+		DstPorts        string `json:"dst_ports"`
+		SrcSet          string `json:"src_set"`
+		DstSet          string `json:"dst_set"`
+		SrcCountry      string `json:"src_country"`
+		DstCountry      string `json:"dst_country"`
+		SrcASN          string `json:"src_asn"`
 		PayloadContains string `json:"payload_contains"`
 		Priority        int    `json:"priority"`
 		Enabled         *bool  `json:"enabled"`
@@ -695,33 +1369,50 @@ func (h *Handlers) AddIDSRule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 		return
 	}
-	var srcNet *net.IPNet
-	if req.SrcCIDR != "" {
-		_, parsed, err := net.ParseCIDR(req.SrcCIDR)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_cidr"})
-			return
-		}
-		srcNet = parsed
+	srcAddrs, err := network.ParseAddrMatch(req.SrcCIDR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_cidr"})
+		return
 	}
-	var dstNet *net.IPNet
-	if req.DstCIDR != "" {
-		_, parsed, err := net.ParseCIDR(req.DstCIDR)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_cidr"})
-			return
-		}
-		dstNet = parsed
+	dstAddrs, err := network.ParseAddrMatch(req.DstCIDR)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_cidr"})
+		return
+	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	geo, ok := parseGeoMatch(c, "", req.SrcCountry, req.DstCountry, req.SrcASN)
+	if !ok {
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
 	}
 
 	rule := ids.Rule{
 		Name:            req.Name,
 		Action:          ids.Action(strings.ToUpper(req.Action)),
 		Protocol:        req.Protocol,
-		SrcNet:          srcNet,
-		DstNet:          dstNet,
+		SrcAddrs:        srcAddrs,
+		DstAddrs:        dstAddrs,
 		SrcPort:         req.SrcPort,
 		DstPort:         req.DstPort,
+		SrcPorts:        srcPorts,
+		DstPorts:        dstPorts,
+		SrcSet:          req.SrcSet,
+		DstSet:          req.DstSet,
+		SrcCountry:      geo.srcCountry,
+		DstCountry:      geo.dstCountry,
+		SrcASN:          geo.srcASN,
 		PayloadContains: req.PayloadContains,
 		Priority:        req.Priority,
 		Enabled:         true,
@@ -755,6 +1446,13 @@ func (h *Handlers) UpdateIDSRule(c *gin.Context) {
 		DstCIDR         string `json:"dst_cidr"`
 		SrcPort         int    `json:"src_port"`
 		DstPort         int    `json:"dst_port"`
+		SrcPorts        string `json:"src_ports"`
+		DstPorts        string `json:"dst_ports"`
+		SrcSet          string `json:"src_set"`
+		DstSet          string `json:"dst_set"`
+		SrcCountry      string `json:"src_country"`
+		DstCountry      string `json:"dst_country"`
+		SrcASN          string `json:"src_asn"`
 		PayloadContains string `json:"payload_contains"`
 		Priority        int    `json:"priority"`
 		Enabled         *bool  `json:"enabled"`
@@ -763,27 +1461,23 @@ func (h *Handlers) UpdateIDSRule(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
-	var srcNet *net.IPNet
+	srcAddrs := existing.SrcAddrs
 	if req.SrcCIDR != "" {
-		_, parsed, err := net.ParseCIDR(req.SrcCIDR)
+		parsed, err := network.ParseAddrMatch(req.SrcCIDR)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_cidr"})
 			return
 		}
-		srcNet = parsed
-	} else {
-		srcNet = existing.SrcNet
+		srcAddrs = parsed
 	}
-	var dstNet *net.IPNet
+	dstAddrs := existing.DstAddrs
 	if req.DstCIDR != "" {
-		_, parsed, err := net.ParseCIDR(req.DstCIDR)
+		parsed, err := network.ParseAddrMatch(req.DstCIDR)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_cidr"})
 			return
 		}
-		dstNet = parsed
-	} else {
-		dstNet = existing.DstNet
+		dstAddrs = parsed
 	}
 
 	action := req.Action
@@ -794,13 +1488,40 @@ func (h *Handlers) UpdateIDSRule(c *gin.Context) {
 	if protocol == "" {
 		protocol = existing.Protocol
 	}
-	srcPort := req.SrcPort
-	if srcPort == 0 {
-		srcPort = existing.SrcPort
+	srcPorts := existing.SrcPorts
+	if req.SrcPorts != "" {
+		parsed, err := network.ParsePortMatch(req.SrcPorts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+			return
+		}
+		srcPorts = parsed
+	} else if req.SrcPort != 0 {
+		srcPorts = network.SinglePort(req.SrcPort)
+	}
+	dstPorts := existing.DstPorts
+	if req.DstPorts != "" {
+		parsed, err := network.ParsePortMatch(req.DstPorts)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+			return
+		}
+		dstPorts = parsed
+	} else if req.DstPort != 0 {
+		dstPorts = network.SinglePort(req.DstPort)
+	}
+	geo, ok := parseGeoMatch(c, "", req.SrcCountry, req.DstCountry, req.SrcASN)
+	if !ok {
+		return
+	}
+	if req.SrcCountry == "" {
+		geo.srcCountry = existing.SrcCountry
+	}
+	if req.DstCountry == "" {
+		geo.dstCountry = existing.DstCountry
 	}
-	dstPort := req.DstPort
-	if dstPort == 0 {
-		dstPort = existing.DstPort
+	if req.SrcASN == "" {
+		geo.srcASN = existing.SrcASN
 	}
 	payload := req.PayloadContains
 	if payload == "" {
@@ -810,15 +1531,32 @@ func (h *Handlers) UpdateIDSRule(c *gin.Context) {
 	if priority == 0 {
 		priority = existing.Priority
 	}
+	srcSet := req.SrcSet
+	if srcSet == "" {
+		srcSet = existing.SrcSet
+	}
+	dstSet := req.DstSet
+	if dstSet == "" {
+		dstSet = existing.DstSet
+	}
+	if !h.ipSetsDefined(srcSet, dstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
 
 	rule := ids.Rule{
 		Name:            name,
 		Action:          ids.Action(strings.ToUpper(action)),
 		Protocol:        protocol,
-		SrcNet:          srcNet,
-		DstNet:          dstNet,
-		SrcPort:         srcPort,
-		DstPort:         dstPort,
+		SrcAddrs:        srcAddrs,
+		DstAddrs:        dstAddrs,
+		SrcPorts:        srcPorts,
+		DstPorts:        dstPorts,
+		SrcSet:          srcSet,
+		DstSet:          dstSet,
+		SrcCountry:      geo.srcCountry,
+		DstCountry:      geo.dstCountry,
+		SrcASN:          geo.srcASN,
 		PayloadContains: payload,
 		Priority:        priority,
 		Enabled:         existing.Enabled,
@@ -1091,6 +1829,10 @@ func (h *Handlers) ExportPolicyBundle(c *gin.Context) {
 		Routes:           append([]config.RouteConfig(nil), cfg.Routes...),
 		Firewall:         append([]config.FirewallRuleConfig(nil), cfg.Firewall...),
 		FirewallDefaults: cfg.FirewallDefaults,
+		FirewallZones:    append([]config.FirewallZoneConfig(nil), cfg.FirewallZones...),
+		ZonePolicies:     append([]config.ZonePolicyConfig(nil), cfg.ZonePolicies...),
+		IPSets:           append([]config.IPSetConfig(nil), cfg.IPSets...),
+		Schedules:        append([]config.ScheduleConfig(nil), cfg.Schedules...),
 		NAT:              append([]config.NATRuleConfig(nil), cfg.NAT...),
 		QoS:              append([]config.QoSClassConfig(nil), cfg.QoS...),
 		IDS:              cfg.IDS,
@@ -1131,6 +1873,10 @@ func (h *Handlers) ImportPolicyBundle(c *gin.Context) {
 		cfg.Routes = append([]config.RouteConfig(nil), req.Bundle.Routes...)
 		cfg.Firewall = append([]config.FirewallRuleConfig(nil), req.Bundle.Firewall...)
 		cfg.FirewallDefaults = req.Bundle.FirewallDefaults
+		cfg.FirewallZones = append([]config.FirewallZoneConfig(nil), req.Bundle.FirewallZones...)
+		cfg.ZonePolicies = append([]config.ZonePolicyConfig(nil), req.Bundle.ZonePolicies...)
+		cfg.IPSets = append([]config.IPSetConfig(nil), req.Bundle.IPSets...)
+		cfg.Schedules = append([]config.ScheduleConfig(nil), req.Bundle.Schedules...)
 		cfg.NAT = append([]config.NATRuleConfig(nil), req.Bundle.NAT...)
 		cfg.QoS = append([]config.QoSClassConfig(nil), req.Bundle.QoS...)
 		cfg.IDS = req.Bundle.IDS
@@ -1140,6 +1886,10 @@ func (h *Handlers) ImportPolicyBundle(c *gin.Context) {
 		if req.Bundle.FirewallDefaults.Input != "" || req.Bundle.FirewallDefaults.Output != "" || req.Bundle.FirewallDefaults.Forward != "" {
 			cfg.FirewallDefaults = req.Bundle.FirewallDefaults
 		}
+		cfg.FirewallZones = append(cfg.FirewallZones, req.Bundle.FirewallZones...)
+		cfg.ZonePolicies = append(cfg.ZonePolicies, req.Bundle.ZonePolicies...)
+		cfg.IPSets = append(cfg.IPSets, req.Bundle.IPSets...)
+		cfg.Schedules = append(cfg.Schedules, req.Bundle.Schedules...)
 		cfg.NAT = append(cfg.NAT, req.Bundle.NAT...)
 		cfg.QoS = append(cfg.QoS, req.Bundle.QoS...)
 		if hasIDSOverrides(req.Bundle.IDS) {
@@ -1802,6 +2552,10 @@ func (h *Handlers) webhookByID(id string) (WebhookConfig, bool) {
 	return WebhookConfig{}, false
 }
 
+func (h *Handlers) EmitEvent(event string, details map[string]any) {
+	h.emitWebhookEvent(event, "system", details)
+}
+
 func (h *Handlers) emitWebhookEvent(event string, actor string, details map[string]any) {
 	h.webhookMu.Lock()
 	targets := make([]WebhookConfig, 0, len(h.webhooks))
@@ -2153,32 +2907,50 @@ func (h *Handlers) GetEnrichIP(c *gin.Context) {
 }
 
 func (h *Handlers) GetNAT(c *gin.Context) {
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
 	type natView struct {
-		Type    string `json:"type"`
-		SrcIP   string `json:"src_ip,omitempty"`
-		DstIP   string `json:"dst_ip,omitempty"`
-		SrcPort int    `json:"src_port,omitempty"`
-		DstPort int    `json:"dst_port,omitempty"`
-		ToIP    string `json:"to_ip,omitempty"`
-		ToPort  int    `json:"to_port,omitempty"`
-		Hits    uint64 `json:"hits"`
-	}
-	stats := h.NAT.RulesWithStats()
+		ID          uint64 `json:"id"`
+		Position    int    `json:"position"`
+		Description string `json:"description,omitempty"`
+		Disabled    bool   `json:"disabled,omitempty"`
+		Type        string `json:"type"`
+		SrcIP       string `json:"src_ip,omitempty"`
+		DstIP       string `json:"dst_ip,omitempty"`
+		SrcPort     int    `json:"src_port,omitempty"`
+		DstPort     int    `json:"dst_port,omitempty"`
+		SrcPorts    string `json:"src_ports,omitempty"`
+		DstPorts    string `json:"dst_ports,omitempty"`
+		SrcSet      string `json:"src_set,omitempty"`
+		DstSet      string `json:"dst_set,omitempty"`
+		Schedule    string `json:"schedule,omitempty"`
+		ToIP        string `json:"to_ip,omitempty"`
+		ToPort      int    `json:"to_port,omitempty"`
+		Hits        uint64 `json:"hits"`
+	}
+	stats := table.RulesWithStats()
 	out := make([]natView, 0, len(stats))
-	for _, stat := range stats {
+	for i, stat := range stats {
 		r := stat.Rule
 		view := natView{
-			Type:    string(r.Type),
-			SrcPort: r.SrcPort,
-			DstPort: r.DstPort,
-			ToPort:  r.ToPort,
-			Hits:    stat.Hits,
-		}
-		if r.SrcNet != nil {
-			view.SrcIP = r.SrcNet.String()
-		}
-		if r.DstNet != nil {
-			view.DstIP = r.DstNet.String()
+			ID:          r.ID,
+			Position:    i + 1,
+			Description: r.Description,
+			Disabled:    r.Disabled,
+			Type:        string(r.Type),
+			SrcIP:       r.SrcAddrs.String(),
+			DstIP:       r.DstAddrs.String(),
+			SrcPort:     r.SrcPort,
+			DstPort:     r.DstPort,
+			SrcPorts:    portListView(r.SrcPorts),
+			DstPorts:    portListView(r.DstPorts),
+			SrcSet:      r.SrcSet,
+			DstSet:      r.DstSet,
+			Schedule:    r.Schedule,
+			ToPort:      r.ToPort,
+			Hits:        stat.Hits,
 		}
 		if r.ToIP != nil {
 			view.ToIP = r.ToIP.String()
@@ -2189,48 +2961,65 @@ func (h *Handlers) GetNAT(c *gin.Context) {
 }
 
 func (h *Handlers) DeleteNATRule(c *gin.Context) {
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		Type    string `json:"type"`
-		SrcIP   string `json:"src_ip"`
-		DstIP   string `json:"dst_ip"`
-		SrcPort int    `json:"src_port"`
-		DstPort int    `json:"dst_port"`
-		ToIP    string `json:"to_ip"`
-		ToPort  int    `json:"to_port"`
+		ID       uint64 `json:"id"`
+		Type     string `json:"type"`
+		SrcIP    string `json:"src_ip"`
+		DstIP    string `json:"dst_ip"`
+		SrcPort  int    `json:"src_port"`
+		DstPort  int    `json:"dst_port"`
+		SrcPorts string `json:"src_ports"`
+		DstPorts string `json:"dst_ports"`
+		SrcSet   string `json:"src_set"`
+		DstSet   string `json:"dst_set"`
+		Schedule string `json:"schedule"`
+		ToIP     string `json:"to_ip"`
+		ToPort   int    `json:"to_port"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
 
-	var srcNet *net.IPNet
-	if req.SrcIP != "" {
-		_, parsed, err := net.ParseCIDR(req.SrcIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
-			return
-		}
-		srcNet = parsed
+	srcAddrs, err := network.ParseAddrMatch(req.SrcIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
+		return
 	}
-
-	var dstNet *net.IPNet
-	if req.DstIP != "" {
-		_, parsed, err := net.ParseCIDR(req.DstIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
-			return
-		}
-		dstNet = parsed
+	dstAddrs, err := network.ParseAddrMatch(req.DstIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
+		return
 	}
-
-	ok := h.NAT.RemoveRule(nat.Rule{
-		Type:    nat.Type(req.Type),
-		SrcNet:  srcNet,
-		DstNet:  dstNet,
-		SrcPort: req.SrcPort,
-		DstPort: req.DstPort,
-		ToIP:    net.ParseIP(req.ToIP),
-		ToPort:  req.ToPort,
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+
+	ok = table.RemoveRule(nat.Rule{
+		ID:       req.ID,
+		Type:     nat.Type(req.Type),
+		SrcAddrs: srcAddrs,
+		DstAddrs: dstAddrs,
+		SrcPort:  req.SrcPort,
+		DstPort:  req.DstPort,
+		SrcPorts: srcPorts,
+		DstPorts: dstPorts,
+		SrcSet:   req.SrcSet,
+		DstSet:   req.DstSet,
+		Schedule: req.Schedule,
+		ToIP:     net.ParseIP(req.ToIP),
+		ToPort:   req.ToPort,
 	})
 	if !ok {
 		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
@@ -2240,77 +3029,132 @@ func (h *Handlers) DeleteNATRule(c *gin.Context) {
 }
 
 func (h *Handlers) UpdateNATRule(c *gin.Context) {
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		OldType    string `json:"old_type"`
-		OldSrcIP   string `json:"old_src_ip"`
-		OldDstIP   string `json:"old_dst_ip"`
-		OldSrcPort int    `json:"old_src_port"`
-		OldDstPort int    `json:"old_dst_port"`
-		OldToIP    string `json:"old_to_ip"`
-		OldToPort  int    `json:"old_to_port"`
-		Type       string `json:"type"`
-		SrcIP      string `json:"src_ip"`
-		DstIP      string `json:"dst_ip"`
-		SrcPort    int    `json:"src_port"`
-		DstPort    int    `json:"dst_port"`
-		ToIP       string `json:"to_ip"`
-		ToPort     int    `json:"to_port"`
+		ID          uint64 `json:"id"`
+		OldType     string `json:"old_type"`
+		OldSrcIP    string `json:"old_src_ip"`
+		OldDstIP    string `json:"old_dst_ip"`
+		OldSrcPort  int    `json:"old_src_port"`
+		OldDstPort  int    `json:"old_dst_port"`
+		OldSrcPorts string `json:"old_src_ports"`
+		OldDstPorts string `json:"old_dst_ports"`
+		OldSrcSet   string `json:"old_src_set"`
+		OldDstSet   string `json:"old_dst_set"`
+		OldSchedule string `json:"old_schedule"`
+		OldToIP     string `json:"old_to_ip"`
+		OldToPort   int    `json:"old_to_port"`
+		Description string `json:"description"`
+		Disabled    bool   `json:"disabled"`
+		Type        string `json:"type"`
+		SrcIP       string `json:"src_ip"`
+		DstIP       string `json:"dst_ip"`
+		SrcPort     int    `json:"src_port"`
+		DstPort     int    `json:"dst_port"`
+		SrcPorts    string `json:"src_ports"`
+		DstPorts    string `json:"dst_ports"`
+		SrcSet      string `json:"src_set"`
+		DstSet      string `json:"dst_set"`
+		Schedule    string `json:"schedule"`
+		ToIP        string `json:"to_ip"`
+		ToPort      int    `json:"to_port"`
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
 
-	parseNet := func(value string) (*net.IPNet, error) {
-		if strings.TrimSpace(value) == "" {
-			return nil, nil
-		}
-		_, parsed, err := net.ParseCIDR(value)
-		if err != nil {
-			return nil, err
-		}
-		return parsed, nil
-	}
-
-	oldSrc, err := parseNet(req.OldSrcIP)
+	oldSrc, err := network.ParseAddrMatch(req.OldSrcIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_src_ip"})
 		return
 	}
-	oldDst, err := parseNet(req.OldDstIP)
+	oldDst, err := network.ParseAddrMatch(req.OldDstIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_dst_ip"})
 		return
 	}
-	src, err := parseNet(req.SrcIP)
+	oldSrcPorts, err := network.ParsePortMatch(req.OldSrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_src_ports"})
+		return
+	}
+	oldDstPorts, err := network.ParsePortMatch(req.OldDstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid old_dst_ports"})
+		return
+	}
+	src, err := network.ParseAddrMatch(req.SrcIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
 		return
 	}
-	dst, err := parseNet(req.DstIP)
+	dst, err := network.ParseAddrMatch(req.DstIP)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
 		return
 	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
+	if !h.scheduleDefined(req.Schedule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown schedule"})
+		return
+	}
+	if req.ToIP != "" && net.ParseIP(req.ToIP) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to_ip"})
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(req.Type), string(nat.TypeDNAT)) && req.ToIP == "" && req.ToPort == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "DNAT needs to_ip or to_port"})
+		return
+	}
 
-	ok := h.NAT.UpdateRule(
+	ok = table.UpdateRule(
 		nat.Rule{
-			Type:    nat.Type(req.OldType),
-			SrcNet:  oldSrc,
-			DstNet:  oldDst,
-			SrcPort: req.OldSrcPort,
-			DstPort: req.OldDstPort,
-			ToIP:    net.ParseIP(req.OldToIP),
-			ToPort:  req.OldToPort,
+			ID:       req.ID,
+			Type:     nat.Type(req.OldType),
+			SrcAddrs: oldSrc,
+			DstAddrs: oldDst,
+			SrcPort:  req.OldSrcPort,
+			DstPort:  req.OldDstPort,
+			SrcPorts: oldSrcPorts,
+			DstPorts: oldDstPorts,
+			SrcSet:   req.OldSrcSet,
+			DstSet:   req.OldDstSet,
+			Schedule: req.OldSchedule,
+			ToIP:     net.ParseIP(req.OldToIP),
+			ToPort:   req.OldToPort,
 		},
 		nat.Rule{
-			Type:    nat.Type(req.Type),
-			SrcNet:  src,
-			DstNet:  dst,
-			SrcPort: req.SrcPort,
-			DstPort: req.DstPort,
-			ToIP:    net.ParseIP(req.ToIP),
-			ToPort:  req.ToPort,
+			Description: req.Description,
+			Disabled:    req.Disabled,
+			Type:        nat.Type(req.Type),
+			SrcAddrs:    src,
+			DstAddrs:    dst,
+			SrcPort:     req.SrcPort,
+			DstPort:     req.DstPort,
+			SrcPorts:    srcPorts,
+			DstPorts:    dstPorts,
+			SrcSet:      req.SrcSet,
+			DstSet:      req.DstSet,
+			Schedule:    req.Schedule,
+			ToIP:        net.ParseIP(req.ToIP),
+			ToPort:      req.ToPort,
 		},
 	)
 	if !ok {
@@ -2321,56 +3165,106 @@ func (h *Handlers) UpdateNATRule(c *gin.Context) {
 }
 
 func (h *Handlers) ResetNATStats(c *gin.Context) {
-	h.NAT.ResetStats()
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
+	table.ResetStats()
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
 func (h *Handlers) AddNATRule(c *gin.Context) {
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
 	var req struct {
-		Type    string `json:"type"`
-		SrcIP   string `json:"src_ip"`
-		DstIP   string `json:"dst_ip"`
-		SrcPort int    `json:"src_port"`
-		DstPort int    `json:"dst_port"`
-		ToIP    string `json:"to_ip"`
-		ToPort  int    `json:"to_port"`
+		ID          uint64 `json:"id"`
+		Description string `json:"description"`
+		Disabled    bool   `json:"disabled"`
+		Type        string `json:"type"`
+		SrcIP       string `json:"src_ip"`
+		DstIP       string `json:"dst_ip"`
+		SrcPort     int    `json:"src_port"`
+		DstPort     int    `json:"dst_port"`
+		SrcPorts    string `json:"src_ports"`
+		DstPorts    string `json:"dst_ports"`
+		SrcSet      string `json:"src_set"`
+		DstSet      string `json:"dst_set"`
+		Schedule    string `json:"schedule"`
+		ToIP        string `json:"to_ip"`
+		ToPort      int    `json:"to_port"`
+		placementRequest
 	}
 	if err := c.BindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
 		return
 	}
 
-	var srcNet *net.IPNet
-	if req.SrcIP != "" {
-		_, parsed, err := net.ParseCIDR(req.SrcIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
-			return
-		}
-		srcNet = parsed
+	srcAddrs, err := network.ParseAddrMatch(req.SrcIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip"})
+		return
 	}
-
-	var dstNet *net.IPNet
-	if req.DstIP != "" {
-		_, parsed, err := net.ParseCIDR(req.DstIP)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
-			return
-		}
-		dstNet = parsed
+	dstAddrs, err := network.ParseAddrMatch(req.DstIP)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ip"})
+		return
+	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
+	if !h.scheduleDefined(req.Schedule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown schedule"})
+		return
+	}
+	if req.ToIP != "" && net.ParseIP(req.ToIP) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to_ip"})
+		return
+	}
+	if strings.EqualFold(strings.TrimSpace(req.Type), string(nat.TypeDNAT)) && req.ToIP == "" && req.ToPort == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "DNAT needs to_ip or to_port"})
+		return
+	}
+	if !req.placementRequest.valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return
 	}
 
 	rule := nat.Rule{
-		Type:    nat.Type(req.Type),
-		SrcNet:  srcNet,
-		DstNet:  dstNet,
-		SrcPort: req.SrcPort,
-		DstPort: req.DstPort,
-		ToIP:    net.ParseIP(req.ToIP),
-		ToPort:  req.ToPort,
-	}
-	h.NAT.AddRule(rule)
-	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+		ID:          req.ID,
+		Description: req.Description,
+		Disabled:    req.Disabled,
+		Type:        nat.Type(req.Type),
+		SrcAddrs:    srcAddrs,
+		DstAddrs:    dstAddrs,
+		SrcPort:     req.SrcPort,
+		DstPort:     req.DstPort,
+		SrcPorts:    srcPorts,
+		DstPorts:    dstPorts,
+		SrcSet:      req.SrcSet,
+		DstSet:      req.DstSet,
+		Schedule:    req.Schedule,
+		ToIP:        net.ParseIP(req.ToIP),
+		ToPort:      req.ToPort,
+	}
+	id, ok := table.InsertRule(rule, nat.Placement(req.placementRequest))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id})
 }
 
 func (h *Handlers) GetQoS(c *gin.Context) {
@@ -2404,6 +3298,11 @@ func (h *Handlers) UpdateQoSClass(c *gin.Context) {
 		Protocol      string `json:"protocol"`
 		SrcPort       int    `json:"src_port"`
 		DstPort       int    `json:"dst_port"`
+		SrcPorts      string `json:"src_ports"`
+		DstPorts      string `json:"dst_ports"`
+		SrcSet        string `json:"src_set"`
+		DstSet        string `json:"dst_set"`
+		Schedule      string `json:"schedule"`
 		RateLimitKbps int    `json:"rate_limit_kbps"`
 		Priority      int    `json:"priority"`
 		MaxQueue      int    `json:"max_queue"`
@@ -2421,11 +3320,34 @@ func (h *Handlers) UpdateQoSClass(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 		return
 	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
+	if !h.scheduleDefined(req.Schedule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown schedule"})
+		return
+	}
 	ok := h.QoS.UpdateClass(req.OldName, qos.Class{
 		Name:          req.Name,
 		Protocol:      req.Protocol,
 		SrcPort:       req.SrcPort,
 		DstPort:       req.DstPort,
+		SrcPorts:      srcPorts,
+		DstPorts:      dstPorts,
+		SrcSet:        req.SrcSet,
+		DstSet:        req.DstSet,
+		Schedule:      req.Schedule,
 		RateLimitKbps: req.RateLimitKbps,
 		Priority:      req.Priority,
 		MaxQueue:      req.MaxQueue,
@@ -2444,6 +3366,11 @@ func (h *Handlers) AddQoSClass(c *gin.Context) {
 		Protocol      string `json:"protocol"`
 		SrcPort       int    `json:"src_port"`
 		DstPort       int    `json:"dst_port"`
+		SrcPorts      string `json:"src_ports"`
+		DstPorts      string `json:"dst_ports"`
+		SrcSet        string `json:"src_set"`
+		DstSet        string `json:"dst_set"`
+		Schedule      string `json:"schedule"`
 		RateLimitKbps int    `json:"rate_limit_kbps"`
 		Priority      int    `json:"priority"`
 		MaxQueue      int    `json:"max_queue"`
@@ -2457,12 +3384,35 @@ func (h *Handlers) AddQoSClass(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
 		return
 	}
+	srcPorts, err := network.ParsePortMatch(req.SrcPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ports"})
+		return
+	}
+	dstPorts, err := network.ParsePortMatch(req.DstPorts)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dst_ports"})
+		return
+	}
+	if !h.ipSetsDefined(req.SrcSet, req.DstSet) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown ip set"})
+		return
+	}
+	if !h.scheduleDefined(req.Schedule) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unknown schedule"})
+		return
+	}
 
 	class := qos.Class{
 		Name:          req.Name,
 		Protocol:      req.Protocol,
 		SrcPort:       req.SrcPort,
 		DstPort:       req.DstPort,
+		SrcPorts:      srcPorts,
+		DstPorts:      dstPorts,
+		SrcSet:        req.SrcSet,
+		DstSet:        req.DstSet,
+		Schedule:      req.Schedule,
 		RateLimitKbps: req.RateLimitKbps,
 		Priority:      req.Priority,
 		MaxQueue:      req.MaxQueue,
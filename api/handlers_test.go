@@ -493,6 +493,74 @@ func TestAddRoute(t *testing.T) {
 	}
 }
 
+func TestAddMultipathRouteShowsNextHopCounters(t *testing.T) {
+	h := &Handlers{
+		Routes:  routing.NewTable(nil),
+		NAT:     nat.NewTable(nil),
+		QoS:     qos.NewQueueManager(nil),
+		Metrics: metrics.NewWithRegistry(prometheus.NewRegistry()),
+	}
+	router := setupRouter(h)
+
+	payload := map[string]any{
+		"destination": "0.0.0.0/0",
+		"next_hops": []map[string]any{
+			{"gateway": "192.0.2.1", "interface": "wan1", "weight": 1},
+			{"gateway": "198.51.100.1", "interface": "wan2", "weight": 1},
+		},
+	}
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, "/api/routes", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+
+	h.Routes.LookupFlow(network.PacketMetadata{
+		SrcIP:    net.ParseIP("10.0.0.2"),
+		DstIP:    net.ParseIP("203.0.113.5"),
+		Protocol: "TCP",
+		SrcPort:  40000,
+		DstPort:  443,
+	})
+
+	req = httptest.NewRequest(http.MethodGet, "/api/routes", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	var resp []struct {
+		NextHops []struct {
+			Interface string `json:"interface"`
+			Up        bool   `json:"up"`
+			Packets   uint64 `json:"packets"`
+		} `json:"next_hops"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp) != 1 || len(resp[0].NextHops) != 2 {
+		t.Fatalf("expected one route with 2 next hops, got %s", w.Body.String())
+	}
+	total := resp[0].NextHops[0].Packets + resp[0].NextHops[1].Packets
+	if total != 1 || !resp[0].NextHops[0].Up {
+		t.Fatalf("unexpected next hop stats: %s", w.Body.String())
+	}
+
+	payload = map[string]any{
+		"destination": "0.0.0.0/0",
+		"next_hops":   []map[string]any{{"gateway": "bad"}},
+	}
+	body, _ = json.Marshal(payload)
+	req = httptest.NewRequest(http.MethodPost, "/api/routes", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+}
+
 func TestAddRouteInvalid(t *testing.T) {
 	h := &Handlers{
 		Routes:  routing.NewTable(nil),
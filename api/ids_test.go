@@ -138,3 +138,44 @@ func TestGetIDSAlerts(t *testing.T) {
 		t.Fatalf("expected alert in response")
 	}
 }
+
+func TestIDSRuleCountryMatch(t *testing.T) {
+	router, engine := setupIDSRouter()
+	post := func(payload map[string]any) int {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(http.MethodPost, "/api/ids/rules", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := post(map[string]any{"name": "bad", "src_country": "XYZ"}); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid country, got %d", code)
+	}
+	if code := post(map[string]any{"name": "geo", "action": "DROP", "src_country": "cn,ru", "src_asn": "AS64500"}); code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", code)
+	}
+	rule, ok := engine.GetRule("geo")
+	if !ok || rule.SrcCountry.String() != "CN,RU" || rule.SrcASN.String() != "64500" {
+		t.Fatalf("unexpected rule %+v", rule)
+	}
+
+	body, _ := json.Marshal(map[string]any{"priority": 5})
+	req := httptest.NewRequest(http.MethodPut, "/api/ids/rules/geo", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if rule, _ = engine.GetRule("geo"); rule.SrcCountry.String() != "CN,RU" {
+		t.Fatalf("expected update to keep the country match, got %q", rule.SrcCountry.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/ids/rules", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"src_country":"CN,RU"`)) {
+		t.Fatalf("expected country in response: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"router-go/pkg/ipset"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ipSetEntryView struct {
+	Address        string `json:"address"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"`
+}
+
+type ipSetView struct {
+	Name           string           `json:"name"`
+	Type           string           `json:"type"`
+	TimeoutSeconds int              `json:"timeout_seconds,omitempty"`
+	Size           int              `json:"size"`
+	Entries        []ipSetEntryView `json:"entries,omitempty"`
+}
+
+func (h *Handlers) GetIPSets(c *gin.Context) {
+	if h.IPSets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ip sets disabled"})
+		return
+	}
+	infos := h.IPSets.List()
+	out := make([]ipSetView, 0, len(infos))
+	for _, info := range infos {
+		out = append(out, ipSetView{
+			Name:           info.Name,
+			Type:           string(info.Type),
+			TimeoutSeconds: int(info.Timeout / time.Second),
+			Size:           info.Size,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GetIPSet returns a set with its live members; timeout_seconds on a member
+// is the time it has left.
+func (h *Handlers) GetIPSet(c *gin.Context) {
+	if h.IPSets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ip sets disabled"})
+		return
+	}
+	set, ok := h.IPSets.Get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ip set not found"})
+		return
+	}
+	now := time.Now()
+	entries := set.Entries()
+	view := ipSetView{
+		Name:           set.Name(),
+		Type:           string(set.Type()),
+		TimeoutSeconds: int(set.Timeout() / time.Second),
+		Size:           len(entries),
+		Entries:        make([]ipSetEntryView, 0, len(entries)),
+	}
+	for _, entry := range entries {
+		item := ipSetEntryView{Address: ipSetMember(entry.Prefix)}
+		if !entry.Expires.IsZero() {
+			item.TimeoutSeconds = max(int(math.Ceil(entry.Expires.Sub(now).Seconds())), 1)
+		}
+		view.Entries = append(view.Entries, item)
+	}
+	c.JSON(http.StatusOK, view)
+}
+
+func (h *Handlers) CreateIPSet(c *gin.Context) {
+	if h.IPSets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ip sets disabled"})
+		return
+	}
+	var req struct {
+		Name           string           `json:"name"`
+		Type           string           `json:"type"`
+		TimeoutSeconds int              `json:"timeout_seconds"`
+		Entries        []ipSetEntryView `json:"entries"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	typ, err := ipset.ParseType(req.Type)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid type"})
+		return
+	}
+	if !ipset.ValidName(req.Name) || req.TimeoutSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ip set"})
+		return
+	}
+	entries, err := parseIPSetEntries(typ, req.Entries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.IPSets.Create(req.Name, typ, time.Duration(req.TimeoutSeconds)*time.Second); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if len(entries) > 0 {
+		if err := h.IPSets.Replace(req.Name, entries); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) DeleteIPSet(c *gin.Context) {
+	if h.IPSets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ip sets disabled"})
+		return
+	}
+	if !h.IPSets.Delete(c.Param("name")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ip set not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// AddIPSetEntries adds all members of the request or none of them.
+func (h *Handlers) AddIPSetEntries(c *gin.Context) {
+	h.changeIPSetEntries(c, func(name string, entries []ipset.Entry) (gin.H, error) {
+		if err := h.IPSets.Add(name, entries); err != nil {
+			return nil, err
+		}
+		return gin.H{"status": "ok", "added": len(entries)}, nil
+	})
+}
+
+func (h *Handlers) RemoveIPSetEntries(c *gin.Context) {
+	h.changeIPSetEntries(c, func(name string, entries []ipset.Entry) (gin.H, error) {
+		prefixes := make([]*net.IPNet, 0, len(entries))
+		for _, entry := range entries {
+			prefixes = append(prefixes, entry.Prefix)
+		}
+		removed, err := h.IPSets.Remove(name, prefixes)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"status": "ok", "removed": removed}, nil
+	})
+}
+
+// ReplaceIPSetEntries swaps the whole membership in one step.
+func (h *Handlers) ReplaceIPSetEntries(c *gin.Context) {
+	h.changeIPSetEntries(c, func(name string, entries []ipset.Entry) (gin.H, error) {
+		if err := h.IPSets.Replace(name, entries); err != nil {
+			return nil, err
+		}
+		return gin.H{"status": "ok", "size": len(entries)}, nil
+	})
+}
+
+func (h *Handlers) changeIPSetEntries(c *gin.Context, apply func(name string, entries []ipset.Entry) (gin.H, error)) {
+	if h.IPSets == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ip sets disabled"})
+		return
+	}
+	set, ok := h.IPSets.Get(c.Param("name"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ip set not found"})
+		return
+	}
+	var req struct {
+		Entries []ipSetEntryView `json:"entries"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	entries, err := parseIPSetEntries(set.Type(), req.Entries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := apply(set.Name(), entries)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func parseIPSetEntries(typ ipset.Type, views []ipSetEntryView) ([]ipset.Entry, error) {
+	now := time.Now()
+	entries := make([]ipset.Entry, 0, len(views))
+	for _, view := range views {
+		prefix, err := ipset.ParseMember(typ, view.Address)
+		if err != nil {
+			return nil, err
+		}
+		entry := ipset.Entry{Prefix: prefix}
+		if view.TimeoutSeconds > 0 {
+			entry.Expires = now.Add(time.Duration(view.TimeoutSeconds) * time.Second)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func ipSetMember(prefix *net.IPNet) string {
+	if ones, bits := prefix.Mask.Size(); ones == bits {
+		return prefix.IP.String()
+	}
+	return prefix.String()
+}
+
+// ipSetsDefined reports whether every set reference names an existing set.
+func (h *Handlers) ipSetsDefined(refs ...string) bool {
+	for _, ref := range refs {
+		name, _ := ipset.ParseRef(ref)
+		if name == "" {
+			continue
+		}
+		if !h.IPSets.Has(name) {
+			return false
+		}
+	}
+	return true
+}
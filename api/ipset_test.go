@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"router-go/internal/metrics"
+	"router-go/pkg/firewall"
+	"router-go/pkg/ipset"
+	"router-go/pkg/network"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestIPSetLifecycleAndRuleReference(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sets := ipset.NewRegistry()
+	engine := firewall.NewEngineWithDefaults(nil, map[string]firewall.Action{"INPUT": firewall.ActionAccept})
+	engine.SetIPSets(sets)
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, IPSets: sets, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/ipsets", `{"name":"blocklist","entries":[{"address":"203.0.113.0/24"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = do(http.MethodPost, "/api/ipsets", `{"name":"blocklist"}`); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate set, got %d", w.Code)
+	}
+	w = do(http.MethodPost, "/api/firewall", `{"chain":"INPUT","action":"DROP","src_set":"blocklist"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w = do(http.MethodPost, "/api/firewall", `{"chain":"INPUT","action":"DROP","src_set":"missing"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown set, got %d", w.Code)
+	}
+	if w = do(http.MethodGet, "/api/firewall", ""); !bytes.Contains(w.Body.Bytes(), []byte(`"src_set":"blocklist"`)) {
+		t.Fatalf("expected src_set in rules: %s", w.Body.String())
+	}
+
+	pkt := func(ip string) network.Packet {
+		return network.Packet{Metadata: network.PacketMetadata{SrcIP: net.ParseIP(ip), Protocol: "TCP"}}
+	}
+	if got := engine.Evaluate("INPUT", pkt("198.51.100.9")); got != firewall.ActionAccept {
+		t.Fatalf("expected accept before the add, got %s", got)
+	}
+	w = do(http.MethodPost, "/api/ipsets/blocklist/entries", `{"entries":[{"address":"198.51.100.9","timeout_seconds":60},{"address":"2001:db8::/32"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := engine.Evaluate("INPUT", pkt("198.51.100.9")); got != firewall.ActionDrop {
+		t.Fatalf("expected added member to take effect without a reload, got %s", got)
+	}
+	w = do(http.MethodGet, "/api/ipsets/blocklist", "")
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"size":3`)) || !bytes.Contains(w.Body.Bytes(), []byte(`{"address":"198.51.100.9","timeout_seconds":60}`)) {
+		t.Fatalf("unexpected set view: %s", w.Body.String())
+	}
+	if w = do(http.MethodPost, "/api/ipsets/blocklist/entries", `{"entries":[{"address":"192.0.2.1"},{"address":"bogus"}]}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid entry, got %d", w.Code)
+	}
+	if set, _ := sets.Get("blocklist"); set.Len() != 3 {
+		t.Fatalf("expected rejected batch to leave the set untouched, got %d", set.Len())
+	}
+
+	w = do(http.MethodDelete, "/api/ipsets/blocklist/entries", `{"entries":[{"address":"198.51.100.9"}]}`)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"removed":1`)) {
+		t.Fatalf("unexpected remove response %d: %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodPut, "/api/ipsets/blocklist/entries", `{"entries":[{"address":"192.0.2.0/24"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if engine.Evaluate("INPUT", pkt("203.0.113.5")) != firewall.ActionAccept || engine.Evaluate("INPUT", pkt("192.0.2.7")) != firewall.ActionDrop {
+		t.Fatalf("expected replace to swap membership")
+	}
+
+	if w = do(http.MethodGet, "/api/ipsets", ""); !bytes.Contains(w.Body.Bytes(), []byte(`{"name":"blocklist","type":"hash:net","size":1}`)) {
+		t.Fatalf("unexpected set list: %s", w.Body.String())
+	}
+	if w = do(http.MethodDelete, "/api/ipsets/blocklist", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w = do(http.MethodGet, "/api/ipsets/blocklist", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 after delete, got %d", w.Code)
+	}
+}
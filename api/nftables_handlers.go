@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"router-go/pkg/nftables"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) RenderNFTables(c *gin.Context) {
+	if h.NFTables != nil {
+		c.JSON(http.StatusOK, h.NFTables.Render())
+		return
+	}
+	ruleset := nftables.RenderSpec(nftables.Spec{
+		Rules:        h.Firewall.Rules(),
+		Defaults:     h.Firewall.DefaultPolicies(),
+		Zones:        h.Firewall.Zones(),
+		ZonePolicies: h.Firewall.ZonePolicies(),
+		Sets:         h.IPSets,
+		Schedules:    h.Schedules,
+		NAT:          h.NAT.Rules(),
+	})
+	c.JSON(http.StatusOK, ruleset)
+}
+
+func (h *Handlers) GetNFTablesStatus(c *gin.Context) {
+	if h.NFTables == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "nftables disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, h.NFTables.Status())
+}
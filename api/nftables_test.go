@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"router-go/internal/metrics"
+	"router-go/pkg/firewall"
+	"router-go/pkg/nat"
+	"router-go/pkg/qos"
+	"router-go/pkg/routing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRenderNFTablesDryRun(t *testing.T) {
+	h := &Handlers{
+		Routes: routing.NewTable(nil),
+		Firewall: firewall.NewEngineWithDefaults([]firewall.Rule{
+			{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+		}, map[string]firewall.Action{"INPUT": firewall.ActionDrop}),
+		NAT:     nat.NewTable([]nat.Rule{{Type: nat.TypeSNAT, ToIP: net.ParseIP("203.0.113.10")}}),
+		QoS:     qos.NewQueueManager(nil),
+		Metrics: metrics.NewWithRegistry(prometheus.NewRegistry()),
+	}
+	router := setupRouter(h)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/nftables/render", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp struct {
+		Table         string `json:"table"`
+		Ruleset       string `json:"ruleset"`
+		FirewallRules int    `json:"firewall_rules"`
+		NATRules      int    `json:"nat_rules"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if resp.Table != "routergo" || resp.FirewallRules != 1 || resp.NATRules != 1 {
+		t.Fatalf("unexpected render response: %+v", resp)
+	}
+	if !strings.Contains(resp.Ruleset, "tcp dport 22 counter accept") || !strings.Contains(resp.Ruleset, "snat ip to 203.0.113.10") {
+		t.Fatalf("unexpected ruleset:\n%s", resp.Ruleset)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/nftables/status", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when backend disabled, got %d", w.Code)
+	}
+}
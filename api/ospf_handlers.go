@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) GetOSPFInterfaces(c *gin.Context) {
+	if h.OSPF == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ospf disabled"})
+		return
+	}
+	type interfaceView struct {
+		Name      string `json:"name"`
+		Address   string `json:"address"`
+		Network   string `json:"network_type"`
+		State     string `json:"state"`
+		Cost      int    `json:"cost"`
+		Priority  int    `json:"priority"`
+		DR        string `json:"dr,omitempty"`
+		BDR       string `json:"bdr,omitempty"`
+		Neighbors int    `json:"neighbors"`
+	}
+	ifaces := h.OSPF.Interfaces()
+	out := make([]interfaceView, 0, len(ifaces))
+	for _, i := range ifaces {
+		out = append(out, interfaceView{
+			Name:      i.Name,
+			Address:   i.Address,
+			Network:   i.Network,
+			State:     i.State,
+			Cost:      i.Cost,
+			Priority:  i.Priority,
+			DR:        i.DR,
+			BDR:       i.BDR,
+			Neighbors: i.Neighbors,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) GetOSPFNeighbors(c *gin.Context) {
+	if h.OSPF == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ospf disabled"})
+		return
+	}
+	type neighborView struct {
+		Interface   string `json:"interface"`
+		RouterID    string `json:"router_id"`
+		Address     string `json:"address"`
+		State       string `json:"state"`
+		Priority    int    `json:"priority"`
+		DR          string `json:"dr,omitempty"`
+		BDR         string `json:"bdr,omitempty"`
+		Requests    int    `json:"requests"`
+		Retransmits int    `json:"retransmits"`
+		LastHello   string `json:"last_hello,omitempty"`
+		FullSince   string `json:"full_since,omitempty"`
+	}
+	neighbors := h.OSPF.Neighbors()
+	out := make([]neighborView, 0, len(neighbors))
+	for _, n := range neighbors {
+		view := neighborView{
+			Interface:   n.Interface,
+			RouterID:    n.RouterID,
+			Address:     n.Address,
+			State:       n.State,
+			Priority:    n.Priority,
+			DR:          n.DR,
+			BDR:         n.BDR,
+			Requests:    n.Requests,
+			Retransmits: n.Retransmits,
+		}
+		if !n.LastHello.IsZero() {
+			view.LastHello = n.LastHello.UTC().Format(time.RFC3339)
+		}
+		if !n.FullSince.IsZero() && n.State == "full" {
+			view.FullSince = n.FullSince.UTC().Format(time.RFC3339)
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) GetOSPFLSDB(c *gin.Context) {
+	if h.OSPF == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "ospf disabled"})
+		return
+	}
+	type linkView struct {
+		Type   string `json:"type"`
+		ID     string `json:"id"`
+		Data   string `json:"data"`
+		Metric int    `json:"metric"`
+	}
+	type lsaView struct {
+		Type        string     `json:"type"`
+		LinkStateID string     `json:"link_state_id"`
+		AdvRouter   string     `json:"advertising_router"`
+		Age         int        `json:"age"`
+		Sequence    string     `json:"sequence"`
+		Checksum    string     `json:"checksum"`
+		Links       []linkView `json:"links,omitempty"`
+		Mask        string     `json:"mask,omitempty"`
+		Attached    []string   `json:"attached_routers,omitempty"`
+	}
+	entries := h.OSPF.LSDB()
+	out := make([]lsaView, 0, len(entries))
+	for _, e := range entries {
+		view := lsaView{
+			Type:        e.Type,
+			LinkStateID: e.LinkStateID,
+			AdvRouter:   e.AdvRouter,
+			Age:         e.Age,
+			Sequence:    e.Sequence,
+			Checksum:    e.Checksum,
+			Mask:        e.Mask,
+			Attached:    e.Attached,
+		}
+		for _, link := range e.Links {
+			view.Links = append(view.Links, linkView{Type: link.Type, ID: link.ID, Data: link.Data, Metric: link.Metric})
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *Handlers) GetRIPRoutes(c *gin.Context) {
+	if h.RIP == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "rip disabled"})
+		return
+	}
+	type ripRouteView struct {
+		Prefix    string `json:"prefix"`
+		Family    string `json:"family"`
+		Metric    int    `json:"metric"`
+		NextHop   string `json:"next_hop,omitempty"`
+		Interface string `json:"interface,omitempty"`
+		From      string `json:"from,omitempty"`
+		Tag       uint16 `json:"tag,omitempty"`
+		Local     bool   `json:"local"`
+		State     string `json:"state"`
+		Expires   string `json:"expires,omitempty"`
+	}
+	routes := h.RIP.Routes()
+	out := make([]ripRouteView, 0, len(routes))
+	for _, r := range routes {
+		view := ripRouteView{
+			Prefix:    r.Prefix,
+			Family:    r.Family,
+			Metric:    r.Metric,
+			NextHop:   r.NextHop,
+			Interface: r.Interface,
+			From:      r.From,
+			Tag:       r.Tag,
+			Local:     r.Local,
+			State:     r.State,
+		}
+		if !r.Expires.IsZero() {
+			view.Expires = r.Expires.UTC().Format(time.RFC3339)
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) GetRIPNeighbors(c *gin.Context) {
+	if h.RIP == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "rip disabled"})
+		return
+	}
+	type ripNeighborView struct {
+		Interface  string `json:"interface"`
+		Family     string `json:"family"`
+		Address    string `json:"address"`
+		LastUpdate string `json:"last_update,omitempty"`
+		Routes     int    `json:"routes"`
+		BadPackets int    `json:"bad_packets"`
+		BadRoutes  int    `json:"bad_routes"`
+	}
+	neighbors := h.RIP.Neighbors()
+	out := make([]ripNeighborView, 0, len(neighbors))
+	for _, n := range neighbors {
+		view := ripNeighborView{
+			Interface:  n.Interface,
+			Family:     n.Family,
+			Address:    n.Address,
+			Routes:     n.Routes,
+			BadPackets: n.BadPackets,
+			BadRoutes:  n.BadRoutes,
+		}
+		if !n.LastUpdate.IsZero() {
+			view.LastUpdate = n.LastUpdate.UTC().Format(time.RFC3339)
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
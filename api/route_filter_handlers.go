@@ -0,0 +1,241 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"router-go/pkg/routing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type prefixListEntryView struct {
+	Seq    int    `json:"seq"`
+	Action string `json:"action"`
+	Prefix string `json:"prefix"`
+	GE     int    `json:"ge,omitempty"`
+	LE     int    `json:"le,omitempty"`
+}
+
+type routeMapEntryView struct {
+	Seq             int     `json:"seq"`
+	Action          string  `json:"action"`
+	MatchPrefixList string  `json:"match_prefix_list,omitempty"`
+	MatchSource     string  `json:"match_source,omitempty"`
+	MatchMetric     *int    `json:"match_metric,omitempty"`
+	SetMetric       *int    `json:"set_metric,omitempty"`
+	SetTag          *uint32 `json:"set_tag,omitempty"`
+}
+
+func (h *Handlers) GetPrefixLists(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	type listView struct {
+		Name    string                `json:"name"`
+		Entries []prefixListEntryView `json:"entries"`
+	}
+	lists := h.RouteFilters.PrefixLists()
+	out := make([]listView, 0, len(lists))
+	for _, list := range lists {
+		view := listView{Name: list.Name, Entries: make([]prefixListEntryView, 0, len(list.Entries))}
+		for _, entry := range list.Entries {
+			view.Entries = append(view.Entries, prefixListEntryView{
+				Seq:    entry.Seq,
+				Action: entry.Action,
+				Prefix: entry.Prefix.String(),
+				GE:     entry.GE,
+				LE:     entry.LE,
+			})
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) SetPrefixList(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	var req struct {
+		Entries []prefixListEntryView `json:"entries"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	list := routing.PrefixList{Name: c.Param("name")}
+	for _, entry := range req.Entries {
+		_, prefix, err := net.ParseCIDR(entry.Prefix)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid prefix " + entry.Prefix})
+			return
+		}
+		list.Entries = append(list.Entries, routing.PrefixListEntry{
+			Seq:    entry.Seq,
+			Action: filterAction(entry.Action),
+			Prefix: *prefix,
+			GE:     entry.GE,
+			LE:     entry.LE,
+		})
+	}
+	if err := h.RouteFilters.SetPrefixList(list); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) DeletePrefixList(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	err := h.RouteFilters.DeletePrefixList(c.Param("name"))
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	case errors.Is(err, routing.ErrPrefixListNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	}
+}
+
+func (h *Handlers) GetRouteMaps(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	type mapView struct {
+		Name    string              `json:"name"`
+		Entries []routeMapEntryView `json:"entries"`
+	}
+	maps := h.RouteFilters.RouteMaps()
+	out := make([]mapView, 0, len(maps))
+	for _, m := range maps {
+		view := mapView{Name: m.Name, Entries: make([]routeMapEntryView, 0, len(m.Entries))}
+		for _, entry := range m.Entries {
+			view.Entries = append(view.Entries, routeMapEntryView{
+				Seq:             entry.Seq,
+				Action:          entry.Action,
+				MatchPrefixList: entry.MatchPrefixList,
+				MatchSource:     entry.MatchSource,
+				MatchMetric:     entry.MatchMetric,
+				SetMetric:       entry.SetMetric,
+				SetTag:          entry.SetTag,
+			})
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) SetRouteMap(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	var req struct {
+		Entries []routeMapEntryView `json:"entries"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	m := routing.RouteMap{Name: c.Param("name")}
+	for _, entry := range req.Entries {
+		m.Entries = append(m.Entries, routing.RouteMapEntry{
+			Seq:             entry.Seq,
+			Action:          filterAction(entry.Action),
+			MatchPrefixList: entry.MatchPrefixList,
+			MatchSource:     entry.MatchSource,
+			MatchMetric:     entry.MatchMetric,
+			SetMetric:       entry.SetMetric,
+			SetTag:          entry.SetTag,
+		})
+	}
+	if err := h.RouteFilters.SetRouteMap(m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) DeleteRouteMap(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	if !h.RouteFilters.DeleteRouteMap(c.Param("name")) {
+		c.JSON(http.StatusNotFound, gin.H{"error": routing.ErrRouteMapNotFound.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// TestRouteMap evaluates a route against a route map without installing it
+// and returns the per-entry trace.
+func (h *Handlers) TestRouteMap(c *gin.Context) {
+	if h.RouteFilters == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route filters disabled"})
+		return
+	}
+	var req struct {
+		Destination string `json:"destination"`
+		Source      string `json:"source"`
+		Metric      int    `json:"metric"`
+		Tag         uint32 `json:"tag"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	_, dst, err := net.ParseCIDR(req.Destination)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid destination"})
+		return
+	}
+	result := h.RouteFilters.Apply(c.Param("name"), routing.Route{
+		Destination: *dst,
+		Source:      req.Source,
+		Metric:      req.Metric,
+		Tag:         req.Tag,
+	})
+	action := routing.ActionDeny
+	if result.Permit {
+		action = routing.ActionPermit
+	}
+	trace := result.Trace
+	if trace == nil {
+		trace = []routing.RouteMapStep{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"route_map": result.Map,
+		"action":    action,
+		"seq":       result.Seq,
+		"metric":    result.Route.Metric,
+		"tag":       result.Route.Tag,
+		"trace":     trace,
+	})
+}
+
+func (h *Handlers) GetP2PFilteredRoutes(c *gin.Context) {
+	if h.P2P == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "p2p disabled"})
+		return
+	}
+	c.JSON(http.StatusOK, h.P2P.Filtered())
+}
+
+func filterAction(action string) string {
+	action = strings.ToLower(strings.TrimSpace(action))
+	if action == "" {
+		return routing.ActionPermit
+	}
+	return action
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"router-go/pkg/routing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const routeWatchHeartbeat = 15 * time.Second
+
+type routeChangeView struct {
+	Destination string           `json:"destination"`
+	Gateway     string           `json:"gateway,omitempty"`
+	Interface   string           `json:"interface,omitempty"`
+	Metric      int              `json:"metric"`
+	Distance    int              `json:"distance"`
+	Source      string           `json:"source,omitempty"`
+	Tag         uint32           `json:"tag,omitempty"`
+	TargetVRF   string           `json:"target_vrf,omitempty"`
+	NextHops    []nextHopRequest `json:"next_hops,omitempty"`
+}
+
+type routeEventView struct {
+	Revision uint64           `json:"revision"`
+	Type     string           `json:"type"`
+	Source   string           `json:"source,omitempty"`
+	Route    routeChangeView  `json:"route"`
+	Previous *routeChangeView `json:"previous,omitempty"`
+}
+
+// WatchRoutes streams table changes as server-sent events. Each event id is
+// the table revision; clients resume with ?since= or Last-Event-ID.
+func (h *Handlers) WatchRoutes(c *gin.Context) {
+	table, ok := h.routeTable(c)
+	if !ok {
+		return
+	}
+	since := table.Revision()
+	resume := strings.TrimSpace(c.Query("since"))
+	if resume == "" {
+		resume = strings.TrimSpace(c.GetHeader("Last-Event-ID"))
+	}
+	if resume != "" {
+		parsed, err := strconv.ParseUint(resume, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+		since = parsed
+	}
+	events, stop, err := table.Watch(since)
+	if err != nil {
+		if errors.Is(err, routing.ErrRevisionCompacted) {
+			c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+	writeSSE(c.Writer, since, "sync", gin.H{"revision": since})
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(routeWatchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, _ = io.WriteString(c.Writer, ": ping\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			writeSSE(c.Writer, ev.Revision, ev.Type, routeEventViewFrom(ev))
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeSSE(w io.Writer, id uint64, event string, payload any) {
+	data, _ := json.Marshal(payload)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, data)
+}
+
+func routeEventViewFrom(ev routing.RouteEvent) routeEventView {
+	out := routeEventView{
+		Revision: ev.Revision,
+		Type:     ev.Type,
+		Source:   ev.Route.Source,
+		Route:    routeChangeViewFrom(ev.Route),
+	}
+	if ev.Previous != nil {
+		prev := routeChangeViewFrom(*ev.Previous)
+		out.Previous = &prev
+	}
+	return out
+}
+
+func routeChangeViewFrom(r routing.Route) routeChangeView {
+	out := routeChangeView{
+		Destination: r.Destination.String(),
+		Interface:   r.Interface,
+		Metric:      r.Metric,
+		Distance:    r.Distance,
+		Source:      r.Source,
+		Tag:         r.Tag,
+		TargetVRF:   r.TargetVRF,
+	}
+	if r.Gateway != nil {
+		out.Gateway = r.Gateway.String()
+	}
+	for _, hop := range r.NextHops {
+		view := nextHopRequest{Interface: hop.Interface, Weight: hop.Weight, Probe: hop.Probe}
+		if hop.Gateway != nil {
+			view.Gateway = hop.Gateway.String()
+		}
+		out.NextHops = append(out.NextHops, view)
+	}
+	return out
+}
@@ -20,14 +20,50 @@ func RegisterRoutes(router *gin.Engine, handlers *Handlers) {
 	apiGroup.POST("/routes", RequireRole(roleOps), handlers.AddRoute)
 	apiGroup.DELETE("/routes", RequireRole(roleOps), handlers.DeleteRoute)
 	apiGroup.PUT("/routes", RequireRole(roleOps), handlers.UpdateRoute)
+	apiGroup.GET("/routes/tracking", RequireRole(roleRead), handlers.GetRouteTracking)
+	apiGroup.GET("/routes/watch", RequireRole(roleRead), handlers.WatchRoutes)
+	apiGroup.GET("/routing/tables", RequireRole(roleRead), handlers.GetRoutingTables)
+	apiGroup.POST("/routing/tables", RequireRole(roleOps), handlers.AddRoutingTable)
+	apiGroup.DELETE("/routing/tables/:name", RequireRole(roleOps), handlers.DeleteRoutingTable)
+	apiGroup.GET("/routing/rules", RequireRole(roleRead), handlers.GetRoutingRules)
+	apiGroup.POST("/routing/rules", RequireRole(roleOps), handlers.AddRoutingRule)
+	apiGroup.PUT("/routing/rules/:priority", RequireRole(roleOps), handlers.UpdateRoutingRule)
+	apiGroup.DELETE("/routing/rules/:priority", RequireRole(roleOps), handlers.DeleteRoutingRule)
+	apiGroup.GET("/routing/prefix-lists", RequireRole(roleRead), handlers.GetPrefixLists)
+	apiGroup.PUT("/routing/prefix-lists/:name", RequireRole(roleOps), handlers.SetPrefixList)
+	apiGroup.DELETE("/routing/prefix-lists/:name", RequireRole(roleOps), handlers.DeletePrefixList)
+	apiGroup.GET("/routing/route-maps", RequireRole(roleRead), handlers.GetRouteMaps)
+	apiGroup.PUT("/routing/route-maps/:name", RequireRole(roleOps), handlers.SetRouteMap)
+	apiGroup.DELETE("/routing/route-maps/:name", RequireRole(roleOps), handlers.DeleteRouteMap)
+	apiGroup.POST("/routing/route-maps/:name/test", RequireRole(roleRead), handlers.TestRouteMap)
+	apiGroup.GET("/bgp/neighbors", RequireRole(roleRead), handlers.GetBGPNeighbors)
+	apiGroup.GET("/bgp/rib", RequireRole(roleRead), handlers.GetBGPRIB)
+	apiGroup.GET("/ospf/interfaces", RequireRole(roleRead), handlers.GetOSPFInterfaces)
+	apiGroup.GET("/ospf/neighbors", RequireRole(roleRead), handlers.GetOSPFNeighbors)
+	apiGroup.GET("/ospf/lsdb", RequireRole(roleRead), handlers.GetOSPFLSDB)
+	apiGroup.GET("/rip/routes", RequireRole(roleRead), handlers.GetRIPRoutes)
+	apiGroup.GET("/rip/neighbors", RequireRole(roleRead), handlers.GetRIPNeighbors)
+	apiGroup.GET("/bfd/sessions", RequireRole(roleRead), handlers.GetBFDSessions)
+	apiGroup.GET("/vrfs", RequireRole(roleRead), handlers.GetVRFs)
+	apiGroup.POST("/vrfs/leaks", RequireRole(roleOps), handlers.AddRouteLeak)
+	apiGroup.DELETE("/vrfs/leaks", RequireRole(roleOps), handlers.DeleteRouteLeak)
 	apiGroup.POST("/firewall", RequireRole(roleOps), handlers.AddFirewallRule)
 	apiGroup.DELETE("/firewall", RequireRole(roleOps), handlers.DeleteFirewallRule)
 	apiGroup.PUT("/firewall", RequireRole(roleOps), handlers.UpdateFirewallRule)
 	apiGroup.GET("/firewall", RequireRole(roleRead), handlers.GetFirewallRules)
 	apiGroup.GET("/firewall/defaults", RequireRole(roleRead), handlers.GetFirewallDefaults)
+	apiGroup.GET("/firewall/zones", RequireRole(roleRead), handlers.GetFirewallZones)
+	apiGroup.GET("/schedules", RequireRole(roleRead), handlers.GetSchedules)
 	apiGroup.GET("/firewall/stats", RequireRole(roleRead), handlers.GetFirewallStats)
+	apiGroup.GET("/firewall/analyze", RequireRole(roleRead), handlers.AnalyzeFirewall)
+	apiGroup.POST("/firewall/trace", RequireRole(roleRead), handlers.TraceFirewall)
 	apiGroup.POST("/firewall/reset", RequireRole(roleOps), handlers.ResetFirewallStats)
 	apiGroup.POST("/firewall/defaults", RequireRole(roleOps), handlers.SetFirewallDefault)
+	apiGroup.POST("/firewall/rules/:id/move", RequireRole(roleOps), handlers.MoveFirewallRule)
+	apiGroup.POST("/firewall/rules/:id/enable", RequireRole(roleOps), handlers.EnableFirewallRule)
+	apiGroup.POST("/firewall/rules/:id/disable", RequireRole(roleOps), handlers.DisableFirewallRule)
+	apiGroup.GET("/nftables/render", RequireRole(roleRead), handlers.RenderNFTables)
+	apiGroup.GET("/nftables/status", RequireRole(roleRead), handlers.GetNFTablesStatus)
 	apiGroup.GET("/stats", RequireRole(roleRead), handlers.GetStats)
 	apiGroup.GET("/ids/rules", RequireRole(roleRead), handlers.GetIDSRules)
 	apiGroup.POST("/ids/rules", RequireRole(roleOps), handlers.AddIDSRule)
@@ -84,6 +120,7 @@ func RegisterRoutes(router *gin.Engine, handlers *Handlers) {
 	apiGroup.GET("/observability/alerts", RequireRole(roleRead), handlers.GetAlerts)
 	apiGroup.GET("/p2p/peers", RequireRole(roleRead), handlers.GetP2PPeers)
 	apiGroup.GET("/p2p/routes", RequireRole(roleRead), handlers.GetP2PRoutes)
+	apiGroup.GET("/p2p/routes/filtered", RequireRole(roleRead), handlers.GetP2PFilteredRoutes)
 	apiGroup.POST("/p2p/reset", RequireRole(roleOps), handlers.ResetP2P)
 	apiGroup.GET("/proxy/stats", RequireRole(roleRead), handlers.GetProxyStats)
 	apiGroup.POST("/proxy/cache/clear", RequireRole(roleOps), handlers.ClearProxyCache)
@@ -91,11 +128,22 @@ func RegisterRoutes(router *gin.Engine, handlers *Handlers) {
 	apiGroup.GET("/ha/status", RequireRole(roleRead), handlers.GetHAStatus)
 	apiGroup.GET("/ha/state", RequireRole(roleRead), handlers.GetHAState)
 	apiGroup.POST("/ha/state", RequireRole(roleOps), handlers.ApplyHAState)
+	apiGroup.GET("/conntrack", RequireRole(roleRead), handlers.GetConntrack)
+	apiGroup.GET("/ipsets", RequireRole(roleRead), handlers.GetIPSets)
+	apiGroup.POST("/ipsets", RequireRole(roleOps), handlers.CreateIPSet)
+	apiGroup.GET("/ipsets/:name", RequireRole(roleRead), handlers.GetIPSet)
+	apiGroup.DELETE("/ipsets/:name", RequireRole(roleOps), handlers.DeleteIPSet)
+	apiGroup.POST("/ipsets/:name/entries", RequireRole(roleOps), handlers.AddIPSetEntries)
+	apiGroup.DELETE("/ipsets/:name/entries", RequireRole(roleOps), handlers.RemoveIPSetEntries)
+	apiGroup.PUT("/ipsets/:name/entries", RequireRole(roleOps), handlers.ReplaceIPSetEntries)
 	apiGroup.GET("/nat", RequireRole(roleRead), handlers.GetNAT)
 	apiGroup.POST("/nat/reset", RequireRole(roleOps), handlers.ResetNATStats)
 	apiGroup.POST("/nat", RequireRole(roleOps), handlers.AddNATRule)
 	apiGroup.DELETE("/nat", RequireRole(roleOps), handlers.DeleteNATRule)
 	apiGroup.PUT("/nat", RequireRole(roleOps), handlers.UpdateNATRule)
+	apiGroup.POST("/nat/rules/:id/move", RequireRole(roleOps), handlers.MoveNATRule)
+	apiGroup.POST("/nat/rules/:id/enable", RequireRole(roleOps), handlers.EnableNATRule)
+	apiGroup.POST("/nat/rules/:id/disable", RequireRole(roleOps), handlers.DisableNATRule)
 	apiGroup.GET("/qos", RequireRole(roleRead), handlers.GetQoS)
 	apiGroup.POST("/qos", RequireRole(roleOps), handlers.AddQoSClass)
 	apiGroup.DELETE("/qos", RequireRole(roleOps), handlers.DeleteQoSClass)
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"router-go/pkg/routing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type policyRuleView struct {
+	Priority    int    `json:"priority"`
+	SrcIP       string `json:"src_ip,omitempty"`
+	DstIP       string `json:"dst_ip,omitempty"`
+	InInterface string `json:"in_interface,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	SrcPort     int    `json:"src_port,omitempty"`
+	DstPort     int    `json:"dst_port,omitempty"`
+	DSCP        *int   `json:"dscp,omitempty"`
+	Mark        uint32 `json:"mark,omitempty"`
+	MarkMask    uint32 `json:"mark_mask,omitempty"`
+	Table       string `json:"table"`
+}
+
+func (h *Handlers) GetRoutingTables(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	type tableView struct {
+		Name   string `json:"name"`
+		Routes int    `json:"routes"`
+	}
+	names := h.RoutePolicy.TableNames()
+	out := make([]tableView, 0, len(names))
+	for _, name := range names {
+		table, ok := h.RoutePolicy.Table(name)
+		if !ok {
+			continue
+		}
+		out = append(out, tableView{Name: name, Routes: len(table.Routes())})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) AddRoutingTable(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if _, err := h.RoutePolicy.AddTable(name, nil); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) DeleteRoutingTable(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	err := h.RoutePolicy.RemoveTable(c.Param("name"))
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	case errors.Is(err, routing.ErrTableNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	}
+}
+
+func (h *Handlers) GetRoutingRules(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	rules := h.RoutePolicy.Rules()
+	out := make([]policyRuleView, 0, len(rules))
+	for _, rule := range rules {
+		out = append(out, policyRuleViewFrom(rule))
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func (h *Handlers) AddRoutingRule(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	var req policyRuleView
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	rule, err := parsePolicyRule(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.RoutePolicy.AddRule(rule); err != nil {
+		c.JSON(policyRuleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) UpdateRoutingRule(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	priority, err := strconv.Atoi(c.Param("priority"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid priority"})
+		return
+	}
+	var req policyRuleView
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	rule, err := parsePolicyRule(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.RoutePolicy.UpdateRule(priority, rule); err != nil {
+		c.JSON(policyRuleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) DeleteRoutingRule(c *gin.Context) {
+	if h.RoutePolicy == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "routing policy disabled"})
+		return
+	}
+	priority, err := strconv.Atoi(c.Param("priority"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid priority"})
+		return
+	}
+	if !h.RoutePolicy.RemoveRule(priority) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "policy rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func parsePolicyRule(req policyRuleView) (routing.PolicyRule, error) {
+	rule := routing.PolicyRule{
+		Priority:    req.Priority,
+		InInterface: req.InInterface,
+		Protocol:    strings.ToUpper(strings.TrimSpace(req.Protocol)),
+		SrcPort:     req.SrcPort,
+		DstPort:     req.DstPort,
+		Mark:        req.Mark,
+		MarkMask:    req.MarkMask,
+		Table:       strings.TrimSpace(req.Table),
+	}
+	if rule.Table == "" {
+		return rule, errors.New("table is required")
+	}
+	if req.SrcIP != "" {
+		_, parsed, err := net.ParseCIDR(req.SrcIP)
+		if err != nil {
+			return rule, errors.New("invalid src_ip")
+		}
+		rule.SrcNet = parsed
+	}
+	if req.DstIP != "" {
+		_, parsed, err := net.ParseCIDR(req.DstIP)
+		if err != nil {
+			return rule, errors.New("invalid dst_ip")
+		}
+		rule.DstNet = parsed
+	}
+	if req.SrcPort < 0 || req.SrcPort > 65535 || req.DstPort < 0 || req.DstPort > 65535 {
+		return rule, errors.New("invalid port")
+	}
+	if req.DSCP != nil {
+		if *req.DSCP < 0 || *req.DSCP > 63 {
+			return rule, errors.New("invalid dscp")
+		}
+		rule.DSCP = uint8(*req.DSCP)
+		rule.MatchDSCP = true
+	}
+	return rule, nil
+}
+
+func policyRuleViewFrom(rule routing.PolicyRule) policyRuleView {
+	out := policyRuleView{
+		Priority:    rule.Priority,
+		InInterface: rule.InInterface,
+		Protocol:    rule.Protocol,
+		SrcPort:     rule.SrcPort,
+		DstPort:     rule.DstPort,
+		Mark:        rule.Mark,
+		MarkMask:    rule.MarkMask,
+		Table:       rule.Table,
+	}
+	if rule.SrcNet != nil {
+		out.SrcIP = rule.SrcNet.String()
+	}
+	if rule.DstNet != nil {
+		out.DstIP = rule.DstNet.String()
+	}
+	if rule.MatchDSCP {
+		dscp := int(rule.DSCP)
+		out.DSCP = &dscp
+	}
+	return out
+}
+
+func policyRuleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, routing.ErrRuleNotFound), errors.Is(err, routing.ErrTableNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, routing.ErrRuleExists):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+func (h *Handlers) GetRouteTracking(c *gin.Context) {
+	if h.RouteTracker == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "route tracking disabled"})
+		return
+	}
+	type trackView struct {
+		Destination string `json:"destination"`
+		Gateway     string `json:"gateway,omitempty"`
+		Interface   string `json:"interface,omitempty"`
+		Metric      int    `json:"metric"`
+		Type        string `json:"type"`
+		Target      string `json:"target"`
+		Up          bool   `json:"up"`
+		Failures    int    `json:"failures"`
+		Successes   int    `json:"successes"`
+		Transitions int    `json:"transitions"`
+		LastError   string `json:"last_error,omitempty"`
+		LastCheck   string `json:"last_check,omitempty"`
+	}
+	statuses := h.RouteTracker.Status()
+	out := make([]trackView, 0, len(statuses))
+	for _, st := range statuses {
+		view := trackView{
+			Destination: st.Route.Destination.String(),
+			Interface:   st.Route.Interface,
+			Metric:      st.Route.Metric,
+			Type:        st.Type,
+			Target:      st.Target,
+			Up:          st.Up,
+			Failures:    st.Failures,
+			Successes:   st.Successes,
+			Transitions: st.Transitions,
+			LastError:   st.LastError,
+		}
+		if st.Route.Gateway != nil {
+			view.Gateway = st.Route.Gateway.String()
+		}
+		if !st.LastCheck.IsZero() {
+			view.LastCheck = st.LastCheck.UTC().Format(time.RFC3339)
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, out)
+}
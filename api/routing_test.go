@@ -0,0 +1,528 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"router-go/internal/metrics"
+	"router-go/pkg/bfd"
+	"router-go/pkg/bgp"
+	"router-go/pkg/firewall"
+	"router-go/pkg/nat"
+	"router-go/pkg/ospf"
+	"router-go/pkg/qos"
+	"router-go/pkg/rip"
+	"router-go/pkg/routing"
+	"router-go/pkg/vrf"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func newRoutingPolicyHandlers() *Handlers {
+	table := routing.NewTable(nil)
+	return &Handlers{
+		Routes:      table,
+		RoutePolicy: routing.NewPolicy(table),
+		NAT:         nat.NewTable(nil),
+		QoS:         qos.NewQueueManager(nil),
+		Metrics:     metrics.NewWithRegistry(prometheus.NewRegistry()),
+	}
+}
+
+func doJSON(t *testing.T, router http.Handler, method string, path string, payload any) *httptest.ResponseRecorder {
+	t.Helper()
+	var body []byte
+	if payload != nil {
+		body, _ = json.Marshal(payload)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoutingTablesAndRulesCRUD(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	router := setupRouter(h)
+
+	if w := doJSON(t, router, http.MethodPost, "/api/routing/tables", map[string]any{"name": "isp2"}); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 creating table, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodPost, "/api/routing/tables", map[string]any{"name": "isp2"}); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate table, got %d", w.Code)
+	}
+	w := doJSON(t, router, http.MethodPost, "/api/routes?table=isp2", map[string]any{
+		"destination": "0.0.0.0/0",
+		"interface":   "wan2",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding route to isp2, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/routes?table=missing", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown table, got %d", w.Code)
+	}
+	w = doJSON(t, router, http.MethodGet, "/api/routes?table=isp2", nil)
+	if !bytes.Contains(w.Body.Bytes(), []byte("wan2")) {
+		t.Fatalf("expected isp2 route, got %s", w.Body.String())
+	}
+	if len(h.Routes.Routes()) != 0 {
+		t.Fatalf("expected main table untouched")
+	}
+
+	w = doJSON(t, router, http.MethodPost, "/api/routing/rules", map[string]any{
+		"priority": 100,
+		"src_ip":   "10.0.2.0/24",
+		"dscp":     0,
+		"table":    "isp2",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding rule, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodPost, "/api/routing/rules", map[string]any{"priority": 100, "table": "isp2"}); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate priority, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodPost, "/api/routing/rules", map[string]any{"priority": 5, "src_ip": "bad", "table": "isp2"}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid src_ip, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/tables/isp2", nil); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 deleting referenced table, got %d", w.Code)
+	}
+
+	w = doJSON(t, router, http.MethodPut, "/api/routing/rules/100", map[string]any{
+		"priority": 50,
+		"protocol": "udp",
+		"table":    "isp2",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 updating rule, got %d", w.Code)
+	}
+	w = doJSON(t, router, http.MethodGet, "/api/routing/rules", nil)
+	var rules []policyRuleView
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		t.Fatalf("decode rules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Priority != 50 || rules[0].Protocol != "UDP" || rules[0].DSCP != nil {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/rules/50", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting rule, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/rules/50", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 deleting missing rule, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/tables/isp2", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 deleting table, got %d", w.Code)
+	}
+}
+
+func TestRoutingPolicyDisabled(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	h.RoutePolicy = nil
+	router := setupRouter(h)
+	if w := doJSON(t, router, http.MethodGet, "/api/routing/rules", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", w.Code)
+	}
+}
+
+func TestVRFScopedRoutesFirewallAndLeaks(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	h.Firewall = firewall.NewEngine(nil)
+	h.VRFs = vrf.NewManager(&vrf.Instance{Routes: h.Routes, Policy: h.RoutePolicy, Firewall: h.Firewall, NAT: h.NAT})
+	if err := h.VRFs.Add(&vrf.Instance{Name: "tenant", Routes: h.Routes.NewSibling(nil)}); err != nil {
+		t.Fatalf("add vrf: %v", err)
+	}
+	router := setupRouter(h)
+
+	w := doJSON(t, router, http.MethodPost, "/api/routes?vrf=tenant", map[string]any{
+		"destination": "192.168.50.0/24",
+		"interface":   "tenant-lan",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding vrf route, got %d", w.Code)
+	}
+	if len(h.Routes.Routes()) != 0 {
+		t.Fatalf("vrf route leaked into default table")
+	}
+	w = doJSON(t, router, http.MethodPost, "/api/firewall?vrf=tenant", map[string]any{"chain": "FORWARD", "action": "DROP"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding vrf firewall rule, got %d", w.Code)
+	}
+	tenant, _ := h.VRFs.Get("tenant")
+	if len(tenant.Firewall.Rules()) != 1 || len(h.Firewall.Rules()) != 0 {
+		t.Fatalf("expected firewall rule only in tenant vrf")
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/nat?vrf=missing", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown vrf, got %d", w.Code)
+	}
+
+	leak := map[string]any{"from_vrf": "tenant", "to_vrf": "default", "destination": "8.8.8.0/24"}
+	if w := doJSON(t, router, http.MethodPost, "/api/vrfs/leaks", leak); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 adding leak, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodPost, "/api/vrfs/leaks", leak); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for duplicate leak, got %d", w.Code)
+	}
+	w = doJSON(t, router, http.MethodGet, "/api/vrfs", nil)
+	var resp struct {
+		VRFs []struct {
+			Name   string `json:"name"`
+			Routes int    `json:"routes"`
+		} `json:"vrfs"`
+		Leaks []map[string]any `json:"leaks"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode vrfs: %v", err)
+	}
+	if len(resp.VRFs) != 2 || resp.VRFs[1].Name != "tenant" || resp.VRFs[1].Routes != 2 || len(resp.Leaks) != 1 {
+		t.Fatalf("unexpected vrf listing: %s", w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/vrfs/leaks", leak); w.Code != http.StatusOK {
+		t.Fatalf("expected 200 removing leak, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/vrfs/leaks", leak); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 removing missing leak, got %d", w.Code)
+	}
+}
+
+func TestGetRouteTracking(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	router := setupRouter(h)
+	if w := doJSON(t, router, http.MethodGet, "/api/routes/tracking", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without tracker, got %d", w.Code)
+	}
+
+	_, defNet, _ := net.ParseCIDR("0.0.0.0/0")
+	h.RouteTracker = routing.NewTracker(h.Routes)
+	route := routing.Route{Destination: *defNet, Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Metric: 10}
+	if err := h.RouteTracker.Add(route, routing.TrackProbe{Type: "tcp", Target: ":443"}); err != nil {
+		t.Fatalf("add track: %v", err)
+	}
+	w := doJSON(t, router, http.MethodGet, "/api/routes/tracking", nil)
+	var resp []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode tracking: %v", err)
+	}
+	if len(resp) != 1 || resp[0]["target"] != "192.0.2.1:443" || resp[0]["up"] != true || resp[0]["type"] != "tcp" {
+		t.Fatalf("unexpected tracking response: %s", w.Body.String())
+	}
+}
+
+func TestBGPEndpoints(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	router := setupRouter(h)
+	if w := doJSON(t, router, http.MethodGet, "/api/bgp/neighbors", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without bgp, got %d", w.Code)
+	}
+
+	speaker, err := bgp.NewSpeaker(bgp.Config{
+		ASN:       65001,
+		RouterID:  net.ParseIP("192.0.2.1"),
+		Neighbors: []bgp.NeighborConfig{{Address: net.ParseIP("192.0.2.2"), RemoteASN: 65002, Description: "upstream", MaxPrefixes: 100}},
+	}, h.Routes)
+	if err != nil {
+		t.Fatalf("new speaker: %v", err)
+	}
+	h.BGP = speaker
+	w := doJSON(t, router, http.MethodGet, "/api/bgp/neighbors", nil)
+	var neighbors []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &neighbors); err != nil {
+		t.Fatalf("decode neighbors: %v", err)
+	}
+	if len(neighbors) != 1 || neighbors[0]["state"] != "idle" || neighbors[0]["remote_asn"] != float64(65002) || neighbors[0]["max_prefixes"] != float64(100) {
+		t.Fatalf("unexpected neighbors: %s", w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/bgp/rib", nil); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Fatalf("expected empty rib, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestOSPFEndpoints(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	router := setupRouter(h)
+	for _, path := range []string{"/api/ospf/interfaces", "/api/ospf/neighbors", "/api/ospf/lsdb"} {
+		if w := doJSON(t, router, http.MethodGet, path, nil); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 for %s without ospf, got %d", path, w.Code)
+		}
+	}
+
+	ospfRouter, err := ospf.NewRouter(ospf.Config{
+		RouterID: net.ParseIP("192.0.2.1"),
+		Interfaces: []ospf.InterfaceConfig{{
+			Name:     "lan",
+			Address:  net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)},
+			Priority: 1,
+		}},
+	}, h.Routes)
+	if err != nil {
+		t.Fatalf("new ospf router: %v", err)
+	}
+	h.OSPF = ospfRouter
+	w := doJSON(t, router, http.MethodGet, "/api/ospf/interfaces", nil)
+	var ifaces []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &ifaces); err != nil {
+		t.Fatalf("decode interfaces: %v", err)
+	}
+	if len(ifaces) != 1 || ifaces[0]["address"] != "10.0.0.1/24" || ifaces[0]["network_type"] != "broadcast" || ifaces[0]["cost"] != float64(10) {
+		t.Fatalf("unexpected interfaces: %s", w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/ospf/neighbors", nil); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Fatalf("expected no neighbors, got %d %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/ospf/lsdb", nil); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Fatalf("expected empty lsdb, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestRIPEndpoints(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	router := setupRouter(h)
+	for _, path := range []string{"/api/rip/routes", "/api/rip/neighbors"} {
+		if w := doJSON(t, router, http.MethodGet, path, nil); w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503 for %s without rip, got %d", path, w.Code)
+		}
+	}
+
+	ripRouter, err := rip.NewRouter(rip.Config{
+		Interfaces: []rip.InterfaceConfig{{
+			Name:    "lan",
+			Address: net.IPNet{IP: net.ParseIP("10.0.0.1"), Mask: net.CIDRMask(24, 32)},
+		}},
+		Networks: []net.IPNet{{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(48, 128)}},
+	}, h.Routes)
+	if err != nil {
+		t.Fatalf("new rip router: %v", err)
+	}
+	h.RIP = ripRouter
+	w := doJSON(t, router, http.MethodGet, "/api/rip/routes", nil)
+	var routes []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil {
+		t.Fatalf("decode routes: %v", err)
+	}
+	if len(routes) != 2 || routes[0]["prefix"] != "10.0.0.0/24" || routes[0]["local"] != true || routes[0]["metric"] != float64(1) || routes[1]["family"] != "ipv6" {
+		t.Fatalf("unexpected rip routes: %s", w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/rip/neighbors", nil); w.Code != http.StatusOK || w.Body.String() != "[]" {
+		t.Fatalf("expected no neighbors, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestBFDSessionsEndpoint(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	router := setupRouter(h)
+	if w := doJSON(t, router, http.MethodGet, "/api/bfd/sessions", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without bfd, got %d", w.Code)
+	}
+
+	manager := bfd.NewManager(bfd.Config{})
+	if err := manager.Add(bfd.SessionConfig{Peer: net.ParseIP("192.0.2.1"), Interface: "wan1", DesiredMinTx: 50 * time.Millisecond}, nil); err != nil {
+		t.Fatalf("add session: %v", err)
+	}
+	h.BFD = manager
+	w := doJSON(t, router, http.MethodGet, "/api/bfd/sessions", nil)
+	var sessions []map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &sessions); err != nil {
+		t.Fatalf("decode sessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0]["peer"] != "192.0.2.1" || sessions[0]["state"] != "down" || sessions[0]["min_tx_ms"] != float64(50) || sessions[0]["multiplier"] != float64(3) {
+		t.Fatalf("unexpected sessions: %s", w.Body.String())
+	}
+}
+
+func TestGetRoutesShowsRIBCandidates(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.20.0.0/24")
+	h := newRoutingPolicyHandlers()
+	h.Routes.Add(routing.Route{Destination: *dst, Interface: "eth1", Source: routing.SourceConnected})
+	router := setupRouter(h)
+
+	w := doJSON(t, router, http.MethodPost, "/api/routes", map[string]any{"destination": "10.20.0.0/24", "gateway": "192.0.2.1"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodPost, "/api/routes", map[string]any{"destination": "10.20.0.0/24", "distance": 300}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid distance, got %d", w.Code)
+	}
+
+	w = doJSON(t, router, http.MethodGet, "/api/routes", nil)
+	var resp []struct {
+		Source   string `json:"source"`
+		Distance int    `json:"distance"`
+		Selected bool   `json:"selected"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp) != 2 || resp[0].Source != routing.SourceConnected || !resp[0].Selected {
+		t.Fatalf("expected selected connected route first, got %s", w.Body.String())
+	}
+	if resp[1].Source != routing.SourceAPI || resp[1].Distance != 1 || resp[1].Selected {
+		t.Fatalf("unexpected api candidate: %s", w.Body.String())
+	}
+
+	if w := doJSON(t, router, http.MethodDelete, "/api/routes", map[string]any{"destination": "10.20.0.0/24", "interface": "eth1"}); w.Code != http.StatusNotFound {
+		t.Fatalf("expected connected route to be protected, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/routes", map[string]any{"destination": "10.20.0.0/24", "gateway": "192.0.2.1"}); w.Code != http.StatusOK {
+		t.Fatalf("expected api route to be deleted, got %d", w.Code)
+	}
+	if got := len(h.Routes.Routes()); got != 1 {
+		t.Fatalf("expected 1 route left, got %d", got)
+	}
+}
+
+func TestRouteFilterEndpoints(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	h.RouteFilters = routing.NewRouteFilters()
+	router := setupRouter(h)
+
+	w := doJSON(t, router, http.MethodPut, "/api/routing/prefix-lists/bogons", map[string]any{
+		"entries": []map[string]any{{"seq": 10, "prefix": "0.0.0.0/0"}, {"seq": 20, "prefix": "10.0.0.0/8", "le": 32}},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodPut, "/api/routing/prefix-lists/bad", map[string]any{
+		"entries": []map[string]any{{"prefix": "10.0.0.0/8", "ge": 4}},
+	}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid ge, got %d", w.Code)
+	}
+	w = doJSON(t, router, http.MethodPut, "/api/routing/route-maps/p2p-in", map[string]any{
+		"entries": []map[string]any{
+			{"seq": 10, "action": "deny", "match_prefix_list": "bogons"},
+			{"seq": 20, "set_metric": 40},
+		},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := doJSON(t, router, http.MethodPut, "/api/routing/route-maps/other", map[string]any{
+		"entries": []map[string]any{{"seq": 10, "match_prefix_list": "missing"}},
+	}); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown prefix list, got %d", w.Code)
+	}
+
+	w = doJSON(t, router, http.MethodGet, "/api/routing/route-maps", nil)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"match_prefix_list":"bogons"`)) {
+		t.Fatalf("unexpected route maps: %d %s", w.Code, w.Body.String())
+	}
+
+	w = doJSON(t, router, http.MethodPost, "/api/routing/route-maps/p2p-in/test", map[string]any{"destination": "10.1.0.0/16", "source": "p2p"})
+	var res struct {
+		Action string                 `json:"action"`
+		Seq    int                    `json:"seq"`
+		Trace  []routing.RouteMapStep `json:"trace"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if res.Action != "deny" || res.Seq != 10 || len(res.Trace) != 1 || !res.Trace[0].Matched {
+		t.Fatalf("unexpected trace: %s", w.Body.String())
+	}
+	w = doJSON(t, router, http.MethodPost, "/api/routing/route-maps/p2p-in/test", map[string]any{"destination": "192.0.2.0/24"})
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"action":"permit"`)) || !bytes.Contains(w.Body.Bytes(), []byte(`"metric":40`)) {
+		t.Fatalf("expected permit with metric 40: %s", w.Body.String())
+	}
+
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/prefix-lists/bogons", nil); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for prefix list in use, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/route-maps/p2p-in", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodDelete, "/api/routing/prefix-lists/bogons", nil); w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if w := doJSON(t, router, http.MethodGet, "/api/p2p/routes/filtered", nil); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without p2p, got %d", w.Code)
+	}
+}
+
+func readSSE(t *testing.T, r *bufio.Reader) (string, string, string) {
+	t.Helper()
+	var id, event, data string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if event != "" {
+				return id, event, data
+			}
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestWatchRoutesStreamsEvents(t *testing.T) {
+	h := newRoutingPolicyHandlers()
+	srv := httptest.NewServer(setupRouter(h))
+	defer srv.Close()
+	_, dst, _ := net.ParseCIDR("10.30.0.0/24")
+	h.Routes.Add(routing.Route{Destination: *dst, Interface: "eth0", Source: routing.SourceConnected})
+
+	resp, err := http.Get(srv.URL + "/api/routes/watch")
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	stream := bufio.NewReader(resp.Body)
+	if id, event, _ := readSSE(t, stream); id != "1" || event != "sync" {
+		t.Fatalf("expected sync at revision 1, got %s %s", id, event)
+	}
+	if w := doJSON(t, setupRouter(h), http.MethodPost, "/api/routes", map[string]any{"destination": "10.31.0.0/24", "gateway": "10.30.0.1"}); w.Code != http.StatusOK {
+		t.Fatalf("add route: %d", w.Code)
+	}
+	id, event, data := readSSE(t, stream)
+	var ev struct {
+		Revision uint64 `json:"revision"`
+		Source   string `json:"source"`
+		Route    struct {
+			Destination string `json:"destination"`
+		} `json:"route"`
+	}
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if id != "2" || event != "add" || ev.Revision != 2 || ev.Source != routing.SourceAPI || ev.Route.Destination != "10.31.0.0/24" {
+		t.Fatalf("unexpected event %s %s %s", id, event, data)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/routes/watch", nil)
+	req.Header.Set("Last-Event-ID", "0")
+	resumed, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	defer resumed.Body.Close()
+	stream = bufio.NewReader(resumed.Body)
+	readSSE(t, stream)
+	if id, event, _ := readSSE(t, stream); id != "1" || event != "add" {
+		t.Fatalf("expected replay from revision 1, got %s %s", id, event)
+	}
+
+	if w := doJSON(t, setupRouter(h), http.MethodGet, "/api/routes/watch?since=bad", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", w.Code)
+	}
+	if w := doJSON(t, setupRouter(h), http.MethodGet, "/api/routes/watch?since=1000", nil); w.Code != http.StatusGone {
+		t.Fatalf("expected 410 for a revision ahead of the table, got %d", w.Code)
+	}
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"router-go/pkg/conntrack"
+	"router-go/pkg/firewall"
+	"router-go/pkg/nat"
+	"router-go/pkg/network"
+)
+
+// placementRequest is the position part of rule insert and move requests:
+// before or after a rule ID, or a 1-based position. At most one may be set;
+// none appends.
+type placementRequest struct {
+	Before   uint64 `json:"before"`
+	After    uint64 `json:"after"`
+	Position int    `json:"position"`
+}
+
+func (p placementRequest) valid() bool {
+	set := 0
+	for _, ok := range []bool{p.Before != 0, p.After != 0, p.Position != 0} {
+		if ok {
+			set++
+		}
+	}
+	return set <= 1 && p.Position >= 0
+}
+
+func ruleIDParam(c *gin.Context) (uint64, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil || id == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// bindPlacement reads a move request, which must name a new place.
+func bindPlacement(c *gin.Context) (placementRequest, bool) {
+	var req placementRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return req, false
+	}
+	if !req.valid() || req == (placementRequest{}) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return req, false
+	}
+	return req, true
+}
+
+func (h *Handlers) MoveFirewallRule(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	id, ok := ruleIDParam(c)
+	if !ok {
+		return
+	}
+	req, ok := bindPlacement(c)
+	if !ok {
+		return
+	}
+	if _, _, found := engine.RuleByID(id); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	if !engine.MoveRule(id, firewall.Placement(req)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return
+	}
+	_, position, _ := engine.RuleByID(id)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id, "position": position})
+}
+
+func (h *Handlers) EnableFirewallRule(c *gin.Context) {
+	h.setFirewallRuleEnabled(c, true)
+}
+
+func (h *Handlers) DisableFirewallRule(c *gin.Context) {
+	h.setFirewallRuleEnabled(c, false)
+}
+
+func (h *Handlers) setFirewallRuleEnabled(c *gin.Context, enabled bool) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	id, ok := ruleIDParam(c)
+	if !ok {
+		return
+	}
+	if enabled {
+		if rule, _, ok := engine.RuleByID(id); ok && h.nftablesRejects(c, engine, geoMatch{rule.SrcCountry, rule.DstCountry, rule.SrcASN}, false) {
+			return
+		}
+	}
+	if !engine.SetRuleEnabled(id, enabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id, "enabled": enabled})
+}
+
+// AnalyzeFirewall reports shadowed, redundant, conflicting and unreachable
+// rules; with since (RFC 3339) also the rules without hits since then.
+func (h *Handlers) AnalyzeFirewall(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	var since time.Time
+	if value := strings.TrimSpace(c.Query("since")); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid since"})
+			return
+		}
+		since = parsed
+	}
+	type findingView struct {
+		Kind     string `json:"kind"`
+		Chain    string `json:"chain,omitempty"`
+		Rule     uint64 `json:"rule"`
+		Position int    `json:"position"`
+		Related  uint64 `json:"related,omitempty"`
+		Detail   string `json:"detail"`
+	}
+	findings := engine.Analyze(since)
+	out := make([]findingView, 0, len(findings))
+	for _, f := range findings {
+		out = append(out, findingView{
+			Kind:     string(f.Kind),
+			Chain:    f.Chain,
+			Rule:     f.Rule,
+			Position: f.Position,
+			Related:  f.Related,
+			Detail:   f.Detail,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": len(engine.Rules()), "findings": out})
+}
+
+// TraceFirewall evaluates a described packet without counting it and
+// returns the verdict with the rules it matched on the way, including the
+// JUMP, GOTO and RETURN steps through user chains.
+func (h *Handlers) TraceFirewall(c *gin.Context) {
+	engine, ok := h.firewallFor(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Chain        string `json:"chain"`
+		Protocol     string `json:"protocol"`
+		SrcIP        string `json:"src_ip"`
+		DstIP        string `json:"dst_ip"`
+		SrcPort      int    `json:"src_port"`
+		DstPort      int    `json:"dst_port"`
+		InInterface  string `json:"in_interface"`
+		OutInterface string `json:"out_interface"`
+		CTState      string `json:"ct_state"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return
+	}
+	if !firewall.IsBuiltinChain(req.Chain) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chain must be INPUT, OUTPUT or FORWARD"})
+		return
+	}
+	srcIP, dstIP := net.ParseIP(req.SrcIP), net.ParseIP(req.DstIP)
+	if srcIP == nil || dstIP == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid src_ip or dst_ip"})
+		return
+	}
+	ctState, err := conntrack.ParseStates(req.CTState)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid ct_state"})
+		return
+	}
+	if ctState == 0 {
+		ctState = network.CTNew
+	}
+	verdict, steps := engine.Trace(req.Chain, network.Packet{
+		IngressInterface: req.InInterface,
+		EgressInterface:  req.OutInterface,
+		CTState:          ctState,
+		Metadata: network.PacketMetadata{
+			Protocol: strings.ToUpper(req.Protocol),
+			SrcIP:    srcIP,
+			DstIP:    dstIP,
+			SrcPort:  req.SrcPort,
+			DstPort:  req.DstPort,
+		},
+	})
+	type stepView struct {
+		Chain    string `json:"chain"`
+		Rule     uint64 `json:"rule,omitempty"`
+		Position int    `json:"position,omitempty"`
+		Action   string `json:"action"`
+		Target   string `json:"target,omitempty"`
+	}
+	out := make([]stepView, 0, len(steps))
+	for _, step := range steps {
+		out = append(out, stepView{
+			Chain:    step.Chain,
+			Rule:     step.Rule,
+			Position: step.Position,
+			Action:   string(step.Action),
+			Target:   step.Target,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"verdict": verdict, "trace": out})
+}
+
+func (h *Handlers) MoveNATRule(c *gin.Context) {
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
+	id, ok := ruleIDParam(c)
+	if !ok {
+		return
+	}
+	req, ok := bindPlacement(c)
+	if !ok {
+		return
+	}
+	if _, _, found := table.RuleByID(id); !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	if !table.MoveRule(id, nat.Placement(req)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid placement"})
+		return
+	}
+	_, position, _ := table.RuleByID(id)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id, "position": position})
+}
+
+func (h *Handlers) EnableNATRule(c *gin.Context) {
+	h.setNATRuleEnabled(c, true)
+}
+
+func (h *Handlers) DisableNATRule(c *gin.Context) {
+	h.setNATRuleEnabled(c, false)
+}
+
+func (h *Handlers) setNATRuleEnabled(c *gin.Context, enabled bool) {
+	table, ok := h.natFor(c)
+	if !ok {
+		return
+	}
+	id, ok := ruleIDParam(c)
+	if !ok {
+		return
+	}
+	if !table.SetRuleEnabled(id, enabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "id": id, "enabled": enabled})
+}
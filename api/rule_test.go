@@ -0,0 +1,285 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"router-go/internal/metrics"
+	"router-go/pkg/firewall"
+	"router-go/pkg/nat"
+	"router-go/pkg/network"
+	"router-go/pkg/nftables"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFirewallRuleIDsAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := firewall.NewEngineWithDefaults([]firewall.Rule{
+		{ID: 1, Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+		{ID: 2, Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]firewall.Action{"INPUT": firewall.ActionDrop})
+	table := nat.NewTable([]nat.Rule{{ID: 4, Type: nat.TypeDNAT, DstPort: 2222, ToIP: net.ParseIP("192.168.1.50")}})
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, NAT: table, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/firewall", `{"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":23,"description":"telnet","before":2}`)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"id":3`)) {
+		t.Fatalf("expected insert with id 3, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{
+		`{"chain":"INPUT","action":"DROP","before":1,"after":2}`,
+		`{"chain":"INPUT","action":"DROP","after":99}`,
+		`{"chain":"INPUT","action":"DROP","position":-1}`,
+	} {
+		if w := do(http.MethodPost, "/api/firewall", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	if w := do(http.MethodPost, "/api/firewall/rules/1/move", `{"position":3}`); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"position":3`)) {
+		t.Fatalf("expected move to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/api/firewall/rules/1/move", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for move without placement, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/firewall/rules/9/disable", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown rule, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/firewall/rules/2/disable", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected disable to succeed, got %d", w.Code)
+	}
+
+	// Rules 1 and 2 are equal apart from their IDs; delete by ID picks one.
+	if w := do(http.MethodDelete, "/api/firewall", `{"id":1}`); w.Code != http.StatusOK {
+		t.Fatalf("expected delete by id, got %d: %s", w.Code, w.Body.String())
+	}
+	var rules []struct {
+		ID          uint64 `json:"id"`
+		Position    int    `json:"position"`
+		Description string `json:"description"`
+		Disabled    bool   `json:"disabled"`
+	}
+	w = do(http.MethodGet, "/api/firewall", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+		t.Fatalf("decode rules: %v", err)
+	}
+	if len(rules) != 2 || rules[0].ID != 3 || rules[0].Description != "telnet" || rules[1].ID != 2 || rules[1].Position != 2 || !rules[1].Disabled {
+		t.Fatalf("unexpected rules: %+v", rules)
+	}
+
+	w = do(http.MethodPut, "/api/firewall", `{"id":3,"chain":"INPUT","action":"REJECT","protocol":"TCP","dst_port":23}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected update by id, got %d: %s", w.Code, w.Body.String())
+	}
+	if rule, _, _ := engine.RuleByID(3); rule.Action != firewall.ActionReject {
+		t.Fatalf("unexpected rule after update: %+v", rule)
+	}
+
+	w = do(http.MethodPost, "/api/nat", `{"type":"DNAT","dst_port":8080,"to_ip":"192.168.1.60","position":1}`)
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"id":5`)) {
+		t.Fatalf("expected nat insert with id 5, got %d: %s", w.Code, w.Body.String())
+	}
+	for _, body := range []string{`{"type":"dnat","dst_port":8081}`, `{"type":"DNAT","dst_port":8081,"to_ip":"bad"}`} {
+		if w := do(http.MethodPost, "/api/nat", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	if w := do(http.MethodPut, "/api/nat", `{"id":5,"type":"DNAT","dst_port":8080}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected update without a DNAT target to be rejected, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/nat/rules/4/move", `{"before":5}`); w.Code != http.StatusOK {
+		t.Fatalf("expected nat move, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/api/nat/rules/5/disable", ""); w.Code != http.StatusOK {
+		t.Fatalf("expected nat disable, got %d", w.Code)
+	}
+	if rules := table.Rules(); rules[0].ID != 4 || rules[1].ID != 5 || !rules[1].Disabled {
+		t.Fatalf("unexpected nat rules: %+v", rules)
+	}
+}
+
+func TestAnalyzeFirewallAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := firewall.NewEngineWithDefaults([]firewall.Rule{
+		{ID: 1, Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP"},
+		{ID: 2, Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]firewall.Action{"INPUT": firewall.ActionDrop})
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	var out struct {
+		Rules    int `json:"rules"`
+		Findings []struct {
+			Kind    string `json:"kind"`
+			Rule    uint64 `json:"rule"`
+			Related uint64 `json:"related"`
+		} `json:"findings"`
+	}
+	w := get("/api/firewall/analyze")
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.Rules != 2 || len(out.Findings) != 1 || out.Findings[0].Kind != "shadowed" || out.Findings[0].Rule != 2 || out.Findings[0].Related != 1 {
+		t.Fatalf("unexpected analysis: %s", w.Body.String())
+	}
+	w = get("/api/firewall/analyze?since=2020-01-01T00:00:00Z")
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(out.Findings) != 3 {
+		t.Fatalf("expected both rules to be unused as well: %s", w.Body.String())
+	}
+	if w := get("/api/firewall/analyze?since=yesterday"); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for invalid since, got %d", w.Code)
+	}
+}
+
+func TestFirewallChainsAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := firewall.NewEngineWithDefaults([]firewall.Rule{
+		{ID: 1, Chain: "guest-policy", Action: firewall.ActionAccept, Protocol: "UDP", DstPort: 53},
+		{ID: 2, Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]firewall.Action{"INPUT": firewall.ActionDrop})
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"chain":"INPUT","action":"JUMP"}`,
+		`{"chain":"INPUT","action":"JUMP","target":"nowhere"}`,
+		`{"chain":"INPUT","action":"GOTO","target":"FORWARD"}`,
+		`{"chain":"guest-policy","action":"JUMP","target":"guest-policy"}`,
+	} {
+		if w := do(http.MethodPost, "/api/firewall", body); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", body, w.Code)
+		}
+	}
+	w := do(http.MethodPost, "/api/firewall", `{"chain":"INPUT","action":"JUMP","target":"guest-policy","in_interface":"eth0.20","position":1}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected jump to be added, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodPost, "/api/firewall/trace", `{"chain":"input","protocol":"udp","src_ip":"10.20.0.5","dst_ip":"10.20.0.1","src_port":5353,"dst_port":53,"in_interface":"eth0.20"}`)
+	var out struct {
+		Verdict string `json:"verdict"`
+		Trace   []struct {
+			Chain  string `json:"chain"`
+			Rule   uint64 `json:"rule"`
+			Action string `json:"action"`
+			Target string `json:"target"`
+		} `json:"trace"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.Verdict != "ACCEPT" || len(out.Trace) != 2 || out.Trace[0].Action != "JUMP" || out.Trace[0].Target != "GUEST-POLICY" ||
+		out.Trace[1].Chain != "GUEST-POLICY" || out.Trace[1].Rule != 1 {
+		t.Fatalf("unexpected trace: %s", w.Body.String())
+	}
+	if w := do(http.MethodPost, "/api/firewall/trace", `{"chain":"guest-policy","src_ip":"10.20.0.5","dst_ip":"10.20.0.1"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for a user chain, got %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/api/firewall", ""); !bytes.Contains(w.Body.Bytes(), []byte(`"target":"guest-policy"`)) {
+		t.Fatalf("expected target in rule view: %s", w.Body.String())
+	}
+}
+
+func TestFirewallLimitedRuleByFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := firewall.NewEngine([]firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 22, ConnLimit: 4, LimitPrefix: 24},
+	})
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(http.MethodPut, "/api/firewall", `{"old_chain":"INPUT","old_action":"DROP","old_protocol":"TCP","old_dst_port":22,"old_conn_limit":4,"old_limit_prefix":24,`+
+		`"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22,"rate_limit":10,"rate_burst":20}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected update by fields, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodDelete, "/api/firewall", `{"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22}`); w.Code != http.StatusNotFound {
+		t.Fatalf("expected limits to be part of the match, got %d", w.Code)
+	}
+	w = do(http.MethodDelete, "/api/firewall", `{"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22,"rate_limit":10,"rate_burst":20}`)
+	if w.Code != http.StatusOK || len(engine.Rules()) != 0 {
+		t.Fatalf("expected delete by fields, got %d: %s", w.Code, w.Body.String())
+	}
+
+	engine.AddRule(firewall.Rule{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: network.CountryMatch{Codes: []string{"CN"}}})
+	w = do(http.MethodPut, "/api/firewall", `{"old_chain":"INPUT","old_action":"DROP","old_protocol":"TCP","old_dst_port":22,"old_src_country":"cn",`+
+		`"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22,"src_country":"CN,RU","src_asn":"AS64500"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected update of geo rule by fields, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/api/firewall", `{"old_src_country":"CHN"}`); w.Code != http.StatusBadRequest || !bytes.Contains(w.Body.Bytes(), []byte("old_src_country")) {
+		t.Fatalf("expected invalid old_src_country, got %d: %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodDelete, "/api/firewall", `{"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22,"src_country":"cn,ru","src_asn":"64500"}`)
+	if w.Code != http.StatusOK || len(engine.Rules()) != 0 {
+		t.Fatalf("expected delete of geo rule by fields, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestFirewallGeoRulesWithNFTables(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := firewall.NewEngine([]firewall.Rule{
+		{ID: 1, Chain: "INPUT", Action: firewall.ActionDrop, Disabled: true, SrcASN: network.ASNMatch{Numbers: []uint32{64500}}},
+	})
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{
+		Firewall: engine,
+		NFTables: nftables.NewBackend(nftables.Config{}, engine, nil, nil),
+		Metrics:  metrics.NewWithRegistry(prometheus.NewRegistry()),
+	})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPost, "/api/firewall", `{"chain":"INPUT","action":"DROP","protocol":"TCP","dst_port":22,"src_country":"CN"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected country rule to be rejected with nftables, got %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/firewall", `{"chain":"INPUT","action":"DROP","disabled":true,"src_country":"CN"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected disabled country rule to be accepted, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPost, "/api/firewall/rules/1/enable", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected enabling an ASN rule to be rejected, got %d", w.Code)
+	}
+	if rule, _, _ := engine.RuleByID(1); !rule.Disabled {
+		t.Fatalf("expected rule to stay disabled")
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type scheduleView struct {
+	Name   string   `json:"name"`
+	Days   []string `json:"days,omitempty"`
+	Times  []string `json:"times,omitempty"`
+	Active bool     `json:"active"`
+}
+
+// GetSchedules lists the schedules with the timezone they are evaluated in
+// and whether each is active now.
+func (h *Handlers) GetSchedules(c *gin.Context) {
+	if h.Schedules == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "schedules disabled"})
+		return
+	}
+	schedules := h.Schedules.List()
+	out := make([]scheduleView, 0, len(schedules))
+	for _, s := range schedules {
+		view := scheduleView{Name: s.Name, Active: h.Schedules.Active(s.Name)}
+		for _, day := range s.Days {
+			view.Days = append(view.Days, dayName(day))
+		}
+		for _, r := range s.Ranges {
+			view.Times = append(view.Times, r.String())
+		}
+		out = append(out, view)
+	}
+	c.JSON(http.StatusOK, gin.H{"timezone": h.Schedules.Location().String(), "schedules": out})
+}
+
+func dayName(day time.Weekday) string {
+	return strings.ToLower(day.String()[:3])
+}
+
+// scheduleDefined reports whether name is empty or names a known schedule.
+func (h *Handlers) scheduleDefined(name string) bool {
+	return name == "" || h.Schedules.Has(name)
+}
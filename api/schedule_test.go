@@ -0,0 +1,69 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"router-go/internal/metrics"
+	"router-go/pkg/firewall"
+	"router-go/pkg/schedule"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestScheduledFirewallRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	evening, _ := schedule.ParseRange("18:00-22:00")
+	weekend, _ := schedule.ParseDays([]string{"weekend"})
+	schedules := schedule.NewRegistry([]schedule.Schedule{
+		{Name: "evening", Ranges: []schedule.Range{evening}},
+		{Name: "weekend", Days: weekend},
+	}, time.UTC)
+	// 2026-10-19 is a Monday.
+	schedules.SetClock(func() time.Time { return time.Date(2026, 10, 19, 19, 0, 0, 0, time.UTC) })
+	engine := firewall.NewEngineWithDefaults(nil, map[string]firewall.Action{"FORWARD": firewall.ActionAccept})
+	engine.SetSchedules(schedules)
+	router := gin.New()
+	RegisterRoutes(router, &Handlers{Firewall: engine, Schedules: schedules, Metrics: metrics.NewWithRegistry(prometheus.NewRegistry())})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, body := range []string{
+		`{"chain":"FORWARD","action":"DROP","in_interface":"wlan1","schedule":"evening"}`,
+		`{"chain":"FORWARD","action":"DROP","in_interface":"wlan2","schedule":"weekend"}`,
+	} {
+		if w := do(http.MethodPost, "/api/firewall", body); w.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+	}
+	if w := do(http.MethodPost, "/api/firewall", `{"chain":"FORWARD","action":"DROP","schedule":"missing"}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown schedule, got %d", w.Code)
+	}
+	w := do(http.MethodGet, "/api/firewall", "")
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"schedule":"evening","active":true`)) || !bytes.Contains(w.Body.Bytes(), []byte(`"schedule":"weekend","active":false`)) {
+		t.Fatalf("expected schedule activity in rules: %s", w.Body.String())
+	}
+
+	w = do(http.MethodGet, "/api/schedules", "")
+	if w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte(`"timezone":"UTC"`)) ||
+		!bytes.Contains(w.Body.Bytes(), []byte(`{"name":"weekend","days":["sun","sat"],"active":false}`)) {
+		t.Fatalf("unexpected schedules response %d: %s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodPut, "/api/firewall", `{"old_chain":"FORWARD","old_action":"DROP","old_in_interface":"wlan1","old_schedule":"evening","chain":"FORWARD","action":"REJECT","in_interface":"wlan1","schedule":"weekend"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected update by schedule identity, got %d: %s", w.Code, w.Body.String())
+	}
+	if rules := engine.Rules(); rules[0].Schedule != "weekend" || rules[0].Action != firewall.ActionReject {
+		t.Fatalf("unexpected rules after update: %+v", rules)
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"router-go/pkg/vrf"
+
+	"github.com/gin-gonic/gin"
+)
+
+type routeLeakView struct {
+	FromVRF     string `json:"from_vrf"`
+	ToVRF       string `json:"to_vrf"`
+	Destination string `json:"destination"`
+	Metric      int    `json:"metric"`
+}
+
+func (h *Handlers) GetVRFs(c *gin.Context) {
+	if h.VRFs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "vrf disabled"})
+		return
+	}
+	type vrfView struct {
+		Name       string   `json:"name"`
+		Interfaces []string `json:"interfaces"`
+		Routes     int      `json:"routes"`
+		Tables     []string `json:"tables"`
+	}
+	names := h.VRFs.Names()
+	out := make([]vrfView, 0, len(names))
+	for _, name := range names {
+		inst, ok := h.VRFs.Get(name)
+		if !ok {
+			continue
+		}
+		interfaces := append([]string{}, inst.Interfaces...)
+		out = append(out, vrfView{
+			Name:       inst.Name,
+			Interfaces: interfaces,
+			Routes:     len(inst.Routes.Routes()),
+			Tables:     inst.Policy.TableNames(),
+		})
+	}
+	leaks := h.VRFs.Leaks()
+	leakViews := make([]routeLeakView, 0, len(leaks))
+	for _, leak := range leaks {
+		leakViews = append(leakViews, routeLeakView{
+			FromVRF:     leak.From,
+			ToVRF:       leak.To,
+			Destination: leak.Destination.String(),
+			Metric:      leak.Metric,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"vrfs": out, "leaks": leakViews})
+}
+
+func (h *Handlers) AddRouteLeak(c *gin.Context) {
+	if h.VRFs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "vrf disabled"})
+		return
+	}
+	leak, ok := bindRouteLeak(c)
+	if !ok {
+		return
+	}
+	if err := h.VRFs.AddLeak(leak); err != nil {
+		c.JSON(routeLeakErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *Handlers) DeleteRouteLeak(c *gin.Context) {
+	if h.VRFs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "vrf disabled"})
+		return
+	}
+	leak, ok := bindRouteLeak(c)
+	if !ok {
+		return
+	}
+	if err := h.VRFs.RemoveLeak(leak); err != nil {
+		c.JSON(routeLeakErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func bindRouteLeak(c *gin.Context) (vrf.Leak, bool) {
+	var req routeLeakView
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		return vrf.Leak{}, false
+	}
+	_, dst, err := net.ParseCIDR(strings.TrimSpace(req.Destination))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid destination"})
+		return vrf.Leak{}, false
+	}
+	return vrf.Leak{
+		From:        strings.TrimSpace(req.FromVRF),
+		To:          strings.TrimSpace(req.ToVRF),
+		Destination: *dst,
+		Metric:      req.Metric,
+	}, true
+}
+
+func routeLeakErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, vrf.ErrNotFound), errors.Is(err, vrf.ErrLeakNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, vrf.ErrLeakExists):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
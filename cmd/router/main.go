@@ -23,18 +23,27 @@ import (
 	"router-go/internal/observability"
 	"router-go/internal/platform"
 	"router-go/internal/presets"
+	"router-go/pkg/bfd"
+	"router-go/pkg/bgp"
+	"router-go/pkg/conntrack"
 	"router-go/pkg/enrich"
 	"router-go/pkg/firewall"
 	"router-go/pkg/flow"
 	"router-go/pkg/ha"
 	"router-go/pkg/ids"
 	"router-go/pkg/integrations/logs"
+	"router-go/pkg/ipset"
 	"router-go/pkg/nat"
 	"router-go/pkg/network"
+	"router-go/pkg/nftables"
+	"router-go/pkg/ospf"
 	"router-go/pkg/p2p"
 	"router-go/pkg/proxy"
 	"router-go/pkg/qos"
+	"router-go/pkg/rip"
 	"router-go/pkg/routing"
+	"router-go/pkg/schedule"
+	"router-go/pkg/vrf"
 
 	"github.com/gin-gonic/gin"
 )
@@ -71,19 +80,37 @@ func main() {
 	metrics.StartRemoteWrite(ctx, cfg.Integrations.Metrics, metricsSrv)
 
 	routeTable := buildRoutes(cfg, log)
+	routePolicy := buildPolicy(cfg, log, routeTable)
+	ipSets := buildIPSets(ctx, cfg, log)
+	schedules := buildSchedules(cfg, log)
 	firewallEngine := buildFirewall(cfg, log)
 	idsEngine := buildIDS(cfg)
 	natTable := buildNAT(cfg, log)
+	conntrackTable := buildConntrack(ctx, cfg)
+	vrfs := buildVRFs(cfg, log, routeTable, routePolicy, firewallEngine, natTable, ipSets, schedules)
+	attachConnCounts(conntrackTable, vrfs)
+	attachGeo(buildGeo(cfg, log), vrfs, idsEngine)
+	startRouteMonitor(ctx, cfg, log, routePolicy, vrfs)
+	routeTracker := buildRouteTracker(cfg, log, routeTable)
+	bgpSpeaker := buildBGP(ctx, cfg, log, routeTable)
+	ospfRouter := buildOSPF(ctx, cfg, log, routeTable)
+	ripRouter := buildRIP(ctx, cfg, log, routeTable)
 	qosQueue := buildQoSQueue(cfg)
+	attachIPSets(ipSets, firewallEngine, natTable, idsEngine, qosQueue)
+	attachSchedules(schedules, firewallEngine, natTable, qosQueue)
+	nftBackend := buildNFTables(ctx, cfg, log, firewallEngine, natTable, ipSets, schedules)
 	cfgManager := config.NewManagerWithStore(cfg, config.DefaultHealthCheck, cfg.System.StateStorePath)
+	cfgManager.SetAnalyzer(func(next *config.Config) []string { return analyzeFirewallConfig(next, log) })
 	if err := cfgManager.LoadPersisted(); err != nil {
 		log.Warn("config state load failed", map[string]any{"err": err.Error(), "path": cfg.System.StateStorePath})
 	}
 	flowEngine := flow.NewEngine()
-	p2pEngine := buildP2P(cfg, routeTable, metricsSrv, log, ctx)
+	routeFilters := buildRouteFilters(cfg, log)
+	p2pEngine := buildP2P(cfg, routeTable, routePolicy, routeFilters, metricsSrv, log, ctx)
 	proxyEngine := buildProxy(cfg, metricsSrv, log, ctx)
 	enrichSvc := buildEnrichService(cfg, log)
-	haMgr := buildHA(cfg, log, routeTable, firewallEngine, natTable, qosQueue)
+	haMgr := buildHA(cfg, log, routeTable, routePolicy, firewallEngine, natTable, qosQueue)
+	bfdMgr := buildBFD(ctx, cfg, log, routeTracker, bgpSpeaker, haMgr)
 	obsStore := buildObservability(cfg, log)
 	alertStore := startAlerting(ctx, cfg, metricsSrv, log)
 	presetStore := loadPresets(cfg, log)
@@ -95,9 +122,20 @@ func main() {
 	}
 	handlers := &api.Handlers{
 		Routes:        routeTable,
+		RoutePolicy:   routePolicy,
+		RouteFilters:  routeFilters,
+		VRFs:          vrfs,
+		RouteTracker:  routeTracker,
+		BGP:           bgpSpeaker,
+		OSPF:          ospfRouter,
+		RIP:           ripRouter,
+		BFD:           bfdMgr,
 		Firewall:      firewallEngine,
 		IDS:           idsEngine,
 		NAT:           natTable,
+		Conntrack:     conntrackTable,
+		IPSets:        ipSets,
+		Schedules:     schedules,
 		QoS:           qosQueue,
 		Flow:          flowEngine,
 		P2P:           p2pEngine,
@@ -112,8 +150,10 @@ func main() {
 		Observability: obsStore,
 		Alerts:        alertStore,
 		Presets:       presetStore,
+		NFTables:      nftBackend,
 	}
 	api.RegisterRoutes(router, handlers)
+	startRouteTracker(ctx, routeTracker, log, alertStore, handlers)
 	if cfg.Observability.PprofEnabled {
 		api.RegisterPprof(router, cfg.Observability.PprofPath)
 	}
@@ -131,29 +171,29 @@ func main() {
 		}()
 	}
 
-	startPacketLoop(ctx, cfg, log, metricsSrv, routeTable, firewallEngine, idsEngine, natTable, qosQueue, flowEngine)
+	startPacketLoop(ctx, cfg, log, &dataplane{
+		routes:    routeTable,
+		policy:    routePolicy,
+		vrfs:      vrfs,
+		firewall:  firewallEngine,
+		ids:       idsEngine,
+		nat:       natTable,
+		conntrack: conntrackTable,
+		qos:       qosQueue,
+		metrics:   metricsSrv,
+		flow:      flowEngine,
+	})
 	<-ctx.Done()
 	log.Info("shutdown", nil)
 }
 
-func startPacketLoop(
-	ctx context.Context,
-	cfg *config.Config,
-	log *logger.Logger,
-	metricsSrv *metrics.Metrics,
-	routes *routing.Table,
-	firewallEngine *firewall.Engine,
-	idsEngine *ids.Engine,
-	natTable *nat.Table,
-	qosQueue *qos.QueueManager,
-	flowEngine *flow.Engine,
-) {
+func startPacketLoop(ctx context.Context, cfg *config.Config, log *logger.Logger, dp *dataplane) {
 	if len(cfg.Interfaces) == 0 {
 		log.Warn("no interfaces configured", nil)
 		return
 	}
 
-	localIPs := buildLocalIPs(cfg)
+	dp.localIPs = buildLocalIPs(cfg)
 	writers := make(map[string]network.PacketIO, len(cfg.Interfaces))
 	var defaultWriter network.PacketIO
 	for _, iface := range cfg.Interfaces {
@@ -177,29 +217,18 @@ func startPacketLoop(
 
 	batchSize := cfg.Performance.EgressBatchSize
 	idleSleep := time.Duration(cfg.Performance.EgressIdleSleepMillis) * time.Millisecond
-	go runEgressLoop(ctx, defaultWriter, writers, qosQueue, metricsSrv, batchSize, idleSleep)
+	go runEgressLoop(ctx, defaultWriter, writers, dp.qos, dp.metrics, batchSize, idleSleep)
 	for _, iface := range cfg.Interfaces {
 		io, ok := writers[iface.Name]
 		if !ok {
 			continue
 		}
-		go runIngressLoop(ctx, io, iface.Name, localIPs, routes, firewallEngine, idsEngine, natTable, qosQueue, metricsSrv, flowEngine)
+		go runIngressLoop(ctx, io, iface.Name, dp)
 	}
 }
 
-func runIngressLoop(
-	ctx context.Context,
-	io network.PacketIO,
-	interfaceName string,
-	localIPs []net.IP,
-	routes *routing.Table,
-	firewallEngine *firewall.Engine,
-	idsEngine *ids.Engine,
-	natTable *nat.Table,
-	qosQueue *qos.QueueManager,
-	metricsSrv *metrics.Metrics,
-	flowEngine *flow.Engine,
-) {
+func runIngressLoop(ctx context.Context, io network.PacketIO, interfaceName string, dp *dataplane) {
+	metricsSrv := dp.metrics
 	defer io.Close()
 	for {
 		select {
@@ -232,31 +261,54 @@ func runIngressLoop(
 
 		metricsSrv.IncPackets()
 		metricsSrv.AddBytes(len(pkt.Data))
-		handlePacket(pkt, localIPs, routes, firewallEngine, idsEngine, natTable, qosQueue, metricsSrv, flowEngine)
+		handlePacket(pkt, dp)
 	}
 }
 
-func processPacket(
-	pkt network.Packet,
-	localIPs []net.IP,
-	routes *routing.Table,
-	firewallEngine *firewall.Engine,
-	idsEngine *ids.Engine,
-	natTable *nat.Table,
-	qosQueue *qos.QueueManager,
-	metricsSrv *metrics.Metrics,
-	flowEngine *flow.Engine,
-) {
-	if routes != nil {
-		if route, ok := routes.Lookup(pkt.Metadata.DstIP); ok && route.Interface != "" {
-			pkt.EgressInterface = route.Interface
+// dataplane holds the engines a packet passes through. Any of them may be
+// nil except firewall and nat; with vrfs set, the ingress VRF's own local
+// addresses, firewall and NAT table replace localIPs, firewall and nat.
+type dataplane struct {
+	localIPs  []net.IP
+	routes    *routing.Table
+	policy    *routing.Policy
+	vrfs      *vrf.Manager
+	firewall  *firewall.Engine
+	ids       *ids.Engine
+	nat       *nat.Table
+	conntrack *conntrack.Table
+	qos       *qos.QueueManager
+	metrics   *metrics.Metrics
+	flow      *flow.Engine
+}
+
+func processPacket(pkt network.Packet, dp *dataplane) {
+	zone := ""
+	localIPs, firewallEngine, natTable := dp.localIPs, dp.firewall, dp.nat
+	if dp.vrfs != nil {
+		inst := dp.vrfs.ForInterface(pkt.IngressInterface)
+		zone = inst.Name
+		localIPs = inst.LocalIPs
+		firewallEngine = inst.Firewall
+		natTable = inst.NAT
+		if _, hop, _, ok := dp.vrfs.LookupFlow(inst, pkt); ok && hop.Interface != "" {
+			pkt.EgressInterface = hop.Interface
+		}
+	} else if dp.policy != nil {
+		if _, hop, _, ok := dp.policy.LookupFlow(pkt); ok && hop.Interface != "" {
+			pkt.EgressInterface = hop.Interface
+		}
+	} else if dp.routes != nil {
+		if _, hop, ok := dp.routes.LookupFlow(pkt.Metadata); ok && hop.Interface != "" {
+			pkt.EgressInterface = hop.Interface
 		}
 	}
-	if flowEngine != nil {
-		flowEngine.AddPacket(pkt)
+	if dp.flow != nil {
+		dp.flow.AddPacket(pkt)
 	}
-	if idsEngine != nil {
-		res := idsEngine.Detect(pkt)
+	metricsSrv := dp.metrics
+	if dp.ids != nil {
+		res := dp.ids.Detect(pkt)
 		if res.Alert != nil {
 			metricsSrv.IncIDSAlert()
 			metricsSrv.IncIDSAlertType(res.Alert.Type)
@@ -268,16 +320,24 @@ func processPacket(
 			return
 		}
 	}
+	ct := dp.conntrack
+	if ct != nil {
+		pkt.CTState = ct.Track(zone, pkt)
+	}
+	original := pkt
 	pkt = natTable.Apply(pkt)
 	chain := determineChain(pkt, localIPs)
 	if firewallEngine.Evaluate(chain, pkt) != firewall.ActionAccept {
 		metricsSrv.IncDropReason("firewall")
 		return
 	}
-	if qosQueue == nil {
+	if ct != nil {
+		ct.Confirm(zone, original, pkt)
+	}
+	if dp.qos == nil {
 		return
 	}
-	ok, dropped, className := qosQueue.Enqueue(pkt)
+	ok, dropped, className := dp.qos.Enqueue(pkt)
 	if dropped {
 		metricsSrv.IncQoSDrop(className)
 	}
@@ -286,18 +346,8 @@ func processPacket(
 	}
 }
 
-func handlePacket(
-	pkt network.Packet,
-	localIPs []net.IP,
-	routes *routing.Table,
-	firewallEngine *firewall.Engine,
-	idsEngine *ids.Engine,
-	natTable *nat.Table,
-	qosQueue *qos.QueueManager,
-	metricsSrv *metrics.Metrics,
-	flowEngine *flow.Engine,
-) {
-	processPacket(pkt, localIPs, routes, firewallEngine, idsEngine, natTable, qosQueue, metricsSrv, flowEngine)
+func handlePacket(pkt network.Packet, dp *dataplane) {
+	processPacket(pkt, dp)
 	if pkt.Release != nil {
 		pkt.Release()
 	}
@@ -375,111 +425,887 @@ func dequeueAndWriteBatchWithResolver(
 }
 
 func buildRoutes(cfg *config.Config, log *logger.Logger) *routing.Table {
-	table := routing.NewTable(nil)
-	for _, rc := range cfg.Routes {
+	return routing.NewTable(append(buildConnectedRoutes(cfg, vrf.Default), buildRouteList(cfg.Routes, log)...))
+}
+
+func buildConnectedRoutes(cfg *config.Config, name string) []routing.Route {
+	var out []routing.Route
+	for _, iface := range cfg.Interfaces {
+		if interfaceVRF(iface) != name {
+			continue
+		}
+		_, dst, err := net.ParseCIDR(iface.IP)
+		if err != nil {
+			continue
+		}
+		out = append(out, routing.Route{
+			Destination: *dst,
+			Interface:   iface.Name,
+			Distance:    routing.DefaultDistance(routing.SourceConnected),
+			Source:      routing.SourceConnected,
+		})
+	}
+	return out
+}
+
+func buildRouteList(routes []config.RouteConfig, log *logger.Logger) []routing.Route {
+	var out []routing.Route
+	for _, rc := range routes {
 		_, dst, err := net.ParseCIDR(rc.Destination)
 		if err != nil {
 			log.Warn("invalid route destination", map[string]any{"destination": rc.Destination})
 			continue
 		}
 		gw := net.ParseIP(rc.Gateway)
-		table.Add(routing.Route{
+		var hops []routing.NextHop
+		for _, hc := range rc.NextHops {
+			hops = append(hops, routing.NextHop{
+				Gateway:   net.ParseIP(hc.Gateway),
+				Interface: hc.Interface,
+				Weight:    hc.Weight,
+				Probe:     hc.Probe,
+			})
+		}
+		distance := rc.Distance
+		if distance == 0 {
+			distance = routing.DefaultDistance(routing.SourceStatic)
+		}
+		out = append(out, routing.Route{
 			Destination: *dst,
 			Gateway:     gw,
 			Interface:   rc.Interface,
 			Metric:      rc.Metric,
+			Distance:    distance,
+			Source:      routing.SourceStatic,
+			NextHops:    hops,
 		})
 	}
-	return table
+	return out
 }
 
-func buildFirewall(cfg *config.Config, log *logger.Logger) *firewall.Engine {
-	var rules []firewall.Rule
-	for _, rc := range cfg.Firewall {
-		var srcNet *net.IPNet
+func buildRouteFilters(cfg *config.Config, log *logger.Logger) *routing.RouteFilters {
+	filters := routing.NewRouteFilters()
+	for _, lc := range cfg.Routing.PrefixLists {
+		list := routing.PrefixList{Name: lc.Name}
+		for _, ec := range lc.Entries {
+			_, prefix, err := net.ParseCIDR(ec.Prefix)
+			if err != nil {
+				log.Warn("invalid prefix list entry", map[string]any{"prefix_list": lc.Name, "prefix": ec.Prefix})
+				continue
+			}
+			list.Entries = append(list.Entries, routing.PrefixListEntry{
+				Seq:    ec.Seq,
+				Action: ec.Action,
+				Prefix: *prefix,
+				GE:     ec.GE,
+				LE:     ec.LE,
+			})
+		}
+		if err := filters.SetPrefixList(list); err != nil {
+			log.Warn("invalid prefix list", map[string]any{"prefix_list": lc.Name, "err": err.Error()})
+		}
+	}
+	for _, mc := range cfg.Routing.RouteMaps {
+		m := routing.RouteMap{Name: mc.Name}
+		for _, ec := range mc.Entries {
+			m.Entries = append(m.Entries, routing.RouteMapEntry{
+				Seq:             ec.Seq,
+				Action:          ec.Action,
+				MatchPrefixList: ec.MatchPrefixList,
+				MatchSource:     ec.MatchSource,
+				MatchMetric:     ec.MatchMetric,
+				SetMetric:       ec.SetMetric,
+				SetTag:          ec.SetTag,
+			})
+		}
+		if err := filters.SetRouteMap(m); err != nil {
+			log.Warn("invalid route map", map[string]any{"route_map": mc.Name, "err": err.Error()})
+		}
+	}
+	return filters
+}
+
+func buildPolicy(cfg *config.Config, log *logger.Logger, main *routing.Table) *routing.Policy {
+	policy := routing.NewPolicy(main)
+	for _, tc := range cfg.Routing.Tables {
+		if _, err := policy.AddTable(tc.Name, buildRouteList(tc.Routes, log)); err != nil {
+			log.Warn("invalid routing table", map[string]any{"table": tc.Name, "err": err.Error()})
+		}
+	}
+	var rules []routing.PolicyRule
+	for _, rc := range cfg.Routing.Rules {
+		rule := routing.PolicyRule{
+			Priority:    rc.Priority,
+			InInterface: rc.InInterface,
+			Protocol:    rc.Protocol,
+			SrcPort:     rc.SrcPort,
+			DstPort:     rc.DstPort,
+			Mark:        rc.Mark,
+			MarkMask:    rc.MarkMask,
+			Table:       rc.Table,
+		}
 		if rc.SrcIP != "" {
 			_, parsed, err := net.ParseCIDR(rc.SrcIP)
 			if err != nil {
-				log.Warn("invalid firewall src_ip", map[string]any{"src_ip": rc.SrcIP})
+				log.Warn("invalid routing rule src_ip", map[string]any{"src_ip": rc.SrcIP})
 				continue
 			}
-			srcNet = parsed
+			rule.SrcNet = parsed
 		}
-
-		var dstNet *net.IPNet
 		if rc.DstIP != "" {
 			_, parsed, err := net.ParseCIDR(rc.DstIP)
 			if err != nil {
-				log.Warn("invalid firewall dst_ip", map[string]any{"dst_ip": rc.DstIP})
+				log.Warn("invalid routing rule dst_ip", map[string]any{"dst_ip": rc.DstIP})
+				continue
+			}
+			rule.DstNet = parsed
+		}
+		if rc.DSCP != nil {
+			rule.DSCP = uint8(*rc.DSCP)
+			rule.MatchDSCP = true
+		}
+		rules = append(rules, rule)
+	}
+	policy.ReplaceRules(rules)
+	return policy
+}
+
+func startRouteMonitor(ctx context.Context, cfg *config.Config, log *logger.Logger, policy *routing.Policy, vrfs *vrf.Manager) {
+	monitor := routing.NewMonitor(
+		policy.Main(),
+		time.Duration(cfg.Routing.MonitorIntervalSeconds)*time.Second,
+		time.Duration(cfg.Routing.ProbeTimeoutSeconds)*time.Second,
+	)
+	monitor.SetRoutes(vrfs.AllRoutes)
+	monitor.SetThresholds(cfg.Routing.ProbeFall, cfg.Routing.ProbeRise)
+	monitor.SetOnChange(func(target string, up bool) {
+		if up {
+			log.Info("route next hop up", map[string]any{"target": target})
+			return
+		}
+		log.Warn("route next hop down", map[string]any{"target": target})
+	})
+	go monitor.Run(ctx)
+}
+
+func buildBGP(ctx context.Context, cfg *config.Config, log *logger.Logger, table *routing.Table) *bgp.Speaker {
+	bc := cfg.Routing.BGP
+	if !bc.Enabled {
+		return nil
+	}
+	speakerCfg := bgp.Config{
+		ASN:           bc.ASN,
+		RouterID:      net.ParseIP(cfg.Routing.RouterID),
+		ListenAddress: bc.ListenAddress,
+		HoldTime:      time.Duration(bc.HoldTimeSeconds) * time.Second,
+		ConnectRetry:  time.Duration(bc.ConnectRetrySeconds) * time.Second,
+		ExportStatic:  bc.ExportStatic,
+	}
+	for _, network := range bc.Networks {
+		_, prefix, err := net.ParseCIDR(network)
+		if err != nil {
+			log.Warn("invalid bgp network", map[string]any{"network": network})
+			continue
+		}
+		speakerCfg.Networks = append(speakerCfg.Networks, *prefix)
+	}
+	for _, nc := range bc.Neighbors {
+		speakerCfg.Neighbors = append(speakerCfg.Neighbors, bgp.NeighborConfig{
+			Address:     net.ParseIP(nc.Address),
+			Port:        nc.Port,
+			RemoteASN:   nc.RemoteASN,
+			Description: nc.Description,
+			Passive:     nc.Passive,
+			HoldTime:    time.Duration(nc.HoldTimeSeconds) * time.Second,
+			MaxPrefixes: nc.MaxPrefixes,
+			Import:      buildBGPPolicy(nc.Import, log),
+			Export:      buildBGPPolicy(nc.Export, log),
+		})
+	}
+	speaker, err := bgp.NewSpeaker(speakerCfg, table)
+	if err != nil {
+		log.Warn("bgp disabled", map[string]any{"err": err.Error()})
+		return nil
+	}
+	speaker.SetOnStateChange(func(neighbor string, state string, reason string) {
+		fields := map[string]any{"neighbor": neighbor, "state": state}
+		if reason != "" {
+			fields["reason"] = reason
+		}
+		log.Info("bgp neighbor state", fields)
+	})
+	if err := speaker.Start(ctx); err != nil {
+		log.Error("bgp start failed", map[string]any{"err": err.Error()})
+		return nil
+	}
+	return speaker
+}
+
+func buildOSPF(ctx context.Context, cfg *config.Config, log *logger.Logger, table *routing.Table) *ospf.Router {
+	oc := cfg.Routing.OSPF
+	if !oc.Enabled {
+		return nil
+	}
+	addresses := map[string]string{}
+	for _, iface := range cfg.Interfaces {
+		addresses[iface.Name] = iface.IP
+	}
+	routerCfg := ospf.Config{
+		RouterID: net.ParseIP(cfg.Routing.RouterID),
+		Area:     net.ParseIP(oc.Area),
+	}
+	for _, ic := range oc.Interfaces {
+		ip, prefix, err := net.ParseCIDR(addresses[ic.Name])
+		if err != nil {
+			log.Warn("ospf interface without address", map[string]any{"interface": ic.Name})
+			continue
+		}
+		priority := 1
+		if ic.Priority != nil {
+			priority = *ic.Priority
+		}
+		routerCfg.Interfaces = append(routerCfg.Interfaces, ospf.InterfaceConfig{
+			Name:               ic.Name,
+			Address:            net.IPNet{IP: ip, Mask: prefix.Mask},
+			Network:            ic.NetworkType,
+			Cost:               ic.Cost,
+			Priority:           priority,
+			HelloInterval:      time.Duration(ic.HelloIntervalSeconds) * time.Second,
+			DeadInterval:       time.Duration(ic.DeadIntervalSeconds) * time.Second,
+			RetransmitInterval: time.Duration(ic.RetransmitIntervalSeconds) * time.Second,
+			Passive:            ic.Passive,
+		})
+	}
+	router, err := ospf.NewRouter(routerCfg, table)
+	if err != nil {
+		log.Warn("ospf disabled", map[string]any{"err": err.Error()})
+		return nil
+	}
+	router.SetOnNeighborChange(func(iface string, neighbor string, state string) {
+		log.Info("ospf neighbor state", map[string]any{"interface": iface, "neighbor": neighbor, "state": state})
+	})
+	if err := router.Start(ctx); err != nil {
+		log.Error("ospf start failed", map[string]any{"err": err.Error()})
+		return nil
+	}
+	return router
+}
+
+func buildRIP(ctx context.Context, cfg *config.Config, log *logger.Logger, table *routing.Table) *rip.Router {
+	rc := cfg.Routing.RIP
+	if !rc.Enabled {
+		return nil
+	}
+	addresses := map[string]string{}
+	for _, iface := range cfg.Interfaces {
+		addresses[iface.Name] = iface.IP
+	}
+	routerCfg := rip.Config{
+		UpdateInterval:    time.Duration(rc.UpdateIntervalSeconds) * time.Second,
+		Timeout:           time.Duration(rc.TimeoutSeconds) * time.Second,
+		GarbageCollection: time.Duration(rc.GarbageSeconds) * time.Second,
+	}
+	for _, network := range rc.Networks {
+		if _, prefix, err := net.ParseCIDR(network); err == nil {
+			routerCfg.Networks = append(routerCfg.Networks, *prefix)
+		}
+	}
+	for _, ic := range rc.Interfaces {
+		families := []string{rip.FamilyIPv4}
+		switch ic.Version {
+		case "ng":
+			families = []string{rip.FamilyIPv6}
+		case "both":
+			families = []string{rip.FamilyIPv4, rip.FamilyIPv6}
+		}
+		for _, family := range families {
+			ifaceCfg := rip.InterfaceConfig{
+				Name:         ic.Name,
+				Family:       family,
+				Cost:         ic.Cost,
+				SplitHorizon: ic.SplitHorizon,
+				Passive:      ic.Passive,
+			}
+			if ip, prefix, err := net.ParseCIDR(addresses[ic.Name]); err == nil && (ip.To4() != nil) == (family == rip.FamilyIPv4) {
+				ifaceCfg.Address = net.IPNet{IP: ip, Mask: prefix.Mask}
+			}
+			if family == rip.FamilyIPv4 {
+				ifaceCfg.AuthKey = ic.AuthKey
+				ifaceCfg.AuthKeyID = uint8(ic.AuthKeyID)
+			}
+			routerCfg.Interfaces = append(routerCfg.Interfaces, ifaceCfg)
+		}
+	}
+	router, err := rip.NewRouter(routerCfg, table)
+	if err != nil {
+		log.Warn("rip disabled", map[string]any{"err": err.Error()})
+		return nil
+	}
+	if err := router.Start(ctx); err != nil {
+		log.Error("rip start failed", map[string]any{"err": err.Error()})
+		return nil
+	}
+	return router
+}
+
+func buildBFD(ctx context.Context, cfg *config.Config, log *logger.Logger, tracker *routing.Tracker, speaker *bgp.Speaker, haMgr *ha.Manager) *bfd.Manager {
+	manager := bfd.NewManager(bfd.Config{})
+	sessions := 0
+	add := func(bc config.BFDConfig, peer net.IP, iface string, apply func(peer net.IP, up bool, diag string)) {
+		err := manager.Add(bfd.SessionConfig{
+			Peer:          peer,
+			Interface:     iface,
+			DesiredMinTx:  time.Duration(bc.MinTxMillis) * time.Millisecond,
+			RequiredMinRx: time.Duration(bc.MinRxMillis) * time.Millisecond,
+			DetectMult:    bc.Multiplier,
+		}, func(ev bfd.Event) {
+			fields := map[string]any{"peer": ev.Peer, "state": ev.State, "previous": ev.Previous}
+			if ev.Diag != "" {
+				fields["diag"] = ev.Diag
+			}
+			up := ev.State == bfd.StateUp
+			if !up && ev.Previous != bfd.StateUp {
+				return
+			}
+			if up {
+				log.Info("bfd session state", fields)
+			} else {
+				log.Warn("bfd session state", fields)
+			}
+			apply(net.ParseIP(ev.Peer), up, ev.Diag)
+		})
+		if err != nil {
+			log.Warn("invalid bfd session", map[string]any{"peer": peer.String(), "err": err.Error()})
+			return
+		}
+		sessions++
+	}
+	if tracker != nil {
+		for _, rc := range cfg.Routes {
+			if rc.BFD == nil {
+				continue
+			}
+			routes := buildRouteList([]config.RouteConfig{rc}, log)
+			if len(routes) == 0 {
 				continue
 			}
-			dstNet = parsed
+			peer, iface := net.ParseIP(rc.BFD.Peer), rc.BFD.Interface
+			for _, hop := range routes[0].Paths() {
+				if peer == nil && hop.Gateway != nil {
+					peer = hop.Gateway
+				}
+				if iface == "" {
+					iface = hop.Interface
+				}
+			}
+			if peer == nil {
+				continue
+			}
+			add(*rc.BFD, peer, iface, func(peer net.IP, up bool, diag string) {
+				tracker.SetBFDState(peer.String(), up, diag)
+			})
+		}
+	}
+	if speaker != nil {
+		for _, nc := range cfg.Routing.BGP.Neighbors {
+			if nc.BFD == nil {
+				continue
+			}
+			add(*nc.BFD, net.ParseIP(nc.Address), nc.BFD.Interface, func(peer net.IP, up bool, diag string) {
+				speaker.SetBFDState(peer, up, diag)
+			})
+		}
+	}
+	if haMgr != nil {
+		for _, bc := range cfg.HA.BFD {
+			add(bc, net.ParseIP(bc.Peer), bc.Interface, func(peer net.IP, up bool, diag string) {
+				haMgr.SetBFDState(peer, up)
+			})
+		}
+	}
+	if sessions == 0 {
+		return nil
+	}
+	if err := manager.Start(ctx); err != nil {
+		log.Error("bfd start failed", map[string]any{"err": err.Error()})
+		return nil
+	}
+	return manager
+}
+
+func buildBGPPolicy(pc config.BGPPolicyConfig, log *logger.Logger) bgp.Policy {
+	policy := bgp.Policy{DefaultAction: strings.ToLower(pc.DefaultAction)}
+	for _, tc := range pc.Terms {
+		_, prefix, err := net.ParseCIDR(tc.Prefix)
+		if err != nil {
+			log.Warn("invalid bgp policy prefix", map[string]any{"prefix": tc.Prefix})
+			continue
+		}
+		policy.Terms = append(policy.Terms, bgp.PolicyTerm{
+			Prefix:    *prefix,
+			GE:        tc.GE,
+			LE:        tc.LE,
+			Action:    strings.ToLower(tc.Action),
+			LocalPref: tc.LocalPref,
+			MED:       tc.MED,
+			Prepend:   tc.Prepend,
+		})
+	}
+	return policy
+}
+
+func buildRouteTracker(cfg *config.Config, log *logger.Logger, table *routing.Table) *routing.Tracker {
+	tracker := routing.NewTracker(table)
+	for _, rc := range cfg.Routes {
+		if rc.Track == nil && rc.BFD == nil {
+			continue
+		}
+		routes := buildRouteList([]config.RouteConfig{rc}, log)
+		if len(routes) == 0 {
+			continue
+		}
+		probe := routing.TrackProbe{Type: routing.TrackBFD}
+		if rc.BFD != nil {
+			probe.Target = rc.BFD.Peer
+		} else {
+			probe = routing.TrackProbe{
+				Type:          rc.Track.Type,
+				Target:        rc.Track.Target,
+				Interval:      time.Duration(rc.Track.IntervalSeconds) * time.Second,
+				Timeout:       time.Duration(rc.Track.TimeoutSeconds) * time.Second,
+				FailThreshold: rc.Track.FailThreshold,
+				RiseThreshold: rc.Track.RiseThreshold,
+			}
+		}
+		err := tracker.Add(routes[0], probe)
+		if err != nil {
+			log.Warn("invalid route track", map[string]any{"destination": rc.Destination, "err": err.Error()})
+		}
+	}
+	if tracker.Len() == 0 {
+		return nil
+	}
+	return tracker
+}
+
+func startRouteTracker(ctx context.Context, tracker *routing.Tracker, log *logger.Logger, alerts *observability.AlertStore, handlers *api.Handlers) {
+	if tracker == nil {
+		return
+	}
+	tracker.SetOnChange(func(ev routing.TrackEvent) {
+		state, event := "up", "route.track.up"
+		if !ev.Up {
+			state, event = "down", "route.track.down"
+		}
+		details := map[string]any{
+			"destination": ev.Route.Destination.String(),
+			"metric":      ev.Route.Metric,
+			"type":        ev.Type,
+			"target":      ev.Target,
+		}
+		if ev.Route.Gateway != nil {
+			details["gateway"] = ev.Route.Gateway.String()
+		}
+		if ev.Err != "" {
+			details["error"] = ev.Err
+		}
+		if ev.Up {
+			log.Info("tracked route restored", details)
+		} else {
+			log.Warn("tracked route withdrawn", details)
+		}
+		if alerts != nil {
+			alerts.Add(observability.NewAlert(observability.AlertRoute, "route "+ev.Route.Destination.String()+" via "+ev.Target+" is "+state))
+		}
+		handlers.EmitEvent(event, details)
+	})
+	go tracker.Run(ctx)
+}
+
+func buildFirewall(cfg *config.Config, log *logger.Logger) *firewall.Engine {
+	engine := buildFirewallEngine(cfg.Firewall, cfg.FirewallDefaults, log)
+	engine.SetZones(buildFirewallZones(cfg.FirewallZones, cfg.ZonePolicies))
+	engine.SetLogSink(firewallLogSink(log, ""))
+	return engine
+}
+
+// analyzeFirewallConfig runs the firewall analysis over the rule lists of a
+// config, so plans warn about dead and conflicting rules.
+func analyzeFirewallConfig(cfg *config.Config, log *logger.Logger) []string {
+	var warnings []string
+	analyze := func(prefix string, rules []config.FirewallRuleConfig, defaults config.FirewallDefaultsConfig, zones []config.FirewallZoneConfig, policies []config.ZonePolicyConfig) {
+		engine := buildFirewallEngine(rules, defaults, log)
+		engine.SetZones(buildFirewallZones(zones, policies))
+		for _, finding := range engine.Analyze(time.Time{}) {
+			warnings = append(warnings, prefix+finding.String())
+		}
+	}
+	analyze("", cfg.Firewall, cfg.FirewallDefaults, cfg.FirewallZones, cfg.ZonePolicies)
+	for _, vc := range cfg.VRFs {
+		analyze("vrf "+vc.Name+": ", vc.Firewall, vc.FirewallDefaults, vc.FirewallZones, vc.ZonePolicies)
+	}
+	return warnings
+}
+
+// firewallLogSink writes firewall log records through the application logger,
+// so they reach the configured Loki and Elasticsearch hooks as well.
+func firewallLogSink(log *logger.Logger, vrf string) func(firewall.LogRecord) {
+	return func(record firewall.LogRecord) {
+		fields := map[string]any{
+			"rule":          record.Rule,
+			"chain":         record.Chain,
+			"verdict":       string(record.Verdict),
+			"protocol":      record.Protocol,
+			"src_ip":        record.SrcIP.String(),
+			"dst_ip":        record.DstIP.String(),
+			"src_port":      record.SrcPort,
+			"dst_port":      record.DstPort,
+			"in_interface":  record.InInterface,
+			"out_interface": record.OutInterface,
+		}
+		if record.Suppressed > 0 {
+			fields["suppressed"] = record.Suppressed
+		}
+		if vrf != "" {
+			fields["vrf"] = vrf
+		}
+		log.Info("firewall packet", fields)
+	}
+}
+
+func buildFirewallZones(zoneConfigs []config.FirewallZoneConfig, policyConfigs []config.ZonePolicyConfig) ([]firewall.Zone, []firewall.ZonePolicy) {
+	zones := make([]firewall.Zone, 0, len(zoneConfigs))
+	for _, zc := range zoneConfigs {
+		zones = append(zones, firewall.Zone{
+			Name:       zc.Name,
+			Interfaces: zc.Interfaces,
+			Input:      parseFirewallAction(zc.Input, ""),
+			Output:     parseFirewallAction(zc.Output, ""),
+			Forward:    parseFirewallAction(zc.Forward, ""),
+		})
+	}
+	policies := make([]firewall.ZonePolicy, 0, len(policyConfigs))
+	for _, pc := range policyConfigs {
+		policies = append(policies, firewall.ZonePolicy{
+			From:   pc.From,
+			To:     pc.To,
+			Action: parseFirewallAction(pc.Action, firewall.ActionDrop),
+		})
+	}
+	return zones, policies
+}
+
+func buildFirewallEngine(ruleConfigs []config.FirewallRuleConfig, defaultsConfig config.FirewallDefaultsConfig, log *logger.Logger) *firewall.Engine {
+	var rules []firewall.Rule
+	for _, rc := range ruleConfigs {
+		srcAddrs, err := network.ParseAddrMatch(rc.SrcIP)
+		if err != nil {
+			log.Warn("invalid firewall src_ip", map[string]any{"src_ip": rc.SrcIP})
+			continue
+		}
+		dstAddrs, err := network.ParseAddrMatch(rc.DstIP)
+		if err != nil {
+			log.Warn("invalid firewall dst_ip", map[string]any{"dst_ip": rc.DstIP})
+			continue
+		}
+		srcPorts, err := network.ParsePortMatch(rc.SrcPorts)
+		if err != nil {
+			log.Warn("invalid firewall src_ports", map[string]any{"src_ports": rc.SrcPorts})
+			continue
+		}
+		dstPorts, err := network.ParsePortMatch(rc.DstPorts)
+		if err != nil {
+			log.Warn("invalid firewall dst_ports", map[string]any{"dst_ports": rc.DstPorts})
+			continue
+		}
+		srcCountry, err := network.ParseCountryMatch(rc.SrcCountry)
+		if err != nil {
+			log.Warn("invalid firewall src_country", map[string]any{"src_country": rc.SrcCountry})
+			continue
+		}
+		dstCountry, err := network.ParseCountryMatch(rc.DstCountry)
+		if err != nil {
+			log.Warn("invalid firewall dst_country", map[string]any{"dst_country": rc.DstCountry})
+			continue
+		}
+		srcASN, err := network.ParseASNMatch(rc.SrcASN)
+		if err != nil {
+			log.Warn("invalid firewall src_asn", map[string]any{"src_asn": rc.SrcASN})
+			continue
+		}
+
+		ctState, err := conntrack.ParseStates(rc.CTState)
+		if err != nil {
+			log.Warn("invalid firewall ct_state", map[string]any{"ct_state": rc.CTState})
+			continue
 		}
 
 		rules = append(rules, firewall.Rule{
+			ID:           rc.ID,
+			Description:  rc.Description,
+			Disabled:     rc.Disabled,
 			Chain:        rc.Chain,
 			Action:       firewall.Action(rc.Action),
+			Target:       rc.Target,
 			Protocol:     rc.Protocol,
-			SrcNet:       srcNet,
-			DstNet:       dstNet,
+			SrcAddrs:     srcAddrs,
+			DstAddrs:     dstAddrs,
 			SrcPort:      rc.SrcPort,
 			DstPort:      rc.DstPort,
+			SrcPorts:     srcPorts,
+			DstPorts:     dstPorts,
+			SrcSet:       rc.SrcSet,
+			DstSet:       rc.DstSet,
 			InInterface:  rc.InInterface,
 			OutInterface: rc.OutInterface,
+			FromZone:     rc.From,
+			ToZone:       rc.To,
+			CTState:      ctState,
+			Schedule:     rc.Schedule,
+			Log:          rc.Log,
+			LogRate:      rc.LogRate,
+			RateLimit:    rc.RateLimit,
+			RateBurst:    rc.RateBurst,
+			ConnLimit:    rc.ConnLimit,
+			LimitPrefix:  rc.LimitPrefix,
+			SrcCountry:   srcCountry,
+			DstCountry:   dstCountry,
+			SrcASN:       srcASN,
 		})
 	}
 	defaults := map[string]firewall.Action{
-		"INPUT":   parseFirewallAction(cfg.FirewallDefaults.Input, firewall.ActionDrop),
-		"OUTPUT":  parseFirewallAction(cfg.FirewallDefaults.Output, firewall.ActionDrop),
-		"FORWARD": parseFirewallAction(cfg.FirewallDefaults.Forward, firewall.ActionDrop),
+		"INPUT":   parseFirewallAction(defaultsConfig.Input, firewall.ActionDrop),
+		"OUTPUT":  parseFirewallAction(defaultsConfig.Output, firewall.ActionDrop),
+		"FORWARD": parseFirewallAction(defaultsConfig.Forward, firewall.ActionDrop),
 	}
 	return firewall.NewEngineWithDefaults(rules, defaults)
 }
 
-func buildNAT(cfg *config.Config, log *logger.Logger) *nat.Table {
-	var rules []nat.Rule
-	for _, rc := range cfg.NAT {
-		var srcNet *net.IPNet
-		if rc.SrcIP != "" {
-			_, parsed, err := net.ParseCIDR(rc.SrcIP)
+func buildIPSets(ctx context.Context, cfg *config.Config, log *logger.Logger) *ipset.Registry {
+	sets := ipset.NewRegistry()
+	for _, sc := range cfg.IPSets {
+		typ, err := ipset.ParseType(sc.Type)
+		if err != nil {
+			log.Warn("invalid ip set type", map[string]any{"set": sc.Name, "type": sc.Type})
+			continue
+		}
+		if err := sets.Create(sc.Name, typ, time.Duration(sc.TimeoutSeconds)*time.Second); err != nil {
+			log.Warn("invalid ip set", map[string]any{"set": sc.Name, "err": err.Error()})
+			continue
+		}
+		entries := make([]ipset.Entry, 0, len(sc.Entries))
+		for _, value := range sc.Entries {
+			prefix, err := ipset.ParseMember(typ, value)
 			if err != nil {
-				log.Warn("invalid nat src_ip", map[string]any{"src_ip": rc.SrcIP})
+				log.Warn("invalid ip set entry", map[string]any{"set": sc.Name, "entry": value})
 				continue
 			}
-			srcNet = parsed
+			entries = append(entries, ipset.Entry{Prefix: prefix})
+		}
+		if err := sets.Replace(sc.Name, entries); err != nil {
+			log.Warn("invalid ip set entries", map[string]any{"set": sc.Name, "err": err.Error()})
 		}
+	}
+	sets.Start(ctx)
+	return sets
+}
 
-		var dstNet *net.IPNet
-		if rc.DstIP != "" {
-			_, parsed, err := net.ParseCIDR(rc.DstIP)
+func attachIPSets(sets *ipset.Registry, firewallEngine *firewall.Engine, natTable *nat.Table, idsEngine *ids.Engine, qosQueue *qos.QueueManager) {
+	firewallEngine.SetIPSets(sets)
+	natTable.SetIPSets(sets)
+	if idsEngine != nil {
+		idsEngine.SetIPSets(sets)
+	}
+	qosQueue.SetIPSets(sets)
+}
+
+// buildSchedules evaluates schedules in system.timezone, falling back to UTC
+// when the zone cannot be loaded.
+func buildSchedules(cfg *config.Config, log *logger.Logger) *schedule.Registry {
+	loc, err := time.LoadLocation(cfg.System.Timezone)
+	if err != nil {
+		log.Warn("invalid timezone, schedules use UTC", map[string]any{"timezone": cfg.System.Timezone, "err": err.Error()})
+		loc = time.UTC
+	}
+	schedules := make([]schedule.Schedule, 0, len(cfg.Schedules))
+	for _, sc := range cfg.Schedules {
+		days, err := schedule.ParseDays(sc.Days)
+		if err != nil {
+			log.Warn("invalid schedule days", map[string]any{"schedule": sc.Name, "err": err.Error()})
+			continue
+		}
+		entry := schedule.Schedule{Name: sc.Name, Days: days}
+		for _, value := range sc.Times {
+			r, err := schedule.ParseRange(value)
 			if err != nil {
-				log.Warn("invalid nat dst_ip", map[string]any{"dst_ip": rc.DstIP})
+				log.Warn("invalid schedule time range", map[string]any{"schedule": sc.Name, "times": value})
 				continue
 			}
-			dstNet = parsed
+			entry.Ranges = append(entry.Ranges, r)
+		}
+		schedules = append(schedules, entry)
+	}
+	return schedule.NewRegistry(schedules, loc)
+}
+
+func attachSchedules(schedules *schedule.Registry, firewallEngine *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager) {
+	firewallEngine.SetSchedules(schedules)
+	natTable.SetSchedules(schedules)
+	qosQueue.SetSchedules(schedules)
+}
+
+func buildConntrack(ctx context.Context, cfg *config.Config) *conntrack.Table {
+	if !cfg.Conntrack.Enabled {
+		return nil
+	}
+	t := cfg.Conntrack.Timeouts
+	table := conntrack.NewTable(cfg.Conntrack.MaxEntries, conntrack.Timeouts{
+		TCPEstablished: time.Duration(t.TCPEstablished) * time.Second,
+		TCPTransitory:  time.Duration(t.TCPTransitory) * time.Second,
+		TCPClose:       time.Duration(t.TCPClose) * time.Second,
+		UDP:            time.Duration(t.UDP) * time.Second,
+		UDPStream:      time.Duration(t.UDPStream) * time.Second,
+		ICMP:           time.Duration(t.ICMP) * time.Second,
+		Generic:        time.Duration(t.Generic) * time.Second,
+	})
+	go table.Run(ctx)
+	return table
+}
+
+// attachConnCounts lets each VRF firewall count connections for ConnLimit
+// rules in its own conntrack zone, which is named after the VRF.
+func attachConnCounts(ct *conntrack.Table, vrfs *vrf.Manager) {
+	if ct == nil {
+		return
+	}
+	for _, name := range vrfs.Names() {
+		inst, ok := vrfs.Get(name)
+		if !ok {
+			continue
+		}
+		zone := inst.Name
+		inst.Firewall.SetConnCounter(func(src net.IP, bits int) int {
+			return ct.CountFrom(zone, src, bits)
+		})
+	}
+}
+
+// buildGeo opens the local GeoIP databases firewall and IDS country and ASN
+// matches use. It returns nil when none is configured or loadable, which
+// leaves those rules unmatched.
+func buildGeo(cfg *config.Config, log *logger.Logger) *enrich.GeoCache {
+	geo := cfg.Integrations.GeoIP
+	if !geo.Enabled || (geo.MMDBPath == "" && geo.ASNMMDBPath == "") {
+		return nil
+	}
+	cache, err := enrich.OpenGeoCache(geo.MMDBPath, geo.ASNMMDBPath, geo.CacheSize)
+	if err != nil {
+		log.Warn("geoip mmdb load failed, country and ASN rules will not match", map[string]any{"err": err.Error()})
+		return nil
+	}
+	return cache
+}
+
+func attachGeo(geo *enrich.GeoCache, vrfs *vrf.Manager, idsEngine *ids.Engine) {
+	if geo == nil {
+		return
+	}
+	for _, name := range vrfs.Names() {
+		if inst, ok := vrfs.Get(name); ok {
+			inst.Firewall.SetGeo(geo)
+		}
+	}
+	if idsEngine != nil {
+		idsEngine.SetGeo(geo)
+	}
+}
+
+func buildNAT(cfg *config.Config, log *logger.Logger) *nat.Table {
+	return buildNATTable(cfg.NAT, log)
+}
+
+func buildNATTable(ruleConfigs []config.NATRuleConfig, log *logger.Logger) *nat.Table {
+	var rules []nat.Rule
+	for _, rc := range ruleConfigs {
+		srcAddrs, err := network.ParseAddrMatch(rc.SrcIP)
+		if err != nil {
+			log.Warn("invalid nat src_ip", map[string]any{"src_ip": rc.SrcIP})
+			continue
+		}
+		dstAddrs, err := network.ParseAddrMatch(rc.DstIP)
+		if err != nil {
+			log.Warn("invalid nat dst_ip", map[string]any{"dst_ip": rc.DstIP})
+			continue
+		}
+		srcPorts, err := network.ParsePortMatch(rc.SrcPorts)
+		if err != nil {
+			log.Warn("invalid nat src_ports", map[string]any{"src_ports": rc.SrcPorts})
+			continue
+		}
+		dstPorts, err := network.ParsePortMatch(rc.DstPorts)
+		if err != nil {
+			log.Warn("invalid nat dst_ports", map[string]any{"dst_ports": rc.DstPorts})
+			continue
 		}
 
 		rules = append(rules, nat.Rule{
-			Type:    nat.Type(rc.Type),
-			SrcNet:  srcNet,
-			DstNet:  dstNet,
-			SrcPort: rc.SrcPort,
-			DstPort: rc.DstPort,
-			ToIP:    net.ParseIP(rc.ToIP),
-			ToPort:  rc.ToPort,
+			ID:          rc.ID,
+			Description: rc.Description,
+			Disabled:    rc.Disabled,
+			Type:        nat.Type(rc.Type),
+			SrcAddrs:    srcAddrs,
+			DstAddrs:    dstAddrs,
+			SrcPort:     rc.SrcPort,
+			DstPort:     rc.DstPort,
+			SrcPorts:    srcPorts,
+			DstPorts:    dstPorts,
+			SrcSet:      rc.SrcSet,
+			DstSet:      rc.DstSet,
+			ToIP:        net.ParseIP(rc.ToIP),
+			ToPort:      rc.ToPort,
+			Schedule:    rc.Schedule,
 		})
 	}
 	return nat.NewTable(rules)
 }
 
+func buildNFTables(ctx context.Context, cfg *config.Config, log *logger.Logger, firewallEngine *firewall.Engine, natTable *nat.Table, sets *ipset.Registry, schedules *schedule.Registry) *nftables.Backend {
+	if !cfg.NFTables.Enabled {
+		return nil
+	}
+	backend := nftables.NewBackend(nftables.Config{
+		Table:           cfg.NFTables.Table,
+		CounterInterval: time.Duration(cfg.NFTables.CounterIntervalSeconds) * time.Second,
+	}, firewallEngine, natTable, nftables.ExecRunner{Binary: cfg.NFTables.Binary})
+	backend.SetIPSets(sets)
+	backend.SetSchedules(schedules)
+	firewallEngine.SetOnChange(backend.Trigger)
+	natTable.SetOnChange(backend.Trigger)
+	sets.SetOnChange(backend.Trigger)
+	sets.SetOnMembers(backend.UpdateSet)
+	go backend.Run(ctx, func(err error) {
+		log.Warn("nftables sync failed", map[string]any{"err": err.Error(), "table": backend.Table()})
+	})
+	log.Info("nftables backend enabled", map[string]any{"table": backend.Table()})
+	return backend
+}
+
 func buildQoSQueue(cfg *config.Config) *qos.QueueManager {
 	classes := make([]qos.Class, 0, len(cfg.QoS))
 	for _, qc := range cfg.QoS {
+		// Port lists are checked by config validation.
+		srcPorts, _ := network.ParsePortMatch(qc.SrcPorts)
+		dstPorts, _ := network.ParsePortMatch(qc.DstPorts)
 		classes = append(classes, qos.Class{
 			Name:          qc.Name,
 			Protocol:      qc.Protocol,
 			SrcPort:       qc.SrcPort,
 			DstPort:       qc.DstPort,
+			SrcPorts:      srcPorts,
+			DstPorts:      dstPorts,
+			SrcSet:        qc.SrcSet,
+			DstSet:        qc.DstSet,
+			Schedule:      qc.Schedule,
 			RateLimitKbps: qc.RateLimitKbps,
 			Priority:      qc.Priority,
 			MaxQueue:      qc.MaxQueue,
@@ -519,7 +1345,7 @@ func buildIDS(cfg *config.Config) *ids.Engine {
 	})
 }
 
-func buildP2P(cfg *config.Config, table *routing.Table, metricsSrv *metrics.Metrics, log *logger.Logger, ctx context.Context) *p2p.Engine {
+func buildP2P(cfg *config.Config, table *routing.Table, policy *routing.Policy, filters *routing.RouteFilters, metricsSrv *metrics.Metrics, log *logger.Logger, ctx context.Context) *p2p.Engine {
 	if !cfg.P2P.Enabled {
 		return nil
 	}
@@ -533,7 +1359,12 @@ func buildP2P(cfg *config.Config, table *routing.Table, metricsSrv *metrics.Metr
 		MulticastAddr: cfg.P2P.MulticastAddr,
 		PublicKey:     pubKey,
 		PrivateKey:    privKey,
+		ImportMap:     cfg.P2P.ImportRouteMap,
+		ExportMap:     cfg.P2P.ExportRouteMap,
 	}, table, nil, metricsSrv.IncP2PPeer, metricsSrv.IncP2PRouteSynced)
+	engine.SetPolicy(policy)
+	engine.SetRouteFilters(filters)
+	filters.SetOnChange(engine.Refilter)
 
 	if err := engine.Start(ctx); err != nil {
 		log.Warn("p2p start failed", map[string]any{"err": err.Error()})
@@ -609,7 +1440,7 @@ func buildEnrichService(cfg *config.Config, log *logger.Logger) *enrich.Service
 	return enrich.NewService(geoProvider, asnProvider, threatProvider, 2*time.Minute)
 }
 
-func buildHA(cfg *config.Config, log *logger.Logger, routes *routing.Table, firewallEngine *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager) *ha.Manager {
+func buildHA(cfg *config.Config, log *logger.Logger, routes *routing.Table, policy *routing.Policy, firewallEngine *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager) *ha.Manager {
 	if !cfg.HA.Enabled {
 		return nil
 	}
@@ -631,10 +1462,10 @@ func buildHA(cfg *config.Config, log *logger.Logger, routes *routing.Table, fire
 		statePath,
 		time.Duration(cfg.HA.StateSyncInterval)*time.Second,
 		func() ha.State {
-			return ha.BuildState(firewallEngine, natTable, qosQueue, routes)
+			return ha.BuildStateWithPolicy(firewallEngine, natTable, qosQueue, routes, policy)
 		},
 		func(state ha.State) {
-			ha.ApplyState(firewallEngine, natTable, qosQueue, routes, state)
+			ha.ApplyStateWithPolicy(firewallEngine, natTable, qosQueue, routes, policy, state)
 		},
 	)
 	if cfg.HA.TLS.Enabled {
@@ -781,8 +1612,15 @@ func loadTLSConfig(cfg config.TLSConfig, log *logger.Logger) (*tls.Config, error
 }
 
 func buildLocalIPs(cfg *config.Config) []net.IP {
+	return buildVRFLocalIPs(cfg, vrf.Default)
+}
+
+func buildVRFLocalIPs(cfg *config.Config, name string) []net.IP {
 	out := make([]net.IP, 0, len(cfg.Interfaces))
 	for _, iface := range cfg.Interfaces {
+		if interfaceVRF(iface) != name {
+			continue
+		}
 		ip, _, err := net.ParseCIDR(iface.IP)
 		if err != nil {
 			continue
@@ -792,6 +1630,67 @@ func buildLocalIPs(cfg *config.Config) []net.IP {
 	return out
 }
 
+func interfaceVRF(iface config.InterfaceConfig) string {
+	if iface.VRF == "" {
+		return vrf.Default
+	}
+	return iface.VRF
+}
+
+func vrfInterfaces(cfg *config.Config, name string) []string {
+	var out []string
+	for _, iface := range cfg.Interfaces {
+		if interfaceVRF(iface) == name {
+			out = append(out, iface.Name)
+		}
+	}
+	return out
+}
+
+func buildVRFs(cfg *config.Config, log *logger.Logger, routes *routing.Table, policy *routing.Policy, firewallEngine *firewall.Engine, natTable *nat.Table, sets *ipset.Registry, schedules *schedule.Registry) *vrf.Manager {
+	manager := vrf.NewManager(&vrf.Instance{
+		Routes:     routes,
+		Policy:     policy,
+		Firewall:   firewallEngine,
+		NAT:        natTable,
+		Interfaces: vrfInterfaces(cfg, vrf.Default),
+		LocalIPs:   buildLocalIPs(cfg),
+	})
+	for _, vc := range cfg.VRFs {
+		vrfFirewall := buildFirewallEngine(vc.Firewall, vc.FirewallDefaults, log)
+		vrfFirewall.SetZones(buildFirewallZones(vc.FirewallZones, vc.ZonePolicies))
+		vrfFirewall.SetIPSets(sets)
+		vrfFirewall.SetSchedules(schedules)
+		vrfFirewall.SetLogSink(firewallLogSink(log, vc.Name))
+		vrfNAT := buildNATTable(vc.NAT, log)
+		vrfNAT.SetIPSets(sets)
+		vrfNAT.SetSchedules(schedules)
+		err := manager.Add(&vrf.Instance{
+			Name:       vc.Name,
+			Routes:     routes.NewSibling(append(buildConnectedRoutes(cfg, vc.Name), buildRouteList(vc.Routes, log)...)),
+			Firewall:   vrfFirewall,
+			NAT:        vrfNAT,
+			Interfaces: vrfInterfaces(cfg, vc.Name),
+			LocalIPs:   buildVRFLocalIPs(cfg, vc.Name),
+		})
+		if err != nil {
+			log.Warn("invalid vrf", map[string]any{"vrf": vc.Name, "err": err.Error()})
+		}
+	}
+	for _, lc := range cfg.RouteLeaks {
+		_, dst, err := net.ParseCIDR(lc.Destination)
+		if err != nil {
+			log.Warn("invalid route leak destination", map[string]any{"destination": lc.Destination})
+			continue
+		}
+		err = manager.AddLeak(vrf.Leak{From: lc.FromVRF, To: lc.ToVRF, Destination: *dst, Metric: lc.Metric})
+		if err != nil {
+			log.Warn("invalid route leak", map[string]any{"destination": lc.Destination, "err": err.Error()})
+		}
+	}
+	return manager
+}
+
 func determineChain(pkt network.Packet, localIPs []net.IP) string {
 	if isLocalIP(pkt.Metadata.DstIP, localIPs) {
 		return "INPUT"
@@ -16,17 +16,20 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"router-go/internal/config"
 	"router-go/internal/logger"
 	"router-go/internal/metrics"
+	"router-go/pkg/conntrack"
 	"router-go/pkg/firewall"
 	"router-go/pkg/nat"
 	"router-go/pkg/network"
 	"router-go/pkg/qos"
 	"router-go/pkg/routing"
+	"router-go/pkg/vrf"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -105,6 +108,24 @@ func TestBuildFirewallSkipsInvalidAndDefaults(t *testing.T) {
 	}
 }
 
+func TestAnalyzeFirewallConfig(t *testing.T) {
+	cfg := &config.Config{
+		Firewall: []config.FirewallRuleConfig{
+			{ID: 1, Chain: "INPUT", Action: "DROP", Protocol: "TCP"},
+			{ID: 2, Chain: "INPUT", Action: "ACCEPT", Protocol: "TCP", DstPort: 22},
+		},
+		VRFs: []config.VRFConfig{{
+			Name:     "tenant",
+			Firewall: []config.FirewallRuleConfig{{Chain: "SOMEWHERE", Action: "ACCEPT"}},
+		}},
+	}
+	warnings := analyzeFirewallConfig(cfg, logger.New("info"))
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "rule 2 ") || !strings.Contains(warnings[0], "shadowed") ||
+		!strings.HasPrefix(warnings[1], "vrf tenant: ") || !strings.Contains(warnings[1], "unreachable") {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+}
+
 func TestBuildNATSkipsInvalid(t *testing.T) {
 	cfg := &config.Config{
 		NAT: []config.NATRuleConfig{
@@ -191,6 +212,32 @@ func TestParseCIDRsSkipsInvalid(t *testing.T) {
 	}
 }
 
+func TestBuildRoutesAddsConnectedRoutes(t *testing.T) {
+	cfg := &config.Config{
+		Interfaces: []config.InterfaceConfig{
+			{Name: "eth0", IP: "10.0.0.1/24"},
+			{Name: "eth1", IP: "bad"},
+			{Name: "eth2", IP: "10.9.0.1/24", VRF: "blue"},
+		},
+		Routes: []config.RouteConfig{
+			{Destination: "10.0.0.0/24", Gateway: "192.0.2.1"},
+			{Destination: "0.0.0.0/0", Gateway: "10.0.0.254", Distance: 250},
+		},
+	}
+	table := buildRoutes(cfg, logger.New("info"))
+	route, ok := table.Lookup(net.ParseIP("10.0.0.20"))
+	if !ok || route.Source != routing.SourceConnected || route.Interface != "eth0" {
+		t.Fatalf("expected connected route to win, got %+v", route)
+	}
+	if got := len(table.RIB()); got != 3 {
+		t.Fatalf("expected 3 rib entries, got %d", got)
+	}
+	route, _ = table.Lookup(net.ParseIP("203.0.113.1"))
+	if route.Source != routing.SourceStatic || route.Distance != 250 {
+		t.Fatalf("unexpected default route: %+v", route)
+	}
+}
+
 func TestBuildLocalIPs(t *testing.T) {
 	cfg := &config.Config{
 		Interfaces: []config.InterfaceConfig{
@@ -370,7 +417,7 @@ func TestProcessPacketSetsEgressInterfaceFromRoute(t *testing.T) {
 		},
 	}
 
-	processPacket(pkt, nil, routes, fw, nil, natTable, queue, metricsSrv, nil)
+	processPacket(pkt, &dataplane{routes: routes, firewall: fw, nat: natTable, qos: queue, metrics: metricsSrv})
 
 	out, ok := queue.Dequeue()
 	if !ok {
@@ -381,6 +428,94 @@ func TestProcessPacketSetsEgressInterfaceFromRoute(t *testing.T) {
 	}
 }
 
+func TestProcessPacketUsesPolicyRoutingTable(t *testing.T) {
+	_, defNet, _ := net.ParseCIDR("0.0.0.0/0")
+	_, guestNet, _ := net.ParseCIDR("10.0.2.0/24")
+	routes := routing.NewTable([]routing.Route{{Destination: *defNet, Interface: "wan1"}})
+	policy := routing.NewPolicy(routes)
+	if _, err := policy.AddTable("isp2", []routing.Route{{Destination: *defNet, Interface: "wan2"}}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	if err := policy.AddRule(routing.PolicyRule{Priority: 100, SrcNet: guestNet, Table: "isp2"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	fw := firewall.NewEngineWithDefaults(nil, map[string]firewall.Action{"FORWARD": firewall.ActionAccept})
+	natTable := nat.NewTable(nil)
+	queue := qos.NewQueueManager(nil)
+	metricsSrv := metrics.NewWithRegistry(prometheus.NewRegistry())
+
+	for _, tc := range []struct {
+		src    string
+		egress string
+	}{
+		{src: "10.0.2.7", egress: "wan2"},
+		{src: "10.0.1.7", egress: "wan1"},
+	} {
+		pkt := network.Packet{
+			Metadata: network.PacketMetadata{
+				SrcIP:    net.ParseIP(tc.src),
+				DstIP:    net.ParseIP("8.8.8.8"),
+				Protocol: "UDP",
+				SrcPort:  12345,
+				DstPort:  53,
+			},
+		}
+		processPacket(pkt, &dataplane{routes: routes, policy: policy, firewall: fw, nat: natTable, qos: queue, metrics: metricsSrv})
+		out, ok := queue.Dequeue()
+		if !ok {
+			t.Fatalf("expected packet from %s to be enqueued", tc.src)
+		}
+		if out.EgressInterface != tc.egress {
+			t.Fatalf("expected egress %s for %s, got %q", tc.egress, tc.src, out.EgressInterface)
+		}
+	}
+}
+
+func TestProcessPacketUsesIngressVRF(t *testing.T) {
+	_, defNet, _ := net.ParseCIDR("0.0.0.0/0")
+	_, leakNet, _ := net.ParseCIDR("8.8.8.0/24")
+	routes := routing.NewTable([]routing.Route{{Destination: *defNet, Interface: "wan"}})
+	fw := firewall.NewEngineWithDefaults(nil, map[string]firewall.Action{"FORWARD": firewall.ActionAccept})
+	vrfs := vrf.NewManager(&vrf.Instance{Routes: routes, Firewall: fw, NAT: nat.NewTable(nil), Interfaces: []string{"lan"}})
+	tenantFW := firewall.NewEngineWithDefaults(nil, map[string]firewall.Action{"FORWARD": firewall.ActionAccept})
+	if err := vrfs.Add(&vrf.Instance{Name: "tenant", Routes: routes.NewSibling(nil), Firewall: tenantFW, Interfaces: []string{"tenant0"}}); err != nil {
+		t.Fatalf("add vrf: %v", err)
+	}
+	queue := qos.NewQueueManager(nil)
+	metricsSrv := metrics.NewWithRegistry(prometheus.NewRegistry())
+	send := func(dst string) (network.Packet, bool) {
+		pkt := network.Packet{
+			IngressInterface: "tenant0",
+			Metadata: network.PacketMetadata{
+				SrcIP:    net.ParseIP("192.168.50.2"),
+				DstIP:    net.ParseIP(dst),
+				Protocol: "UDP",
+				SrcPort:  12345,
+				DstPort:  53,
+			},
+		}
+		processPacket(pkt, &dataplane{routes: routes, vrfs: vrfs, firewall: fw, qos: queue, metrics: metricsSrv})
+		return queue.Dequeue()
+	}
+
+	if out, ok := send("8.8.8.8"); ok && out.EgressInterface != "" {
+		t.Fatalf("tenant packet must not use default vrf routes, got egress %q", out.EgressInterface)
+	}
+	if err := vrfs.AddLeak(vrf.Leak{From: "tenant", To: vrf.Default, Destination: *leakNet}); err != nil {
+		t.Fatalf("add leak: %v", err)
+	}
+	out, ok := send("8.8.8.8")
+	if !ok || out.EgressInterface != "wan" {
+		t.Fatalf("expected leaked route via wan, got ok=%v egress=%q", ok, out.EgressInterface)
+	}
+	if hits := tenantFW.ChainHits()["FORWARD"]; hits == 0 {
+		t.Fatalf("expected tenant firewall to evaluate the packet")
+	}
+	if hits := fw.ChainHits()["FORWARD"]; hits != 0 {
+		t.Fatalf("default firewall must not see tenant traffic, got %d hits", hits)
+	}
+}
+
 func TestProcessPacketPipelineNATRoutingFirewall(t *testing.T) {
 	_, dstNet, err := net.ParseCIDR("8.8.8.0/24")
 	if err != nil {
@@ -431,7 +566,7 @@ func TestProcessPacketPipelineNATRoutingFirewall(t *testing.T) {
 		},
 	}
 
-	processPacket(in, nil, routes, fw, nil, natTable, queue, metricsSrv, nil)
+	processPacket(in, &dataplane{routes: routes, firewall: fw, nat: natTable, qos: queue, metrics: metricsSrv})
 
 	out, ok := queue.Dequeue()
 	if !ok {
@@ -445,6 +580,54 @@ func TestProcessPacketPipelineNATRoutingFirewall(t *testing.T) {
 	}
 }
 
+func TestProcessPacketConntrackAllowsReplies(t *testing.T) {
+	_, lanNet, _ := net.ParseCIDR("10.0.0.0/24")
+	natTable := nat.NewTable([]nat.Rule{
+		{Type: nat.TypeSNAT, SrcNet: lanNet, ToIP: net.ParseIP("203.0.113.10")},
+	})
+	fw := firewall.NewEngineWithDefaults([]firewall.Rule{
+		{Chain: "FORWARD", Action: firewall.ActionAccept, CTState: network.CTEstablished | network.CTRelated},
+		{Chain: "FORWARD", Action: firewall.ActionAccept, InInterface: "lan", CTState: network.CTNew},
+	}, map[string]firewall.Action{
+		"FORWARD": firewall.ActionDrop,
+	})
+	ct := conntrack.NewTable(0, conntrack.Timeouts{})
+	queue := qos.NewQueueManager(nil)
+	metricsSrv := metrics.NewWithRegistry(prometheus.NewRegistry())
+	udp := func(iface, src, dst string, sport, dport int) network.Packet {
+		return network.Packet{
+			IngressInterface: iface,
+			Metadata: network.PacketMetadata{
+				SrcIP:    net.ParseIP(src),
+				DstIP:    net.ParseIP(dst),
+				Protocol: "UDP",
+				SrcPort:  sport,
+				DstPort:  dport,
+			},
+		}
+	}
+
+	dp := &dataplane{firewall: fw, nat: natTable, conntrack: ct, qos: queue, metrics: metricsSrv}
+	processPacket(udp("lan", "10.0.0.2", "8.8.8.8", 12000, 53), dp)
+	processPacket(udp("wan1", "8.8.8.8", "203.0.113.10", 53, 12000), dp)
+	processPacket(udp("wan1", "8.8.4.4", "203.0.113.10", 53, 12000), dp)
+
+	if _, ok := queue.Dequeue(); !ok {
+		t.Fatalf("expected new outbound packet to be forwarded")
+	}
+	reply, ok := queue.Dequeue()
+	if !ok || reply.CTState != network.CTEstablished || reply.Metadata.DstIP.String() != "10.0.0.2" {
+		t.Fatalf("expected established reply to be forwarded to 10.0.0.2, got %+v", reply)
+	}
+	if _, ok := queue.Dequeue(); ok {
+		t.Fatalf("expected unsolicited packet to be dropped")
+	}
+	entries := ct.Entries()
+	if len(entries) != 1 || entries[0].ReplyDst.String() != "203.0.113.10" || entries[0].ReplyPackets != 1 {
+		t.Fatalf("unexpected conntrack entries: %+v", entries)
+	}
+}
+
 type mockPacketIO struct {
 	writes []network.Packet
 }
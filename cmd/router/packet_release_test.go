@@ -30,7 +30,7 @@ func TestHandlePacketCallsRelease(t *testing.T) {
 	natTable := nat.NewTable(nil)
 	m := metrics.NewWithRegistry(prometheus.NewRegistry())
 
-	handlePacket(pkt, nil, routes, fw, nil, natTable, nil, m, nil)
+	handlePacket(pkt, &dataplane{routes: routes, firewall: fw, nat: natTable, metrics: m})
 
 	if !released {
 		t.Fatalf("expected packet release")
@@ -61,7 +61,7 @@ func TestSmokePacketPipelineThroughput(t *testing.T) {
 				DstPort:     53,
 			},
 		}
-		processPacket(pkt, nil, routes, fw, nil, natTable, queue, metricsSrv, nil)
+		processPacket(pkt, &dataplane{routes: routes, firewall: fw, nat: natTable, qos: queue, metrics: metricsSrv})
 		if _, ok := queue.Dequeue(); !ok {
 			dropped++
 		}
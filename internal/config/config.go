@@ -2,16 +2,30 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"strings"
 
+	"router-go/pkg/firewall"
+	"router-go/pkg/ipset"
+	"router-go/pkg/network"
+	"router-go/pkg/schedule"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
 	Interfaces       []InterfaceConfig      `mapstructure:"interfaces"`
 	Routes           []RouteConfig          `mapstructure:"routes"`
+	Routing          RoutingConfig          `mapstructure:"routing"`
+	VRFs             []VRFConfig            `mapstructure:"vrfs"`
+	RouteLeaks       []RouteLeakConfig      `mapstructure:"route_leaks"`
 	Firewall         []FirewallRuleConfig   `mapstructure:"firewall"`
 	FirewallDefaults FirewallDefaultsConfig `mapstructure:"firewall_defaults"`
+	FirewallZones    []FirewallZoneConfig   `mapstructure:"firewall_zones"`
+	ZonePolicies     []ZonePolicyConfig     `mapstructure:"firewall_zone_policies"`
+	IPSets           []IPSetConfig          `mapstructure:"ip_sets"`
+	Schedules        []ScheduleConfig       `mapstructure:"schedules"`
+	Conntrack        ConntrackConfig        `mapstructure:"conntrack"`
 	NAT              []NATRuleConfig        `mapstructure:"nat"`
 	QoS              []QoSClassConfig       `mapstructure:"qos"`
 	IDS              IDSConfig              `mapstructure:"ids"`
@@ -26,6 +40,7 @@ type Config struct {
 	Metrics          MetricsConfig          `mapstructure:"metrics"`
 	Observability    ObservabilityConfig    `mapstructure:"observability"`
 	Performance      PerformanceConfig      `mapstructure:"performance"`
+	NFTables         NFTablesConfig         `mapstructure:"nftables"`
 	Logging          LoggingConfig          `mapstructure:"logging"`
 	Presets          PresetsConfig          `mapstructure:"presets"`
 	System           SystemConfig           `mapstructure:"system"`
@@ -34,25 +49,229 @@ type Config struct {
 type InterfaceConfig struct {
 	Name string `mapstructure:"name"`
 	IP   string `mapstructure:"ip"`
+	VRF  string `mapstructure:"vrf"`
 }
 
-type RouteConfig struct {
+type VRFConfig struct {
+	Name             string                 `mapstructure:"name"`
+	Routes           []RouteConfig          `mapstructure:"routes"`
+	Firewall         []FirewallRuleConfig   `mapstructure:"firewall"`
+	FirewallDefaults FirewallDefaultsConfig `mapstructure:"firewall_defaults"`
+	FirewallZones    []FirewallZoneConfig   `mapstructure:"firewall_zones"`
+	ZonePolicies     []ZonePolicyConfig     `mapstructure:"firewall_zone_policies"`
+	NAT              []NATRuleConfig        `mapstructure:"nat"`
+}
+
+type RouteLeakConfig struct {
+	FromVRF     string `mapstructure:"from_vrf"`
+	ToVRF       string `mapstructure:"to_vrf"`
 	Destination string `mapstructure:"destination"`
-	Gateway     string `mapstructure:"gateway"`
-	Interface   string `mapstructure:"interface"`
 	Metric      int    `mapstructure:"metric"`
 }
 
+type RouteConfig struct {
+	Destination string            `mapstructure:"destination"`
+	Gateway     string            `mapstructure:"gateway"`
+	Interface   string            `mapstructure:"interface"`
+	Metric      int               `mapstructure:"metric"`
+	Distance    int               `mapstructure:"distance"`
+	NextHops    []NextHopConfig   `mapstructure:"next_hops"`
+	Track       *RouteTrackConfig `mapstructure:"track"`
+	BFD         *BFDConfig        `mapstructure:"bfd"`
+}
+
+type RouteTrackConfig struct {
+	Type            string `mapstructure:"type"`
+	Target          string `mapstructure:"target"`
+	IntervalSeconds int    `mapstructure:"interval_seconds"`
+	TimeoutSeconds  int    `mapstructure:"timeout_seconds"`
+	FailThreshold   int    `mapstructure:"fail_threshold"`
+	RiseThreshold   int    `mapstructure:"rise_threshold"`
+}
+
+type BFDConfig struct {
+	Peer        string `mapstructure:"peer"`
+	Interface   string `mapstructure:"interface"`
+	MinTxMillis int    `mapstructure:"min_tx_ms"`
+	MinRxMillis int    `mapstructure:"min_rx_ms"`
+	Multiplier  int    `mapstructure:"multiplier"`
+}
+
+type NextHopConfig struct {
+	Gateway   string `mapstructure:"gateway"`
+	Interface string `mapstructure:"interface"`
+	Weight    int    `mapstructure:"weight"`
+	Probe     string `mapstructure:"probe"`
+}
+
+type RoutingConfig struct {
+	MonitorIntervalSeconds int                  `mapstructure:"monitor_interval_seconds"`
+	ProbeTimeoutSeconds    int                  `mapstructure:"probe_timeout_seconds"`
+	ProbeFall              int                  `mapstructure:"probe_fall"`
+	ProbeRise              int                  `mapstructure:"probe_rise"`
+	RouterID               string               `mapstructure:"router_id"`
+	Tables                 []RoutingTableConfig `mapstructure:"tables"`
+	Rules                  []PolicyRuleConfig   `mapstructure:"rules"`
+	BGP                    BGPConfig            `mapstructure:"bgp"`
+	OSPF                   OSPFConfig           `mapstructure:"ospf"`
+	RIP                    RIPConfig            `mapstructure:"rip"`
+	PrefixLists            []PrefixListConfig   `mapstructure:"prefix_lists"`
+	RouteMaps              []RouteMapConfig     `mapstructure:"route_maps"`
+}
+
+type PrefixListConfig struct {
+	Name    string                  `mapstructure:"name"`
+	Entries []PrefixListEntryConfig `mapstructure:"entries"`
+}
+
+type PrefixListEntryConfig struct {
+	Seq    int    `mapstructure:"seq"`
+	Action string `mapstructure:"action"`
+	Prefix string `mapstructure:"prefix"`
+	GE     int    `mapstructure:"ge"`
+	LE     int    `mapstructure:"le"`
+}
+
+type RouteMapConfig struct {
+	Name    string                `mapstructure:"name"`
+	Entries []RouteMapEntryConfig `mapstructure:"entries"`
+}
+
+type RouteMapEntryConfig struct {
+	Seq             int     `mapstructure:"seq"`
+	Action          string  `mapstructure:"action"`
+	MatchPrefixList string  `mapstructure:"match_prefix_list"`
+	MatchSource     string  `mapstructure:"match_source"`
+	MatchMetric     *int    `mapstructure:"match_metric"`
+	SetMetric       *int    `mapstructure:"set_metric"`
+	SetTag          *uint32 `mapstructure:"set_tag"`
+}
+
+type OSPFConfig struct {
+	Enabled    bool                  `mapstructure:"enabled"`
+	Area       string                `mapstructure:"area"`
+	Interfaces []OSPFInterfaceConfig `mapstructure:"interfaces"`
+}
+
+type OSPFInterfaceConfig struct {
+	Name                      string `mapstructure:"name"`
+	NetworkType               string `mapstructure:"network_type"`
+	Cost                      int    `mapstructure:"cost"`
+	Priority                  *int   `mapstructure:"priority"`
+	HelloIntervalSeconds      int    `mapstructure:"hello_interval_seconds"`
+	DeadIntervalSeconds       int    `mapstructure:"dead_interval_seconds"`
+	RetransmitIntervalSeconds int    `mapstructure:"retransmit_interval_seconds"`
+	Passive                   bool   `mapstructure:"passive"`
+}
+
+type RIPConfig struct {
+	Enabled               bool                 `mapstructure:"enabled"`
+	UpdateIntervalSeconds int                  `mapstructure:"update_interval_seconds"`
+	TimeoutSeconds        int                  `mapstructure:"timeout_seconds"`
+	GarbageSeconds        int                  `mapstructure:"garbage_seconds"`
+	Networks              []string             `mapstructure:"networks"`
+	Interfaces            []RIPInterfaceConfig `mapstructure:"interfaces"`
+}
+
+type RIPInterfaceConfig struct {
+	Name         string `mapstructure:"name"`
+	Version      string `mapstructure:"version"`
+	Cost         int    `mapstructure:"cost"`
+	SplitHorizon string `mapstructure:"split_horizon"`
+	Passive      bool   `mapstructure:"passive"`
+	AuthKey      string `mapstructure:"auth_key"`
+	AuthKeyID    int    `mapstructure:"auth_key_id"`
+}
+
+type BGPConfig struct {
+	Enabled             bool                `mapstructure:"enabled"`
+	ASN                 uint32              `mapstructure:"asn"`
+	ListenAddress       string              `mapstructure:"listen_address"`
+	HoldTimeSeconds     int                 `mapstructure:"hold_time_seconds"`
+	ConnectRetrySeconds int                 `mapstructure:"connect_retry_seconds"`
+	Networks            []string            `mapstructure:"networks"`
+	ExportStatic        bool                `mapstructure:"export_static"`
+	Neighbors           []BGPNeighborConfig `mapstructure:"neighbors"`
+}
+
+type BGPNeighborConfig struct {
+	Address         string          `mapstructure:"address"`
+	Port            int             `mapstructure:"port"`
+	RemoteASN       uint32          `mapstructure:"remote_asn"`
+	Description     string          `mapstructure:"description"`
+	Passive         bool            `mapstructure:"passive"`
+	HoldTimeSeconds int             `mapstructure:"hold_time_seconds"`
+	MaxPrefixes     int             `mapstructure:"max_prefixes"`
+	Import          BGPPolicyConfig `mapstructure:"import"`
+	Export          BGPPolicyConfig `mapstructure:"export"`
+	BFD             *BFDConfig      `mapstructure:"bfd"`
+}
+
+type BGPPolicyConfig struct {
+	DefaultAction string                `mapstructure:"default_action"`
+	Terms         []BGPPolicyTermConfig `mapstructure:"terms"`
+}
+
+type BGPPolicyTermConfig struct {
+	Prefix    string  `mapstructure:"prefix"`
+	GE        int     `mapstructure:"ge"`
+	LE        int     `mapstructure:"le"`
+	Action    string  `mapstructure:"action"`
+	LocalPref uint32  `mapstructure:"local_pref"`
+	MED       *uint32 `mapstructure:"med"`
+	Prepend   int     `mapstructure:"prepend"`
+}
+
+type RoutingTableConfig struct {
+	Name   string        `mapstructure:"name"`
+	Routes []RouteConfig `mapstructure:"routes"`
+}
+
+type PolicyRuleConfig struct {
+	Priority    int    `mapstructure:"priority"`
+	SrcIP       string `mapstructure:"src_ip"`
+	DstIP       string `mapstructure:"dst_ip"`
+	InInterface string `mapstructure:"in_interface"`
+	Protocol    string `mapstructure:"protocol"`
+	SrcPort     int    `mapstructure:"src_port"`
+	DstPort     int    `mapstructure:"dst_port"`
+	DSCP        *int   `mapstructure:"dscp"`
+	Mark        uint32 `mapstructure:"mark"`
+	MarkMask    uint32 `mapstructure:"mark_mask"`
+	Table       string `mapstructure:"table"`
+}
+
 type FirewallRuleConfig struct {
+	ID           uint64 `mapstructure:"id"`
+	Description  string `mapstructure:"description"`
+	Disabled     bool   `mapstructure:"disabled"`
 	Chain        string `mapstructure:"chain"`
 	Action       string `mapstructure:"action"`
+	Target       string `mapstructure:"target"`
 	Protocol     string `mapstructure:"protocol"`
 	SrcIP        string `mapstructure:"src_ip"`
 	DstIP        string `mapstructure:"dst_ip"`
 	SrcPort      int    `mapstructure:"src_port"`
 	DstPort      int    `mapstructure:"dst_port"`
+	SrcPorts     string `mapstructure:"src_ports"`
+	DstPorts     string `mapstructure:"dst_ports"`
+	SrcSet       string `mapstructure:"src_set"`
+	DstSet       string `mapstructure:"dst_set"`
 	InInterface  string `mapstructure:"in_interface"`
 	OutInterface string `mapstructure:"out_interface"`
+	From         string `mapstructure:"from"`
+	To           string `mapstructure:"to"`
+	CTState      string `mapstructure:"ct_state"`
+	Schedule     string `mapstructure:"schedule"`
+	Log          bool   `mapstructure:"log"`
+	LogRate      int    `mapstructure:"log_rate"`
+	RateLimit    int    `mapstructure:"rate_limit"`
+	RateBurst    int    `mapstructure:"rate_burst"`
+	ConnLimit    int    `mapstructure:"conn_limit"`
+	LimitPrefix  int    `mapstructure:"limit_prefix"`
+	SrcCountry   string `mapstructure:"src_country"`
+	DstCountry   string `mapstructure:"dst_country"`
+	SrcASN       string `mapstructure:"src_asn"`
 }
 
 type FirewallDefaultsConfig struct {
@@ -61,14 +280,75 @@ type FirewallDefaultsConfig struct {
 	Forward string `mapstructure:"forward"`
 }
 
+// FirewallZoneConfig groups interfaces into a zone; a trailing "*" in an
+// interface name matches by prefix (VLANs, tunnels).
+type FirewallZoneConfig struct {
+	Name       string   `mapstructure:"name"`
+	Interfaces []string `mapstructure:"interfaces"`
+	Input      string   `mapstructure:"input"`
+	Output     string   `mapstructure:"output"`
+	Forward    string   `mapstructure:"forward"`
+}
+
+type ZonePolicyConfig struct {
+	From   string `mapstructure:"from"`
+	To     string `mapstructure:"to"`
+	Action string `mapstructure:"action"`
+}
+
+// IPSetConfig defines a named address group. Type is hash:net (default) or
+// hash:ip; TimeoutSeconds is the default lifetime of members, 0 keeps them
+// until removed.
+type IPSetConfig struct {
+	Name           string   `mapstructure:"name"`
+	Type           string   `mapstructure:"type"`
+	TimeoutSeconds int      `mapstructure:"timeout_seconds"`
+	Entries        []string `mapstructure:"entries"`
+}
+
+// ScheduleConfig is a weekly time window rules can reference. Days are day
+// names or "weekdays"/"weekend", empty for every day; Times are "HH:MM-HH:MM"
+// ranges in system.timezone, empty for the whole day.
+type ScheduleConfig struct {
+	Name  string   `mapstructure:"name"`
+	Days  []string `mapstructure:"days"`
+	Times []string `mapstructure:"times"`
+}
+
+type ConntrackConfig struct {
+	Enabled    bool                    `mapstructure:"enabled"`
+	MaxEntries int                     `mapstructure:"max_entries"`
+	Timeouts   ConntrackTimeoutsConfig `mapstructure:"timeouts"`
+}
+
+// ConntrackTimeoutsConfig holds idle timeouts in seconds; zero keeps the
+// built-in default.
+type ConntrackTimeoutsConfig struct {
+	TCPEstablished int `mapstructure:"tcp_established"`
+	TCPTransitory  int `mapstructure:"tcp_transitory"`
+	TCPClose       int `mapstructure:"tcp_close"`
+	UDP            int `mapstructure:"udp"`
+	UDPStream      int `mapstructure:"udp_stream"`
+	ICMP           int `mapstructure:"icmp"`
+	Generic        int `mapstructure:"generic"`
+}
+
 type NATRuleConfig struct {
-	Type    string `mapstructure:"type"`
-	SrcIP   string `mapstructure:"src_ip"`
-	DstIP   string `mapstructure:"dst_ip"`
-	SrcPort int    `mapstructure:"src_port"`
-	DstPort int    `mapstructure:"dst_port"`
-	ToIP    string `mapstructure:"to_ip"`
-	ToPort  int    `mapstructure:"to_port"`
+	ID          uint64 `mapstructure:"id"`
+	Description string `mapstructure:"description"`
+	Disabled    bool   `mapstructure:"disabled"`
+	Type        string `mapstructure:"type"`
+	SrcIP       string `mapstructure:"src_ip"`
+	DstIP       string `mapstructure:"dst_ip"`
+	SrcPort     int    `mapstructure:"src_port"`
+	DstPort     int    `mapstructure:"dst_port"`
+	SrcPorts    string `mapstructure:"src_ports"`
+	DstPorts    string `mapstructure:"dst_ports"`
+	SrcSet      string `mapstructure:"src_set"`
+	DstSet      string `mapstructure:"dst_set"`
+	ToIP        string `mapstructure:"to_ip"`
+	ToPort      int    `mapstructure:"to_port"`
+	Schedule    string `mapstructure:"schedule"`
 }
 
 type QoSClassConfig struct {
@@ -76,6 +356,11 @@ type QoSClassConfig struct {
 	Protocol      string `mapstructure:"protocol"`
 	SrcPort       int    `mapstructure:"src_port"`
 	DstPort       int    `mapstructure:"dst_port"`
+	SrcPorts      string `mapstructure:"src_ports"`
+	DstPorts      string `mapstructure:"dst_ports"`
+	SrcSet        string `mapstructure:"src_set"`
+	DstSet        string `mapstructure:"dst_set"`
+	Schedule      string `mapstructure:"schedule"`
 	RateLimitKbps int    `mapstructure:"rate_limit_kbps"`
 	Priority      int    `mapstructure:"priority"`
 	MaxQueue      int    `mapstructure:"max_queue"`
@@ -116,6 +401,8 @@ type P2PConfig struct {
 	PeerTTLSeconds int    `mapstructure:"peer_ttl_seconds"`
 	PrivateKeyFile string `mapstructure:"private_key_file"`
 	PublicKeyFile  string `mapstructure:"public_key_file"`
+	ImportRouteMap string `mapstructure:"import_route_map"`
+	ExportRouteMap string `mapstructure:"export_route_map"`
 }
 
 type ProxyConfig struct {
@@ -143,10 +430,12 @@ type IntegrationsConfig struct {
 }
 
 type GeoIPConfig struct {
-	Enabled   bool   `mapstructure:"enabled"`
-	MMDBPath  string `mapstructure:"mmdb_path"`
-	HTTPURL   string `mapstructure:"http_url"`
-	HTTPToken string `mapstructure:"http_token"`
+	Enabled     bool   `mapstructure:"enabled"`
+	MMDBPath    string `mapstructure:"mmdb_path"`
+	ASNMMDBPath string `mapstructure:"asn_mmdb_path"`
+	CacheSize   int    `mapstructure:"cache_size"`
+	HTTPURL     string `mapstructure:"http_url"`
+	HTTPToken   string `mapstructure:"http_token"`
 }
 
 type ASNConfig struct {
@@ -198,17 +487,18 @@ type TLSConfig struct {
 }
 
 type HAConfig struct {
-	Enabled           bool      `mapstructure:"enabled"`
-	NodeID            string    `mapstructure:"node_id"`
-	Priority          int       `mapstructure:"priority"`
-	HeartbeatInterval int       `mapstructure:"heartbeat_interval_seconds"`
-	HoldSeconds       int       `mapstructure:"hold_seconds"`
-	BindAddr          string    `mapstructure:"bind_addr"`
-	MulticastAddr     string    `mapstructure:"multicast_addr"`
-	Peers             []string  `mapstructure:"peers"`
-	StateSyncInterval int       `mapstructure:"state_sync_interval_seconds"`
-	StateEndpointPath string    `mapstructure:"state_endpoint_path"`
-	TLS               TLSConfig `mapstructure:"tls"`
+	Enabled           bool        `mapstructure:"enabled"`
+	NodeID            string      `mapstructure:"node_id"`
+	Priority          int         `mapstructure:"priority"`
+	HeartbeatInterval int         `mapstructure:"heartbeat_interval_seconds"`
+	HoldSeconds       int         `mapstructure:"hold_seconds"`
+	BindAddr          string      `mapstructure:"bind_addr"`
+	MulticastAddr     string      `mapstructure:"multicast_addr"`
+	Peers             []string    `mapstructure:"peers"`
+	StateSyncInterval int         `mapstructure:"state_sync_interval_seconds"`
+	StateEndpointPath string      `mapstructure:"state_endpoint_path"`
+	TLS               TLSConfig   `mapstructure:"tls"`
+	BFD               []BFDConfig `mapstructure:"bfd"`
 }
 
 type APIConfig struct {
@@ -225,6 +515,13 @@ type PerformanceConfig struct {
 	EgressIdleSleepMillis int `mapstructure:"egress_idle_sleep_millis"`
 }
 
+type NFTablesConfig struct {
+	Enabled                bool   `mapstructure:"enabled"`
+	Table                  string `mapstructure:"table"`
+	Binary                 string `mapstructure:"binary"`
+	CounterIntervalSeconds int    `mapstructure:"counter_interval_seconds"`
+}
+
 type ObservabilityConfig struct {
 	Enabled              bool   `mapstructure:"enabled"`
 	TracesLimit          int    `mapstructure:"traces_limit"`
@@ -294,6 +591,363 @@ func LoadFromBytes(data []byte) (*Config, error) {
 	return &cfg, nil
 }
 
+func validateRoutes(path string, routes []RouteConfig) error {
+	for i, route := range routes {
+		if route.Destination == "" {
+			return fmt.Errorf("%s[%d].destination is required", path, i)
+		}
+		if route.Distance < 0 || route.Distance > 255 {
+			return fmt.Errorf("%s[%d].distance must be between 0 and 255", path, i)
+		}
+		for j, hop := range route.NextHops {
+			if hop.Gateway == "" && hop.Interface == "" {
+				return fmt.Errorf("%s[%d].next_hops[%d] requires gateway or interface", path, i, j)
+			}
+			if hop.Weight < 0 {
+				return fmt.Errorf("%s[%d].next_hops[%d].weight must be >= 0", path, i, j)
+			}
+		}
+		if route.Track != nil {
+			if err := validateRouteTrack(fmt.Sprintf("%s[%d].track", path, i), route); err != nil {
+				return err
+			}
+		}
+		if route.BFD != nil {
+			if route.Track != nil {
+				return fmt.Errorf("%s[%d] cannot combine track and bfd", path, i)
+			}
+			hasGateway := route.Gateway != ""
+			for _, hop := range route.NextHops {
+				if hop.Gateway != "" {
+					hasGateway = true
+				}
+			}
+			if route.BFD.Peer == "" && !hasGateway {
+				return fmt.Errorf("%s[%d].bfd requires peer or route gateway", path, i)
+			}
+			if err := validateBFD(fmt.Sprintf("%s[%d].bfd", path, i), *route.BFD); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateRouteTrack(path string, route RouteConfig) error {
+	track := route.Track
+	if track.IntervalSeconds < 0 || track.TimeoutSeconds < 0 {
+		return fmt.Errorf("%s interval_seconds and timeout_seconds must be >= 0", path)
+	}
+	if track.FailThreshold < 0 || track.RiseThreshold < 0 {
+		return fmt.Errorf("%s fail_threshold and rise_threshold must be >= 0", path)
+	}
+	hasGateway := route.Gateway != ""
+	for _, hop := range route.NextHops {
+		if hop.Gateway != "" {
+			hasGateway = true
+		}
+	}
+	switch strings.ToLower(track.Type) {
+	case "", "icmp":
+		if track.Target == "" && !hasGateway {
+			return fmt.Errorf("%s requires target or route gateway", path)
+		}
+	case "tcp":
+		if track.Target == "" {
+			return fmt.Errorf("%s.target is required for tcp", path)
+		}
+	case "http":
+		if track.Target == "" {
+			return fmt.Errorf("%s.target is required for http", path)
+		}
+	default:
+		return fmt.Errorf("%s.type must be icmp, tcp or http", path)
+	}
+	return nil
+}
+
+func validateBFD(path string, bfd BFDConfig) error {
+	if bfd.Peer != "" && net.ParseIP(bfd.Peer) == nil {
+		return fmt.Errorf("%s.peer is invalid", path)
+	}
+	if bfd.MinTxMillis < 0 || bfd.MinRxMillis < 0 {
+		return fmt.Errorf("%s min_tx_ms and min_rx_ms must be >= 0", path)
+	}
+	if bfd.Multiplier < 0 || bfd.Multiplier > 255 {
+		return fmt.Errorf("%s.multiplier must be 0-255", path)
+	}
+	return nil
+}
+
+func validateBGP(routing RoutingConfig) error {
+	bgp := routing.BGP
+	if bgp.ASN == 0 {
+		return fmt.Errorf("routing.bgp.asn is required")
+	}
+	if routing.RouterID == "" {
+		return fmt.Errorf("routing.router_id is required when bgp is enabled")
+	}
+	if bgp.HoldTimeSeconds != 0 && bgp.HoldTimeSeconds < 3 {
+		return fmt.Errorf("routing.bgp.hold_time_seconds must be 0 or >= 3")
+	}
+	for i, network := range bgp.Networks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("routing.bgp.networks[%d] is invalid", i)
+		}
+	}
+	seen := map[string]struct{}{}
+	for i, neighbor := range bgp.Neighbors {
+		path := fmt.Sprintf("routing.bgp.neighbors[%d]", i)
+		ip := net.ParseIP(neighbor.Address)
+		if ip == nil {
+			return fmt.Errorf("%s.address is invalid", path)
+		}
+		if _, ok := seen[ip.String()]; ok {
+			return fmt.Errorf("%s.address is duplicated", path)
+		}
+		seen[ip.String()] = struct{}{}
+		if neighbor.RemoteASN == 0 {
+			return fmt.Errorf("%s.remote_asn is required", path)
+		}
+		if neighbor.Port < 0 || neighbor.Port > 65535 {
+			return fmt.Errorf("%s.port is invalid", path)
+		}
+		if neighbor.HoldTimeSeconds != 0 && neighbor.HoldTimeSeconds < 3 {
+			return fmt.Errorf("%s.hold_time_seconds must be 0 or >= 3", path)
+		}
+		if neighbor.MaxPrefixes < 0 {
+			return fmt.Errorf("%s.max_prefixes must be >= 0", path)
+		}
+		if err := validateBGPPolicy(path+".import", neighbor.Import); err != nil {
+			return err
+		}
+		if err := validateBGPPolicy(path+".export", neighbor.Export); err != nil {
+			return err
+		}
+		if neighbor.BFD != nil {
+			if err := validateBFD(path+".bfd", *neighbor.BFD); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateOSPF(cfg *Config) error {
+	ospf := cfg.Routing.OSPF
+	if cfg.Routing.RouterID == "" {
+		return fmt.Errorf("routing.router_id is required when ospf is enabled")
+	}
+	if ip := net.ParseIP(ospf.Area); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("routing.ospf.area must be a dotted IPv4 area id")
+	}
+	if len(ospf.Interfaces) == 0 {
+		return fmt.Errorf("routing.ospf.interfaces is required when ospf is enabled")
+	}
+	addresses := map[string]string{}
+	for _, iface := range cfg.Interfaces {
+		addresses[iface.Name] = iface.IP
+	}
+	seen := map[string]struct{}{}
+	for i, iface := range ospf.Interfaces {
+		path := fmt.Sprintf("routing.ospf.interfaces[%d]", i)
+		address, ok := addresses[iface.Name]
+		if !ok {
+			return fmt.Errorf("%s.name %q is not a configured interface", path, iface.Name)
+		}
+		if ip, _, err := net.ParseCIDR(address); err != nil || ip.To4() == nil {
+			return fmt.Errorf("%s: interface %q needs an IPv4 ip with prefix length", path, iface.Name)
+		}
+		if _, ok := seen[iface.Name]; ok {
+			return fmt.Errorf("%s.name is duplicated", path)
+		}
+		seen[iface.Name] = struct{}{}
+		switch iface.NetworkType {
+		case "broadcast", "point-to-point":
+		default:
+			return fmt.Errorf("%s.network_type must be broadcast or point-to-point", path)
+		}
+		if iface.Cost < 1 || iface.Cost > 65535 {
+			return fmt.Errorf("%s.cost must be 1-65535", path)
+		}
+		if iface.Priority != nil && (*iface.Priority < 0 || *iface.Priority > 255) {
+			return fmt.Errorf("%s.priority must be 0-255", path)
+		}
+		if iface.DeadIntervalSeconds <= iface.HelloIntervalSeconds {
+			return fmt.Errorf("%s.dead_interval_seconds must be greater than hello_interval_seconds", path)
+		}
+	}
+	return nil
+}
+
+func validateRIP(cfg *Config) error {
+	rip := cfg.Routing.RIP
+	if rip.TimeoutSeconds <= rip.UpdateIntervalSeconds {
+		return fmt.Errorf("routing.rip.timeout_seconds must be greater than update_interval_seconds")
+	}
+	if rip.GarbageSeconds <= 0 {
+		return fmt.Errorf("routing.rip.garbage_seconds must be positive")
+	}
+	for i, network := range rip.Networks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("routing.rip.networks[%d] is invalid", i)
+		}
+	}
+	if len(rip.Interfaces) == 0 {
+		return fmt.Errorf("routing.rip.interfaces is required when rip is enabled")
+	}
+	configured := map[string]struct{}{}
+	for _, iface := range cfg.Interfaces {
+		configured[iface.Name] = struct{}{}
+	}
+	seen := map[string]struct{}{}
+	for i, iface := range rip.Interfaces {
+		path := fmt.Sprintf("routing.rip.interfaces[%d]", i)
+		if _, ok := configured[iface.Name]; !ok {
+			return fmt.Errorf("%s.name %q is not a configured interface", path, iface.Name)
+		}
+		if _, ok := seen[iface.Name]; ok {
+			return fmt.Errorf("%s.name is duplicated", path)
+		}
+		seen[iface.Name] = struct{}{}
+		switch iface.Version {
+		case "2", "ng", "both":
+		default:
+			return fmt.Errorf("%s.version must be 2, ng or both", path)
+		}
+		if iface.Cost < 1 || iface.Cost > 15 {
+			return fmt.Errorf("%s.cost must be 1-15", path)
+		}
+		switch iface.SplitHorizon {
+		case "none", "simple", "poison_reverse":
+		default:
+			return fmt.Errorf("%s.split_horizon must be none, simple or poison_reverse", path)
+		}
+		if iface.AuthKey != "" {
+			if iface.Version == "ng" {
+				return fmt.Errorf("%s.auth_key is not supported by ripng", path)
+			}
+			if len(iface.AuthKey) > 16 {
+				return fmt.Errorf("%s.auth_key must be at most 16 bytes", path)
+			}
+		}
+		if iface.AuthKeyID < 0 || iface.AuthKeyID > 255 {
+			return fmt.Errorf("%s.auth_key_id must be 0-255", path)
+		}
+	}
+	return nil
+}
+
+func validateBGPPolicy(path string, policy BGPPolicyConfig) error {
+	switch strings.ToLower(policy.DefaultAction) {
+	case "", "accept", "reject":
+	default:
+		return fmt.Errorf("%s.default_action must be accept or reject", path)
+	}
+	for i, term := range policy.Terms {
+		_, prefix, err := net.ParseCIDR(term.Prefix)
+		if err != nil {
+			return fmt.Errorf("%s.terms[%d].prefix is invalid", path, i)
+		}
+		switch strings.ToLower(term.Action) {
+		case "", "accept", "reject":
+		default:
+			return fmt.Errorf("%s.terms[%d].action must be accept or reject", path, i)
+		}
+		ones, bits := prefix.Mask.Size()
+		if term.GE != 0 && (term.GE < ones || term.GE > bits) || term.LE != 0 && (term.LE < ones || term.LE > bits) || term.GE != 0 && term.LE != 0 && term.GE > term.LE {
+			return fmt.Errorf("%s.terms[%d] ge/le out of range", path, i)
+		}
+		if term.Prepend < 0 || term.Prepend > 10 {
+			return fmt.Errorf("%s.terms[%d].prepend must be 0-10", path, i)
+		}
+	}
+	return nil
+}
+
+func filterAction(action string) string {
+	action = strings.ToLower(strings.TrimSpace(action))
+	if action == "" {
+		return "permit"
+	}
+	return action
+}
+
+func validateRouteFilters(cfg *Config) error {
+	lists := map[string]struct{}{}
+	for i, list := range cfg.Routing.PrefixLists {
+		path := fmt.Sprintf("routing.prefix_lists[%d]", i)
+		if list.Name == "" {
+			return fmt.Errorf("%s.name is required", path)
+		}
+		if _, ok := lists[list.Name]; ok {
+			return fmt.Errorf("%s.name %q is duplicated", path, list.Name)
+		}
+		lists[list.Name] = struct{}{}
+		seqs := map[int]struct{}{}
+		for j, entry := range list.Entries {
+			entryPath := fmt.Sprintf("%s.entries[%d]", path, j)
+			if _, ok := seqs[entry.Seq]; ok {
+				return fmt.Errorf("%s.seq %d is duplicated", entryPath, entry.Seq)
+			}
+			seqs[entry.Seq] = struct{}{}
+			if entry.Action != "permit" && entry.Action != "deny" {
+				return fmt.Errorf("%s.action must be permit or deny", entryPath)
+			}
+			_, prefix, err := net.ParseCIDR(entry.Prefix)
+			if err != nil {
+				return fmt.Errorf("%s.prefix is invalid", entryPath)
+			}
+			ones, bits := prefix.Mask.Size()
+			if entry.GE != 0 && (entry.GE < ones || entry.GE > bits) || entry.LE != 0 && (entry.LE < ones || entry.LE > bits) || entry.GE != 0 && entry.LE != 0 && entry.GE > entry.LE {
+				return fmt.Errorf("%s ge/le out of range", entryPath)
+			}
+		}
+	}
+	maps := map[string]struct{}{}
+	for i, m := range cfg.Routing.RouteMaps {
+		path := fmt.Sprintf("routing.route_maps[%d]", i)
+		if m.Name == "" {
+			return fmt.Errorf("%s.name is required", path)
+		}
+		if _, ok := maps[m.Name]; ok {
+			return fmt.Errorf("%s.name %q is duplicated", path, m.Name)
+		}
+		maps[m.Name] = struct{}{}
+		seqs := map[int]struct{}{}
+		for j, entry := range m.Entries {
+			entryPath := fmt.Sprintf("%s.entries[%d]", path, j)
+			if _, ok := seqs[entry.Seq]; ok {
+				return fmt.Errorf("%s.seq %d is duplicated", entryPath, entry.Seq)
+			}
+			seqs[entry.Seq] = struct{}{}
+			if entry.Action != "permit" && entry.Action != "deny" {
+				return fmt.Errorf("%s.action must be permit or deny", entryPath)
+			}
+			if entry.MatchPrefixList != "" {
+				if _, ok := lists[entry.MatchPrefixList]; !ok {
+					return fmt.Errorf("%s.match_prefix_list %q is not defined", entryPath, entry.MatchPrefixList)
+				}
+			}
+			if entry.SetMetric != nil && *entry.SetMetric < 0 {
+				return fmt.Errorf("%s.set_metric must be >= 0", entryPath)
+			}
+		}
+	}
+	for _, ref := range []struct{ path, name string }{
+		{"p2p.import_route_map", cfg.P2P.ImportRouteMap},
+		{"p2p.export_route_map", cfg.P2P.ExportRouteMap},
+	} {
+		if ref.name == "" {
+			continue
+		}
+		if _, ok := maps[ref.name]; !ok {
+			return fmt.Errorf("%s %q is not defined", ref.path, ref.name)
+		}
+	}
+	return nil
+}
+
 func applyDefaults(cfg *Config) {
 	if cfg.API.Address == "" {
 		cfg.API.Address = ":8080"
@@ -310,6 +964,109 @@ func applyDefaults(cfg *Config) {
 	if cfg.Performance.EgressIdleSleepMillis == 0 {
 		cfg.Performance.EgressIdleSleepMillis = 2
 	}
+	if cfg.Routing.MonitorIntervalSeconds == 0 {
+		cfg.Routing.MonitorIntervalSeconds = 5
+	}
+	if cfg.Routing.ProbeTimeoutSeconds == 0 {
+		cfg.Routing.ProbeTimeoutSeconds = 2
+	}
+	if cfg.Routing.ProbeFall == 0 {
+		cfg.Routing.ProbeFall = 3
+	}
+	if cfg.Routing.ProbeRise == 0 {
+		cfg.Routing.ProbeRise = 2
+	}
+	if cfg.Routing.BGP.ListenAddress == "" {
+		cfg.Routing.BGP.ListenAddress = ":179"
+	}
+	if cfg.Routing.BGP.HoldTimeSeconds == 0 {
+		cfg.Routing.BGP.HoldTimeSeconds = 90
+	}
+	if cfg.Routing.BGP.ConnectRetrySeconds == 0 {
+		cfg.Routing.BGP.ConnectRetrySeconds = 5
+	}
+	if cfg.Routing.OSPF.Area == "" {
+		cfg.Routing.OSPF.Area = "0.0.0.0"
+	}
+	for i := range cfg.Routing.OSPF.Interfaces {
+		iface := &cfg.Routing.OSPF.Interfaces[i]
+		if iface.NetworkType == "" {
+			iface.NetworkType = "broadcast"
+		}
+		if iface.Cost == 0 {
+			iface.Cost = 10
+		}
+		if iface.Priority == nil {
+			priority := 1
+			iface.Priority = &priority
+		}
+		if iface.HelloIntervalSeconds == 0 {
+			iface.HelloIntervalSeconds = 10
+		}
+		if iface.DeadIntervalSeconds == 0 {
+			iface.DeadIntervalSeconds = 4 * iface.HelloIntervalSeconds
+		}
+		if iface.RetransmitIntervalSeconds == 0 {
+			iface.RetransmitIntervalSeconds = 5
+		}
+	}
+	if cfg.Routing.RIP.UpdateIntervalSeconds == 0 {
+		cfg.Routing.RIP.UpdateIntervalSeconds = 30
+	}
+	if cfg.Routing.RIP.TimeoutSeconds == 0 {
+		cfg.Routing.RIP.TimeoutSeconds = 6 * cfg.Routing.RIP.UpdateIntervalSeconds
+	}
+	if cfg.Routing.RIP.GarbageSeconds == 0 {
+		cfg.Routing.RIP.GarbageSeconds = 4 * cfg.Routing.RIP.UpdateIntervalSeconds
+	}
+	for i := range cfg.Routing.RIP.Interfaces {
+		iface := &cfg.Routing.RIP.Interfaces[i]
+		if iface.Version == "" {
+			iface.Version = "2"
+		}
+		if iface.Cost == 0 {
+			iface.Cost = 1
+		}
+		if iface.SplitHorizon == "" {
+			iface.SplitHorizon = "poison_reverse"
+		}
+	}
+	for i := range cfg.Routing.PrefixLists {
+		entries := cfg.Routing.PrefixLists[i].Entries
+		for j := range entries {
+			if entries[j].Seq == 0 {
+				entries[j].Seq = (j + 1) * 10
+			}
+			entries[j].Action = filterAction(entries[j].Action)
+		}
+	}
+	for i := range cfg.Routing.RouteMaps {
+		entries := cfg.Routing.RouteMaps[i].Entries
+		for j := range entries {
+			if entries[j].Seq == 0 {
+				entries[j].Seq = (j + 1) * 10
+			}
+			entries[j].Action = filterAction(entries[j].Action)
+		}
+	}
+	for i := range cfg.Routes {
+		applyBFDDefaults(cfg.Routes[i].BFD)
+	}
+	for i := range cfg.Routing.BGP.Neighbors {
+		applyBFDDefaults(cfg.Routing.BGP.Neighbors[i].BFD)
+	}
+	for i := range cfg.HA.BFD {
+		applyBFDDefaults(&cfg.HA.BFD[i])
+	}
+	if cfg.NFTables.Table == "" {
+		cfg.NFTables.Table = "routergo"
+	}
+	if cfg.NFTables.Binary == "" {
+		cfg.NFTables.Binary = "nft"
+	}
+	if cfg.NFTables.CounterIntervalSeconds == 0 {
+		cfg.NFTables.CounterIntervalSeconds = 5
+	}
 	if cfg.Observability.TracesLimit == 0 {
 		cfg.Observability.TracesLimit = 1000
 	}
@@ -414,15 +1171,508 @@ func applyDefaults(cfg *Config) {
 	}
 }
 
+func validateFirewallRules(path string, rules []FirewallRuleConfig) error {
+	ids := map[uint64]struct{}{}
+	for i, rule := range rules {
+		if err := validateRuleID(fmt.Sprintf("%s[%d]", path, i), rule.ID, ids); err != nil {
+			return err
+		}
+		for _, state := range strings.Split(rule.CTState, ",") {
+			switch strings.ToLower(strings.TrimSpace(state)) {
+			case "", "new", "established", "related", "invalid":
+			default:
+				return fmt.Errorf("%s[%d].ct_state %q is invalid", path, i, strings.TrimSpace(state))
+			}
+		}
+		if err := validatePortLists(fmt.Sprintf("%s[%d]", path, i), rule.SrcPorts, rule.DstPorts); err != nil {
+			return err
+		}
+		if rule.LogRate < 0 {
+			return fmt.Errorf("%s[%d].log_rate must be >= 0", path, i)
+		}
+		if rule.RateLimit < 0 || rule.RateBurst < 0 || rule.ConnLimit < 0 {
+			return fmt.Errorf("%s[%d].rate_limit, rate_burst and conn_limit must be >= 0", path, i)
+		}
+		if rule.LimitPrefix < 0 || rule.LimitPrefix > 128 {
+			return fmt.Errorf("%s[%d].limit_prefix must be between 0 and 128", path, i)
+		}
+		if _, err := network.ParseCountryMatch(rule.SrcCountry); err != nil {
+			return fmt.Errorf("%s[%d].src_country: %w", path, i, err)
+		}
+		if _, err := network.ParseCountryMatch(rule.DstCountry); err != nil {
+			return fmt.Errorf("%s[%d].dst_country: %w", path, i, err)
+		}
+		if _, err := network.ParseASNMatch(rule.SrcASN); err != nil {
+			return fmt.Errorf("%s[%d].src_asn: %w", path, i, err)
+		}
+	}
+	return validateFirewallChains(path, rules)
+}
+
+// validateFirewallChains checks that JUMP and GOTO rules lead to a chain
+// with rules and that no chain leads back to itself.
+func validateFirewallChains(path string, rules []FirewallRuleConfig) error {
+	chains := map[string]struct{}{}
+	fwRules := make([]firewall.Rule, 0, len(rules))
+	for _, rule := range rules {
+		chains[strings.ToUpper(strings.TrimSpace(rule.Chain))] = struct{}{}
+		fwRules = append(fwRules, firewall.Rule{
+			Chain:    rule.Chain,
+			Action:   firewall.Action(rule.Action),
+			Target:   rule.Target,
+			Disabled: rule.Disabled,
+			FromZone: rule.From,
+			ToZone:   rule.To,
+		})
+	}
+	if err := firewall.CheckChains(fwRules); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for i, rule := range fwRules {
+		if rule.Action != firewall.ActionJump && rule.Action != firewall.ActionGoto {
+			continue
+		}
+		if _, ok := chains[strings.ToUpper(strings.TrimSpace(rule.Target))]; !ok {
+			return fmt.Errorf("%s[%d].target chain %q has no rules", path, i, rule.Target)
+		}
+	}
+	return nil
+}
+
+// validateGeoRules checks that firewall rules matching on country or ASN
+// have a local database to match against.
+func validateGeoRules(cfg *Config) error {
+	geo := cfg.Integrations.GeoIP
+	check := func(path string, rules []FirewallRuleConfig) error {
+		for i, rule := range rules {
+			if (rule.SrcCountry != "" || rule.DstCountry != "") && (!geo.Enabled || geo.MMDBPath == "") {
+				return fmt.Errorf("%s[%d] matches on country and needs integrations.geoip enabled with mmdb_path", path, i)
+			}
+			if rule.SrcASN != "" && (!geo.Enabled || geo.ASNMMDBPath == "") {
+				return fmt.Errorf("%s[%d] matches on ASN and needs integrations.geoip enabled with asn_mmdb_path", path, i)
+			}
+		}
+		return nil
+	}
+	if err := check("firewall", cfg.Firewall); err != nil {
+		return err
+	}
+	if cfg.NFTables.Enabled {
+		// The nftables backend refuses to load such rules rather than
+		// leave them unenforced.
+		for i, rule := range cfg.Firewall {
+			if !rule.Disabled && (rule.SrcCountry != "" || rule.DstCountry != "" || rule.SrcASN != "") {
+				return fmt.Errorf("firewall[%d] matches on country or ASN, which the nftables backend cannot enforce", i)
+			}
+		}
+	}
+	for i, vrf := range cfg.VRFs {
+		if err := check(fmt.Sprintf("vrfs[%d].firewall", i), vrf.Firewall); err != nil {
+			return err
+		}
+	}
+	if geo.CacheSize < 0 {
+		return fmt.Errorf("integrations.geoip.cache_size must be >= 0")
+	}
+	return nil
+}
+
+func validateNATRules(path string, rules []NATRuleConfig) error {
+	ids := map[uint64]struct{}{}
+	for i, rule := range rules {
+		if err := validateRuleID(fmt.Sprintf("%s[%d]", path, i), rule.ID, ids); err != nil {
+			return err
+		}
+		if err := validatePortLists(fmt.Sprintf("%s[%d]", path, i), rule.SrcPorts, rule.DstPorts); err != nil {
+			return err
+		}
+		if rule.ToIP != "" && net.ParseIP(rule.ToIP) == nil {
+			return fmt.Errorf("%s[%d].to_ip %q is invalid", path, i, rule.ToIP)
+		}
+		if strings.EqualFold(strings.TrimSpace(rule.Type), "DNAT") && rule.ToIP == "" && rule.ToPort == 0 {
+			return fmt.Errorf("%s[%d]: DNAT needs to_ip or to_port", path, i)
+		}
+	}
+	return nil
+}
+
+// validateRuleID rejects an id already used in the same rule list; rules
+// without one get an id when loaded.
+func validateRuleID(path string, id uint64, seen map[uint64]struct{}) error {
+	if id == 0 {
+		return nil
+	}
+	if _, ok := seen[id]; ok {
+		return fmt.Errorf("%s.id %d is duplicated", path, id)
+	}
+	seen[id] = struct{}{}
+	return nil
+}
+
+func validateFirewallZones(prefix string, zones []FirewallZoneConfig, policies []ZonePolicyConfig, rules []FirewallRuleConfig) error {
+	names := map[string]struct{}{}
+	owners := map[string]string{}
+	for i, zone := range zones {
+		name := strings.ToLower(strings.TrimSpace(zone.Name))
+		if name == "" {
+			return fmt.Errorf("%sfirewall_zones[%d].name is required", prefix, i)
+		}
+		if _, ok := names[name]; ok {
+			return fmt.Errorf("%sfirewall_zones[%d].name %q is duplicated", prefix, i, zone.Name)
+		}
+		names[name] = struct{}{}
+		for _, iface := range zone.Interfaces {
+			if owner, ok := owners[iface]; ok {
+				return fmt.Errorf("%sfirewall_zones[%d] interface %q already belongs to zone %q", prefix, i, iface, owner)
+			}
+			owners[iface] = zone.Name
+		}
+		for _, action := range []string{zone.Input, zone.Output, zone.Forward} {
+			if action != "" && !validFirewallAction(action) {
+				return fmt.Errorf("%sfirewall_zones[%d] policy %q is invalid", prefix, i, action)
+			}
+		}
+	}
+	zoneDefined := func(name string) bool {
+		_, ok := names[strings.ToLower(strings.TrimSpace(name))]
+		return ok
+	}
+	for i, policy := range policies {
+		if !zoneDefined(policy.From) || !zoneDefined(policy.To) {
+			return fmt.Errorf("%sfirewall_zone_policies[%d] references an undefined zone", prefix, i)
+		}
+		if !validFirewallAction(policy.Action) {
+			return fmt.Errorf("%sfirewall_zone_policies[%d].action %q is invalid", prefix, i, policy.Action)
+		}
+	}
+	for i, rule := range rules {
+		if rule.From != "" && !zoneDefined(rule.From) {
+			return fmt.Errorf("%sfirewall[%d].from zone %q is not defined", prefix, i, rule.From)
+		}
+		if rule.To != "" && !zoneDefined(rule.To) {
+			return fmt.Errorf("%sfirewall[%d].to zone %q is not defined", prefix, i, rule.To)
+		}
+	}
+	return nil
+}
+
+func validFirewallAction(action string) bool {
+	switch strings.ToUpper(strings.TrimSpace(action)) {
+	case "ACCEPT", "DROP", "REJECT":
+		return true
+	default:
+		return false
+	}
+}
+
+func validatePortLists(path, src, dst string) error {
+	if _, err := network.ParsePortMatch(src); err != nil {
+		return fmt.Errorf("%s.src_ports: %w", path, err)
+	}
+	if _, err := network.ParsePortMatch(dst); err != nil {
+		return fmt.Errorf("%s.dst_ports: %w", path, err)
+	}
+	return nil
+}
+
+// validateIPSets checks the set definitions and that every src_set/dst_set
+// reference names a defined set.
+func validateIPSets(cfg *Config) error {
+	names := map[string]struct{}{}
+	for i, set := range cfg.IPSets {
+		if !ipset.ValidName(set.Name) {
+			return fmt.Errorf("ip_sets[%d].name %q is invalid", i, set.Name)
+		}
+		if _, ok := names[set.Name]; ok {
+			return fmt.Errorf("ip_sets[%d].name %q is duplicated", i, set.Name)
+		}
+		names[set.Name] = struct{}{}
+		typ, err := ipset.ParseType(set.Type)
+		if err != nil {
+			return fmt.Errorf("ip_sets[%d].type %q is invalid", i, set.Type)
+		}
+		if set.TimeoutSeconds < 0 {
+			return fmt.Errorf("ip_sets[%d].timeout_seconds must be >= 0", i)
+		}
+		for j, entry := range set.Entries {
+			if _, err := ipset.ParseMember(typ, entry); err != nil {
+				return fmt.Errorf("ip_sets[%d].entries[%d]: %v", i, j, err)
+			}
+		}
+	}
+	check := func(path, src, dst string) error {
+		for _, ref := range []struct{ field, value string }{{"src_set", src}, {"dst_set", dst}} {
+			if strings.TrimSpace(ref.value) == "" {
+				continue
+			}
+			name, _ := ipset.ParseRef(ref.value)
+			if _, ok := names[name]; !ok {
+				return fmt.Errorf("%s.%s %q is not defined", path, ref.field, name)
+			}
+		}
+		return nil
+	}
+	for i, rule := range cfg.Firewall {
+		if err := check(fmt.Sprintf("firewall[%d]", i), rule.SrcSet, rule.DstSet); err != nil {
+			return err
+		}
+	}
+	for i, rule := range cfg.NAT {
+		if err := check(fmt.Sprintf("nat[%d]", i), rule.SrcSet, rule.DstSet); err != nil {
+			return err
+		}
+	}
+	for i, class := range cfg.QoS {
+		if err := check(fmt.Sprintf("qos[%d]", i), class.SrcSet, class.DstSet); err != nil {
+			return err
+		}
+	}
+	for i, vrf := range cfg.VRFs {
+		for j, rule := range vrf.Firewall {
+			if err := check(fmt.Sprintf("vrfs[%d].firewall[%d]", i, j), rule.SrcSet, rule.DstSet); err != nil {
+				return err
+			}
+		}
+		for j, rule := range vrf.NAT {
+			if err := check(fmt.Sprintf("vrfs[%d].nat[%d]", i, j), rule.SrcSet, rule.DstSet); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// validateSchedules checks the schedule definitions and that every schedule
+// reference names a defined schedule.
+func validateSchedules(cfg *Config) error {
+	names := map[string]struct{}{}
+	for i, s := range cfg.Schedules {
+		if !schedule.ValidName(s.Name) {
+			return fmt.Errorf("schedules[%d].name %q is invalid", i, s.Name)
+		}
+		if _, ok := names[s.Name]; ok {
+			return fmt.Errorf("schedules[%d].name %q is duplicated", i, s.Name)
+		}
+		names[s.Name] = struct{}{}
+		if _, err := schedule.ParseDays(s.Days); err != nil {
+			return fmt.Errorf("schedules[%d].days: %w", i, err)
+		}
+		for j, value := range s.Times {
+			if _, err := schedule.ParseRange(value); err != nil {
+				return fmt.Errorf("schedules[%d].times[%d]: %w", i, j, err)
+			}
+		}
+	}
+	check := func(path, name string) error {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return nil
+		}
+		if _, ok := names[name]; !ok {
+			return fmt.Errorf("%s.schedule %q is not defined", path, name)
+		}
+		return nil
+	}
+	for i, rule := range cfg.Firewall {
+		if err := check(fmt.Sprintf("firewall[%d]", i), rule.Schedule); err != nil {
+			return err
+		}
+	}
+	for i, rule := range cfg.NAT {
+		if err := check(fmt.Sprintf("nat[%d]", i), rule.Schedule); err != nil {
+			return err
+		}
+	}
+	for i, class := range cfg.QoS {
+		if err := check(fmt.Sprintf("qos[%d]", i), class.Schedule); err != nil {
+			return err
+		}
+	}
+	for i, vrf := range cfg.VRFs {
+		for j, rule := range vrf.Firewall {
+			if err := check(fmt.Sprintf("vrfs[%d].firewall[%d]", i, j), rule.Schedule); err != nil {
+				return err
+			}
+		}
+		for j, rule := range vrf.NAT {
+			if err := check(fmt.Sprintf("vrfs[%d].nat[%d]", i, j), rule.Schedule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateConntrack(ct ConntrackConfig) error {
+	if ct.MaxEntries < 0 {
+		return fmt.Errorf("conntrack.max_entries must be >= 0")
+	}
+	t := ct.Timeouts
+	for _, v := range []int{t.TCPEstablished, t.TCPTransitory, t.TCPClose, t.UDP, t.UDPStream, t.ICMP, t.Generic} {
+		if v < 0 {
+			return fmt.Errorf("conntrack.timeouts must be >= 0")
+		}
+	}
+	return nil
+}
+
+func applyBFDDefaults(bfd *BFDConfig) {
+	if bfd == nil {
+		return
+	}
+	if bfd.MinTxMillis == 0 {
+		bfd.MinTxMillis = 300
+	}
+	if bfd.MinRxMillis == 0 {
+		bfd.MinRxMillis = 300
+	}
+	if bfd.Multiplier == 0 {
+		bfd.Multiplier = 3
+	}
+}
+
 func validate(cfg *Config) error {
 	for i, iface := range cfg.Interfaces {
 		if iface.Name == "" {
 			return fmt.Errorf("interface[%d].name is required", i)
 		}
 	}
-	for i, route := range cfg.Routes {
-		if route.Destination == "" {
-			return fmt.Errorf("routes[%d].destination is required", i)
+	if err := validateRoutes("routes", cfg.Routes); err != nil {
+		return err
+	}
+	if err := validateFirewallRules("firewall", cfg.Firewall); err != nil {
+		return err
+	}
+	if err := validateFirewallZones("", cfg.FirewallZones, cfg.ZonePolicies, cfg.Firewall); err != nil {
+		return err
+	}
+	if err := validateIPSets(cfg); err != nil {
+		return err
+	}
+	if err := validateSchedules(cfg); err != nil {
+		return err
+	}
+	if err := validateGeoRules(cfg); err != nil {
+		return err
+	}
+	if err := validateConntrack(cfg.Conntrack); err != nil {
+		return err
+	}
+	if err := validateNATRules("nat", cfg.NAT); err != nil {
+		return err
+	}
+	for i, class := range cfg.QoS {
+		if err := validatePortLists(fmt.Sprintf("qos[%d]", i), class.SrcPorts, class.DstPorts); err != nil {
+			return err
+		}
+	}
+	vrfs := map[string]struct{}{"default": {}}
+	for i, vrf := range cfg.VRFs {
+		if vrf.Name == "" {
+			return fmt.Errorf("vrfs[%d].name is required", i)
+		}
+		if _, ok := vrfs[vrf.Name]; ok {
+			return fmt.Errorf("vrfs[%d].name %q is duplicated", i, vrf.Name)
+		}
+		vrfs[vrf.Name] = struct{}{}
+		if err := validateRoutes(fmt.Sprintf("vrfs[%d].routes", i), vrf.Routes); err != nil {
+			return err
+		}
+		if err := validateFirewallRules(fmt.Sprintf("vrfs[%d].firewall", i), vrf.Firewall); err != nil {
+			return err
+		}
+		if err := validateFirewallZones(fmt.Sprintf("vrfs[%d].", i), vrf.FirewallZones, vrf.ZonePolicies, vrf.Firewall); err != nil {
+			return err
+		}
+		if err := validateNATRules(fmt.Sprintf("vrfs[%d].nat", i), vrf.NAT); err != nil {
+			return err
+		}
+	}
+	for i, iface := range cfg.Interfaces {
+		if iface.VRF == "" {
+			continue
+		}
+		if _, ok := vrfs[iface.VRF]; !ok {
+			return fmt.Errorf("interface[%d].vrf %q is not defined", i, iface.VRF)
+		}
+	}
+	for i, leak := range cfg.RouteLeaks {
+		if leak.Destination == "" {
+			return fmt.Errorf("route_leaks[%d].destination is required", i)
+		}
+		from, to := leak.FromVRF, leak.ToVRF
+		if from == "" {
+			from = "default"
+		}
+		if to == "" {
+			to = "default"
+		}
+		if _, ok := vrfs[from]; !ok {
+			return fmt.Errorf("route_leaks[%d].from_vrf %q is not defined", i, from)
+		}
+		if _, ok := vrfs[to]; !ok {
+			return fmt.Errorf("route_leaks[%d].to_vrf %q is not defined", i, to)
+		}
+		if from == to {
+			return fmt.Errorf("route_leaks[%d] must leak between different vrfs", i)
+		}
+	}
+	tables := map[string]struct{}{"main": {}}
+	for i, table := range cfg.Routing.Tables {
+		if table.Name == "" {
+			return fmt.Errorf("routing.tables[%d].name is required", i)
+		}
+		if _, ok := tables[table.Name]; ok {
+			return fmt.Errorf("routing.tables[%d].name %q is duplicated", i, table.Name)
+		}
+		tables[table.Name] = struct{}{}
+		if err := validateRoutes(fmt.Sprintf("routing.tables[%d].routes", i), table.Routes); err != nil {
+			return err
+		}
+	}
+	priorities := map[int]struct{}{}
+	for i, rule := range cfg.Routing.Rules {
+		if _, ok := tables[rule.Table]; !ok {
+			return fmt.Errorf("routing.rules[%d].table %q is not defined", i, rule.Table)
+		}
+		if _, ok := priorities[rule.Priority]; ok {
+			return fmt.Errorf("routing.rules[%d].priority %d is duplicated", i, rule.Priority)
+		}
+		priorities[rule.Priority] = struct{}{}
+		if rule.DSCP != nil && (*rule.DSCP < 0 || *rule.DSCP > 63) {
+			return fmt.Errorf("routing.rules[%d].dscp must be 0-63", i)
+		}
+	}
+	if err := validateRouteFilters(cfg); err != nil {
+		return err
+	}
+	if cfg.Routing.RouterID != "" {
+		if ip := net.ParseIP(cfg.Routing.RouterID); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("routing.router_id must be an IPv4 address")
+		}
+	}
+	if cfg.Routing.BGP.Enabled {
+		if err := validateBGP(cfg.Routing); err != nil {
+			return err
+		}
+	}
+	if cfg.Routing.OSPF.Enabled {
+		if err := validateOSPF(cfg); err != nil {
+			return err
+		}
+	}
+	if cfg.Routing.RIP.Enabled {
+		if err := validateRIP(cfg); err != nil {
+			return err
+		}
+	}
+	for i, peer := range cfg.HA.BFD {
+		path := fmt.Sprintf("ha.bfd[%d]", i)
+		if peer.Peer == "" {
+			return fmt.Errorf("%s.peer is required", path)
+		}
+		if err := validateBFD(path, peer); err != nil {
+			return err
 		}
 	}
 	validRoles := map[string]struct{}{
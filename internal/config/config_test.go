@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -37,6 +38,9 @@ security:
 	if cfg.Performance.EgressBatchSize != 16 {
 		t.Fatalf("expected default egress batch size, got %d", cfg.Performance.EgressBatchSize)
 	}
+	if cfg.NFTables.Table != "routergo" || cfg.NFTables.Binary != "nft" {
+		t.Fatalf("expected default nftables table/binary, got %q/%q", cfg.NFTables.Table, cfg.NFTables.Binary)
+	}
 	if cfg.Observability.TracesLimit != 1000 {
 		t.Fatalf("expected default traces limit, got %d", cfg.Observability.TracesLimit)
 	}
@@ -92,6 +96,920 @@ routes:
 	}
 }
 
+func TestLoadFromBytesRouteDistance(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+routes:
+  - destination: 0.0.0.0/0
+    gateway: 192.0.2.1
+    distance: 250
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Routes[0].Distance != 250 {
+		t.Fatalf("unexpected distance: %d", cfg.Routes[0].Distance)
+	}
+	_, err = LoadFromBytes([]byte(`
+interfaces:
+  - name: eth0
+routes:
+  - destination: 0.0.0.0/0
+    gateway: 192.0.2.1
+    distance: 256
+`))
+	if err == nil {
+		t.Fatalf("expected error for out of range distance")
+	}
+}
+
+func TestLoadFromBytesRouteFilters(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+routing:
+  prefix_lists:
+    - name: no-default
+      entries:
+        - action: deny
+          prefix: 0.0.0.0/0
+        - prefix: 0.0.0.0/0
+          le: 32
+  route_maps:
+    - name: p2p-in
+      entries:
+        - match_prefix_list: no-default
+          set_tag: 100
+p2p:
+  import_route_map: p2p-in
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	entries := cfg.Routing.PrefixLists[0].Entries
+	if entries[0].Seq != 10 || entries[0].Action != "deny" || entries[1].Seq != 20 || entries[1].Action != "permit" {
+		t.Fatalf("unexpected prefix list defaults: %+v", entries)
+	}
+	if entry := cfg.Routing.RouteMaps[0].Entries[0]; entry.SetTag == nil || *entry.SetTag != 100 {
+		t.Fatalf("unexpected route map entry: %+v", entry)
+	}
+
+	_, err = LoadFromBytes([]byte(`
+interfaces:
+  - name: eth0
+p2p:
+  export_route_map: missing
+`))
+	if err == nil {
+		t.Fatalf("expected error for unknown route map")
+	}
+	_, err = LoadFromBytes([]byte(`
+interfaces:
+  - name: eth0
+routing:
+  route_maps:
+    - name: m
+      entries:
+        - match_prefix_list: missing
+`))
+	if err == nil {
+		t.Fatalf("expected error for unknown prefix list")
+	}
+}
+
+func TestLoadFromBytesConntrack(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+conntrack:
+  enabled: true
+  timeouts:
+    udp: 60
+firewall:
+  - chain: FORWARD
+    action: ACCEPT
+    ct_state: established,related
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !cfg.Conntrack.Enabled || cfg.Conntrack.Timeouts.UDP != 60 || cfg.Firewall[0].CTState != "established,related" {
+		t.Fatalf("unexpected conntrack config: %+v %+v", cfg.Conntrack, cfg.Firewall)
+	}
+
+	_, err = LoadFromBytes([]byte(`
+interfaces:
+  - name: eth0
+vrfs:
+  - name: blue
+    firewall:
+      - chain: FORWARD
+        action: ACCEPT
+        ct_state: established,untracked
+`))
+	if err == nil {
+		t.Fatalf("expected error for unknown ct_state")
+	}
+}
+
+func TestLoadFromBytesPortLists(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+firewall:
+  - chain: INPUT
+    action: ACCEPT
+    src_ip: "!10.0.0.0/8"
+    dst_ports: "80,443,8000-8080"
+nat:
+  - type: SNAT
+    src_ports: "1024-65535"
+qos:
+  - name: voice
+    dst_ports: "5060,10000-20000"
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Firewall[0].DstPorts != "80,443,8000-8080" || cfg.NAT[0].SrcPorts != "1024-65535" || cfg.QoS[0].DstPorts != "5060,10000-20000" {
+		t.Fatalf("unexpected port lists: %+v %+v %+v", cfg.Firewall, cfg.NAT, cfg.QoS)
+	}
+
+	for _, bad := range []string{
+		"firewall:\n  - chain: INPUT\n    dst_ports: \"2000-1000\"\n",
+		"nat:\n  - type: DNAT\n    dst_ports: \"70000\"\n",
+		"nat:\n  - type: DNAT\n    dst_port: 8080\n",
+		"nat:\n  - type: DNAT\n    dst_port: 8080\n    to_ip: bad\n",
+		"qos:\n  - name: bulk\n    src_ports: \"!\"\n",
+	} {
+		if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\n" + bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesFirewallZones(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+  - name: eth1
+firewall_zones:
+  - name: wan
+    interfaces: [eth0]
+    input: DROP
+  - name: lan
+    interfaces: [eth1, "eth1.*"]
+    input: ACCEPT
+    forward: ACCEPT
+firewall_zone_policies:
+  - from: lan
+    to: wan
+    action: ACCEPT
+firewall:
+  - from: wan
+    to: lan
+    action: DROP
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.FirewallZones) != 2 || cfg.FirewallZones[1].Interfaces[1] != "eth1.*" {
+		t.Fatalf("unexpected zones: %+v", cfg.FirewallZones)
+	}
+	if cfg.ZonePolicies[0].Action != "ACCEPT" || cfg.Firewall[0].From != "wan" || cfg.Firewall[0].To != "lan" {
+		t.Fatalf("unexpected zone policies/rules: %+v %+v", cfg.ZonePolicies, cfg.Firewall)
+	}
+
+	zones := "firewall_zones:\n  - name: wan\n    interfaces: [eth0]\n"
+	for _, bad := range []string{
+		zones + "firewall:\n  - from: dmz\n    action: DROP\n",
+		zones + "firewall_zone_policies:\n  - from: wan\n    to: lan\n    action: DROP\n",
+		zones + "  - name: dmz\n    interfaces: [eth0]\n",
+		zones + "  - name: WAN\n    interfaces: [eth1]\n",
+		"firewall_zones:\n  - name: wan\n    input: MAYBE\n",
+	} {
+		if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\n" + bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesIPSets(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+ip_sets:
+  - name: blocklist
+    timeout_seconds: 3600
+    entries: ["198.51.100.0/24", "2001:db8::/32"]
+  - name: admins
+    type: hash:ip
+    entries: ["192.168.1.10"]
+firewall:
+  - chain: INPUT
+    action: DROP
+    src_set: blocklist
+  - chain: INPUT
+    action: ACCEPT
+    src_set: "!admins"
+nat:
+  - type: SNAT
+    dst_set: blocklist
+qos:
+  - name: admin
+    src_set: admins
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.IPSets) != 2 || cfg.IPSets[0].TimeoutSeconds != 3600 || cfg.IPSets[1].Type != "hash:ip" {
+		t.Fatalf("unexpected ip sets: %+v", cfg.IPSets)
+	}
+	if cfg.Firewall[1].SrcSet != "!admins" || cfg.NAT[0].DstSet != "blocklist" || cfg.QoS[0].SrcSet != "admins" {
+		t.Fatalf("unexpected set references: %+v %+v %+v", cfg.Firewall, cfg.NAT, cfg.QoS)
+	}
+
+	for _, bad := range []string{
+		"ip_sets:\n  - name: \"bad name\"\n",
+		"ip_sets:\n  - name: a\n  - name: a\n",
+		"ip_sets:\n  - name: a\n    type: hash:mac\n",
+		"ip_sets:\n  - name: a\n    type: hash:ip\n    entries: [\"10.0.0.0/8\"]\n",
+		"firewall:\n  - chain: INPUT\n    src_set: missing\n",
+		"vrfs:\n  - name: blue\n    nat:\n      - type: SNAT\n        src_set: \"!missing\"\n",
+	} {
+		if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\n" + bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesSchedules(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+system:
+  timezone: Europe/Moscow
+schedules:
+  - name: school
+    days: [weekdays]
+    times: ["08:00-15:00"]
+  - name: night
+    times: ["22:00-07:00"]
+firewall:
+  - chain: FORWARD
+    action: DROP
+    schedule: school
+nat:
+  - type: DNAT
+    dst_port: 2222
+    to_ip: 192.168.1.50
+    to_port: 22
+    schedule: night
+qos:
+  - name: backup
+    schedule: night
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(cfg.Schedules) != 2 || cfg.Schedules[0].Days[0] != "weekdays" || cfg.Schedules[1].Times[0] != "22:00-07:00" {
+		t.Fatalf("unexpected schedules: %+v", cfg.Schedules)
+	}
+	if cfg.Firewall[0].Schedule != "school" || cfg.NAT[0].Schedule != "night" || cfg.QoS[0].Schedule != "night" {
+		t.Fatalf("unexpected schedule references: %+v %+v %+v", cfg.Firewall, cfg.NAT, cfg.QoS)
+	}
+
+	for _, bad := range []string{
+		"schedules:\n  - name: a\n  - name: a\n",
+		"schedules:\n  - name: a\n    days: [funday]\n",
+		"schedules:\n  - name: a\n    times: [\"8-17\"]\n",
+		"firewall:\n  - chain: INPUT\n    schedule: missing\n",
+		"vrfs:\n  - name: blue\n    firewall:\n      - chain: INPUT\n        schedule: missing\n",
+	} {
+		if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\n" + bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesFirewallLog(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+firewall:
+  - chain: INPUT
+    action: LOG
+    dst_port: 22
+  - chain: INPUT
+    action: DROP
+    protocol: TCP
+    dst_port: 23
+    log: true
+    log_rate: 5
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Firewall[0].Action != "LOG" || !cfg.Firewall[1].Log || cfg.Firewall[1].LogRate != 5 {
+		t.Fatalf("unexpected firewall rules: %+v", cfg.Firewall)
+	}
+	if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\nfirewall:\n  - chain: INPUT\n    log: true\n    log_rate: -1\n")); err == nil {
+		t.Fatalf("expected negative log_rate to be rejected")
+	}
+}
+
+func TestLoadFromBytesFirewallLimits(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+firewall:
+  - chain: INPUT
+    action: DROP
+    protocol: TCP
+    dst_port: 22
+    rate_limit: 3
+    rate_burst: 6
+    limit_prefix: 24
+  - chain: INPUT
+    action: REJECT
+    protocol: TCP
+    dst_port: 22
+    conn_limit: 4
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	first, second := cfg.Firewall[0], cfg.Firewall[1]
+	if first.RateLimit != 3 || first.RateBurst != 6 || first.LimitPrefix != 24 || second.ConnLimit != 4 {
+		t.Fatalf("unexpected firewall rules: %+v", cfg.Firewall)
+	}
+	for _, field := range []string{"rate_limit: -1", "conn_limit: -1", "limit_prefix: 129"} {
+		if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\nfirewall:\n  - chain: INPUT\n    " + field + "\n")); err == nil {
+			t.Fatalf("expected %q to be rejected", field)
+		}
+	}
+}
+
+func TestLoadFromBytesFirewallGeo(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+integrations:
+  geoip:
+    enabled: true
+    mmdb_path: GeoLite2-Country.mmdb
+    asn_mmdb_path: GeoLite2-ASN.mmdb
+    cache_size: 1024
+firewall:
+  - chain: INPUT
+    action: DROP
+    protocol: TCP
+    dst_ports: "22,8443"
+    src_country: "CN,RU"
+  - chain: INPUT
+    action: DROP
+    src_asn: "AS64500"
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Firewall[0].SrcCountry != "CN,RU" || cfg.Firewall[1].SrcASN != "AS64500" || cfg.Integrations.GeoIP.CacheSize != 1024 {
+		t.Fatalf("unexpected config: %+v %+v", cfg.Firewall, cfg.Integrations.GeoIP)
+	}
+
+	for _, field := range []string{"src_country: CHN", "dst_country: \"!\"", "src_asn: AS0"} {
+		if _, err := LoadFromBytes([]byte(strings.Replace(string(data), "src_asn: \"AS64500\"", field, 1))); err == nil {
+			t.Fatalf("expected %q to be rejected", field)
+		}
+	}
+	noASN := strings.Replace(string(data), "asn_mmdb_path: GeoLite2-ASN.mmdb", "", 1)
+	if _, err := LoadFromBytes([]byte(noASN)); err == nil {
+		t.Fatalf("expected an ASN rule without an ASN database to be rejected")
+	}
+	disabled := strings.Replace(noASN, "enabled: true", "enabled: false", 1)
+	disabled = strings.Replace(disabled, "src_asn: \"AS64500\"", "", 1)
+	if _, err := LoadFromBytes([]byte(disabled)); err == nil {
+		t.Fatalf("expected a country rule with geoip disabled to be rejected")
+	}
+	nft := string(data) + "nftables:\n  enabled: true\n"
+	if _, err := LoadFromBytes([]byte(nft)); err == nil || !strings.Contains(err.Error(), "nftables") {
+		t.Fatalf("expected geo rules to be rejected with the nftables backend, got %v", err)
+	}
+	nft = strings.Replace(nft, "src_country: \"CN,RU\"", "src_country: \"CN,RU\"\n    disabled: true", 1)
+	nft = strings.Replace(nft, "src_asn: \"AS64500\"", "src_asn: \"AS64500\"\n    disabled: true", 1)
+	if _, err := LoadFromBytes([]byte(nft)); err != nil {
+		t.Fatalf("expected disabled geo rules to be accepted with the nftables backend: %v", err)
+	}
+}
+
+func TestLoadFromBytesFirewallChains(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+firewall:
+  - chain: INPUT
+    action: JUMP
+    target: ssh-guard
+    protocol: TCP
+    dst_port: 22
+  - chain: ssh-guard
+    action: RETURN
+    src_ip: 10.0.0.0/8
+  - chain: ssh-guard
+    action: DROP
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Firewall[0].Target != "ssh-guard" {
+		t.Fatalf("unexpected firewall rules: %+v", cfg.Firewall)
+	}
+
+	for name, extra := range map[string]string{
+		"loop":     "  - chain: ssh-guard\n    action: GOTO\n    target: audit\n  - chain: audit\n    action: JUMP\n    target: SSH-GUARD\n",
+		"builtin":  "  - chain: ssh-guard\n    action: JUMP\n    target: INPUT\n",
+		"missing":  "  - chain: ssh-guard\n    action: GOTO\n    target: nowhere\n",
+		"notarget": "  - chain: INPUT\n    action: JUMP\n",
+	} {
+		if _, err := LoadFromBytes(append(append([]byte(nil), data...), extra...)); err == nil {
+			t.Fatalf("%s: expected chains to be rejected", name)
+		}
+	}
+}
+
+func TestLoadFromBytesRuleIDs(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+firewall:
+  - id: 10
+    description: ssh from lan
+    chain: INPUT
+    action: ACCEPT
+    dst_port: 22
+  - chain: INPUT
+    action: DROP
+    disabled: true
+nat:
+  - id: 3
+    description: port forward
+    type: DNAT
+    dst_port: 2222
+    to_ip: 192.168.1.50
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if cfg.Firewall[0].ID != 10 || cfg.Firewall[0].Description != "ssh from lan" || !cfg.Firewall[1].Disabled {
+		t.Fatalf("unexpected firewall rules: %+v", cfg.Firewall)
+	}
+	if cfg.NAT[0].ID != 3 || cfg.NAT[0].Description != "port forward" {
+		t.Fatalf("unexpected nat rules: %+v", cfg.NAT)
+	}
+
+	for _, bad := range []string{
+		"firewall:\n  - id: 1\n    chain: INPUT\n  - id: 1\n    chain: OUTPUT\n",
+		"nat:\n  - id: 2\n    type: SNAT\n  - id: 2\n    type: DNAT\n",
+		"vrfs:\n  - name: blue\n    nat:\n      - id: 2\n        type: SNAT\n      - id: 2\n        type: SNAT\n",
+	} {
+		if _, err := LoadFromBytes([]byte("interfaces:\n  - name: eth0\n" + bad)); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesPolicyRouting(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+routing:
+  tables:
+    - name: isp2
+      routes:
+        - destination: 0.0.0.0/0
+          gateway: 198.51.100.1
+  rules:
+    - priority: 100
+      src_ip: 10.0.2.0/24
+      dscp: 0
+      table: isp2
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Routing.Rules) != 1 || cfg.Routing.Rules[0].DSCP == nil || *cfg.Routing.Rules[0].DSCP != 0 {
+		t.Fatalf("expected rule with explicit dscp 0, got %+v", cfg.Routing.Rules)
+	}
+
+	data = []byte(`
+interfaces:
+  - name: eth0
+routing:
+  rules:
+    - priority: 100
+      table: missing
+`)
+	if _, err := LoadFromBytes(data); err == nil {
+		t.Fatalf("expected error for undefined routing table")
+	}
+}
+
+func TestLoadFromBytesVRFs(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+  - name: eth1
+    vrf: tenant
+vrfs:
+  - name: tenant
+    routes:
+      - destination: 192.168.50.0/24
+        interface: eth1
+route_leaks:
+  - from_vrf: tenant
+    destination: 8.8.8.0/24
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.VRFs) != 1 || len(cfg.VRFs[0].Routes) != 1 || len(cfg.RouteLeaks) != 1 {
+		t.Fatalf("unexpected vrf config: %+v %+v", cfg.VRFs, cfg.RouteLeaks)
+	}
+
+	data = []byte(`
+interfaces:
+  - name: eth0
+    vrf: missing
+`)
+	if _, err := LoadFromBytes(data); err == nil {
+		t.Fatalf("expected error for undefined interface vrf")
+	}
+
+	data = []byte(`
+interfaces:
+  - name: eth0
+vrfs:
+  - name: tenant
+route_leaks:
+  - from_vrf: tenant
+    to_vrf: tenant
+    destination: 10.0.0.0/8
+`)
+	if _, err := LoadFromBytes(data); err == nil {
+		t.Fatalf("expected error for leak within the same vrf")
+	}
+}
+
+func TestLoadFromBytesRouteTrack(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+routes:
+  - destination: 0.0.0.0/0
+    gateway: 192.0.2.1
+    interface: eth0
+    metric: 10
+    track:
+      type: icmp
+      target: 8.8.8.8
+      interval_seconds: 2
+      fail_threshold: 3
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Routes[0].Track == nil || cfg.Routes[0].Track.Target != "8.8.8.8" || cfg.Routes[0].Track.FailThreshold != 3 {
+		t.Fatalf("unexpected track config: %+v", cfg.Routes[0].Track)
+	}
+
+	for _, track := range []string{
+		"      type: tcp\n",
+		"      type: dns\n      target: 8.8.8.8\n",
+		"      type: http\n      target: http://example.com\n      rise_threshold: -1\n",
+	} {
+		data := []byte(`
+interfaces:
+  - name: eth0
+routes:
+  - destination: 0.0.0.0/0
+    gateway: 192.0.2.1
+    track:
+` + track)
+		if _, err := LoadFromBytes(data); err == nil {
+			t.Fatalf("expected error for track %q", track)
+		}
+	}
+}
+
+func TestLoadFromBytesBGP(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+routing:
+  router_id: 192.0.2.1
+  bgp:
+    enabled: true
+    asn: 4200000001
+    networks: [203.0.113.0/24]
+    neighbors:
+      - address: 192.0.2.2
+        remote_asn: 65002
+        max_prefixes: 1000
+        import:
+          default_action: accept
+          terms:
+            - prefix: 0.0.0.0/0
+              action: reject
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bgp := cfg.Routing.BGP
+	if bgp.ASN != 4200000001 || bgp.ListenAddress != ":179" || bgp.HoldTimeSeconds != 90 {
+		t.Fatalf("unexpected bgp config: %+v", bgp)
+	}
+	if len(bgp.Neighbors) != 1 || bgp.Neighbors[0].Import.Terms[0].Action != "reject" {
+		t.Fatalf("unexpected neighbors: %+v", bgp.Neighbors)
+	}
+
+	data = []byte(`
+interfaces:
+  - name: eth0
+routing:
+  bgp:
+    enabled: true
+    asn: 65001
+`)
+	if _, err := LoadFromBytes(data); err == nil {
+		t.Fatalf("expected error for bgp without router_id")
+	}
+
+	data = []byte(`
+interfaces:
+  - name: eth0
+routing:
+  router_id: 192.0.2.1
+  bgp:
+    enabled: true
+    asn: 65001
+    neighbors:
+      - address: 192.0.2.2
+        remote_asn: 65002
+        export:
+          terms:
+            - prefix: 10.0.0.0/8
+              le: 4
+`)
+	if _, err := LoadFromBytes(data); err == nil {
+		t.Fatalf("expected error for le shorter than prefix")
+	}
+}
+
+func TestLoadFromBytesOSPF(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+  - name: eth1
+    ip: 10.0.1.1/30
+routing:
+  router_id: 192.0.2.1
+  ospf:
+    enabled: true
+    interfaces:
+      - name: eth0
+        priority: 0
+      - name: eth1
+        network_type: point-to-point
+        cost: 5
+        hello_interval_seconds: 2
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ospf := cfg.Routing.OSPF
+	if ospf.Area != "0.0.0.0" || len(ospf.Interfaces) != 2 {
+		t.Fatalf("unexpected ospf config: %+v", ospf)
+	}
+	lan, p2p := ospf.Interfaces[0], ospf.Interfaces[1]
+	if lan.NetworkType != "broadcast" || lan.Cost != 10 || *lan.Priority != 0 || lan.HelloIntervalSeconds != 10 || lan.DeadIntervalSeconds != 40 {
+		t.Fatalf("unexpected lan defaults: %+v", lan)
+	}
+	if p2p.Cost != 5 || *p2p.Priority != 1 || p2p.DeadIntervalSeconds != 8 {
+		t.Fatalf("unexpected p2p config: %+v", p2p)
+	}
+
+	for _, bad := range []string{`
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  router_id: 192.0.2.1
+  ospf:
+    enabled: true
+    interfaces:
+      - name: eth9
+`, `
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  ospf:
+    enabled: true
+    interfaces:
+      - name: eth0
+`, `
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  router_id: 192.0.2.1
+  ospf:
+    enabled: true
+    interfaces:
+      - name: eth0
+        network_type: nbma
+`} {
+		if _, err := LoadFromBytes([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesRIP(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+  - name: eth1
+    ip: 2001:db8::1/64
+routing:
+  rip:
+    enabled: true
+    networks: [192.168.1.0/24]
+    interfaces:
+      - name: eth0
+        auth_key: secret
+        auth_key_id: 3
+      - name: eth1
+        version: ng
+        cost: 2
+        split_horizon: simple
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rip := cfg.Routing.RIP
+	if rip.UpdateIntervalSeconds != 30 || rip.TimeoutSeconds != 180 || rip.GarbageSeconds != 120 || len(rip.Interfaces) != 2 {
+		t.Fatalf("unexpected rip config: %+v", rip)
+	}
+	v2, ng := rip.Interfaces[0], rip.Interfaces[1]
+	if v2.Version != "2" || v2.Cost != 1 || v2.SplitHorizon != "poison_reverse" || v2.AuthKeyID != 3 {
+		t.Fatalf("unexpected rip v2 defaults: %+v", v2)
+	}
+	if ng.Version != "ng" || ng.Cost != 2 || ng.SplitHorizon != "simple" {
+		t.Fatalf("unexpected ripng config: %+v", ng)
+	}
+
+	for _, bad := range []string{`
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  rip:
+    enabled: true
+    interfaces:
+      - name: eth0
+        version: 1
+`, `
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  rip:
+    enabled: true
+    interfaces:
+      - name: eth0
+        version: ng
+        auth_key: secret
+`, `
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  rip:
+    enabled: true
+    interfaces:
+      - name: eth0
+        cost: 16
+`, `
+interfaces:
+  - name: eth0
+    ip: 10.0.0.1/24
+routing:
+  rip:
+    enabled: true
+    update_interval_seconds: 30
+    timeout_seconds: 20
+    interfaces:
+      - name: eth0
+`} {
+		if _, err := LoadFromBytes([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
+func TestLoadFromBytesBFD(t *testing.T) {
+	data := []byte(`
+interfaces:
+  - name: wan1
+    ip: 192.0.2.2/30
+routes:
+  - destination: 0.0.0.0/0
+    gateway: 192.0.2.1
+    interface: wan1
+    bfd:
+      min_tx_ms: 50
+      min_rx_ms: 50
+routing:
+  router_id: 192.0.2.2
+  bgp:
+    enabled: true
+    asn: 65001
+    neighbors:
+      - address: 192.0.2.1
+        remote_asn: 65002
+        bfd: {}
+ha:
+  bfd:
+    - peer: 10.0.0.2
+      multiplier: 5
+`)
+	cfg, err := LoadFromBytes(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	route := cfg.Routes[0].BFD
+	if route == nil || route.MinTxMillis != 50 || route.MinRxMillis != 50 || route.Multiplier != 3 {
+		t.Fatalf("unexpected route bfd: %+v", route)
+	}
+	neighbor := cfg.Routing.BGP.Neighbors[0].BFD
+	if neighbor == nil || neighbor.MinTxMillis != 300 || neighbor.MinRxMillis != 300 || neighbor.Multiplier != 3 {
+		t.Fatalf("unexpected neighbor bfd: %+v", neighbor)
+	}
+	if len(cfg.HA.BFD) != 1 || cfg.HA.BFD[0].Multiplier != 5 || cfg.HA.BFD[0].MinTxMillis != 300 {
+		t.Fatalf("unexpected ha bfd: %+v", cfg.HA.BFD)
+	}
+
+	for _, bad := range []string{`
+routes:
+  - destination: 10.0.0.0/8
+    interface: wan1
+    bfd: {}
+`, `
+routes:
+  - destination: 10.0.0.0/8
+    gateway: 192.0.2.1
+    track:
+      type: icmp
+    bfd: {}
+`, `
+routes:
+  - destination: 10.0.0.0/8
+    gateway: 192.0.2.1
+    bfd:
+      multiplier: 300
+`, `
+ha:
+  bfd:
+    - min_tx_ms: 100
+`} {
+		if _, err := LoadFromBytes([]byte(bad)); err == nil {
+			t.Fatalf("expected error for %s", bad)
+		}
+	}
+}
+
 func TestValidateWrapper(t *testing.T) {
 	cfg := &Config{
 		Interfaces: []InterfaceConfig{{Name: "eth0", IP: "192.168.1.1/24"}},
@@ -33,6 +33,7 @@ type Manager struct {
 	revision  int
 	storePath string
 	health    func(*Config) error
+	analyze   func(*Config) []string
 }
 
 type ApplyPlan struct {
@@ -43,6 +44,7 @@ type ApplyPlan struct {
 	ChangedSections   []string  `json:"changed_sections"`
 	Validation        string    `json:"validation"`
 	HealthCheck       string    `json:"health_check"`
+	Warnings          []string  `json:"warnings,omitempty"`
 }
 
 type SectionDiff struct {
@@ -104,6 +106,14 @@ func NewManagerWithStore(cfg *Config, health func(*Config) error, storePath stri
 	}
 }
 
+// SetAnalyzer sets a check run by Plan on valid configs; what it returns is
+// reported as plan warnings and does not block the apply.
+func (m *Manager) SetAnalyzer(analyze func(*Config) []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.analyze = analyze
+}
+
 func (m *Manager) Current() *Config {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -301,6 +311,7 @@ func (m *Manager) Plan(newCfg *Config) (ApplyPlan, error) {
 	plannedSnapshotID := m.nextID
 	baseRevision := m.revision
 	health := m.health
+	analyze := m.analyze
 	m.mu.Unlock()
 
 	healthStatus := "skipped"
@@ -310,6 +321,10 @@ func (m *Manager) Plan(newCfg *Config) (ApplyPlan, error) {
 		}
 		healthStatus = "ok"
 	}
+	var warnings []string
+	if analyze != nil {
+		warnings = analyze(newCfg)
+	}
 
 	return ApplyPlan{
 		Timestamp:         time.Now(),
@@ -319,6 +334,7 @@ func (m *Manager) Plan(newCfg *Config) (ApplyPlan, error) {
 		ChangedSections:   changedSections(prev, newCfg),
 		Validation:        "ok",
 		HealthCheck:       healthStatus,
+		Warnings:          warnings,
 	}, nil
 }
 
@@ -155,6 +155,30 @@ func TestManagerPlanSuccess(t *testing.T) {
 	}
 }
 
+func TestManagerPlanWarnings(t *testing.T) {
+	mgr := NewManager(&Config{API: APIConfig{Address: ":8080"}}, nil)
+	mgr.SetAnalyzer(func(cfg *Config) []string {
+		if len(cfg.Firewall) > 1 {
+			return []string{"firewall rule 2 is shadowed"}
+		}
+		return nil
+	})
+	next := &Config{
+		API:      APIConfig{Address: ":8080"},
+		Firewall: []FirewallRuleConfig{{Chain: "INPUT", Action: "DROP"}, {Chain: "INPUT", Action: "ACCEPT"}},
+	}
+	plan, err := mgr.Plan(next)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Warnings) != 1 {
+		t.Fatalf("expected analyzer warnings in plan, got %v", plan.Warnings)
+	}
+	if err := mgr.ApplyWithPlan(next, plan); err != nil {
+		t.Fatalf("expected warnings not to block apply: %v", err)
+	}
+}
+
 func TestManagerApplyWithPlanStale(t *testing.T) {
 	base := &Config{API: APIConfig{Address: ":8080"}}
 	mgr := NewManager(base, nil)
@@ -13,6 +13,7 @@ const (
 	AlertDrops  AlertType = "drops"
 	AlertErrors AlertType = "errors"
 	AlertIDS    AlertType = "ids"
+	AlertRoute  AlertType = "route"
 )
 
 type Alert struct {
@@ -121,6 +122,15 @@ func EvaluateAlerts(prev metrics.Snapshot, curr metrics.Snapshot, cfg AlertsConf
 	return out
 }
 
+func NewAlert(alertType AlertType, message string) Alert {
+	return Alert{
+		ID:        newAlertID(),
+		Type:      alertType,
+		Message:   message,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
 func newAlertID() string {
 	return time.Now().Format("20060102150405.000000000")
 }
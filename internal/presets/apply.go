@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"router-go/internal/config"
+	"router-go/pkg/network"
 )
 
 type ApplySummary struct {
@@ -49,6 +50,12 @@ func ApplyPreset(base *config.Config, preset Preset) (*config.Config, ApplySumma
 	if preset.Settings.FirewallDefaults != nil {
 		next.FirewallDefaults = *preset.Settings.FirewallDefaults
 	}
+	if preset.Settings.FirewallZones != nil {
+		next.FirewallZones = preset.Settings.FirewallZones
+	}
+	if preset.Settings.ZonePolicies != nil {
+		next.ZonePolicies = preset.Settings.ZonePolicies
+	}
 	if preset.Settings.NAT != nil {
 		next.NAT = preset.Settings.NAT
 	}
@@ -199,15 +206,17 @@ func validatePresetSettings(settings PresetSettings) error {
 		if rule.DstPort < 0 || rule.DstPort > 65535 {
 			return fmt.Errorf("firewall[%d].dst_port invalid", i)
 		}
-		if rule.SrcIP != "" {
-			if _, _, err := net.ParseCIDR(strings.TrimSpace(rule.SrcIP)); err != nil {
-				return fmt.Errorf("firewall[%d].src_ip invalid", i)
-			}
+		if _, err := network.ParseAddrMatch(rule.SrcIP); err != nil {
+			return fmt.Errorf("firewall[%d].src_ip invalid", i)
 		}
-		if rule.DstIP != "" {
-			if _, _, err := net.ParseCIDR(strings.TrimSpace(rule.DstIP)); err != nil {
-				return fmt.Errorf("firewall[%d].dst_ip invalid", i)
-			}
+		if _, err := network.ParseAddrMatch(rule.DstIP); err != nil {
+			return fmt.Errorf("firewall[%d].dst_ip invalid", i)
+		}
+		if _, err := network.ParsePortMatch(rule.SrcPorts); err != nil {
+			return fmt.Errorf("firewall[%d].src_ports invalid", i)
+		}
+		if _, err := network.ParsePortMatch(rule.DstPorts); err != nil {
+			return fmt.Errorf("firewall[%d].dst_ports invalid", i)
 		}
 	}
 	for i, rule := range settings.NAT {
@@ -220,15 +229,17 @@ func validatePresetSettings(settings PresetSettings) error {
 		if rule.ToPort < 0 || rule.ToPort > 65535 {
 			return fmt.Errorf("nat[%d].to_port invalid", i)
 		}
-		if rule.SrcIP != "" {
-			if _, _, err := net.ParseCIDR(strings.TrimSpace(rule.SrcIP)); err != nil {
-				return fmt.Errorf("nat[%d].src_ip invalid", i)
-			}
+		if _, err := network.ParseAddrMatch(rule.SrcIP); err != nil {
+			return fmt.Errorf("nat[%d].src_ip invalid", i)
 		}
-		if rule.DstIP != "" {
-			if _, _, err := net.ParseCIDR(strings.TrimSpace(rule.DstIP)); err != nil {
-				return fmt.Errorf("nat[%d].dst_ip invalid", i)
-			}
+		if _, err := network.ParseAddrMatch(rule.DstIP); err != nil {
+			return fmt.Errorf("nat[%d].dst_ip invalid", i)
+		}
+		if _, err := network.ParsePortMatch(rule.SrcPorts); err != nil {
+			return fmt.Errorf("nat[%d].src_ports invalid", i)
+		}
+		if _, err := network.ParsePortMatch(rule.DstPorts); err != nil {
+			return fmt.Errorf("nat[%d].dst_ports invalid", i)
 		}
 		if rule.ToIP != "" && net.ParseIP(strings.TrimSpace(rule.ToIP)) == nil {
 			return fmt.Errorf("nat[%d].to_ip invalid", i)
@@ -244,6 +255,12 @@ func validatePresetSettings(settings PresetSettings) error {
 		if class.DstPort < 0 || class.DstPort > 65535 {
 			return fmt.Errorf("qos[%d].dst_port invalid", i)
 		}
+		if _, err := network.ParsePortMatch(class.SrcPorts); err != nil {
+			return fmt.Errorf("qos[%d].src_ports invalid", i)
+		}
+		if _, err := network.ParsePortMatch(class.DstPorts); err != nil {
+			return fmt.Errorf("qos[%d].dst_ports invalid", i)
+		}
 	}
 	return nil
 }
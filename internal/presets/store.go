@@ -11,6 +11,8 @@ import (
 	"sync"
 
 	"router-go/internal/config"
+
+	"github.com/go-viper/mapstructure/v2"
 )
 
 type Preset struct {
@@ -23,13 +25,41 @@ type Preset struct {
 }
 
 type PresetSettings struct {
-	Interfaces       []config.InterfaceConfig      `json:"interfaces,omitempty"`
-	Routes           []config.RouteConfig          `json:"routes,omitempty"`
-	Firewall         []config.FirewallRuleConfig   `json:"firewall,omitempty"`
-	FirewallDefaults *config.FirewallDefaultsConfig `json:"firewall_defaults,omitempty"`
-	NAT              []config.NATRuleConfig        `json:"nat,omitempty"`
-	QoS              []config.QoSClassConfig       `json:"qos,omitempty"`
-	IDS              *config.IDSConfig             `json:"ids,omitempty"`
+	Interfaces       []config.InterfaceConfig      `json:"interfaces,omitempty" mapstructure:"interfaces"`
+	Routes           []config.RouteConfig          `json:"routes,omitempty" mapstructure:"routes"`
+	Firewall         []config.FirewallRuleConfig   `json:"firewall,omitempty" mapstructure:"firewall"`
+	FirewallDefaults *config.FirewallDefaultsConfig `json:"firewall_defaults,omitempty" mapstructure:"firewall_defaults"`
+	FirewallZones    []config.FirewallZoneConfig   `json:"firewall_zones,omitempty" mapstructure:"firewall_zones"`
+	ZonePolicies     []config.ZonePolicyConfig     `json:"firewall_zone_policies,omitempty" mapstructure:"firewall_zone_policies"`
+	NAT              []config.NATRuleConfig        `json:"nat,omitempty" mapstructure:"nat"`
+	QoS              []config.QoSClassConfig       `json:"qos,omitempty" mapstructure:"qos"`
+	IDS              *config.IDSConfig             `json:"ids,omitempty" mapstructure:"ids"`
+}
+
+// UnmarshalJSON also reads the settings by their config file keys
+// (to_ip, dst_port, ...), which encoding/json does not match to the config
+// structs; presets saved with the Go field names are still read as before.
+func (s *PresetSettings) UnmarshalJSON(data []byte) error {
+	type plain PresetSettings
+	if err := json.Unmarshal(data, (*plain)(s)); err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           s,
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(raw)
 }
 
 type PresetSummary struct {
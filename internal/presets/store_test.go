@@ -1,6 +1,7 @@
 package presets
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -197,6 +198,49 @@ func TestApplyPresetWithOverrides(t *testing.T) {
 	}
 }
 
+func TestSmallOfficePresetZones(t *testing.T) {
+	store, err := LoadStore(filepath.Join("..", "..", "presets"))
+	if err != nil {
+		t.Fatalf("load presets: %v", err)
+	}
+	preset, ok := store.Get("small-office")
+	if !ok {
+		t.Fatalf("expected small-office preset")
+	}
+	base := &config.Config{
+		Interfaces: []config.InterfaceConfig{{Name: "eth0", IP: "192.168.1.1/24"}},
+	}
+	updated, _, err := ApplyPreset(base, preset)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(updated.FirewallZones) != 3 || len(updated.ZonePolicies) != 1 {
+		t.Fatalf("expected zones applied, got %+v %+v", updated.FirewallZones, updated.ZonePolicies)
+	}
+	if updated.ZonePolicies[0].From != "guest" || updated.ZonePolicies[0].To != "lan" {
+		t.Fatalf("unexpected zone policy: %+v", updated.ZonePolicies[0])
+	}
+	if err := config.Validate(updated); err != nil {
+		t.Fatalf("expected preset to validate: %v", err)
+	}
+	if nat := updated.NAT[1]; nat.Type != "DNAT" || nat.DstPort != 8080 || nat.ToIP != "192.168.10.10" || nat.ToPort != 80 {
+		t.Fatalf("expected config file keys to be read, got %+v", nat)
+	}
+	if updated.Firewall[0].DstPort != 25 {
+		t.Fatalf("expected firewall dst_port to be read, got %+v", updated.Firewall[0])
+	}
+}
+
+func TestPresetSettingsGoFieldNames(t *testing.T) {
+	var settings PresetSettings
+	if err := json.Unmarshal([]byte(`{"nat":[{"Type":"DNAT","DstPort":8080,"ToIP":"192.168.10.10"},{"type":"SNAT","to_ip":"203.0.113.1"}]}`), &settings); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if len(settings.NAT) != 2 || settings.NAT[0].ToIP != "192.168.10.10" || settings.NAT[0].DstPort != 8080 || settings.NAT[1].ToIP != "203.0.113.1" {
+		t.Fatalf("unexpected settings: %+v", settings.NAT)
+	}
+}
+
 func TestStoreSavePreset(t *testing.T) {
 	dir := t.TempDir()
 	store, err := LoadStore(dir)
@@ -0,0 +1,472 @@
+package bfd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StateAdminDown = "admin_down"
+	StateDown      = "down"
+	StateInit      = "init"
+	StateUp        = "up"
+)
+
+type Config struct {
+	SlowTxInterval time.Duration
+	Tick           time.Duration
+}
+
+type SessionConfig struct {
+	Peer          net.IP
+	Interface     string
+	DesiredMinTx  time.Duration
+	RequiredMinRx time.Duration
+	DetectMult    int
+}
+
+type Event struct {
+	Peer      string
+	Interface string
+	State     string
+	Previous  string
+	Diag      string
+}
+
+type SessionStatus struct {
+	Peer          string
+	Interface     string
+	State         string
+	RemoteState   string
+	Diag          string
+	RemoteDiag    string
+	LocalDiscr    uint32
+	RemoteDiscr   uint32
+	DesiredMinTx  time.Duration
+	RequiredMinRx time.Duration
+	DetectMult    int
+	TxInterval    time.Duration
+	DetectTime    time.Duration
+	UpSince       time.Time
+	LastReceived  time.Time
+	Transitions   int
+}
+
+type Transport interface {
+	Send(dst net.IP, iface string, pkt []byte) error
+	Receive() (net.IP, int, []byte, error)
+	Close() error
+}
+
+type TransportFunc func() (Transport, error)
+
+type Manager struct {
+	cfg       Config
+	transport TransportFunc
+	mu        sync.Mutex
+	tr        Transport
+	sessions  map[string]*session
+	byDiscr   map[uint32]*session
+	rnd       *rand.Rand
+	started   bool
+	pending   []func()
+}
+
+type session struct {
+	cfg              SessionConfig
+	state            uint8
+	diag             uint8
+	localDiscr       uint32
+	remoteDiscr      uint32
+	remoteState      uint8
+	remoteDiag       uint8
+	remoteMinRx      time.Duration
+	remoteDesiredTx  time.Duration
+	remoteDetectMult int
+	pollActive       bool
+	sendFinal        bool
+	nextTx           time.Time
+	detectAt         time.Time
+	lastRx           time.Time
+	upSince          time.Time
+	transitions      int
+	listeners        []func(Event)
+}
+
+type inbound struct {
+	src net.IP
+	ttl int
+	pkt []byte
+}
+
+func NewManager(cfg Config) *Manager {
+	if cfg.SlowTxInterval <= 0 {
+		cfg.SlowTxInterval = time.Second
+	}
+	if cfg.Tick <= 0 {
+		cfg.Tick = 10 * time.Millisecond
+	}
+	return &Manager{
+		cfg:       cfg,
+		transport: newUDPTransport,
+		sessions:  map[string]*session{},
+		byDiscr:   map[uint32]*session{},
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (m *Manager) SetTransport(fn TransportFunc) {
+	m.mu.Lock()
+	m.transport = fn
+	m.mu.Unlock()
+}
+
+// Add creates the session for cfg.Peer or joins an existing one. Sessions
+// shared by several dependents run with the most aggressive timers requested.
+func (m *Manager) Add(cfg SessionConfig, fn func(Event)) error {
+	if cfg.Peer == nil {
+		return errors.New("bfd peer is required")
+	}
+	if cfg.DesiredMinTx <= 0 {
+		cfg.DesiredMinTx = 300 * time.Millisecond
+	}
+	if cfg.RequiredMinRx <= 0 {
+		cfg.RequiredMinRx = 300 * time.Millisecond
+	}
+	if cfg.DetectMult <= 0 {
+		cfg.DetectMult = 3
+	}
+	if cfg.DetectMult > 255 {
+		return fmt.Errorf("bfd detect multiplier %d is out of range", cfg.DetectMult)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := cfg.Peer.String()
+	s := m.sessions[key]
+	if s == nil {
+		s = &session{cfg: cfg, state: stateDown, remoteMinRx: time.Microsecond}
+		for s.localDiscr == 0 || m.byDiscr[s.localDiscr] != nil {
+			s.localDiscr = m.rnd.Uint32()
+		}
+		m.sessions[key] = s
+		m.byDiscr[s.localDiscr] = s
+	} else {
+		if cfg.Interface != "" && s.cfg.Interface == "" {
+			s.cfg.Interface = cfg.Interface
+		}
+		if cfg.DesiredMinTx < s.cfg.DesiredMinTx {
+			s.cfg.DesiredMinTx = cfg.DesiredMinTx
+			s.pollActive = s.state == stateUp
+		}
+		if cfg.RequiredMinRx < s.cfg.RequiredMinRx {
+			s.cfg.RequiredMinRx = cfg.RequiredMinRx
+			s.pollActive = s.state == stateUp
+		}
+		if cfg.DetectMult < s.cfg.DetectMult {
+			s.cfg.DetectMult = cfg.DetectMult
+		}
+	}
+	if fn != nil {
+		s.listeners = append(s.listeners, fn)
+	}
+	if m.started {
+		s.nextTx = time.Now()
+	}
+	return nil
+}
+
+func (m *Manager) Start(ctx context.Context) error {
+	m.mu.Lock()
+	tr, err := m.transport()
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	m.tr = tr
+	m.started = true
+	now := time.Now()
+	for _, s := range m.sessions {
+		s.nextTx = now
+	}
+	m.mu.Unlock()
+
+	in := make(chan inbound, 256)
+	go m.receive(ctx, tr, in)
+	go m.run(ctx, in)
+	return nil
+}
+
+func (m *Manager) Up(peer net.IP) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.sessions[peer.String()]
+	return s != nil && s.state == stateUp
+}
+
+func (m *Manager) Sessions() []SessionStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]SessionStatus, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		st := SessionStatus{
+			Peer:          s.cfg.Peer.String(),
+			Interface:     s.cfg.Interface,
+			State:         stateNames[s.state],
+			Diag:          diagNames[s.diag],
+			LocalDiscr:    s.localDiscr,
+			RemoteDiscr:   s.remoteDiscr,
+			DesiredMinTx:  s.cfg.DesiredMinTx,
+			RequiredMinRx: s.cfg.RequiredMinRx,
+			DetectMult:    s.cfg.DetectMult,
+			TxInterval:    m.txInterval(s),
+			UpSince:       s.upSince,
+			LastReceived:  s.lastRx,
+			Transitions:   s.transitions,
+		}
+		if !s.lastRx.IsZero() {
+			st.RemoteState = stateNames[s.remoteState]
+			st.RemoteDiag = diagNames[s.remoteDiag]
+			st.DetectTime = s.detectTime()
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Peer < out[j].Peer })
+	return out
+}
+
+func (m *Manager) receive(ctx context.Context, tr Transport, in chan<- inbound) {
+	for {
+		src, ttl, pkt, err := tr.Receive()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case in <- inbound{src: src, ttl: ttl, pkt: pkt}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Manager) run(ctx context.Context, in <-chan inbound) {
+	ticker := time.NewTicker(m.cfg.Tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.shutdown()
+			return
+		case msg := <-in:
+			m.mu.Lock()
+			m.handle(msg, time.Now())
+			m.flush()
+		case now := <-ticker.C:
+			m.mu.Lock()
+			m.tick(now)
+			m.flush()
+		}
+	}
+}
+
+// flush releases the lock and delivers queued state change events.
+func (m *Manager) flush() {
+	pending := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+func (m *Manager) shutdown() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		s.state = stateAdminDown
+		s.diag = diagAdminDown
+		m.transmit(s)
+	}
+	if m.tr != nil {
+		_ = m.tr.Close()
+	}
+	m.started = false
+}
+
+func (m *Manager) handle(msg inbound, now time.Time) {
+	if msg.ttl != requiredTTL {
+		return
+	}
+	p, err := decodeControl(msg.pkt)
+	if err != nil {
+		return
+	}
+	var s *session
+	if p.yourDiscr != 0 {
+		s = m.byDiscr[p.yourDiscr]
+		if s != nil && !s.cfg.Peer.Equal(msg.src) {
+			return
+		}
+	} else {
+		s = m.sessions[msg.src.String()]
+	}
+	if s == nil {
+		return
+	}
+	s.remoteDiscr = p.myDiscr
+	s.remoteState = p.state
+	s.remoteDiag = p.diag
+	s.remoteDesiredTx = time.Duration(p.desiredMinTx) * time.Microsecond
+	s.remoteMinRx = time.Duration(p.requiredMinRx) * time.Microsecond
+	s.remoteDetectMult = int(p.detectMult)
+	s.lastRx = now
+	if p.final {
+		s.pollActive = false
+	}
+	if s.state == stateAdminDown {
+		return
+	}
+	if p.state == stateAdminDown {
+		if s.state != stateDown {
+			m.setState(s, stateDown, diagNeighborDown, now)
+		}
+	} else {
+		switch s.state {
+		case stateDown:
+			switch p.state {
+			case stateDown:
+				m.setState(s, stateInit, diagNone, now)
+			case stateInit:
+				m.setState(s, stateUp, diagNone, now)
+			}
+		case stateInit:
+			if p.state == stateInit || p.state == stateUp {
+				m.setState(s, stateUp, diagNone, now)
+			}
+		case stateUp:
+			if p.state == stateDown {
+				m.setState(s, stateDown, diagNeighborDown, now)
+			}
+		}
+	}
+	if s.state == stateInit || s.state == stateUp {
+		s.detectAt = now.Add(s.detectTime())
+	}
+	if p.poll {
+		s.sendFinal = true
+		m.transmit(s)
+	}
+}
+
+func (m *Manager) tick(now time.Time) {
+	for _, s := range m.sessions {
+		if s.state == stateAdminDown {
+			continue
+		}
+		if (s.state == stateInit || s.state == stateUp) && !s.detectAt.IsZero() && now.After(s.detectAt) {
+			s.remoteDiscr = 0
+			s.remoteState = stateDown
+			s.remoteMinRx = time.Microsecond
+			s.detectAt = time.Time{}
+			m.setState(s, stateDown, diagTimeExpired, now)
+		}
+		if now.Before(s.nextTx) {
+			continue
+		}
+		m.transmit(s)
+		interval := m.txInterval(s)
+		jitter := 75 + m.rnd.Intn(26)
+		if s.cfg.DetectMult == 1 {
+			jitter = 75 + m.rnd.Intn(16)
+		}
+		s.nextTx = now.Add(interval * time.Duration(jitter) / 100)
+	}
+}
+
+func (m *Manager) setState(s *session, state uint8, diag uint8, now time.Time) {
+	if s.state == state {
+		return
+	}
+	prev := s.state
+	s.state = state
+	s.diag = diag
+	s.transitions++
+	switch {
+	case state == stateUp:
+		s.upSince = now
+		s.pollActive = s.cfg.DesiredMinTx < m.cfg.SlowTxInterval
+		s.nextTx = now
+	case prev == stateUp:
+		s.upSince = time.Time{}
+		s.pollActive = false
+	}
+	if state == stateDown {
+		s.detectAt = time.Time{}
+	}
+	ev := Event{
+		Peer:      s.cfg.Peer.String(),
+		Interface: s.cfg.Interface,
+		State:     stateNames[state],
+		Previous:  stateNames[prev],
+		Diag:      diagNames[diag],
+	}
+	for _, fn := range s.listeners {
+		fn := fn
+		m.pending = append(m.pending, func() { fn(ev) })
+	}
+}
+
+func (m *Manager) transmit(s *session) {
+	if m.tr == nil || (s.remoteMinRx == 0 && !s.sendFinal) {
+		return
+	}
+	p := controlPacket{
+		diag:          s.diag,
+		state:         s.state,
+		detectMult:    uint8(s.cfg.DetectMult),
+		myDiscr:       s.localDiscr,
+		yourDiscr:     s.remoteDiscr,
+		desiredMinTx:  uint32(m.desiredMinTx(s) / time.Microsecond),
+		requiredMinRx: uint32(s.cfg.RequiredMinRx / time.Microsecond),
+	}
+	if s.sendFinal {
+		p.final = true
+		s.sendFinal = false
+	} else {
+		p.poll = s.pollActive
+	}
+	_ = m.tr.Send(s.cfg.Peer, s.cfg.Interface, encodeControl(p))
+}
+
+// desiredMinTx slows transmission down to SlowTxInterval until the session
+// is up, as required by RFC 5880 section 6.8.3.
+func (m *Manager) desiredMinTx(s *session) time.Duration {
+	if s.state != stateUp && s.cfg.DesiredMinTx < m.cfg.SlowTxInterval {
+		return m.cfg.SlowTxInterval
+	}
+	return s.cfg.DesiredMinTx
+}
+
+func (m *Manager) txInterval(s *session) time.Duration {
+	interval := m.desiredMinTx(s)
+	if s.remoteMinRx > interval {
+		interval = s.remoteMinRx
+	}
+	return interval
+}
+
+func (s *session) detectTime() time.Duration {
+	interval := s.cfg.RequiredMinRx
+	if s.remoteDesiredTx > interval {
+		interval = s.remoteDesiredTx
+	}
+	return time.Duration(s.remoteDetectMult) * interval
+}
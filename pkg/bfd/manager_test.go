@@ -0,0 +1,207 @@
+package bfd
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fabric struct {
+	mu    sync.Mutex
+	nodes map[string]*memTransport
+}
+
+type memPacket struct {
+	src net.IP
+	pkt []byte
+}
+
+type memTransport struct {
+	f      *fabric
+	ip     net.IP
+	ttl    int
+	ch     chan memPacket
+	done   chan struct{}
+	closed sync.Once
+	muted  atomic.Bool
+}
+
+func newFabric() *fabric {
+	return &fabric{nodes: map[string]*memTransport{}}
+}
+
+func (f *fabric) transport(ip string) (*memTransport, TransportFunc) {
+	tr := &memTransport{f: f, ip: net.ParseIP(ip).To4(), ttl: requiredTTL, ch: make(chan memPacket, 256), done: make(chan struct{})}
+	f.mu.Lock()
+	f.nodes[tr.ip.String()] = tr
+	f.mu.Unlock()
+	return tr, func() (Transport, error) { return tr, nil }
+}
+
+func (t *memTransport) Send(dst net.IP, iface string, pkt []byte) error {
+	if t.muted.Load() {
+		return nil
+	}
+	t.f.mu.Lock()
+	peer := t.f.nodes[dst.String()]
+	t.f.mu.Unlock()
+	if peer == nil {
+		return nil
+	}
+	select {
+	case peer.ch <- memPacket{src: t.ip, pkt: append([]byte(nil), pkt...)}:
+	default:
+	}
+	return nil
+}
+
+func (t *memTransport) Receive() (net.IP, int, []byte, error) {
+	select {
+	case p := <-t.ch:
+		return p.src, t.ttl, p.pkt, nil
+	case <-t.done:
+		return nil, 0, nil, net.ErrClosed
+	}
+}
+
+func (t *memTransport) Close() error {
+	t.closed.Do(func() { close(t.done) })
+	return nil
+}
+
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (l *eventLog) add(ev Event) {
+	l.mu.Lock()
+	l.events = append(l.events, ev)
+	l.mu.Unlock()
+}
+
+func (l *eventLog) last() Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) == 0 {
+		return Event{}
+	}
+	return l.events[len(l.events)-1]
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func startManager(t *testing.T, ctx context.Context, fn TransportFunc, peer string, log *eventLog) *Manager {
+	t.Helper()
+	m := NewManager(Config{SlowTxInterval: 50 * time.Millisecond, Tick: 2 * time.Millisecond})
+	m.SetTransport(fn)
+	err := m.Add(SessionConfig{
+		Peer:          net.ParseIP(peer),
+		Interface:     "lan",
+		DesiredMinTx:  20 * time.Millisecond,
+		RequiredMinRx: 20 * time.Millisecond,
+		DetectMult:    3,
+	}, log.add)
+	if err != nil {
+		t.Fatalf("add session: %v", err)
+	}
+	if err := m.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	return m
+}
+
+func TestSessionsComeUpAndDetectFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFabric()
+	trA, fnA := f.transport("10.0.0.1")
+	_, fnB := f.transport("10.0.0.2")
+	var logA, logB eventLog
+	a := startManager(t, ctx, fnA, "10.0.0.2", &logA)
+	bctx, stopB := context.WithCancel(ctx)
+	b := startManager(t, bctx, fnB, "10.0.0.1", &logB)
+
+	peerA, peerB := net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1")
+	waitFor(t, "sessions up", func() bool { return a.Up(peerA) && b.Up(peerB) })
+	if ev := logA.last(); ev.State != StateUp || ev.Peer != "10.0.0.2" || ev.Interface != "lan" {
+		t.Fatalf("unexpected up event: %+v", ev)
+	}
+	waitFor(t, "poll sequence finished", func() bool {
+		st := a.Sessions()[0]
+		return st.TxInterval == 20*time.Millisecond && st.DetectTime == 60*time.Millisecond
+	})
+
+	trA.muted.Store(true)
+	start := time.Now()
+	waitFor(t, "failure detected", func() bool { return !b.Up(peerB) })
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("detection took %v", elapsed)
+	}
+	if ev := logB.last(); ev.State != StateDown || ev.Previous != StateUp || ev.Diag != "control_detection_time_expired" {
+		t.Fatalf("unexpected down event: %+v", ev)
+	}
+
+	trA.muted.Store(false)
+	waitFor(t, "sessions recovered", func() bool { return a.Up(peerA) && b.Up(peerB) })
+
+	stopB()
+	waitFor(t, "admin down signalled", func() bool { return !a.Up(peerA) })
+	if ev := logA.last(); ev.State != StateDown || ev.Diag != "neighbor_signaled_session_down" {
+		t.Fatalf("unexpected admin down event: %+v", ev)
+	}
+	if st := a.Sessions()[0]; st.RemoteState != StateAdminDown || st.Transitions < 4 {
+		t.Fatalf("unexpected session status: %+v", st)
+	}
+}
+
+func TestSessionIgnoresPacketsWithoutMaxTTL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFabric()
+	trA, fnA := f.transport("10.0.0.1")
+	_, fnB := f.transport("10.0.0.2")
+	trA.ttl = 254
+	var logA, logB eventLog
+	a := startManager(t, ctx, fnA, "10.0.0.2", &logA)
+	startManager(t, ctx, fnB, "10.0.0.1", &logB)
+
+	time.Sleep(300 * time.Millisecond)
+	if a.Up(net.ParseIP("10.0.0.2")) {
+		t.Fatalf("session must not come up from packets with ttl below 255")
+	}
+	if st := a.Sessions()[0]; !st.LastReceived.IsZero() || st.State != StateDown {
+		t.Fatalf("unexpected session status: %+v", st)
+	}
+}
+
+func TestAddSharesSessionPerPeer(t *testing.T) {
+	m := NewManager(Config{})
+	peer := net.ParseIP("192.0.2.1")
+	if err := m.Add(SessionConfig{Peer: peer, DesiredMinTx: time.Second}, nil); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if err := m.Add(SessionConfig{Peer: peer, DesiredMinTx: 100 * time.Millisecond, DetectMult: 5}, nil); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	sessions := m.Sessions()
+	if len(sessions) != 1 || sessions[0].DesiredMinTx != 100*time.Millisecond || sessions[0].RequiredMinRx != 300*time.Millisecond || sessions[0].DetectMult != 3 {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+	if err := m.Add(SessionConfig{}, nil); err == nil {
+		t.Fatalf("expected error without peer")
+	}
+}
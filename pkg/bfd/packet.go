@@ -0,0 +1,129 @@
+package bfd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	bfdVersion = 1
+	packetLen  = 24
+
+	stateAdminDown = 0
+	stateDown      = 1
+	stateInit      = 2
+	stateUp        = 3
+
+	diagNone         = 0
+	diagTimeExpired  = 1
+	diagNeighborDown = 3
+	diagAdminDown    = 7
+
+	flagPoll       = 0x20
+	flagFinal      = 0x10
+	flagAuth       = 0x04
+	flagMultipoint = 0x01
+	stateShift     = 6
+	diagMask       = 0x1f
+
+	ControlPort   = 3784
+	sourcePortMin = 49152
+	sourcePortMax = 65535
+	requiredTTL   = 255
+)
+
+var stateNames = map[uint8]string{
+	stateAdminDown: StateAdminDown,
+	stateDown:      StateDown,
+	stateInit:      StateInit,
+	stateUp:        StateUp,
+}
+
+var diagNames = map[uint8]string{
+	diagNone:         "",
+	diagTimeExpired:  "control_detection_time_expired",
+	2:                "echo_function_failed",
+	diagNeighborDown: "neighbor_signaled_session_down",
+	4:                "forwarding_plane_reset",
+	5:                "path_down",
+	6:                "concatenated_path_down",
+	diagAdminDown:    "administratively_down",
+	8:                "reverse_concatenated_path_down",
+}
+
+type controlPacket struct {
+	diag              uint8
+	state             uint8
+	poll              bool
+	final             bool
+	detectMult        uint8
+	myDiscr           uint32
+	yourDiscr         uint32
+	desiredMinTx      uint32
+	requiredMinRx     uint32
+	requiredMinEchoRx uint32
+}
+
+func encodeControl(p controlPacket) []byte {
+	b := make([]byte, packetLen)
+	b[0] = bfdVersion<<5 | p.diag&diagMask
+	b[1] = p.state << stateShift
+	if p.poll {
+		b[1] |= flagPoll
+	}
+	if p.final {
+		b[1] |= flagFinal
+	}
+	b[2] = p.detectMult
+	b[3] = packetLen
+	binary.BigEndian.PutUint32(b[4:], p.myDiscr)
+	binary.BigEndian.PutUint32(b[8:], p.yourDiscr)
+	binary.BigEndian.PutUint32(b[12:], p.desiredMinTx)
+	binary.BigEndian.PutUint32(b[16:], p.requiredMinRx)
+	binary.BigEndian.PutUint32(b[20:], p.requiredMinEchoRx)
+	return b
+}
+
+// decodeControl applies the reception checks of RFC 5880 section 6.8.6.
+func decodeControl(b []byte) (controlPacket, error) {
+	if len(b) < packetLen {
+		return controlPacket{}, errors.New("bfd packet too short")
+	}
+	if version := b[0] >> 5; version != bfdVersion {
+		return controlPacket{}, fmt.Errorf("unsupported bfd version %d", version)
+	}
+	length := int(b[3])
+	if length < packetLen || length > len(b) {
+		return controlPacket{}, errors.New("invalid bfd packet length")
+	}
+	flags := b[1]
+	if flags&flagAuth != 0 {
+		return controlPacket{}, errors.New("bfd authentication is not supported")
+	}
+	if flags&flagMultipoint != 0 {
+		return controlPacket{}, errors.New("multipoint bfd is not supported")
+	}
+	p := controlPacket{
+		diag:              b[0] & diagMask,
+		state:             flags >> stateShift,
+		poll:              flags&flagPoll != 0,
+		final:             flags&flagFinal != 0,
+		detectMult:        b[2],
+		myDiscr:           binary.BigEndian.Uint32(b[4:]),
+		yourDiscr:         binary.BigEndian.Uint32(b[8:]),
+		desiredMinTx:      binary.BigEndian.Uint32(b[12:]),
+		requiredMinRx:     binary.BigEndian.Uint32(b[16:]),
+		requiredMinEchoRx: binary.BigEndian.Uint32(b[20:]),
+	}
+	if p.detectMult == 0 {
+		return controlPacket{}, errors.New("bfd detect multiplier is zero")
+	}
+	if p.myDiscr == 0 {
+		return controlPacket{}, errors.New("bfd my discriminator is zero")
+	}
+	if p.yourDiscr == 0 && p.state != stateDown && p.state != stateAdminDown {
+		return controlPacket{}, errors.New("bfd your discriminator is zero")
+	}
+	return p, nil
+}
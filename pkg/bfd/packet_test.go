@@ -0,0 +1,47 @@
+package bfd
+
+import "testing"
+
+func TestControlPacketRoundTrip(t *testing.T) {
+	p := controlPacket{
+		diag:          diagTimeExpired,
+		state:         stateInit,
+		poll:          true,
+		detectMult:    3,
+		myDiscr:       0x11223344,
+		yourDiscr:     0x55667788,
+		desiredMinTx:  50000,
+		requiredMinRx: 100000,
+	}
+	got, err := decodeControl(encodeControl(p))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got != p {
+		t.Fatalf("round trip mismatch: %+v != %+v", got, p)
+	}
+
+	raw := encodeControl(p)
+	raw[0] = 0
+	if _, err := decodeControl(raw); err == nil {
+		t.Fatalf("expected version error")
+	}
+	raw = encodeControl(p)
+	raw[1] |= flagAuth
+	if _, err := decodeControl(raw); err == nil {
+		t.Fatalf("expected authentication error")
+	}
+	raw = encodeControl(p)
+	raw[2] = 0
+	if _, err := decodeControl(raw); err == nil {
+		t.Fatalf("expected detect multiplier error")
+	}
+	p.yourDiscr = 0
+	if _, err := decodeControl(encodeControl(p)); err == nil {
+		t.Fatalf("expected zero your discriminator to be rejected outside down state")
+	}
+	p.state = stateDown
+	if _, err := decodeControl(encodeControl(p)); err != nil {
+		t.Fatalf("zero your discriminator is valid in down state: %v", err)
+	}
+}
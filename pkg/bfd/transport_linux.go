@@ -0,0 +1,185 @@
+//go:build linux
+
+package bfd
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type received struct {
+	src net.IP
+	ttl int
+	pkt []byte
+}
+
+type udpTransport struct {
+	listeners []*net.UDPConn
+	send4     *net.UDPConn
+	send6     *net.UDPConn
+	in        chan received
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newUDPTransport() (Transport, error) {
+	t := &udpTransport{in: make(chan received, 256), done: make(chan struct{})}
+	for _, network := range []string{"udp4", "udp6"} {
+		conn, err := listenControl(network)
+		if err != nil {
+			_ = t.Close()
+			return nil, err
+		}
+		t.listeners = append(t.listeners, conn)
+	}
+	var err error
+	if t.send4, err = listenSource("udp4"); err != nil {
+		_ = t.Close()
+		return nil, err
+	}
+	if t.send6, err = listenSource("udp6"); err != nil {
+		_ = t.Close()
+		return nil, err
+	}
+	for _, conn := range t.listeners {
+		go t.read(conn)
+	}
+	return t, nil
+}
+
+func listenControl(network string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sock := int(fd)
+			if sockErr = unix.SetsockoptInt(sock, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+				return
+			}
+			if network == "udp6" {
+				sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IPV6, unix.IPV6_RECVHOPLIMIT, 1)
+				return
+			}
+			sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IP, unix.IP_RECVTTL, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	address := fmt.Sprintf("0.0.0.0:%d", ControlPort)
+	if network == "udp6" {
+		address = fmt.Sprintf("[::]:%d", ControlPort)
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// listenSource binds the sending socket to a port from the RFC 5881 source
+// range with the TTL/hop limit fixed at 255.
+func listenSource(network string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			if network == "udp6" {
+				sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, requiredTTL)
+				return
+			}
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, requiredTTL)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	span := sourcePortMax - sourcePortMin + 1
+	start := rand.Intn(span)
+	var lastErr error
+	for i := 0; i < 64; i++ {
+		port := sourcePortMin + (start+i)%span
+		address := fmt.Sprintf("0.0.0.0:%d", port)
+		if network == "udp6" {
+			address = fmt.Sprintf("[::]:%d", port)
+		}
+		conn, err := lc.ListenPacket(context.Background(), network, address)
+		if err == nil {
+			return conn.(*net.UDPConn), nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (t *udpTransport) read(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	oob := make([]byte, 128)
+	for {
+		n, oobn, _, addr, err := conn.ReadMsgUDP(buf, oob)
+		if err != nil {
+			return
+		}
+		msg := received{src: addr.IP, ttl: -1, pkt: append([]byte(nil), buf[:n]...)}
+		if v4 := msg.src.To4(); v4 != nil {
+			msg.src = v4
+		}
+		if cmsgs, err := unix.ParseSocketControlMessage(oob[:oobn]); err == nil {
+			for _, cmsg := range cmsgs {
+				if len(cmsg.Data) < 4 {
+					continue
+				}
+				if (cmsg.Header.Level == unix.IPPROTO_IP && cmsg.Header.Type == unix.IP_TTL) ||
+					(cmsg.Header.Level == unix.IPPROTO_IPV6 && cmsg.Header.Type == unix.IPV6_HOPLIMIT) {
+					msg.ttl = int(binary.NativeEndian.Uint32(cmsg.Data))
+				}
+			}
+		}
+		select {
+		case t.in <- msg:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *udpTransport) Send(dst net.IP, iface string, pkt []byte) error {
+	addr := &net.UDPAddr{IP: dst, Port: ControlPort}
+	if dst.To4() != nil {
+		_, err := t.send4.WriteToUDP(pkt, addr)
+		return err
+	}
+	if dst.IsLinkLocalUnicast() {
+		addr.Zone = iface
+	}
+	_, err := t.send6.WriteToUDP(pkt, addr)
+	return err
+}
+
+func (t *udpTransport) Receive() (net.IP, int, []byte, error) {
+	select {
+	case msg := <-t.in:
+		return msg.src, msg.ttl, msg.pkt, nil
+	case <-t.done:
+		return nil, 0, nil, net.ErrClosed
+	}
+}
+
+func (t *udpTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.done)
+		for _, conn := range append(t.listeners, t.send4, t.send6) {
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+	})
+	return nil
+}
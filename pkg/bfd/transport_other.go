@@ -0,0 +1,9 @@
+//go:build !linux
+
+package bfd
+
+import "errors"
+
+func newUDPTransport() (Transport, error) {
+	return nil, errors.New("bfd sockets are only supported on linux")
+}
@@ -0,0 +1,539 @@
+package bgp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	msgOpen         = 1
+	msgUpdate       = 2
+	msgNotification = 3
+	msgKeepalive    = 4
+
+	headerLen  = 19
+	maxMsgLen  = 4096
+	asTrans    = 23456
+	bgpVersion = 4
+
+	capMultiprotocol = 1
+	capFourOctetAS   = 65
+
+	attrOrigin     = 1
+	attrASPath     = 2
+	attrNextHop    = 3
+	attrMED        = 4
+	attrLocalPref  = 5
+	attrMPReach    = 14
+	attrMPUnreach  = 15
+	flagOptional   = 0x80
+	flagTransitive = 0x40
+	flagExtended   = 0x10
+
+	segmentSet      = 1
+	segmentSequence = 2
+
+	OriginIGP        = 0
+	OriginEGP        = 1
+	OriginIncomplete = 2
+)
+
+const (
+	errMessageHeader = 1
+	errOpenMessage   = 2
+	errUpdateMessage = 3
+	errHoldTimer     = 4
+	errFSM           = 5
+	errCease         = 6
+
+	subBadPeerAS       = 2
+	subBadBGPID        = 3
+	subUnacceptHold    = 6
+	subMaxPrefixes     = 1
+	subAdminShutdown   = 2
+	subCollision       = 7
+	subBFDDown         = 10
+	subMalformedAttrs  = 1
+	subBadMessageLen   = 2
+	subUnsupportedVers = 1
+)
+
+type Family struct {
+	AFI  uint16
+	SAFI uint8
+}
+
+var (
+	FamilyIPv4Unicast = Family{AFI: 1, SAFI: 1}
+	FamilyIPv6Unicast = Family{AFI: 2, SAFI: 1}
+)
+
+func (f Family) String() string {
+	switch f {
+	case FamilyIPv4Unicast:
+		return "ipv4-unicast"
+	case FamilyIPv6Unicast:
+		return "ipv6-unicast"
+	default:
+		return fmt.Sprintf("afi%d-safi%d", f.AFI, f.SAFI)
+	}
+}
+
+type openMsg struct {
+	ASN         uint32
+	HoldTime    uint16
+	RouterID    net.IP
+	Families    []Family
+	FourOctetAS bool
+}
+
+type PathAttrs struct {
+	Origin       uint8
+	ASPath       []uint32
+	NextHop      net.IP
+	NextHop6     net.IP
+	MED          uint32
+	HasMED       bool
+	LocalPref    uint32
+	HasLocalPref bool
+}
+
+type updateMsg struct {
+	Withdrawn []net.IPNet
+	Attrs     *PathAttrs
+	NLRI      []net.IPNet
+}
+
+type notificationMsg struct {
+	Code    uint8
+	Subcode uint8
+	Data    []byte
+}
+
+func (n notificationMsg) Error() string {
+	return fmt.Sprintf("bgp notification code %d subcode %d", n.Code, n.Subcode)
+}
+
+func frame(msgType byte, body []byte) []byte {
+	out := make([]byte, headerLen+len(body))
+	for i := 0; i < 16; i++ {
+		out[i] = 0xff
+	}
+	binary.BigEndian.PutUint16(out[16:], uint16(len(out)))
+	out[18] = msgType
+	copy(out[headerLen:], body)
+	return out
+}
+
+func readMessage(r io.Reader) (byte, []byte, error) {
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	for i := 0; i < 16; i++ {
+		if hdr[i] != 0xff {
+			return 0, nil, notificationMsg{Code: errMessageHeader, Subcode: 1}
+		}
+	}
+	length := int(binary.BigEndian.Uint16(hdr[16:]))
+	if length < headerLen || length > maxMsgLen {
+		return 0, nil, notificationMsg{Code: errMessageHeader, Subcode: subBadMessageLen}
+	}
+	body := make([]byte, length-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return hdr[18], body, nil
+}
+
+func encodeOpen(o openMsg) []byte {
+	var caps bytes.Buffer
+	for _, fam := range o.Families {
+		caps.Write([]byte{capMultiprotocol, 4, byte(fam.AFI >> 8), byte(fam.AFI), 0, fam.SAFI})
+	}
+	if o.FourOctetAS {
+		caps.Write([]byte{capFourOctetAS, 4})
+		_ = binary.Write(&caps, binary.BigEndian, o.ASN)
+	}
+	myAS := uint16(asTrans)
+	if o.ASN <= 0xffff {
+		myAS = uint16(o.ASN)
+	}
+	body := make([]byte, 10, 10+2+caps.Len())
+	body[0] = bgpVersion
+	binary.BigEndian.PutUint16(body[1:], myAS)
+	binary.BigEndian.PutUint16(body[3:], o.HoldTime)
+	copy(body[5:9], o.RouterID.To4())
+	if caps.Len() > 0 {
+		body[9] = byte(2 + caps.Len())
+		body = append(body, 2, byte(caps.Len()))
+		body = append(body, caps.Bytes()...)
+	}
+	return frame(msgOpen, body)
+}
+
+func decodeOpen(body []byte) (openMsg, error) {
+	if len(body) < 10 {
+		return openMsg{}, notificationMsg{Code: errMessageHeader, Subcode: subBadMessageLen}
+	}
+	if body[0] != bgpVersion {
+		return openMsg{}, notificationMsg{Code: errOpenMessage, Subcode: subUnsupportedVers, Data: []byte{0, bgpVersion}}
+	}
+	o := openMsg{
+		ASN:      uint32(binary.BigEndian.Uint16(body[1:])),
+		HoldTime: binary.BigEndian.Uint16(body[3:]),
+		RouterID: net.IP(append([]byte(nil), body[5:9]...)),
+	}
+	params := body[10:]
+	if int(body[9]) != len(params) {
+		return openMsg{}, notificationMsg{Code: errOpenMessage}
+	}
+	for len(params) >= 2 {
+		ptype, plen := params[0], int(params[1])
+		if len(params) < 2+plen {
+			return openMsg{}, notificationMsg{Code: errOpenMessage}
+		}
+		value := params[2 : 2+plen]
+		params = params[2+plen:]
+		if ptype != 2 {
+			continue
+		}
+		for len(value) >= 2 {
+			code, clen := value[0], int(value[1])
+			if len(value) < 2+clen {
+				return openMsg{}, notificationMsg{Code: errOpenMessage}
+			}
+			data := value[2 : 2+clen]
+			value = value[2+clen:]
+			switch {
+			case code == capMultiprotocol && clen == 4:
+				o.Families = append(o.Families, Family{AFI: binary.BigEndian.Uint16(data), SAFI: data[3]})
+			case code == capFourOctetAS && clen == 4:
+				o.FourOctetAS = true
+				o.ASN = binary.BigEndian.Uint32(data)
+			}
+		}
+	}
+	return o, nil
+}
+
+func encodeNotification(n notificationMsg) []byte {
+	body := append([]byte{n.Code, n.Subcode}, n.Data...)
+	return frame(msgNotification, body)
+}
+
+func decodeNotification(body []byte) notificationMsg {
+	if len(body) < 2 {
+		return notificationMsg{}
+	}
+	return notificationMsg{Code: body[0], Subcode: body[1], Data: append([]byte(nil), body[2:]...)}
+}
+
+func encodeKeepalive() []byte {
+	return frame(msgKeepalive, nil)
+}
+
+func encodeUpdate(u updateMsg, as4 bool) []byte {
+	var withdrawn4, withdrawn6, nlri4, nlri6 []net.IPNet
+	for _, prefix := range u.Withdrawn {
+		if prefix.IP.To4() != nil {
+			withdrawn4 = append(withdrawn4, prefix)
+		} else {
+			withdrawn6 = append(withdrawn6, prefix)
+		}
+	}
+	for _, prefix := range u.NLRI {
+		if prefix.IP.To4() != nil {
+			nlri4 = append(nlri4, prefix)
+		} else {
+			nlri6 = append(nlri6, prefix)
+		}
+	}
+
+	var attrs bytes.Buffer
+	if u.Attrs != nil && len(u.NLRI) > 0 {
+		a := u.Attrs
+		writeAttr(&attrs, flagTransitive, attrOrigin, []byte{a.Origin})
+		writeAttr(&attrs, flagTransitive, attrASPath, encodeASPath(a.ASPath, as4))
+		if len(nlri4) > 0 {
+			writeAttr(&attrs, flagTransitive, attrNextHop, nextHopV4(a.NextHop))
+		}
+		if a.HasMED {
+			writeAttr(&attrs, flagOptional, attrMED, be32(a.MED))
+		}
+		if a.HasLocalPref {
+			writeAttr(&attrs, flagTransitive, attrLocalPref, be32(a.LocalPref))
+		}
+		if len(nlri6) > 0 {
+			var mp bytes.Buffer
+			mp.Write([]byte{0, 2, 1, 16})
+			mp.Write(nextHopV6(a.NextHop6))
+			mp.WriteByte(0)
+			for _, prefix := range nlri6 {
+				writePrefix(&mp, prefix)
+			}
+			writeAttr(&attrs, flagOptional, attrMPReach, mp.Bytes())
+		}
+	}
+	if len(withdrawn6) > 0 {
+		var mp bytes.Buffer
+		mp.Write([]byte{0, 2, 1})
+		for _, prefix := range withdrawn6 {
+			writePrefix(&mp, prefix)
+		}
+		writeAttr(&attrs, flagOptional, attrMPUnreach, mp.Bytes())
+	}
+
+	var withdrawn bytes.Buffer
+	for _, prefix := range withdrawn4 {
+		writePrefix(&withdrawn, prefix)
+	}
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, uint16(withdrawn.Len()))
+	body.Write(withdrawn.Bytes())
+	_ = binary.Write(&body, binary.BigEndian, uint16(attrs.Len()))
+	body.Write(attrs.Bytes())
+	for _, prefix := range nlri4 {
+		writePrefix(&body, prefix)
+	}
+	return frame(msgUpdate, body.Bytes())
+}
+
+func decodeUpdate(body []byte, as4 bool) (updateMsg, error) {
+	malformed := notificationMsg{Code: errUpdateMessage, Subcode: subMalformedAttrs}
+	if len(body) < 4 {
+		return updateMsg{}, malformed
+	}
+	var u updateMsg
+	wlen := int(binary.BigEndian.Uint16(body))
+	if len(body) < 2+wlen+2 {
+		return updateMsg{}, malformed
+	}
+	withdrawn, err := readPrefixes(body[2:2+wlen], false)
+	if err != nil {
+		return updateMsg{}, malformed
+	}
+	u.Withdrawn = withdrawn
+	rest := body[2+wlen:]
+	alen := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+alen {
+		return updateMsg{}, malformed
+	}
+	attrData := rest[2 : 2+alen]
+	nlri, err := readPrefixes(rest[2+alen:], false)
+	if err != nil {
+		return updateMsg{}, malformed
+	}
+	u.NLRI = nlri
+
+	attrs := &PathAttrs{}
+	hasAttrs := false
+	for len(attrData) >= 3 {
+		flags, code := attrData[0], attrData[1]
+		var length, hdr int
+		if flags&flagExtended != 0 {
+			if len(attrData) < 4 {
+				return updateMsg{}, malformed
+			}
+			length, hdr = int(binary.BigEndian.Uint16(attrData[2:])), 4
+		} else {
+			length, hdr = int(attrData[2]), 3
+		}
+		if len(attrData) < hdr+length {
+			return updateMsg{}, malformed
+		}
+		value := attrData[hdr : hdr+length]
+		attrData = attrData[hdr+length:]
+		hasAttrs = true
+		switch code {
+		case attrOrigin:
+			if length != 1 {
+				return updateMsg{}, malformed
+			}
+			attrs.Origin = value[0]
+		case attrASPath:
+			path, err := decodeASPath(value, as4)
+			if err != nil {
+				return updateMsg{}, malformed
+			}
+			attrs.ASPath = path
+		case attrNextHop:
+			if length != 4 {
+				return updateMsg{}, malformed
+			}
+			if attrs.NextHop == nil {
+				attrs.NextHop = net.IP(append([]byte(nil), value...))
+			}
+		case attrMED:
+			if length != 4 {
+				return updateMsg{}, malformed
+			}
+			attrs.MED, attrs.HasMED = binary.BigEndian.Uint32(value), true
+		case attrLocalPref:
+			if length != 4 {
+				return updateMsg{}, malformed
+			}
+			attrs.LocalPref, attrs.HasLocalPref = binary.BigEndian.Uint32(value), true
+		case attrMPReach:
+			if length < 5 {
+				return updateMsg{}, malformed
+			}
+			fam := Family{AFI: binary.BigEndian.Uint16(value), SAFI: value[2]}
+			nhLen := int(value[3])
+			if length < 5+nhLen {
+				return updateMsg{}, malformed
+			}
+			if fam != FamilyIPv6Unicast {
+				continue
+			}
+			if nhLen == 16 || nhLen == 32 {
+				attrs.NextHop6 = net.IP(append([]byte(nil), value[4:20]...))
+			}
+			prefixes, err := readPrefixes(value[5+nhLen:], true)
+			if err != nil {
+				return updateMsg{}, malformed
+			}
+			u.NLRI = append(u.NLRI, prefixes...)
+		case attrMPUnreach:
+			if length < 3 {
+				return updateMsg{}, malformed
+			}
+			fam := Family{AFI: binary.BigEndian.Uint16(value), SAFI: value[2]}
+			if fam != FamilyIPv6Unicast {
+				continue
+			}
+			prefixes, err := readPrefixes(value[3:], true)
+			if err != nil {
+				return updateMsg{}, malformed
+			}
+			u.Withdrawn = append(u.Withdrawn, prefixes...)
+		}
+	}
+	if len(attrData) != 0 {
+		return updateMsg{}, malformed
+	}
+	if hasAttrs {
+		u.Attrs = attrs
+	}
+	if len(u.NLRI) > 0 && u.Attrs == nil {
+		return updateMsg{}, notificationMsg{Code: errUpdateMessage, Subcode: 3}
+	}
+	return u, nil
+}
+
+func writeAttr(buf *bytes.Buffer, flags byte, code byte, value []byte) {
+	if len(value) > 255 {
+		buf.Write([]byte{flags | flagExtended, code, byte(len(value) >> 8), byte(len(value))})
+	} else {
+		buf.Write([]byte{flags, code, byte(len(value))})
+	}
+	buf.Write(value)
+}
+
+func encodeASPath(path []uint32, as4 bool) []byte {
+	var buf bytes.Buffer
+	for len(path) > 0 {
+		n := len(path)
+		if n > 255 {
+			n = 255
+		}
+		buf.Write([]byte{segmentSequence, byte(n)})
+		for _, asn := range path[:n] {
+			if as4 {
+				_ = binary.Write(&buf, binary.BigEndian, asn)
+				continue
+			}
+			if asn > 0xffff {
+				asn = asTrans
+			}
+			_ = binary.Write(&buf, binary.BigEndian, uint16(asn))
+		}
+		path = path[n:]
+	}
+	return buf.Bytes()
+}
+
+func decodeASPath(data []byte, as4 bool) ([]uint32, error) {
+	size := 2
+	if as4 {
+		size = 4
+	}
+	var path []uint32
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, errors.New("short as_path segment")
+		}
+		segType, count := data[0], int(data[1])
+		if segType != segmentSet && segType != segmentSequence {
+			return nil, errors.New("bad as_path segment type")
+		}
+		if len(data) < 2+count*size {
+			return nil, errors.New("short as_path segment")
+		}
+		for i := 0; i < count; i++ {
+			off := 2 + i*size
+			if as4 {
+				path = append(path, binary.BigEndian.Uint32(data[off:]))
+			} else {
+				path = append(path, uint32(binary.BigEndian.Uint16(data[off:])))
+			}
+		}
+		data = data[2+count*size:]
+	}
+	return path, nil
+}
+
+func writePrefix(buf *bytes.Buffer, prefix net.IPNet) {
+	ones, _ := prefix.Mask.Size()
+	ip := prefix.IP.To4()
+	if ip == nil {
+		ip = prefix.IP.To16()
+	}
+	buf.WriteByte(byte(ones))
+	buf.Write(ip[:(ones+7)/8])
+}
+
+func readPrefixes(data []byte, v6 bool) ([]net.IPNet, error) {
+	size := 4
+	if v6 {
+		size = 16
+	}
+	var out []net.IPNet
+	for len(data) > 0 {
+		ones := int(data[0])
+		n := (ones + 7) / 8
+		if ones > size*8 || len(data) < 1+n {
+			return nil, errors.New("bad prefix")
+		}
+		ip := make(net.IP, size)
+		copy(ip, data[1:1+n])
+		mask := net.CIDRMask(ones, size*8)
+		out = append(out, net.IPNet{IP: ip.Mask(mask), Mask: mask})
+		data = data[1+n:]
+	}
+	return out, nil
+}
+
+func nextHopV4(ip net.IP) []byte {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return make([]byte, 4)
+}
+
+func nextHopV6(ip net.IP) []byte {
+	if ip == nil {
+		return make([]byte, 16)
+	}
+	return ip.To16()
+}
+
+func be32(v uint32) []byte {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, v)
+	return out
+}
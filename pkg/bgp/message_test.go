@@ -0,0 +1,113 @@
+package bgp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func mustPrefix(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cidr, err)
+	}
+	return *prefix
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	msg := encodeOpen(openMsg{
+		ASN:         4200000001,
+		HoldTime:    90,
+		RouterID:    net.ParseIP("192.0.2.1"),
+		Families:    []Family{FamilyIPv4Unicast, FamilyIPv6Unicast},
+		FourOctetAS: true,
+	})
+	msgType, body, err := readMessage(bytes.NewReader(msg))
+	if err != nil || msgType != msgOpen {
+		t.Fatalf("read open: type=%d err=%v", msgType, err)
+	}
+	open, err := decodeOpen(body)
+	if err != nil {
+		t.Fatalf("decode open: %v", err)
+	}
+	if open.ASN != 4200000001 || !open.FourOctetAS || open.HoldTime != 90 || !open.RouterID.Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("unexpected open: %+v", open)
+	}
+	if len(open.Families) != 2 || open.Families[1] != FamilyIPv6Unicast {
+		t.Fatalf("unexpected families: %+v", open.Families)
+	}
+}
+
+func TestUpdateRoundTripMultiprotocol(t *testing.T) {
+	attrs := &PathAttrs{
+		Origin:       OriginIGP,
+		ASPath:       []uint32{65001, 4200000001},
+		NextHop:      net.ParseIP("192.0.2.1").To4(),
+		NextHop6:     net.ParseIP("2001:db8::1"),
+		MED:          50,
+		HasMED:       true,
+		LocalPref:    200,
+		HasLocalPref: true,
+	}
+	in := updateMsg{
+		Withdrawn: []net.IPNet{mustPrefix(t, "198.51.100.0/24"), mustPrefix(t, "2001:db8:ff::/48")},
+		Attrs:     attrs,
+		NLRI:      []net.IPNet{mustPrefix(t, "203.0.113.0/24"), mustPrefix(t, "10.0.0.0/8"), mustPrefix(t, "2001:db8:1::/48")},
+	}
+	_, body, err := readMessage(bytes.NewReader(encodeUpdate(in, true)))
+	if err != nil {
+		t.Fatalf("read update: %v", err)
+	}
+	out, err := decodeUpdate(body, true)
+	if err != nil {
+		t.Fatalf("decode update: %v", err)
+	}
+	if len(out.Withdrawn) != 2 || out.Withdrawn[1].String() != "2001:db8:ff::/48" {
+		t.Fatalf("unexpected withdrawn: %v", out.Withdrawn)
+	}
+	if len(out.NLRI) != 3 || out.NLRI[0].String() != "203.0.113.0/24" || out.NLRI[2].String() != "2001:db8:1::/48" {
+		t.Fatalf("unexpected nlri: %v", out.NLRI)
+	}
+	got := out.Attrs
+	if got == nil || got.MED != 50 || got.LocalPref != 200 || len(got.ASPath) != 2 || got.ASPath[1] != 4200000001 {
+		t.Fatalf("unexpected attrs: %+v", got)
+	}
+	if !got.NextHop.Equal(net.ParseIP("192.0.2.1")) || !got.NextHop6.Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("unexpected next hops: %s %s", got.NextHop, got.NextHop6)
+	}
+}
+
+func TestTwoOctetASPathUsesASTrans(t *testing.T) {
+	path, err := decodeASPath(encodeASPath([]uint32{65001, 4200000001}, false), false)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(path) != 2 || path[0] != 65001 || path[1] != asTrans {
+		t.Fatalf("unexpected path: %v", path)
+	}
+}
+
+func TestPolicyTermMatching(t *testing.T) {
+	med := uint32(10)
+	policy := Policy{
+		Terms: []PolicyTerm{
+			{Prefix: mustPrefix(t, "0.0.0.0/0"), Action: ActionReject},
+			{Prefix: mustPrefix(t, "10.0.0.0/8"), LE: 24, Action: ActionAccept, LocalPref: 300, MED: &med},
+		},
+		DefaultAction: ActionReject,
+	}
+	attrs := PathAttrs{}
+	if policy.apply(mustPrefix(t, "0.0.0.0/0"), &attrs) {
+		t.Fatalf("default route must be rejected")
+	}
+	if !policy.apply(mustPrefix(t, "10.1.0.0/16"), &attrs) || attrs.LocalPref != 300 || attrs.MED != 10 {
+		t.Fatalf("expected 10.1.0.0/16 accepted with local pref, got %+v", attrs)
+	}
+	if policy.apply(mustPrefix(t, "10.1.1.0/25"), &PathAttrs{}) {
+		t.Fatalf("prefix longer than le must fall through to default reject")
+	}
+	if policy.apply(mustPrefix(t, "192.0.2.0/24"), &PathAttrs{}) {
+		t.Fatalf("unmatched prefix must use default action")
+	}
+}
@@ -0,0 +1,70 @@
+package bgp
+
+import (
+	"net"
+	"strings"
+)
+
+const (
+	ActionAccept = "accept"
+	ActionReject = "reject"
+)
+
+type PolicyTerm struct {
+	Prefix    net.IPNet
+	GE        int
+	LE        int
+	Action    string
+	LocalPref uint32
+	MED       *uint32
+	Prepend   int
+}
+
+type Policy struct {
+	Terms         []PolicyTerm
+	DefaultAction string
+}
+
+func (p Policy) apply(prefix net.IPNet, attrs *PathAttrs) bool {
+	for _, term := range p.Terms {
+		if !term.matches(prefix) {
+			continue
+		}
+		if strings.EqualFold(term.Action, ActionReject) {
+			return false
+		}
+		if term.LocalPref != 0 {
+			attrs.LocalPref, attrs.HasLocalPref = term.LocalPref, true
+		}
+		if term.MED != nil {
+			attrs.MED, attrs.HasMED = *term.MED, true
+		}
+		if term.Prepend > 0 && len(attrs.ASPath) > 0 {
+			path := make([]uint32, 0, len(attrs.ASPath)+term.Prepend)
+			for i := 0; i < term.Prepend; i++ {
+				path = append(path, attrs.ASPath[0])
+			}
+			attrs.ASPath = append(path, attrs.ASPath...)
+		}
+		return true
+	}
+	return !strings.EqualFold(p.DefaultAction, ActionReject)
+}
+
+func (t PolicyTerm) matches(prefix net.IPNet) bool {
+	termLen, termBits := t.Prefix.Mask.Size()
+	ones, bits := prefix.Mask.Size()
+	if termBits != bits || ones < termLen || !t.Prefix.Contains(prefix.IP) {
+		return false
+	}
+	ge, le := t.GE, t.LE
+	switch {
+	case ge == 0 && le == 0:
+		return ones == termLen
+	case le == 0:
+		le = bits
+	case ge == 0:
+		ge = termLen
+	}
+	return ones >= ge && ones <= le
+}
@@ -0,0 +1,992 @@
+package bgp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"router-go/pkg/routing"
+)
+
+const (
+	Source       = "bgp"
+	EBGPDistance = 20
+	IBGPDistance = 200
+
+	defaultLocalPref  = 100
+	openHoldTime      = 4 * time.Minute
+	maxPrefixesPerMsg = 200
+)
+
+const (
+	StateIdle        = "idle"
+	StateConnect     = "connect"
+	StateActive      = "active"
+	StateOpenSent    = "open_sent"
+	StateOpenConfirm = "open_confirm"
+	StateEstablished = "established"
+)
+
+type Config struct {
+	ASN            uint32
+	RouterID       net.IP
+	ListenAddress  string
+	HoldTime       time.Duration
+	ConnectRetry   time.Duration
+	ExportInterval time.Duration
+	Networks       []net.IPNet
+	ExportStatic   bool
+	Neighbors      []NeighborConfig
+}
+
+type NeighborConfig struct {
+	Address     net.IP
+	Port        int
+	RemoteASN   uint32
+	Description string
+	Passive     bool
+	HoldTime    time.Duration
+	MaxPrefixes int
+	Import      Policy
+	Export      Policy
+}
+
+type NeighborStatus struct {
+	Address       string
+	Port          int
+	RemoteASN     uint32
+	Description   string
+	State         string
+	RemoteID      string
+	EstablishedAt time.Time
+	Families      []string
+	Received      int
+	Accepted      int
+	Advertised    int
+	MaxPrefixes   int
+	Flaps         int
+	LastError     string
+}
+
+type RIBEntry struct {
+	Prefix    net.IPNet
+	NextHop   net.IP
+	ASPath    []uint32
+	Origin    uint8
+	LocalPref uint32
+	MED       uint32
+	Peer      string
+	External  bool
+	Best      bool
+}
+
+type path struct {
+	prefix  net.IPNet
+	attrs   PathAttrs
+	nextHop net.IP
+	peer    *peer
+}
+
+type Speaker struct {
+	cfg       Config
+	table     *routing.Table
+	peers     []*peer
+	mu        sync.Mutex
+	best      map[string]*path
+	installed map[string]routing.Route
+	listener  net.Listener
+	onState   func(neighbor string, state string, reason string)
+}
+
+type peer struct {
+	s             *Speaker
+	cfg           NeighborConfig
+	ebgp          bool
+	kick          chan struct{}
+	mu            sync.Mutex
+	state         string
+	active        *session
+	remoteID      net.IP
+	families      []Family
+	establishedAt time.Time
+	received      map[string]struct{}
+	adjIn         map[string]*path
+	adjOut        map[string]string
+	flaps         int
+	lastError     string
+	bfdDown       bool
+}
+
+type session struct {
+	conn        net.Conn
+	outgoing    bool
+	wmu         sync.Mutex
+	as4         bool
+	families    map[Family]bool
+	remoteID    net.IP
+	localIP     net.IP
+	hold        time.Duration
+	established bool
+}
+
+type exportEntry struct {
+	prefix net.IPNet
+	attrs  PathAttrs
+}
+
+func NewSpeaker(cfg Config, table *routing.Table) (*Speaker, error) {
+	if cfg.ASN == 0 {
+		return nil, errors.New("bgp asn is required")
+	}
+	if cfg.RouterID.To4() == nil || cfg.RouterID.To4().IsUnspecified() {
+		return nil, errors.New("bgp router_id must be a non-zero IPv4 address")
+	}
+	if cfg.ListenAddress == "" {
+		cfg.ListenAddress = ":179"
+	}
+	if cfg.HoldTime == 0 {
+		cfg.HoldTime = 90 * time.Second
+	}
+	if cfg.ConnectRetry <= 0 {
+		cfg.ConnectRetry = 5 * time.Second
+	}
+	if cfg.ExportInterval <= 0 {
+		cfg.ExportInterval = 5 * time.Second
+	}
+	s := &Speaker{
+		cfg:       cfg,
+		table:     table,
+		best:      map[string]*path{},
+		installed: map[string]routing.Route{},
+	}
+	seen := map[string]struct{}{}
+	for _, nc := range cfg.Neighbors {
+		if nc.Address == nil {
+			return nil, errors.New("bgp neighbor address is required")
+		}
+		if nc.RemoteASN == 0 {
+			return nil, fmt.Errorf("bgp neighbor %s remote asn is required", nc.Address)
+		}
+		if _, ok := seen[nc.Address.String()]; ok {
+			return nil, fmt.Errorf("bgp neighbor %s is duplicated", nc.Address)
+		}
+		seen[nc.Address.String()] = struct{}{}
+		if nc.Port == 0 {
+			nc.Port = 179
+		}
+		if nc.HoldTime == 0 {
+			nc.HoldTime = cfg.HoldTime
+		}
+		s.peers = append(s.peers, &peer{
+			s:        s,
+			cfg:      nc,
+			ebgp:     nc.RemoteASN != cfg.ASN,
+			kick:     make(chan struct{}, 1),
+			state:    StateIdle,
+			received: map[string]struct{}{},
+			adjIn:    map[string]*path{},
+			adjOut:   map[string]string{},
+		})
+	}
+	return s, nil
+}
+
+func (s *Speaker) SetOnStateChange(fn func(neighbor string, state string, reason string)) {
+	s.mu.Lock()
+	s.onState = fn
+	s.mu.Unlock()
+}
+
+func (s *Speaker) Start(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.cfg.ListenAddress)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+	go s.acceptLoop(ctx, ln)
+	for _, p := range s.peers {
+		go p.run(ctx)
+	}
+	return nil
+}
+
+func (s *Speaker) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Speaker) Neighbors() []NeighborStatus {
+	out := make([]NeighborStatus, 0, len(s.peers))
+	for _, p := range s.peers {
+		p.mu.Lock()
+		st := NeighborStatus{
+			Address:       p.cfg.Address.String(),
+			Port:          p.cfg.Port,
+			RemoteASN:     p.cfg.RemoteASN,
+			Description:   p.cfg.Description,
+			State:         p.state,
+			EstablishedAt: p.establishedAt,
+			Received:      len(p.received),
+			Accepted:      len(p.adjIn),
+			Advertised:    len(p.adjOut),
+			MaxPrefixes:   p.cfg.MaxPrefixes,
+			Flaps:         p.flaps,
+			LastError:     p.lastError,
+		}
+		if p.remoteID != nil {
+			st.RemoteID = p.remoteID.String()
+		}
+		for _, fam := range p.families {
+			st.Families = append(st.Families, fam.String())
+		}
+		p.mu.Unlock()
+		out = append(out, st)
+	}
+	return out
+}
+
+func (s *Speaker) RIB() []RIBEntry {
+	s.mu.Lock()
+	best := make(map[string]*path, len(s.best))
+	for k, v := range s.best {
+		best[k] = v
+	}
+	s.mu.Unlock()
+	var out []RIBEntry
+	for _, p := range s.peers {
+		p.mu.Lock()
+		for key, candidate := range p.adjIn {
+			out = append(out, RIBEntry{
+				Prefix:    candidate.prefix,
+				NextHop:   candidate.nextHop,
+				ASPath:    append([]uint32(nil), candidate.attrs.ASPath...),
+				Origin:    candidate.attrs.Origin,
+				LocalPref: localPref(candidate.attrs),
+				MED:       candidate.attrs.MED,
+				Peer:      p.cfg.Address.String(),
+				External:  p.ebgp,
+				Best:      best[key] == candidate,
+			})
+		}
+		p.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].Prefix.String(), out[j].Prefix.String()
+		if a != b {
+			return a < b
+		}
+		return out[i].Peer < out[j].Peer
+	})
+	return out
+}
+
+// SetBFDState tears the session to the neighbor down as soon as its BFD
+// session fails and keeps it down until BFD reports the neighbor up again.
+func (s *Speaker) SetBFDState(addr net.IP, up bool, reason string) bool {
+	var p *peer
+	for _, candidate := range s.peers {
+		if candidate.cfg.Address.Equal(addr) {
+			p = candidate
+		}
+	}
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	p.bfdDown = !up
+	sess := p.active
+	p.mu.Unlock()
+	if up || sess == nil {
+		return true
+	}
+	if reason == "" {
+		reason = "bfd down"
+	} else {
+		reason = "bfd down: " + reason
+	}
+	_ = sess.write(encodeNotification(notificationMsg{Code: errCease, Subcode: subBFDDown}))
+	p.release(sess, reason)
+	_ = sess.conn.Close()
+	return true
+}
+
+func (s *Speaker) acceptLoop(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		remote, _ := conn.RemoteAddr().(*net.TCPAddr)
+		p := s.peerFor(remote)
+		if p == nil {
+			_ = conn.Close()
+			continue
+		}
+		go s.runSession(ctx, p, conn, false)
+	}
+}
+
+func (s *Speaker) peerFor(addr *net.TCPAddr) *peer {
+	if addr == nil {
+		return nil
+	}
+	for _, p := range s.peers {
+		if p.cfg.Address.Equal(addr.IP) {
+			return p
+		}
+	}
+	return nil
+}
+
+func (p *peer) run(ctx context.Context) {
+	s := p.s
+	addr := net.JoinHostPort(p.cfg.Address.String(), strconv.Itoa(p.cfg.Port))
+	for {
+		if p.heldByBFD() {
+			p.setState(StateIdle, "")
+		} else if !p.cfg.Passive && !p.busy() {
+			p.setState(StateConnect, "")
+			dialer := net.Dialer{Timeout: s.cfg.ConnectRetry}
+			conn, err := dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				p.setState(StateActive, err.Error())
+			} else {
+				s.runSession(ctx, p, conn, true)
+			}
+		} else if p.cfg.Passive && !p.busy() {
+			p.setState(StateActive, "")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.cfg.ConnectRetry):
+		}
+	}
+}
+
+func (s *Speaker) runSession(ctx context.Context, p *peer, conn net.Conn, outgoing bool) {
+	sess := &session{conn: conn, outgoing: outgoing}
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		sess.localIP = local.IP
+	}
+	defer conn.Close()
+	if p.heldByBFD() {
+		return
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = sess.write(encodeNotification(notificationMsg{Code: errCease, Subcode: subAdminShutdown}))
+		_ = conn.Close()
+	})
+	defer stop()
+
+	p.setStateIfIdle(StateOpenSent)
+	err := sess.write(encodeOpen(openMsg{
+		ASN:         s.cfg.ASN,
+		HoldTime:    uint16(p.cfg.HoldTime / time.Second),
+		RouterID:    s.cfg.RouterID,
+		Families:    []Family{FamilyIPv4Unicast, FamilyIPv6Unicast},
+		FourOctetAS: true,
+	}))
+	if err != nil {
+		p.setStateIfIdle(StateActive)
+		return
+	}
+	reader := bufio.NewReader(conn)
+	_ = conn.SetReadDeadline(time.Now().Add(openHoldTime))
+	open, err := s.receiveOpen(p, sess, reader)
+	if err != nil {
+		var notif notificationMsg
+		if errors.As(err, &notif) && notif.Code != 0 {
+			_ = sess.write(encodeNotification(notif))
+		}
+		p.setStateIfIdle(StateActive)
+		p.noteError(err)
+		return
+	}
+	sess.remoteID = open.RouterID
+	sess.as4 = open.FourOctetAS
+	sess.hold = p.cfg.HoldTime
+	if remoteHold := time.Duration(open.HoldTime) * time.Second; remoteHold < sess.hold {
+		sess.hold = remoteHold
+	}
+	sess.families = map[Family]bool{}
+	if len(open.Families) == 0 {
+		sess.families[FamilyIPv4Unicast] = true
+	}
+	for _, fam := range open.Families {
+		if fam == FamilyIPv4Unicast || fam == FamilyIPv6Unicast {
+			sess.families[fam] = true
+		}
+	}
+
+	if !p.claim(sess, s.cfg.RouterID) {
+		_ = sess.write(encodeNotification(notificationMsg{Code: errCease, Subcode: subCollision}))
+		return
+	}
+	reason := ""
+	defer func() {
+		p.release(sess, reason)
+	}()
+	if err := sess.write(encodeKeepalive()); err != nil {
+		reason = err.Error()
+		return
+	}
+	p.setState(StateOpenConfirm, "")
+
+	for established := false; !established; {
+		msgType, body, err := s.readWithHold(sess, reader)
+		if err != nil {
+			reason = err.Error()
+			return
+		}
+		switch msgType {
+		case msgKeepalive:
+			p.establish(sess)
+			established = true
+		case msgNotification:
+			reason = decodeNotification(body).Error()
+			return
+		default:
+			_ = sess.write(encodeNotification(notificationMsg{Code: errFSM}))
+			reason = "unexpected message in open_confirm"
+			return
+		}
+	}
+
+	writerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.writeLoop(writerCtx, p, sess)
+
+	for {
+		msgType, body, err := s.readWithHold(sess, reader)
+		if err != nil {
+			reason = err.Error()
+			return
+		}
+		switch msgType {
+		case msgUpdate:
+			if err := s.handleUpdate(p, sess, body); err != nil {
+				var notif notificationMsg
+				if errors.As(err, &notif) {
+					_ = sess.write(encodeNotification(notif))
+				}
+				reason = err.Error()
+				return
+			}
+		case msgKeepalive:
+		case msgNotification:
+			reason = decodeNotification(body).Error()
+			return
+		default:
+			_ = sess.write(encodeNotification(notificationMsg{Code: errFSM}))
+			reason = "unexpected message in established"
+			return
+		}
+	}
+}
+
+func (s *Speaker) receiveOpen(p *peer, sess *session, reader *bufio.Reader) (openMsg, error) {
+	msgType, body, err := readMessage(reader)
+	if err != nil {
+		return openMsg{}, err
+	}
+	switch msgType {
+	case msgOpen:
+	case msgNotification:
+		return openMsg{}, fmt.Errorf("peer sent %s", decodeNotification(body).Error())
+	default:
+		return openMsg{}, notificationMsg{Code: errFSM}
+	}
+	open, err := decodeOpen(body)
+	if err != nil {
+		return openMsg{}, err
+	}
+	if open.ASN != p.cfg.RemoteASN {
+		return openMsg{}, notificationMsg{Code: errOpenMessage, Subcode: subBadPeerAS}
+	}
+	if open.RouterID.IsUnspecified() || open.RouterID.Equal(s.cfg.RouterID) {
+		return openMsg{}, notificationMsg{Code: errOpenMessage, Subcode: subBadBGPID}
+	}
+	if open.HoldTime == 1 || open.HoldTime == 2 {
+		return openMsg{}, notificationMsg{Code: errOpenMessage, Subcode: subUnacceptHold}
+	}
+	return open, nil
+}
+
+func (s *Speaker) readWithHold(sess *session, reader *bufio.Reader) (byte, []byte, error) {
+	if sess.hold > 0 {
+		_ = sess.conn.SetReadDeadline(time.Now().Add(sess.hold))
+	} else {
+		_ = sess.conn.SetReadDeadline(time.Time{})
+	}
+	msgType, body, err := readMessage(reader)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			_ = sess.write(encodeNotification(notificationMsg{Code: errHoldTimer}))
+			return 0, nil, errors.New("hold timer expired")
+		}
+		var notif notificationMsg
+		if errors.As(err, &notif) {
+			_ = sess.write(encodeNotification(notif))
+		}
+		return 0, nil, err
+	}
+	return msgType, body, nil
+}
+
+func (s *Speaker) writeLoop(ctx context.Context, p *peer, sess *session) {
+	var keepalive <-chan time.Time
+	if sess.hold > 0 {
+		ticker := time.NewTicker(sess.hold / 3)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+	exportTicker := time.NewTicker(s.cfg.ExportInterval)
+	defer exportTicker.Stop()
+	if err := s.export(p, sess); err != nil {
+		_ = sess.conn.Close()
+		return
+	}
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepalive:
+			err = sess.write(encodeKeepalive())
+		case <-p.kick:
+			err = s.export(p, sess)
+		case <-exportTicker.C:
+			err = s.export(p, sess)
+		}
+		if err != nil {
+			_ = sess.conn.Close()
+			return
+		}
+	}
+}
+
+func (s *Speaker) handleUpdate(p *peer, sess *session, body []byte) error {
+	u, err := decodeUpdate(body, sess.as4)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	p.mu.Lock()
+	if p.active != sess {
+		p.mu.Unlock()
+		return errors.New("session released")
+	}
+	for _, prefix := range u.Withdrawn {
+		key := prefix.String()
+		delete(p.received, key)
+		if _, ok := p.adjIn[key]; ok {
+			delete(p.adjIn, key)
+			changed = append(changed, key)
+		}
+	}
+	for _, prefix := range u.NLRI {
+		key := prefix.String()
+		p.received[key] = struct{}{}
+		changed = append(changed, key)
+		attrs := *u.Attrs
+		attrs.ASPath = append([]uint32(nil), u.Attrs.ASPath...)
+		nextHop := attrs.NextHop
+		if prefix.IP.To4() == nil {
+			nextHop = attrs.NextHop6
+		}
+		if nextHop == nil || containsASN(attrs.ASPath, s.cfg.ASN) || !p.cfg.Import.apply(prefix, &attrs) {
+			delete(p.adjIn, key)
+			continue
+		}
+		p.adjIn[key] = &path{prefix: prefix, attrs: attrs, nextHop: nextHop, peer: p}
+	}
+	overLimit := p.cfg.MaxPrefixes > 0 && len(p.adjIn) > p.cfg.MaxPrefixes
+	p.mu.Unlock()
+	if overLimit {
+		return notificationMsg{Code: errCease, Subcode: subMaxPrefixes}
+	}
+	s.recompute(changed)
+	return nil
+}
+
+func (s *Speaker) recompute(keys []string) {
+	s.mu.Lock()
+	changed := false
+	for _, key := range keys {
+		var best *path
+		for _, p := range s.peers {
+			p.mu.Lock()
+			candidate := p.adjIn[key]
+			p.mu.Unlock()
+			if candidate != nil && (best == nil || betterPath(candidate, best)) {
+				best = candidate
+			}
+		}
+		if s.best[key] != best {
+			changed = true
+		}
+		old, installed := s.installed[key]
+		if best == nil {
+			delete(s.best, key)
+			if installed {
+				s.table.RemoveRoute(old)
+				delete(s.installed, key)
+			}
+			continue
+		}
+		s.best[key] = best
+		distance := IBGPDistance
+		if best.peer.ebgp {
+			distance = EBGPDistance
+		}
+		route := routing.Route{
+			Destination: best.prefix,
+			Gateway:     best.nextHop,
+			Metric:      int(best.attrs.MED),
+			Distance:    distance,
+			Source:      Source,
+		}
+		if installed && sameRoute(old, route) {
+			continue
+		}
+		if installed {
+			s.table.RemoveRoute(old)
+		}
+		s.table.Add(route)
+		s.installed[key] = route
+	}
+	s.mu.Unlock()
+	if changed {
+		for _, p := range s.peers {
+			p.poke()
+		}
+	}
+}
+
+func (s *Speaker) export(p *peer, sess *session) error {
+	desired := s.exportRoutes(p, sess)
+	var withdraw []net.IPNet
+	groups := map[string][]net.IPNet{}
+	groupAttrs := map[string]PathAttrs{}
+	p.mu.Lock()
+	if p.active != sess {
+		p.mu.Unlock()
+		return nil
+	}
+	for key := range p.adjOut {
+		if _, ok := desired[key]; !ok {
+			_, prefix, err := net.ParseCIDR(key)
+			if err == nil {
+				withdraw = append(withdraw, *prefix)
+			}
+			delete(p.adjOut, key)
+		}
+	}
+	for key, entry := range desired {
+		sig := attrsSignature(entry.attrs)
+		if p.adjOut[key] == sig {
+			continue
+		}
+		p.adjOut[key] = sig
+		groups[sig] = append(groups[sig], entry.prefix)
+		groupAttrs[sig] = entry.attrs
+	}
+	p.mu.Unlock()
+
+	for len(withdraw) > 0 {
+		n := min(len(withdraw), maxPrefixesPerMsg)
+		if err := sess.write(encodeUpdate(updateMsg{Withdrawn: withdraw[:n]}, sess.as4)); err != nil {
+			return err
+		}
+		withdraw = withdraw[n:]
+	}
+	sigs := make([]string, 0, len(groups))
+	for sig := range groups {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	for _, sig := range sigs {
+		prefixes := groups[sig]
+		attrs := groupAttrs[sig]
+		for len(prefixes) > 0 {
+			n := min(len(prefixes), maxPrefixesPerMsg)
+			if err := sess.write(encodeUpdate(updateMsg{Attrs: &attrs, NLRI: prefixes[:n]}, sess.as4)); err != nil {
+				return err
+			}
+			prefixes = prefixes[n:]
+		}
+	}
+	return nil
+}
+
+func (s *Speaker) exportRoutes(p *peer, sess *session) map[string]exportEntry {
+	out := map[string]exportEntry{}
+	add := func(prefix net.IPNet, attrs PathAttrs) {
+		key := prefix.String()
+		if _, ok := out[key]; ok {
+			return
+		}
+		v4 := prefix.IP.To4() != nil
+		if v4 && !sess.families[FamilyIPv4Unicast] || !v4 && !sess.families[FamilyIPv6Unicast] {
+			return
+		}
+		if local4 := sess.localIP.To4(); local4 != nil {
+			attrs.NextHop, attrs.NextHop6 = local4, local4.To16()
+		} else if v4 {
+			return
+		} else {
+			attrs.NextHop6 = sess.localIP
+		}
+		if p.ebgp {
+			attrs.ASPath = append([]uint32{s.cfg.ASN}, attrs.ASPath...)
+			attrs.LocalPref, attrs.HasLocalPref = 0, false
+		} else if !attrs.HasLocalPref {
+			attrs.LocalPref, attrs.HasLocalPref = defaultLocalPref, true
+		}
+		if !p.cfg.Export.apply(prefix, &attrs) {
+			return
+		}
+		out[key] = exportEntry{prefix: prefix, attrs: attrs}
+	}
+
+	for _, network := range s.cfg.Networks {
+		add(network, PathAttrs{Origin: OriginIGP})
+	}
+	s.mu.Lock()
+	best := make([]*path, 0, len(s.best))
+	for _, bp := range s.best {
+		best = append(best, bp)
+	}
+	s.mu.Unlock()
+	if s.cfg.ExportStatic {
+		// Only configured routes are announced; connected and protocol
+		// routes, including the ones installed from BGP, are not
+		// redistributed.
+		for _, route := range s.table.Routes() {
+			if route.TargetVRF != "" || !route.Configured() {
+				continue
+			}
+			add(route.Destination, PathAttrs{Origin: OriginIncomplete})
+		}
+	}
+	for _, bp := range best {
+		if bp.peer == p || !bp.peer.ebgp && !p.ebgp {
+			continue
+		}
+		attrs := bp.attrs
+		attrs.ASPath = append([]uint32(nil), bp.attrs.ASPath...)
+		if p.ebgp {
+			attrs.MED, attrs.HasMED = 0, false
+		}
+		add(bp.prefix, attrs)
+	}
+	return out
+}
+
+func (p *peer) busy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.active != nil
+}
+
+func (p *peer) heldByBFD() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bfdDown
+}
+
+func (p *peer) poke() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (p *peer) setState(state string, reason string) {
+	p.mu.Lock()
+	changed := p.state != state
+	p.state = state
+	if reason != "" {
+		p.lastError = reason
+	}
+	p.mu.Unlock()
+	if changed {
+		p.s.notifyState(p, state, reason)
+	}
+}
+
+func (p *peer) setStateIfIdle(state string) {
+	if !p.busy() {
+		p.setState(state, "")
+	}
+}
+
+func (p *peer) noteError(err error) {
+	p.mu.Lock()
+	p.lastError = err.Error()
+	p.mu.Unlock()
+}
+
+func (p *peer) claim(sess *session, localID net.IP) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	other := p.active
+	if other == nil {
+		p.active = sess
+		return true
+	}
+	if other.established {
+		return false
+	}
+	keepOutgoing := bytes.Compare(localID.To4(), sess.remoteID.To4()) > 0
+	if sess.outgoing != keepOutgoing {
+		return false
+	}
+	p.active = sess
+	_ = other.write(encodeNotification(notificationMsg{Code: errCease, Subcode: subCollision}))
+	_ = other.conn.Close()
+	return true
+}
+
+func (p *peer) establish(sess *session) {
+	p.mu.Lock()
+	sess.established = true
+	p.remoteID = sess.remoteID
+	p.families = p.families[:0]
+	for _, fam := range []Family{FamilyIPv4Unicast, FamilyIPv6Unicast} {
+		if sess.families[fam] {
+			p.families = append(p.families, fam)
+		}
+	}
+	p.establishedAt = time.Now()
+	p.received = map[string]struct{}{}
+	p.adjIn = map[string]*path{}
+	p.adjOut = map[string]string{}
+	p.lastError = ""
+	p.mu.Unlock()
+	p.setState(StateEstablished, "")
+}
+
+func (p *peer) release(sess *session, reason string) {
+	p.mu.Lock()
+	if p.active != sess {
+		p.mu.Unlock()
+		return
+	}
+	p.active = nil
+	if sess.established {
+		p.flaps++
+	}
+	keys := make([]string, 0, len(p.adjIn))
+	for key := range p.adjIn {
+		keys = append(keys, key)
+	}
+	p.received = map[string]struct{}{}
+	p.adjIn = map[string]*path{}
+	p.adjOut = map[string]string{}
+	p.establishedAt = time.Time{}
+	p.mu.Unlock()
+	p.s.recompute(keys)
+	state := StateActive
+	if p.cfg.Passive {
+		state = StateIdle
+	}
+	p.setState(state, reason)
+}
+
+func (s *Speaker) notifyState(p *peer, state string, reason string) {
+	s.mu.Lock()
+	fn := s.onState
+	s.mu.Unlock()
+	if fn != nil {
+		fn(p.cfg.Address.String(), state, reason)
+	}
+}
+
+func (sess *session) write(msg []byte) error {
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+	_ = sess.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := sess.conn.Write(msg)
+	return err
+}
+
+func betterPath(a *path, b *path) bool {
+	if la, lb := localPref(a.attrs), localPref(b.attrs); la != lb {
+		return la > lb
+	}
+	if len(a.attrs.ASPath) != len(b.attrs.ASPath) {
+		return len(a.attrs.ASPath) < len(b.attrs.ASPath)
+	}
+	if a.attrs.Origin != b.attrs.Origin {
+		return a.attrs.Origin < b.attrs.Origin
+	}
+	if a.attrs.MED != b.attrs.MED {
+		return a.attrs.MED < b.attrs.MED
+	}
+	if a.peer.ebgp != b.peer.ebgp {
+		return a.peer.ebgp
+	}
+	if c := bytes.Compare(a.peer.remoteIDLocked(), b.peer.remoteIDLocked()); c != 0 {
+		return c < 0
+	}
+	return bytes.Compare(a.peer.cfg.Address.To16(), b.peer.cfg.Address.To16()) < 0
+}
+
+func (p *peer) remoteIDLocked() []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.remoteID.To4()
+}
+
+func localPref(attrs PathAttrs) uint32 {
+	if attrs.HasLocalPref {
+		return attrs.LocalPref
+	}
+	return defaultLocalPref
+}
+
+func containsASN(path []uint32, asn uint32) bool {
+	for _, v := range path {
+		if v == asn {
+			return true
+		}
+	}
+	return false
+}
+
+func sameRoute(a routing.Route, b routing.Route) bool {
+	return a.Destination.String() == b.Destination.String() &&
+		a.Gateway.Equal(b.Gateway) &&
+		a.Metric == b.Metric &&
+		a.Distance == b.Distance
+}
+
+func attrsSignature(attrs PathAttrs) string {
+	return fmt.Sprintf("%d|%v|%s|%s|%t:%d|%t:%d", attrs.Origin, attrs.ASPath, attrs.NextHop, attrs.NextHop6, attrs.HasMED, attrs.MED, attrs.HasLocalPref, attrs.LocalPref)
+}
@@ -0,0 +1,198 @@
+package bgp
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"router-go/pkg/routing"
+)
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func startPair(t *testing.T, ctx context.Context, a Config, b Config, tableA *routing.Table, tableB *routing.Table) (*Speaker, *Speaker) {
+	t.Helper()
+	a.ListenAddress = "127.0.0.1:0"
+	a.ConnectRetry = 50 * time.Millisecond
+	a.Neighbors[0].Address = net.ParseIP("127.0.0.1")
+	a.Neighbors[0].Passive = true
+	speakerA, err := NewSpeaker(a, tableA)
+	if err != nil {
+		t.Fatalf("new speaker a: %v", err)
+	}
+	if err := speakerA.Start(ctx); err != nil {
+		t.Fatalf("start a: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(speakerA.Addr().String())
+	b.ListenAddress = "127.0.0.1:0"
+	b.ConnectRetry = 50 * time.Millisecond
+	b.Neighbors[0].Address = net.ParseIP("127.0.0.1")
+	b.Neighbors[0].Port, _ = strconv.Atoi(port)
+	speakerB, err := NewSpeaker(b, tableB)
+	if err != nil {
+		t.Fatalf("new speaker b: %v", err)
+	}
+	if err := speakerB.Start(ctx); err != nil {
+		t.Fatalf("start b: %v", err)
+	}
+	return speakerA, speakerB
+}
+
+func TestSpeakersExchangeRoutesOverLoopback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	staticNet := mustPrefix(t, "203.0.113.0/24")
+	tableA := routing.NewTable([]routing.Route{{Destination: staticNet, Interface: "wan0", Metric: 100}})
+	tableB := routing.NewTable([]routing.Route{
+		{Destination: mustPrefix(t, "10.20.0.0/16"), Interface: "lan0"},
+		{Destination: mustPrefix(t, "10.30.0.0/16"), Interface: "lan0", Source: routing.SourceConnected},
+		{Destination: mustPrefix(t, "10.40.0.0/16"), Gateway: net.ParseIP("10.20.0.2"), Interface: "lan0", Source: "ospf", Distance: 110},
+	})
+
+	speakerA, speakerB := startPair(t, ctx,
+		Config{
+			ASN:       65001,
+			RouterID:  net.ParseIP("192.0.2.1"),
+			Networks:  []net.IPNet{mustPrefix(t, "192.0.2.0/24")},
+			Neighbors: []NeighborConfig{{RemoteASN: 4200000002, Import: Policy{Terms: []PolicyTerm{{Prefix: mustPrefix(t, "198.51.100.0/24"), LE: 32, Action: ActionReject}}}}},
+		},
+		Config{
+			ASN:          4200000002,
+			RouterID:     net.ParseIP("192.0.2.2"),
+			ExportStatic: true,
+			Networks:     []net.IPNet{staticNet, mustPrefix(t, "198.51.100.0/25"), mustPrefix(t, "2001:db8:100::/48")},
+			Neighbors:    []NeighborConfig{{RemoteASN: 65001}},
+		},
+		tableA, tableB,
+	)
+
+	waitFor(t, "routes from b", func() bool {
+		_, ok := tableA.Lookup(net.ParseIP("10.20.1.1"))
+		_, ok6 := tableA.Lookup(net.ParseIP("2001:db8:100::1"))
+		return ok && ok6
+	})
+	route, _ := tableA.Lookup(net.ParseIP("10.20.1.1"))
+	if route.Distance != EBGPDistance || !route.Gateway.Equal(net.ParseIP("127.0.0.1")) {
+		t.Fatalf("unexpected bgp route: %+v", route)
+	}
+	if route, _ := tableA.Lookup(net.ParseIP("203.0.113.5")); route.Interface != "wan0" || route.Distance != 0 {
+		t.Fatalf("static route must win over ebgp, got %+v", route)
+	}
+	if _, ok := tableA.Lookup(net.ParseIP("198.51.100.1")); ok {
+		t.Fatalf("import policy must reject 198.51.100.0/25")
+	}
+	for _, addr := range []string{"10.30.1.1", "10.40.1.1"} {
+		if route, ok := tableA.Lookup(net.ParseIP(addr)); ok {
+			t.Fatalf("only configured routes may be exported, got %+v", route)
+		}
+	}
+	waitFor(t, "routes from a", func() bool {
+		route, ok := tableB.Lookup(net.ParseIP("192.0.2.10"))
+		return ok && route.Distance == EBGPDistance
+	})
+
+	status := speakerA.Neighbors()[0]
+	if status.State != StateEstablished || status.RemoteID != "192.0.2.2" || status.Received != 4 || status.Accepted != 3 {
+		t.Fatalf("unexpected neighbor status: %+v", status)
+	}
+	rib := speakerA.RIB()
+	if len(rib) != 3 || len(rib[0].ASPath) != 1 || rib[0].ASPath[0] != 4200000002 || !rib[0].Best {
+		t.Fatalf("unexpected rib: %+v", rib)
+	}
+	for _, entry := range speakerB.RIB() {
+		if entry.Prefix.String() == "10.20.0.0/16" {
+			t.Fatalf("b must not learn its own static route back: %+v", entry)
+		}
+	}
+}
+
+func TestSpeakerWithdrawsOnSessionLossAndPrefixLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctxB, cancelB := context.WithCancel(ctx)
+	tableA := routing.NewTable(nil)
+	speakerA, _ := startPair(t, ctxB,
+		Config{ASN: 65001, RouterID: net.ParseIP("192.0.2.1"), Neighbors: []NeighborConfig{{RemoteASN: 65001}}},
+		Config{ASN: 65001, RouterID: net.ParseIP("192.0.2.2"), Networks: []net.IPNet{mustPrefix(t, "10.1.0.0/16")}, Neighbors: []NeighborConfig{{RemoteASN: 65001}}},
+		tableA, routing.NewTable(nil),
+	)
+	waitFor(t, "ibgp route", func() bool {
+		route, ok := tableA.Lookup(net.ParseIP("10.1.2.3"))
+		return ok && route.Distance == IBGPDistance
+	})
+	cancelB()
+	waitFor(t, "withdrawal after session loss", func() bool {
+		_, ok := tableA.Lookup(net.ParseIP("10.1.2.3"))
+		return !ok && speakerA.Neighbors()[0].State != StateEstablished
+	})
+
+	tableC := routing.NewTable(nil)
+	speakerC, _ := startPair(t, ctx,
+		Config{ASN: 65001, RouterID: net.ParseIP("192.0.2.3"), Neighbors: []NeighborConfig{{RemoteASN: 65002, MaxPrefixes: 1}}},
+		Config{ASN: 65002, RouterID: net.ParseIP("192.0.2.4"), Networks: []net.IPNet{mustPrefix(t, "10.1.0.0/16"), mustPrefix(t, "10.2.0.0/16")}, Neighbors: []NeighborConfig{{RemoteASN: 65001}}},
+		tableC, routing.NewTable(nil),
+	)
+	waitFor(t, "prefix limit teardown", func() bool {
+		return speakerC.Neighbors()[0].Flaps > 0
+	})
+	if len(tableC.Routes()) != 0 {
+		t.Fatalf("routes must be withdrawn after prefix limit, got %v", tableC.Routes())
+	}
+	if status := speakerC.Neighbors()[0]; status.LastError == "" {
+		t.Fatalf("expected last error after prefix limit, got %+v", status)
+	}
+}
+
+func TestSpeakerHoldsSessionDownWhileBFDIsDown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tableA := routing.NewTable(nil)
+	speakerA, speakerB := startPair(t, ctx,
+		Config{ASN: 65001, RouterID: net.ParseIP("192.0.2.1"), Neighbors: []NeighborConfig{{RemoteASN: 65002}}},
+		Config{ASN: 65002, RouterID: net.ParseIP("192.0.2.2"), Networks: []net.IPNet{mustPrefix(t, "10.1.0.0/16")}, Neighbors: []NeighborConfig{{RemoteASN: 65001}}},
+		tableA, routing.NewTable(nil),
+	)
+	waitFor(t, "ebgp route", func() bool {
+		_, ok := tableA.Lookup(net.ParseIP("10.1.2.3"))
+		return ok
+	})
+
+	if !speakerA.SetBFDState(net.ParseIP("127.0.0.1"), false, "control_detection_time_expired") {
+		t.Fatalf("expected neighbor to be found")
+	}
+	if _, ok := tableA.Lookup(net.ParseIP("10.1.2.3")); ok {
+		t.Fatalf("routes must be withdrawn as soon as bfd goes down")
+	}
+	status := speakerA.Neighbors()[0]
+	if status.State == StateEstablished || status.LastError != "bfd down: control_detection_time_expired" {
+		t.Fatalf("unexpected neighbor status: %+v", status)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if speakerA.Neighbors()[0].State == StateEstablished {
+		t.Fatalf("session must stay down while bfd is down")
+	}
+	waitFor(t, "peer notices cease", func() bool {
+		return speakerB.Neighbors()[0].State != StateEstablished || speakerB.Neighbors()[0].Flaps > 0
+	})
+
+	speakerA.SetBFDState(net.ParseIP("127.0.0.1"), true, "")
+	waitFor(t, "session restored", func() bool {
+		_, ok := tableA.Lookup(net.ParseIP("10.1.2.3"))
+		return ok && speakerA.Neighbors()[0].State == StateEstablished
+	})
+	if speakerA.SetBFDState(net.ParseIP("192.0.2.99"), false, "") {
+		t.Fatalf("unknown neighbor must not be reported as found")
+	}
+}
@@ -0,0 +1,678 @@
+package conntrack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+const (
+	TCPSynSent     = "SYN_SENT"
+	TCPSynRecv     = "SYN_RECV"
+	TCPEstablished = "ESTABLISHED"
+	TCPFinWait     = "FIN_WAIT"
+	TCPCloseWait   = "CLOSE_WAIT"
+	TCPLastAck     = "LAST_ACK"
+	TCPTimeWait    = "TIME_WAIT"
+	TCPClose       = "CLOSE"
+
+	DefaultMaxEntries = 65536
+
+	gcInterval = 10 * time.Second
+)
+
+// Timeouts controls how long an idle entry is kept. TCPTransitory applies to
+// the handshake and teardown states.
+type Timeouts struct {
+	TCPEstablished time.Duration
+	TCPTransitory  time.Duration
+	TCPClose       time.Duration
+	UDP            time.Duration
+	UDPStream      time.Duration
+	ICMP           time.Duration
+	Generic        time.Duration
+}
+
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		TCPEstablished: 5 * 24 * time.Hour,
+		TCPTransitory:  2 * time.Minute,
+		TCPClose:       10 * time.Second,
+		UDP:            30 * time.Second,
+		UDPStream:      3 * time.Minute,
+		ICMP:           30 * time.Second,
+		Generic:        10 * time.Minute,
+	}
+}
+
+// Entry is a snapshot of one tracked connection. Src/Dst describe the
+// original direction, Reply* the tuple expected on replies (after NAT).
+type Entry struct {
+	Zone         string
+	Protocol     string
+	Src          net.IP
+	Dst          net.IP
+	SrcPort      int
+	DstPort      int
+	ReplySrc     net.IP
+	ReplyDst     net.IP
+	ReplySrcPort int
+	ReplyDstPort int
+	State        string
+	SeenReply    bool
+	OrigPackets  uint64
+	OrigBytes    uint64
+	ReplyPackets uint64
+	ReplyBytes   uint64
+	Created      time.Time
+	LastSeen     time.Time
+	Expires      time.Time
+}
+
+type Stats struct {
+	Entries  int
+	Max      int
+	Inserted uint64
+	Expired  uint64
+	Invalid  uint64
+	Full     uint64
+}
+
+type key struct {
+	zone    string
+	proto   uint8
+	src     [16]byte
+	dst     [16]byte
+	srcPort uint16
+	dstPort uint16
+}
+
+type conn struct {
+	Entry
+	orig    key
+	reply   key
+	finOrig bool
+	finRepl bool
+}
+
+// sourceKey counts connections per original source address within a zone.
+type sourceKey struct {
+	zone string
+	src  [16]byte
+}
+
+// prefixKey counts connections per masked source network within a zone;
+// bits is the prefix length in the 16-byte address form.
+type prefixKey struct {
+	zone string
+	bits int
+	src  [16]byte
+}
+
+type prefixLen struct {
+	bits int
+	mask net.IPMask
+}
+
+type Table struct {
+	mu       sync.Mutex
+	conns    map[key]*conn
+	sources  map[sourceKey]int
+	prefixes map[prefixKey]int
+	lengths  []prefixLen
+	max      int
+	timeouts Timeouts
+	stats    Stats
+	now      func() time.Time
+}
+
+func NewTable(maxEntries int, timeouts Timeouts) *Table {
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+	defaults := DefaultTimeouts()
+	for _, pair := range []struct{ dst, def *time.Duration }{
+		{&timeouts.TCPEstablished, &defaults.TCPEstablished},
+		{&timeouts.TCPTransitory, &defaults.TCPTransitory},
+		{&timeouts.TCPClose, &defaults.TCPClose},
+		{&timeouts.UDP, &defaults.UDP},
+		{&timeouts.UDPStream, &defaults.UDPStream},
+		{&timeouts.ICMP, &defaults.ICMP},
+		{&timeouts.Generic, &defaults.Generic},
+	} {
+		if *pair.dst <= 0 {
+			*pair.dst = *pair.def
+		}
+	}
+	return &Table{
+		conns:    map[key]*conn{},
+		sources:  map[sourceKey]int{},
+		prefixes: map[prefixKey]int{},
+		max:      maxEntries,
+		timeouts: timeouts,
+		now:      time.Now,
+	}
+}
+
+// Track classifies a packet before NAT and filtering and updates the state
+// of a known connection. New connections are only recorded by Confirm, so a
+// packet dropped by the firewall never creates an entry.
+func (t *Table) Track(zone string, pkt network.Packet) network.CTState {
+	meta := pkt.Metadata
+	proto := protoNum(meta)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	if isICMPError(proto, meta.ICMPType) {
+		inner, ok := embeddedMetadata(pkt.Data)
+		if ok {
+			k := makeKey(zone, inner)
+			if c := t.lookup(k, now); c != nil {
+				return network.CTRelated
+			}
+			if c := t.lookup(reverseKey(k), now); c != nil {
+				return network.CTRelated
+			}
+		}
+		t.stats.Invalid++
+		return network.CTInvalid
+	}
+
+	k := makeKey(zone, meta)
+	c := t.lookup(k, now)
+	if c == nil {
+		if !startsConnection(proto, meta) {
+			t.stats.Invalid++
+			return network.CTInvalid
+		}
+		return network.CTNew
+	}
+
+	reply := k == c.reply && k != c.orig
+	if proto == 6 && !c.updateTCP(reply, meta.TCPFlags) {
+		t.stats.Invalid++
+		return network.CTInvalid
+	}
+	size := uint64(packetLength(pkt))
+	if reply {
+		c.ReplyPackets++
+		c.ReplyBytes += size
+		c.SeenReply = true
+	} else {
+		c.OrigPackets++
+		c.OrigBytes += size
+	}
+	c.LastSeen = now
+	c.Expires = now.Add(t.timeoutFor(c))
+	if reply || c.SeenReply {
+		return network.CTEstablished
+	}
+	return network.CTNew
+}
+
+// Confirm records a new connection once its first packet was accepted.
+// translated is the same packet after NAT and defines the reply tuple.
+func (t *Table) Confirm(zone string, orig network.Packet, translated network.Packet) {
+	if orig.CTState != network.CTNew {
+		return
+	}
+	meta := orig.Metadata
+	proto := protoNum(meta)
+	if !startsConnection(proto, meta) || (isICMP(proto) && !isEchoRequest(proto, meta.ICMPType)) {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	k := makeKey(zone, meta)
+	if t.lookup(k, now) != nil {
+		return
+	}
+	if len(t.conns) >= 2*t.max {
+		t.expireLocked(now)
+		if len(t.conns) >= 2*t.max {
+			t.stats.Full++
+			return
+		}
+	}
+	replyMeta := translated.Metadata
+	if replyMeta.SrcIP == nil {
+		replyMeta = meta
+	}
+	c := &conn{orig: k, reply: reverseKey(makeKey(zone, replyMeta))}
+	c.Entry = Entry{
+		Zone:         zone,
+		Protocol:     protoName(proto, meta.Protocol),
+		Src:          meta.SrcIP,
+		Dst:          meta.DstIP,
+		SrcPort:      meta.SrcPort,
+		DstPort:      meta.DstPort,
+		ReplySrc:     replyMeta.DstIP,
+		ReplyDst:     replyMeta.SrcIP,
+		ReplySrcPort: replyMeta.DstPort,
+		ReplyDstPort: replyMeta.SrcPort,
+		OrigPackets:  1,
+		OrigBytes:    uint64(packetLength(orig)),
+		Created:      now,
+		LastSeen:     now,
+	}
+	if proto == 6 {
+		c.State = TCPSynSent
+	}
+	c.Expires = now.Add(t.timeoutFor(c))
+	t.conns[c.orig] = c
+	t.conns[c.reply] = c
+	t.sources[sourceKey{zone: zone, src: k.src}]++
+	for _, l := range t.lengths {
+		t.prefixes[prefixKey{zone: zone, bits: l.bits, src: maskKey(k.src, l.mask)}]++
+	}
+	t.stats.Inserted++
+}
+
+func (t *Table) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	out := make([]Entry, 0, len(t.conns)/2)
+	for k, c := range t.conns {
+		if k != c.orig || !now.Before(c.Expires) {
+			continue
+		}
+		out = append(out, c.Entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Zone != out[j].Zone {
+			return out[i].Zone < out[j].Zone
+		}
+		if cmp := bytes.Compare(out[i].Src.To16(), out[j].Src.To16()); cmp != 0 {
+			return cmp < 0
+		}
+		if cmp := bytes.Compare(out[i].Dst.To16(), out[j].Dst.To16()); cmp != 0 {
+			return cmp < 0
+		}
+		if out[i].SrcPort != out[j].SrcPort {
+			return out[i].SrcPort < out[j].SrcPort
+		}
+		return out[i].DstPort < out[j].DstPort
+	})
+	return out
+}
+
+func (t *Table) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats
+	stats.Entries = len(t.conns) / 2
+	stats.Max = t.max
+	return stats
+}
+
+// CountFrom returns how many connections in the zone were opened from src,
+// or from any address in src's network when bits is shorter than the
+// address. Entries past their timeout count until the next expiry run.
+// The first query for a prefix length counts the table once; after that the
+// per-network counters are kept up to date as connections come and go.
+func (t *Table) CountFrom(zone string, src net.IP, bits int) int {
+	if src == nil {
+		return 0
+	}
+	full := 128
+	if src.To4() != nil {
+		full = 32
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if bits <= 0 || bits >= full {
+		return t.sources[sourceKey{zone: zone, src: ipKey(src)}]
+	}
+	l := t.prefixLength(bits + 128 - full)
+	return t.prefixes[prefixKey{zone: zone, bits: l.bits, src: maskKey(ipKey(src), l.mask)}]
+}
+
+// prefixLength returns the tracked prefix length, starting to count
+// connections for it from the current sources when it is new.
+func (t *Table) prefixLength(bits int) prefixLen {
+	for _, l := range t.lengths {
+		if l.bits == bits {
+			return l
+		}
+	}
+	l := prefixLen{bits: bits, mask: net.CIDRMask(bits, 128)}
+	t.lengths = append(t.lengths, l)
+	for k, n := range t.sources {
+		t.prefixes[prefixKey{zone: k.zone, bits: bits, src: maskKey(k.src, l.mask)}] += n
+	}
+	return l
+}
+
+// Expire drops idle connections and returns how many were removed.
+func (t *Table) Expire() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.expireLocked(t.now())
+}
+
+func (t *Table) Run(ctx context.Context) {
+	ticker := time.NewTicker(gcInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.Expire()
+		}
+	}
+}
+
+func (t *Table) expireLocked(now time.Time) int {
+	removed := 0
+	for k, c := range t.conns {
+		if k != c.orig || now.Before(c.Expires) {
+			continue
+		}
+		t.remove(c)
+		removed++
+	}
+	t.stats.Expired += uint64(removed)
+	return removed
+}
+
+func (t *Table) lookup(k key, now time.Time) *conn {
+	c, ok := t.conns[k]
+	if !ok {
+		return nil
+	}
+	if !now.Before(c.Expires) {
+		t.remove(c)
+		t.stats.Expired++
+		return nil
+	}
+	return c
+}
+
+func (t *Table) remove(c *conn) {
+	delete(t.conns, c.orig)
+	delete(t.conns, c.reply)
+	src := sourceKey{zone: c.orig.zone, src: c.orig.src}
+	if t.sources[src]--; t.sources[src] <= 0 {
+		delete(t.sources, src)
+	}
+	for _, l := range t.lengths {
+		prefix := prefixKey{zone: src.zone, bits: l.bits, src: maskKey(src.src, l.mask)}
+		if t.prefixes[prefix]--; t.prefixes[prefix] <= 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+}
+
+func (t *Table) timeoutFor(c *conn) time.Duration {
+	switch {
+	case c.State == TCPEstablished:
+		return t.timeouts.TCPEstablished
+	case c.State == TCPClose:
+		return t.timeouts.TCPClose
+	case c.State != "":
+		return t.timeouts.TCPTransitory
+	case c.orig.proto == 17 && c.SeenReply:
+		return t.timeouts.UDPStream
+	case c.orig.proto == 17:
+		return t.timeouts.UDP
+	case isICMP(c.orig.proto):
+		return t.timeouts.ICMP
+	default:
+		return t.timeouts.Generic
+	}
+}
+
+// updateTCP advances the TCP state machine and reports whether the segment
+// is acceptable for the connection.
+func (c *conn) updateTCP(reply bool, flags uint8) bool {
+	syn := flags&network.TCPFlagSYN != 0
+	ack := flags&network.TCPFlagACK != 0
+	fin := flags&network.TCPFlagFIN != 0
+	rst := flags&network.TCPFlagRST != 0
+	if flags == 0 || (syn && (fin || rst)) {
+		return false
+	}
+	switch {
+	case rst:
+		c.State = TCPClose
+	case syn && !ack:
+		if reply {
+			return false
+		}
+		switch c.State {
+		case TCPSynSent:
+		case TCPTimeWait, TCPClose:
+			c.State = TCPSynSent
+			c.SeenReply = false
+			c.finOrig, c.finRepl = false, false
+		default:
+			return false
+		}
+	case syn:
+		if !reply {
+			return false
+		}
+		switch c.State {
+		case TCPSynSent, TCPSynRecv:
+			c.State = TCPSynRecv
+		case TCPEstablished:
+		default:
+			return false
+		}
+	case fin:
+		if c.State == TCPSynSent {
+			return false
+		}
+		if reply {
+			c.finRepl = true
+		} else {
+			c.finOrig = true
+		}
+		switch {
+		case c.finOrig && c.finRepl:
+			if c.State != TCPTimeWait {
+				c.State = TCPLastAck
+			}
+		case c.finOrig:
+			c.State = TCPFinWait
+		default:
+			c.State = TCPCloseWait
+		}
+	case ack:
+		switch c.State {
+		case TCPSynSent:
+			return false
+		case TCPSynRecv:
+			if !reply {
+				c.State = TCPEstablished
+			}
+		case TCPLastAck:
+			c.State = TCPTimeWait
+		}
+	}
+	return true
+}
+
+func startsConnection(proto uint8, meta network.PacketMetadata) bool {
+	switch {
+	case proto == 6:
+		return meta.TCPFlags&(network.TCPFlagSYN|network.TCPFlagACK|network.TCPFlagFIN|network.TCPFlagRST) == network.TCPFlagSYN
+	case proto == 1:
+		return meta.ICMPType != 0
+	case proto == 58:
+		return meta.ICMPType != 129
+	default:
+		return true
+	}
+}
+
+func makeKey(zone string, meta network.PacketMetadata) key {
+	proto := protoNum(meta)
+	k := key{zone: zone, proto: proto, src: ipKey(meta.SrcIP), dst: ipKey(meta.DstIP)}
+	switch {
+	case proto == 6 || proto == 17:
+		k.srcPort, k.dstPort = uint16(meta.SrcPort), uint16(meta.DstPort)
+	case isEchoRequest(proto, meta.ICMPType):
+		k.srcPort = uint16(meta.ICMPID)
+	case isEchoReply(proto, meta.ICMPType):
+		k.dstPort = uint16(meta.ICMPID)
+	}
+	return k
+}
+
+func reverseKey(k key) key {
+	k.src, k.dst = k.dst, k.src
+	k.srcPort, k.dstPort = k.dstPort, k.srcPort
+	return k
+}
+
+// embeddedMetadata parses the offending packet quoted in an ICMP error.
+func embeddedMetadata(data []byte) (network.PacketMetadata, bool) {
+	if len(data) == 0 {
+		return network.PacketMetadata{}, false
+	}
+	offset := 40
+	if data[0]>>4 == 4 {
+		offset = int(data[0]&0x0F) * 4
+	}
+	offset += 8
+	if len(data) <= offset {
+		return network.PacketMetadata{}, false
+	}
+	meta, err := network.ParseIPMetadata(data[offset:])
+	if err != nil {
+		return network.PacketMetadata{}, false
+	}
+	return meta, true
+}
+
+func isICMP(proto uint8) bool {
+	return proto == 1 || proto == 58
+}
+
+func isICMPError(proto uint8, typ int) bool {
+	switch proto {
+	case 1:
+		return typ == 3 || typ == 4 || typ == 5 || typ == 11 || typ == 12
+	case 58:
+		return typ >= 1 && typ <= 4
+	}
+	return false
+}
+
+func isEchoRequest(proto uint8, typ int) bool {
+	return (proto == 1 && typ == 8) || (proto == 58 && typ == 128)
+}
+
+func isEchoReply(proto uint8, typ int) bool {
+	return (proto == 1 && typ == 0) || (proto == 58 && typ == 129)
+}
+
+func protoNum(meta network.PacketMetadata) uint8 {
+	if meta.ProtocolNum != 0 {
+		return meta.ProtocolNum
+	}
+	switch strings.ToUpper(meta.Protocol) {
+	case "TCP":
+		return 6
+	case "UDP":
+		return 17
+	case "ICMP":
+		return 1
+	case "ICMPV6":
+		return 58
+	}
+	return 0
+}
+
+func protoName(proto uint8, name string) string {
+	switch proto {
+	case 6:
+		return "TCP"
+	case 17:
+		return "UDP"
+	case 1:
+		return "ICMP"
+	case 58:
+		return "ICMPv6"
+	}
+	if name != "" && name != "OTHER" {
+		return name
+	}
+	return fmt.Sprintf("%d", proto)
+}
+
+func packetLength(pkt network.Packet) int {
+	if pkt.Metadata.Length > 0 {
+		return pkt.Metadata.Length
+	}
+	return len(pkt.Data)
+}
+
+func ipKey(ip net.IP) [16]byte {
+	var out [16]byte
+	if ip == nil {
+		return out
+	}
+	copy(out[:], ip.To16())
+	return out
+}
+
+func maskKey(k [16]byte, mask net.IPMask) [16]byte {
+	for i := range k {
+		k[i] &= mask[i]
+	}
+	return k
+}
+
+// ParseStates parses a comma separated list such as "established,related".
+func ParseStates(value string) (network.CTState, error) {
+	var out network.CTState
+	for _, part := range strings.Split(value, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		switch part {
+		case "":
+		case "new":
+			out |= network.CTNew
+		case "established":
+			out |= network.CTEstablished
+		case "related":
+			out |= network.CTRelated
+		case "invalid":
+			out |= network.CTInvalid
+		default:
+			return 0, fmt.Errorf("unknown ct_state %q", part)
+		}
+	}
+	return out, nil
+}
+
+// FormatStates is the inverse of ParseStates.
+func FormatStates(state network.CTState) string {
+	parts := make([]string, 0, 4)
+	for _, s := range []struct {
+		bit  network.CTState
+		name string
+	}{
+		{network.CTNew, "new"},
+		{network.CTEstablished, "established"},
+		{network.CTRelated, "related"},
+		{network.CTInvalid, "invalid"},
+	} {
+		if state&s.bit != 0 {
+			parts = append(parts, s.name)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,252 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+func tcpPacket(src, dst string, sport, dport int, flags uint8) network.Packet {
+	return network.Packet{Metadata: network.PacketMetadata{
+		SrcIP:    net.ParseIP(src),
+		DstIP:    net.ParseIP(dst),
+		Protocol: "TCP",
+		SrcPort:  sport,
+		DstPort:  dport,
+		TCPFlags: flags,
+		Length:   60,
+	}}
+}
+
+// track runs a packet through the same sequence as the packet loop: Track,
+// an accepting firewall, then Confirm.
+func track(table *Table, pkt network.Packet) network.CTState {
+	pkt.CTState = table.Track("", pkt)
+	table.Confirm("", pkt, pkt)
+	return pkt.CTState
+}
+
+func TestTCPLifecycle(t *testing.T) {
+	table := NewTable(0, Timeouts{})
+	const (
+		syn = network.TCPFlagSYN
+		ack = network.TCPFlagACK
+		fin = network.TCPFlagFIN
+	)
+	steps := []struct {
+		pkt   network.Packet
+		want  network.CTState
+		state string
+	}{
+		{tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, syn), network.CTNew, TCPSynSent},
+		{tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, syn), network.CTNew, TCPSynSent},
+		{tcpPacket("1.1.1.1", "10.0.0.2", 443, 40000, syn|ack), network.CTEstablished, TCPSynRecv},
+		{tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, ack), network.CTEstablished, TCPEstablished},
+		{tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, syn|ack), network.CTInvalid, TCPEstablished},
+		{tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, fin|ack), network.CTEstablished, TCPFinWait},
+		{tcpPacket("1.1.1.1", "10.0.0.2", 443, 40000, fin|ack), network.CTEstablished, TCPLastAck},
+		{tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, ack), network.CTEstablished, TCPTimeWait},
+	}
+	for i, step := range steps {
+		if got := track(table, step.pkt); got != step.want {
+			t.Fatalf("step %d: expected ct state %d, got %d", i, step.want, got)
+		}
+		entries := table.Entries()
+		if len(entries) != 1 || entries[0].State != step.state {
+			t.Fatalf("step %d: expected tcp state %s, got %+v", i, step.state, entries)
+		}
+	}
+	e := table.Entries()[0]
+	if e.OrigPackets != 5 || e.ReplyPackets != 2 || e.OrigBytes != 300 || !e.SeenReply {
+		t.Fatalf("unexpected counters: %+v", e)
+	}
+
+	if got := track(table, tcpPacket("10.0.0.3", "1.1.1.1", 40001, 443, ack)); got != network.CTInvalid {
+		t.Fatalf("expected mid-stream ack without entry to be invalid, got %d", got)
+	}
+	if stats := table.Stats(); stats.Entries != 1 || stats.Inserted != 1 || stats.Invalid != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestUnconfirmedConnectionIsNotTracked(t *testing.T) {
+	table := NewTable(0, Timeouts{})
+	out := tcpPacket("10.0.0.2", "1.1.1.1", 40000, 443, network.TCPFlagSYN)
+	if state := table.Track("", out); state != network.CTNew {
+		t.Fatalf("expected new, got %d", state)
+	}
+	reply := tcpPacket("1.1.1.1", "10.0.0.2", 443, 40000, network.TCPFlagSYN|network.TCPFlagACK)
+	if state := table.Track("", reply); state != network.CTInvalid {
+		t.Fatalf("expected reply to a dropped syn to be invalid, got %d", state)
+	}
+}
+
+func TestUDPAndICMPWithNAT(t *testing.T) {
+	table := NewTable(0, Timeouts{UDP: time.Second})
+	now := time.Unix(1000, 0)
+	table.now = func() time.Time { return now }
+
+	out := network.Packet{Metadata: network.PacketMetadata{
+		SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8"),
+		Protocol: "UDP", SrcPort: 5353, DstPort: 53,
+	}}
+	out.CTState = table.Track("", out)
+	translated := out
+	translated.Metadata.SrcIP = net.ParseIP("203.0.113.1")
+	translated.Metadata.SrcPort = 40000
+	table.Confirm("", out, translated)
+
+	reply := network.Packet{Metadata: network.PacketMetadata{
+		SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("203.0.113.1"),
+		Protocol: "UDP", SrcPort: 53, DstPort: 40000,
+	}}
+	if state := table.Track("", reply); state != network.CTEstablished {
+		t.Fatalf("expected nat reply to be established, got %d", state)
+	}
+	if state := table.Track("other", reply); state != network.CTNew {
+		t.Fatalf("expected other zone not to share entries, got %d", state)
+	}
+
+	echo := network.Packet{Metadata: network.PacketMetadata{
+		SrcIP: net.ParseIP("192.168.1.10"), DstIP: net.ParseIP("8.8.8.8"),
+		Protocol: "ICMP", ICMPType: 8, ICMPID: 7,
+	}}
+	if state := track(table, echo); state != network.CTNew {
+		t.Fatalf("expected echo request to be new, got %d", state)
+	}
+	echoReply := network.Packet{Metadata: network.PacketMetadata{
+		SrcIP: net.ParseIP("8.8.8.8"), DstIP: net.ParseIP("192.168.1.10"),
+		Protocol: "ICMP", ICMPType: 0, ICMPID: 7,
+	}}
+	if state := table.Track("", echoReply); state != network.CTEstablished {
+		t.Fatalf("expected echo reply to be established, got %d", state)
+	}
+	echoReply.Metadata.ICMPID = 8
+	if state := table.Track("", echoReply); state != network.CTInvalid {
+		t.Fatalf("expected unsolicited echo reply to be invalid, got %d", state)
+	}
+
+	now = now.Add(2 * time.Second)
+	if removed := table.Expire(); removed != 0 {
+		t.Fatalf("expected replied udp flow to use the stream timeout, removed %d", removed)
+	}
+	now = now.Add(time.Hour)
+	if removed := table.Expire(); removed != 2 || len(table.Entries()) != 0 {
+		t.Fatalf("expected both entries to expire, removed %d", removed)
+	}
+}
+
+func TestICMPErrorIsRelated(t *testing.T) {
+	table := NewTable(0, Timeouts{})
+	track(table, network.Packet{Metadata: network.PacketMetadata{
+		SrcIP: net.ParseIP("10.0.0.2"), DstIP: net.ParseIP("1.1.1.1"),
+		Protocol: "UDP", SrcPort: 33434, DstPort: 33435,
+	}})
+
+	inner := ipv4UDP("10.0.0.2", "1.1.1.1", 33434, 33435)
+	data := make([]byte, 20+8+len(inner))
+	data[0] = 0x45
+	data[9] = 1
+	copy(data[12:16], net.ParseIP("192.0.2.1").To4())
+	copy(data[16:20], net.ParseIP("10.0.0.2").To4())
+	data[20] = 11
+	copy(data[28:], inner)
+	meta, err := network.ParseIPv4Metadata(data)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	pkt := network.Packet{Data: data, Metadata: meta}
+	if state := table.Track("", pkt); state != network.CTRelated {
+		t.Fatalf("expected time exceeded for a known flow to be related, got %d", state)
+	}
+
+	binary.BigEndian.PutUint16(data[28+20+2:], 9999)
+	if state := table.Track("", pkt); state != network.CTInvalid {
+		t.Fatalf("expected icmp error for an unknown flow to be invalid, got %d", state)
+	}
+}
+
+func TestParseStates(t *testing.T) {
+	state, err := ParseStates("Established, related")
+	if err != nil || state != network.CTEstablished|network.CTRelated {
+		t.Fatalf("unexpected parse result %d %v", state, err)
+	}
+	if FormatStates(state) != "established,related" {
+		t.Fatalf("unexpected format %q", FormatStates(state))
+	}
+	if _, err := ParseStates("untracked"); err == nil {
+		t.Fatalf("expected unknown state to fail")
+	}
+}
+
+func ipv4UDP(src, dst string, sport, dport int) []byte {
+	b := make([]byte, 28)
+	b[0] = 0x45
+	binary.BigEndian.PutUint16(b[2:], 28)
+	b[9] = 17
+	copy(b[12:16], net.ParseIP(src).To4())
+	copy(b[16:20], net.ParseIP(dst).To4())
+	binary.BigEndian.PutUint16(b[20:], uint16(sport))
+	binary.BigEndian.PutUint16(b[22:], uint16(dport))
+	return b
+}
+
+func TestCountFrom(t *testing.T) {
+	table := NewTable(0, Timeouts{TCPTransitory: time.Second})
+	now := time.Unix(1000, 0)
+	table.now = func() time.Time { return now }
+	track(table, tcpPacket("10.0.0.2", "1.1.1.1", 40000, 22, network.TCPFlagSYN))
+	track(table, tcpPacket("10.0.0.2", "1.1.1.1", 40001, 22, network.TCPFlagSYN))
+	track(table, tcpPacket("10.0.0.3", "1.1.1.1", 40000, 22, network.TCPFlagSYN))
+	track(table, tcpPacket("10.0.1.2", "1.1.1.1", 40000, 22, network.TCPFlagSYN))
+
+	if n := table.CountFrom("", net.ParseIP("10.0.0.2"), 0); n != 2 {
+		t.Fatalf("expected 2 connections from host, got %d", n)
+	}
+	if n := table.CountFrom("", net.ParseIP("10.0.0.9"), 24); n != 3 {
+		t.Fatalf("expected 3 connections from /24, got %d", n)
+	}
+	if n := table.CountFrom("other", net.ParseIP("10.0.0.2"), 0); n != 0 {
+		t.Fatalf("expected no connections in another zone, got %d", n)
+	}
+	now = now.Add(2 * time.Second)
+	table.Expire()
+	if n := table.CountFrom("", net.ParseIP("10.0.0.2"), 16); n != 0 {
+		t.Fatalf("expected expired connections to be released, got %d", n)
+	}
+}
+
+func TestCountFromKeepsPrefixCounters(t *testing.T) {
+	table := NewTable(0, Timeouts{TCPTransitory: time.Second})
+	now := time.Unix(1000, 0)
+	table.now = func() time.Time { return now }
+	track(table, tcpPacket("10.0.0.2", "1.1.1.1", 40000, 22, network.TCPFlagSYN))
+	if n := table.CountFrom("", net.ParseIP("10.0.0.9"), 24); n != 1 {
+		t.Fatalf("expected 1 connection from /24, got %d", n)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	track(table, tcpPacket("10.0.0.3", "1.1.1.1", 40000, 22, network.TCPFlagSYN))
+	track(table, tcpPacket("10.0.1.3", "1.1.1.1", 40000, 22, network.TCPFlagSYN))
+	if n := table.CountFrom("", net.ParseIP("10.0.0.9"), 24); n != 2 {
+		t.Fatalf("expected new connections to be counted, got %d", n)
+	}
+	if n := table.CountFrom("", net.ParseIP("10.0.9.9"), 16); n != 3 {
+		t.Fatalf("expected 3 connections from /16, got %d", n)
+	}
+
+	now = now.Add(700 * time.Millisecond)
+	table.Expire()
+	if n := table.CountFrom("", net.ParseIP("10.0.0.9"), 24); n != 1 {
+		t.Fatalf("expected expired connection to leave the /24, got %d", n)
+	}
+	if n := table.CountFrom("", net.ParseIP("10.0.9.9"), 16); n != 2 {
+		t.Fatalf("expected expired connection to leave the /16, got %d", n)
+	}
+	if len(table.prefixes) != 3 {
+		t.Fatalf("expected empty networks to be dropped, got %v", table.prefixes)
+	}
+}
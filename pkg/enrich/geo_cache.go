@@ -0,0 +1,172 @@
+package enrich
+
+import (
+	"container/list"
+	"hash/maphash"
+	"net"
+	"sync"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// DefaultGeoCacheSize bounds the per-address cache used for rule matching.
+const DefaultGeoCacheSize = 65536
+
+const (
+	geoCacheShards    = 64
+	geoCacheShardSize = 256
+)
+
+type geoEntry struct {
+	key     [16]byte
+	country string
+	asn     uint32
+}
+
+// geoShard is an LRU over the addresses hashed to it.
+type geoShard struct {
+	mu       sync.Mutex
+	entries  map[[16]byte]*list.Element
+	order    *list.List
+	capacity int
+}
+
+// GeoCache resolves addresses for firewall and IDS matching and keeps the most
+// recently used results in a bounded LRU, so the per-packet cost after the
+// first lookup is a map hit. The LRU is split into shards by address, each
+// with its own lock, so lookups from different packets rarely contend.
+type GeoCache struct {
+	lookup  func(ip net.IP) (string, uint32)
+	seed    maphash.Seed
+	shards  []geoShard
+	closers []func() error
+}
+
+func NewGeoCache(lookup func(ip net.IP) (string, uint32), capacity int) *GeoCache {
+	if capacity <= 0 {
+		capacity = DefaultGeoCacheSize
+	}
+	// Small caches keep fewer shards so the bound stays close to an exact
+	// LRU over all addresses.
+	shards := geoCacheShards
+	for shards > 1 && capacity/shards < geoCacheShardSize {
+		shards /= 2
+	}
+	c := &GeoCache{
+		lookup: lookup,
+		seed:   maphash.MakeSeed(),
+		shards: make([]geoShard, shards),
+	}
+	for i := range c.shards {
+		shardCap := capacity / shards
+		if i < capacity%shards {
+			shardCap++
+		}
+		c.shards[i] = geoShard{
+			entries:  map[[16]byte]*list.Element{},
+			order:    list.New(),
+			capacity: shardCap,
+		}
+	}
+	return c
+}
+
+// OpenGeoCache opens local MaxMind databases: countryPath may be a country or
+// city database and asnPath an ASN database. Either path may be empty.
+func OpenGeoCache(countryPath, asnPath string, capacity int) (*GeoCache, error) {
+	var countryDB, asnDB *geoip2.Reader
+	var err error
+	if countryPath != "" {
+		if countryDB, err = geoip2.Open(countryPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if asnDB, err = geoip2.Open(asnPath); err != nil {
+			if countryDB != nil {
+				countryDB.Close()
+			}
+			return nil, err
+		}
+	}
+	cache := NewGeoCache(func(ip net.IP) (string, uint32) {
+		var country string
+		var asn uint32
+		if countryDB != nil {
+			if record, err := countryDB.Country(ip); err == nil {
+				country = record.Country.IsoCode
+			}
+		}
+		if asnDB != nil {
+			if record, err := asnDB.ASN(ip); err == nil {
+				asn = uint32(record.AutonomousSystemNumber)
+			}
+		}
+		return country, asn
+	}, capacity)
+	for _, db := range []*geoip2.Reader{countryDB, asnDB} {
+		if db != nil {
+			cache.closers = append(cache.closers, db.Close)
+		}
+	}
+	return cache, nil
+}
+
+func (c *GeoCache) Geo(ip net.IP) (string, uint32) {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return "", 0
+	}
+	var key [16]byte
+	copy(key[:], ip16)
+	shard := &c.shards[0]
+	if len(c.shards) > 1 {
+		shard = &c.shards[maphash.Bytes(c.seed, key[:])%uint64(len(c.shards))]
+	}
+
+	shard.mu.Lock()
+	if elem, ok := shard.entries[key]; ok {
+		shard.order.MoveToFront(elem)
+		entry := elem.Value.(*geoEntry)
+		shard.mu.Unlock()
+		return entry.country, entry.asn
+	}
+	shard.mu.Unlock()
+
+	country, asn := c.lookup(ip)
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if elem, ok := shard.entries[key]; ok {
+		shard.order.MoveToFront(elem)
+		return country, asn
+	}
+	shard.entries[key] = shard.order.PushFront(&geoEntry{key: key, country: country, asn: asn})
+	for shard.order.Len() > shard.capacity {
+		oldest := shard.order.Back()
+		shard.order.Remove(oldest)
+		delete(shard.entries, oldest.Value.(*geoEntry).key)
+	}
+	return country, asn
+}
+
+func (c *GeoCache) Len() int {
+	n := 0
+	for i := range c.shards {
+		shard := &c.shards[i]
+		shard.mu.Lock()
+		n += shard.order.Len()
+		shard.mu.Unlock()
+	}
+	return n
+}
+
+func (c *GeoCache) Close() error {
+	var first error
+	for _, closeDB := range c.closers {
+		if err := closeDB(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
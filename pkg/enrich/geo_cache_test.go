@@ -0,0 +1,56 @@
+package enrich
+
+import (
+	"net"
+	"testing"
+)
+
+func TestGeoCacheBounded(t *testing.T) {
+	lookups := 0
+	cache := NewGeoCache(func(ip net.IP) (string, uint32) {
+		lookups++
+		if ip.To4() != nil && ip.To4()[0] == 1 {
+			return "AU", 13335
+		}
+		return "", 0
+	}, 2)
+
+	if country, asn := cache.Geo(net.ParseIP("1.1.1.1")); country != "AU" || asn != 13335 {
+		t.Fatalf("unexpected result %q %d", country, asn)
+	}
+	cache.Geo(net.ParseIP("1.1.1.1"))
+	if lookups != 1 {
+		t.Fatalf("expected cached result, got %d lookups", lookups)
+	}
+
+	cache.Geo(net.ParseIP("10.0.0.1"))
+	cache.Geo(net.ParseIP("1.1.1.1"))
+	cache.Geo(net.ParseIP("10.0.0.2"))
+	if cache.Len() != 2 {
+		t.Fatalf("expected cache to stay at 2 entries, got %d", cache.Len())
+	}
+	cache.Geo(net.ParseIP("1.1.1.1"))
+	if lookups != 3 {
+		t.Fatalf("expected most recently used entry to survive, got %d lookups", lookups)
+	}
+	cache.Geo(net.ParseIP("10.0.0.1"))
+	if lookups != 4 {
+		t.Fatalf("expected least recently used entry to be evicted, got %d lookups", lookups)
+	}
+}
+
+func TestGeoCacheSharded(t *testing.T) {
+	cache := NewGeoCache(func(ip net.IP) (string, uint32) { return "NL", 64500 }, DefaultGeoCacheSize)
+	if len(cache.shards) != geoCacheShards {
+		t.Fatalf("expected %d shards, got %d", geoCacheShards, len(cache.shards))
+	}
+	for i := 0; i < 2*DefaultGeoCacheSize; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		if country, asn := cache.Geo(ip); country != "NL" || asn != 64500 {
+			t.Fatalf("unexpected result %q %d", country, asn)
+		}
+	}
+	if n := cache.Len(); n > DefaultGeoCacheSize || n < DefaultGeoCacheSize*9/10 {
+		t.Fatalf("expected about %d cached entries, got %d", DefaultGeoCacheSize, n)
+	}
+}
@@ -0,0 +1,450 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+// FindingKind classifies a problem found by Analyze.
+type FindingKind string
+
+const (
+	// FindingShadowed is a rule an earlier rule with another action always
+	// matches first, so it never takes effect.
+	FindingShadowed FindingKind = "shadowed"
+	// FindingRedundant is a rule an earlier rule with the same action always
+	// matches first; removing it changes nothing.
+	FindingRedundant FindingKind = "redundant"
+	// FindingConflict is a rule that partly overlaps an earlier rule with
+	// the opposite verdict, so their order decides part of the traffic.
+	FindingConflict FindingKind = "conflict"
+	// FindingUnreachable is a rule no packet of its chain can match.
+	FindingUnreachable FindingKind = "unreachable"
+	// FindingUnused is a rule without hits in the analysed period.
+	FindingUnused FindingKind = "unused"
+)
+
+// Finding is one result of Analyze. Rule and Position identify the rule the
+// finding is about; Related is the earlier rule involved, if any.
+type Finding struct {
+	Kind     FindingKind
+	Chain    string
+	Rule     uint64
+	Position int
+	Related  uint64
+	Detail   string
+}
+
+func (f Finding) String() string {
+	chain := f.Chain
+	if chain == "" {
+		chain = "any chain"
+	}
+	return fmt.Sprintf("firewall rule %d (position %d, %s) is %s: %s", f.Rule, f.Position, chain, f.Kind, f.Detail)
+}
+
+// Analyze checks the rules for shadowed, redundant, conflicting and
+// unreachable rules. With a non-zero since, rules without a hit since then
+// are reported as unused as well.
+func (e *Engine) Analyze(since time.Time) []Finding {
+	e.mu.Lock()
+	rules := append([]Rule(nil), e.rules...)
+	zones := e.zones.zones
+	var lastHits []time.Time
+	if !since.IsZero() {
+		lastHits = make([]time.Time, len(e.counters))
+		for i, counter := range e.counters {
+			lastHits[i] = counter.lastHit()
+		}
+	}
+	e.mu.Unlock()
+
+	findings := AnalyzeRules(rules, zones)
+	for i, last := range lastHits {
+		if rules[i].Disabled || !last.Before(since) {
+			continue
+		}
+		detail := "no hits since " + since.Format(time.RFC3339)
+		if !last.IsZero() {
+			detail += ", last hit " + last.Format(time.RFC3339)
+		}
+		findings = append(findings, Finding{
+			Kind:     FindingUnused,
+			Chain:    rules[i].chainNorm,
+			Rule:     rules[i].ID,
+			Position: i + 1,
+			Detail:   detail,
+		})
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Position < findings[j].Position })
+	return findings
+}
+
+// AnalyzeRules is the static part of Analyze for a rule list and the zones
+// its rules may refer to. Rules are compared pairwise on their match fields;
+// ip set, schedule and limit matches are only known to overlap when equal.
+// A later rule that is more general than an earlier one with the opposite
+// action is the usual exception pattern and not a conflict. Disabled rules
+// are left out. A JUMP may return, so it never shadows later rules; GOTO and
+// RETURN do.
+func AnalyzeRules(rules []Rule, zones []Zone) []Finding {
+	rules = normalizeRules(rules)
+	zoneNames := make(map[string]bool, len(zones))
+	for _, zone := range zones {
+		zoneNames[strings.ToLower(zone.Name)] = true
+	}
+	evaluated := evaluatedChains(rules)
+	user := userChains(rules)
+	// inScope reports whether earlier applies in the chain of rule; rules
+	// without a chain do not apply in user chains.
+	inScope := func(earlier, rule *Rule) bool {
+		return !(earlier.chainNorm == "" && user[rule.chainNorm]) && !(rule.chainNorm == "" && user[earlier.chainNorm])
+	}
+	var findings []Finding
+	live := make([]bool, len(rules))
+	for j := range rules {
+		rule := &rules[j]
+		if rule.Disabled {
+			continue
+		}
+		finding := func(kind FindingKind, related *Rule, detail string) Finding {
+			f := Finding{Kind: kind, Chain: rule.chainNorm, Rule: rule.ID, Position: j + 1, Detail: detail}
+			if related != nil {
+				f.Related = related.ID
+				if f.Chain == "" {
+					f.Chain = related.chainNorm
+				}
+			}
+			return f
+		}
+		if reason := unreachable(rule, evaluated, zoneNames); reason != "" {
+			findings = append(findings, finding(FindingUnreachable, nil, reason))
+			continue
+		}
+		live[j] = true
+		covered := false
+		for i := 0; i < j && !covered; i++ {
+			earlier := &rules[i]
+			if !live[i] || earlier.Action == ActionLog || earlier.Action == ActionJump || !inScope(earlier, rule) || !earlier.covers(rule) {
+				continue
+			}
+			covered = true
+			if earlier.Action == rule.Action && earlier.targetNorm == rule.targetNorm {
+				findings = append(findings, finding(FindingRedundant, earlier, fmt.Sprintf("rule %d already matches all its packets with %s", earlier.ID, earlier.Action)))
+			} else {
+				findings = append(findings, finding(FindingShadowed, earlier, fmt.Sprintf("rule %d matches all its packets first with %s", earlier.ID, earlier.Action)))
+			}
+		}
+		if covered || !rule.terminal() {
+			continue
+		}
+		for i := 0; i < j; i++ {
+			earlier := &rules[i]
+			if !live[i] || !earlier.terminal() || earlier.permits() == rule.permits() || earlier.statefulAccept() || !inScope(earlier, rule) {
+				continue
+			}
+			if rule.covers(earlier) || !earlier.overlaps(rule) {
+				continue
+			}
+			findings = append(findings, finding(FindingConflict, earlier, fmt.Sprintf("overlaps rule %d, which %s part of the same packets first", earlier.ID, verb(earlier.Action))))
+			break
+		}
+	}
+	return findings
+}
+
+// evaluatedChains returns the built-in chains and the user chains enabled
+// JUMP and GOTO rules lead to from them.
+func evaluatedChains(rules []Rule) map[string]bool {
+	out := map[string]bool{}
+	for name := range builtinChains {
+		out[name] = true
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := range rules {
+			rule := &rules[i]
+			if rule.Disabled || !rule.Action.jumps() || out[rule.targetNorm] {
+				continue
+			}
+			if rule.chainNorm == "" || out[rule.chainNorm] {
+				out[rule.targetNorm] = true
+				changed = true
+			}
+		}
+	}
+	return out
+}
+
+func verb(action Action) string {
+	switch action {
+	case ActionAccept:
+		return "accepts"
+	case ActionReject:
+		return "rejects"
+	default:
+		return "drops"
+	}
+}
+
+// terminal reports whether the rule decides the verdict when it matches.
+func (rule *Rule) terminal() bool {
+	return rule.Action != ActionLog && rule.Action != ActionReturn && !rule.Action.jumps()
+}
+
+func (rule *Rule) permits() bool {
+	return rule.Action == ActionAccept
+}
+
+// statefulAccept reports the usual accept of established and related
+// traffic ahead of the policy rules; overlapping it is intended.
+func (rule *Rule) statefulAccept() bool {
+	return rule.permits() && rule.CTState != 0 && rule.CTState&^(network.CTEstablished|network.CTRelated) == 0
+}
+
+// unreachable explains why no packet of the rule's chain can match it, or
+// returns "" when some can.
+func unreachable(rule *Rule, evaluated map[string]bool, zoneNames map[string]bool) string {
+	if rule.chainNorm != "" && !evaluated[rule.chainNorm] {
+		return "chain " + rule.Chain + " is never evaluated"
+	}
+	for _, zone := range []string{rule.fromZoneNorm, rule.toZoneNorm} {
+		if zone != "" && !zoneNames[zone] {
+			return "zone " + zone + " is not defined"
+		}
+	}
+	if rule.protoKey == 1 || rule.protoKey == 58 {
+		if !rule.SrcPorts.Matches(0) || !rule.DstPorts.Matches(0) {
+			return "port match on " + rule.Protocol + ", which has no ports"
+		}
+	}
+	src4, src6 := addrFamilies(rule.SrcAddrs)
+	dst4, dst6 := addrFamilies(rule.DstAddrs)
+	v4, v6 := src4 && dst4, src6 && dst6
+	switch rule.protoKey {
+	case 1:
+		v6 = false
+	case 58:
+		v4 = false
+	}
+	if !v4 && !v6 {
+		return "address matches leave no address family"
+	}
+	return ""
+}
+
+// addrFamilies reports which families an address match can select.
+func addrFamilies(m network.AddrMatch) (bool, bool) {
+	if m.IsZero() || m.Negate {
+		return true, true
+	}
+	return m.Families()
+}
+
+// covers reports whether every packet b matches in its chain is matched by
+// rule as well.
+func (rule *Rule) covers(b *Rule) bool {
+	if rule.chainNorm != "" && rule.chainNorm != b.chainNorm {
+		return false
+	}
+	if rule.hasProto && (!b.hasProto || !strings.EqualFold(rule.Protocol, b.Protocol)) {
+		return false
+	}
+	if !addrCovers(rule.SrcAddrs, b.SrcAddrs) || !addrCovers(rule.DstAddrs, b.DstAddrs) {
+		return false
+	}
+	if !portsOf(rule.SrcPorts).covers(portsOf(b.SrcPorts)) || !portsOf(rule.DstPorts).covers(portsOf(b.DstPorts)) {
+		return false
+	}
+	if !fieldCovers(rule.SrcSet, b.SrcSet) || !fieldCovers(rule.DstSet, b.DstSet) {
+		return false
+	}
+	if !fieldCovers(rule.InInterface, b.InInterface) || !fieldCovers(rule.OutInterface, b.OutInterface) {
+		return false
+	}
+	if !fieldCovers(rule.fromZoneNorm, b.fromZoneNorm) || !fieldCovers(rule.toZoneNorm, b.toZoneNorm) {
+		return false
+	}
+	if rule.CTState != 0 && (b.CTState == 0 || b.CTState&^rule.CTState != 0) {
+		return false
+	}
+	if rule.geoMatched() && !rule.sameGeo(b) {
+		return false
+	}
+	return fieldCovers(rule.Schedule, b.Schedule) && !rule.limited()
+}
+
+// overlaps reports whether some packet is known to match both rules.
+func (rule *Rule) overlaps(b *Rule) bool {
+	if rule.chainNorm != "" && b.chainNorm != "" && rule.chainNorm != b.chainNorm {
+		return false
+	}
+	if rule.hasProto && b.hasProto && !strings.EqualFold(rule.Protocol, b.Protocol) {
+		return false
+	}
+	if !addrOverlaps(rule.SrcAddrs, b.SrcAddrs) || !addrOverlaps(rule.DstAddrs, b.DstAddrs) {
+		return false
+	}
+	if !portsOf(rule.SrcPorts).overlaps(portsOf(b.SrcPorts)) || !portsOf(rule.DstPorts).overlaps(portsOf(b.DstPorts)) {
+		return false
+	}
+	// Set contents, schedule windows and the geo database change at run
+	// time, so rules only overlap on them when they refer to the same ones.
+	if rule.SrcSet != b.SrcSet || rule.DstSet != b.DstSet || rule.Schedule != b.Schedule || !rule.sameGeo(b) {
+		return false
+	}
+	for _, pair := range [][2]string{
+		{rule.InInterface, b.InInterface}, {rule.OutInterface, b.OutInterface},
+		{rule.fromZoneNorm, b.fromZoneNorm}, {rule.toZoneNorm, b.toZoneNorm},
+	} {
+		if pair[0] != "" && pair[1] != "" && pair[0] != pair[1] {
+			return false
+		}
+	}
+	return rule.CTState == 0 || b.CTState == 0 || rule.CTState&b.CTState != 0
+}
+
+func (rule *Rule) sameGeo(b *Rule) bool {
+	return rule.SrcCountry.Equal(b.SrcCountry) && rule.DstCountry.Equal(b.DstCountry) && rule.SrcASN.Equal(b.SrcASN)
+}
+
+func fieldCovers(a, b string) bool {
+	return a == "" || a == b
+}
+
+func addrCovers(a, b network.AddrMatch) bool {
+	switch {
+	case a.IsZero():
+		return true
+	case b.IsZero():
+		return false
+	case !a.Negate && !b.Negate:
+		return allWithin(b.Nets, a.Nets)
+	case a.Negate && b.Negate:
+		return allWithin(a.Nets, b.Nets)
+	case a.Negate:
+		for _, x := range b.Nets {
+			for _, y := range a.Nets {
+				if prefixesOverlap(x, y) {
+					return false
+				}
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+func addrOverlaps(a, b network.AddrMatch) bool {
+	switch {
+	case a.IsZero() || b.IsZero() || a.Negate && b.Negate:
+		return true
+	case !a.Negate && !b.Negate:
+		for _, x := range a.Nets {
+			for _, y := range b.Nets {
+				if prefixesOverlap(x, y) {
+					return true
+				}
+			}
+		}
+		return false
+	case a.Negate:
+		return !allWithin(b.Nets, a.Nets)
+	default:
+		return !allWithin(a.Nets, b.Nets)
+	}
+}
+
+// allWithin reports whether each prefix in nets lies inside one of outer.
+func allWithin(nets, outer []*net.IPNet) bool {
+	for _, n := range nets {
+		within := false
+		for _, o := range outer {
+			if prefixWithin(n, o) {
+				within = true
+				break
+			}
+		}
+		if !within {
+			return false
+		}
+	}
+	return true
+}
+
+func prefixWithin(inner, outer *net.IPNet) bool {
+	innerOnes, innerBits := inner.Mask.Size()
+	outerOnes, outerBits := outer.Mask.Size()
+	return innerBits == outerBits && outerOnes <= innerOnes && outer.Contains(inner.IP)
+}
+
+func prefixesOverlap(a, b *net.IPNet) bool {
+	return prefixWithin(a, b) || prefixWithin(b, a)
+}
+
+// portSet is a port match as sorted, disjoint ranges over 0-65535.
+type portSet []network.PortRange
+
+func portsOf(m network.PortMatch) portSet {
+	if m.IsZero() {
+		return portSet{{From: 0, To: 0xffff}}
+	}
+	ranges := append([]network.PortRange(nil), m.Ranges...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].From < ranges[j].From })
+	var merged portSet
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.From <= merged[n-1].To+1 {
+			merged[n-1].To = max(merged[n-1].To, r.To)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	if !m.Negate {
+		return merged
+	}
+	var out portSet
+	next := 0
+	for _, r := range merged {
+		if r.From > next {
+			out = append(out, network.PortRange{From: next, To: r.From - 1})
+		}
+		next = r.To + 1
+	}
+	if next <= 0xffff {
+		out = append(out, network.PortRange{From: next, To: 0xffff})
+	}
+	return out
+}
+
+func (s portSet) covers(other portSet) bool {
+	for _, r := range other {
+		within := false
+		for _, o := range s {
+			if o.From <= r.From && r.To <= o.To {
+				within = true
+				break
+			}
+		}
+		if !within {
+			return false
+		}
+	}
+	return true
+}
+
+func (s portSet) overlaps(other portSet) bool {
+	for _, r := range other {
+		for _, o := range s {
+			if o.From <= r.To && r.From <= o.To {
+				return true
+			}
+		}
+	}
+	return false
+}
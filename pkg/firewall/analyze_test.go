@@ -0,0 +1,90 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+func findingKinds(findings []Finding) map[uint64]FindingKind {
+	out := map[uint64]FindingKind{}
+	for _, f := range findings {
+		out[f.Rule] = f.Kind
+	}
+	return out
+}
+
+func TestAnalyzeRules(t *testing.T) {
+	ports := func(value string) network.PortMatch {
+		m, err := network.ParsePortMatch(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	rules := []Rule{
+		{ID: 1, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", SrcAddrs: mustAddrs(t, "10.0.0.0/8"), DstPort: 22},
+		{ID: 2, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", SrcAddrs: mustAddrs(t, "10.1.0.0/16"), DstPort: 22},
+		{ID: 3, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPorts: ports("20-30")},
+		{ID: 4, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPorts: ports("22,25")},
+		{ID: 5, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPorts: ports("25-40")},
+		{ID: 6, Chain: "INPUT", Action: ActionReject, Protocol: "TCP", DstPorts: ports("!1-10000")},
+		{ID: 7, Chain: "CUSTOM", Action: ActionAccept},
+		{ID: 8, Chain: "INPUT", Action: ActionAccept, Protocol: "ICMP", DstPort: 80},
+		{ID: 9, Action: ActionAccept, SrcAddrs: mustAddrs(t, "10.0.0.0/8"), DstAddrs: mustAddrs(t, "2001:db8::/32")},
+		{ID: 10, Chain: "FORWARD", Action: ActionAccept, FromZone: "lan", ToZone: "dmz"},
+		{ID: 11, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 4000, Disabled: true},
+		{ID: 12, Chain: "INPUT", Action: ActionLog, Protocol: "TCP", DstPort: 5000},
+		{ID: 13, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 5000, RateLimit: 5},
+		{ID: 14, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 5000},
+		{ID: 15, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 5000, CTState: network.CTNew},
+	}
+	findings := AnalyzeRules(rules, []Zone{{Name: "lan"}})
+	want := map[uint64]FindingKind{
+		2:  FindingShadowed,
+		4:  FindingRedundant,
+		5:  FindingConflict,
+		7:  FindingUnreachable,
+		8:  FindingUnreachable,
+		9:  FindingUnreachable,
+		10: FindingUnreachable,
+		15: FindingShadowed,
+	}
+	got := findingKinds(findings)
+	if len(got) != len(want) {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+	for id, kind := range want {
+		if got[id] != kind {
+			t.Fatalf("rule %d: expected %s, got %q in %v", id, kind, got[id], findings)
+		}
+	}
+	for _, f := range findings {
+		if f.Rule == 2 && (f.Related != 1 || f.Position != 2 || f.Chain != "INPUT") {
+			t.Fatalf("unexpected shadowing finding: %+v", f)
+		}
+		if f.Rule == 5 && f.Related != 3 || f.Rule == 15 && f.Related != 14 {
+			t.Fatalf("unexpected related rule: %+v", f)
+		}
+	}
+}
+
+func TestAnalyzeUnusedRules(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{ID: 1, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+		{ID: 2, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 80},
+	}, map[string]Action{"INPUT": ActionDrop})
+	engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTNew))
+
+	if findings := engine.Analyze(time.Time{}); len(findings) != 0 {
+		t.Fatalf("expected no findings without a period, got %v", findings)
+	}
+	got := findingKinds(engine.Analyze(time.Now().Add(-time.Hour)))
+	if len(got) != 1 || got[2] != FindingUnused {
+		t.Fatalf("expected rule 2 to be unused, got %v", got)
+	}
+	if stats := engine.RulesWithStats(); stats[0].LastHit.IsZero() || !stats[1].LastHit.IsZero() {
+		t.Fatalf("unexpected last hits: %+v", stats)
+	}
+}
@@ -0,0 +1,173 @@
+package firewall
+
+import (
+	"fmt"
+	"strings"
+
+	"router-go/pkg/network"
+)
+
+// MaxChainDepth bounds how deeply JUMP and GOTO rules nest. A jump beyond
+// it returns at once; CheckChains rejects the loops that could reach it.
+const MaxChainDepth = 16
+
+// builtinChains are the chains the packet path evaluates. Every other chain
+// is only walked when a JUMP or GOTO rule leads to it.
+var builtinChains = map[string]bool{"INPUT": true, "OUTPUT": true, "FORWARD": true}
+
+// IsBuiltinChain reports whether name is INPUT, OUTPUT or FORWARD.
+func IsBuiltinChain(name string) bool {
+	return builtinChains[strings.ToUpper(strings.TrimSpace(name))]
+}
+
+func (a Action) jumps() bool {
+	return a == ActionJump || a == ActionGoto
+}
+
+// userChains returns the chains JUMP and GOTO rules lead to. Rules without
+// a chain do not apply in them.
+func userChains(rules []Rule) map[string]bool {
+	out := map[string]bool{}
+	for i := range rules {
+		if rules[i].Action.jumps() && rules[i].targetNorm != "" {
+			out[rules[i].targetNorm] = true
+		}
+	}
+	return out
+}
+
+// CheckChains validates the JUMP and GOTO rules of a rule list: each needs a
+// target that is not a built-in chain, and no chain may lead back to itself.
+// Disabled rules are checked too, so enabling one cannot create a loop.
+func CheckChains(rules []Rule) error {
+	rules = normalizeRules(rules)
+	names := map[string]string{}
+	edges := map[string][]string{}
+	for i := range rules {
+		rule := &rules[i]
+		if _, ok := names[rule.chainNorm]; !ok {
+			names[rule.chainNorm] = rule.Chain
+		}
+		if !rule.Action.jumps() {
+			if rule.Target != "" {
+				return fmt.Errorf("firewall rule %d: target is only valid for JUMP and GOTO", i+1)
+			}
+			continue
+		}
+		if rule.targetNorm == "" {
+			return fmt.Errorf("firewall rule %d: %s needs a target chain", i+1, rule.Action)
+		}
+		if builtinChains[rule.targetNorm] {
+			return fmt.Errorf("firewall rule %d: cannot %s to built-in chain %s", i+1, strings.ToLower(string(rule.Action)), rule.targetNorm)
+		}
+		if _, ok := names[rule.targetNorm]; !ok {
+			names[rule.targetNorm] = rule.Target
+		}
+		// Rules without a chain only apply in built-in chains, which
+		// cannot be jumped to, so they cannot close a loop.
+		if rule.chainNorm != "" {
+			edges[rule.chainNorm] = append(edges[rule.chainNorm], rule.targetNorm)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(chain string) error
+	visit = func(chain string) error {
+		state[chain] = visiting
+		path = append(path, chain)
+		for _, next := range edges[chain] {
+			switch state[next] {
+			case visiting:
+				start := 0
+				for path[start] != next {
+					start++
+				}
+				loop := make([]string, 0, len(path)-start+1)
+				for _, name := range append(path[start:], next) {
+					loop = append(loop, names[name])
+				}
+				return fmt.Errorf("firewall chain loop: %s", strings.Join(loop, " -> "))
+			case unvisited:
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[chain] = done
+		return nil
+	}
+	for i := range rules {
+		chain := rules[i].chainNorm
+		if state[chain] == unvisited {
+			if err := visit(chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckRule validates the JUMP or GOTO target of a rule about to be added,
+// or to replace the rule matching replace (found as in RemoveRule) when
+// replace is not nil: the target chain must have rules and the change must
+// not close a chain loop.
+func (e *Engine) CheckRule(rule Rule, replace *Rule) error {
+	e.mu.Lock()
+	rules := append([]Rule(nil), e.rules...)
+	if replace != nil {
+		if i := e.find(*replace); i >= 0 {
+			rules = append(rules[:i], rules[i+1:]...)
+		}
+	}
+	e.mu.Unlock()
+
+	rules = append(rules, rule)
+	if err := CheckChains(rules); err != nil {
+		return err
+	}
+	if !rule.Action.jumps() {
+		return nil
+	}
+	target := strings.ToUpper(strings.TrimSpace(rule.Target))
+	for i := range rules {
+		if rules[i].chainNorm == target {
+			return nil
+		}
+	}
+	return fmt.Errorf("target chain %s has no rules", rule.Target)
+}
+
+// TraceStep is one matched rule on a packet's way through the chains. A
+// step without a rule is either the return at the end of a user chain or,
+// last, the zone default or policy that decided the verdict.
+type TraceStep struct {
+	Chain    string
+	Rule     uint64
+	Position int
+	Action   Action
+	Target   string
+}
+
+// Trace evaluates a packet like Evaluate and returns the steps it took,
+// without counting hits or logging. Rate and connection limited rules are
+// left out, as their state would change.
+func (e *Engine) Trace(chain string, pkt network.Packet) (Action, []TraceStep) {
+	rs := e.compiled.Load()
+	chainNorm := strings.ToUpper(chain)
+	steps := []TraceStep{}
+	w := &walk{
+		pkt:         pkt,
+		packetProto: packetProtoKey(pkt.Metadata),
+		inZone:      rs.zones.zoneOf(pkt.IngressInterface),
+		outZone:     rs.zones.zoneOf(pkt.EgressInterface),
+		trace:       &steps,
+	}
+	return rs.run(chainNorm, w), steps
+}
@@ -0,0 +1,140 @@
+package firewall
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+func chainPacket(src string, port int) network.Packet {
+	return network.Packet{
+		CTState: network.CTNew,
+		Metadata: network.PacketMetadata{
+			Protocol: "TCP", SrcIP: net.ParseIP(src), DstIP: net.ParseIP("192.0.2.1"), SrcPort: 40000, DstPort: port,
+		},
+	}
+}
+
+func sshGuardRules() []Rule {
+	trusted, _ := network.ParseAddrMatch("10.0.0.0/8")
+	blocked, _ := network.ParseAddrMatch("203.0.113.0/24")
+	return []Rule{
+		{ID: 1, Chain: "INPUT", Action: ActionJump, Target: "ssh-guard", Protocol: "TCP", DstPort: 22},
+		{ID: 2, Chain: "INPUT", Action: ActionGoto, Target: "web", Protocol: "TCP", DstPort: 80},
+		{ID: 3, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+		{ID: 4, Chain: "ssh-guard", Action: ActionReturn, SrcAddrs: trusted},
+		{ID: 5, Chain: "ssh-guard", Action: ActionDrop, SrcAddrs: blocked},
+		{ID: 6, Chain: "web", Action: ActionReject, SrcAddrs: blocked},
+		{ID: 7, Action: ActionAccept, Protocol: "TCP", DstPort: 80},
+	}
+}
+
+func TestFirewallJumpGotoReturn(t *testing.T) {
+	engine := NewEngineWithDefaults(sshGuardRules(), map[string]Action{"INPUT": ActionDrop})
+	for _, tc := range []struct {
+		src  string
+		port int
+		want Action
+	}{
+		{"10.1.2.3", 22, ActionAccept},     // RETURN, then rule 3
+		{"203.0.113.5", 22, ActionDrop},    // dropped in ssh-guard
+		{"198.51.100.5", 22, ActionAccept}, // falls off ssh-guard
+		{"203.0.113.5", 80, ActionReject},  // rejected in web
+		{"198.51.100.5", 80, ActionDrop},   // web returns, GOTO skips rule 7
+		{"198.51.100.5", 443, ActionDrop},  // policy
+	} {
+		if got := engine.Evaluate("INPUT", chainPacket(tc.src, tc.port)); got != tc.want {
+			t.Fatalf("%s:%d: expected %s, got %s", tc.src, tc.port, tc.want, got)
+		}
+	}
+
+	hits := engine.ChainHits()
+	if hits["INPUT"] != 6 || hits["SSH-GUARD"] != 3 || hits["WEB"] != 2 {
+		t.Fatalf("unexpected chain hits: %v", hits)
+	}
+	stats := engine.RulesWithStats()
+	if stats[0].Hits != 3 || stats[3].Hits != 1 || stats[6].Hits != 0 {
+		t.Fatalf("unexpected rule hits: %d %d %d", stats[0].Hits, stats[3].Hits, stats[6].Hits)
+	}
+}
+
+func TestFirewallTrace(t *testing.T) {
+	engine := NewEngineWithDefaults(sshGuardRules(), map[string]Action{"INPUT": ActionDrop})
+
+	verdict, steps := engine.Trace("INPUT", chainPacket("198.51.100.5", 22))
+	if verdict != ActionAccept {
+		t.Fatalf("expected accept, got %s", verdict)
+	}
+	want := []TraceStep{
+		{Chain: "INPUT", Rule: 1, Position: 1, Action: ActionJump, Target: "SSH-GUARD"},
+		{Chain: "SSH-GUARD", Action: ActionReturn},
+		{Chain: "INPUT", Rule: 3, Position: 3, Action: ActionAccept},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("unexpected steps: %+v", steps)
+	}
+	for i := range want {
+		if steps[i] != want[i] {
+			t.Fatalf("step %d: expected %+v, got %+v", i, want[i], steps[i])
+		}
+	}
+
+	verdict, steps = engine.Trace("INPUT", chainPacket("198.51.100.5", 80))
+	if verdict != ActionDrop || len(steps) != 3 || steps[2] != (TraceStep{Chain: "INPUT", Action: ActionDrop}) {
+		t.Fatalf("expected the policy to decide after web returns, got %s %+v", verdict, steps)
+	}
+	if hits := engine.ChainHits(); hits["INPUT"] != 0 || hits["SSH-GUARD"] != 0 || hits["WEB"] != 0 {
+		t.Fatalf("expected trace not to count hits, got %v", hits)
+	}
+}
+
+func TestCheckChains(t *testing.T) {
+	if err := CheckChains(sshGuardRules()); err != nil {
+		t.Fatalf("expected valid chains, got %v", err)
+	}
+	loop := append(sshGuardRules(),
+		Rule{Chain: "web", Action: ActionJump, Target: "audit"},
+		Rule{Chain: "audit", Action: ActionGoto, Target: "WEB"},
+	)
+	err := CheckChains(loop)
+	if err == nil || !strings.Contains(err.Error(), "web -> audit -> web") {
+		t.Fatalf("expected loop to be reported, got %v", err)
+	}
+	for _, rule := range []Rule{
+		{Chain: "INPUT", Action: ActionJump},
+		{Chain: "web", Action: ActionJump, Target: "input"},
+		{Chain: "INPUT", Action: ActionAccept, Target: "web"},
+		{Chain: "web", Action: ActionJump, Target: "web", Disabled: true},
+	} {
+		if err := CheckChains([]Rule{rule}); err == nil {
+			t.Fatalf("expected %+v to be rejected", rule)
+		}
+	}
+
+	rules := []Rule{
+		{Chain: "a", Action: ActionJump, Target: "b"},
+		{Chain: "b", Action: ActionJump, Target: "c"},
+		{Chain: "c", Action: ActionAccept},
+		{Chain: "a", Action: ActionGoto, Target: "c"},
+	}
+	if err := CheckChains(rules); err != nil {
+		t.Fatalf("expected shared targets without a loop to be valid, got %v", err)
+	}
+}
+
+func TestAnalyzeUserChains(t *testing.T) {
+	rules := append(sshGuardRules(),
+		Rule{ID: 8, Chain: "orphan", Action: ActionDrop},
+		Rule{ID: 9, Chain: "ssh-guard", Action: ActionDrop, SrcAddrs: sshGuardRules()[4].SrcAddrs, Protocol: "TCP"},
+	)
+	findings := AnalyzeRules(rules, nil)
+	byRule := map[uint64]FindingKind{}
+	for _, f := range findings {
+		byRule[f.Rule] = f.Kind
+	}
+	if byRule[8] != FindingUnreachable || byRule[9] != FindingRedundant || len(findings) != 2 {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+}
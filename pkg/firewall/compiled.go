@@ -0,0 +1,373 @@
+package firewall
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"router-go/pkg/ipset"
+	"router-go/pkg/network"
+	"router-go/pkg/schedule"
+)
+
+// counterShards spreads hit counters over cache lines so packets of
+// different flows evaluated in parallel do not contend on one counter.
+const counterShards = 8
+
+// maxIndexedPorts is the largest destination port list a rule can have and
+// still be indexed by port; larger lists and negated ones are checked for
+// every packet of the protocol.
+const maxIndexedPorts = 64
+
+type hitCounter struct {
+	shards [counterShards]struct {
+		n    atomic.Uint64
+		last atomic.Int64
+		_    [48]byte
+	}
+}
+
+func (c *hitCounter) add(shard int, n uint64) {
+	c.shards[shard&(counterShards-1)].n.Add(n)
+}
+
+// hit counts n packets and records when the rule last matched.
+func (c *hitCounter) hit(shard int, n uint64) {
+	s := &c.shards[shard&(counterShards-1)]
+	s.n.Add(n)
+	s.last.Store(hitClock.Load())
+}
+
+// lastHit returns when hit was last called, zero if never.
+func (c *hitCounter) lastHit() time.Time {
+	var last int64
+	for i := range c.shards {
+		last = max(last, c.shards[i].last.Load())
+	}
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+func (c *hitCounter) load() uint64 {
+	var total uint64
+	for i := range c.shards {
+		total += c.shards[i].n.Load()
+	}
+	return total
+}
+
+func (c *hitCounter) reset() {
+	for i := range c.shards {
+		c.shards[i].n.Store(0)
+		c.shards[i].last.Store(0)
+	}
+}
+
+// hitClock is a coarse clock for last hit times, so counting a hit does not
+// read the system clock. It ticks every second for the life of the process
+// once the first engine is created.
+var (
+	hitClock     atomic.Int64
+	hitClockOnce sync.Once
+)
+
+func startHitClock() {
+	hitClockOnce.Do(func() {
+		hitClock.Store(time.Now().UnixNano())
+		go func() {
+			for now := range time.Tick(time.Second) {
+				hitClock.Store(now.UnixNano())
+			}
+		}()
+	})
+}
+
+// counterShard picks a counter shard from the packet's flow, so one flow
+// always lands on the same shard.
+func counterShard(meta network.PacketMetadata) int {
+	shard := meta.SrcPort ^ meta.DstPort
+	if n := len(meta.SrcIP); n > 0 {
+		shard ^= int(meta.SrcIP[n-1])
+	}
+	return shard
+}
+
+// ruleset is the immutable compiled form of the engine state that Evaluate
+// reads without locking. It is rebuilt and published on every change.
+type ruleset struct {
+	chains    map[string]*chainRules
+	anyChain  *chainRules
+	zones     zoneSet
+	defaults  map[string]Action
+	sets      *ipset.Registry
+	schedules *schedule.Registry
+	logSink   func(LogRecord)
+	conns     ConnCounter
+	geo       network.GeoResolver
+}
+
+// chainRules holds the enabled rules that apply to one chain, in order,
+// indexed by protocol and destination port. Candidate lists hold positions
+// in rules and are sorted, so merging them keeps the first match semantics.
+type chainRules struct {
+	rules []compiledRule
+	exact map[portKey][]int32
+	wild  [256][]int32
+	hits  *hitCounter
+}
+
+type compiledRule struct {
+	rule     *Rule
+	hits     *hitCounter
+	position int
+}
+
+type portKey struct {
+	proto uint8
+	port  uint16
+}
+
+// compile builds a ruleset from the engine state; the caller holds e.mu.
+// Rules are copied because the engine updates its own list in place.
+func (e *Engine) compile() *ruleset {
+	rules := append([]Rule(nil), e.rules...)
+	rs := &ruleset{
+		chains:    map[string]*chainRules{},
+		zones:     e.zones,
+		defaults:  make(map[string]Action, len(e.defaultPolicies)),
+		sets:      e.sets,
+		schedules: e.schedules,
+		logSink:   e.logSink,
+		conns:     e.conns,
+		geo:       e.geo,
+	}
+	for k, v := range e.defaultPolicies {
+		rs.defaults[k] = v
+	}
+	names := map[string]bool{}
+	user := userChains(rules)
+	for i := range rules {
+		if rules[i].chainNorm != "" {
+			names[rules[i].chainNorm] = true
+		}
+	}
+	for name := range names {
+		chain := compileChain(rules, e.counters, name, user[name])
+		if user[name] {
+			chain.hits = e.chainCounter(name)
+		}
+		rs.chains[name] = chain
+	}
+	rs.anyChain = compileChain(rules, e.counters, "", false)
+	return rs
+}
+
+func (e *Engine) publish() {
+	e.compiled.Store(e.compile())
+}
+
+// compileChain collects the enabled rules of a chain, rules without a chain
+// included unless it is a user chain, and indexes them.
+func compileChain(rules []Rule, counters []*hitCounter, chain string, user bool) *chainRules {
+	c := &chainRules{exact: map[portKey][]int32{}}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Disabled || (rule.chainNorm != "" && rule.chainNorm != chain) || (rule.chainNorm == "" && user) {
+			continue
+		}
+		pos := int32(len(c.rules))
+		c.rules = append(c.rules, compiledRule{rule: rule, hits: counters[i], position: i + 1})
+		proto := uint8(0)
+		if rule.hasProto {
+			proto = rule.protoKey
+		}
+		ports, ok := indexedPorts(rule.DstPorts)
+		if !ok {
+			c.wild[proto] = append(c.wild[proto], pos)
+			continue
+		}
+		for _, port := range ports {
+			key := portKey{proto: proto, port: port}
+			c.exact[key] = append(c.exact[key], pos)
+		}
+	}
+	return c
+}
+
+// indexedPorts lists the ports of a small non-negated port match.
+func indexedPorts(m network.PortMatch) ([]uint16, bool) {
+	if m.IsZero() || m.Negate {
+		return nil, false
+	}
+	total := 0
+	for _, r := range m.Ranges {
+		total += r.To - r.From + 1
+	}
+	if total > maxIndexedPorts {
+		return nil, false
+	}
+	ports := make([]uint16, 0, total)
+	for _, r := range m.Ranges {
+		for port := r.From; port <= r.To; port++ {
+			ports = append(ports, uint16(port))
+		}
+	}
+	return ports, true
+}
+
+// candidates returns the sorted lists of rules that can match a packet with
+// the given protocol and destination port. A rule is in at most one of them.
+func (c *chainRules) candidates(proto uint8, port int) [4][]int32 {
+	var lists [4][]int32
+	if port > 0 && port <= 0xffff {
+		lists[0] = c.exact[portKey{proto: proto, port: uint16(port)}]
+		if proto != 0 {
+			lists[1] = c.exact[portKey{port: uint16(port)}]
+		}
+	}
+	lists[2] = c.wild[proto]
+	if proto != 0 {
+		lists[3] = c.wild[0]
+	}
+	return lists
+}
+
+// nextCandidate pops the lowest rule position off the lists, -1 when all
+// are empty.
+func nextCandidate(lists *[4][]int32) int {
+	best, from := int32(-1), -1
+	for k := range lists {
+		if len(lists[k]) > 0 && (best < 0 || lists[k][0] < best) {
+			best, from = lists[k][0], k
+		}
+	}
+	if from < 0 {
+		return -1
+	}
+	lists[from] = lists[from][1:]
+	return int(best)
+}
+
+// walk is the state of one packet's way through the chains. With trace set
+// the walk only records its steps: no hits are counted, nothing is logged
+// and rate and connection limited rules do not match.
+type walk struct {
+	pkt         network.Packet
+	packetProto uint8
+	inZone      string
+	outZone     string
+	shard       int
+	records     []LogRecord
+	trace       *[]TraceStep
+}
+
+// evaluate returns the verdict and the log records of the logging rules the
+// packet matched on the way; LOG rules do not end the walk.
+func (rs *ruleset) evaluate(chainNorm string, pkt network.Packet, shard int) (Action, []LogRecord) {
+	w := &walk{
+		pkt:         pkt,
+		packetProto: packetProtoKey(pkt.Metadata),
+		inZone:      rs.zones.zoneOf(pkt.IngressInterface),
+		outZone:     rs.zones.zoneOf(pkt.EgressInterface),
+		shard:       shard,
+	}
+	return rs.run(chainNorm, w), w.records
+}
+
+// run walks the chain the packet entered; when it returns without a
+// verdict, the zone defaults and then the chain policy decide.
+func (rs *ruleset) run(chainNorm string, w *walk) Action {
+	chain := rs.chains[chainNorm]
+	if chain == nil {
+		chain = rs.anyChain
+	}
+	if action, ok := rs.walkChain(chainNorm, chain, w, 0); ok {
+		return action
+	}
+	for _, rule := range rs.zones.defaults {
+		if rule.matches(chainNorm, w.packetProto, w.inZone, w.outZone, rs.sets, rs.schedules, w.pkt) {
+			w.step(TraceStep{Chain: chainNorm, Action: rule.Action})
+			return rule.Action
+		}
+	}
+	action, ok := rs.defaults[chainNorm]
+	if !ok {
+		action = ActionDrop
+	}
+	w.step(TraceStep{Chain: chainNorm, Action: action})
+	return action
+}
+
+// walkChain returns the verdict the chain reaches, or false when it returns
+// to its caller by RETURN, by running out of rules or through a GOTO whose
+// chain returns.
+func (rs *ruleset) walkChain(chainNorm string, chain *chainRules, w *walk, depth int) (Action, bool) {
+	lists := chain.candidates(w.packetProto, w.pkt.Metadata.DstPort)
+	for {
+		i := nextCandidate(&lists)
+		if i < 0 {
+			break
+		}
+		cr := &chain.rules[i]
+		rule := cr.rule
+		if !rule.matches(chainNorm, w.packetProto, w.inZone, w.outZone, rs.sets, rs.schedules, w.pkt) {
+			continue
+		}
+		if rule.geoMatched() && !rule.matchesGeo(rs.geo, w.pkt) {
+			continue
+		}
+		if rule.limited() && (w.trace != nil || !rule.overLimits(rs.conns, w.pkt)) {
+			continue
+		}
+		if w.trace != nil {
+			w.step(TraceStep{Chain: chainNorm, Rule: rule.ID, Position: cr.position, Action: rule.Action, Target: rule.targetNorm})
+		} else {
+			cr.hits.hit(w.shard, 1)
+			if rule.logLimit != nil && rs.logSink != nil {
+				if ok, suppressed := rule.logLimit.allow(time.Now()); ok {
+					w.records = append(w.records, logRecord(rule.ID, chainNorm, w.pkt, suppressed))
+				}
+			}
+		}
+		switch rule.Action {
+		case ActionLog:
+			continue
+		case ActionReturn:
+			return "", false
+		case ActionJump:
+			if action, ok := rs.enter(rule.targetNorm, w, depth); ok {
+				return action, true
+			}
+			continue
+		case ActionGoto:
+			return rs.enter(rule.targetNorm, w, depth)
+		}
+		return rule.Action, true
+	}
+	if depth > 0 {
+		w.step(TraceStep{Chain: chainNorm, Action: ActionReturn})
+	}
+	return "", false
+}
+
+// enter walks a user chain for a JUMP or GOTO. A missing chain, or one
+// nested deeper than MaxChainDepth, returns at once.
+func (rs *ruleset) enter(target string, w *walk, depth int) (Action, bool) {
+	chain := rs.chains[target]
+	if chain == nil || depth >= MaxChainDepth {
+		w.step(TraceStep{Chain: target, Action: ActionReturn})
+		return "", false
+	}
+	if chain.hits != nil && w.trace == nil {
+		chain.hits.add(w.shard, 1)
+	}
+	return rs.walkChain(target, chain, w, depth+1)
+}
+
+func (w *walk) step(step TraceStep) {
+	if w.trace != nil {
+		*w.trace = append(*w.trace, step)
+	}
+}
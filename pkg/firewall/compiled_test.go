@@ -0,0 +1,169 @@
+package firewall
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+func randomRules(rng *rand.Rand, n int) []Rule {
+	chains := []string{"INPUT", "FORWARD", "OUTPUT", ""}
+	protos := []string{"TCP", "UDP", "ICMP", ""}
+	actions := []Action{ActionAccept, ActionDrop, ActionReject}
+	rules := make([]Rule, 0, n)
+	for i := 0; i < n; i++ {
+		rule := Rule{
+			Chain:    chains[rng.Intn(len(chains))],
+			Action:   actions[rng.Intn(len(actions))],
+			Protocol: protos[rng.Intn(len(protos))],
+			Disabled: rng.Intn(20) == 0,
+		}
+		switch rng.Intn(6) {
+		case 0:
+		case 1:
+			rule.DstPorts, _ = network.ParsePortMatch(fmt.Sprintf("%d-%d", 1000+rng.Intn(50), 1100+rng.Intn(50)))
+		case 2:
+			rule.DstPorts, _ = network.ParsePortMatch(fmt.Sprintf("!%d", 1+rng.Intn(200)))
+		case 3:
+			rule.DstPorts, _ = network.ParsePortMatch(fmt.Sprintf("%d,%d", 1+rng.Intn(200), 1+rng.Intn(200)))
+		default:
+			rule.DstPort = 1 + rng.Intn(200)
+		}
+		if rng.Intn(3) == 0 {
+			rule.SrcAddrs, _ = network.ParseAddrMatch(fmt.Sprintf("10.%d.0.0/16", rng.Intn(4)))
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func randomPacket(rng *rand.Rand) network.Packet {
+	protos := []string{"TCP", "UDP", "ICMP", "GRE"}
+	return network.Packet{
+		Metadata: network.PacketMetadata{
+			Protocol: protos[rng.Intn(len(protos))],
+			SrcIP:    net.IPv4(10, byte(rng.Intn(5)), byte(rng.Intn(256)), byte(rng.Intn(256))),
+			DstIP:    net.IPv4(192, 0, 2, 1),
+			SrcPort:  1024 + rng.Intn(60000),
+			DstPort:  rng.Intn(1200),
+		},
+	}
+}
+
+// linearEvaluate is the reference first match walk over every rule.
+func linearEvaluate(rules []Rule, chain string, pkt network.Packet) (Action, int) {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Disabled && rule.matches(chain, packetProtoKey(pkt.Metadata), "", "", nil, nil, pkt) {
+			return rule.Action, i
+		}
+	}
+	return ActionDrop, -1
+}
+
+func TestCompiledMatchesLinearEvaluation(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	engine := NewEngine(randomRules(rng, 2000))
+	rules := engine.Rules()
+	want := make([]uint64, len(rules))
+	for i := 0; i < 20000; i++ {
+		chain := []string{"INPUT", "FORWARD", "OUTPUT", "CUSTOM"}[rng.Intn(4)]
+		pkt := randomPacket(rng)
+		action, index := linearEvaluate(rules, chain, pkt)
+		if index >= 0 {
+			want[index]++
+		}
+		if got := engine.Evaluate(chain, pkt); got != action {
+			t.Fatalf("packet %+v in %s: got %s, want %s from rule %d", pkt.Metadata, chain, got, action, index)
+		}
+	}
+	for i, stat := range engine.RulesWithStats() {
+		if stat.Hits != want[i] {
+			t.Fatalf("rule %d: got %d hits, want %d", i, stat.Hits, want[i])
+		}
+	}
+	if hits := engine.ChainHits(); hits["CUSTOM"] == 0 || hits["INPUT"] == 0 {
+		t.Fatalf("expected chain hits for evaluated chains, got %v", hits)
+	}
+}
+
+func TestEvaluateConcurrentWithUpdates(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	engine := NewEngine(randomRules(rng, 200))
+	packets := make([]network.Packet, 256)
+	for i := range packets {
+		packets[i] = randomPacket(rng)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				engine.Evaluate("INPUT", packets[(i+w)&255])
+			}
+		}(w)
+	}
+	for i := 0; i < 50; i++ {
+		id := engine.AddRule(Rule{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22})
+		engine.SetRuleEnabled(id, false)
+		engine.MoveRule(id, Placement{Position: 1})
+		engine.RemoveRule(Rule{ID: id})
+	}
+	wg.Wait()
+
+	var total uint64
+	for _, stat := range engine.RulesWithStats() {
+		total += stat.Hits
+	}
+	if chain := engine.ChainHits()["INPUT"]; chain != 8000 || total > chain {
+		t.Fatalf("expected 8000 INPUT evaluations and at most as many rule hits, got %d and %d", chain, total)
+	}
+}
+
+func BenchmarkEvaluate10kRules(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	engine := NewEngine(randomRules(rng, 10000))
+	packets := make([]network.Packet, 1024)
+	for i := range packets {
+		packets[i] = randomPacket(rng)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.Evaluate("FORWARD", packets[i&1023])
+	}
+}
+
+func BenchmarkEvaluate10kRulesParallel(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	engine := NewEngine(randomRules(rng, 10000))
+	packets := make([]network.Packet, 1024)
+	for i := range packets {
+		packets[i] = randomPacket(rng)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			engine.Evaluate("FORWARD", packets[i&1023])
+			i++
+		}
+	})
+}
+
+func BenchmarkCompile10kRules(b *testing.B) {
+	engine := NewEngine(randomRules(rand.New(rand.NewSource(1)), 10000))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		engine.mu.Lock()
+		engine.publish()
+		engine.mu.Unlock()
+	}
+}
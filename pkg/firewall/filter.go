@@ -4,8 +4,12 @@ import (
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
+	"router-go/pkg/schedule"
 )
 
 type Action string
@@ -14,38 +18,108 @@ const (
 	ActionAccept Action = "ACCEPT"
 	ActionDrop   Action = "DROP"
 	ActionReject Action = "REJECT"
+	// ActionLog records the packet and continues with the next rule.
+	ActionLog Action = "LOG"
+	// ActionJump walks the user chain named by Target and, when it returns,
+	// continues with the next rule.
+	ActionJump Action = "JUMP"
+	// ActionGoto walks the user chain named by Target in place of the rest
+	// of the current chain; when it returns, so does the current chain.
+	ActionGoto Action = "GOTO"
+	// ActionReturn ends the current chain. A built-in chain that returns
+	// falls through to its zone defaults and policy.
+	ActionReturn Action = "RETURN"
 )
 
+// Rule matches packets by protocol, addresses, ip sets, ports, interfaces,
+// zones and conntrack state. A rule with both FromZone and ToZone and no
+// chain is a zone pair rule in FORWARD.
 type Rule struct {
-	Chain        string
-	Action       Action
-	Protocol     string
-	SrcNet       *net.IPNet
-	DstNet       *net.IPNet
-	SrcPort      int
-	DstPort      int
+	// ID identifies the rule for its lifetime; the engine assigns one when
+	// it is zero or already taken.
+	ID          uint64
+	Description string
+	// Disabled rules keep their place and counters but never match.
+	Disabled bool
+	Chain    string
+	Action   Action
+	// Target names the user chain of a JUMP or GOTO rule.
+	Target   string
+	Protocol string
+	// SrcNet/DstNet and SrcPort/DstPort are the single value forms of
+	// SrcAddrs/DstAddrs and SrcPorts/DstPorts; the lists win when both are set.
+	SrcNet   *net.IPNet
+	DstNet   *net.IPNet
+	SrcPort  int
+	DstPort  int
+	SrcAddrs network.AddrMatch
+	DstAddrs network.AddrMatch
+	// SrcSet/DstSet name an ip set, "!name" negates.
+	SrcSet       string
+	DstSet       string
+	SrcPorts     network.PortMatch
+	DstPorts     network.PortMatch
 	InInterface  string
 	OutInterface string
+	FromZone     string
+	ToZone       string
+	CTState      network.CTState
+	// Schedule names a schedule the rule only matches while it is active.
+	Schedule string
+	// Log records matched packets, at most LogRate per second
+	// (DefaultLogRate when zero).
+	Log     bool
+	LogRate int
+	// RateLimit matches only sources sending more than RateLimit packets
+	// per second, with bursts of RateBurst (the rate when zero). With
+	// ConnLimit also set, a source must be over both limits.
+	RateLimit int
+	RateBurst int
+	// ConnLimit matches only sources holding more than ConnLimit tracked
+	// connections; with RateLimit, only while the source is also over its
+	// rate. Packets of sources under ConnLimit do not use up the rate.
+	ConnLimit int
+	// LimitPrefix keys RateLimit and ConnLimit sources by their first
+	// LimitPrefix bits, the whole address when zero.
+	LimitPrefix int
+	// SrcCountry/DstCountry and SrcASN match what the engine's GeoResolver
+	// reports for the address; without a resolver they never match.
+	SrcCountry network.CountryMatch
+	DstCountry network.CountryMatch
+	SrcASN     network.ASNMatch
+
 	chainNorm    string
+	fromZoneNorm string
+	toZoneNorm   string
+	targetNorm   string
 	protoKey     uint8
 	hasProto     bool
+	logLimit     *logLimiter
+	rateLimit    *rateLimiter
 }
 
+// Engine holds the rule list and publishes a compiled ruleset after every
+// change; Evaluate only reads the published ruleset and never locks. Hit
+// counters belong to the rules and survive recompilation.
 type Engine struct {
 	rules           []Rule
 	defaultPolicies map[string]Action
-	hits            []uint64
+	counters        []*hitCounter
 	mu              sync.Mutex
-	chainHits       map[string]uint64
+	chainHits       sync.Map
+	zones           zoneSet
+	sets            *ipset.Registry
+	schedules       *schedule.Registry
+	logSink         func(LogRecord)
+	conns           ConnCounter
+	geo             network.GeoResolver
+	onChange        func()
+	nextID          uint64
+	compiled        atomic.Pointer[ruleset]
 }
 
 func NewEngine(rules []Rule) *Engine {
-	return &Engine{
-		rules:           normalizeRules(rules),
-		defaultPolicies: map[string]Action{},
-		hits:            make([]uint64, len(rules)),
-		chainHits:       map[string]uint64{},
-	}
+	return NewEngineWithDefaults(rules, nil)
 }
 
 func NewEngineWithDefaults(rules []Rule, defaults map[string]Action) *Engine {
@@ -53,54 +127,146 @@ func NewEngineWithDefaults(rules []Rule, defaults map[string]Action) *Engine {
 	for k, v := range defaults {
 		policies[strings.ToUpper(k)] = v
 	}
-	return &Engine{
+	e := &Engine{
 		rules:           normalizeRules(rules),
 		defaultPolicies: policies,
-		hits:            make([]uint64, len(rules)),
-		chainHits:       map[string]uint64{},
+		counters:        newCounters(len(rules)),
 	}
+	e.assignIDs()
+	e.publish()
+	startHitClock()
+	return e
 }
 
-func (e *Engine) AddRule(rule Rule) {
+func (e *Engine) SetOnChange(fn func()) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	e.rules = append(e.rules, normalizeRule(rule))
-	e.hits = append(e.hits, 0)
+	e.onChange = fn
 }
 
+// AddRule appends the rule and returns its ID.
+func (e *Engine) AddRule(rule Rule) uint64 {
+	e.mu.Lock()
+	rule = normalizeRule(rule)
+	rule.ID = e.claimID(rule.ID)
+	e.rules = append(e.rules, rule)
+	e.counters = append(e.counters, &hitCounter{})
+	e.publish()
+	e.mu.Unlock()
+	e.notifyChange()
+	return rule.ID
+}
+
+// RemoveRule removes the first rule matching match, by ID when match has
+// one and by its fields otherwise.
 func (e *Engine) RemoveRule(match Rule) bool {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-	for i, rule := range e.rules {
-		if rulesEqual(rule, normalizeRule(match)) {
-			e.rules = append(e.rules[:i], e.rules[i+1:]...)
-			e.hits = append(e.hits[:i], e.hits[i+1:]...)
-			return true
-		}
+	i := e.find(match)
+	if i >= 0 {
+		e.rules = append(e.rules[:i], e.rules[i+1:]...)
+		e.counters = append(e.counters[:i], e.counters[i+1:]...)
+		e.publish()
 	}
-	return false
+	e.mu.Unlock()
+	if i < 0 {
+		return false
+	}
+	e.notifyChange()
+	return true
 }
 
+// UpdateRule replaces the rule matching old, found as in RemoveRule. The
+// rule keeps its position, hit counter and, unless updated sets another free
+// one, its ID.
 func (e *Engine) UpdateRule(old Rule, updated Rule) bool {
 	e.mu.Lock()
-	defer e.mu.Unlock()
-	for i, rule := range e.rules {
-		if rulesEqual(rule, normalizeRule(old)) {
-			e.rules[i] = normalizeRule(updated)
-			if i < len(e.hits) {
-				e.hits[i] = 0
-			}
-			return true
+	i := e.find(old)
+	if i >= 0 {
+		updated = normalizeRule(updated)
+		if updated.ID == 0 || updated.ID == e.rules[i].ID || e.indexOf(updated.ID) >= 0 {
+			updated.ID = e.rules[i].ID
+		} else {
+			updated.ID = e.claimID(updated.ID)
 		}
+		e.rules[i] = updated
+		e.publish()
 	}
-	return false
+	e.mu.Unlock()
+	if i < 0 {
+		return false
+	}
+	e.notifyChange()
+	return true
 }
 
 func (e *Engine) SetDefaultPolicy(chain string, action Action) {
+	e.mu.Lock()
 	if e.defaultPolicies == nil {
 		e.defaultPolicies = map[string]Action{}
 	}
 	e.defaultPolicies[strings.ToUpper(chain)] = action
+	e.publish()
+	e.mu.Unlock()
+	e.notifyChange()
+}
+
+// SetIPSets attaches the registry that SrcSet/DstSet references resolve
+// against. Without one every referenced set is empty.
+func (e *Engine) SetIPSets(sets *ipset.Registry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sets = sets
+	e.publish()
+}
+
+// SetSchedules attaches the registry Schedule references resolve against.
+// Without one every scheduled rule is inactive.
+func (e *Engine) SetSchedules(schedules *schedule.Registry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.schedules = schedules
+	e.publish()
+}
+
+// SetZones replaces the zone definitions and zone pair policies.
+func (e *Engine) SetZones(zones []Zone, policies []ZonePolicy) {
+	e.mu.Lock()
+	e.zones = compileZones(zones, policies)
+	e.publish()
+	e.mu.Unlock()
+	e.notifyChange()
+}
+
+func (e *Engine) Zones() []Zone {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Zone(nil), e.zones.zones...)
+}
+
+func (e *Engine) ZonePolicies() []ZonePolicy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]ZonePolicy(nil), e.zones.policies...)
+}
+
+// ZoneOf returns the zone an interface belongs to, or "" when it is in none.
+func (e *Engine) ZoneOf(iface string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.zones.zoneOf(iface)
+}
+
+// HasZone reports whether a zone with the given name is defined.
+func (e *Engine) HasZone(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, zone := range e.zones.zones {
+		if strings.ToLower(zone.Name) == name {
+			return true
+		}
+	}
+	return false
 }
 
 func (e *Engine) Rules() []Rule {
@@ -112,6 +278,8 @@ func (e *Engine) Rules() []Rule {
 }
 
 func (e *Engine) DefaultPolicies() map[string]Action {
+	e.mu.Lock()
+	defer e.mu.Unlock()
 	out := make(map[string]Action, len(e.defaultPolicies))
 	for k, v := range e.defaultPolicies {
 		out[k] = v
@@ -120,8 +288,10 @@ func (e *Engine) DefaultPolicies() map[string]Action {
 }
 
 type RuleStat struct {
-	Rule Rule
-	Hits uint64
+	Rule    Rule
+	Hits    uint64
+	LastHit time.Time
+	Active  bool
 }
 
 func (e *Engine) RulesWithStats() []RuleStat {
@@ -130,94 +300,185 @@ func (e *Engine) RulesWithStats() []RuleStat {
 	out := make([]RuleStat, 0, len(e.rules))
 	for i, rule := range e.rules {
 		out = append(out, RuleStat{
-			Rule: rule,
-			Hits: e.hits[i],
+			Rule:    rule,
+			Hits:    e.counters[i].load(),
+			LastHit: e.counters[i].lastHit(),
+			Active:  !rule.Disabled && e.schedules.Active(rule.Schedule),
 		})
 	}
 	return out
 }
 
 func (e *Engine) ChainHits() map[string]uint64 {
+	out := map[string]uint64{}
+	e.chainHits.Range(func(key, value any) bool {
+		out[key.(string)] = value.(*hitCounter).load()
+		return true
+	})
+	return out
+}
+
+// AddRuleHits adds packet counts by rule ID; IDs of rules that are gone are
+// ignored.
+func (e *Engine) AddRuleHits(deltas map[uint64]uint64) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	out := make(map[string]uint64, len(e.chainHits))
-	for k, v := range e.chainHits {
-		out[k] = v
+	for i, rule := range e.rules {
+		if delta := deltas[rule.ID]; delta > 0 {
+			e.counters[i].hit(0, delta)
+		}
 	}
-	return out
 }
 
 func (e *Engine) ResetStats() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	for i := range e.hits {
-		e.hits[i] = 0
-	}
-	for k := range e.chainHits {
-		e.chainHits[k] = 0
+	for _, counter := range e.counters {
+		counter.reset()
 	}
+	e.chainHits.Range(func(_, value any) bool {
+		value.(*hitCounter).reset()
+		return true
+	})
 }
 
 func (e *Engine) Replace(rules []Rule, defaults map[string]Action) {
 	e.mu.Lock()
-	defer e.mu.Unlock()
 	e.rules = normalizeRules(rules)
-	e.hits = make([]uint64, len(rules))
+	e.assignIDs()
+	e.counters = newCounters(len(rules))
 	e.defaultPolicies = map[string]Action{}
 	for k, v := range defaults {
 		e.defaultPolicies[strings.ToUpper(k)] = v
 	}
-	e.chainHits = map[string]uint64{}
+	e.chainHits.Clear()
+	e.publish()
+	e.mu.Unlock()
+	e.notifyChange()
 }
 
-func (e *Engine) Evaluate(chain string, pkt network.Packet) Action {
+func (e *Engine) notifyChange() {
 	e.mu.Lock()
-	defer e.mu.Unlock()
+	fn := e.onChange
+	e.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (e *Engine) Evaluate(chain string, pkt network.Packet) Action {
+	rs := e.compiled.Load()
 	chainNorm := strings.ToUpper(chain)
+	shard := counterShard(pkt.Metadata)
 	if chainNorm != "" {
-		e.chainHits[chainNorm]++
+		e.chainCounter(chainNorm).add(shard, 1)
 	}
-	packetProto := packetProtoKey(pkt.Metadata)
-	for i, rule := range e.rules {
-		if rule.chainNorm != "" && rule.chainNorm != chainNorm {
-			continue
-		}
-		if rule.hasProto && rule.protoKey != packetProto {
-			continue
-		}
-		if rule.SrcNet != nil && pkt.Metadata.SrcIP != nil && !rule.SrcNet.Contains(pkt.Metadata.SrcIP) {
-			continue
-		}
-		if rule.DstNet != nil && pkt.Metadata.DstIP != nil && !rule.DstNet.Contains(pkt.Metadata.DstIP) {
-			continue
-		}
-		if rule.SrcPort != 0 && rule.SrcPort != pkt.Metadata.SrcPort {
-			continue
-		}
-		if rule.DstPort != 0 && rule.DstPort != pkt.Metadata.DstPort {
-			continue
-		}
-		if rule.InInterface != "" && rule.InInterface != pkt.IngressInterface {
-			continue
-		}
-		if rule.OutInterface != "" && rule.OutInterface != pkt.EgressInterface {
-			continue
-		}
-		e.hits[i]++
-		return rule.Action
+	action, records := rs.evaluate(chainNorm, pkt, shard)
+	for _, record := range records {
+		record.Verdict = action
+		rs.logSink(record)
 	}
-	if e.defaultPolicies != nil {
-		if action, ok := e.defaultPolicies[chainNorm]; ok {
-			return action
-		}
+	return action
+}
+
+func (e *Engine) chainCounter(chain string) *hitCounter {
+	if counter, ok := e.chainHits.Load(chain); ok {
+		return counter.(*hitCounter)
 	}
-	return ActionDrop
+	counter, _ := e.chainHits.LoadOrStore(chain, &hitCounter{})
+	return counter.(*hitCounter)
+}
+
+func newCounters(n int) []*hitCounter {
+	out := make([]*hitCounter, n)
+	for i := range out {
+		out[i] = &hitCounter{}
+	}
+	return out
+}
+
+func (rule *Rule) matches(chainNorm string, packetProto uint8, inZone string, outZone string, sets *ipset.Registry, schedules *schedule.Registry, pkt network.Packet) bool {
+	if rule.chainNorm != "" && rule.chainNorm != chainNorm {
+		return false
+	}
+	if rule.hasProto && rule.protoKey != packetProto {
+		return false
+	}
+	if pkt.Metadata.SrcIP != nil && !rule.SrcAddrs.Matches(pkt.Metadata.SrcIP) {
+		return false
+	}
+	if pkt.Metadata.DstIP != nil && !rule.DstAddrs.Matches(pkt.Metadata.DstIP) {
+		return false
+	}
+	if rule.SrcSet != "" && pkt.Metadata.SrcIP != nil && !sets.Match(rule.SrcSet, pkt.Metadata.SrcIP) {
+		return false
+	}
+	if rule.DstSet != "" && pkt.Metadata.DstIP != nil && !sets.Match(rule.DstSet, pkt.Metadata.DstIP) {
+		return false
+	}
+	if !rule.SrcPorts.Matches(pkt.Metadata.SrcPort) || !rule.DstPorts.Matches(pkt.Metadata.DstPort) {
+		return false
+	}
+	if rule.InInterface != "" && rule.InInterface != pkt.IngressInterface {
+		return false
+	}
+	if rule.OutInterface != "" && rule.OutInterface != pkt.EgressInterface {
+		return false
+	}
+	if rule.fromZoneNorm != "" && rule.fromZoneNorm != inZone {
+		return false
+	}
+	if rule.toZoneNorm != "" && rule.toZoneNorm != outZone {
+		return false
+	}
+	if rule.CTState != 0 && rule.CTState&pkt.CTState == 0 {
+		return false
+	}
+	if rule.Schedule != "" && !schedules.Active(rule.Schedule) {
+		return false
+	}
+	return true
 }
 
 func normalizeRule(rule Rule) Rule {
+	if rule.SrcAddrs.IsZero() {
+		rule.SrcAddrs = network.NetMatch(rule.SrcNet)
+	}
+	if rule.DstAddrs.IsZero() {
+		rule.DstAddrs = network.NetMatch(rule.DstNet)
+	}
+	if rule.SrcPorts.IsZero() {
+		rule.SrcPorts = network.SinglePort(rule.SrcPort)
+	}
+	if rule.DstPorts.IsZero() {
+		rule.DstPorts = network.SinglePort(rule.DstPort)
+	}
+	rule.SrcNet = rule.SrcAddrs.Single()
+	rule.DstNet = rule.DstAddrs.Single()
+	rule.SrcPort, _ = rule.SrcPorts.Single()
+	rule.DstPort, _ = rule.DstPorts.Single()
+	rule.SrcSet = ipset.NormalizeRef(rule.SrcSet)
+	rule.DstSet = ipset.NormalizeRef(rule.DstSet)
+	rule.Schedule = strings.TrimSpace(rule.Schedule)
+	rule.Description = strings.TrimSpace(rule.Description)
+	if rule.Chain == "" && rule.FromZone != "" && rule.ToZone != "" {
+		rule.Chain = "FORWARD"
+	}
 	rule.chainNorm = strings.ToUpper(rule.Chain)
+	rule.Target = strings.TrimSpace(rule.Target)
+	rule.targetNorm = strings.ToUpper(rule.Target)
+	rule.fromZoneNorm = strings.ToLower(rule.FromZone)
+	rule.toZoneNorm = strings.ToLower(rule.ToZone)
 	rule.protoKey = protoToKey(rule.Protocol)
 	rule.hasProto = rule.Protocol != ""
+	rule.logLimit = nil
+	if rule.logs() {
+		rule.logLimit = newLogLimiter(rule.LogRate)
+	}
+	rule.rateLimit = nil
+	if rule.RateLimit > 0 {
+		rule.rateLimit = newRateLimiter(rule.RateLimit, rule.RateBurst, rule.LimitPrefix)
+	}
 	return rule
 }
 
@@ -233,43 +494,34 @@ func rulesEqual(a Rule, b Rule) bool {
 	if !strings.EqualFold(a.Chain, b.Chain) {
 		return false
 	}
-	if a.Action != b.Action || !strings.EqualFold(a.Protocol, b.Protocol) {
+	if a.Action != b.Action || a.targetNorm != b.targetNorm || !strings.EqualFold(a.Protocol, b.Protocol) {
 		return false
 	}
-	if a.SrcPort != b.SrcPort || a.DstPort != b.DstPort {
+	if !a.SrcPorts.Equal(b.SrcPorts) || !a.DstPorts.Equal(b.DstPorts) {
 		return false
 	}
 	if a.InInterface != b.InInterface || a.OutInterface != b.OutInterface {
 		return false
 	}
-	if !ipNetEqual(a.SrcNet, b.SrcNet) || !ipNetEqual(a.DstNet, b.DstNet) {
+	if a.SrcSet != b.SrcSet || a.DstSet != b.DstSet {
 		return false
 	}
-	return true
-}
-
-func ipNetEqual(a *net.IPNet, b *net.IPNet) bool {
-	if a == nil && b == nil {
-		return true
-	}
-	if a == nil || b == nil {
+	if a.fromZoneNorm != b.fromZoneNorm || a.toZoneNorm != b.toZoneNorm {
 		return false
 	}
-	if len(a.Mask) != len(b.Mask) {
+	if a.CTState != b.CTState || a.Schedule != b.Schedule {
 		return false
 	}
-	for i := range a.Mask {
-		if a.Mask[i] != b.Mask[i] {
-			return false
-		}
+	if a.RateLimit != b.RateLimit || a.RateBurst != b.RateBurst || a.ConnLimit != b.ConnLimit || a.LimitPrefix != b.LimitPrefix {
+		return false
 	}
-	if a.IP == nil && b.IP == nil {
-		return true
+	if !a.SrcAddrs.Equal(b.SrcAddrs) || !a.DstAddrs.Equal(b.DstAddrs) {
+		return false
 	}
-	if a.IP == nil || b.IP == nil {
+	if !a.SrcCountry.Equal(b.SrcCountry) || !a.DstCountry.Equal(b.DstCountry) || !a.SrcASN.Equal(b.SrcASN) {
 		return false
 	}
-	return a.IP.Equal(b.IP)
+	return true
 }
 
 func protoToKey(proto string) uint8 {
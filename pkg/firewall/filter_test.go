@@ -3,8 +3,11 @@ package firewall
 import (
 	"net"
 	"testing"
+	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
+	"router-go/pkg/schedule"
 )
 
 func TestFirewallAccept(t *testing.T) {
@@ -286,3 +289,155 @@ func TestFirewallUpdateRule(t *testing.T) {
 		t.Fatalf("expected update to fail for missing rule")
 	}
 }
+
+func TestFirewallCTStateMatch(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "FORWARD", Action: ActionDrop, CTState: network.CTInvalid},
+		{Chain: "FORWARD", Action: ActionAccept, CTState: network.CTEstablished | network.CTRelated},
+	}, map[string]Action{"FORWARD": ActionDrop})
+
+	cases := map[network.CTState]Action{
+		network.CTEstablished: ActionAccept,
+		network.CTRelated:     ActionAccept,
+		network.CTNew:         ActionDrop,
+		network.CTInvalid:     ActionDrop,
+		0:                     ActionDrop,
+	}
+	for state, want := range cases {
+		pkt := network.Packet{CTState: state, Metadata: network.PacketMetadata{Protocol: "TCP"}}
+		if got := engine.Evaluate("FORWARD", pkt); got != want {
+			t.Fatalf("state %d: expected %s, got %s", state, want, got)
+		}
+	}
+	if engine.RemoveRule(Rule{Chain: "FORWARD", Action: ActionAccept}) {
+		t.Fatalf("expected ct_state to be part of rule identity")
+	}
+}
+
+func TestFirewallPortRangesListsAndNegation(t *testing.T) {
+	srcAddrs, _ := network.ParseAddrMatch("!10.0.0.0/8,192.168.0.0/16")
+	dstPorts, _ := network.ParsePortMatch("80,443,8000-8080")
+	_, legacy, _ := net.ParseCIDR("172.16.0.0/12")
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", SrcAddrs: srcAddrs, DstPorts: dstPorts},
+		{Chain: "INPUT", Action: ActionAccept, SrcNet: legacy, DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+
+	cases := []struct {
+		src  string
+		port int
+		want Action
+	}{
+		{"8.8.8.8", 443, ActionAccept},
+		{"8.8.8.8", 8042, ActionAccept},
+		{"8.8.8.8", 8081, ActionDrop},
+		{"10.1.2.3", 80, ActionDrop},
+		{"192.168.1.1", 80, ActionDrop},
+		{"172.16.0.1", 22, ActionAccept},
+	}
+	for _, tc := range cases {
+		pkt := network.Packet{Metadata: network.PacketMetadata{Protocol: "TCP", SrcIP: net.ParseIP(tc.src), DstPort: tc.port}}
+		if got := engine.Evaluate("INPUT", pkt); got != tc.want {
+			t.Fatalf("%s:%d: expected %s, got %s", tc.src, tc.port, tc.want, got)
+		}
+	}
+
+	rules := engine.Rules()
+	if rules[0].SrcNet != nil || rules[0].DstPort != 0 || rules[1].SrcAddrs.String() != "172.16.0.0/12" || rules[1].DstPorts.String() != "22" {
+		t.Fatalf("unexpected normalized rules: %+v", rules)
+	}
+	if !engine.RemoveRule(Rule{Chain: "INPUT", Action: ActionAccept, SrcAddrs: network.NetMatch(legacy), DstPorts: network.SinglePort(22)}) {
+		t.Fatalf("expected single value and matcher forms to be the same rule")
+	}
+}
+
+func TestFirewallIPSets(t *testing.T) {
+	sets := ipset.NewRegistry()
+	_ = sets.Create("blocklist", ipset.TypeHashNet, 0)
+	_ = sets.Create("admins", ipset.TypeHashIP, 0)
+	block, _ := ipset.ParseMember(ipset.TypeHashNet, "198.51.100.0/24")
+	admin, _ := ipset.ParseMember(ipset.TypeHashIP, "192.168.1.10")
+	_ = sets.Add("blocklist", []ipset.Entry{{Prefix: block}})
+	_ = sets.Add("admins", []ipset.Entry{{Prefix: admin}})
+
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionDrop, SrcSet: "blocklist"},
+		{Chain: "INPUT", Action: ActionReject, Protocol: "TCP", DstPort: 22, SrcSet: "! admins"},
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionAccept})
+	engine.SetIPSets(sets)
+
+	cases := []struct {
+		src  string
+		port int
+		want Action
+	}{
+		{"198.51.100.7", 80, ActionDrop},
+		{"192.168.1.10", 22, ActionAccept},
+		{"192.168.1.11", 22, ActionReject},
+		{"203.0.113.1", 80, ActionAccept},
+	}
+	for _, tc := range cases {
+		pkt := network.Packet{Metadata: network.PacketMetadata{Protocol: "TCP", SrcIP: net.ParseIP(tc.src), DstPort: tc.port}}
+		if got := engine.Evaluate("INPUT", pkt); got != tc.want {
+			t.Fatalf("%s:%d: expected %s, got %s", tc.src, tc.port, tc.want, got)
+		}
+	}
+
+	if _, err := sets.Remove("blocklist", []*net.IPNet{block}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	pkt := network.Packet{Metadata: network.PacketMetadata{Protocol: "TCP", SrcIP: net.ParseIP("198.51.100.7"), DstPort: 80}}
+	if got := engine.Evaluate("INPUT", pkt); got != ActionAccept {
+		t.Fatalf("expected membership change to apply without reloading rules, got %s", got)
+	}
+	if engine.Rules()[1].SrcSet != "!admins" {
+		t.Fatalf("expected normalized set reference, got %q", engine.Rules()[1].SrcSet)
+	}
+}
+
+func TestFirewallSchedules(t *testing.T) {
+	school, _ := schedule.ParseRange("08:00-15:00")
+	night, _ := schedule.ParseRange("22:00-07:00")
+	weekdays, _ := schedule.ParseDays([]string{"weekdays"})
+	schedules := schedule.NewRegistry([]schedule.Schedule{
+		{Name: "school", Days: weekdays, Ranges: []schedule.Range{school}},
+		{Name: "bedtime", Ranges: []schedule.Range{night}},
+	}, time.UTC)
+	// 2026-10-19 is a Monday.
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	schedules.SetClock(func() time.Time { return now })
+
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "FORWARD", Action: ActionDrop, InInterface: "wlan1", Protocol: "TCP", DstPort: 443, Schedule: "school"},
+		{Chain: "FORWARD", Action: ActionReject, InInterface: "wlan1", Schedule: "bedtime"},
+		{Chain: "FORWARD", Action: ActionDrop, Schedule: "missing"},
+	}, map[string]Action{"FORWARD": ActionAccept})
+	engine.SetSchedules(schedules)
+	pkt := network.Packet{IngressInterface: "wlan1", Metadata: network.PacketMetadata{Protocol: "TCP", DstPort: 443}}
+
+	cases := []struct {
+		at   time.Time
+		want Action
+	}{
+		{time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC), ActionDrop},
+		{time.Date(2026, 10, 19, 16, 0, 0, 0, time.UTC), ActionAccept},
+		{time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC), ActionReject},
+		{time.Date(2026, 10, 24, 10, 0, 0, 0, time.UTC), ActionAccept},
+	}
+	for _, tc := range cases {
+		now = tc.at
+		if got := engine.Evaluate("FORWARD", pkt); got != tc.want {
+			t.Fatalf("%s: expected %s, got %s", tc.at, tc.want, got)
+		}
+	}
+
+	now = time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	stats := engine.RulesWithStats()
+	if !stats[0].Active || stats[1].Active || stats[2].Active {
+		t.Fatalf("unexpected active flags: %+v", stats)
+	}
+	if engine.RemoveRule(Rule{Chain: "FORWARD", Action: ActionDrop, InInterface: "wlan1", Protocol: "TCP", DstPort: 443}) {
+		t.Fatalf("expected schedule to be part of rule identity")
+	}
+}
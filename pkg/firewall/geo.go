@@ -0,0 +1,43 @@
+package firewall
+
+import "router-go/pkg/network"
+
+// SetGeo attaches the resolver SrcCountry, DstCountry and SrcASN matches
+// consult. Without one those rules never match.
+func (e *Engine) SetGeo(geo network.GeoResolver) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.geo = geo
+	e.publish()
+}
+
+func (rule *Rule) geoMatched() bool {
+	return !rule.SrcCountry.IsZero() || !rule.DstCountry.IsZero() || !rule.SrcASN.IsZero()
+}
+
+// matchesGeo runs after the cheaper matches so only packets that otherwise
+// hit the rule pay for a lookup.
+func (rule *Rule) matchesGeo(geo network.GeoResolver, pkt network.Packet) bool {
+	if geo == nil {
+		return false
+	}
+	if !rule.SrcCountry.IsZero() || !rule.SrcASN.IsZero() {
+		if pkt.Metadata.SrcIP == nil {
+			return false
+		}
+		country, asn := geo.Geo(pkt.Metadata.SrcIP)
+		if !rule.SrcCountry.Matches(country) || !rule.SrcASN.Matches(asn) {
+			return false
+		}
+	}
+	if !rule.DstCountry.IsZero() {
+		if pkt.Metadata.DstIP == nil {
+			return false
+		}
+		country, _ := geo.Geo(pkt.Metadata.DstIP)
+		if !rule.DstCountry.Matches(country) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+type fakeGeo map[string]struct {
+	country string
+	asn     uint32
+}
+
+func (g fakeGeo) Geo(ip net.IP) (string, uint32) {
+	rec := g[ip.String()]
+	return rec.country, rec.asn
+}
+
+func TestFirewallGeoMatch(t *testing.T) {
+	countries, _ := network.ParseCountryMatch("CN,RU")
+	asns, _ := network.ParseASNMatch("AS64500")
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: countries},
+		{Chain: "INPUT", Action: ActionReject, Protocol: "TCP", DstPort: 22, SrcASN: asns},
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTNew)); got != ActionAccept {
+		t.Fatalf("expected geo rules not to match without a resolver, got %s", got)
+	}
+
+	engine.SetGeo(fakeGeo{
+		"203.0.113.5":  {country: "CN", asn: 64501},
+		"203.0.113.6":  {country: "DE", asn: 64500},
+		"198.51.100.7": {country: "DE", asn: 64501},
+	})
+	for src, want := range map[string]Action{
+		"203.0.113.5":  ActionDrop,
+		"203.0.113.6":  ActionReject,
+		"198.51.100.7": ActionAccept,
+		"10.0.0.1":     ActionAccept,
+	} {
+		if got := engine.Evaluate("INPUT", sshPacket(src, network.CTNew)); got != want {
+			t.Fatalf("%s: expected %s, got %s", src, want, got)
+		}
+	}
+
+	findings := AnalyzeRules([]Rule{
+		{ID: 1, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: countries},
+		{ID: 2, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: countries},
+		{ID: 3, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, nil)
+	if len(findings) != 1 || findings[0].Kind != FindingRedundant || findings[0].Rule != 2 {
+		t.Fatalf("unexpected findings: %v", findings)
+	}
+}
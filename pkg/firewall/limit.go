@@ -0,0 +1,141 @@
+package firewall
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"router-go/pkg/network"
+	"router-go/pkg/qos"
+)
+
+// MaxLimitSources bounds how many source keys a rate limited rule tracks.
+// When the table is full, sources whose bucket has refilled are forgotten
+// first, then arbitrary ones.
+const MaxLimitSources = 65536
+
+// limitTokenScale counts packets in thousandths, so slow rates refill
+// between closely spaced packets despite the bucket's integer tokens.
+const limitTokenScale = 1000
+
+// ConnCounter returns the number of tracked connections opened from src, or
+// from its network when bits is shorter than the address.
+type ConnCounter func(src net.IP, bits int) int
+
+// SetConnCounter attaches the connection tracker ConnLimit rules count
+// against. Without one no source holds any connection.
+func (e *Engine) SetConnCounter(counter ConnCounter) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.conns = counter
+	e.publish()
+}
+
+// rateLimiter keeps a token bucket per source key for a RateLimit rule.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    int64
+	burst   int64
+	bits    int
+	idle    time.Duration
+	buckets map[[16]byte]*sourceBucket
+}
+
+type sourceBucket struct {
+	bucket *qos.TokenBucket
+	seen   time.Time
+}
+
+func newRateLimiter(rate int, burst int, bits int) *rateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &rateLimiter{
+		rate:    int64(rate),
+		burst:   int64(burst),
+		bits:    bits,
+		idle:    time.Duration(float64(burst) / float64(rate) * float64(time.Second)),
+		buckets: map[[16]byte]*sourceBucket{},
+	}
+}
+
+// exceeded takes a packet from the source's bucket and reports whether the
+// source is over its rate.
+func (l *rateLimiter) exceeded(src net.IP, now time.Time) bool {
+	key := sourceKey(src, l.bits)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[key]
+	if b == nil {
+		if len(l.buckets) >= MaxLimitSources {
+			l.evict(now)
+		}
+		b = &sourceBucket{bucket: qos.NewTokenBucket(l.rate*limitTokenScale, l.burst*limitTokenScale)}
+		l.buckets[key] = b
+	}
+	b.seen = now
+	return !b.bucket.Allow(limitTokenScale)
+}
+
+func (l *rateLimiter) evict(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.seen) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+	for key := range l.buckets {
+		if len(l.buckets) < MaxLimitSources {
+			break
+		}
+		delete(l.buckets, key)
+	}
+}
+
+// sourceKey masks src to its first bits bits; bits of zero or at least the
+// address length keep the whole address.
+func sourceKey(src net.IP, bits int) [16]byte {
+	var key [16]byte
+	copy(key[:], src.To16())
+	full := 128
+	if src.To4() != nil {
+		full = 32
+	}
+	if bits <= 0 || bits >= full {
+		return key
+	}
+	mask := net.CIDRMask(bits+128-full, 128)
+	for i := range key {
+		key[i] &= mask[i]
+	}
+	return key
+}
+
+func (rule *Rule) limited() bool {
+	return rule.RateLimit > 0 || rule.ConnLimit > 0
+}
+
+// overLimits checks the rate and connection limits of a rule whose other
+// matches passed; with both set the source must exceed both. A new
+// connection counts towards its own source's limit.
+func (rule *Rule) overLimits(conns ConnCounter, pkt network.Packet) bool {
+	src := pkt.Metadata.SrcIP
+	if src == nil {
+		return false
+	}
+	if rule.ConnLimit > 0 {
+		n := 0
+		if conns != nil {
+			n = conns(src, rule.LimitPrefix)
+		}
+		if pkt.CTState&network.CTNew != 0 {
+			n++
+		}
+		if n <= rule.ConnLimit {
+			return false
+		}
+	}
+	if rule.rateLimit != nil && !rule.rateLimit.exceeded(src, time.Now()) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+func sshPacket(src string, state network.CTState) network.Packet {
+	return network.Packet{
+		CTState: state,
+		Metadata: network.PacketMetadata{
+			Protocol: "TCP", SrcIP: net.ParseIP(src), DstIP: net.ParseIP("192.0.2.1"), SrcPort: 40000, DstPort: 22,
+		},
+	}
+}
+
+func TestFirewallRateLimit(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22, RateLimit: 1, RateBurst: 3, LimitPrefix: 24},
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+
+	for i := 0; i < 3; i++ {
+		if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTNew)); got != ActionAccept {
+			t.Fatalf("packet %d: expected accept within burst, got %s", i, got)
+		}
+	}
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.6", network.CTNew)); got != ActionDrop {
+		t.Fatalf("expected the /24 to be over its rate, got %s", got)
+	}
+	if got := engine.Evaluate("INPUT", sshPacket("198.51.100.5", network.CTNew)); got != ActionAccept {
+		t.Fatalf("expected another source to have its own bucket, got %s", got)
+	}
+	stats := engine.RulesWithStats()
+	if stats[0].Hits != 1 || stats[1].Hits != 4 {
+		t.Fatalf("unexpected hits: %d %d", stats[0].Hits, stats[1].Hits)
+	}
+}
+
+func TestFirewallConnLimit(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionReject, Protocol: "TCP", DstPort: 22, CTState: network.CTNew, ConnLimit: 2},
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTNew)); got != ActionAccept {
+		t.Fatalf("expected accept without a connection tracker, got %s", got)
+	}
+
+	counts := map[string]int{"203.0.113.5": 2, "203.0.113.6": 1}
+	var prefix int
+	engine.SetConnCounter(func(src net.IP, bits int) int {
+		prefix = bits
+		return counts[src.String()]
+	})
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTNew)); got != ActionReject {
+		t.Fatalf("expected a third connection to be rejected, got %s", got)
+	}
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.6", network.CTNew)); got != ActionAccept {
+		t.Fatalf("expected a second connection to be accepted, got %s", got)
+	}
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTEstablished)); got != ActionAccept {
+		t.Fatalf("expected established traffic to pass, got %s", got)
+	}
+	if prefix != 0 {
+		t.Fatalf("expected whole address keys, got /%d", prefix)
+	}
+}
+
+func TestFirewallRateAndConnLimit(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22, RateLimit: 1, RateBurst: 1, ConnLimit: 2},
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+	counts := map[string]int{"203.0.113.5": 5}
+	engine.SetConnCounter(func(src net.IP, bits int) int {
+		return counts[src.String()]
+	})
+
+	for i := 0; i < 3; i++ {
+		if got := engine.Evaluate("INPUT", sshPacket("198.51.100.5", network.CTEstablished)); got != ActionAccept {
+			t.Fatalf("packet %d: expected a source under its connection limit to pass at any rate, got %s", i, got)
+		}
+	}
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTEstablished)); got != ActionAccept {
+		t.Fatalf("expected a source over its connection limit to pass within its rate, got %s", got)
+	}
+	if got := engine.Evaluate("INPUT", sshPacket("203.0.113.5", network.CTEstablished)); got != ActionDrop {
+		t.Fatalf("expected a source over both limits to be dropped, got %s", got)
+	}
+	counts["198.51.100.5"] = 5
+	if got := engine.Evaluate("INPUT", sshPacket("198.51.100.5", network.CTEstablished)); got != ActionAccept {
+		t.Fatalf("expected packets under the connection limit not to have used up the rate, got %s", got)
+	}
+}
+
+func TestRateLimiterBounded(t *testing.T) {
+	limiter := newRateLimiter(1, 1, 0)
+	ip := make(net.IP, 4)
+	for i := 0; i < MaxLimitSources+10; i++ {
+		ip[0], ip[1], ip[2], ip[3] = 10, byte(i>>16), byte(i>>8), byte(i)
+		limiter.exceeded(ip, time.Unix(1000, 0))
+	}
+	if n := len(limiter.buckets); n > MaxLimitSources {
+		t.Fatalf("expected at most %d sources, got %d", MaxLimitSources, n)
+	}
+}
@@ -0,0 +1,95 @@
+package firewall
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+// DefaultLogRate is the per-rule limit, in records per second, for rules
+// that log without their own LogRate.
+const DefaultLogRate = 10
+
+// LogRecord describes a packet matched by a LOG rule or a rule with Log set;
+// Rule is the rule ID. Verdict is the final action for the packet, so a LOG
+// rule reports what the rest of the chain decided. Suppressed counts the
+// records the rule's rate limit dropped since its previous record.
+type LogRecord struct {
+	Rule         uint64
+	Chain        string
+	Verdict      Action
+	Protocol     string
+	SrcIP        net.IP
+	DstIP        net.IP
+	SrcPort      int
+	DstPort      int
+	InInterface  string
+	OutInterface string
+	Suppressed   uint64
+}
+
+// SetLogSink sets the function logged packets are passed to. It is called
+// outside the engine lock, once per record.
+func (e *Engine) SetLogSink(sink func(LogRecord)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.logSink = sink
+	e.publish()
+}
+
+// logLimiter is a token bucket refilled at the rule's log rate with a burst
+// of one second worth of records.
+type logLimiter struct {
+	mu         sync.Mutex
+	rate       float64
+	tokens     float64
+	last       time.Time
+	suppressed uint64
+}
+
+func newLogLimiter(rate int) *logLimiter {
+	if rate <= 0 {
+		rate = DefaultLogRate
+	}
+	return &logLimiter{rate: float64(rate), tokens: float64(rate)}
+}
+
+// allow takes a token; when none is left the record is counted as
+// suppressed. It returns the suppressed count to report with the record.
+func (l *logLimiter) allow(now time.Time) (bool, uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens < 1 {
+		l.suppressed++
+		return false, 0
+	}
+	l.tokens--
+	suppressed := l.suppressed
+	l.suppressed = 0
+	return true, suppressed
+}
+
+func (rule *Rule) logs() bool {
+	return rule.Log || rule.Action == ActionLog
+}
+
+func logRecord(id uint64, chain string, pkt network.Packet, suppressed uint64) LogRecord {
+	return LogRecord{
+		Rule:         id,
+		Chain:        chain,
+		Protocol:     pkt.Metadata.Protocol,
+		SrcIP:        pkt.Metadata.SrcIP,
+		DstIP:        pkt.Metadata.DstIP,
+		SrcPort:      pkt.Metadata.SrcPort,
+		DstPort:      pkt.Metadata.DstPort,
+		InInterface:  pkt.IngressInterface,
+		OutInterface: pkt.EgressInterface,
+		Suppressed:   suppressed,
+	}
+}
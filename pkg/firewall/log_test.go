@@ -0,0 +1,72 @@
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+func TestFirewallLogRules(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{Chain: "INPUT", Action: ActionLog, Protocol: "TCP", DstPort: 22, LogRate: 2},
+		{Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22, SrcAddrs: mustAddrs(t, "198.51.100.0/24"), Log: true},
+		{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+	var records []LogRecord
+	engine.SetLogSink(func(record LogRecord) { records = append(records, record) })
+
+	pkt := func(src string) network.Packet {
+		return network.Packet{
+			IngressInterface: "eth0",
+			Metadata: network.PacketMetadata{
+				Protocol: "TCP", SrcIP: net.ParseIP(src), DstIP: net.ParseIP("192.0.2.1"), SrcPort: 40000, DstPort: 22,
+			},
+		}
+	}
+	if got := engine.Evaluate("input", pkt("203.0.113.5")); got != ActionAccept {
+		t.Fatalf("expected LOG to fall through to accept, got %s", got)
+	}
+	if got := engine.Evaluate("INPUT", pkt("198.51.100.7")); got != ActionDrop {
+		t.Fatalf("expected drop, got %s", got)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected three records, got %+v", records)
+	}
+	first := records[0]
+	if first.Rule != 1 || first.Chain != "INPUT" || first.Verdict != ActionAccept || first.InInterface != "eth0" ||
+		first.SrcIP.String() != "203.0.113.5" || first.DstPort != 22 || first.Protocol != "TCP" {
+		t.Fatalf("unexpected record: %+v", first)
+	}
+	if records[1].Rule != 1 || records[1].Verdict != ActionDrop || records[2].Rule != 2 || records[2].Verdict != ActionDrop {
+		t.Fatalf("expected both logging rules to report the final verdict: %+v", records[1:])
+	}
+
+	// LogRate 2 allows a burst of two records; the rest are counted and
+	// reported once tokens are back.
+	for i := 0; i < 5; i++ {
+		engine.Evaluate("INPUT", pkt("203.0.113.5"))
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected rate limit to suppress records, got %d", len(records))
+	}
+	engine.mu.Lock()
+	engine.rules[0].logLimit.tokens = 1
+	engine.mu.Unlock()
+	engine.Evaluate("INPUT", pkt("203.0.113.5"))
+	if len(records) != 4 || records[3].Suppressed != 5 {
+		t.Fatalf("expected record with suppressed count, got %+v", records[3:])
+	}
+	if stats := engine.RulesWithStats(); stats[0].Hits != 8 {
+		t.Fatalf("expected LOG rule to count hits, got %d", stats[0].Hits)
+	}
+}
+
+func mustAddrs(t *testing.T, value string) network.AddrMatch {
+	t.Helper()
+	m, err := network.ParseAddrMatch(value)
+	if err != nil {
+		t.Fatalf("parse %q: %v", value, err)
+	}
+	return m
+}
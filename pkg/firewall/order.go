@@ -0,0 +1,165 @@
+package firewall
+
+// Placement says where InsertRule and MoveRule put a rule: before or after
+// the rule with the given ID, or at a 1-based Position. The zero value
+// appends.
+type Placement struct {
+	Before   uint64
+	After    uint64
+	Position int
+}
+
+// InsertRule adds the rule at the given placement and returns its ID. It
+// fails when the placement refers to an unknown rule or position.
+func (e *Engine) InsertRule(rule Rule, at Placement) (uint64, bool) {
+	e.mu.Lock()
+	index, ok := e.placementIndex(at)
+	if ok {
+		rule = normalizeRule(rule)
+		rule.ID = e.claimID(rule.ID)
+		e.rules = insertAt(e.rules, index, rule)
+		e.counters = insertAt(e.counters, index, &hitCounter{})
+		e.publish()
+	}
+	e.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	e.notifyChange()
+	return rule.ID, true
+}
+
+// MoveRule moves the rule with the given ID, with its hit counter, to a new
+// placement. Placing a rule relative to itself fails.
+func (e *Engine) MoveRule(id uint64, at Placement) bool {
+	e.mu.Lock()
+	ok := e.moveLocked(id, at)
+	e.publish()
+	e.mu.Unlock()
+	if ok {
+		e.notifyChange()
+	}
+	return ok
+}
+
+func (e *Engine) moveLocked(id uint64, at Placement) bool {
+	from := e.indexOf(id)
+	if from < 0 || at.Before == id || at.After == id {
+		return false
+	}
+	rule, counter := e.rules[from], e.counters[from]
+	e.rules = append(e.rules[:from], e.rules[from+1:]...)
+	e.counters = append(e.counters[:from], e.counters[from+1:]...)
+	to, ok := e.placementIndex(at)
+	if !ok {
+		to = from
+	}
+	e.rules = insertAt(e.rules, to, rule)
+	e.counters = insertAt(e.counters, to, counter)
+	return ok
+}
+
+// SetRuleEnabled enables or disables the rule with the given ID.
+func (e *Engine) SetRuleEnabled(id uint64, enabled bool) bool {
+	e.mu.Lock()
+	i := e.indexOf(id)
+	if i >= 0 {
+		e.rules[i].Disabled = !enabled
+		e.publish()
+	}
+	e.mu.Unlock()
+	if i < 0 {
+		return false
+	}
+	e.notifyChange()
+	return true
+}
+
+// RuleByID returns the rule with the given ID and its 1-based position.
+func (e *Engine) RuleByID(id uint64) (Rule, int, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	i := e.indexOf(id)
+	if i < 0 {
+		return Rule{}, 0, false
+	}
+	return e.rules[i], i + 1, true
+}
+
+func (e *Engine) indexOf(id uint64) int {
+	if id == 0 {
+		return -1
+	}
+	for i := range e.rules {
+		if e.rules[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// find returns the index of the rule with match's ID, or of the first rule
+// equal to match when it has none.
+func (e *Engine) find(match Rule) int {
+	if match.ID != 0 {
+		return e.indexOf(match.ID)
+	}
+	match = normalizeRule(match)
+	for i, rule := range e.rules {
+		if rulesEqual(rule, match) {
+			return i
+		}
+	}
+	return -1
+}
+
+// placementIndex resolves a placement to an index in e.rules.
+func (e *Engine) placementIndex(at Placement) (int, bool) {
+	switch {
+	case at.Before != 0:
+		i := e.indexOf(at.Before)
+		return i, i >= 0
+	case at.After != 0:
+		i := e.indexOf(at.After)
+		return i + 1, i >= 0
+	case at.Position != 0:
+		return at.Position - 1, at.Position > 0 && at.Position <= len(e.rules)+1
+	default:
+		return len(e.rules), true
+	}
+}
+
+// claimID returns id when it is set and free, the next unused ID otherwise.
+func (e *Engine) claimID(id uint64) uint64 {
+	if id == 0 || e.indexOf(id) >= 0 {
+		id = max(e.nextID, 1)
+	}
+	e.nextID = max(e.nextID, id+1)
+	return id
+}
+
+// assignIDs gives rules loaded without an ID, or with one used by an earlier
+// rule, a fresh ID; configured IDs are kept.
+func (e *Engine) assignIDs() {
+	for _, rule := range e.rules {
+		e.nextID = max(e.nextID, rule.ID+1)
+	}
+	seen := make(map[uint64]bool, len(e.rules))
+	for i := range e.rules {
+		id := e.rules[i].ID
+		if id == 0 || seen[id] {
+			id = max(e.nextID, 1)
+			e.nextID = id + 1
+			e.rules[i].ID = id
+		}
+		seen[id] = true
+	}
+}
+
+func insertAt[T any](list []T, index int, value T) []T {
+	var zero T
+	list = append(list, zero)
+	copy(list[index+1:], list[index:])
+	list[index] = value
+	return list
+}
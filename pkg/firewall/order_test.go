@@ -0,0 +1,90 @@
+package firewall
+
+import (
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+func ruleIDs(engine *Engine) []uint64 {
+	var ids []uint64
+	for _, rule := range engine.Rules() {
+		ids = append(ids, rule.ID)
+	}
+	return ids
+}
+
+func sameIDs(got []uint64, want ...uint64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFirewallRuleIDsAndOrdering(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{ID: 10, Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 22},
+		{Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22},
+		{ID: 10, Chain: "INPUT", Action: ActionDrop, Protocol: "TCP", DstPort: 22},
+	}, map[string]Action{"INPUT": ActionDrop})
+	if ids := ruleIDs(engine); !sameIDs(ids, 10, 11, 12) {
+		t.Fatalf("expected missing and duplicate ids to be assigned, got %v", ids)
+	}
+
+	pkt := network.Packet{Metadata: network.PacketMetadata{Protocol: "TCP", DstPort: 22}}
+	engine.Evaluate("INPUT", pkt)
+
+	if _, ok := engine.InsertRule(Rule{Chain: "INPUT", Action: ActionReject}, Placement{Before: 99}); ok {
+		t.Fatalf("expected insert before unknown rule to fail")
+	}
+	id, ok := engine.InsertRule(Rule{Chain: "INPUT", Action: ActionReject, Protocol: "TCP", DstPort: 22, Description: " ssh "}, Placement{After: 10})
+	if !ok || id != 13 {
+		t.Fatalf("unexpected insert result %d %v", id, ok)
+	}
+	if id, _ := engine.InsertRule(Rule{Chain: "INPUT", Action: ActionAccept}, Placement{Position: 1}); id != 14 {
+		t.Fatalf("expected next id 14, got %d", id)
+	}
+	if ids := ruleIDs(engine); !sameIDs(ids, 14, 10, 13, 11, 12) {
+		t.Fatalf("unexpected order %v", ids)
+	}
+	if rule, pos, ok := engine.RuleByID(13); !ok || pos != 3 || rule.Description != "ssh" {
+		t.Fatalf("unexpected rule %+v at %d", rule, pos)
+	}
+
+	// Moving and updating keep the hit counter with the rule.
+	if engine.MoveRule(10, Placement{Before: 10}) || engine.MoveRule(10, Placement{Position: 9}) {
+		t.Fatalf("expected invalid moves to fail")
+	}
+	if !engine.MoveRule(10, Placement{Position: 5}) {
+		t.Fatalf("expected move to succeed")
+	}
+	if !engine.UpdateRule(Rule{ID: 10}, Rule{Chain: "INPUT", Action: ActionAccept, Protocol: "TCP", DstPort: 2222}) {
+		t.Fatalf("expected update by id to succeed")
+	}
+	stats := engine.RulesWithStats()
+	if last := stats[4]; last.Rule.ID != 10 || last.Rule.DstPort != 2222 || last.Hits != 1 {
+		t.Fatalf("expected rule 10 last with its hits, got %+v", last)
+	}
+
+	// Disabled rules are skipped; removing by id leaves equal rules alone.
+	if !engine.SetRuleEnabled(14, false) || !engine.SetRuleEnabled(13, false) {
+		t.Fatalf("expected disable to succeed")
+	}
+	if got := engine.Evaluate("INPUT", pkt); got != ActionDrop {
+		t.Fatalf("expected disabled rules to be skipped, got %s", got)
+	}
+	if stats := engine.RulesWithStats(); stats[0].Active || stats[0].Hits != 0 {
+		t.Fatalf("expected disabled rule to be inactive, got %+v", stats[0])
+	}
+	if !engine.RemoveRule(Rule{ID: 12}) {
+		t.Fatalf("expected remove by id to succeed")
+	}
+	if ids := ruleIDs(engine); !sameIDs(ids, 14, 13, 11, 10) {
+		t.Fatalf("expected only rule 12 removed, got %v", ids)
+	}
+}
@@ -0,0 +1,98 @@
+package firewall
+
+import "strings"
+
+// Zone groups interfaces under one policy. Interface names ending in "*"
+// match by prefix, so "eth0.*" covers VLAN subinterfaces and "wg*" tunnels.
+// Input, Output and Forward are the zone defaults for traffic to the router,
+// from the router and forwarded out of the zone; empty leaves the decision to
+// the chain default.
+type Zone struct {
+	Name       string
+	Interfaces []string
+	Input      Action
+	Output     Action
+	Forward    Action
+}
+
+// ZonePolicy is the default action for traffic forwarded from one zone to
+// another. It takes precedence over the Forward default of the source zone.
+type ZonePolicy struct {
+	From   string
+	To     string
+	Action Action
+}
+
+// zoneSet is the compiled form of the zone configuration: an interface to
+// zone index and the zone defaults expanded into chain rules.
+type zoneSet struct {
+	zones    []Zone
+	policies []ZonePolicy
+	exact    map[string]string
+	prefixes []zonePrefix
+	defaults []Rule
+}
+
+type zonePrefix struct {
+	prefix string
+	zone   string
+}
+
+func compileZones(zones []Zone, policies []ZonePolicy) zoneSet {
+	set := zoneSet{
+		zones:    append([]Zone(nil), zones...),
+		policies: append([]ZonePolicy(nil), policies...),
+		exact:    map[string]string{},
+	}
+	for _, zone := range zones {
+		name := strings.ToLower(zone.Name)
+		for _, iface := range zone.Interfaces {
+			if prefix, ok := strings.CutSuffix(iface, "*"); ok {
+				set.prefixes = append(set.prefixes, zonePrefix{prefix: prefix, zone: name})
+				continue
+			}
+			set.exact[iface] = name
+		}
+	}
+	set.defaults = normalizeRules(ZoneDefaultRules(zones, policies))
+	return set
+}
+
+func (z zoneSet) zoneOf(iface string) string {
+	if iface == "" {
+		return ""
+	}
+	if zone, ok := z.exact[iface]; ok {
+		return zone
+	}
+	for _, p := range z.prefixes {
+		if strings.HasPrefix(iface, p.prefix) {
+			return p.zone
+		}
+	}
+	return ""
+}
+
+// ZoneDefaultRules expands zone defaults into rules in the order they are
+// evaluated: input and output defaults, zone pair policies, then the forward
+// default of the source zone.
+func ZoneDefaultRules(zones []Zone, policies []ZonePolicy) []Rule {
+	var rules []Rule
+	for _, zone := range zones {
+		if zone.Input != "" {
+			rules = append(rules, Rule{Chain: "INPUT", Action: zone.Input, FromZone: zone.Name})
+		}
+		if zone.Output != "" {
+			rules = append(rules, Rule{Chain: "OUTPUT", Action: zone.Output, ToZone: zone.Name})
+		}
+	}
+	for _, policy := range policies {
+		rules = append(rules, Rule{Chain: "FORWARD", Action: policy.Action, FromZone: policy.From, ToZone: policy.To})
+	}
+	for _, zone := range zones {
+		if zone.Forward != "" {
+			rules = append(rules, Rule{Chain: "FORWARD", Action: zone.Forward, FromZone: zone.Name})
+		}
+	}
+	return rules
+}
@@ -0,0 +1,62 @@
+package firewall
+
+import (
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+func TestFirewallZones(t *testing.T) {
+	engine := NewEngineWithDefaults([]Rule{
+		{FromZone: "guest", ToZone: "lan", Action: ActionReject},
+		{Chain: "INPUT", FromZone: "wan", Action: ActionAccept, Protocol: "UDP", DstPort: 51820},
+	}, map[string]Action{"INPUT": ActionDrop, "OUTPUT": ActionAccept, "FORWARD": ActionDrop})
+	engine.SetZones([]Zone{
+		{Name: "LAN", Interfaces: []string{"eth1", "eth1.*"}, Input: ActionAccept, Forward: ActionAccept},
+		{Name: "guest", Interfaces: []string{"wlan1"}, Input: ActionDrop},
+		{Name: "wan", Interfaces: []string{"eth0", "wg*"}, Output: ActionDrop},
+	}, []ZonePolicy{{From: "guest", To: "wan", Action: ActionAccept}})
+
+	cases := []struct {
+		chain   string
+		in, out string
+		port    int
+		want    Action
+	}{
+		{"FORWARD", "wlan1", "eth1.20", 0, ActionReject},
+		{"FORWARD", "wlan1", "eth0", 0, ActionAccept},
+		{"FORWARD", "eth1.20", "wg0", 0, ActionAccept},
+		{"FORWARD", "eth0", "eth1", 0, ActionDrop},
+		{"FORWARD", "eth9", "eth0", 0, ActionDrop},
+		{"INPUT", "eth1", "", 0, ActionAccept},
+		{"INPUT", "wlan1", "", 0, ActionDrop},
+		{"INPUT", "eth0", "", 51820, ActionAccept},
+		{"INPUT", "eth0", "", 53, ActionDrop},
+		{"OUTPUT", "", "wg1", 0, ActionDrop},
+		{"OUTPUT", "", "eth1", 0, ActionAccept},
+	}
+	for _, tc := range cases {
+		pkt := network.Packet{
+			IngressInterface: tc.in,
+			EgressInterface:  tc.out,
+			Metadata:         network.PacketMetadata{Protocol: "UDP", DstPort: tc.port},
+		}
+		if got := engine.Evaluate(tc.chain, pkt); got != tc.want {
+			t.Fatalf("%s %s->%s:%d: expected %s, got %s", tc.chain, tc.in, tc.out, tc.port, tc.want, got)
+		}
+	}
+
+	if engine.ZoneOf("eth1.100") != "lan" || engine.ZoneOf("eth2") != "" {
+		t.Fatalf("unexpected zone lookup")
+	}
+	rules := engine.Rules()
+	if rules[0].Chain != "FORWARD" {
+		t.Fatalf("expected zone pair rule to default to FORWARD, got %q", rules[0].Chain)
+	}
+	if !engine.RemoveRule(Rule{FromZone: "Guest", ToZone: "LAN", Action: ActionReject}) {
+		t.Fatalf("expected zone names to be case-insensitive in rule identity")
+	}
+	if stats := engine.RulesWithStats(); len(stats) != 1 || stats[0].Hits != 1 {
+		t.Fatalf("expected zone defaults not to count as rule hits: %+v", stats)
+	}
+}
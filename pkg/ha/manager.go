@@ -23,6 +23,7 @@ type Manager struct {
 	statePath   string
 	stateEvery  time.Duration
 	lastSeen    map[string]PeerStatus
+	bfdDown     map[string]struct{}
 	stateProvider func() State
 	stateApplier  func(State)
 	httpClient  *http.Client
@@ -48,6 +49,7 @@ func NewManager(nodeID string, priority int, interval time.Duration, hold time.D
 		statePath:    statePath,
 		stateEvery:   stateEvery,
 		lastSeen:     map[string]PeerStatus{},
+		bfdDown:      map[string]struct{}{},
 		stateProvider: provider,
 		stateApplier:  applier,
 		httpClient:   &http.Client{Timeout: 3 * time.Second},
@@ -92,6 +94,7 @@ func (m *Manager) Status() map[string]any {
 	defer m.mu.Unlock()
 	peers := make([]PeerStatus, 0, len(m.lastSeen))
 	for _, p := range m.lastSeen {
+		_, p.BFDDown = m.bfdDown[p.Address]
 		peers = append(peers, p)
 	}
 	return map[string]any{
@@ -108,11 +111,24 @@ func (m *Manager) ApplyState(state State) {
 	}
 }
 
+// SetBFDState stops counting heartbeats from addr while its BFD session is
+// down, so failover does not wait for hold_seconds.
+func (m *Manager) SetBFDState(addr net.IP, up bool) {
+	m.mu.Lock()
+	if up {
+		delete(m.bfdDown, addr.String())
+	} else {
+		m.bfdDown[addr.String()] = struct{}{}
+	}
+	m.mu.Unlock()
+	m.evaluateRole()
+}
+
 func (m *Manager) recvLoop(ctx context.Context, conn net.PacketConn) {
 	buf := make([]byte, 2048)
 	for {
 		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, _, err := conn.ReadFrom(buf)
+		n, from, err := conn.ReadFrom(buf)
 		if err != nil {
 			select {
 			case <-ctx.Done():
@@ -129,11 +145,15 @@ func (m *Manager) recvLoop(ctx context.Context, conn net.PacketConn) {
 			continue
 		}
 		m.mu.Lock()
-		m.lastSeen[hb.NodeID] = PeerStatus{
+		status := PeerStatus{
 			NodeID:   hb.NodeID,
 			Priority: hb.Priority,
 			LastSeen: hb.TS,
 		}
+		if udp, ok := from.(*net.UDPAddr); ok {
+			status.Address = udp.IP.String()
+		}
+		m.lastSeen[hb.NodeID] = status
 		m.mu.Unlock()
 	}
 }
@@ -174,10 +194,15 @@ func (m *Manager) electionLoop(ctx context.Context) {
 func (m *Manager) evaluateRole() {
 	now := time.Now().Unix()
 	active := true
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, peer := range m.lastSeen {
 		if now-int64(m.hold.Seconds()) > peer.LastSeen {
 			continue
 		}
+		if _, down := m.bfdDown[peer.Address]; down {
+			continue
+		}
 		if peer.Priority > m.priority {
 			active = false
 		}
@@ -185,13 +210,11 @@ func (m *Manager) evaluateRole() {
 			active = false
 		}
 	}
-	m.mu.Lock()
 	if active {
 		m.role = RoleActive
 	} else {
 		m.role = RoleStandby
 	}
-	m.mu.Unlock()
 }
 
 func (m *Manager) stateSyncLoop(ctx context.Context) {
@@ -1,6 +1,7 @@
 package ha
 
 import (
+	"net"
 	"net/http"
 	"testing"
 	"time"
@@ -69,6 +70,46 @@ func TestManagerEvaluateRole(t *testing.T) {
 	}
 }
 
+func TestManagerBFDDownTriggersFailover(t *testing.T) {
+	manager := NewManager(
+		"node-1",
+		100,
+		2*time.Second,
+		6*time.Second,
+		":5356",
+		"224.0.0.252:5356",
+		nil,
+		"/api/ha/state",
+		5*time.Second,
+		nil,
+		nil,
+	)
+	manager.lastSeen["node-2"] = PeerStatus{
+		NodeID:   "node-2",
+		Priority: 150,
+		LastSeen: time.Now().Unix(),
+		Address:  "10.0.0.2",
+	}
+	manager.evaluateRole()
+	if manager.Role() != RoleStandby {
+		t.Fatalf("expected standby while higher priority peer is alive")
+	}
+
+	manager.SetBFDState(net.ParseIP("10.0.0.2"), false)
+	if manager.Role() != RoleActive {
+		t.Fatalf("expected immediate takeover when bfd to the peer goes down")
+	}
+	peers := manager.Status()["peers"].([]PeerStatus)
+	if len(peers) != 1 || !peers[0].BFDDown {
+		t.Fatalf("expected peer marked bfd down, got %+v", peers)
+	}
+
+	manager.SetBFDState(net.ParseIP("10.0.0.2"), true)
+	if manager.Role() != RoleStandby {
+		t.Fatalf("expected standby once bfd to the peer is back")
+	}
+}
+
 func TestManagerStatus(t *testing.T) {
 	manager := NewManager(
 		"node-1",
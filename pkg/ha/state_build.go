@@ -3,13 +3,19 @@ package ha
 import (
 	"net"
 
+	"router-go/pkg/conntrack"
 	"router-go/pkg/firewall"
 	"router-go/pkg/nat"
+	"router-go/pkg/network"
 	"router-go/pkg/qos"
 	"router-go/pkg/routing"
 )
 
 func BuildState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager, routes *routing.Table) State {
+	return BuildStateWithPolicy(fw, natTable, qosQueue, routes, nil)
+}
+
+func BuildStateWithPolicy(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager, routes *routing.Table, policy *routing.Policy) State {
 	state := State{
 		FirewallDefaults: map[string]string{},
 	}
@@ -18,26 +24,55 @@ func BuildState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueMan
 	}
 	for _, rule := range fw.Rules() {
 		state.FirewallRules = append(state.FirewallRules, FirewallRule{
+			ID:           rule.ID,
+			Description:  rule.Description,
+			Disabled:     rule.Disabled,
 			Chain:        rule.Chain,
 			Action:       string(rule.Action),
+			Target:       rule.Target,
 			Protocol:     rule.Protocol,
-			SrcCIDR:      cidrFromNet(rule.SrcNet),
-			DstCIDR:      cidrFromNet(rule.DstNet),
+			SrcCIDR:      rule.SrcAddrs.String(),
+			DstCIDR:      rule.DstAddrs.String(),
 			SrcPort:      rule.SrcPort,
 			DstPort:      rule.DstPort,
+			SrcPorts:     portList(rule.SrcPorts),
+			DstPorts:     portList(rule.DstPorts),
+			SrcSet:       rule.SrcSet,
+			DstSet:       rule.DstSet,
+			Schedule:     rule.Schedule,
 			InInterface:  rule.InInterface,
 			OutInterface: rule.OutInterface,
+			FromZone:     rule.FromZone,
+			ToZone:       rule.ToZone,
+			CTState:      conntrack.FormatStates(rule.CTState),
+			Log:          rule.Log,
+			LogRate:      rule.LogRate,
+			RateLimit:    rule.RateLimit,
+			RateBurst:    rule.RateBurst,
+			ConnLimit:    rule.ConnLimit,
+			LimitPrefix:  rule.LimitPrefix,
+			SrcCountry:   rule.SrcCountry.String(),
+			DstCountry:   rule.DstCountry.String(),
+			SrcASN:       rule.SrcASN.String(),
 		})
 	}
 	for _, rule := range natTable.Rules() {
 		state.NATRules = append(state.NATRules, NATRule{
-			Type:    string(rule.Type),
-			SrcCIDR: cidrFromNet(rule.SrcNet),
-			DstCIDR: cidrFromNet(rule.DstNet),
-			SrcPort: rule.SrcPort,
-			DstPort: rule.DstPort,
-			ToIP:    rule.ToIP.String(),
-			ToPort:  rule.ToPort,
+			ID:          rule.ID,
+			Description: rule.Description,
+			Disabled:    rule.Disabled,
+			Type:        string(rule.Type),
+			SrcCIDR:     rule.SrcAddrs.String(),
+			DstCIDR:     rule.DstAddrs.String(),
+			SrcPort:     rule.SrcPort,
+			DstPort:     rule.DstPort,
+			SrcPorts:    portList(rule.SrcPorts),
+			DstPorts:    portList(rule.DstPorts),
+			SrcSet:      rule.SrcSet,
+			DstSet:      rule.DstSet,
+			Schedule:    rule.Schedule,
+			ToIP:        rule.ToIP.String(),
+			ToPort:      rule.ToPort,
 		})
 	}
 	for _, class := range qosQueue.Classes() {
@@ -46,6 +81,11 @@ func BuildState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueMan
 			Protocol:      class.Protocol,
 			SrcPort:       class.SrcPort,
 			DstPort:       class.DstPort,
+			SrcPorts:      portList(class.SrcPorts),
+			DstPorts:      portList(class.DstPorts),
+			SrcSet:        class.SrcSet,
+			DstSet:        class.DstSet,
+			Schedule:      class.Schedule,
 			RateLimitKbps: class.RateLimitKbps,
 			Priority:      class.Priority,
 			MaxQueue:      class.MaxQueue,
@@ -53,24 +93,76 @@ func BuildState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueMan
 		})
 	}
 	for _, route := range routes.Routes() {
+		if route.TargetVRF != "" || !route.Configured() {
+			continue
+		}
 		state.Routes = append(state.Routes, RouteFrom(route))
 	}
+	if policy == nil {
+		return state
+	}
+	for _, name := range policy.TableNames() {
+		if name == routing.MainTable {
+			continue
+		}
+		table, ok := policy.Table(name)
+		if !ok {
+			continue
+		}
+		entry := RoutingTable{Name: name}
+		for _, route := range table.Routes() {
+			if route.TargetVRF != "" || !route.Configured() {
+				continue
+			}
+			entry.Routes = append(entry.Routes, RouteFrom(route))
+		}
+		state.RoutingTables = append(state.RoutingTables, entry)
+	}
+	for _, rule := range policy.Rules() {
+		state.PolicyRules = append(state.PolicyRules, PolicyRuleFrom(rule))
+	}
 	return state
 }
 
 func ApplyState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager, routes *routing.Table, state State) {
+	ApplyStateWithPolicy(fw, natTable, qosQueue, routes, nil, state)
+}
+
+func ApplyStateWithPolicy(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueManager, routes *routing.Table, policy *routing.Policy, state State) {
 	firewallRules := make([]firewall.Rule, 0, len(state.FirewallRules))
 	for _, rule := range state.FirewallRules {
+		ctState, _ := conntrack.ParseStates(rule.CTState)
 		firewallRules = append(firewallRules, firewall.Rule{
+			ID:           rule.ID,
+			Description:  rule.Description,
+			Disabled:     rule.Disabled,
 			Chain:        rule.Chain,
 			Action:       firewall.Action(rule.Action),
+			Target:       rule.Target,
 			Protocol:     rule.Protocol,
-			SrcNet:       parseCIDR(rule.SrcCIDR),
-			DstNet:       parseCIDR(rule.DstCIDR),
+			SrcAddrs:     parseAddrs(rule.SrcCIDR),
+			DstAddrs:     parseAddrs(rule.DstCIDR),
 			SrcPort:      rule.SrcPort,
 			DstPort:      rule.DstPort,
+			SrcPorts:     parsePorts(rule.SrcPorts),
+			DstPorts:     parsePorts(rule.DstPorts),
+			SrcSet:       rule.SrcSet,
+			DstSet:       rule.DstSet,
+			Schedule:     rule.Schedule,
 			InInterface:  rule.InInterface,
 			OutInterface: rule.OutInterface,
+			FromZone:     rule.FromZone,
+			ToZone:       rule.ToZone,
+			CTState:      ctState,
+			Log:          rule.Log,
+			LogRate:      rule.LogRate,
+			RateLimit:    rule.RateLimit,
+			RateBurst:    rule.RateBurst,
+			ConnLimit:    rule.ConnLimit,
+			LimitPrefix:  rule.LimitPrefix,
+			SrcCountry:   parseCountries(rule.SrcCountry),
+			DstCountry:   parseCountries(rule.DstCountry),
+			SrcASN:       parseASNs(rule.SrcASN),
 		})
 	}
 	defaults := map[string]firewall.Action{}
@@ -82,13 +174,21 @@ func ApplyState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueMan
 	natRules := make([]nat.Rule, 0, len(state.NATRules))
 	for _, rule := range state.NATRules {
 		natRules = append(natRules, nat.Rule{
-			Type:    nat.Type(rule.Type),
-			SrcNet:  parseCIDR(rule.SrcCIDR),
-			DstNet:  parseCIDR(rule.DstCIDR),
-			SrcPort: rule.SrcPort,
-			DstPort: rule.DstPort,
-			ToIP:    net.ParseIP(rule.ToIP),
-			ToPort:  rule.ToPort,
+			ID:          rule.ID,
+			Description: rule.Description,
+			Disabled:    rule.Disabled,
+			Type:        nat.Type(rule.Type),
+			SrcAddrs:    parseAddrs(rule.SrcCIDR),
+			DstAddrs:    parseAddrs(rule.DstCIDR),
+			SrcPort:     rule.SrcPort,
+			DstPort:     rule.DstPort,
+			SrcPorts:    parsePorts(rule.SrcPorts),
+			DstPorts:    parsePorts(rule.DstPorts),
+			SrcSet:      rule.SrcSet,
+			DstSet:      rule.DstSet,
+			Schedule:    rule.Schedule,
+			ToIP:        net.ParseIP(rule.ToIP),
+			ToPort:      rule.ToPort,
 		})
 	}
 	natTable.ReplaceRules(natRules)
@@ -100,6 +200,11 @@ func ApplyState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueMan
 			Protocol:      class.Protocol,
 			SrcPort:       class.SrcPort,
 			DstPort:       class.DstPort,
+			SrcPorts:      parsePorts(class.SrcPorts),
+			DstPorts:      parsePorts(class.DstPorts),
+			SrcSet:        class.SrcSet,
+			DstSet:        class.DstSet,
+			Schedule:      class.Schedule,
 			RateLimitKbps: class.RateLimitKbps,
 			Priority:      class.Priority,
 			MaxQueue:      class.MaxQueue,
@@ -108,20 +213,62 @@ func ApplyState(fw *firewall.Engine, natTable *nat.Table, qosQueue *qos.QueueMan
 	}
 	qosQueue.ReplaceClasses(qosClasses)
 
-	routeList := make([]routing.Route, 0, len(state.Routes))
-	for _, r := range state.Routes {
+	routes.ReplaceRoutes(append(routeList(state.Routes), localRoutes(routes)...))
+	if policy == nil {
+		return
+	}
+	tables := make(map[string][]routing.Route, len(state.RoutingTables))
+	for _, table := range state.RoutingTables {
+		tables[table.Name] = routeList(table.Routes)
+	}
+	policy.ReplaceTables(tables)
+	rules := make([]routing.PolicyRule, 0, len(state.PolicyRules))
+	for _, rule := range state.PolicyRules {
+		rules = append(rules, rule.ToRouting())
+	}
+	policy.ReplaceRules(rules)
+}
+
+// localRoutes returns the routes a node owns itself: VRF leaks, connected
+// subnets and routes learned by its own routing protocols.
+func localRoutes(table *routing.Table) []routing.Route {
+	var out []routing.Route
+	for _, route := range table.Routes() {
+		if route.TargetVRF != "" || !route.Configured() {
+			out = append(out, route)
+		}
+	}
+	return out
+}
+
+func routeList(routes []Route) []routing.Route {
+	out := make([]routing.Route, 0, len(routes))
+	for _, r := range routes {
 		_, dst, err := net.ParseCIDR(r.Destination)
 		if err != nil {
 			continue
 		}
-		routeList = append(routeList, routing.Route{
+		var hops []routing.NextHop
+		for _, hop := range r.NextHops {
+			hops = append(hops, routing.NextHop{
+				Gateway:   net.ParseIP(hop.Gateway),
+				Interface: hop.Interface,
+				Weight:    hop.Weight,
+				Probe:     hop.Probe,
+			})
+		}
+		out = append(out, routing.Route{
 			Destination: *dst,
 			Gateway:     net.ParseIP(r.Gateway),
 			Interface:   r.Interface,
 			Metric:      r.Metric,
+			Distance:    r.Distance,
+			Source:      r.Source,
+			Tag:         r.Tag,
+			NextHops:    hops,
 		})
 	}
-	routes.ReplaceRoutes(routeList)
+	return out
 }
 
 func cidrFromNet(netw *net.IPNet) string {
@@ -131,6 +278,47 @@ func cidrFromNet(netw *net.IPNet) string {
 	return netw.String()
 }
 
+// portList returns the list form of a port match; single ports travel in the
+// src_port/dst_port fields.
+func portList(m network.PortMatch) string {
+	if _, ok := m.Single(); ok {
+		return ""
+	}
+	return m.String()
+}
+
+func parseAddrs(value string) network.AddrMatch {
+	m, err := network.ParseAddrMatch(value)
+	if err != nil {
+		return network.AddrMatch{}
+	}
+	return m
+}
+
+func parsePorts(value string) network.PortMatch {
+	m, err := network.ParsePortMatch(value)
+	if err != nil {
+		return network.PortMatch{}
+	}
+	return m
+}
+
+func parseCountries(value string) network.CountryMatch {
+	m, err := network.ParseCountryMatch(value)
+	if err != nil {
+		return network.CountryMatch{}
+	}
+	return m
+}
+
+func parseASNs(value string) network.ASNMatch {
+	m, err := network.ParseASNMatch(value)
+	if err != nil {
+		return network.ASNMatch{}
+	}
+	return m
+}
+
 func parseCIDR(value string) *net.IPNet {
 	if value == "" {
 		return nil
@@ -6,6 +6,7 @@ import (
 
 	"router-go/pkg/firewall"
 	"router-go/pkg/nat"
+	"router-go/pkg/network"
 	"router-go/pkg/qos"
 	"router-go/pkg/routing"
 )
@@ -17,6 +18,9 @@ func TestBuildStateAndApplyState(t *testing.T) {
 
 	fw := firewall.NewEngineWithDefaults([]firewall.Rule{
 		{
+			ID:           42,
+			Description:  "web",
+			Disabled:     true,
 			Chain:        "INPUT",
 			Action:       firewall.ActionDrop,
 			Protocol:     "tcp",
@@ -26,6 +30,10 @@ func TestBuildStateAndApplyState(t *testing.T) {
 			DstPort:      80,
 			InInterface:  "eth0",
 			OutInterface: "",
+			RateLimit:    5,
+			ConnLimit:    3,
+			LimitPrefix:  24,
+			SrcCountry:   network.CountryMatch{Codes: []string{"CN"}, Negate: true},
 		},
 	}, map[string]firewall.Action{
 		"INPUT": firewall.ActionAccept,
@@ -33,6 +41,7 @@ func TestBuildStateAndApplyState(t *testing.T) {
 
 	natTable := nat.NewTable([]nat.Rule{
 		{
+			ID:      7,
 			Type:    nat.TypeSNAT,
 			SrcNet:  srcNet,
 			SrcPort: 1234,
@@ -89,11 +98,16 @@ func TestBuildStateAndApplyState(t *testing.T) {
 	if len(fw2.Rules()) != 1 {
 		t.Fatalf("expected 1 firewall rule after apply, got %d", len(fw2.Rules()))
 	}
+	if rule := fw2.Rules()[0]; rule.ID != 42 || rule.Description != "web" || !rule.Disabled ||
+		rule.RateLimit != 5 || rule.ConnLimit != 3 || rule.LimitPrefix != 24 ||
+		rule.SrcCountry.String() != "!CN" {
+		t.Fatalf("expected firewall rule id and flags to sync, got %+v", rule)
+	}
 	if fw2.DefaultPolicies()["INPUT"] != firewall.ActionAccept {
 		t.Fatalf("expected default policy INPUT=ACCEPT after apply")
 	}
-	if len(nat2.Rules()) != 1 {
-		t.Fatalf("expected 1 nat rule after apply, got %d", len(nat2.Rules()))
+	if len(nat2.Rules()) != 1 || nat2.Rules()[0].ID != 7 {
+		t.Fatalf("expected nat rule 7 after apply, got %+v", nat2.Rules())
 	}
 	if !hasQoSClass(qos2.Classes(), "video") {
 		t.Fatalf("expected qos class video after apply")
@@ -103,6 +117,85 @@ func TestBuildStateAndApplyState(t *testing.T) {
 	}
 }
 
+func TestApplyStateKeepsNextHops(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	routes := routing.NewTable([]routing.Route{{
+		Destination: *dst,
+		NextHops: []routing.NextHop{
+			{Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Weight: 2},
+			{Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2", Weight: 1, Probe: ":443"},
+		},
+	}})
+	state := BuildState(firewall.NewEngine(nil), nat.NewTable(nil), qos.NewQueueManager(nil), routes)
+	if len(state.Routes) != 1 || len(state.Routes[0].NextHops) != 2 {
+		t.Fatalf("expected route with 2 next hops, got %+v", state.Routes)
+	}
+
+	routes2 := routing.NewTable(nil)
+	ApplyState(firewall.NewEngine(nil), nat.NewTable(nil), qos.NewQueueManager(nil), routes2, state)
+	got := routes2.Routes()
+	if len(got) != 1 || len(got[0].NextHops) != 2 {
+		t.Fatalf("expected next hops after apply, got %+v", got)
+	}
+	if got[0].NextHops[0].Weight != 2 || got[0].NextHops[1].Probe != ":443" {
+		t.Fatalf("unexpected next hops after apply: %+v", got[0].NextHops)
+	}
+}
+
+func TestApplyStateKeepsLocalRoutes(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/24")
+	_, learned, _ := net.ParseCIDR("172.16.0.0/16")
+	_, static, _ := net.ParseCIDR("192.168.50.0/24")
+	active := routing.NewTable([]routing.Route{
+		{Destination: *lan, Interface: "eth0", Source: routing.SourceConnected},
+		{Destination: *static, Gateway: net.ParseIP("10.0.0.254"), Distance: 1, Source: routing.SourceStatic},
+	})
+	state := BuildState(firewall.NewEngine(nil), nat.NewTable(nil), qos.NewQueueManager(nil), active)
+	if len(state.Routes) != 1 || state.Routes[0].Source != routing.SourceStatic {
+		t.Fatalf("expected only the static route in state, got %+v", state.Routes)
+	}
+
+	standby := routing.NewTable([]routing.Route{
+		{Destination: *lan, Interface: "eth0", Source: routing.SourceConnected},
+		{Destination: *learned, Gateway: net.ParseIP("10.0.0.2"), Distance: 110, Source: "ospf"},
+	})
+	ApplyState(firewall.NewEngine(nil), nat.NewTable(nil), qos.NewQueueManager(nil), standby, state)
+	sources := map[string]int{}
+	for _, route := range standby.Routes() {
+		sources[route.Source]++
+	}
+	if len(standby.Routes()) != 3 || sources[routing.SourceConnected] != 1 || sources["ospf"] != 1 || sources[routing.SourceStatic] != 1 {
+		t.Fatalf("unexpected routes after apply: %+v", standby.Routes())
+	}
+}
+
+func TestStateSyncsPolicyRouting(t *testing.T) {
+	_, def, _ := net.ParseCIDR("0.0.0.0/0")
+	_, guests, _ := net.ParseCIDR("10.0.2.0/24")
+	policy := routing.NewPolicy(routing.NewTable(nil))
+	if _, err := policy.AddTable("isp2", []routing.Route{{Destination: *def, Interface: "wan2"}}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	if err := policy.AddRule(routing.PolicyRule{Priority: 100, SrcNet: guests, DSCP: 0, MatchDSCP: true, Table: "isp2"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	state := BuildStateWithPolicy(firewall.NewEngine(nil), nat.NewTable(nil), qos.NewQueueManager(nil), policy.Main(), policy)
+	if len(state.RoutingTables) != 1 || len(state.PolicyRules) != 1 {
+		t.Fatalf("expected policy routing in state, got %+v", state)
+	}
+
+	policy2 := routing.NewPolicy(routing.NewTable(nil))
+	ApplyStateWithPolicy(firewall.NewEngine(nil), nat.NewTable(nil), qos.NewQueueManager(nil), policy2.Main(), policy2, state)
+	table, ok := policy2.Table("isp2")
+	if !ok || len(table.Routes()) != 1 {
+		t.Fatalf("expected isp2 table after apply")
+	}
+	rules := policy2.Rules()
+	if len(rules) != 1 || rules[0].SrcNet.String() != guests.String() || !rules[0].MatchDSCP {
+		t.Fatalf("unexpected rules after apply: %+v", rules)
+	}
+}
+
 func hasQoSClass(classes []qos.Class, name string) bool {
 	for _, cl := range classes {
 		if cl.Name == name {
@@ -13,6 +13,8 @@ type PeerStatus struct {
 	NodeID   string `json:"node_id"`
 	Priority int    `json:"priority"`
 	LastSeen int64  `json:"last_seen"`
+	Address  string `json:"address,omitempty"`
+	BFDDown  bool   `json:"bfd_down,omitempty"`
 }
 
 type State struct {
@@ -21,28 +23,59 @@ type State struct {
 	NATRules         []NATRule         `json:"nat_rules"`
 	QoSClasses       []QoSClass        `json:"qos_classes"`
 	Routes           []Route           `json:"routes"`
+	RoutingTables    []RoutingTable    `json:"routing_tables,omitempty"`
+	PolicyRules      []PolicyRule      `json:"policy_rules,omitempty"`
 }
 
 type FirewallRule struct {
+	ID           uint64 `json:"id,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Disabled     bool   `json:"disabled,omitempty"`
 	Chain        string `json:"chain"`
 	Action       string `json:"action"`
+	Target       string `json:"target,omitempty"`
 	Protocol     string `json:"protocol"`
 	SrcCIDR      string `json:"src_cidr,omitempty"`
 	DstCIDR      string `json:"dst_cidr,omitempty"`
 	SrcPort      int    `json:"src_port,omitempty"`
 	DstPort      int    `json:"dst_port,omitempty"`
+	SrcPorts     string `json:"src_ports,omitempty"`
+	DstPorts     string `json:"dst_ports,omitempty"`
+	SrcSet       string `json:"src_set,omitempty"`
+	DstSet       string `json:"dst_set,omitempty"`
 	InInterface  string `json:"in_interface,omitempty"`
 	OutInterface string `json:"out_interface,omitempty"`
+	FromZone     string `json:"from_zone,omitempty"`
+	ToZone       string `json:"to_zone,omitempty"`
+	CTState      string `json:"ct_state,omitempty"`
+	Schedule     string `json:"schedule,omitempty"`
+	Log          bool   `json:"log,omitempty"`
+	LogRate      int    `json:"log_rate,omitempty"`
+	RateLimit    int    `json:"rate_limit,omitempty"`
+	RateBurst    int    `json:"rate_burst,omitempty"`
+	ConnLimit    int    `json:"conn_limit,omitempty"`
+	LimitPrefix  int    `json:"limit_prefix,omitempty"`
+	SrcCountry   string `json:"src_country,omitempty"`
+	DstCountry   string `json:"dst_country,omitempty"`
+	SrcASN       string `json:"src_asn,omitempty"`
 }
 
 type NATRule struct {
-	Type    string `json:"type"`
-	SrcCIDR string `json:"src_cidr,omitempty"`
-	DstCIDR string `json:"dst_cidr,omitempty"`
-	SrcPort int    `json:"src_port,omitempty"`
-	DstPort int    `json:"dst_port,omitempty"`
-	ToIP    string `json:"to_ip,omitempty"`
-	ToPort  int    `json:"to_port,omitempty"`
+	ID          uint64 `json:"id,omitempty"`
+	Description string `json:"description,omitempty"`
+	Disabled    bool   `json:"disabled,omitempty"`
+	Type        string `json:"type"`
+	SrcCIDR     string `json:"src_cidr,omitempty"`
+	DstCIDR     string `json:"dst_cidr,omitempty"`
+	SrcPort     int    `json:"src_port,omitempty"`
+	DstPort     int    `json:"dst_port,omitempty"`
+	SrcPorts    string `json:"src_ports,omitempty"`
+	DstPorts    string `json:"dst_ports,omitempty"`
+	SrcSet      string `json:"src_set,omitempty"`
+	DstSet      string `json:"dst_set,omitempty"`
+	Schedule    string `json:"schedule,omitempty"`
+	ToIP        string `json:"to_ip,omitempty"`
+	ToPort      int    `json:"to_port,omitempty"`
 }
 
 type QoSClass struct {
@@ -50,6 +83,11 @@ type QoSClass struct {
 	Protocol      string `json:"protocol"`
 	SrcPort       int    `json:"src_port,omitempty"`
 	DstPort       int    `json:"dst_port,omitempty"`
+	SrcPorts      string `json:"src_ports,omitempty"`
+	DstPorts      string `json:"dst_ports,omitempty"`
+	SrcSet        string `json:"src_set,omitempty"`
+	DstSet        string `json:"dst_set,omitempty"`
+	Schedule      string `json:"schedule,omitempty"`
 	RateLimitKbps int    `json:"rate_limit_kbps"`
 	Priority      int    `json:"priority"`
 	MaxQueue      int    `json:"max_queue,omitempty"`
@@ -57,17 +95,103 @@ type QoSClass struct {
 }
 
 type Route struct {
-	Destination string `json:"destination"`
-	Gateway     string `json:"gateway"`
-	Interface   string `json:"interface"`
-	Metric      int    `json:"metric"`
+	Destination string    `json:"destination"`
+	Gateway     string    `json:"gateway"`
+	Interface   string    `json:"interface"`
+	Metric      int       `json:"metric"`
+	Distance    int       `json:"distance,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Tag         uint32    `json:"tag,omitempty"`
+	NextHops    []NextHop `json:"next_hops,omitempty"`
+}
+
+type NextHop struct {
+	Gateway   string `json:"gateway,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Weight    int    `json:"weight,omitempty"`
+	Probe     string `json:"probe,omitempty"`
 }
 
 func RouteFrom(r routing.Route) Route {
-	return Route{
+	out := Route{
 		Destination: r.Destination.String(),
 		Gateway:     r.Gateway.String(),
 		Interface:   r.Interface,
 		Metric:      r.Metric,
+		Distance:    r.Distance,
+		Source:      r.Source,
+		Tag:         r.Tag,
+	}
+	for _, hop := range r.NextHops {
+		gw := ""
+		if hop.Gateway != nil {
+			gw = hop.Gateway.String()
+		}
+		out.NextHops = append(out.NextHops, NextHop{
+			Gateway:   gw,
+			Interface: hop.Interface,
+			Weight:    hop.Weight,
+			Probe:     hop.Probe,
+		})
+	}
+	return out
+}
+
+type RoutingTable struct {
+	Name   string  `json:"name"`
+	Routes []Route `json:"routes"`
+}
+
+type PolicyRule struct {
+	Priority    int    `json:"priority"`
+	SrcCIDR     string `json:"src_cidr,omitempty"`
+	DstCIDR     string `json:"dst_cidr,omitempty"`
+	InInterface string `json:"in_interface,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	SrcPort     int    `json:"src_port,omitempty"`
+	DstPort     int    `json:"dst_port,omitempty"`
+	DSCP        *int   `json:"dscp,omitempty"`
+	Mark        uint32 `json:"mark,omitempty"`
+	MarkMask    uint32 `json:"mark_mask,omitempty"`
+	Table       string `json:"table"`
+}
+
+func PolicyRuleFrom(r routing.PolicyRule) PolicyRule {
+	out := PolicyRule{
+		Priority:    r.Priority,
+		SrcCIDR:     cidrFromNet(r.SrcNet),
+		DstCIDR:     cidrFromNet(r.DstNet),
+		InInterface: r.InInterface,
+		Protocol:    r.Protocol,
+		SrcPort:     r.SrcPort,
+		DstPort:     r.DstPort,
+		Mark:        r.Mark,
+		MarkMask:    r.MarkMask,
+		Table:       r.Table,
+	}
+	if r.MatchDSCP {
+		dscp := int(r.DSCP)
+		out.DSCP = &dscp
+	}
+	return out
+}
+
+func (r PolicyRule) ToRouting() routing.PolicyRule {
+	out := routing.PolicyRule{
+		Priority:    r.Priority,
+		SrcNet:      parseCIDR(r.SrcCIDR),
+		DstNet:      parseCIDR(r.DstCIDR),
+		InInterface: r.InInterface,
+		Protocol:    r.Protocol,
+		SrcPort:     r.SrcPort,
+		DstPort:     r.DstPort,
+		Mark:        r.Mark,
+		MarkMask:    r.MarkMask,
+		Table:       r.Table,
+	}
+	if r.DSCP != nil {
+		out.DSCP = uint8(*r.DSCP)
+		out.MatchDSCP = true
 	}
+	return out
 }
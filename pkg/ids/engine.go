@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
 )
 
@@ -26,6 +27,15 @@ type Rule struct {
 	DstNet          *net.IPNet
 	SrcPort         int
 	DstPort         int
+	SrcAddrs        network.AddrMatch
+	DstAddrs        network.AddrMatch
+	SrcSet          string
+	DstSet          string
+	SrcPorts        network.PortMatch
+	DstPorts        network.PortMatch
+	SrcCountry      network.CountryMatch
+	DstCountry      network.CountryMatch
+	SrcASN          network.ASNMatch
 	PayloadContains string
 	Priority        int
 	Enabled         bool
@@ -57,13 +67,15 @@ type Config struct {
 }
 
 type Engine struct {
-	mu      sync.Mutex
-	rules   []Rule
-	alerts  []Alert
-	stats   map[string]*ipStats
+	mu       sync.Mutex
+	rules    []Rule
+	alerts   []Alert
+	stats    map[string]*ipStats
 	ruleHits map[string]uint64
-	cfg     Config
-	nowFunc func() time.Time
+	cfg      Config
+	sets     *ipset.Registry
+	geo      network.GeoResolver
+	nowFunc  func() time.Time
 }
 
 type ipStats struct {
@@ -98,15 +110,31 @@ func NewEngine(cfg Config) *Engine {
 		cfg.AlertLimit = 1000
 	}
 	return &Engine{
-		rules:   nil,
-		alerts:  nil,
-		stats:   map[string]*ipStats{},
+		rules:    nil,
+		alerts:   nil,
+		stats:    map[string]*ipStats{},
 		ruleHits: map[string]uint64{},
-		cfg:     cfg,
-		nowFunc: time.Now,
+		cfg:      cfg,
+		nowFunc:  time.Now,
 	}
 }
 
+// SetIPSets attaches the registry that SrcSet/DstSet references resolve
+// against.
+func (e *Engine) SetIPSets(sets *ipset.Registry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sets = sets
+}
+
+// SetGeo attaches the resolver SrcCountry/DstCountry and SrcASN matches
+// consult. Without one those rules never match.
+func (e *Engine) SetGeo(geo network.GeoResolver) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.geo = geo
+}
+
 func (e *Engine) AddRule(rule Rule) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -232,21 +260,27 @@ func (e *Engine) matchSignature(pkt network.Packet) (Result, bool) {
 		if rule.hasProto && rule.protoKey != packetProto {
 			continue
 		}
-		if rule.SrcNet != nil && pkt.Metadata.SrcIP != nil && !rule.SrcNet.Contains(pkt.Metadata.SrcIP) {
+		if pkt.Metadata.SrcIP != nil && !rule.SrcAddrs.Matches(pkt.Metadata.SrcIP) {
+			continue
+		}
+		if pkt.Metadata.DstIP != nil && !rule.DstAddrs.Matches(pkt.Metadata.DstIP) {
 			continue
 		}
-		if rule.DstNet != nil && pkt.Metadata.DstIP != nil && !rule.DstNet.Contains(pkt.Metadata.DstIP) {
+		if rule.SrcSet != "" && pkt.Metadata.SrcIP != nil && !e.sets.Match(rule.SrcSet, pkt.Metadata.SrcIP) {
 			continue
 		}
-		if rule.SrcPort != 0 && rule.SrcPort != pkt.Metadata.SrcPort {
+		if rule.DstSet != "" && pkt.Metadata.DstIP != nil && !e.sets.Match(rule.DstSet, pkt.Metadata.DstIP) {
 			continue
 		}
-		if rule.DstPort != 0 && rule.DstPort != pkt.Metadata.DstPort {
+		if !rule.SrcPorts.Matches(pkt.Metadata.SrcPort) || !rule.DstPorts.Matches(pkt.Metadata.DstPort) {
 			continue
 		}
 		if rule.PayloadContains != "" && !bytes.Contains(pkt.Data, []byte(rule.PayloadContains)) {
 			continue
 		}
+		if !e.matchGeo(rule, pkt) {
+			continue
+		}
 
 		e.ruleHits[rule.Name]++
 		alert := e.addAlert(Alert{
@@ -269,7 +303,50 @@ func (e *Engine) matchSignature(pkt network.Packet) (Result, bool) {
 	return Result{}, false
 }
 
+func (e *Engine) matchGeo(rule Rule, pkt network.Packet) bool {
+	if rule.SrcCountry.IsZero() && rule.DstCountry.IsZero() && rule.SrcASN.IsZero() {
+		return true
+	}
+	if e.geo == nil {
+		return false
+	}
+	if !rule.SrcCountry.IsZero() || !rule.SrcASN.IsZero() {
+		country, asn := e.geo.Geo(pkt.Metadata.SrcIP)
+		if !rule.SrcCountry.Matches(country) || !rule.SrcASN.Matches(asn) {
+			return false
+		}
+	}
+	if !rule.DstCountry.IsZero() {
+		if pkt.Metadata.DstIP == nil {
+			return false
+		}
+		country, _ := e.geo.Geo(pkt.Metadata.DstIP)
+		if !rule.DstCountry.Matches(country) {
+			return false
+		}
+	}
+	return true
+}
+
 func normalizeRule(rule Rule) Rule {
+	if rule.SrcAddrs.IsZero() {
+		rule.SrcAddrs = network.NetMatch(rule.SrcNet)
+	}
+	if rule.DstAddrs.IsZero() {
+		rule.DstAddrs = network.NetMatch(rule.DstNet)
+	}
+	if rule.SrcPorts.IsZero() {
+		rule.SrcPorts = network.SinglePort(rule.SrcPort)
+	}
+	if rule.DstPorts.IsZero() {
+		rule.DstPorts = network.SinglePort(rule.DstPort)
+	}
+	rule.SrcNet = rule.SrcAddrs.Single()
+	rule.DstNet = rule.DstAddrs.Single()
+	rule.SrcPort, _ = rule.SrcPorts.Single()
+	rule.DstPort, _ = rule.DstPorts.Single()
+	rule.SrcSet = ipset.NormalizeRef(rule.SrcSet)
+	rule.DstSet = ipset.NormalizeRef(rule.DstSet)
 	rule.protoKey = protoToKey(rule.Protocol)
 	rule.hasProto = rule.Protocol != ""
 	return rule
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
 )
 
@@ -42,6 +43,38 @@ func TestSignatureRuleMatch(t *testing.T) {
 	}
 }
 
+func TestSignatureRulePortRangeAndNegatedSource(t *testing.T) {
+	srcAddrs, _ := network.ParseAddrMatch("!10.0.0.0/8")
+	dstPorts, _ := network.ParsePortMatch("1-1023")
+	engine := NewEngine(Config{AlertLimit: 10})
+	engine.AddRule(Rule{
+		Name:     "external-low-ports",
+		Action:   ActionDrop,
+		Protocol: "TCP",
+		SrcAddrs: srcAddrs,
+		DstPorts: dstPorts,
+		Enabled:  true,
+	})
+
+	cases := []struct {
+		src  string
+		port int
+		drop bool
+	}{
+		{"203.0.113.5", 22, true},
+		{"203.0.113.5", 8080, false},
+		{"10.1.2.3", 22, false},
+	}
+	for _, tc := range cases {
+		res := engine.Detect(network.Packet{Metadata: network.PacketMetadata{
+			Protocol: "TCP", SrcIP: net.ParseIP(tc.src), DstIP: net.ParseIP("192.0.2.1"), SrcPort: 40000, DstPort: tc.port,
+		}})
+		if res.Drop != tc.drop {
+			t.Fatalf("%s:%d: expected drop=%v", tc.src, tc.port, tc.drop)
+		}
+	}
+}
+
 func TestSignatureRuleProtocolNumMatch(t *testing.T) {
 	engine := NewEngine(Config{AlertLimit: 10})
 	engine.AddRule(Rule{
@@ -354,3 +387,49 @@ func TestRulesAndAlertsReset(t *testing.T) {
 		t.Fatalf("expected rule hits reset, got %+v", stats)
 	}
 }
+
+func TestSignatureRuleIPSet(t *testing.T) {
+	sets := ipset.NewRegistry()
+	_ = sets.Create("scanners", ipset.TypeHashNet, 0)
+	member, _ := ipset.ParseMember(ipset.TypeHashNet, "203.0.113.0/24")
+	_ = sets.Add("scanners", []ipset.Entry{{Prefix: member}})
+	engine := NewEngine(Config{AlertLimit: 10})
+	engine.SetIPSets(sets)
+	engine.AddRule(Rule{Name: "known-scanner", Action: ActionDrop, SrcSet: "scanners", Enabled: true})
+
+	for src, drop := range map[string]bool{"203.0.113.9": true, "198.51.100.9": false} {
+		res := engine.Detect(network.Packet{Metadata: network.PacketMetadata{
+			Protocol: "TCP", SrcIP: net.ParseIP(src), DstIP: net.ParseIP("192.0.2.1"), SrcPort: 40000, DstPort: 22,
+		}})
+		if res.Drop != drop {
+			t.Fatalf("%s: expected drop=%v", src, drop)
+		}
+	}
+}
+
+type fakeGeo map[string]string
+
+func (g fakeGeo) Geo(ip net.IP) (string, uint32) {
+	return g[ip.String()], 0
+}
+
+func TestSignatureRuleCountry(t *testing.T) {
+	countries, _ := network.ParseCountryMatch("!DE")
+	engine := NewEngine(Config{AlertLimit: 10})
+	engine.AddRule(Rule{Name: "foreign-admin", Action: ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: countries, Enabled: true})
+
+	packet := func(src string) network.Packet {
+		return network.Packet{Metadata: network.PacketMetadata{
+			Protocol: "TCP", SrcIP: net.ParseIP(src), DstIP: net.ParseIP("192.0.2.1"), SrcPort: 40000, DstPort: 22,
+		}}
+	}
+	if engine.Detect(packet("203.0.113.9")).Drop {
+		t.Fatalf("expected country rule not to match without a resolver")
+	}
+	engine.SetGeo(fakeGeo{"203.0.113.9": "CN", "198.51.100.9": "DE"})
+	for src, drop := range map[string]bool{"203.0.113.9": true, "198.51.100.9": false, "10.0.0.9": false} {
+		if res := engine.Detect(packet(src)); res.Drop != drop {
+			t.Fatalf("%s: expected drop=%v", src, drop)
+		}
+	}
+}
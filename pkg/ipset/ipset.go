@@ -0,0 +1,601 @@
+package ipset
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeHashNet Type = "hash:net"
+	TypeHashIP  Type = "hash:ip"
+
+	MaxNameLen = 32
+
+	gcInterval = 10 * time.Second
+)
+
+// Entry is a set member. A zero Expires means the entry is permanent.
+type Entry struct {
+	Prefix  *net.IPNet
+	Expires time.Time
+}
+
+// Change is a membership update of one set, for mirrors such as the nftables
+// backend that apply it without reloading the whole set. Flush means the old
+// members were dropped first; Removed is applied before Added. Added entries
+// carry their resolved expiry, and a member whose expiry changed is both
+// removed and added.
+type Change struct {
+	Set     string
+	Flush   bool
+	Added   []Entry
+	Removed []*net.IPNet
+}
+
+// Info summarises a set for listings.
+type Info struct {
+	Name    string
+	Type    Type
+	Timeout time.Duration
+	Size    int
+}
+
+// Set is a named group of addresses or prefixes. Lookups walk a binary
+// prefix trie per family, so the cost is bounded by the address length and
+// not by the number of members.
+type Set struct {
+	mu      sync.RWMutex
+	name    string
+	typ     Type
+	timeout time.Duration
+	v4      *node
+	v6      *node
+	size    int
+	timed   int
+	now     func() time.Time
+}
+
+type node struct {
+	child   [2]*node
+	member  bool
+	expires int64
+}
+
+func ParseType(value string) (Type, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", string(TypeHashNet):
+		return TypeHashNet, nil
+	case string(TypeHashIP):
+		return TypeHashIP, nil
+	default:
+		return "", fmt.Errorf("unsupported set type %q", value)
+	}
+}
+
+func ValidName(name string) bool {
+	if name == "" || len(name) > MaxNameLen {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ParseRef splits a set reference such as "blocklist" or "!trusted" into the
+// set name and whether membership is negated.
+func ParseRef(ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if name, ok := strings.CutPrefix(ref, "!"); ok {
+		return strings.TrimSpace(name), true
+	}
+	return ref, false
+}
+
+// NormalizeRef returns the canonical form of a set reference.
+func NormalizeRef(ref string) string {
+	name, negate := ParseRef(ref)
+	if negate {
+		return "!" + name
+	}
+	return name
+}
+
+// ParseMember parses an address or prefix for a set of the given type;
+// hash:ip sets only accept single addresses.
+func ParseMember(typ Type, value string) (*net.IPNet, error) {
+	value = strings.TrimSpace(value)
+	var prefix *net.IPNet
+	if strings.Contains(value, "/") {
+		_, parsed, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid member %q", value)
+		}
+		prefix = parsed
+	} else {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid member %q", value)
+		}
+		prefix = hostPrefix(ip)
+	}
+	if ip4 := prefix.IP.To4(); ip4 != nil {
+		ones, _ := prefix.Mask.Size()
+		if len(prefix.Mask) == net.IPv6len {
+			ones -= 96
+		}
+		prefix = &net.IPNet{IP: ip4, Mask: net.CIDRMask(ones, 32)}
+	}
+	if typ == TypeHashIP {
+		ones, bits := prefix.Mask.Size()
+		if ones != bits {
+			return nil, fmt.Errorf("set type %s accepts addresses only, got %q", typ, value)
+		}
+	}
+	return prefix, nil
+}
+
+func hostPrefix(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+	}
+	return &net.IPNet{IP: ip.To16(), Mask: net.CIDRMask(128, 128)}
+}
+
+func newSet(name string, typ Type, timeout time.Duration, now func() time.Time) *Set {
+	return &Set{name: name, typ: typ, timeout: timeout, now: now}
+}
+
+func (s *Set) Name() string {
+	return s.name
+}
+
+func (s *Set) Type() Type {
+	return s.typ
+}
+
+// Timeout is the default lifetime of entries added without their own expiry.
+func (s *Set) Timeout() time.Duration {
+	return s.timeout
+}
+
+func (s *Set) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.size
+}
+
+func (s *Set) Contains(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var now int64
+	if s.timed > 0 {
+		now = s.now().UnixNano()
+	}
+	n, key := s.v4, ip.To4()
+	if key == nil {
+		n, key = s.v6, ip.To16()
+		if key == nil {
+			return false
+		}
+	}
+	for i := 0; n != nil; i++ {
+		if n.member && (n.expires == 0 || n.expires > now) {
+			return true
+		}
+		if i == len(key)*8 {
+			break
+		}
+		n = n.child[bit(key, i)]
+	}
+	return false
+}
+
+// Entries returns the live members in address order.
+func (s *Set) Entries() []Entry {
+	now := s.now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Entry, 0, s.size)
+	walk(s.v4, make([]byte, net.IPv4len), 0, now, &out)
+	walk(s.v6, make([]byte, net.IPv6len), 0, now, &out)
+	return out
+}
+
+func walk(n *node, key []byte, depth int, now int64, out *[]Entry) {
+	if n == nil {
+		return
+	}
+	if n.member && (n.expires == 0 || n.expires > now) {
+		ip := make(net.IP, len(key))
+		copy(ip, key)
+		entry := Entry{Prefix: &net.IPNet{IP: ip, Mask: net.CIDRMask(depth, len(key)*8)}}
+		if n.expires != 0 {
+			entry.Expires = time.Unix(0, n.expires)
+		}
+		*out = append(*out, entry)
+	}
+	for b := 0; b < 2; b++ {
+		if n.child[b] == nil {
+			continue
+		}
+		if b == 1 {
+			key[depth/8] |= 0x80 >> (depth % 8)
+		}
+		walk(n.child[b], key, depth+1, now, out)
+		key[depth/8] &^= 0x80 >> (depth % 8)
+	}
+}
+
+// expiry resolves the expiry of an entry, applying the set default timeout
+// to entries without their own.
+func (s *Set) expiry(entry Entry, now time.Time) int64 {
+	if !entry.Expires.IsZero() {
+		return entry.Expires.UnixNano()
+	}
+	if s.timeout > 0 {
+		return now.Add(s.timeout).UnixNano()
+	}
+	return 0
+}
+
+// insert adds entries and returns them with their resolved expiry, and the
+// prefixes that were already members with another expiry.
+func (s *Set) insert(entries []Entry, now time.Time) ([]Entry, []*net.IPNet) {
+	added := make([]Entry, 0, len(entries))
+	var refreshed []*net.IPNet
+	for _, entry := range entries {
+		key, ones := prefixKey(entry.Prefix)
+		root := &s.v4
+		if len(key) == net.IPv6len {
+			root = &s.v6
+		}
+		if *root == nil {
+			*root = &node{}
+		}
+		n := *root
+		for i := 0; i < ones; i++ {
+			b := bit(key, i)
+			if n.child[b] == nil {
+				n.child[b] = &node{}
+			}
+			n = n.child[b]
+		}
+		expires := s.expiry(entry, now)
+		if !n.member {
+			s.size++
+		} else {
+			if n.expires != expires {
+				refreshed = append(refreshed, entry.Prefix)
+			}
+			if n.expires != 0 {
+				s.timed--
+			}
+		}
+		n.member = true
+		n.expires = expires
+		resolved := Entry{Prefix: entry.Prefix}
+		if expires != 0 {
+			s.timed++
+			resolved.Expires = time.Unix(0, expires)
+		}
+		added = append(added, resolved)
+	}
+	return added, refreshed
+}
+
+// remove deletes the prefixes and returns the ones that were members.
+func (s *Set) remove(prefixes []*net.IPNet) []*net.IPNet {
+	var removed []*net.IPNet
+	for _, prefix := range prefixes {
+		key, ones := prefixKey(prefix)
+		root := &s.v4
+		if len(key) == net.IPv6len {
+			root = &s.v6
+		}
+		if s.removeNode(root, key, 0, ones) {
+			removed = append(removed, prefix)
+		}
+	}
+	return removed
+}
+
+func (s *Set) removeNode(ref **node, key []byte, depth int, ones int) bool {
+	n := *ref
+	if n == nil {
+		return false
+	}
+	var removed bool
+	if depth == ones {
+		if !n.member {
+			return false
+		}
+		if n.expires != 0 {
+			s.timed--
+		}
+		n.member, n.expires = false, 0
+		s.size--
+		removed = true
+	} else {
+		removed = s.removeNode(&n.child[bit(key, depth)], key, depth+1, ones)
+	}
+	if !n.member && n.child[0] == nil && n.child[1] == nil {
+		*ref = nil
+	}
+	return removed
+}
+
+// expire drops members whose expiry has passed and reports how many were
+// removed.
+func (s *Set) expire(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timed == 0 {
+		return 0
+	}
+	var all []Entry
+	walk(s.v4, make([]byte, net.IPv4len), 0, math.MinInt64, &all)
+	walk(s.v6, make([]byte, net.IPv6len), 0, math.MinInt64, &all)
+	var stale []*net.IPNet
+	for _, entry := range all {
+		if !entry.Expires.IsZero() && !entry.Expires.After(now) {
+			stale = append(stale, entry.Prefix)
+		}
+	}
+	return len(s.remove(stale))
+}
+
+func prefixKey(prefix *net.IPNet) ([]byte, int) {
+	ones, _ := prefix.Mask.Size()
+	if ip4 := prefix.IP.To4(); ip4 != nil {
+		if len(prefix.Mask) == net.IPv6len {
+			ones -= 96
+		}
+		return ip4, ones
+	}
+	return prefix.IP.To16(), ones
+}
+
+func bit(key []byte, i int) int {
+	return int(key[i/8]>>(7-i%8)) & 1
+}
+
+// Registry holds the named sets shared by the packet engines.
+type Registry struct {
+	mu        sync.RWMutex
+	sets      map[string]*Set
+	onChange  func()
+	onMembers func(Change)
+	now       func() time.Time
+}
+
+func NewRegistry() *Registry {
+	return &Registry{sets: map[string]*Set{}, now: time.Now}
+}
+
+func (r *Registry) SetOnChange(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = fn
+}
+
+// SetOnMembers receives membership changes made through Add, Remove and
+// Replace; without it those call the SetOnChange callback instead. Expired
+// members are not reported: a mirror is expected to time them out itself.
+func (r *Registry) SetOnMembers(fn func(Change)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onMembers = fn
+}
+
+func (r *Registry) notifyMembers(change Change) {
+	r.mu.RLock()
+	fn := r.onMembers
+	r.mu.RUnlock()
+	if fn == nil {
+		r.notifyChange()
+		return
+	}
+	fn(change)
+}
+
+func (r *Registry) mirrored() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.onMembers != nil
+}
+
+func (r *Registry) notifyChange() {
+	r.mu.RLock()
+	fn := r.onChange
+	r.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (r *Registry) Create(name string, typ Type, timeout time.Duration) error {
+	if !ValidName(name) {
+		return fmt.Errorf("invalid set name %q", name)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("invalid timeout")
+	}
+	r.mu.Lock()
+	if _, ok := r.sets[name]; ok {
+		r.mu.Unlock()
+		return fmt.Errorf("set %q already exists", name)
+	}
+	r.sets[name] = newSet(name, typ, timeout, r.now)
+	r.mu.Unlock()
+	r.notifyChange()
+	return nil
+}
+
+func (r *Registry) Delete(name string) bool {
+	r.mu.Lock()
+	_, ok := r.sets[name]
+	delete(r.sets, name)
+	r.mu.Unlock()
+	if ok {
+		r.notifyChange()
+	}
+	return ok
+}
+
+func (r *Registry) Get(name string) (*Set, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set, ok := r.sets[name]
+	return set, ok
+}
+
+func (r *Registry) Has(name string) bool {
+	_, ok := r.Get(name)
+	return ok
+}
+
+func (r *Registry) List() []Info {
+	r.mu.RLock()
+	sets := make([]*Set, 0, len(r.sets))
+	for _, set := range r.sets {
+		sets = append(sets, set)
+	}
+	r.mu.RUnlock()
+	sort.Slice(sets, func(i, j int) bool { return sets[i].name < sets[j].name })
+	out := make([]Info, 0, len(sets))
+	for _, set := range sets {
+		out = append(out, Info{Name: set.name, Type: set.typ, Timeout: set.timeout, Size: set.Len()})
+	}
+	return out
+}
+
+// Add inserts all entries or none: every prefix is checked against the set
+// type before the set is touched. Existing entries get the new expiry.
+func (r *Registry) Add(name string, entries []Entry) error {
+	set, err := r.writable(name, entries)
+	if err != nil {
+		return err
+	}
+	set.mu.Lock()
+	added, refreshed := set.insert(entries, r.now())
+	set.mu.Unlock()
+	r.notifyMembers(Change{Set: name, Added: added, Removed: refreshed})
+	return nil
+}
+
+// Remove deletes the given prefixes in one step and reports how many were
+// members.
+func (r *Registry) Remove(name string, prefixes []*net.IPNet) (int, error) {
+	set, ok := r.Get(name)
+	if !ok {
+		return 0, fmt.Errorf("set %q not found", name)
+	}
+	set.mu.Lock()
+	removed := set.remove(prefixes)
+	set.mu.Unlock()
+	if len(removed) > 0 {
+		r.notifyMembers(Change{Set: name, Removed: removed})
+	}
+	return len(removed), nil
+}
+
+// Replace swaps the whole membership; lookups see either the old or the new
+// contents.
+func (r *Registry) Replace(name string, entries []Entry) error {
+	set, err := r.writable(name, entries)
+	if err != nil {
+		return err
+	}
+	next := newSet(set.name, set.typ, set.timeout, set.now)
+	added, _ := next.insert(entries, r.now())
+	set.mu.Lock()
+	set.v4, set.v6, set.size, set.timed = next.v4, next.v6, next.size, next.timed
+	set.mu.Unlock()
+	r.notifyMembers(Change{Set: name, Flush: true, Added: added})
+	return nil
+}
+
+func (r *Registry) writable(name string, entries []Entry) (*Set, error) {
+	set, ok := r.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("set %q not found", name)
+	}
+	for _, entry := range entries {
+		if entry.Prefix == nil {
+			return nil, fmt.Errorf("empty member")
+		}
+		if _, err := ParseMember(set.typ, entry.Prefix.String()); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Match evaluates a set reference against an address: "" matches anything,
+// "name" requires membership and "!name" its absence. Unknown sets, and a
+// nil registry, behave as empty sets.
+func (r *Registry) Match(ref string, ip net.IP) bool {
+	if ref == "" {
+		return true
+	}
+	name, negate := ParseRef(ref)
+	set, ok := r.Get(name)
+	if !ok {
+		return negate
+	}
+	return set.Contains(ip) != negate
+}
+
+// Expire removes expired members from every set.
+func (r *Registry) Expire() int {
+	r.mu.RLock()
+	sets := make([]*Set, 0, len(r.sets))
+	for _, set := range r.sets {
+		sets = append(sets, set)
+	}
+	r.mu.RUnlock()
+	now := r.now()
+	removed := 0
+	for _, set := range sets {
+		removed += set.expire(now)
+	}
+	return removed
+}
+
+// Start runs the expiry sweep until ctx is cancelled. Expired members never
+// match even before they are swept.
+func (r *Registry) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(gcInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if r.Expire() > 0 && !r.mirrored() {
+					r.notifyChange()
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,192 @@
+package ipset
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func mustMember(t *testing.T, typ Type, value string) *net.IPNet {
+	t.Helper()
+	prefix, err := ParseMember(typ, value)
+	if err != nil {
+		t.Fatalf("parse %q: %v", value, err)
+	}
+	return prefix
+}
+
+func TestRegistryMatchPrefixes(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Create("blocklist", TypeHashNet, 0); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	err := reg.Add("blocklist", []Entry{
+		{Prefix: mustMember(t, TypeHashNet, "10.0.0.0/8")},
+		{Prefix: mustMember(t, TypeHashNet, "192.0.2.7")},
+		{Prefix: mustMember(t, TypeHashNet, "2001:db8::/32")},
+	})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	cases := []struct {
+		ref  string
+		ip   string
+		want bool
+	}{
+		{"blocklist", "10.20.30.40", true},
+		{"blocklist", "192.0.2.7", true},
+		{"blocklist", "192.0.2.8", false},
+		{"blocklist", "2001:db8:1::1", true},
+		{"blocklist", "2001:db9::1", false},
+		{"!blocklist", "172.16.0.1", true},
+		{"!blocklist", "10.1.1.1", false},
+		{"missing", "10.1.1.1", false},
+		{"!missing", "10.1.1.1", true},
+		{"", "10.1.1.1", true},
+	}
+	for _, tc := range cases {
+		if got := reg.Match(tc.ref, net.ParseIP(tc.ip)); got != tc.want {
+			t.Fatalf("Match(%q, %s) = %v, want %v", tc.ref, tc.ip, got, tc.want)
+		}
+	}
+
+	removed, err := reg.Remove("blocklist", []*net.IPNet{mustMember(t, TypeHashNet, "10.0.0.0/8"), mustMember(t, TypeHashNet, "10.0.0.0/16")})
+	if err != nil || removed != 1 {
+		t.Fatalf("expected one removal, got %d %v", removed, err)
+	}
+	if reg.Match("blocklist", net.ParseIP("10.20.30.40")) {
+		t.Fatalf("expected removed prefix not to match")
+	}
+	set, _ := reg.Get("blocklist")
+	entries := set.Entries()
+	if len(entries) != 2 || entries[0].Prefix.String() != "192.0.2.7/32" || entries[1].Prefix.String() != "2001:db8::/32" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+}
+
+func TestRegistryAtomicAddAndReplace(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Create("hosts", TypeHashIP, 0); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := ParseMember(TypeHashIP, "10.0.0.0/24"); err == nil {
+		t.Fatalf("expected hash:ip to reject prefixes")
+	}
+	err := reg.Add("hosts", []Entry{
+		{Prefix: mustMember(t, TypeHashIP, "10.0.0.1")},
+		{Prefix: mustMember(t, TypeHashNet, "10.0.1.0/24")},
+	})
+	if err == nil {
+		t.Fatalf("expected add with a prefix to fail")
+	}
+	if set, _ := reg.Get("hosts"); set.Len() != 0 {
+		t.Fatalf("expected failed add to leave the set untouched")
+	}
+
+	if err := reg.Replace("hosts", []Entry{{Prefix: mustMember(t, TypeHashIP, "10.0.0.1")}, {Prefix: mustMember(t, TypeHashIP, "10.0.0.2")}}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if err := reg.Replace("hosts", []Entry{{Prefix: mustMember(t, TypeHashIP, "10.0.0.3")}}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+	if reg.Match("hosts", net.ParseIP("10.0.0.1")) || !reg.Match("hosts", net.ParseIP("10.0.0.3")) {
+		t.Fatalf("expected replace to swap membership")
+	}
+	if err := reg.Create("hosts", TypeHashIP, 0); err == nil {
+		t.Fatalf("expected duplicate set to fail")
+	}
+	if err := reg.Create("bad name", TypeHashIP, 0); err == nil {
+		t.Fatalf("expected invalid name to fail")
+	}
+}
+
+func TestRegistryTimeouts(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reg := NewRegistry()
+	reg.now = func() time.Time { return now }
+	if err := reg.Create("temp", TypeHashNet, time.Minute); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	err := reg.Add("temp", []Entry{
+		{Prefix: mustMember(t, TypeHashNet, "198.51.100.1")},
+		{Prefix: mustMember(t, TypeHashNet, "198.51.100.2"), Expires: now.Add(10 * time.Second)},
+	})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	now = now.Add(30 * time.Second)
+	if !reg.Match("temp", net.ParseIP("198.51.100.1")) || reg.Match("temp", net.ParseIP("198.51.100.2")) {
+		t.Fatalf("expected per-entry expiry to apply before the set default")
+	}
+	if removed := reg.Expire(); removed != 1 {
+		t.Fatalf("expected one expired entry, got %d", removed)
+	}
+	now = now.Add(time.Minute)
+	if reg.Match("temp", net.ParseIP("198.51.100.1")) {
+		t.Fatalf("expected default timeout to expire entry")
+	}
+	reg.Expire()
+	if set, _ := reg.Get("temp"); set.Len() != 0 {
+		t.Fatalf("expected sweep to empty the set, got %d", set.Len())
+	}
+}
+
+func TestRegistryReportsMemberChanges(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reg := NewRegistry()
+	reg.now = func() time.Time { return now }
+	structural := 0
+	reg.SetOnChange(func() { structural++ })
+	var changes []Change
+	reg.SetOnMembers(func(change Change) { changes = append(changes, change) })
+	if err := reg.Create("temp", TypeHashIP, time.Minute); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	host := mustMember(t, TypeHashIP, "198.51.100.1")
+	if err := reg.Add("temp", []Entry{{Prefix: host}}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	now = now.Add(10 * time.Second)
+	if err := reg.Add("temp", []Entry{{Prefix: host}}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if _, err := reg.Remove("temp", []*net.IPNet{host, mustMember(t, TypeHashIP, "198.51.100.2")}); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := reg.Replace("temp", []Entry{{Prefix: host}}); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	if structural != 1 || len(changes) != 4 {
+		t.Fatalf("expected 1 structural and 4 member changes, got %d %+v", structural, changes)
+	}
+	first := changes[0]
+	if first.Set != "temp" || len(first.Added) != 1 || !first.Added[0].Expires.Equal(time.Unix(1060, 0)) || len(first.Removed) != 0 {
+		t.Fatalf("expected added member with its resolved expiry, got %+v", first)
+	}
+	if refresh := changes[1]; len(refresh.Removed) != 1 || len(refresh.Added) != 1 || !refresh.Added[0].Expires.Equal(time.Unix(1070, 0)) {
+		t.Fatalf("expected refreshed member to be removed and added again, got %+v", refresh)
+	}
+	if removed := changes[2]; len(removed.Removed) != 1 || removed.Removed[0].String() != "198.51.100.1/32" {
+		t.Fatalf("expected only the member to be reported removed, got %+v", removed)
+	}
+	if !changes[3].Flush || len(changes[3].Added) != 1 {
+		t.Fatalf("expected replace to flush and add, got %+v", changes[3])
+	}
+}
+
+func BenchmarkMatchLargeSet(b *testing.B) {
+	reg := NewRegistry()
+	_ = reg.Create("big", TypeHashNet, 0)
+	entries := make([]Entry, 0, 1<<20)
+	for i := 0; i < 1<<20; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)).To4()
+		entries = append(entries, Entry{Prefix: &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}})
+	}
+	_ = reg.Replace("big", entries)
+	ip := net.ParseIP("10.15.200.3")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reg.Match("big", ip)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"strings"
 	"sync"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
+	"router-go/pkg/schedule"
 )
 
 type Type string
@@ -16,18 +18,30 @@ const (
 	TypeDNAT Type = "DNAT"
 )
 
+// Rule selects packets to translate. SrcNet/DstNet and SrcPort/DstPort are
+// the single value forms of SrcAddrs/DstAddrs and SrcPorts/DstPorts.
+// SrcSet/DstSet name an ip set, "!name" negates. A rule with a Schedule,
+// typically a port forward, only opens new translations while the schedule
+// is active; translations already set up keep working. ID identifies the
+// rule for its lifetime; disabled rules open no new translations.
 type Rule struct {
-	Type    Type
-	SrcNet  *net.IPNet
-	DstNet  *net.IPNet
-	SrcPort int
-	DstPort int
-	ToIP    net.IP
-	ToPort  int
-	hasSrcNet  bool
-	hasDstNet  bool
-	hasSrcPort bool
-	hasDstPort bool
+	ID          uint64
+	Description string
+	Disabled    bool
+	Type        Type
+	SrcNet      *net.IPNet
+	DstNet      *net.IPNet
+	SrcPort     int
+	DstPort     int
+	SrcAddrs    network.AddrMatch
+	DstAddrs    network.AddrMatch
+	SrcSet      string
+	DstSet      string
+	SrcPorts    network.PortMatch
+	DstPorts    network.PortMatch
+	ToIP        net.IP
+	ToPort      int
+	Schedule    string
 }
 
 type ConnKey struct {
@@ -42,57 +56,105 @@ type ConnValue struct {
 	TranslatedIP   net.IP
 	TranslatedPort int
 	Target         string
-	RuleIndex      int
+	RuleID         uint64
 }
 
 type Table struct {
-	mu    sync.Mutex
-	rules []Rule
-	conns map[ConnKey]ConnValue
-	hits  []uint64
+	mu        sync.Mutex
+	rules     []Rule
+	conns     map[ConnKey]ConnValue
+	hits      []uint64
+	positions map[uint64]int
+	nextID    uint64
+	sets      *ipset.Registry
+	schedules *schedule.Registry
+	onChange  func()
 }
 
 func NewTable(rules []Rule) *Table {
-	return &Table{
+	t := &Table{
 		rules: normalizeRules(rules),
 		conns: make(map[ConnKey]ConnValue),
 		hits:  make([]uint64, len(rules)),
 	}
+	t.assignIDs()
+	return t
 }
 
-func (t *Table) AddRule(rule Rule) {
+func (t *Table) SetOnChange(fn func()) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.rules = append(t.rules, normalizeRule(rule))
+	t.onChange = fn
+}
+
+// SetIPSets attaches the registry that SrcSet/DstSet references resolve
+// against.
+func (t *Table) SetIPSets(sets *ipset.Registry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sets = sets
+}
+
+// SetSchedules attaches the registry Schedule references resolve against.
+func (t *Table) SetSchedules(schedules *schedule.Registry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.schedules = schedules
+}
+
+// AddRule appends the rule and returns its ID.
+func (t *Table) AddRule(rule Rule) uint64 {
+	t.mu.Lock()
+	rule = normalizeRule(rule)
+	rule.ID = t.claimID(rule.ID)
+	t.rules = append(t.rules, rule)
 	t.hits = append(t.hits, 0)
+	t.reindex()
+	t.mu.Unlock()
+	t.notifyChange()
+	return rule.ID
 }
 
+// RemoveRule removes the first rule matching match, by ID when match has
+// one and by its fields otherwise.
 func (t *Table) RemoveRule(match Rule) bool {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-	for i, rule := range t.rules {
-		if rulesEqual(rule, normalizeRule(match)) {
-			t.rules = append(t.rules[:i], t.rules[i+1:]...)
-			t.hits = append(t.hits[:i], t.hits[i+1:]...)
-			return true
-		}
+	i := t.find(match)
+	if i >= 0 {
+		t.rules = append(t.rules[:i], t.rules[i+1:]...)
+		t.hits = append(t.hits[:i], t.hits[i+1:]...)
+		t.reindex()
+	}
+	t.mu.Unlock()
+	if i < 0 {
+		return false
 	}
-	return false
+	t.notifyChange()
+	return true
 }
 
+// UpdateRule replaces the rule matching old, found as in RemoveRule. The
+// rule keeps its position, hit counter and, unless updated sets another free
+// one, its ID.
 func (t *Table) UpdateRule(old Rule, updated Rule) bool {
 	t.mu.Lock()
-	defer t.mu.Unlock()
-	for i, rule := range t.rules {
-		if rulesEqual(rule, normalizeRule(old)) {
-			t.rules[i] = normalizeRule(updated)
-			if i < len(t.hits) {
-				t.hits[i] = 0
-			}
-			return true
-		}
+	i := t.find(old)
+	if i >= 0 {
+		updated = normalizeRule(updated)
+		if updated.ID == 0 || updated.ID == t.rules[i].ID || t.indexOf(updated.ID) >= 0 {
+			updated.ID = t.rules[i].ID
+		} else {
+			updated.ID = t.claimID(updated.ID)
+		}
+		t.rules[i] = updated
+		t.reindex()
+	}
+	t.mu.Unlock()
+	if i < 0 {
+		return false
 	}
-	return false
+	t.notifyChange()
+	return true
 }
 
 func (t *Table) Rules() []Rule {
@@ -121,6 +183,16 @@ func (t *Table) RulesWithStats() []RuleStat {
 	return out
 }
 
+// AddRuleHits adds packet counts by rule ID; IDs of rules that are gone are
+// ignored.
+func (t *Table) AddRuleHits(deltas map[uint64]uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, rule := range t.rules {
+		t.hits[i] += deltas[rule.ID]
+	}
+}
+
 func (t *Table) ResetStats() {
 	t.mu.Lock()
 	defer t.mu.Unlock()
@@ -131,10 +203,21 @@ func (t *Table) ResetStats() {
 
 func (t *Table) ReplaceRules(rules []Rule) {
 	t.mu.Lock()
-	defer t.mu.Unlock()
 	t.rules = normalizeRules(rules)
+	t.assignIDs()
 	t.hits = make([]uint64, len(rules))
 	t.conns = make(map[ConnKey]ConnValue)
+	t.mu.Unlock()
+	t.notifyChange()
+}
+
+func (t *Table) notifyChange() {
+	t.mu.Lock()
+	fn := t.onChange
+	t.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
 }
 
 func (t *Table) Apply(pkt network.Packet) network.Packet {
@@ -143,21 +226,21 @@ func (t *Table) Apply(pkt network.Packet) network.Packet {
 
 	key := makeConnKey(pkt)
 	if val, ok := t.conns[key]; ok {
-		if val.RuleIndex >= 0 && val.RuleIndex < len(t.hits) {
-			t.hits[val.RuleIndex]++
+		if i, ok := t.positions[val.RuleID]; ok {
+			t.hits[i]++
 		}
 		applyTranslation(&pkt, val)
 		return pkt
 	}
 
 	for i, rule := range t.rules {
-		if !matchRule(rule, pkt) {
+		if rule.Disabled || !matchRule(rule, t.sets, t.schedules, pkt) {
 			continue
 		}
 		translated, forwardVal, reverseKey, reverseVal := applyRule(rule, pkt)
 		t.hits[i]++
-		forwardVal.RuleIndex = i
-		reverseVal.RuleIndex = i
+		forwardVal.RuleID = rule.ID
+		reverseVal.RuleID = rule.ID
 		if forwardVal.Target != "" {
 			t.conns[key] = forwardVal
 		}
@@ -170,24 +253,17 @@ func (t *Table) Apply(pkt network.Packet) network.Packet {
 	return pkt
 }
 
-func matchRule(rule Rule, pkt network.Packet) bool {
-	if rule.hasSrcNet {
-		if pkt.Metadata.SrcIP == nil || !rule.SrcNet.Contains(pkt.Metadata.SrcIP) {
-			return false
-		}
-	}
-	if rule.hasDstNet {
-		if pkt.Metadata.DstIP == nil || !rule.DstNet.Contains(pkt.Metadata.DstIP) {
-			return false
-		}
+func matchRule(rule Rule, sets *ipset.Registry, schedules *schedule.Registry, pkt network.Packet) bool {
+	if !rule.SrcAddrs.Matches(pkt.Metadata.SrcIP) || !rule.DstAddrs.Matches(pkt.Metadata.DstIP) {
+		return false
 	}
-	if rule.hasSrcPort && rule.SrcPort != pkt.Metadata.SrcPort {
+	if !sets.Match(rule.SrcSet, pkt.Metadata.SrcIP) || !sets.Match(rule.DstSet, pkt.Metadata.DstIP) {
 		return false
 	}
-	if rule.hasDstPort && rule.DstPort != pkt.Metadata.DstPort {
+	if !rule.SrcPorts.Matches(pkt.Metadata.SrcPort) || !rule.DstPorts.Matches(pkt.Metadata.DstPort) {
 		return false
 	}
-	return true
+	return rule.Schedule == "" || schedules.Active(rule.Schedule)
 }
 
 func makeConnKey(pkt network.Packet) ConnKey {
@@ -222,9 +298,9 @@ func applyTranslation(pkt *network.Packet, val ConnValue) {
 
 func applyRule(rule Rule, pkt network.Packet) (network.Packet, ConnValue, ConnKey, ConnValue) {
 	translated := pkt
-	forward := ConnValue{RuleIndex: -1}
+	forward := ConnValue{}
 	reverseKey := ConnKey{}
-	reverse := ConnValue{RuleIndex: -1}
+	reverse := ConnValue{}
 
 	switch rule.Type {
 	case TypeSNAT:
@@ -252,7 +328,6 @@ func applyRule(rule Rule, pkt network.Packet) (network.Packet, ConnValue, ConnKe
 			Target:         "dst",
 			TranslatedIP:   originalSrcIP,
 			TranslatedPort: originalSrcPort,
-			RuleIndex:      -1,
 		}
 	case TypeDNAT:
 		originalDstIP := pkt.Metadata.DstIP
@@ -279,7 +354,6 @@ func applyRule(rule Rule, pkt network.Packet) (network.Packet, ConnValue, ConnKe
 			Target:         "src",
 			TranslatedIP:   originalDstIP,
 			TranslatedPort: originalDstPort,
-			RuleIndex:      -1,
 		}
 	}
 
@@ -329,10 +403,26 @@ func packetProtoKey(meta network.PacketMetadata) uint8 {
 }
 
 func normalizeRule(rule Rule) Rule {
-	rule.hasSrcNet = rule.SrcNet != nil
-	rule.hasDstNet = rule.DstNet != nil
-	rule.hasSrcPort = rule.SrcPort != 0
-	rule.hasDstPort = rule.DstPort != 0
+	if rule.SrcAddrs.IsZero() {
+		rule.SrcAddrs = network.NetMatch(rule.SrcNet)
+	}
+	if rule.DstAddrs.IsZero() {
+		rule.DstAddrs = network.NetMatch(rule.DstNet)
+	}
+	if rule.SrcPorts.IsZero() {
+		rule.SrcPorts = network.SinglePort(rule.SrcPort)
+	}
+	if rule.DstPorts.IsZero() {
+		rule.DstPorts = network.SinglePort(rule.DstPort)
+	}
+	rule.SrcNet = rule.SrcAddrs.Single()
+	rule.DstNet = rule.DstAddrs.Single()
+	rule.SrcPort, _ = rule.SrcPorts.Single()
+	rule.DstPort, _ = rule.DstPorts.Single()
+	rule.SrcSet = ipset.NormalizeRef(rule.SrcSet)
+	rule.DstSet = ipset.NormalizeRef(rule.DstSet)
+	rule.Schedule = strings.TrimSpace(rule.Schedule)
+	rule.Description = strings.TrimSpace(rule.Description)
 	return rule
 }
 
@@ -348,34 +438,19 @@ func rulesEqual(a Rule, b Rule) bool {
 	if a.Type != b.Type {
 		return false
 	}
-	if a.SrcPort != b.SrcPort || a.DstPort != b.DstPort || a.ToPort != b.ToPort {
-		return false
-	}
-	if !ipNetEqual(a.SrcNet, b.SrcNet) || !ipNetEqual(a.DstNet, b.DstNet) {
+	if !a.SrcPorts.Equal(b.SrcPorts) || !a.DstPorts.Equal(b.DstPorts) || a.ToPort != b.ToPort {
 		return false
 	}
-	if !ipEqual(a.ToIP, b.ToIP) {
+	if !a.SrcAddrs.Equal(b.SrcAddrs) || !a.DstAddrs.Equal(b.DstAddrs) {
 		return false
 	}
-	return true
-}
-
-func ipNetEqual(a *net.IPNet, b *net.IPNet) bool {
-	if a == nil && b == nil {
-		return true
-	}
-	if a == nil || b == nil {
+	if a.SrcSet != b.SrcSet || a.DstSet != b.DstSet || a.Schedule != b.Schedule {
 		return false
 	}
-	if len(a.Mask) != len(b.Mask) {
+	if !ipEqual(a.ToIP, b.ToIP) {
 		return false
 	}
-	for i := range a.Mask {
-		if a.Mask[i] != b.Mask[i] {
-			return false
-		}
-	}
-	return ipEqual(a.IP, b.IP)
+	return true
 }
 
 func ipEqual(a net.IP, b net.IP) bool {
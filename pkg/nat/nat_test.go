@@ -4,8 +4,11 @@ import (
 	"encoding/binary"
 	"net"
 	"testing"
+	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
+	"router-go/pkg/schedule"
 )
 
 func TestApplySNAT(t *testing.T) {
@@ -173,6 +176,36 @@ func TestApplyPortFilter(t *testing.T) {
 	}
 }
 
+func TestApplyAddressListsAndPortRanges(t *testing.T) {
+	srcAddrs, _ := network.ParseAddrMatch("10.0.0.0/8,192.168.0.0/16")
+	dstPorts, _ := network.ParsePortMatch("!25,465")
+	table := NewTable([]Rule{
+		{Type: TypeSNAT, SrcAddrs: srcAddrs, DstPorts: dstPorts, ToIP: net.ParseIP("203.0.113.10")},
+	})
+
+	cases := []struct {
+		src  string
+		port int
+		want string
+	}{
+		{"192.168.1.5", 443, "203.0.113.10"},
+		{"10.2.3.4", 80, "203.0.113.10"},
+		{"192.168.1.5", 25, "192.168.1.5"},
+		{"172.16.0.1", 443, "172.16.0.1"},
+	}
+	for _, tc := range cases {
+		out := table.Apply(network.Packet{Metadata: network.PacketMetadata{
+			SrcIP: net.ParseIP(tc.src), DstIP: net.ParseIP("1.1.1.1"), SrcPort: 40000, DstPort: tc.port,
+		}})
+		if out.Metadata.SrcIP.String() != tc.want {
+			t.Fatalf("%s:%d: expected source %s, got %s", tc.src, tc.port, tc.want, out.Metadata.SrcIP)
+		}
+	}
+	if table.Apply(network.Packet{Metadata: network.PacketMetadata{DstPort: 443}}).Metadata.SrcIP != nil {
+		t.Fatalf("expected packet without source address not to match an address list")
+	}
+}
+
 func TestConnectionTrackingReuse(t *testing.T) {
 	_, srcNet, _ := net.ParseCIDR("10.0.0.0/8")
 	table := NewTable([]Rule{
@@ -653,3 +686,99 @@ func checksumIPv4UDP(pkt []byte) uint16 {
 	pseudo = append(pseudo, udp...)
 	return network.Checksum(pseudo)
 }
+
+func TestApplyIPSetSource(t *testing.T) {
+	sets := ipset.NewRegistry()
+	_ = sets.Create("office", ipset.TypeHashNet, 0)
+	member, _ := ipset.ParseMember(ipset.TypeHashNet, "192.168.10.0/24")
+	_ = sets.Add("office", []ipset.Entry{{Prefix: member}})
+	table := NewTable([]Rule{{Type: TypeSNAT, SrcSet: "office", ToIP: net.ParseIP("203.0.113.20")}})
+	table.SetIPSets(sets)
+
+	for src, want := range map[string]string{"192.168.10.5": "203.0.113.20", "192.168.20.5": "192.168.20.5"} {
+		out := table.Apply(network.Packet{Metadata: network.PacketMetadata{
+			SrcIP: net.ParseIP(src), DstIP: net.ParseIP("1.1.1.1"), SrcPort: 40000, DstPort: 443,
+		}})
+		if out.Metadata.SrcIP.String() != want {
+			t.Fatalf("%s: expected source %s, got %s", src, want, out.Metadata.SrcIP)
+		}
+	}
+	if !table.RemoveRule(Rule{Type: TypeSNAT, SrcSet: " office", ToIP: net.ParseIP("203.0.113.20")}) {
+		t.Fatalf("expected set reference to be part of rule identity")
+	}
+}
+
+func TestApplyScheduledPortForward(t *testing.T) {
+	evening, _ := schedule.ParseRange("18:00-23:00")
+	schedules := schedule.NewRegistry([]schedule.Schedule{{Name: "evening", Ranges: []schedule.Range{evening}}}, time.UTC)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	schedules.SetClock(func() time.Time { return now })
+	table := NewTable([]Rule{{Type: TypeDNAT, DstPort: 2222, ToIP: net.ParseIP("192.168.1.50"), ToPort: 22, Schedule: "evening"}})
+	table.SetSchedules(schedules)
+
+	pkt := func(srcPort int) network.Packet {
+		return network.Packet{Metadata: network.PacketMetadata{
+			SrcIP: net.ParseIP("198.51.100.9"), DstIP: net.ParseIP("203.0.113.10"), SrcPort: srcPort, DstPort: 2222, Protocol: "TCP",
+		}}
+	}
+	if out := table.Apply(pkt(40000)); out.Metadata.DstIP.String() != "203.0.113.10" {
+		t.Fatalf("expected no forward outside the schedule, got %s", out.Metadata.DstIP)
+	}
+	now = time.Date(2026, 10, 19, 19, 0, 0, 0, time.UTC)
+	if out := table.Apply(pkt(40001)); out.Metadata.DstIP.String() != "192.168.1.50" || out.Metadata.DstPort != 22 {
+		t.Fatalf("expected forward inside the schedule, got %s:%d", out.Metadata.DstIP, out.Metadata.DstPort)
+	}
+	now = time.Date(2026, 10, 19, 23, 30, 0, 0, time.UTC)
+	if out := table.Apply(pkt(40001)); out.Metadata.DstIP.String() != "192.168.1.50" {
+		t.Fatalf("expected established translation to survive the schedule end, got %s", out.Metadata.DstIP)
+	}
+	if out := table.Apply(pkt(40002)); out.Metadata.DstIP.String() != "203.0.113.10" {
+		t.Fatalf("expected new connections not to be forwarded, got %s", out.Metadata.DstIP)
+	}
+}
+
+func TestRuleIDsAndOrdering(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/8")
+	table := NewTable([]Rule{
+		{ID: 5, Type: TypeSNAT, SrcNet: lan, ToIP: net.ParseIP("203.0.113.10")},
+		{Type: TypeSNAT, SrcNet: lan, ToIP: net.ParseIP("203.0.113.10")},
+	})
+	pkt := network.Packet{
+		Metadata: network.PacketMetadata{
+			Protocol: "TCP",
+			SrcIP:    net.ParseIP("10.1.2.3"),
+			DstIP:    net.ParseIP("1.1.1.1"),
+			SrcPort:  1234,
+			DstPort:  80,
+		},
+	}
+	table.Apply(pkt)
+
+	id, ok := table.InsertRule(Rule{Type: TypeSNAT, SrcNet: lan, ToIP: net.ParseIP("203.0.113.20")}, Placement{Before: 5})
+	if !ok || id != 7 {
+		t.Fatalf("unexpected insert result %d %v", id, ok)
+	}
+	if !table.MoveRule(5, Placement{After: 6}) {
+		t.Fatalf("expected move to succeed")
+	}
+	// The established connection keeps counting on rule 5 after the move.
+	table.Apply(pkt)
+	stats := table.RulesWithStats()
+	if stats[0].Rule.ID != 7 || stats[1].Rule.ID != 6 || stats[2].Rule.ID != 5 || stats[2].Hits != 2 {
+		t.Fatalf("unexpected rules after move: %+v", stats)
+	}
+
+	if !table.SetRuleEnabled(7, false) {
+		t.Fatalf("expected disable to succeed")
+	}
+	pkt.Metadata.SrcPort = 1235
+	if out := table.Apply(pkt); !out.Metadata.SrcIP.Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected disabled rule to be skipped, got %s", out.Metadata.SrcIP)
+	}
+	if !table.RemoveRule(Rule{ID: 6}) || table.RemoveRule(Rule{ID: 6}) {
+		t.Fatalf("expected remove by id to succeed once")
+	}
+	if _, pos, ok := table.RuleByID(5); !ok || pos != 2 {
+		t.Fatalf("expected rule 5 at position 2, got %d", pos)
+	}
+}
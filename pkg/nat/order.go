@@ -0,0 +1,170 @@
+package nat
+
+// Placement says where InsertRule and MoveRule put a rule: before or after
+// the rule with the given ID, or at a 1-based Position. The zero value
+// appends.
+type Placement struct {
+	Before   uint64
+	After    uint64
+	Position int
+}
+
+// InsertRule adds the rule at the given placement and returns its ID. It
+// fails when the placement refers to an unknown rule or position.
+func (t *Table) InsertRule(rule Rule, at Placement) (uint64, bool) {
+	t.mu.Lock()
+	index, ok := t.placementIndex(at)
+	if ok {
+		rule = normalizeRule(rule)
+		rule.ID = t.claimID(rule.ID)
+		t.rules = insertAt(t.rules, index, rule)
+		t.hits = insertAt(t.hits, index, 0)
+		t.reindex()
+	}
+	t.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	t.notifyChange()
+	return rule.ID, true
+}
+
+// MoveRule moves the rule with the given ID, with its hit counter, to a new
+// placement. Placing a rule relative to itself fails.
+func (t *Table) MoveRule(id uint64, at Placement) bool {
+	t.mu.Lock()
+	ok := t.moveLocked(id, at)
+	t.reindex()
+	t.mu.Unlock()
+	if ok {
+		t.notifyChange()
+	}
+	return ok
+}
+
+func (t *Table) moveLocked(id uint64, at Placement) bool {
+	from := t.indexOf(id)
+	if from < 0 || at.Before == id || at.After == id {
+		return false
+	}
+	rule, hits := t.rules[from], t.hits[from]
+	t.rules = append(t.rules[:from], t.rules[from+1:]...)
+	t.hits = append(t.hits[:from], t.hits[from+1:]...)
+	t.reindex()
+	to, ok := t.placementIndex(at)
+	if !ok {
+		to = from
+	}
+	t.rules = insertAt(t.rules, to, rule)
+	t.hits = insertAt(t.hits, to, hits)
+	return ok
+}
+
+// SetRuleEnabled enables or disables the rule with the given ID.
+// Translations the rule already set up are kept.
+func (t *Table) SetRuleEnabled(id uint64, enabled bool) bool {
+	t.mu.Lock()
+	i := t.indexOf(id)
+	if i >= 0 {
+		t.rules[i].Disabled = !enabled
+	}
+	t.mu.Unlock()
+	if i < 0 {
+		return false
+	}
+	t.notifyChange()
+	return true
+}
+
+// RuleByID returns the rule with the given ID and its 1-based position.
+func (t *Table) RuleByID(id uint64) (Rule, int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i := t.indexOf(id)
+	if i < 0 {
+		return Rule{}, 0, false
+	}
+	return t.rules[i], i + 1, true
+}
+
+func (t *Table) indexOf(id uint64) int {
+	if i, ok := t.positions[id]; ok && id != 0 {
+		return i
+	}
+	return -1
+}
+
+// reindex rebuilds the ID to index map after the rule list changed.
+func (t *Table) reindex() {
+	t.positions = make(map[uint64]int, len(t.rules))
+	for i, rule := range t.rules {
+		t.positions[rule.ID] = i
+	}
+}
+
+// find returns the index of the rule with match's ID, or of the first rule
+// equal to match when it has none.
+func (t *Table) find(match Rule) int {
+	if match.ID != 0 {
+		return t.indexOf(match.ID)
+	}
+	match = normalizeRule(match)
+	for i, rule := range t.rules {
+		if rulesEqual(rule, match) {
+			return i
+		}
+	}
+	return -1
+}
+
+// placementIndex resolves a placement to an index in t.rules.
+func (t *Table) placementIndex(at Placement) (int, bool) {
+	switch {
+	case at.Before != 0:
+		i := t.indexOf(at.Before)
+		return i, i >= 0
+	case at.After != 0:
+		i := t.indexOf(at.After)
+		return i + 1, i >= 0
+	case at.Position != 0:
+		return at.Position - 1, at.Position > 0 && at.Position <= len(t.rules)+1
+	default:
+		return len(t.rules), true
+	}
+}
+
+// claimID returns id when it is set and free, the next unused ID otherwise.
+func (t *Table) claimID(id uint64) uint64 {
+	if id == 0 || t.indexOf(id) >= 0 {
+		id = max(t.nextID, 1)
+	}
+	t.nextID = max(t.nextID, id+1)
+	return id
+}
+
+// assignIDs gives rules loaded without an ID, or with one used by an earlier
+// rule, a fresh ID; configured IDs are kept.
+func (t *Table) assignIDs() {
+	for _, rule := range t.rules {
+		t.nextID = max(t.nextID, rule.ID+1)
+	}
+	seen := make(map[uint64]bool, len(t.rules))
+	for i := range t.rules {
+		id := t.rules[i].ID
+		if id == 0 || seen[id] {
+			id = max(t.nextID, 1)
+			t.nextID = id + 1
+			t.rules[i].ID = id
+		}
+		seen[id] = true
+	}
+	t.reindex()
+}
+
+func insertAt[T any](list []T, index int, value T) []T {
+	var zero T
+	list = append(list, zero)
+	copy(list[index+1:], list[index:])
+	list[index] = value
+	return list
+}
@@ -0,0 +1,148 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// GeoResolver maps an address to its ISO 3166 country code and autonomous
+// system number. Unknown values are reported as "" and 0.
+type GeoResolver interface {
+	Geo(ip net.IP) (country string, asn uint32)
+}
+
+// CountryMatch matches a country code against a list, e.g. "CN,RU" or
+// "!DE,AT". The zero value matches any address. An address whose country is
+// unknown never matches a non-zero list, negated or not, so private and
+// unlisted ranges are not caught by "all but" rules.
+type CountryMatch struct {
+	Codes  []string
+	Negate bool
+}
+
+// ASNMatch matches an autonomous system number against a list, e.g.
+// "AS13335,15169" or "!64512". Unknown numbers behave as in CountryMatch.
+type ASNMatch struct {
+	Numbers []uint32
+	Negate  bool
+}
+
+func ParseCountryMatch(value string) (CountryMatch, error) {
+	var m CountryMatch
+	value, m.Negate = cutNegation(value)
+	if value == "" {
+		if m.Negate {
+			return CountryMatch{}, fmt.Errorf("empty negated country list")
+		}
+		return CountryMatch{}, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		code := strings.ToUpper(strings.TrimSpace(part))
+		if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+			return CountryMatch{}, fmt.Errorf("invalid country code %q", strings.TrimSpace(part))
+		}
+		m.Codes = append(m.Codes, code)
+	}
+	return m, nil
+}
+
+func (m CountryMatch) IsZero() bool {
+	return len(m.Codes) == 0
+}
+
+func (m CountryMatch) Matches(country string) bool {
+	if m.IsZero() {
+		return true
+	}
+	if country == "" {
+		return false
+	}
+	return slices.Contains(m.Codes, strings.ToUpper(country)) != m.Negate
+}
+
+func (m CountryMatch) String() string {
+	return negationPrefix(m.Negate && len(m.Codes) > 0) + strings.Join(m.Codes, ",")
+}
+
+func (m CountryMatch) Equal(other CountryMatch) bool {
+	return m.Negate == other.Negate && slices.Equal(m.Codes, other.Codes)
+}
+
+func (m CountryMatch) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *CountryMatch) UnmarshalText(text []byte) error {
+	parsed, err := ParseCountryMatch(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func ParseASNMatch(value string) (ASNMatch, error) {
+	var m ASNMatch
+	value, m.Negate = cutNegation(value)
+	if value == "" {
+		if m.Negate {
+			return ASNMatch{}, fmt.Errorf("empty negated ASN list")
+		}
+		return ASNMatch{}, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		digits := part
+		if len(digits) > 2 && strings.EqualFold(digits[:2], "AS") {
+			digits = digits[2:]
+		}
+		asn, err := strconv.ParseUint(digits, 10, 32)
+		if err != nil || asn == 0 {
+			return ASNMatch{}, fmt.Errorf("invalid ASN %q", part)
+		}
+		m.Numbers = append(m.Numbers, uint32(asn))
+	}
+	return m, nil
+}
+
+func (m ASNMatch) IsZero() bool {
+	return len(m.Numbers) == 0
+}
+
+func (m ASNMatch) Matches(asn uint32) bool {
+	if m.IsZero() {
+		return true
+	}
+	if asn == 0 {
+		return false
+	}
+	return slices.Contains(m.Numbers, asn) != m.Negate
+}
+
+func (m ASNMatch) String() string {
+	parts := make([]string, 0, len(m.Numbers))
+	for _, asn := range m.Numbers {
+		parts = append(parts, strconv.FormatUint(uint64(asn), 10))
+	}
+	return negationPrefix(m.Negate && len(parts) > 0) + strings.Join(parts, ",")
+}
+
+func (m ASNMatch) Equal(other ASNMatch) bool {
+	return m.Negate == other.Negate && slices.Equal(m.Numbers, other.Numbers)
+}
+
+func (m ASNMatch) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *ASNMatch) UnmarshalText(text []byte) error {
+	parsed, err := ParseASNMatch(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
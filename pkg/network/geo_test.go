@@ -0,0 +1,62 @@
+package network
+
+import "testing"
+
+func TestParseCountryMatch(t *testing.T) {
+	m, err := ParseCountryMatch(" cn, RU ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for country, want := range map[string]bool{"CN": true, "ru": true, "DE": false, "": false} {
+		if got := m.Matches(country); got != want {
+			t.Fatalf("country %q: expected %v, got %v", country, want, got)
+		}
+	}
+	if m.String() != "CN,RU" {
+		t.Fatalf("unexpected string %q", m.String())
+	}
+
+	negated, err := ParseCountryMatch("!DE")
+	if err != nil {
+		t.Fatalf("parse negated: %v", err)
+	}
+	if negated.Matches("DE") || !negated.Matches("CN") {
+		t.Fatalf("unexpected negated match")
+	}
+	if negated.Matches("") {
+		t.Fatalf("expected unknown country not to match a negated list")
+	}
+	if !(CountryMatch{}).Matches("") {
+		t.Fatalf("expected zero value to match any address")
+	}
+	for _, bad := range []string{"!", "DEU", "D1"} {
+		if _, err := ParseCountryMatch(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestParseASNMatch(t *testing.T) {
+	m, err := ParseASNMatch("AS13335, 15169")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if !m.Matches(13335) || !m.Matches(15169) || m.Matches(64512) || m.Matches(0) {
+		t.Fatalf("unexpected match")
+	}
+	var text ASNMatch
+	if err := text.UnmarshalText([]byte("!as64512")); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if out, _ := text.MarshalText(); string(out) != "!64512" {
+		t.Fatalf("unexpected text %q", out)
+	}
+	if text.Matches(64512) || !text.Matches(13335) || text.Matches(0) {
+		t.Fatalf("unexpected negated match")
+	}
+	for _, bad := range []string{"AS", "0", "4294967296", "x1"} {
+		if _, err := ParseASNMatch(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
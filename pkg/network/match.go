@@ -0,0 +1,266 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortRange is an inclusive range of transport ports.
+type PortRange struct {
+	From int
+	To   int
+}
+
+// PortMatch matches a transport port against a list of ports and ranges,
+// e.g. "22", "80,443,8000-8080" or "!1024-65535". The zero value matches any
+// port.
+type PortMatch struct {
+	Ranges []PortRange
+	Negate bool
+}
+
+// AddrMatch matches an address against a list of prefixes, e.g.
+// "10.0.0.0/8,192.168.0.0/16" or "!10.0.0.0/8". The zero value matches any
+// address.
+type AddrMatch struct {
+	Nets   []*net.IPNet
+	Negate bool
+}
+
+func ParsePortMatch(value string) (PortMatch, error) {
+	var m PortMatch
+	value, m.Negate = cutNegation(value)
+	if value == "" {
+		if m.Negate {
+			return PortMatch{}, fmt.Errorf("empty negated port list")
+		}
+		return PortMatch{}, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		from, to, isRange := strings.Cut(part, "-")
+		lo, err := parsePort(from)
+		if err != nil {
+			return PortMatch{}, err
+		}
+		hi := lo
+		if isRange {
+			if hi, err = parsePort(to); err != nil {
+				return PortMatch{}, err
+			}
+			if hi < lo {
+				return PortMatch{}, fmt.Errorf("invalid port range %q", part)
+			}
+		}
+		m.Ranges = append(m.Ranges, PortRange{From: lo, To: hi})
+	}
+	return m, nil
+}
+
+// SinglePort converts the legacy single port form; 0 means any port.
+func SinglePort(port int) PortMatch {
+	if port == 0 {
+		return PortMatch{}
+	}
+	return PortMatch{Ranges: []PortRange{{From: port, To: port}}}
+}
+
+func (m PortMatch) IsZero() bool {
+	return len(m.Ranges) == 0
+}
+
+func (m PortMatch) Matches(port int) bool {
+	if m.IsZero() {
+		return true
+	}
+	for _, r := range m.Ranges {
+		if port >= r.From && port <= r.To {
+			return !m.Negate
+		}
+	}
+	return m.Negate
+}
+
+// Single returns the port when the match is exactly one non-negated port.
+func (m PortMatch) Single() (int, bool) {
+	if m.Negate || len(m.Ranges) != 1 || m.Ranges[0].From != m.Ranges[0].To {
+		return 0, false
+	}
+	return m.Ranges[0].From, true
+}
+
+func (m PortMatch) String() string {
+	parts := make([]string, 0, len(m.Ranges))
+	for _, r := range m.Ranges {
+		if r.From == r.To {
+			parts = append(parts, strconv.Itoa(r.From))
+			continue
+		}
+		parts = append(parts, strconv.Itoa(r.From)+"-"+strconv.Itoa(r.To))
+	}
+	return negationPrefix(m.Negate && len(parts) > 0) + strings.Join(parts, ",")
+}
+
+func (m PortMatch) Equal(other PortMatch) bool {
+	if m.Negate != other.Negate || len(m.Ranges) != len(other.Ranges) {
+		return false
+	}
+	for i := range m.Ranges {
+		if m.Ranges[i] != other.Ranges[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m PortMatch) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *PortMatch) UnmarshalText(text []byte) error {
+	parsed, err := ParsePortMatch(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func ParseAddrMatch(value string) (AddrMatch, error) {
+	var m AddrMatch
+	value, m.Negate = cutNegation(value)
+	if value == "" {
+		if m.Negate {
+			return AddrMatch{}, fmt.Errorf("empty negated address list")
+		}
+		return AddrMatch{}, nil
+	}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return AddrMatch{}, fmt.Errorf("invalid address %q", part)
+			}
+			bits := 128
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			m.Nets = append(m.Nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, prefix, err := net.ParseCIDR(part)
+		if err != nil {
+			return AddrMatch{}, fmt.Errorf("invalid prefix %q", part)
+		}
+		m.Nets = append(m.Nets, prefix)
+	}
+	return m, nil
+}
+
+// NetMatch converts the legacy single prefix form; nil means any address.
+func NetMatch(prefix *net.IPNet) AddrMatch {
+	if prefix == nil {
+		return AddrMatch{}
+	}
+	return AddrMatch{Nets: []*net.IPNet{prefix}}
+}
+
+func (m AddrMatch) IsZero() bool {
+	return len(m.Nets) == 0
+}
+
+// Matches reports whether ip is selected. A nil address only matches the
+// zero value.
+func (m AddrMatch) Matches(ip net.IP) bool {
+	if m.IsZero() {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, prefix := range m.Nets {
+		if prefix.Contains(ip) {
+			return !m.Negate
+		}
+	}
+	return m.Negate
+}
+
+// Single returns the prefix when the match is exactly one non-negated prefix.
+func (m AddrMatch) Single() *net.IPNet {
+	if m.Negate || len(m.Nets) != 1 {
+		return nil
+	}
+	return m.Nets[0]
+}
+
+// Families reports which address families the prefixes belong to.
+func (m AddrMatch) Families() (v4 bool, v6 bool) {
+	for _, prefix := range m.Nets {
+		if prefix.IP.To4() != nil {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	return v4, v6
+}
+
+func (m AddrMatch) String() string {
+	parts := make([]string, 0, len(m.Nets))
+	for _, prefix := range m.Nets {
+		parts = append(parts, prefix.String())
+	}
+	return negationPrefix(m.Negate && len(parts) > 0) + strings.Join(parts, ",")
+}
+
+func (m AddrMatch) Equal(other AddrMatch) bool {
+	if m.Negate != other.Negate || len(m.Nets) != len(other.Nets) {
+		return false
+	}
+	for i := range m.Nets {
+		if m.Nets[i].String() != other.Nets[i].String() {
+			return false
+		}
+	}
+	return true
+}
+
+func (m AddrMatch) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *AddrMatch) UnmarshalText(text []byte) error {
+	parsed, err := ParseAddrMatch(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func cutNegation(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if rest, ok := strings.CutPrefix(value, "!"); ok {
+		return strings.TrimSpace(rest), true
+	}
+	return value, false
+}
+
+func negationPrefix(negate bool) string {
+	if negate {
+		return "!"
+	}
+	return ""
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", strings.TrimSpace(value))
+	}
+	return port, nil
+}
@@ -0,0 +1,102 @@
+package network
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParsePortMatch(t *testing.T) {
+	m, err := ParsePortMatch(" 22, 80,1000-2000 ")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for port, want := range map[int]bool{22: true, 80: true, 1000: true, 1500: true, 2000: true, 443: false, 2001: false} {
+		if got := m.Matches(port); got != want {
+			t.Fatalf("port %d: expected %v, got %v", port, want, got)
+		}
+	}
+	if m.String() != "22,80,1000-2000" {
+		t.Fatalf("unexpected string %q", m.String())
+	}
+	if _, ok := m.Single(); ok {
+		t.Fatalf("expected list not to be a single port")
+	}
+
+	negated, err := ParsePortMatch("!1024-65535")
+	if err != nil {
+		t.Fatalf("parse negated: %v", err)
+	}
+	if !negated.Matches(22) || negated.Matches(8080) {
+		t.Fatalf("unexpected negated match")
+	}
+	if negated.String() != "!1024-65535" {
+		t.Fatalf("unexpected negated string %q", negated.String())
+	}
+
+	if port, ok := SinglePort(53).Single(); !ok || port != 53 {
+		t.Fatalf("expected single port 53, got %d %v", port, ok)
+	}
+	if !SinglePort(0).IsZero() || !SinglePort(0).Matches(1) {
+		t.Fatalf("expected port 0 to match any port")
+	}
+	for _, bad := range []string{"0", "70000", "2000-1000", "http", "!", "80,"} {
+		if _, err := ParsePortMatch(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestParseAddrMatch(t *testing.T) {
+	m, err := ParseAddrMatch("10.0.0.0/8, 192.168.1.1,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	for ip, want := range map[string]bool{"10.1.2.3": true, "192.168.1.1": true, "192.168.1.2": false, "2001:db8::1": true, "2001:db9::1": false} {
+		if got := m.Matches(net.ParseIP(ip)); got != want {
+			t.Fatalf("%s: expected %v, got %v", ip, want, got)
+		}
+	}
+	if m.String() != "10.0.0.0/8,192.168.1.1/32,2001:db8::/32" {
+		t.Fatalf("unexpected string %q", m.String())
+	}
+	if v4, v6 := m.Families(); !v4 || !v6 {
+		t.Fatalf("expected both families")
+	}
+	if m.Matches(nil) {
+		t.Fatalf("expected nil address not to match a prefix list")
+	}
+
+	negated, err := ParseAddrMatch("!10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("parse negated: %v", err)
+	}
+	if negated.Matches(net.ParseIP("10.0.0.1")) || !negated.Matches(net.ParseIP("8.8.8.8")) {
+		t.Fatalf("unexpected negated match")
+	}
+	if negated.Single() != nil {
+		t.Fatalf("expected negated match not to have a single prefix")
+	}
+	if !negated.Equal(AddrMatch{Nets: negated.Nets, Negate: true}) || negated.Equal(NetMatch(negated.Nets[0])) {
+		t.Fatalf("unexpected equality")
+	}
+	if _, err := ParseAddrMatch("10.0.0.0/33"); err == nil {
+		t.Fatalf("expected invalid prefix to be rejected")
+	}
+}
+
+func TestMatchTextRoundTrip(t *testing.T) {
+	var ports PortMatch
+	if err := ports.UnmarshalText([]byte("!80,443")); err != nil {
+		t.Fatalf("unmarshal ports: %v", err)
+	}
+	if text, _ := ports.MarshalText(); string(text) != "!80,443" {
+		t.Fatalf("unexpected ports text %q", text)
+	}
+	var addrs AddrMatch
+	if err := addrs.UnmarshalText([]byte("10.0.0.0/8,fd00::/8")); err != nil {
+		t.Fatalf("unmarshal addrs: %v", err)
+	}
+	if text, _ := addrs.MarshalText(); string(text) != "10.0.0.0/8,fd00::/8" {
+		t.Fatalf("unexpected addrs text %q", text)
+	}
+}
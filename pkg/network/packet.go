@@ -12,20 +12,43 @@ type Packet struct {
 	Data             []byte
 	IngressInterface string
 	EgressInterface  string
+	Mark             uint32
+	CTState          CTState
 	Metadata         PacketMetadata
 	Release          func()
 }
 
+// CTState is the connection tracking state of a packet. The zero value means
+// the packet was not tracked.
+type CTState uint8
+
+const (
+	CTNew CTState = 1 << iota
+	CTEstablished
+	CTRelated
+	CTInvalid
+)
+
+const (
+	TCPFlagFIN uint8 = 0x01
+	TCPFlagSYN uint8 = 0x02
+	TCPFlagRST uint8 = 0x04
+	TCPFlagACK uint8 = 0x10
+)
+
 type PacketMetadata struct {
-	SrcIP    net.IP
-	DstIP    net.IP
-	Protocol string
+	SrcIP       net.IP
+	DstIP       net.IP
+	Protocol    string
 	ProtocolNum uint8
-	SrcPort  int
-	DstPort  int
-	Length   int
-	ICMPType int
-	ICMPCode int
+	SrcPort     int
+	DstPort     int
+	Length      int
+	ICMPType    int
+	ICMPCode    int
+	ICMPID      int
+	TCPFlags    uint8
+	DSCP        uint8
 }
 
 type IPv4Header struct {
@@ -105,6 +128,7 @@ func ParseIPv4Metadata(data []byte) (PacketMetadata, error) {
 		SrcIP:  h.SrcIP,
 		DstIP:  h.DstIP,
 		Length: h.TotalLength,
+		DSCP:   data[1] >> 2,
 	}
 
 	meta.ProtocolNum = h.Protocol
@@ -125,12 +149,18 @@ func ParseIPv4Metadata(data []byte) (PacketMetadata, error) {
 		}
 		meta.SrcPort = int(binary.BigEndian.Uint16(data[h.IHL : h.IHL+2]))
 		meta.DstPort = int(binary.BigEndian.Uint16(data[h.IHL+2 : h.IHL+4]))
+		if meta.Protocol == "TCP" && len(data) >= h.IHL+14 {
+			meta.TCPFlags = data[h.IHL+13]
+		}
 	} else if meta.Protocol == "ICMP" {
 		if len(data) < h.IHL+2 {
 			return PacketMetadata{}, ErrPacketTooShort
 		}
 		meta.ICMPType = int(data[h.IHL])
 		meta.ICMPCode = int(data[h.IHL+1])
+		if len(data) >= h.IHL+6 {
+			meta.ICMPID = int(binary.BigEndian.Uint16(data[h.IHL+4 : h.IHL+6]))
+		}
 	}
 
 	return meta, nil
@@ -146,6 +176,7 @@ func ParseIPv6Metadata(data []byte) (PacketMetadata, error) {
 		SrcIP:  h.SrcIP,
 		DstIP:  h.DstIP,
 		Length: h.PayloadLength + 40,
+		DSCP:   (data[0]<<4 | data[1]>>4) >> 2,
 	}
 
 	meta.ProtocolNum = h.NextHeader
@@ -166,12 +197,18 @@ func ParseIPv6Metadata(data []byte) (PacketMetadata, error) {
 		}
 		meta.SrcPort = int(binary.BigEndian.Uint16(data[40:42]))
 		meta.DstPort = int(binary.BigEndian.Uint16(data[42:44]))
+		if meta.Protocol == "TCP" && len(data) >= 40+14 {
+			meta.TCPFlags = data[40+13]
+		}
 	} else if meta.Protocol == "ICMPv6" {
 		if len(data) < 40+2 {
 			return PacketMetadata{}, ErrPacketTooShort
 		}
 		meta.ICMPType = int(data[40])
 		meta.ICMPCode = int(data[41])
+		if len(data) >= 40+6 {
+			meta.ICMPID = int(binary.BigEndian.Uint16(data[44:46]))
+		}
 	}
 
 	return meta, nil
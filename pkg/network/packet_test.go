@@ -81,6 +81,9 @@ func TestParseIPv4MetadataTCPPorts(t *testing.T) {
 	if meta.SrcPort != 8080 || meta.DstPort != 80 {
 		t.Fatalf("unexpected ports: %d -> %d", meta.SrcPort, meta.DstPort)
 	}
+	if meta.TCPFlags != TCPFlagSYN {
+		t.Fatalf("expected SYN flag, got %#x", meta.TCPFlags)
+	}
 }
 
 func TestParseIPv4MetadataUDPPorts(t *testing.T) {
@@ -343,3 +346,38 @@ func TestParseIPv6MetadataICMPv6TypeCode(t *testing.T) {
 		t.Fatalf("unexpected icmpv6 type/code: %d/%d", meta.ICMPType, meta.ICMPCode)
 	}
 }
+
+func TestParseMetadataDSCP(t *testing.T) {
+	ipv4 := []byte{
+		0x45, 0xb8, 0x00, 0x1c,
+		0x00, 0x00, 0x40, 0x00,
+		0x40, 0x01, 0x00, 0x00,
+		0xc0, 0xa8, 0x01, 0x01,
+		0x08, 0x08, 0x08, 0x08,
+		0x08, 0x00, 0x00, 0x00,
+	}
+	meta, err := ParseIPMetadata(ipv4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.DSCP != 46 {
+		t.Fatalf("expected ipv4 dscp 46, got %d", meta.DSCP)
+	}
+
+	ipv6 := []byte{
+		0x6b, 0x80, 0x00, 0x00,
+		0x00, 0x04, 0x3a, 0x40,
+		0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02,
+		0x80, 0x00, 0x00, 0x00,
+	}
+	meta, err = ParseIPMetadata(ipv6)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if meta.DSCP != 46 {
+		t.Fatalf("expected ipv6 dscp 46, got %d", meta.DSCP)
+	}
+}
@@ -0,0 +1,373 @@
+package nftables
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"router-go/pkg/firewall"
+	"router-go/pkg/ipset"
+	"router-go/pkg/nat"
+	"router-go/pkg/schedule"
+)
+
+// ErrUnsupportedRules is returned by Apply when firewall rules match on
+// country or ASN, which nft cannot enforce.
+var ErrUnsupportedRules = errors.New("nftables cannot enforce country or ASN matches")
+
+type Runner interface {
+	Run(ctx context.Context, stdin []byte, args ...string) ([]byte, error)
+}
+
+type ExecRunner struct {
+	Binary string
+}
+
+func (r ExecRunner) Run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	binary := r.Binary
+	if binary == "" {
+		binary = "nft"
+	}
+	cmd := exec.CommandContext(ctx, binary, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", binary, err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", binary, err)
+	}
+	return out, nil
+}
+
+type Config struct {
+	Table           string
+	CounterInterval time.Duration
+}
+
+type Status struct {
+	Table         string    `json:"table"`
+	Applied       bool      `json:"applied"`
+	LastApplied   time.Time `json:"last_applied,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	FirewallRules int       `json:"firewall_rules"`
+	NATRules      int       `json:"nat_rules"`
+}
+
+type Backend struct {
+	mu          sync.Mutex
+	cfg         Config
+	runner      Runner
+	fw          *firewall.Engine
+	natTable    *nat.Table
+	sets        *ipset.Registry
+	schedules   *schedule.Registry
+	trigger     chan struct{}
+	members     chan struct{}
+	pending     []ipset.Change
+	applied     Ruleset
+	hasApplied  bool
+	lastApplied time.Time
+	lastErr     string
+	fwCounters  map[uint64]uint64
+	natCounters map[uint64]uint64
+	nowFunc     func() time.Time
+}
+
+func NewBackend(cfg Config, fw *firewall.Engine, natTable *nat.Table, runner Runner) *Backend {
+	if cfg.Table == "" {
+		cfg.Table = DefaultTable
+	}
+	if cfg.CounterInterval == 0 {
+		cfg.CounterInterval = 5 * time.Second
+	}
+	if runner == nil {
+		runner = ExecRunner{}
+	}
+	return &Backend{
+		cfg:      cfg,
+		runner:   runner,
+		fw:       fw,
+		natTable: natTable,
+		trigger:  make(chan struct{}, 1),
+		members:  make(chan struct{}, 1),
+		nowFunc:  time.Now,
+	}
+}
+
+// SetIPSets adds the ip sets to the rendered table so rules can reference
+// them.
+func (b *Backend) SetIPSets(sets *ipset.Registry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sets = sets
+}
+
+// SetSchedules lets scheduled rules render as time matches.
+func (b *Backend) SetSchedules(schedules *schedule.Registry) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schedules = schedules
+}
+
+func (b *Backend) Table() string {
+	return b.cfg.Table
+}
+
+func (b *Backend) Render() Ruleset {
+	return RenderSpec(b.spec())
+}
+
+func (b *Backend) spec() Spec {
+	spec := Spec{Table: b.cfg.Table}
+	if b.fw != nil {
+		spec.Rules = b.fw.Rules()
+		spec.Defaults = b.fw.DefaultPolicies()
+		spec.Zones = b.fw.Zones()
+		spec.ZonePolicies = b.fw.ZonePolicies()
+	}
+	if b.natTable != nil {
+		spec.NAT = b.natTable.Rules()
+	}
+	b.mu.Lock()
+	spec.Sets = b.sets
+	spec.Schedules = b.schedules
+	b.mu.Unlock()
+	return spec
+}
+
+func (b *Backend) Apply(ctx context.Context) error {
+	// The full render includes every membership change queued so far.
+	b.mu.Lock()
+	b.pending = nil
+	b.mu.Unlock()
+	spec := b.spec()
+	ruleset := RenderSpec(spec)
+	var err error
+	if len(ruleset.Unsupported) > 0 {
+		// Loading the rest would leave those rules silently unenforced.
+		comments := make([]string, len(ruleset.Unsupported))
+		for i, index := range ruleset.Unsupported {
+			comments[i] = FirewallComment(spec.Rules[index].ID)
+		}
+		err = fmt.Errorf("%w: %s", ErrUnsupportedRules, strings.Join(comments, ", "))
+	} else {
+		_, err = b.runner.Run(ctx, []byte(ruleset.Text), "-f", "-")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err != nil {
+		b.lastErr = err.Error()
+		return err
+	}
+	b.applied = ruleset
+	b.hasApplied = true
+	b.lastApplied = b.nowFunc()
+	b.lastErr = ""
+	b.fwCounters = nil
+	b.natCounters = nil
+	return nil
+}
+
+func (b *Backend) SyncCounters(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.hasApplied {
+		b.mu.Unlock()
+		return nil
+	}
+	b.mu.Unlock()
+
+	out, err := b.runner.Run(ctx, nil, "-j", "list", "table", "inet", b.cfg.Table)
+	if err != nil {
+		return err
+	}
+	fwCounts, natCounts, err := parseCounters(out)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Counters are keyed by rule ID, so rules inserted or moved since the
+	// apply keep their own hits; IDs the engines no longer have are dropped
+	// by AddRuleHits, and from the baseline once they leave the kernel.
+	if b.fw != nil {
+		b.fw.AddRuleHits(counterDeltas(b.fwCounters, fwCounts))
+	}
+	if b.natTable != nil {
+		b.natTable.AddRuleHits(counterDeltas(b.natCounters, natCounts))
+	}
+	b.fwCounters = fwCounts
+	b.natCounters = natCounts
+	return nil
+}
+
+func (b *Backend) Trigger() {
+	select {
+	case b.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// UpdateSet queues an ip set membership change. Run sends it as element
+// updates to the loaded sets instead of reloading the table, which would
+// rewrite every member and reset the rule counters.
+func (b *Backend) UpdateSet(change ipset.Change) {
+	b.mu.Lock()
+	b.pending = append(b.pending, change)
+	b.mu.Unlock()
+	select {
+	case b.members <- struct{}{}:
+	default:
+	}
+}
+
+// applySetChanges sends the queued membership changes. It falls back to a
+// full apply when a set is not loaded as it would need to be, or when nft
+// rejects the update, for example for a member the kernel already expired.
+func (b *Backend) applySetChanges(ctx context.Context) error {
+	b.mu.Lock()
+	changes := b.pending
+	b.pending = nil
+	applied := b.hasApplied
+	timedSets := b.applied.timedSets
+	b.mu.Unlock()
+	if len(changes) == 0 {
+		return nil
+	}
+	if !applied {
+		return b.Apply(ctx)
+	}
+	for _, change := range changes {
+		timed, ok := timedSets[change.Set]
+		if !ok {
+			return b.Apply(ctx)
+		}
+		for _, entry := range change.Added {
+			if !timed && !entry.Expires.IsZero() {
+				return b.Apply(ctx)
+			}
+		}
+	}
+	if _, err := b.runner.Run(ctx, []byte(RenderSetChanges(b.cfg.Table, changes)), "-f", "-"); err != nil {
+		return b.Apply(ctx)
+	}
+	return nil
+}
+
+func (b *Backend) Run(ctx context.Context, onError func(error)) {
+	ticker := time.NewTicker(b.cfg.CounterInterval)
+	defer ticker.Stop()
+	b.Trigger()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.trigger:
+			if err := b.Apply(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-b.members:
+			if err := b.applySetChanges(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-ticker.C:
+			if err := b.SyncCounters(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (b *Backend) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return Status{
+		Table:         b.cfg.Table,
+		Applied:       b.hasApplied,
+		LastApplied:   b.lastApplied,
+		LastError:     b.lastErr,
+		FirewallRules: b.applied.FirewallRules,
+		NATRules:      b.applied.NATRules,
+	}
+}
+
+// counterDeltas returns how far each rule's kernel counter moved since the
+// last sync. A counter that went backwards was reset by a reload and counts
+// from zero again.
+func counterDeltas(prev map[uint64]uint64, curr map[uint64]uint64) map[uint64]uint64 {
+	out := make(map[uint64]uint64, len(curr))
+	for id, value := range curr {
+		if value >= prev[id] {
+			value -= prev[id]
+		}
+		if value > 0 {
+			out[id] = value
+		}
+	}
+	return out
+}
+
+func parseCounters(data []byte) (map[uint64]uint64, map[uint64]uint64, error) {
+	var doc struct {
+		Nftables []map[string]json.RawMessage `json:"nftables"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("decode nft json: %w", err)
+	}
+	fwCounts := map[uint64]uint64{}
+	natCounts := map[uint64]uint64{}
+	for _, item := range doc.Nftables {
+		raw, ok := item["rule"]
+		if !ok {
+			continue
+		}
+		var rule struct {
+			Comment string                       `json:"comment"`
+			Expr    []map[string]json.RawMessage `json:"expr"`
+		}
+		if err := json.Unmarshal(raw, &rule); err != nil {
+			continue
+		}
+		target := fwCounts
+		idStr, ok := strings.CutPrefix(rule.Comment, "fw:")
+		if !ok {
+			idStr, ok = strings.CutPrefix(rule.Comment, "nat:")
+			if !ok {
+				continue
+			}
+			target = natCounts
+		}
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, expr := range rule.Expr {
+			counterRaw, ok := expr["counter"]
+			if !ok {
+				continue
+			}
+			var counter struct {
+				Packets uint64 `json:"packets"`
+			}
+			if err := json.Unmarshal(counterRaw, &counter); err != nil {
+				continue
+			}
+			target[id] += counter.Packets
+		}
+	}
+	return fwCounts, natCounts, nil
+}
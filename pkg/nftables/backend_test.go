@@ -0,0 +1,229 @@
+package nftables
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"router-go/pkg/firewall"
+	"router-go/pkg/ipset"
+	"router-go/pkg/nat"
+	"router-go/pkg/network"
+)
+
+type fakeRunner struct {
+	calls   [][]string
+	stdin   []string
+	listOut string
+	err     error
+}
+
+func (f *fakeRunner) Run(_ context.Context, stdin []byte, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, args)
+	f.stdin = append(f.stdin, string(stdin))
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(args) > 0 && args[0] == "-j" {
+		return []byte(f.listOut), nil
+	}
+	return nil, nil
+}
+
+const listJSON = `{"nftables":[
+{"metainfo":{"version":"1.0.9"}},
+{"table":{"family":"inet","name":"routergo"}},
+{"rule":{"family":"inet","table":"routergo","chain":"input","comment":"fw:1","expr":[{"counter":{"packets":%d,"bytes":100}},{"accept":null}]}},
+{"rule":{"family":"inet","table":"routergo","chain":"output","comment":"fw:2","expr":[{"counter":{"packets":2,"bytes":10}},{"drop":null}]}},
+{"rule":{"family":"inet","table":"routergo","chain":"forward","comment":"fw:2","expr":[{"counter":{"packets":3,"bytes":10}},{"drop":null}]}},
+{"rule":{"family":"inet","table":"routergo","chain":"postrouting","comment":"nat:1","expr":[{"counter":{"packets":7,"bytes":10}}]}}
+]}`
+
+func TestBackendApplyAndSyncCounters(t *testing.T) {
+	fw := firewall.NewEngine([]firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+		{Action: firewall.ActionDrop},
+	})
+	natTable := nat.NewTable([]nat.Rule{{Type: nat.TypeSNAT}})
+	runner := &fakeRunner{listOut: strings.Replace(listJSON, "%d", "5", 1)}
+	backend := NewBackend(Config{}, fw, natTable, runner)
+
+	if err := backend.Apply(context.Background()); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if len(runner.calls) != 1 || strings.Join(runner.calls[0], " ") != "-f -" {
+		t.Fatalf("unexpected nft call: %v", runner.calls)
+	}
+	if !strings.Contains(runner.stdin[0], "table inet routergo {") {
+		t.Fatalf("expected ruleset on stdin")
+	}
+
+	if err := backend.SyncCounters(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	stats := fw.RulesWithStats()
+	if stats[0].Hits != 5 || stats[1].Hits != 5 {
+		t.Fatalf("unexpected firewall hits: %+v", stats)
+	}
+	if hits := natTable.RulesWithStats()[0].Hits; hits != 7 {
+		t.Fatalf("expected nat hits 7, got %d", hits)
+	}
+
+	runner.listOut = strings.Replace(listJSON, "%d", "8", 1)
+	if err := backend.SyncCounters(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if hits := fw.RulesWithStats()[0].Hits; hits != 8 {
+		t.Fatalf("expected counters to advance by delta, got %d", hits)
+	}
+}
+
+func TestBackendCountersFollowRuleIDs(t *testing.T) {
+	fw := firewall.NewEngine([]firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+		{Action: firewall.ActionDrop},
+	})
+	natTable := nat.NewTable([]nat.Rule{{Type: nat.TypeSNAT}})
+	runner := &fakeRunner{listOut: strings.Replace(listJSON, "%d", "5", 1)}
+	backend := NewBackend(Config{}, fw, natTable, runner)
+	if err := backend.Apply(context.Background()); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	// The table changes before the next apply: the kernel still reports
+	// counters for the rules as they were loaded.
+	inserted, _ := fw.InsertRule(firewall.Rule{Chain: "INPUT", Action: firewall.ActionDrop}, firewall.Placement{Position: 1})
+	if !fw.RemoveRule(firewall.Rule{Action: firewall.ActionDrop}) {
+		t.Fatalf("expected chainless rule to be removed")
+	}
+	if err := backend.SyncCounters(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	stats := fw.RulesWithStats()
+	if len(stats) != 2 || stats[0].Rule.ID != inserted || stats[0].Hits != 0 || stats[1].Rule.ID != 1 || stats[1].Hits != 5 {
+		t.Fatalf("expected hits to follow rule IDs, got %+v", stats)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(strings.Replace(listJSON, "%d", "9", 1), "\n") {
+		if !strings.Contains(line, `"fw:2"`) {
+			lines = append(lines, line)
+		}
+	}
+	runner.listOut = strings.Join(lines, "\n")
+	if err := backend.SyncCounters(context.Background()); err != nil {
+		t.Fatalf("sync failed: %v", err)
+	}
+	if hits := fw.RulesWithStats()[1].Hits; hits != 9 {
+		t.Fatalf("expected rule 1 to advance by delta, got %d", hits)
+	}
+	if _, ok := backend.fwCounters[2]; ok {
+		t.Fatalf("expected counter of a rule gone from the kernel to be dropped")
+	}
+}
+
+func TestBackendApplyError(t *testing.T) {
+	runner := &fakeRunner{err: errors.New("permission denied")}
+	backend := NewBackend(Config{Table: "edge"}, firewall.NewEngine(nil), nat.NewTable(nil), runner)
+	if err := backend.Apply(context.Background()); err == nil {
+		t.Fatalf("expected apply error")
+	}
+	status := backend.Status()
+	if status.Applied || status.LastError == "" || status.Table != "edge" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if err := backend.SyncCounters(context.Background()); err != nil {
+		t.Fatalf("expected sync to be skipped before first apply")
+	}
+}
+
+func TestBackendRefusesGeoRules(t *testing.T) {
+	countries, _ := network.ParseCountryMatch("CN,RU")
+	fw := firewall.NewEngine([]firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 80},
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: countries},
+	})
+	runner := &fakeRunner{}
+	backend := NewBackend(Config{}, fw, nil, runner)
+	err := backend.Apply(context.Background())
+	if !errors.Is(err, ErrUnsupportedRules) || !strings.Contains(err.Error(), "fw:2") {
+		t.Fatalf("expected unsupported rules error, got %v", err)
+	}
+	if len(runner.calls) != 0 {
+		t.Fatalf("expected nothing to be loaded, got %v", runner.calls)
+	}
+	if status := backend.Status(); status.Applied || !strings.Contains(status.LastError, "country or ASN") {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	fw.SetRuleEnabled(2, false)
+	if err := backend.Apply(context.Background()); err != nil {
+		t.Fatalf("expected disabled geo rule to be left out, got %v", err)
+	}
+}
+
+func TestBackendUpdatesSetMembers(t *testing.T) {
+	sets := ipset.NewRegistry()
+	_ = sets.Create("blocklist", ipset.TypeHashNet, 0)
+	fw := firewall.NewEngine([]firewall.Rule{{Chain: "INPUT", Action: firewall.ActionDrop, SrcSet: "blocklist"}})
+	runner := &fakeRunner{}
+	backend := NewBackend(Config{}, fw, nil, runner)
+	backend.SetIPSets(sets)
+	sets.SetOnChange(backend.Trigger)
+	sets.SetOnMembers(backend.UpdateSet)
+	if err := backend.Apply(context.Background()); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+
+	block4, _ := ipset.ParseMember(ipset.TypeHashNet, "203.0.113.0/24")
+	block6, _ := ipset.ParseMember(ipset.TypeHashNet, "2001:db8::/32")
+	_ = sets.Add("blocklist", []ipset.Entry{{Prefix: block4}, {Prefix: block6}})
+	_, _ = sets.Remove("blocklist", []*net.IPNet{block6})
+	select {
+	case <-backend.trigger:
+		t.Fatalf("expected member changes not to reload the table")
+	default:
+	}
+	if err := backend.applySetChanges(context.Background()); err != nil {
+		t.Fatalf("set update failed: %v", err)
+	}
+	want := "add element inet routergo blocklist_v4 { 203.0.113.0/24 }\n" +
+		"add element inet routergo blocklist_v6 { 2001:db8::/32 }\n" +
+		"delete element inet routergo blocklist_v6 { 2001:db8::/32 }\n"
+	if len(runner.stdin) != 2 || runner.stdin[1] != want {
+		t.Fatalf("expected element updates, got %q", runner.stdin[1:])
+	}
+
+	// The set was loaded without the timeout flag, so a member with an
+	// expiry needs the table to be rendered again.
+	guest, _ := ipset.ParseMember(ipset.TypeHashNet, "198.51.100.7")
+	_ = sets.Add("blocklist", []ipset.Entry{{Prefix: guest, Expires: time.Now().Add(time.Hour)}})
+	if err := backend.applySetChanges(context.Background()); err != nil {
+		t.Fatalf("set update failed: %v", err)
+	}
+	if len(runner.stdin) != 3 || !strings.Contains(runner.stdin[2], "flags interval, timeout") {
+		t.Fatalf("expected a full apply, got %q", runner.stdin[2:])
+	}
+}
+
+func TestBackendTriggerOnRuleChange(t *testing.T) {
+	fw := firewall.NewEngine(nil)
+	backend := NewBackend(Config{}, fw, nat.NewTable(nil), &fakeRunner{})
+	fw.SetOnChange(backend.Trigger)
+
+	fw.AddRule(firewall.Rule{Chain: "INPUT", Action: firewall.ActionAccept})
+	fw.AddRule(firewall.Rule{Chain: "INPUT", Action: firewall.ActionDrop})
+	select {
+	case <-backend.trigger:
+	default:
+		t.Fatalf("expected pending apply trigger")
+	}
+	select {
+	case <-backend.trigger:
+		t.Fatalf("expected triggers to be coalesced")
+	default:
+	}
+}
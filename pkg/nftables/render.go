@@ -0,0 +1,745 @@
+package nftables
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"router-go/pkg/conntrack"
+	"router-go/pkg/firewall"
+	"router-go/pkg/ipset"
+	"router-go/pkg/nat"
+	"router-go/pkg/network"
+	"router-go/pkg/schedule"
+)
+
+const DefaultTable = "routergo"
+
+var filterChains = []string{"INPUT", "OUTPUT", "FORWARD"}
+
+type Ruleset struct {
+	Table         string `json:"table"`
+	Text          string `json:"ruleset"`
+	FirewallRules int    `json:"firewall_rules"`
+	NATRules      int    `json:"nat_rules"`
+	Skipped       []int  `json:"skipped,omitempty"`
+	// Unsupported lists enabled firewall rules with country or ASN
+	// matches; nft cannot enforce them, so the backend refuses to apply a
+	// ruleset that has any.
+	Unsupported []int `json:"unsupported,omitempty"`
+	// timedSets records, per rendered ip set, whether it has the timeout
+	// flag that members with an expiry need.
+	timedSets map[string]bool
+}
+
+// Spec describes everything a ruleset is rendered from.
+type Spec struct {
+	Table        string
+	Rules        []firewall.Rule
+	Defaults     map[string]firewall.Action
+	Zones        []firewall.Zone
+	ZonePolicies []firewall.ZonePolicy
+	Sets         *ipset.Registry
+	Schedules    *schedule.Registry
+	NAT          []nat.Rule
+}
+
+func Render(table string, rules []firewall.Rule, defaults map[string]firewall.Action, natRules []nat.Rule) Ruleset {
+	return RenderSpec(Spec{Table: table, Rules: rules, Defaults: defaults, NAT: natRules})
+}
+
+// RenderWithZones renders zone selectors as interface sets and appends the
+// zone defaults to each chain after the firewall rules.
+func RenderWithZones(table string, rules []firewall.Rule, defaults map[string]firewall.Action, zones []firewall.Zone, policies []firewall.ZonePolicy, natRules []nat.Rule) Ruleset {
+	return RenderSpec(Spec{Table: table, Rules: rules, Defaults: defaults, Zones: zones, ZonePolicies: policies, NAT: natRules})
+}
+
+// renderer holds the lookups shared by rule rendering: zone interfaces, the
+// names of the ip sets defined in the table and the schedules.
+type renderer struct {
+	zoneIfaces map[string][]string
+	sets       map[string]bool
+	schedules  *schedule.Registry
+}
+
+func RenderSpec(spec Spec) Ruleset {
+	table := spec.Table
+	if table == "" {
+		table = DefaultTable
+	}
+	rules, natRules := spec.Rules, spec.NAT
+	out := Ruleset{
+		Table:         table,
+		FirewallRules: len(rules),
+		NATRules:      len(natRules),
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "table inet %s\n", table)
+	fmt.Fprintf(&b, "delete table inet %s\n", table)
+	fmt.Fprintf(&b, "table inet %s {\n", table)
+
+	r := renderer{
+		zoneIfaces: make(map[string][]string, len(spec.Zones)),
+		sets:       map[string]bool{},
+		schedules:  spec.Schedules,
+	}
+	for _, zone := range spec.Zones {
+		r.zoneIfaces[strings.ToLower(zone.Name)] = zone.Interfaces
+	}
+	if spec.Sets != nil {
+		for _, info := range spec.Sets.List() {
+			set, ok := spec.Sets.Get(info.Name)
+			if !ok {
+				continue
+			}
+			r.sets[info.Name] = true
+			if out.timedSets == nil {
+				out.timedSets = map[string]bool{}
+			}
+			out.timedSets[info.Name] = writeSet(&b, set)
+		}
+	}
+	zoneDefaults := firewall.ZoneDefaultRules(spec.Zones, spec.ZonePolicies)
+	placed := make([]bool, len(rules))
+	for i, rule := range rules {
+		if !rule.Disabled && geoRule(rule) {
+			placed[i] = true
+			out.Unsupported = append(out.Unsupported, i)
+		}
+	}
+	// Chains reached by JUMP and GOTO are regular chains without a hook,
+	// written first so the hook chains can refer to them.
+	for _, chain := range jumpTargets(rules) {
+		fmt.Fprintf(&b, "\tchain %s {\n", UserChainName(chain))
+		for i, rule := range rules {
+			if strings.ToUpper(strings.TrimSpace(rule.Chain)) != chain {
+				continue
+			}
+			placed[i] = r.writeFirewallRule(&b, rule) || placed[i]
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, chain := range filterChains {
+		policy := spec.Defaults[chain]
+		if policy == "" {
+			policy = firewall.ActionDrop
+		}
+		chainPolicy := "drop"
+		if policy == firewall.ActionAccept {
+			chainPolicy = "accept"
+		}
+		fmt.Fprintf(&b, "\tchain %s {\n", strings.ToLower(chain))
+		fmt.Fprintf(&b, "\t\ttype filter hook %s priority filter; policy %s;\n", strings.ToLower(chain), chainPolicy)
+		for i, rule := range rules {
+			ruleChain := strings.ToUpper(rule.Chain)
+			if ruleChain != "" && ruleChain != chain {
+				continue
+			}
+			placed[i] = r.writeFirewallRule(&b, rule) || placed[i]
+		}
+		for i, rule := range zoneDefaults {
+			if rule.Chain != chain {
+				continue
+			}
+			for _, line := range r.firewallRuleLines(rule, ZoneComment(i)) {
+				fmt.Fprintf(&b, "\t\t%s\n", line)
+			}
+		}
+		if policy == firewall.ActionReject {
+			b.WriteString("\t\tcounter reject comment \"default\"\n")
+		}
+		b.WriteString("\t}\n")
+	}
+	for i, ok := range placed {
+		if !ok {
+			out.Skipped = append(out.Skipped, i)
+		}
+	}
+
+	b.WriteString("\tchain prerouting {\n")
+	b.WriteString("\t\ttype nat hook prerouting priority dstnat; policy accept;\n")
+	for _, rule := range natRules {
+		if rule.Type != nat.TypeDNAT || rule.Disabled {
+			continue
+		}
+		for _, line := range r.natRuleLines(rule) {
+			fmt.Fprintf(&b, "\t\t%s\n", line)
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("\tchain postrouting {\n")
+	b.WriteString("\t\ttype nat hook postrouting priority srcnat; policy accept;\n")
+	for _, rule := range natRules {
+		if rule.Type != nat.TypeSNAT || rule.Disabled {
+			continue
+		}
+		for _, line := range r.natRuleLines(rule) {
+			fmt.Fprintf(&b, "\t\t%s\n", line)
+		}
+	}
+	b.WriteString("\t}\n")
+	b.WriteString("}\n")
+
+	out.Text = b.String()
+	return out
+}
+
+// writeFirewallRule writes the nft rules for rule and reports
+// whether it was placed. Disabled rules are left out on purpose and count as
+// placed, so they are not reported as skipped.
+func (r *renderer) writeFirewallRule(b *strings.Builder, rule firewall.Rule) bool {
+	if rule.Disabled {
+		return true
+	}
+	lines := r.firewallRuleLines(rule, FirewallComment(rule.ID))
+	for _, line := range lines {
+		fmt.Fprintf(b, "\t\t%s\n", line)
+	}
+	return len(lines) > 0
+}
+
+func geoRule(rule firewall.Rule) bool {
+	return !rule.SrcCountry.IsZero() || !rule.DstCountry.IsZero() || !rule.SrcASN.IsZero()
+}
+
+// jumpTargets lists the chains enabled JUMP and GOTO rules lead to, in the
+// order they are first referenced.
+func jumpTargets(rules []firewall.Rule) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, rule := range rules {
+		if rule.Disabled || (rule.Action != firewall.ActionJump && rule.Action != firewall.ActionGoto) {
+			continue
+		}
+		target := strings.ToUpper(strings.TrimSpace(rule.Target))
+		if target == "" || firewall.IsBuiltinChain(target) || seen[target] {
+			continue
+		}
+		seen[target] = true
+		out = append(out, target)
+	}
+	return out
+}
+
+// writeSet renders an ip set as one nft set per address family; members
+// with an expiry carry their remaining lifetime. It reports whether the sets
+// have the timeout flag.
+func writeSet(b *strings.Builder, set *ipset.Set) bool {
+	entries := set.Entries()
+	timed := set.Timeout() > 0
+	for _, entry := range entries {
+		if !entry.Expires.IsZero() {
+			timed = true
+		}
+	}
+	for _, family := range []string{"ip", "ip6"} {
+		fmt.Fprintf(b, "\tset %s {\n", SetName(set.Name(), family))
+		addrType := "ipv4_addr"
+		if family == "ip6" {
+			addrType = "ipv6_addr"
+		}
+		fmt.Fprintf(b, "\t\ttype %s\n", addrType)
+		var flags []string
+		if set.Type() == ipset.TypeHashNet {
+			flags = append(flags, "interval")
+		}
+		if timed {
+			flags = append(flags, "timeout")
+		}
+		if len(flags) > 0 {
+			fmt.Fprintf(b, "\t\tflags %s\n", strings.Join(flags, ", "))
+		}
+		if set.Timeout() > 0 {
+			fmt.Fprintf(b, "\t\ttimeout %ds\n", int64(set.Timeout()/time.Second))
+		}
+		if elements := setElements(entries)[family]; len(elements) > 0 {
+			fmt.Fprintf(b, "\t\telements = { %s }\n", strings.Join(elements, ", "))
+		}
+		b.WriteString("\t}\n")
+	}
+	return timed
+}
+
+// setElements formats members by address family; members whose expiry has
+// passed are left out.
+func setElements(entries []ipset.Entry) map[string][]string {
+	out := map[string][]string{}
+	for _, entry := range entries {
+		element := entry.Prefix.String()
+		if !entry.Expires.IsZero() {
+			remaining := int64(math.Ceil(time.Until(entry.Expires).Seconds()))
+			if remaining < 1 {
+				continue
+			}
+			element += " timeout " + strconv.FormatInt(remaining, 10) + "s"
+		}
+		family := prefixFamily(entry.Prefix)
+		out[family] = append(out[family], element)
+	}
+	return out
+}
+
+func prefixFamily(prefix *net.IPNet) string {
+	if prefix.IP.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+// RenderSetChanges renders membership changes as element updates against
+// the sets of an applied table, so they do not reload the table.
+func RenderSetChanges(table string, changes []ipset.Change) string {
+	if table == "" {
+		table = DefaultTable
+	}
+	var b strings.Builder
+	for _, change := range changes {
+		if change.Flush {
+			for _, family := range []string{"ip", "ip6"} {
+				fmt.Fprintf(&b, "flush set inet %s %s\n", table, SetName(change.Set, family))
+			}
+		}
+		removed := map[string][]string{}
+		for _, prefix := range change.Removed {
+			family := prefixFamily(prefix)
+			removed[family] = append(removed[family], prefix.String())
+		}
+		added := setElements(change.Added)
+		for _, family := range []string{"ip", "ip6"} {
+			if elements := removed[family]; len(elements) > 0 {
+				fmt.Fprintf(&b, "delete element inet %s %s { %s }\n", table, SetName(change.Set, family), strings.Join(elements, ", "))
+			}
+		}
+		for _, family := range []string{"ip", "ip6"} {
+			if elements := added[family]; len(elements) > 0 {
+				fmt.Fprintf(&b, "add element inet %s %s { %s }\n", table, SetName(change.Set, family), strings.Join(elements, ", "))
+			}
+		}
+	}
+	return b.String()
+}
+
+// firewallRuleLines renders a rule as one nft rule per address family and
+// schedule window it needs; nil means the rule cannot match any packet or,
+// for country and ASN matches nft has no equivalent of, cannot be expressed.
+func (r *renderer) firewallRuleLines(rule firewall.Rule, comment string) []string {
+	if geoRule(rule) {
+		return nil
+	}
+	inZone, ok := zoneMatch("iifname", rule.FromZone, r.zoneIfaces)
+	if !ok {
+		return nil
+	}
+	outZone, ok := zoneMatch("oifname", rule.ToZone, r.zoneIfaces)
+	if !ok {
+		return nil
+	}
+	limited := rule.RateLimit > 0 || rule.ConnLimit > 0
+	var lines []string
+	for _, addrs := range r.familyMatches(ruleAddrs(rule.SrcAddrs, rule.SrcNet), ruleAddrs(rule.DstAddrs, rule.DstNet), rule.SrcSet, rule.DstSet, limited) {
+		for _, when := range r.scheduleMatches(rule.Schedule) {
+			parts := make([]string, 0, 12)
+			if rule.InInterface != "" {
+				parts = append(parts, "iifname "+strconv.Quote(rule.InInterface))
+			}
+			if rule.OutInterface != "" {
+				parts = append(parts, "oifname "+strconv.Quote(rule.OutInterface))
+			}
+			parts = append(parts, inZone...)
+			parts = append(parts, outZone...)
+			if rule.CTState != 0 {
+				parts = append(parts, "ct state "+conntrack.FormatStates(rule.CTState))
+			}
+			parts = append(parts, addrs.parts...)
+			proto := l4Proto(rule.Protocol)
+			if proto != "" {
+				parts = append(parts, "meta l4proto "+proto)
+			}
+			parts = append(parts, portMatch(proto, "sport", rulePorts(rule.SrcPorts, rule.SrcPort))...)
+			parts = append(parts, portMatch(proto, "dport", rulePorts(rule.DstPorts, rule.DstPort))...)
+			parts = append(parts, when...)
+			parts = append(parts, limitMatches(rule, comment, addrs.family)...)
+			quoted := "comment " + strconv.Quote(comment)
+			if rule.Action == firewall.ActionLog {
+				lines = append(lines, strings.Join(append(parts, "counter", logStatement(rule, comment), quoted), " "))
+				continue
+			}
+			if rule.Log && limited {
+				// A separate log rule would update the meters a second
+				// time, so the log goes inline and is not rate limited.
+				parts = append(parts, "counter", "log prefix "+strconv.Quote(comment+" "), verdict(rule), quoted)
+				lines = append(lines, strings.Join(parts, " "))
+				continue
+			}
+			if rule.Log {
+				lines = append(lines, strings.Join(append(parts[:len(parts):len(parts)], logStatement(rule, comment), quoted), " "))
+			}
+			parts = append(parts, "counter", verdict(rule), quoted)
+			lines = append(lines, strings.Join(parts, " "))
+		}
+	}
+	return lines
+}
+
+// logStatement rate limits and logs with the rule comment as prefix. A rule
+// with Log set gets it as a separate rule in front, so the limit does not
+// affect the verdict.
+func logStatement(rule firewall.Rule, comment string) string {
+	rate := rule.LogRate
+	if rate <= 0 {
+		rate = firewall.DefaultLogRate
+	}
+	return fmt.Sprintf("limit rate %d/second log prefix %s", rate, strconv.Quote(comment+" "))
+}
+
+// limitMatches renders RateLimit and ConnLimit as meters keyed by the
+// source address, masked to LimitPrefix, of the given family.
+func limitMatches(rule firewall.Rule, comment string, family string) []string {
+	key := family + " saddr"
+	full := 32
+	if family == "ip6" {
+		full = 128
+	}
+	if rule.LimitPrefix > 0 && rule.LimitPrefix < full {
+		key += " and " + net.IP(net.CIDRMask(rule.LimitPrefix, full)).String()
+	}
+	name := "limit_" + strings.ReplaceAll(comment, ":", "_")
+	var out []string
+	if rule.ConnLimit > 0 {
+		out = append(out, fmt.Sprintf("meter %s_conn_%s { %s ct count over %d }", name, family, key, rule.ConnLimit))
+	}
+	if rule.RateLimit > 0 {
+		burst := rule.RateBurst
+		if burst <= 0 {
+			burst = rule.RateLimit
+		}
+		out = append(out, fmt.Sprintf("meter %s_rate_%s { %s limit rate over %d/second burst %d packets }", name, family, key, rule.RateLimit, burst))
+	}
+	return out
+}
+
+func (r *renderer) natRuleLines(rule nat.Rule) []string {
+	srcPorts := rulePorts(rule.SrcPorts, rule.SrcPort)
+	dstPorts := rulePorts(rule.DstPorts, rule.DstPort)
+	var lines []string
+	for _, addrs := range r.familyMatches(ruleAddrs(rule.SrcAddrs, rule.SrcNet), ruleAddrs(rule.DstAddrs, rule.DstNet), rule.SrcSet, rule.DstSet, false) {
+		for _, when := range r.scheduleMatches(rule.Schedule) {
+			parts := make([]string, 0, 12)
+			parts = append(parts, addrs.parts...)
+			if !srcPorts.IsZero() || !dstPorts.IsZero() || rule.ToPort != 0 {
+				parts = append(parts, "meta l4proto { tcp, udp }")
+			}
+			parts = append(parts, portMatch("", "sport", srcPorts)...)
+			parts = append(parts, portMatch("", "dport", dstPorts)...)
+			parts = append(parts, when...)
+			parts = append(parts, "counter", natStatement(rule), "comment "+strconv.Quote(NATComment(rule.ID)))
+			lines = append(lines, strings.Join(parts, " "))
+		}
+	}
+	return lines
+}
+
+// scheduleMatches renders a schedule as meta day/hour matches, one set per
+// time range; a range past midnight is split at midnight and its second half
+// moved to the following days. nft converts the times with the host
+// timezone. An unknown schedule is never active, so nothing is returned.
+func (r *renderer) scheduleMatches(name string) [][]string {
+	if name == "" {
+		return [][]string{nil}
+	}
+	s, ok := r.schedules.Get(name)
+	if !ok {
+		return nil
+	}
+	if len(s.Ranges) == 0 {
+		return [][]string{dayMatch(s.Days, 0)}
+	}
+	var out [][]string
+	for _, rng := range s.Ranges {
+		if !rng.Wraps() {
+			out = append(out, append(dayMatch(s.Days, 0), hourMatch(rng.Start, rng.End)))
+			continue
+		}
+		out = append(out, append(dayMatch(s.Days, 0), hourMatch(rng.Start, 24*60)))
+		if rng.End > 0 {
+			out = append(out, append(dayMatch(s.Days, 1), hourMatch(0, rng.End)))
+		}
+	}
+	return out
+}
+
+func dayMatch(days []time.Weekday, shift int) []string {
+	if len(days) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(days))
+	for _, day := range days {
+		names = append(names, strconv.Quote(((day + time.Weekday(shift)) % 7).String()))
+	}
+	if len(names) == 1 {
+		return []string{"meta day " + names[0]}
+	}
+	return []string{"meta day { " + strings.Join(names, ", ") + " }"}
+}
+
+// hourMatch renders [start, end) minutes as an inclusive nft hour range.
+func hourMatch(start, end int) string {
+	last := end*60 - 1
+	return fmt.Sprintf("meta hour %q-\"%02d:%02d:%02d\"", schedule.FormatClock(start), last/3600, last/60%60, last%60)
+}
+
+func natStatement(rule nat.Rule) string {
+	port := ""
+	if rule.ToPort != 0 {
+		port = ":" + strconv.Itoa(rule.ToPort)
+	}
+	if rule.ToIP == nil {
+		if rule.Type == nat.TypeSNAT {
+			if port != "" {
+				return "masquerade to " + port
+			}
+			return "masquerade"
+		}
+		if port == "" {
+			return "redirect"
+		}
+		return "redirect to " + port
+	}
+	family := "ip"
+	addr := rule.ToIP.String()
+	if rule.ToIP.To4() == nil {
+		family = "ip6"
+		if port != "" {
+			addr = "[" + addr + "]"
+		}
+	}
+	verb := "dnat"
+	if rule.Type == nat.TypeSNAT {
+		verb = "snat"
+	}
+	return verb + " " + family + " to " + addr + port
+}
+
+// zoneMatch renders a zone selector as an interface name set; a zone without
+// interfaces cannot match.
+func zoneMatch(field string, zone string, zoneIfaces map[string][]string) ([]string, bool) {
+	if zone == "" {
+		return nil, true
+	}
+	ifaces := zoneIfaces[strings.ToLower(zone)]
+	if len(ifaces) == 0 {
+		return nil, false
+	}
+	values := make([]string, 0, len(ifaces))
+	for _, iface := range ifaces {
+		values = append(values, strconv.Quote(iface))
+	}
+	return []string{field + " " + negatedSet(false, values)}, true
+}
+
+func ruleAddrs(list network.AddrMatch, single *net.IPNet) network.AddrMatch {
+	if list.IsZero() {
+		return network.NetMatch(single)
+	}
+	return list
+}
+
+func rulePorts(list network.PortMatch, single int) network.PortMatch {
+	if list.IsZero() {
+		return network.SinglePort(single)
+	}
+	return list
+}
+
+// familyMatch is the address part of a rule for one family, or for both
+// when family is empty.
+type familyMatch struct {
+	family string
+	parts  []string
+}
+
+// familyMatches renders the source and destination address and set matches.
+// An nft address match is bound to one family, so prefix lists that span
+// IPv4 and IPv6, and set references, yield one match set per family;
+// families the lists exclude entirely are dropped. A reference to an
+// unknown set cannot match, so no match set is returned. split asks for one
+// match set per family even when nothing else needs it.
+func (r *renderer) familyMatches(src, dst network.AddrMatch, srcSet, dstSet string, split bool) []familyMatch {
+	srcSet, ok := r.setRef(srcSet)
+	if !ok {
+		return nil
+	}
+	dstSet, ok = r.setRef(dstSet)
+	if !ok {
+		return nil
+	}
+	if !split && src.IsZero() && dst.IsZero() && srcSet == "" && dstSet == "" {
+		return []familyMatch{{}}
+	}
+	var out []familyMatch
+	for _, family := range []string{"ip", "ip6"} {
+		parts, ok := familyAddrMatch(family, "saddr", src)
+		if !ok {
+			continue
+		}
+		dstParts, ok := familyAddrMatch(family, "daddr", dst)
+		if !ok {
+			continue
+		}
+		parts = append(parts, dstParts...)
+		parts = append(parts, setMatch(family, "saddr", srcSet)...)
+		parts = append(parts, setMatch(family, "daddr", dstSet)...)
+		if len(parts) == 0 {
+			proto := "ipv4"
+			if family == "ip6" {
+				proto = "ipv6"
+			}
+			parts = []string{"meta nfproto " + proto}
+		}
+		out = append(out, familyMatch{family: family, parts: parts})
+	}
+	return out
+}
+
+// setRef resolves a set reference against the rendered sets. An unknown set
+// is empty: a plain reference cannot match and a negated one matches all.
+func (r *renderer) setRef(ref string) (string, bool) {
+	name, negate := ipset.ParseRef(ref)
+	if name == "" || r.sets[name] {
+		return ipset.NormalizeRef(ref), true
+	}
+	return "", negate
+}
+
+func setMatch(family string, field string, ref string) []string {
+	if ref == "" {
+		return nil
+	}
+	name, negate := ipset.ParseRef(ref)
+	op := ""
+	if negate {
+		op = "!= "
+	}
+	return []string{family + " " + field + " " + op + "@" + SetName(name, family)}
+}
+
+func familyAddrMatch(family string, field string, m network.AddrMatch) ([]string, bool) {
+	if m.IsZero() {
+		return nil, true
+	}
+	var values []string
+	for _, prefix := range m.Nets {
+		if (prefix.IP.To4() != nil) == (family == "ip") {
+			values = append(values, prefix.String())
+		}
+	}
+	if len(values) == 0 {
+		// A negated list without prefixes of this family matches every
+		// address of it.
+		return nil, m.Negate
+	}
+	return []string{family + " " + field + " " + negatedSet(m.Negate, values)}, true
+}
+
+func portMatch(proto string, field string, m network.PortMatch) []string {
+	if m.IsZero() {
+		return nil
+	}
+	values := make([]string, 0, len(m.Ranges))
+	for _, r := range m.Ranges {
+		if r.From == r.To {
+			values = append(values, strconv.Itoa(r.From))
+			continue
+		}
+		values = append(values, strconv.Itoa(r.From)+"-"+strconv.Itoa(r.To))
+	}
+	value := negatedSet(m.Negate, values)
+	switch proto {
+	case "tcp", "udp":
+		return []string{proto + " " + field + " " + value}
+	default:
+		return []string{"th " + field + " " + value}
+	}
+}
+
+func negatedSet(negate bool, values []string) string {
+	value := values[0]
+	if len(values) > 1 {
+		value = "{ " + strings.Join(values, ", ") + " }"
+	}
+	if negate {
+		return "!= " + value
+	}
+	return value
+}
+
+func l4Proto(proto string) string {
+	switch strings.ToLower(strings.TrimSpace(proto)) {
+	case "":
+		return ""
+	case "icmpv6":
+		return "ipv6-icmp"
+	default:
+		return strings.ToLower(strings.TrimSpace(proto))
+	}
+}
+
+func verdict(rule firewall.Rule) string {
+	switch rule.Action {
+	case firewall.ActionAccept:
+		return "accept"
+	case firewall.ActionReject:
+		return "reject"
+	case firewall.ActionJump:
+		return "jump " + UserChainName(rule.Target)
+	case firewall.ActionGoto:
+		return "goto " + UserChainName(rule.Target)
+	case firewall.ActionReturn:
+		return "return"
+	default:
+		return "drop"
+	}
+}
+
+// SetName is the nft set holding the members of an ip set for one family.
+func SetName(name string, family string) string {
+	if family == "ip6" {
+		return name + "_v6"
+	}
+	return name + "_v4"
+}
+
+// UserChainName is the nft chain for a user-defined firewall chain. The
+// prefix keeps it apart from the hook chains and the name is reduced to the
+// characters nft accepts in an identifier.
+func UserChainName(name string) string {
+	var b strings.Builder
+	b.WriteString("user_")
+	for _, c := range strings.ToLower(strings.TrimSpace(name)) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '-' {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// FirewallComment tags the nft rules of a firewall rule with its ID, which
+// unlike its position survives inserts and deletes between an apply and the
+// next counter sync.
+func FirewallComment(id uint64) string {
+	return "fw:" + strconv.FormatUint(id, 10)
+}
+
+func ZoneComment(index int) string {
+	return "zone:" + strconv.Itoa(index)
+}
+
+func NATComment(id uint64) string {
+	return "nat:" + strconv.FormatUint(id, 10)
+}
@@ -0,0 +1,343 @@
+package nftables
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"router-go/pkg/firewall"
+	"router-go/pkg/ipset"
+	"router-go/pkg/nat"
+	"router-go/pkg/network"
+	"router-go/pkg/schedule"
+)
+
+// ruleIDs numbers rules from 1 the way the engines do, since the rendered
+// comments carry the rule ID.
+func ruleIDs(rules []firewall.Rule) []firewall.Rule {
+	for i := range rules {
+		rules[i].ID = uint64(i + 1)
+	}
+	return rules
+}
+
+func natRuleIDs(rules []nat.Rule) []nat.Rule {
+	for i := range rules {
+		rules[i].ID = uint64(i + 1)
+	}
+	return rules
+}
+
+func TestRenderFirewallRulesAndDefaults(t *testing.T) {
+	_, src, _ := net.ParseCIDR("10.0.0.0/8")
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", SrcNet: src, DstPort: 22, InInterface: "eth0"},
+		{Action: firewall.ActionDrop, Protocol: "UDP", DstPort: 53},
+		{Chain: "CUSTOM", Action: firewall.ActionAccept},
+		{Chain: "FORWARD", Action: firewall.ActionAccept, CTState: network.CTEstablished | network.CTRelated},
+	}
+	defaults := map[string]firewall.Action{
+		"INPUT":   firewall.ActionDrop,
+		"OUTPUT":  firewall.ActionAccept,
+		"FORWARD": firewall.ActionReject,
+	}
+
+	ruleset := Render("", ruleIDs(rules), defaults, nil)
+	if ruleset.Table != DefaultTable {
+		t.Fatalf("expected default table, got %s", ruleset.Table)
+	}
+	text := ruleset.Text
+	for _, want := range []string{
+		"delete table inet routergo",
+		"type filter hook input priority filter; policy drop;",
+		"type filter hook output priority filter; policy accept;",
+		`iifname "eth0" ip saddr 10.0.0.0/8 meta l4proto tcp tcp dport 22 counter accept comment "fw:1"`,
+		`meta l4proto udp udp dport 53 counter drop comment "fw:2"`,
+		`counter reject comment "default"`,
+		`ct state established,related counter accept comment "fw:4"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	if strings.Count(text, `comment "fw:2"`) != 3 {
+		t.Fatalf("expected chainless rule in all three chains")
+	}
+	if len(ruleset.Skipped) != 1 || ruleset.Skipped[0] != 2 {
+		t.Fatalf("expected custom chain rule to be skipped, got %v", ruleset.Skipped)
+	}
+}
+
+func TestRenderNATRules(t *testing.T) {
+	_, src, _ := net.ParseCIDR("192.168.1.0/24")
+	natRules := []nat.Rule{
+		{Type: nat.TypeSNAT, SrcNet: src, ToIP: net.ParseIP("203.0.113.10")},
+		{Type: nat.TypeDNAT, DstPort: 8080, ToIP: net.ParseIP("192.168.1.10"), ToPort: 80},
+		{Type: nat.TypeSNAT, SrcNet: src},
+		{Type: nat.TypeDNAT, DstPort: 8080, ToPort: 3128},
+		{Type: nat.TypeDNAT, DstPort: 8081},
+	}
+
+	text := Render("edge", nil, nil, natRuleIDs(natRules)).Text
+	for _, want := range []string{
+		"table inet edge {",
+		`ip saddr 192.168.1.0/24 counter snat ip to 203.0.113.10 comment "nat:1"`,
+		`meta l4proto { tcp, udp } th dport 8080 counter dnat ip to 192.168.1.10:80 comment "nat:2"`,
+		`ip saddr 192.168.1.0/24 counter masquerade comment "nat:3"`,
+		`th dport 8080 counter redirect to :3128 comment "nat:4"`,
+		`th dport 8081 counter redirect comment "nat:5"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	prerouting := strings.Index(text, "chain prerouting")
+	postrouting := strings.Index(text, "chain postrouting")
+	dnat := strings.Index(text, "nat:2")
+	if !(prerouting < dnat && dnat < postrouting) {
+		t.Fatalf("expected DNAT rule in prerouting chain")
+	}
+}
+
+func TestRenderIPv6(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("2001:db8::/32")
+	rules := []firewall.Rule{{Chain: "FORWARD", Action: firewall.ActionAccept, Protocol: "ICMPv6", DstNet: dst}}
+	natRules := []nat.Rule{{Type: nat.TypeDNAT, ToIP: net.ParseIP("2001:db8::10"), ToPort: 443}}
+
+	text := Render("", ruleIDs(rules), nil, natRuleIDs(natRules)).Text
+	if !strings.Contains(text, "ip6 daddr 2001:db8::/32 meta l4proto ipv6-icmp counter accept") {
+		t.Fatalf("unexpected ipv6 firewall rendering:\n%s", text)
+	}
+	if !strings.Contains(text, "dnat ip6 to [2001:db8::10]:443") {
+		t.Fatalf("unexpected ipv6 nat rendering:\n%s", text)
+	}
+}
+
+func TestRenderMatchLists(t *testing.T) {
+	mixed, _ := network.ParseAddrMatch("10.0.0.0/8,2001:db8::/32")
+	notLocal, _ := network.ParseAddrMatch("!192.168.0.0/16")
+	v6Only, _ := network.ParseAddrMatch("2001:db8::/32")
+	v4Only, _ := network.ParseAddrMatch("10.0.0.0/8")
+	ports, _ := network.ParsePortMatch("80,443,8000-8080")
+	notSSH, _ := network.ParsePortMatch("!22")
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", SrcAddrs: mixed, DstPorts: ports},
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", SrcAddrs: notLocal, DstPorts: notSSH},
+		{Chain: "INPUT", Action: firewall.ActionAccept, SrcAddrs: v4Only, DstAddrs: v6Only},
+	}
+	natRules := []nat.Rule{{Type: nat.TypeSNAT, SrcAddrs: mixed, DstPorts: ports}}
+
+	ruleset := Render("", ruleIDs(rules), nil, natRuleIDs(natRules))
+	text := ruleset.Text
+	for _, want := range []string{
+		`ip saddr 10.0.0.0/8 meta l4proto tcp tcp dport { 80, 443, 8000-8080 } counter accept comment "fw:1"`,
+		`ip6 saddr 2001:db8::/32 meta l4proto tcp tcp dport { 80, 443, 8000-8080 } counter accept comment "fw:1"`,
+		`ip saddr != 192.168.0.0/16 meta l4proto tcp tcp dport != 22 counter drop comment "fw:2"`,
+		`meta nfproto ipv6 meta l4proto tcp tcp dport != 22 counter drop comment "fw:2"`,
+		`ip saddr 10.0.0.0/8 meta l4proto { tcp, udp } th dport { 80, 443, 8000-8080 } counter masquerade comment "nat:1"`,
+		`ip6 saddr 2001:db8::/32 meta l4proto { tcp, udp } th dport { 80, 443, 8000-8080 } counter masquerade comment "nat:1"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, `comment "fw:3"`) || len(ruleset.Skipped) != 1 || ruleset.Skipped[0] != 2 {
+		t.Fatalf("expected rule matching no address family to be skipped, got %v", ruleset.Skipped)
+	}
+}
+
+func TestRenderZones(t *testing.T) {
+	zones := []firewall.Zone{
+		{Name: "lan", Interfaces: []string{"eth1", "eth1.*"}, Input: firewall.ActionAccept, Forward: firewall.ActionAccept},
+		{Name: "wan", Interfaces: []string{"eth0"}},
+	}
+	policies := []firewall.ZonePolicy{{From: "wan", To: "lan", Action: firewall.ActionDrop}}
+	rules := []firewall.Rule{
+		{Chain: "FORWARD", FromZone: "wan", ToZone: "lan", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 443},
+		{Chain: "INPUT", FromZone: "dmz", Action: firewall.ActionAccept},
+	}
+
+	ruleset := RenderWithZones("", ruleIDs(rules), nil, zones, policies, nil)
+	text := ruleset.Text
+	for _, want := range []string{
+		`iifname "eth0" oifname { "eth1", "eth1.*" } meta l4proto tcp tcp dport 443 counter accept comment "fw:1"`,
+		`iifname { "eth1", "eth1.*" } counter accept comment "zone:0"`,
+		`iifname "eth0" oifname { "eth1", "eth1.*" } counter drop comment "zone:1"`,
+		`iifname { "eth1", "eth1.*" } counter accept comment "zone:2"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	if len(ruleset.Skipped) != 1 || ruleset.Skipped[0] != 1 {
+		t.Fatalf("expected rule for undefined zone to be skipped, got %v", ruleset.Skipped)
+	}
+	forward := strings.Index(text, "chain forward")
+	if strings.Index(text, `"fw:1"`) < forward || strings.Index(text, `"zone:1"`) < strings.Index(text, `"fw:1"`) {
+		t.Fatalf("expected zone defaults after the forward rules:\n%s", text)
+	}
+}
+
+func TestRenderIPSets(t *testing.T) {
+	sets := ipset.NewRegistry()
+	_ = sets.Create("blocklist", ipset.TypeHashNet, 0)
+	_ = sets.Create("guests", ipset.TypeHashIP, time.Hour)
+	block4, _ := ipset.ParseMember(ipset.TypeHashNet, "203.0.113.0/24")
+	block6, _ := ipset.ParseMember(ipset.TypeHashNet, "2001:db8::/32")
+	guest, _ := ipset.ParseMember(ipset.TypeHashIP, "192.168.50.10")
+	_ = sets.Add("blocklist", []ipset.Entry{{Prefix: block4}, {Prefix: block6}})
+	_ = sets.Add("guests", []ipset.Entry{{Prefix: guest}})
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionDrop, SrcSet: "blocklist"},
+		{Chain: "FORWARD", Action: firewall.ActionAccept, SrcSet: "guests", DstSet: "!blocklist"},
+		{Chain: "INPUT", Action: firewall.ActionAccept, SrcSet: "missing"},
+	}
+	natRules := []nat.Rule{{Type: nat.TypeSNAT, SrcSet: "guests"}}
+
+	ruleset := RenderSpec(Spec{Rules: ruleIDs(rules), Sets: sets, NAT: natRuleIDs(natRules)})
+	text := ruleset.Text
+	for _, want := range []string{
+		"set blocklist_v4 {\n\t\ttype ipv4_addr\n\t\tflags interval\n\t\telements = { 203.0.113.0/24 }",
+		"set blocklist_v6 {\n\t\ttype ipv6_addr\n\t\tflags interval\n\t\telements = { 2001:db8::/32 }",
+		"set guests_v4 {\n\t\ttype ipv4_addr\n\t\tflags timeout\n\t\ttimeout 3600s\n\t\telements = { 192.168.50.10/32 timeout 3600s }",
+		`ip saddr @blocklist_v4 counter drop comment "fw:1"`,
+		`ip6 saddr @blocklist_v6 counter drop comment "fw:1"`,
+		`ip saddr @guests_v4 ip daddr != @blocklist_v4 counter accept comment "fw:2"`,
+		`ip saddr @guests_v4 counter masquerade comment "nat:1"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	if len(ruleset.Skipped) != 1 || ruleset.Skipped[0] != 2 {
+		t.Fatalf("expected rule referencing an unknown set to be skipped, got %v", ruleset.Skipped)
+	}
+}
+
+func TestRenderSchedules(t *testing.T) {
+	school, _ := schedule.ParseRange("08:00-15:00")
+	night, _ := schedule.ParseRange("22:00-07:00")
+	weekdays, _ := schedule.ParseDays([]string{"weekdays"})
+	friday, _ := schedule.ParseDays([]string{"fri"})
+	schedules := schedule.NewRegistry([]schedule.Schedule{
+		{Name: "school", Days: weekdays, Ranges: []schedule.Range{school}},
+		{Name: "friday-night", Days: friday, Ranges: []schedule.Range{night}},
+	}, time.UTC)
+	v4Only, _ := network.ParseAddrMatch("192.168.1.0/24")
+	rules := []firewall.Rule{
+		{Chain: "FORWARD", Action: firewall.ActionDrop, SrcAddrs: v4Only, Schedule: "school"},
+		{Chain: "FORWARD", Action: firewall.ActionDrop, Schedule: "missing"},
+	}
+	natRules := []nat.Rule{{Type: nat.TypeDNAT, DstPort: 2222, ToIP: net.ParseIP("192.168.1.50"), ToPort: 22, Schedule: "friday-night"}}
+
+	ruleset := RenderSpec(Spec{Rules: ruleIDs(rules), Schedules: schedules, NAT: natRuleIDs(natRules)})
+	text := ruleset.Text
+	for _, want := range []string{
+		`ip saddr 192.168.1.0/24 meta day { "Monday", "Tuesday", "Wednesday", "Thursday", "Friday" } meta hour "08:00"-"14:59:59" counter drop comment "fw:1"`,
+		`meta l4proto { tcp, udp } th dport 2222 meta day "Friday" meta hour "22:00"-"23:59:59" counter dnat ip to 192.168.1.50:22 comment "nat:1"`,
+		`meta l4proto { tcp, udp } th dport 2222 meta day "Saturday" meta hour "00:00"-"06:59:59" counter dnat ip to 192.168.1.50:22 comment "nat:1"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	if len(ruleset.Skipped) != 1 || ruleset.Skipped[0] != 1 {
+		t.Fatalf("expected rule with an unknown schedule to be skipped, got %v", ruleset.Skipped)
+	}
+}
+
+func TestRenderLogRules(t *testing.T) {
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionLog, Protocol: "TCP", DstPort: 22},
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 23, Log: true, LogRate: 3},
+	}
+
+	text := Render("", ruleIDs(rules), nil, nil).Text
+	for _, want := range []string{
+		`meta l4proto tcp tcp dport 22 counter limit rate 10/second log prefix "fw:1 " comment "fw:1"`,
+		`meta l4proto tcp tcp dport 23 limit rate 3/second log prefix "fw:2 " comment "fw:2"` + "\n\t\tmeta l4proto tcp tcp dport 23 counter drop comment \"fw:2\"",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+}
+
+func TestRenderSkipsDisabledRules(t *testing.T) {
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22, Disabled: true},
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 23},
+	}
+	natRules := []nat.Rule{
+		{Type: nat.TypeDNAT, DstPort: 2222, ToIP: net.ParseIP("192.168.1.50"), Disabled: true},
+	}
+
+	out := Render("", ruleIDs(rules), nil, natRuleIDs(natRules))
+	if strings.Contains(out.Text, "dport 22 ") || strings.Contains(out.Text, "dnat") {
+		t.Fatalf("expected disabled rules to be left out:\n%s", out.Text)
+	}
+	if !strings.Contains(out.Text, `counter drop comment "fw:2"`) || len(out.Skipped) != 0 {
+		t.Fatalf("unexpected ruleset %v:\n%s", out.Skipped, out.Text)
+	}
+}
+
+func TestRenderLimitRules(t *testing.T) {
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 22, RateLimit: 3, LimitPrefix: 24},
+		{Chain: "INPUT", Action: firewall.ActionReject, Protocol: "TCP", DstPort: 22, ConnLimit: 4, Log: true},
+	}
+
+	text := Render("", ruleIDs(rules), nil, nil).Text
+	for _, want := range []string{
+		`meta nfproto ipv4 meta l4proto tcp tcp dport 22 meter limit_fw_1_rate_ip { ip saddr and 255.255.255.0 limit rate over 3/second burst 3 packets } counter drop comment "fw:1"`,
+		`meta nfproto ipv6 meta l4proto tcp tcp dport 22 meter limit_fw_1_rate_ip6 { ip6 saddr and ffff:ff00:: limit rate over 3/second burst 3 packets } counter drop comment "fw:1"`,
+		`meter limit_fw_2_conn_ip { ip saddr ct count over 4 } counter log prefix "fw:2 " reject comment "fw:2"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+}
+
+func TestRenderReportsGeoRules(t *testing.T) {
+	countries, _ := network.ParseCountryMatch("CN")
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionDrop, Protocol: "TCP", DstPort: 22, SrcCountry: countries},
+		{Chain: "INPUT", Action: firewall.ActionAccept, Protocol: "TCP", DstPort: 22},
+	}
+	ruleset := Render("", ruleIDs(rules), nil, nil)
+	if strings.Contains(ruleset.Text, `comment "fw:1"`) || len(ruleset.Skipped) != 0 || len(ruleset.Unsupported) != 1 || ruleset.Unsupported[0] != 0 {
+		t.Fatalf("expected country rule to be unsupported, got %v %v", ruleset.Skipped, ruleset.Unsupported)
+	}
+	if !strings.Contains(ruleset.Text, `comment "fw:2"`) {
+		t.Fatalf("expected the other rule to be rendered:\n%s", ruleset.Text)
+	}
+}
+
+func TestRenderUserChains(t *testing.T) {
+	rules := []firewall.Rule{
+		{Chain: "INPUT", Action: firewall.ActionJump, Target: "ssh-guard", Protocol: "TCP", DstPort: 22},
+		{Chain: "ssh-guard", Action: firewall.ActionReturn},
+		{Chain: "ssh-guard", Action: firewall.ActionGoto, Target: "Block.List"},
+		{Chain: "block.list", Action: firewall.ActionDrop},
+		{Chain: "orphan", Action: firewall.ActionAccept},
+	}
+	ruleset := Render("", ruleIDs(rules), nil, nil)
+	text := ruleset.Text
+	for _, want := range []string{
+		"chain user_ssh-guard {\n\t\tcounter return comment \"fw:2\"\n\t\tcounter goto user_block_list comment \"fw:3\"\n\t}",
+		"chain user_block_list {\n\t\tcounter drop comment \"fw:4\"\n\t}",
+		`meta l4proto tcp tcp dport 22 counter jump user_ssh-guard comment "fw:1"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("expected %q in ruleset:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "chain user_ssh-guard") > strings.Index(text, "chain input") {
+		t.Fatalf("expected user chains before the hook chains:\n%s", text)
+	}
+	if len(ruleset.Skipped) != 1 || ruleset.Skipped[0] != 4 {
+		t.Fatalf("expected only the unreferenced chain to be skipped, got %v", ruleset.Skipped)
+	}
+}
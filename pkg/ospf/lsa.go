@@ -0,0 +1,266 @@
+package ospf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	LSARouter  = 1
+	LSANetwork = 2
+
+	linkPointToPoint = 1
+	linkTransit      = 2
+	linkStub         = 3
+
+	initialSeq    = int32(-0x7fffffff)
+	maxSeq        = int32(0x7fffffff)
+	maxAge        = 3600
+	maxAgeDiff    = 900
+	lsRefreshTime = 1800
+)
+
+type lsaHeader struct {
+	age       uint16
+	options   uint8
+	typ       uint8
+	id        uint32
+	advRouter uint32
+	seq       int32
+	checksum  uint16
+	length    uint16
+}
+
+type lsaKey struct {
+	typ       uint8
+	id        uint32
+	advRouter uint32
+}
+
+type routerLink struct {
+	id     uint32
+	data   uint32
+	typ    uint8
+	metric uint16
+}
+
+type lsa struct {
+	lsaHeader
+	body      []byte
+	installed time.Time
+	flushed   bool
+}
+
+func (h lsaHeader) key() lsaKey {
+	return lsaKey{typ: h.typ, id: h.id, advRouter: h.advRouter}
+}
+
+func (h lsaHeader) encode() []byte {
+	b := make([]byte, lsaHeaderLen)
+	binary.BigEndian.PutUint16(b[0:], h.age)
+	b[2] = h.options
+	b[3] = h.typ
+	binary.BigEndian.PutUint32(b[4:], h.id)
+	binary.BigEndian.PutUint32(b[8:], h.advRouter)
+	binary.BigEndian.PutUint32(b[12:], uint32(h.seq))
+	binary.BigEndian.PutUint16(b[16:], h.checksum)
+	binary.BigEndian.PutUint16(b[18:], h.length)
+	return b
+}
+
+func decodeLSAHeader(b []byte) lsaHeader {
+	return lsaHeader{
+		age:       binary.BigEndian.Uint16(b[0:]),
+		options:   b[2],
+		typ:       b[3],
+		id:        binary.BigEndian.Uint32(b[4:]),
+		advRouter: binary.BigEndian.Uint32(b[8:]),
+		seq:       int32(binary.BigEndian.Uint32(b[12:])),
+		checksum:  binary.BigEndian.Uint16(b[16:]),
+		length:    binary.BigEndian.Uint16(b[18:]),
+	}
+}
+
+func (k lsaKey) String() string {
+	return fmt.Sprintf("%d/%s/%s", k.typ, u32ToIP(k.id), u32ToIP(k.advRouter))
+}
+
+func newLSA(typ uint8, id uint32, advRouter uint32, seq int32, body []byte) *lsa {
+	l := &lsa{
+		lsaHeader: lsaHeader{
+			options:   optionE,
+			typ:       typ,
+			id:        id,
+			advRouter: advRouter,
+			seq:       seq,
+			length:    uint16(lsaHeaderLen + len(body)),
+		},
+		body: body,
+	}
+	l.checksum = lsaChecksum(l.raw())
+	return l
+}
+
+func decodeLSA(b []byte) (*lsa, error) {
+	if len(b) < lsaHeaderLen {
+		return nil, errors.New("lsa too short")
+	}
+	h := decodeLSAHeader(b)
+	if int(h.length) != len(b) {
+		return nil, errors.New("invalid lsa length")
+	}
+	if !fletcherValid(b[2:]) {
+		return nil, errors.New("invalid lsa checksum")
+	}
+	body := make([]byte, len(b)-lsaHeaderLen)
+	copy(body, b[lsaHeaderLen:])
+	return &lsa{lsaHeader: h, body: body}, nil
+}
+
+func (l *lsa) raw() []byte {
+	return append(l.lsaHeader.encode(), l.body...)
+}
+
+func (l *lsa) currentAge(now time.Time) uint16 {
+	if l.installed.IsZero() {
+		return l.age
+	}
+	age := int(l.age) + int(now.Sub(l.installed)/time.Second)
+	if age > maxAge {
+		age = maxAge
+	}
+	return uint16(age)
+}
+
+func (l *lsa) header(now time.Time) lsaHeader {
+	h := l.lsaHeader
+	h.age = l.currentAge(now)
+	return h
+}
+
+func (l *lsa) wire(now time.Time) []byte {
+	h := l.header(now)
+	if h.age < maxAge {
+		h.age++
+	}
+	return append(h.encode(), l.body...)
+}
+
+func compareLSA(a lsaHeader, b lsaHeader) int {
+	switch {
+	case a.seq != b.seq:
+		if a.seq > b.seq {
+			return 1
+		}
+		return -1
+	case a.checksum != b.checksum:
+		if a.checksum > b.checksum {
+			return 1
+		}
+		return -1
+	case a.age == maxAge && b.age != maxAge:
+		return 1
+	case b.age == maxAge && a.age != maxAge:
+		return -1
+	case int(a.age)-int(b.age) > maxAgeDiff:
+		return -1
+	case int(b.age)-int(a.age) > maxAgeDiff:
+		return 1
+	}
+	return 0
+}
+
+func encodeRouterLinks(links []routerLink) []byte {
+	b := make([]byte, 4, 4+12*len(links))
+	binary.BigEndian.PutUint16(b[2:], uint16(len(links)))
+	for _, link := range links {
+		entry := make([]byte, 12)
+		binary.BigEndian.PutUint32(entry[0:], link.id)
+		binary.BigEndian.PutUint32(entry[4:], link.data)
+		entry[8] = link.typ
+		binary.BigEndian.PutUint16(entry[10:], link.metric)
+		b = append(b, entry...)
+	}
+	return b
+}
+
+func decodeRouterLinks(b []byte) ([]routerLink, error) {
+	if len(b) < 4 {
+		return nil, errors.New("invalid router lsa")
+	}
+	count := int(binary.BigEndian.Uint16(b[2:]))
+	b = b[4:]
+	out := make([]routerLink, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < 12 {
+			return nil, errors.New("truncated router lsa")
+		}
+		tos := int(b[9])
+		link := routerLink{
+			id:     binary.BigEndian.Uint32(b[0:]),
+			data:   binary.BigEndian.Uint32(b[4:]),
+			typ:    b[8],
+			metric: binary.BigEndian.Uint16(b[10:]),
+		}
+		if len(b) < 12+4*tos {
+			return nil, errors.New("truncated router lsa")
+		}
+		b = b[12+4*tos:]
+		out = append(out, link)
+	}
+	return out, nil
+}
+
+func encodeNetwork(mask uint32, attached []uint32) []byte {
+	b := make([]byte, 4+4*len(attached))
+	binary.BigEndian.PutUint32(b, mask)
+	for i, id := range attached {
+		binary.BigEndian.PutUint32(b[4+4*i:], id)
+	}
+	return b
+}
+
+func decodeNetwork(b []byte) (uint32, []uint32, error) {
+	if len(b) < 4 || len(b)%4 != 0 {
+		return 0, nil, errors.New("invalid network lsa")
+	}
+	mask := binary.BigEndian.Uint32(b)
+	var attached []uint32
+	for off := 4; off < len(b); off += 4 {
+		attached = append(attached, binary.BigEndian.Uint32(b[off:]))
+	}
+	return mask, attached, nil
+}
+
+// lsaChecksum is the ISO 8473 Fletcher checksum over the LSA without its age field.
+func lsaChecksum(b []byte) uint16 {
+	data := make([]byte, len(b)-2)
+	copy(data, b[2:])
+	const off = 14
+	data[off], data[off+1] = 0, 0
+	var c0, c1 int
+	for _, v := range data {
+		c0 = (c0 + int(v)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	x := ((len(data)-off-1)*c0 - c1) % 255
+	if x <= 0 {
+		x += 255
+	}
+	y := 510 - c0 - x
+	if y > 255 {
+		y -= 255
+	}
+	return uint16(x)<<8 | uint16(y)
+}
+
+func fletcherValid(data []byte) bool {
+	var c0, c1 int
+	for _, v := range data {
+		c0 = (c0 + int(v)) % 255
+		c1 = (c1 + c0) % 255
+	}
+	return c0 == 0 && c1 == 0
+}
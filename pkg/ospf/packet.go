@@ -0,0 +1,270 @@
+package ospf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	ospfVersion  = 2
+	headerLen    = 24
+	lsaHeaderLen = 20
+
+	typeHello = 1
+	typeDBD   = 2
+	typeLSR   = 3
+	typeLSU   = 4
+	typeLSAck = 5
+
+	optionE = 0x02
+
+	dbdMS   = 0x01
+	dbdMore = 0x02
+	dbdInit = 0x04
+)
+
+var (
+	AllSPFRouters = net.IPv4(224, 0, 0, 5).To4()
+	AllDRouters   = net.IPv4(224, 0, 0, 6).To4()
+)
+
+type packet struct {
+	typ      uint8
+	routerID uint32
+	areaID   uint32
+	body     []byte
+}
+
+type hello struct {
+	mask          uint32
+	helloInterval uint16
+	options       uint8
+	priority      uint8
+	deadInterval  uint32
+	dr            uint32
+	bdr           uint32
+	neighbors     []uint32
+}
+
+type dbd struct {
+	mtu     uint16
+	options uint8
+	flags   uint8
+	seq     uint32
+	headers []lsaHeader
+}
+
+type lsaRequest struct {
+	typ       uint8
+	id        uint32
+	advRouter uint32
+}
+
+func encodePacket(typ uint8, routerID uint32, areaID uint32, body []byte) []byte {
+	b := make([]byte, headerLen+len(body))
+	b[0] = ospfVersion
+	b[1] = typ
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+	binary.BigEndian.PutUint32(b[4:], routerID)
+	binary.BigEndian.PutUint32(b[8:], areaID)
+	copy(b[headerLen:], body)
+	binary.BigEndian.PutUint16(b[12:], ipChecksum(b))
+	return b
+}
+
+func decodePacket(b []byte) (packet, error) {
+	if len(b) < headerLen {
+		return packet{}, errors.New("ospf packet too short")
+	}
+	if b[0] != ospfVersion {
+		return packet{}, fmt.Errorf("unsupported ospf version %d", b[0])
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length < headerLen || length > len(b) {
+		return packet{}, errors.New("invalid ospf packet length")
+	}
+	b = b[:length]
+	if autype := binary.BigEndian.Uint16(b[14:]); autype != 0 {
+		return packet{}, fmt.Errorf("unsupported ospf authentication type %d", autype)
+	}
+	check := make([]byte, length)
+	copy(check, b)
+	for i := 16; i < headerLen; i++ {
+		check[i] = 0
+	}
+	if ipChecksum(check) != 0 {
+		return packet{}, errors.New("invalid ospf checksum")
+	}
+	return packet{
+		typ:      b[1],
+		routerID: binary.BigEndian.Uint32(b[4:]),
+		areaID:   binary.BigEndian.Uint32(b[8:]),
+		body:     b[headerLen:],
+	}, nil
+}
+
+func encodeHello(h hello) []byte {
+	b := make([]byte, 20+4*len(h.neighbors))
+	binary.BigEndian.PutUint32(b[0:], h.mask)
+	binary.BigEndian.PutUint16(b[4:], h.helloInterval)
+	b[6] = h.options
+	b[7] = h.priority
+	binary.BigEndian.PutUint32(b[8:], h.deadInterval)
+	binary.BigEndian.PutUint32(b[12:], h.dr)
+	binary.BigEndian.PutUint32(b[16:], h.bdr)
+	for i, id := range h.neighbors {
+		binary.BigEndian.PutUint32(b[20+4*i:], id)
+	}
+	return b
+}
+
+func decodeHello(b []byte) (hello, error) {
+	if len(b) < 20 || (len(b)-20)%4 != 0 {
+		return hello{}, errors.New("invalid ospf hello")
+	}
+	h := hello{
+		mask:          binary.BigEndian.Uint32(b[0:]),
+		helloInterval: binary.BigEndian.Uint16(b[4:]),
+		options:       b[6],
+		priority:      b[7],
+		deadInterval:  binary.BigEndian.Uint32(b[8:]),
+		dr:            binary.BigEndian.Uint32(b[12:]),
+		bdr:           binary.BigEndian.Uint32(b[16:]),
+	}
+	for off := 20; off < len(b); off += 4 {
+		h.neighbors = append(h.neighbors, binary.BigEndian.Uint32(b[off:]))
+	}
+	return h, nil
+}
+
+func encodeDBD(d dbd) []byte {
+	b := make([]byte, 8, 8+lsaHeaderLen*len(d.headers))
+	binary.BigEndian.PutUint16(b[0:], d.mtu)
+	b[2] = d.options
+	b[3] = d.flags
+	binary.BigEndian.PutUint32(b[4:], d.seq)
+	for _, h := range d.headers {
+		b = append(b, h.encode()...)
+	}
+	return b
+}
+
+func decodeDBD(b []byte) (dbd, error) {
+	if len(b) < 8 || (len(b)-8)%lsaHeaderLen != 0 {
+		return dbd{}, errors.New("invalid ospf database description")
+	}
+	d := dbd{
+		mtu:     binary.BigEndian.Uint16(b[0:]),
+		options: b[2],
+		flags:   b[3],
+		seq:     binary.BigEndian.Uint32(b[4:]),
+	}
+	for off := 8; off < len(b); off += lsaHeaderLen {
+		d.headers = append(d.headers, decodeLSAHeader(b[off:]))
+	}
+	return d, nil
+}
+
+func encodeLSR(reqs []lsaRequest) []byte {
+	b := make([]byte, 12*len(reqs))
+	for i, req := range reqs {
+		binary.BigEndian.PutUint32(b[12*i:], uint32(req.typ))
+		binary.BigEndian.PutUint32(b[12*i+4:], req.id)
+		binary.BigEndian.PutUint32(b[12*i+8:], req.advRouter)
+	}
+	return b
+}
+
+func decodeLSR(b []byte) ([]lsaRequest, error) {
+	if len(b)%12 != 0 {
+		return nil, errors.New("invalid ospf link state request")
+	}
+	out := make([]lsaRequest, 0, len(b)/12)
+	for off := 0; off < len(b); off += 12 {
+		out = append(out, lsaRequest{
+			typ:       uint8(binary.BigEndian.Uint32(b[off:])),
+			id:        binary.BigEndian.Uint32(b[off+4:]),
+			advRouter: binary.BigEndian.Uint32(b[off+8:]),
+		})
+	}
+	return out, nil
+}
+
+func encodeLSU(lsas [][]byte) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(len(lsas)))
+	for _, raw := range lsas {
+		b = append(b, raw...)
+	}
+	return b
+}
+
+func decodeLSU(b []byte) ([][]byte, error) {
+	if len(b) < 4 {
+		return nil, errors.New("invalid ospf link state update")
+	}
+	count := int(binary.BigEndian.Uint32(b))
+	b = b[4:]
+	out := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		if len(b) < lsaHeaderLen {
+			return nil, errors.New("truncated ospf link state update")
+		}
+		length := int(binary.BigEndian.Uint16(b[18:]))
+		if length < lsaHeaderLen || length > len(b) {
+			return nil, errors.New("invalid lsa length")
+		}
+		out = append(out, b[:length])
+		b = b[length:]
+	}
+	return out, nil
+}
+
+func encodeLSAck(headers []lsaHeader) []byte {
+	b := make([]byte, 0, lsaHeaderLen*len(headers))
+	for _, h := range headers {
+		b = append(b, h.encode()...)
+	}
+	return b
+}
+
+func decodeLSAck(b []byte) ([]lsaHeader, error) {
+	if len(b)%lsaHeaderLen != 0 {
+		return nil, errors.New("invalid ospf link state ack")
+	}
+	var out []lsaHeader
+	for off := 0; off < len(b); off += lsaHeaderLen {
+		out = append(out, decodeLSAHeader(b[off:]))
+	}
+	return out, nil
+}
+
+func ipChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func ipToU32(ip net.IP) uint32 {
+	v4 := ip.To4()
+	if v4 == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(v4)
+}
+
+func u32ToIP(v uint32) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, v)
+	return ip
+}
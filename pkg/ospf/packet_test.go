@@ -0,0 +1,119 @@
+package ospf
+
+import (
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	h := hello{
+		mask:          0xffffff00,
+		helloInterval: 10,
+		options:       optionE,
+		priority:      1,
+		deadInterval:  40,
+		dr:            ipToU32(u32ToIP(0x0a000001)),
+		neighbors:     []uint32{0x02020202, 0x03030303},
+	}
+	raw := encodePacket(typeHello, 0x01010101, 0, encodeHello(h))
+	pkt, err := decodePacket(raw)
+	if err != nil {
+		t.Fatalf("decode packet: %v", err)
+	}
+	if pkt.typ != typeHello || pkt.routerID != 0x01010101 {
+		t.Fatalf("unexpected header: %+v", pkt)
+	}
+	got, err := decodeHello(pkt.body)
+	if err != nil {
+		t.Fatalf("decode hello: %v", err)
+	}
+	if got.mask != h.mask || got.dr != h.dr || len(got.neighbors) != 2 || got.neighbors[1] != 0x03030303 {
+		t.Fatalf("unexpected hello: %+v", got)
+	}
+
+	raw[len(raw)-1] ^= 0xff
+	if _, err := decodePacket(raw); err == nil {
+		t.Fatalf("expected checksum error")
+	}
+
+	l := newLSA(LSARouter, 0x01010101, 0x01010101, initialSeq, encodeRouterLinks([]routerLink{
+		{id: 0x0a000001, data: 0x0a000002, typ: linkTransit, metric: 10},
+		{id: 0xc0a80100, data: 0xffffff00, typ: linkStub, metric: 1},
+	}))
+	d := dbd{mtu: 1500, options: optionE, flags: dbdMore | dbdMS, seq: 7, headers: []lsaHeader{l.lsaHeader}}
+	gotDBD, err := decodeDBD(encodeDBD(d))
+	if err != nil || gotDBD.seq != 7 || gotDBD.flags != dbdMore|dbdMS || gotDBD.headers[0] != l.lsaHeader {
+		t.Fatalf("unexpected dbd: %+v %v", gotDBD, err)
+	}
+	raws, err := decodeLSU(encodeLSU([][]byte{l.raw()}))
+	if err != nil || len(raws) != 1 {
+		t.Fatalf("decode lsu: %v", err)
+	}
+	decoded, err := decodeLSA(raws[0])
+	if err != nil {
+		t.Fatalf("decode lsa: %v", err)
+	}
+	links, err := decodeRouterLinks(decoded.body)
+	if err != nil || len(links) != 2 || links[0].typ != linkTransit || links[1].metric != 1 {
+		t.Fatalf("unexpected links: %+v %v", links, err)
+	}
+	reqs, err := decodeLSR(encodeLSR([]lsaRequest{{typ: LSANetwork, id: 1, advRouter: 2}}))
+	if err != nil || len(reqs) != 1 || reqs[0].typ != LSANetwork || reqs[0].advRouter != 2 {
+		t.Fatalf("unexpected lsr: %+v %v", reqs, err)
+	}
+}
+
+func TestLSAChecksumAndOrdering(t *testing.T) {
+	l := newLSA(LSANetwork, 0x0a000001, 0x03030303, initialSeq, encodeNetwork(0xffffff00, []uint32{0x03030303, 0x01010101}))
+	raw := l.raw()
+	raw[0], raw[1] = 0x0e, 0x10
+	if _, err := decodeLSA(raw); err != nil {
+		t.Fatalf("checksum must ignore age: %v", err)
+	}
+	raw[25] ^= 0x01
+	if _, err := decodeLSA(raw); err == nil {
+		t.Fatalf("expected checksum error")
+	}
+
+	older := l.lsaHeader
+	newer := older
+	newer.seq++
+	if compareLSA(newer, older) <= 0 || compareLSA(older, newer) >= 0 {
+		t.Fatalf("higher sequence must win")
+	}
+	flushed := older
+	flushed.age = maxAge
+	if compareLSA(flushed, older) <= 0 {
+		t.Fatalf("max age instance must win on equal sequence")
+	}
+	aged := older
+	aged.age = 1000
+	if compareLSA(older, aged) <= 0 {
+		t.Fatalf("much younger instance must win")
+	}
+}
+
+func TestElectDR(t *testing.T) {
+	candidates := []drCandidate{
+		{id: 1, addr: 11, priority: 1},
+		{id: 2, addr: 12, priority: 1},
+		{id: 3, addr: 13, priority: 1},
+	}
+	dr, bdr := electFrom(candidates)
+	if dr != 13 || bdr != 0 {
+		t.Fatalf("expected fresh election to promote 13 without a backup, got %d/%d", dr, bdr)
+	}
+	candidates[2].dr = true
+	dr, bdr = electFrom(candidates)
+	if dr != 13 || bdr != 12 {
+		t.Fatalf("expected dr 13 bdr 12, got %d/%d", dr, bdr)
+	}
+	candidates = []drCandidate{
+		{id: 1, addr: 11, priority: 5, bdr: true},
+		{id: 3, addr: 13, priority: 1, dr: true},
+		{id: 4, addr: 14, priority: 9},
+	}
+	dr, bdr = electFrom(candidates)
+	if dr != 13 || bdr != 11 {
+		t.Fatalf("existing dr and bdr must be kept, got %d/%d", dr, bdr)
+	}
+}
@@ -0,0 +1,1679 @@
+package ospf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"router-go/pkg/routing"
+)
+
+const (
+	Source   = "ospf"
+	Distance = 110
+
+	NetworkBroadcast    = "broadcast"
+	NetworkPointToPoint = "point-to-point"
+
+	IfaceDown         = "down"
+	IfacePassive      = "passive"
+	IfaceWaiting      = "waiting"
+	IfacePointToPoint = "point-to-point"
+	IfaceDROther      = "dr_other"
+	IfaceBackup       = "backup"
+	IfaceDR           = "dr"
+
+	maxHeadersPerDBD = 50
+	maxLSAsPerUpdate = 20
+)
+
+const (
+	nbrDown = iota
+	nbrInit
+	nbrTwoWay
+	nbrExStart
+	nbrExchange
+	nbrLoading
+	nbrFull
+)
+
+var neighborStates = []string{"down", "init", "2-way", "exstart", "exchange", "loading", "full"}
+
+type Config struct {
+	RouterID   net.IP
+	Area       net.IP
+	Interfaces []InterfaceConfig
+	Tick       time.Duration
+}
+
+type InterfaceConfig struct {
+	Name               string
+	Address            net.IPNet
+	Network            string
+	Cost               int
+	Priority           int
+	HelloInterval      time.Duration
+	DeadInterval       time.Duration
+	RetransmitInterval time.Duration
+	Passive            bool
+}
+
+type Transport interface {
+	Send(dst net.IP, pkt []byte) error
+	Receive() (net.IP, []byte, error)
+	Close() error
+}
+
+type TransportFunc func(InterfaceConfig) (Transport, error)
+
+type InterfaceStatus struct {
+	Name      string
+	Address   string
+	Network   string
+	State     string
+	Cost      int
+	Priority  int
+	DR        string
+	BDR       string
+	Neighbors int
+}
+
+type NeighborStatus struct {
+	Interface   string
+	RouterID    string
+	Address     string
+	State       string
+	Priority    int
+	DR          string
+	BDR         string
+	Requests    int
+	Retransmits int
+	LastHello   time.Time
+	FullSince   time.Time
+}
+
+type LSALink struct {
+	Type   string
+	ID     string
+	Data   string
+	Metric int
+}
+
+type LSAEntry struct {
+	Type        string
+	LinkStateID string
+	AdvRouter   string
+	Age         int
+	Sequence    string
+	Checksum    string
+	Links       []LSALink
+	Mask        string
+	Attached    []string
+}
+
+type Router struct {
+	cfg       Config
+	id        uint32
+	area      uint32
+	table     *routing.Table
+	transport TransportFunc
+	mu        sync.Mutex
+	ifaces    []*iface
+	lsdb      map[lsaKey]*lsa
+	installed map[string]routing.Route
+	originate bool
+	spf       bool
+	lastAging time.Time
+	onChange  func(iface string, neighbor string, state string)
+	events    []neighborEvent
+}
+
+type iface struct {
+	cfg       InterfaceConfig
+	addr      uint32
+	mask      uint32
+	tr        Transport
+	state     string
+	dr        uint32
+	bdr       uint32
+	waitUntil time.Time
+	nextHello time.Time
+	nbrs      map[uint32]*neighbor
+}
+
+type neighbor struct {
+	ifc       *iface
+	id        uint32
+	addr      uint32
+	priority  uint8
+	dr        uint32
+	bdr       uint32
+	state     int
+	lastSeen  time.Time
+	fullSince time.Time
+	master    bool
+	ddSeq     uint32
+	summary   []lsaHeader
+	sentLast  bool
+	lastDBD   []byte
+	dbdSentAt time.Time
+	recvFlags uint8
+	recvSeq   uint32
+	received  bool
+	requests  map[lsaKey]lsaHeader
+	pending   []lsaKey
+	lsrSentAt time.Time
+	rxmt      map[lsaKey]*lsa
+	rxmtAt    time.Time
+}
+
+type neighborEvent struct {
+	iface    string
+	neighbor string
+	state    string
+}
+
+type inbound struct {
+	ifc *iface
+	src net.IP
+	pkt []byte
+}
+
+type spfHop struct {
+	iface   string
+	gateway uint32
+}
+
+type vertex struct {
+	key    lsaKey
+	l      *lsa
+	dist   int
+	hops   []spfHop
+	direct bool
+}
+
+func NewRouter(cfg Config, table *routing.Table) (*Router, error) {
+	if cfg.RouterID.To4() == nil || cfg.RouterID.To4().IsUnspecified() {
+		return nil, errors.New("ospf router_id must be a non-zero IPv4 address")
+	}
+	if cfg.Area == nil {
+		cfg.Area = net.IPv4zero
+	}
+	if cfg.Area.To4() == nil {
+		return nil, errors.New("ospf area must be an IPv4 dotted quad")
+	}
+	if cfg.Tick <= 0 {
+		cfg.Tick = 100 * time.Millisecond
+	}
+	r := &Router{
+		cfg:       cfg,
+		id:        ipToU32(cfg.RouterID),
+		area:      ipToU32(cfg.Area),
+		table:     table,
+		transport: newRawTransport,
+		lsdb:      map[lsaKey]*lsa{},
+		installed: map[string]routing.Route{},
+	}
+	seen := map[string]struct{}{}
+	for _, ic := range cfg.Interfaces {
+		if ic.Name == "" {
+			return nil, errors.New("ospf interface name is required")
+		}
+		if _, ok := seen[ic.Name]; ok {
+			return nil, fmt.Errorf("ospf interface %s is duplicated", ic.Name)
+		}
+		seen[ic.Name] = struct{}{}
+		if ic.Address.IP.To4() == nil || ic.Address.Mask == nil {
+			return nil, fmt.Errorf("ospf interface %s requires an IPv4 address", ic.Name)
+		}
+		if ic.Network == "" {
+			ic.Network = NetworkBroadcast
+		}
+		if ic.Network != NetworkBroadcast && ic.Network != NetworkPointToPoint {
+			return nil, fmt.Errorf("ospf interface %s has unknown network type %q", ic.Name, ic.Network)
+		}
+		if ic.Cost <= 0 {
+			ic.Cost = 10
+		}
+		if ic.Priority < 0 || ic.Priority > 255 {
+			return nil, fmt.Errorf("ospf interface %s priority must be 0-255", ic.Name)
+		}
+		if ic.HelloInterval <= 0 {
+			ic.HelloInterval = 10 * time.Second
+		}
+		if ic.DeadInterval <= 0 {
+			ic.DeadInterval = 4 * ic.HelloInterval
+		}
+		if ic.RetransmitInterval <= 0 {
+			ic.RetransmitInterval = 5 * time.Second
+		}
+		ones, _ := ic.Address.Mask.Size()
+		r.ifaces = append(r.ifaces, &iface{
+			cfg:   ic,
+			addr:  ipToU32(ic.Address.IP),
+			mask:  ^uint32(0) << (32 - ones),
+			state: IfaceDown,
+			nbrs:  map[uint32]*neighbor{},
+		})
+	}
+	return r, nil
+}
+
+func (r *Router) SetTransport(fn TransportFunc) {
+	r.mu.Lock()
+	r.transport = fn
+	r.mu.Unlock()
+}
+
+func (r *Router) SetOnNeighborChange(fn func(iface string, neighbor string, state string)) {
+	r.mu.Lock()
+	r.onChange = fn
+	r.mu.Unlock()
+}
+
+func (r *Router) Start(ctx context.Context) error {
+	r.mu.Lock()
+	for _, ifc := range r.ifaces {
+		if ifc.cfg.Passive {
+			continue
+		}
+		tr, err := r.transport(ifc.cfg)
+		if err != nil {
+			for _, opened := range r.ifaces {
+				if opened.tr != nil {
+					_ = opened.tr.Close()
+					opened.tr = nil
+				}
+			}
+			r.mu.Unlock()
+			return fmt.Errorf("ospf interface %s: %w", ifc.cfg.Name, err)
+		}
+		ifc.tr = tr
+	}
+	now := time.Now()
+	for _, ifc := range r.ifaces {
+		r.interfaceUp(ifc, now)
+	}
+	r.lastAging = now
+	r.originate = true
+	r.flush(now)
+	r.mu.Unlock()
+
+	in := make(chan inbound, 256)
+	for _, ifc := range r.ifaces {
+		if ifc.tr != nil {
+			go r.receive(ctx, ifc, in)
+		}
+	}
+	go r.run(ctx, in)
+	return nil
+}
+
+func (r *Router) Interfaces() []InterfaceStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]InterfaceStatus, 0, len(r.ifaces))
+	for _, ifc := range r.ifaces {
+		out = append(out, InterfaceStatus{
+			Name:      ifc.cfg.Name,
+			Address:   ifc.cfg.Address.String(),
+			Network:   ifc.cfg.Network,
+			State:     ifc.state,
+			Cost:      ifc.cfg.Cost,
+			Priority:  ifc.cfg.Priority,
+			DR:        addrString(ifc.dr),
+			BDR:       addrString(ifc.bdr),
+			Neighbors: len(ifc.nbrs),
+		})
+	}
+	return out
+}
+
+func (r *Router) Neighbors() []NeighborStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []NeighborStatus
+	for _, ifc := range r.ifaces {
+		for _, n := range ifc.nbrs {
+			out = append(out, NeighborStatus{
+				Interface:   ifc.cfg.Name,
+				RouterID:    u32ToIP(n.id).String(),
+				Address:     u32ToIP(n.addr).String(),
+				State:       neighborStates[n.state],
+				Priority:    int(n.priority),
+				DR:          addrString(n.dr),
+				BDR:         addrString(n.bdr),
+				Requests:    len(n.requests),
+				Retransmits: len(n.rxmt),
+				LastHello:   n.lastSeen,
+				FullSince:   n.fullSince,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Interface != out[j].Interface {
+			return out[i].Interface < out[j].Interface
+		}
+		return ipToU32(net.ParseIP(out[i].RouterID)) < ipToU32(net.ParseIP(out[j].RouterID))
+	})
+	return out
+}
+
+func (r *Router) LSDB() []LSAEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	keys := r.sortedKeys()
+	out := make([]LSAEntry, 0, len(keys))
+	for _, key := range keys {
+		l := r.lsdb[key]
+		entry := LSAEntry{
+			LinkStateID: u32ToIP(l.id).String(),
+			AdvRouter:   u32ToIP(l.advRouter).String(),
+			Age:         int(l.currentAge(now)),
+			Sequence:    fmt.Sprintf("0x%08x", uint32(l.seq)),
+			Checksum:    fmt.Sprintf("0x%04x", l.checksum),
+		}
+		switch l.typ {
+		case LSARouter:
+			entry.Type = "router"
+			links, _ := decodeRouterLinks(l.body)
+			for _, link := range links {
+				entry.Links = append(entry.Links, LSALink{
+					Type:   linkTypeName(link.typ),
+					ID:     u32ToIP(link.id).String(),
+					Data:   u32ToIP(link.data).String(),
+					Metric: int(link.metric),
+				})
+			}
+		case LSANetwork:
+			entry.Type = "network"
+			mask, attached, _ := decodeNetwork(l.body)
+			entry.Mask = u32ToIP(mask).String()
+			for _, id := range attached {
+				entry.Attached = append(entry.Attached, u32ToIP(id).String())
+			}
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func (r *Router) receive(ctx context.Context, ifc *iface, in chan<- inbound) {
+	for {
+		src, pkt, err := ifc.tr.Receive()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case in <- inbound{ifc: ifc, src: src, pkt: pkt}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Router) run(ctx context.Context, in <-chan inbound) {
+	ticker := time.NewTicker(r.cfg.Tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.shutdown()
+			return
+		case msg := <-in:
+			now := time.Now()
+			r.mu.Lock()
+			r.handle(msg, now)
+			r.flush(now)
+			r.mu.Unlock()
+		case now := <-ticker.C:
+			r.mu.Lock()
+			r.tick(now)
+			r.flush(now)
+			r.mu.Unlock()
+		}
+		r.notify()
+	}
+}
+
+func (r *Router) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, ifc := range r.ifaces {
+		if ifc.tr != nil {
+			_ = ifc.tr.Close()
+		}
+	}
+	for key, route := range r.installed {
+		r.table.RemoveRoute(route)
+		delete(r.installed, key)
+	}
+}
+
+func (r *Router) notify() {
+	r.mu.Lock()
+	events := r.events
+	r.events = nil
+	fn := r.onChange
+	r.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	for _, ev := range events {
+		fn(ev.iface, ev.neighbor, ev.state)
+	}
+}
+
+func (r *Router) flush(now time.Time) {
+	if r.originate {
+		r.originate = false
+		r.originateAll(now)
+	}
+	if r.spf {
+		r.spf = false
+		r.install(r.computeRoutes())
+	}
+}
+
+func (r *Router) interfaceUp(ifc *iface, now time.Time) {
+	ifc.nextHello = now
+	switch {
+	case ifc.cfg.Passive:
+		ifc.state = IfacePassive
+	case ifc.cfg.Network == NetworkPointToPoint:
+		ifc.state = IfacePointToPoint
+	case ifc.cfg.Priority == 0:
+		ifc.state = IfaceDROther
+	default:
+		ifc.state = IfaceWaiting
+		ifc.waitUntil = now.Add(ifc.cfg.DeadInterval)
+	}
+}
+
+func (r *Router) tick(now time.Time) {
+	for _, ifc := range r.ifaces {
+		if ifc.tr == nil {
+			continue
+		}
+		if !now.Before(ifc.nextHello) {
+			r.sendHello(ifc)
+			ifc.nextHello = now.Add(ifc.cfg.HelloInterval)
+		}
+		if ifc.state == IfaceWaiting && !now.Before(ifc.waitUntil) {
+			r.electDR(ifc, now)
+		}
+		rxmt := ifc.cfg.RetransmitInterval
+		for _, n := range ifc.nbrs {
+			if now.Sub(n.lastSeen) >= ifc.cfg.DeadInterval {
+				r.killNeighbor(n, now)
+				continue
+			}
+			if (n.state == nbrExStart || (n.state == nbrExchange && n.master)) && now.Sub(n.dbdSentAt) >= rxmt {
+				r.send(ifc, u32ToIP(n.addr), n.lastDBD)
+				n.dbdSentAt = now
+			}
+			if n.state == nbrLoading && now.Sub(n.lsrSentAt) >= rxmt {
+				r.sendLSR(n, now)
+			}
+			if len(n.rxmt) > 0 && now.Sub(n.rxmtAt) >= rxmt {
+				lsas := make([]*lsa, 0, len(n.rxmt))
+				for _, l := range n.rxmt {
+					lsas = append(lsas, l)
+				}
+				r.sendUpdate(ifc, u32ToIP(n.addr), lsas, now)
+				n.rxmtAt = now
+			}
+		}
+	}
+	if now.Sub(r.lastAging) >= time.Second {
+		r.lastAging = now
+		r.age(now)
+	}
+}
+
+func (r *Router) age(now time.Time) {
+	for _, key := range r.sortedKeys() {
+		l := r.lsdb[key]
+		age := l.currentAge(now)
+		if l.advRouter == r.id && !l.flushed && age >= lsRefreshTime {
+			r.originateLSA(l.typ, l.id, l.body, now, true)
+			continue
+		}
+		if age >= maxAge && !l.flushed {
+			l.flushed = true
+			r.spf = true
+			r.flood(l, nil, now)
+			continue
+		}
+		if l.flushed && !r.retransmitting(key) && !r.exchanging() {
+			delete(r.lsdb, key)
+		}
+	}
+}
+
+func (r *Router) handle(msg inbound, now time.Time) {
+	pkt, err := decodePacket(msg.pkt)
+	if err != nil || pkt.areaID != r.area || pkt.routerID == r.id {
+		return
+	}
+	ifc := msg.ifc
+	src := ipToU32(msg.src)
+	if ifc.cfg.Network == NetworkBroadcast && src&ifc.mask != ifc.addr&ifc.mask {
+		return
+	}
+	if pkt.typ == typeHello {
+		r.recvHello(ifc, pkt.routerID, src, pkt.body, now)
+		return
+	}
+	n := ifc.nbrs[pkt.routerID]
+	if n == nil {
+		return
+	}
+	switch pkt.typ {
+	case typeDBD:
+		r.recvDBD(n, pkt.body, now)
+	case typeLSR:
+		r.recvLSR(n, pkt.body, now)
+	case typeLSU:
+		r.recvLSU(n, pkt.body, now)
+	case typeLSAck:
+		r.recvLSAck(n, pkt.body, now)
+	}
+}
+
+func (r *Router) recvHello(ifc *iface, id uint32, src uint32, body []byte, now time.Time) {
+	h, err := decodeHello(body)
+	if err != nil {
+		return
+	}
+	if h.helloInterval != uint16(ifc.cfg.HelloInterval/time.Second) || h.deadInterval != uint32(ifc.cfg.DeadInterval/time.Second) {
+		return
+	}
+	if ifc.cfg.Network == NetworkBroadcast && h.mask != ifc.mask {
+		return
+	}
+	if h.options&optionE == 0 {
+		return
+	}
+	n := ifc.nbrs[id]
+	if n == nil {
+		n = &neighbor{ifc: ifc, id: id, state: nbrDown}
+		ifc.nbrs[id] = n
+	}
+	n.addr = src
+	n.lastSeen = now
+	if n.state == nbrDown {
+		r.setState(n, nbrInit, now)
+	}
+	changed := n.priority != h.priority ||
+		(n.dr == n.addr) != (h.dr == n.addr) ||
+		(n.bdr == n.addr) != (h.bdr == n.addr)
+	n.priority, n.dr, n.bdr = h.priority, h.dr, h.bdr
+
+	seesUs := false
+	for _, nid := range h.neighbors {
+		if nid == r.id {
+			seesUs = true
+			break
+		}
+	}
+	if !seesUs {
+		if n.state >= nbrTwoWay {
+			r.setState(n, nbrInit, now)
+			changed = true
+		}
+	} else if n.state == nbrInit {
+		if r.adjacencyWanted(ifc, n) {
+			r.startExStart(n, now)
+		} else {
+			r.setState(n, nbrTwoWay, now)
+		}
+		changed = true
+	}
+	if ifc.cfg.Network != NetworkBroadcast {
+		return
+	}
+	if ifc.state == IfaceWaiting {
+		if seesUs && (h.bdr == n.addr || (h.dr == n.addr && h.bdr == 0)) {
+			r.electDR(ifc, now)
+		}
+		return
+	}
+	if changed {
+		r.electDR(ifc, now)
+	}
+}
+
+func (r *Router) adjacencyWanted(ifc *iface, n *neighbor) bool {
+	if ifc.cfg.Network == NetworkPointToPoint {
+		return true
+	}
+	return ifc.dr == ifc.addr || ifc.bdr == ifc.addr || ifc.dr == n.addr || ifc.bdr == n.addr
+}
+
+func (r *Router) setState(n *neighbor, state int, now time.Time) {
+	old := n.state
+	if old == state {
+		return
+	}
+	n.state = state
+	if state < nbrExStart {
+		n.summary = nil
+		n.requests = nil
+		n.rxmt = nil
+		n.lastDBD = nil
+		n.received = false
+	}
+	if state == nbrFull {
+		n.fullSince = now
+	}
+	if old == nbrFull || state == nbrFull {
+		r.originate = true
+	}
+	r.events = append(r.events, neighborEvent{
+		iface:    n.ifc.cfg.Name,
+		neighbor: u32ToIP(n.id).String(),
+		state:    neighborStates[state],
+	})
+}
+
+func (r *Router) killNeighbor(n *neighbor, now time.Time) {
+	ifc := n.ifc
+	r.setState(n, nbrDown, now)
+	delete(ifc.nbrs, n.id)
+	if ifc.cfg.Network == NetworkBroadcast && ifc.state != IfaceWaiting {
+		r.electDR(ifc, now)
+	}
+	r.originate = true
+}
+
+type drCandidate struct {
+	id       uint32
+	addr     uint32
+	priority uint8
+	dr       bool
+	bdr      bool
+}
+
+func (r *Router) electDR(ifc *iface, now time.Time) {
+	candidates := func() []drCandidate {
+		var out []drCandidate
+		if ifc.cfg.Priority > 0 {
+			out = append(out, drCandidate{
+				id:       r.id,
+				addr:     ifc.addr,
+				priority: uint8(ifc.cfg.Priority),
+				dr:       ifc.dr == ifc.addr,
+				bdr:      ifc.bdr == ifc.addr,
+			})
+		}
+		for _, n := range ifc.nbrs {
+			if n.state < nbrTwoWay || n.priority == 0 {
+				continue
+			}
+			out = append(out, drCandidate{
+				id:       n.id,
+				addr:     n.addr,
+				priority: n.priority,
+				dr:       n.dr == n.addr,
+				bdr:      n.bdr == n.addr,
+			})
+		}
+		return out
+	}
+	oldDR, oldBDR, oldState := ifc.dr, ifc.bdr, ifc.state
+	dr, bdr := electFrom(candidates())
+	if (oldDR == ifc.addr) != (dr == ifc.addr) || (oldBDR == ifc.addr) != (bdr == ifc.addr) {
+		ifc.dr, ifc.bdr = dr, bdr
+		dr, bdr = electFrom(candidates())
+	}
+	ifc.dr, ifc.bdr = dr, bdr
+	switch {
+	case dr == ifc.addr:
+		ifc.state = IfaceDR
+	case bdr == ifc.addr:
+		ifc.state = IfaceBackup
+	default:
+		ifc.state = IfaceDROther
+	}
+	if ifc.dr == oldDR && ifc.bdr == oldBDR && ifc.state == oldState {
+		return
+	}
+	r.originate = true
+	for _, n := range ifc.nbrs {
+		if n.state < nbrTwoWay {
+			continue
+		}
+		wanted := r.adjacencyWanted(ifc, n)
+		if n.state == nbrTwoWay && wanted {
+			r.startExStart(n, now)
+		} else if n.state >= nbrExStart && !wanted {
+			r.setState(n, nbrTwoWay, now)
+		}
+	}
+}
+
+func electFrom(candidates []drCandidate) (uint32, uint32) {
+	better := func(a drCandidate, b *drCandidate) bool {
+		if b == nil {
+			return true
+		}
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.id > b.id
+	}
+	var bdr *drCandidate
+	bdrDeclared := false
+	for i := range candidates {
+		c := candidates[i]
+		if c.dr {
+			continue
+		}
+		if c.bdr && !bdrDeclared {
+			bdr, bdrDeclared = &candidates[i], true
+			continue
+		}
+		if c.bdr == bdrDeclared && better(c, bdr) {
+			bdr = &candidates[i]
+		}
+	}
+	var dr *drCandidate
+	for i := range candidates {
+		if candidates[i].dr && better(candidates[i], dr) {
+			dr = &candidates[i]
+		}
+	}
+	if dr == nil {
+		dr = bdr
+	}
+	var drAddr, bdrAddr uint32
+	if dr != nil {
+		drAddr = dr.addr
+	}
+	if bdr != nil && bdr != dr {
+		bdrAddr = bdr.addr
+	}
+	return drAddr, bdrAddr
+}
+
+func (r *Router) startExStart(n *neighbor, now time.Time) {
+	r.setState(n, nbrExStart, now)
+	n.requests = map[lsaKey]lsaHeader{}
+	n.rxmt = map[lsaKey]*lsa{}
+	n.pending = nil
+	n.received = false
+	n.master = true
+	if n.ddSeq == 0 {
+		n.ddSeq = uint32(now.UnixNano())
+	} else {
+		n.ddSeq++
+	}
+	n.summary = nil
+	n.sentLast = false
+	n.lastDBD = r.packet(typeDBD, encodeDBD(dbd{
+		mtu:     1500,
+		options: optionE,
+		flags:   dbdInit | dbdMore | dbdMS,
+		seq:     n.ddSeq,
+	}))
+	r.send(n.ifc, u32ToIP(n.addr), n.lastDBD)
+	n.dbdSentAt = now
+}
+
+func (r *Router) sendDBD(n *neighbor, now time.Time) {
+	count := len(n.summary)
+	if count > maxHeadersPerDBD {
+		count = maxHeadersPerDBD
+	}
+	headers := n.summary[:count]
+	n.summary = n.summary[count:]
+	var flags uint8
+	if n.master {
+		flags |= dbdMS
+	}
+	if len(n.summary) > 0 {
+		flags |= dbdMore
+	}
+	n.sentLast = len(n.summary) == 0
+	n.lastDBD = r.packet(typeDBD, encodeDBD(dbd{
+		mtu:     1500,
+		options: optionE,
+		flags:   flags,
+		seq:     n.ddSeq,
+		headers: headers,
+	}))
+	r.send(n.ifc, u32ToIP(n.addr), n.lastDBD)
+	n.dbdSentAt = now
+}
+
+func (r *Router) recvDBD(n *neighbor, body []byte, now time.Time) {
+	d, err := decodeDBD(body)
+	if err != nil {
+		return
+	}
+	duplicate := n.received && d.flags == n.recvFlags && d.seq == n.recvSeq
+	switch n.state {
+	case nbrExStart:
+		switch {
+		case d.flags&(dbdInit|dbdMore|dbdMS) == dbdInit|dbdMore|dbdMS && len(d.headers) == 0 && n.id > r.id:
+			n.master = false
+			n.ddSeq = d.seq
+			r.negotiationDone(n, d, now)
+			r.sendDBD(n, now)
+		case d.flags&(dbdInit|dbdMS) == 0 && d.seq == n.ddSeq && n.id < r.id:
+			n.master = true
+			r.negotiationDone(n, d, now)
+			if !r.processSummary(n, d, now) {
+				return
+			}
+			n.ddSeq++
+			r.sendDBD(n, now)
+		}
+	case nbrExchange:
+		if duplicate {
+			if !n.master {
+				r.send(n.ifc, u32ToIP(n.addr), n.lastDBD)
+			}
+			return
+		}
+		if (d.flags&dbdMS != 0) == n.master || d.flags&dbdInit != 0 {
+			r.startExStart(n, now)
+			return
+		}
+		if (n.master && d.seq != n.ddSeq) || (!n.master && d.seq != n.ddSeq+1) {
+			r.startExStart(n, now)
+			return
+		}
+		n.recvFlags, n.recvSeq, n.received = d.flags, d.seq, true
+		if !r.processSummary(n, d, now) {
+			return
+		}
+		if n.master {
+			n.ddSeq++
+			if n.sentLast && d.flags&dbdMore == 0 {
+				r.exchangeDone(n, now)
+				return
+			}
+			r.sendDBD(n, now)
+			return
+		}
+		n.ddSeq = d.seq
+		r.sendDBD(n, now)
+		if n.sentLast && d.flags&dbdMore == 0 {
+			r.exchangeDone(n, now)
+		}
+	case nbrLoading, nbrFull:
+		if duplicate {
+			if !n.master {
+				r.send(n.ifc, u32ToIP(n.addr), n.lastDBD)
+			}
+			return
+		}
+		r.startExStart(n, now)
+	}
+}
+
+func (r *Router) negotiationDone(n *neighbor, d dbd, now time.Time) {
+	r.setState(n, nbrExchange, now)
+	n.recvFlags, n.recvSeq, n.received = d.flags, d.seq, true
+	n.summary = n.summary[:0]
+	for _, key := range r.sortedKeys() {
+		n.summary = append(n.summary, r.lsdb[key].header(now))
+	}
+}
+
+func (r *Router) processSummary(n *neighbor, d dbd, now time.Time) bool {
+	for _, h := range d.headers {
+		if h.typ != LSARouter && h.typ != LSANetwork {
+			r.startExStart(n, now)
+			return false
+		}
+		cur := r.lsdb[h.key()]
+		if cur == nil || compareLSA(h, cur.header(now)) > 0 {
+			n.requests[h.key()] = h
+		}
+	}
+	return true
+}
+
+func (r *Router) exchangeDone(n *neighbor, now time.Time) {
+	if len(n.requests) == 0 {
+		r.setState(n, nbrFull, now)
+		return
+	}
+	r.setState(n, nbrLoading, now)
+	r.sendLSR(n, now)
+}
+
+func (r *Router) sendLSR(n *neighbor, now time.Time) {
+	keys := make([]lsaKey, 0, len(n.requests))
+	for key := range n.requests {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	if len(keys) > maxHeadersPerDBD {
+		keys = keys[:maxHeadersPerDBD]
+	}
+	n.pending = keys
+	reqs := make([]lsaRequest, 0, len(keys))
+	for _, key := range keys {
+		reqs = append(reqs, lsaRequest{typ: key.typ, id: key.id, advRouter: key.advRouter})
+	}
+	r.send(n.ifc, u32ToIP(n.addr), r.packet(typeLSR, encodeLSR(reqs)))
+	n.lsrSentAt = now
+}
+
+func (r *Router) awaiting(n *neighbor) bool {
+	for _, key := range n.pending {
+		if _, ok := n.requests[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) recvLSR(n *neighbor, body []byte, now time.Time) {
+	if n.state < nbrExchange {
+		return
+	}
+	reqs, err := decodeLSR(body)
+	if err != nil {
+		return
+	}
+	lsas := make([]*lsa, 0, len(reqs))
+	for _, req := range reqs {
+		l := r.lsdb[lsaKey{typ: req.typ, id: req.id, advRouter: req.advRouter}]
+		if l == nil {
+			r.startExStart(n, now)
+			return
+		}
+		lsas = append(lsas, l)
+	}
+	r.sendUpdate(n.ifc, u32ToIP(n.addr), lsas, now)
+}
+
+func (r *Router) recvLSU(n *neighbor, body []byte, now time.Time) {
+	if n.state < nbrExchange {
+		return
+	}
+	raws, err := decodeLSU(body)
+	if err != nil {
+		return
+	}
+	ifc := n.ifc
+	var acks []lsaHeader
+	for _, raw := range raws {
+		l, err := decodeLSA(raw)
+		if err != nil || (l.typ != LSARouter && l.typ != LSANetwork) {
+			continue
+		}
+		key := l.key()
+		cur := r.lsdb[key]
+		if l.age >= maxAge && cur == nil && !r.exchanging() {
+			r.send(ifc, u32ToIP(n.addr), r.packet(typeLSAck, encodeLSAck([]lsaHeader{l.lsaHeader})))
+			continue
+		}
+		cmp := 1
+		if cur != nil {
+			cmp = compareLSA(l.lsaHeader, cur.header(now))
+		}
+		if cmp > 0 {
+			if l.age >= maxAge {
+				l.flushed = true
+			}
+			r.installLSA(l, now)
+			if !r.flood(l, n, now) {
+				acks = append(acks, l.lsaHeader)
+			}
+			if r.selfOriginated(l) {
+				r.selfOriginatedReceived(l, now)
+			}
+			continue
+		}
+		if _, ok := n.requests[key]; ok {
+			r.startExStart(n, now)
+			return
+		}
+		if cmp == 0 {
+			if _, ok := n.rxmt[key]; ok {
+				delete(n.rxmt, key)
+			} else {
+				r.send(ifc, u32ToIP(n.addr), r.packet(typeLSAck, encodeLSAck([]lsaHeader{l.lsaHeader})))
+			}
+			continue
+		}
+		if cur.currentAge(now) >= maxAge && cur.seq == maxSeq {
+			continue
+		}
+		r.sendUpdate(ifc, u32ToIP(n.addr), []*lsa{cur}, now)
+	}
+	if len(acks) > 0 {
+		r.send(ifc, r.floodDest(ifc), r.packet(typeLSAck, encodeLSAck(acks)))
+	}
+	if n.state == nbrLoading && len(n.requests) > 0 && !r.awaiting(n) {
+		r.sendLSR(n, now)
+	}
+}
+
+func (r *Router) recvLSAck(n *neighbor, body []byte, now time.Time) {
+	if n.state < nbrExchange {
+		return
+	}
+	headers, err := decodeLSAck(body)
+	if err != nil {
+		return
+	}
+	for _, h := range headers {
+		if l, ok := n.rxmt[h.key()]; ok && compareLSA(h, l.header(now)) == 0 {
+			delete(n.rxmt, h.key())
+		}
+	}
+}
+
+func (r *Router) installLSA(l *lsa, now time.Time) {
+	key := l.key()
+	if cur := r.lsdb[key]; cur == nil || cur.flushed != l.flushed || !bytes.Equal(cur.body, l.body) {
+		r.spf = true
+	}
+	for _, ifc := range r.ifaces {
+		for _, n := range ifc.nbrs {
+			delete(n.rxmt, key)
+		}
+	}
+	l.installed = now
+	r.lsdb[key] = l
+}
+
+// flood sends a new LSA to every adjacency except the one it came from and
+// reports whether it went back out of the receiving interface.
+func (r *Router) flood(l *lsa, from *neighbor, now time.Time) bool {
+	key := l.key()
+	hdr := l.header(now)
+	floodedBack := false
+	for _, ifc := range r.ifaces {
+		if ifc.tr == nil {
+			continue
+		}
+		added := false
+		for _, n := range ifc.nbrs {
+			if n.state < nbrExchange {
+				continue
+			}
+			if n.state < nbrFull {
+				if req, ok := n.requests[key]; ok {
+					cmp := compareLSA(hdr, req)
+					if cmp < 0 {
+						continue
+					}
+					delete(n.requests, key)
+					if n.state == nbrLoading && len(n.requests) == 0 {
+						r.setState(n, nbrFull, now)
+					}
+					if cmp == 0 {
+						continue
+					}
+				}
+			}
+			if n == from {
+				continue
+			}
+			if len(n.rxmt) == 0 {
+				n.rxmtAt = now
+			}
+			n.rxmt[key] = l
+			added = true
+		}
+		if !added {
+			continue
+		}
+		if from != nil && from.ifc == ifc {
+			if ifc.dr == from.addr || ifc.bdr == from.addr || ifc.state == IfaceBackup {
+				continue
+			}
+			floodedBack = true
+		}
+		r.sendUpdate(ifc, r.floodDest(ifc), []*lsa{l}, now)
+	}
+	return floodedBack
+}
+
+func (r *Router) floodDest(ifc *iface) net.IP {
+	if ifc.cfg.Network == NetworkBroadcast && ifc.state != IfaceDR && ifc.state != IfaceBackup {
+		return AllDRouters
+	}
+	return AllSPFRouters
+}
+
+func (r *Router) selfOriginated(l *lsa) bool {
+	if l.advRouter == r.id {
+		return true
+	}
+	if l.typ != LSANetwork {
+		return false
+	}
+	for _, ifc := range r.ifaces {
+		if ifc.addr == l.id {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) selfOriginatedReceived(l *lsa, now time.Time) {
+	if l.typ == LSARouter && l.id == r.id {
+		r.originateLSA(LSARouter, r.id, r.routerLinks(), now, true)
+		return
+	}
+	if l.typ == LSANetwork && l.advRouter == r.id {
+		for _, ifc := range r.ifaces {
+			if ifc.addr == l.id && ifc.state == IfaceDR && fullNeighbors(ifc) > 0 {
+				r.originateLSA(LSANetwork, ifc.addr, r.networkBody(ifc), now, true)
+				return
+			}
+		}
+	}
+	r.flushLSA(l, now)
+}
+
+func (r *Router) originateAll(now time.Time) {
+	r.originateLSA(LSARouter, r.id, r.routerLinks(), now, false)
+	for _, ifc := range r.ifaces {
+		if ifc.cfg.Network != NetworkBroadcast {
+			continue
+		}
+		if ifc.state == IfaceDR && fullNeighbors(ifc) > 0 {
+			r.originateLSA(LSANetwork, ifc.addr, r.networkBody(ifc), now, false)
+			continue
+		}
+		if cur := r.lsdb[lsaKey{typ: LSANetwork, id: ifc.addr, advRouter: r.id}]; cur != nil && !cur.flushed {
+			r.flushLSA(cur, now)
+		}
+	}
+}
+
+func (r *Router) originateLSA(typ uint8, id uint32, body []byte, now time.Time, force bool) {
+	seq := initialSeq
+	if cur := r.lsdb[lsaKey{typ: typ, id: id, advRouter: r.id}]; cur != nil {
+		if !force && !cur.flushed && bytes.Equal(cur.body, body) {
+			return
+		}
+		seq = cur.seq + 1
+	}
+	l := newLSA(typ, id, r.id, seq, body)
+	r.installLSA(l, now)
+	r.flood(l, nil, now)
+}
+
+func (r *Router) flushLSA(cur *lsa, now time.Time) {
+	l := &lsa{lsaHeader: cur.lsaHeader, body: cur.body, flushed: true}
+	l.age = maxAge
+	r.installLSA(l, now)
+	r.flood(l, nil, now)
+}
+
+func (r *Router) routerLinks() []byte {
+	var links []routerLink
+	for _, ifc := range r.ifaces {
+		cost := uint16(ifc.cfg.Cost)
+		stub := routerLink{id: ifc.addr & ifc.mask, data: ifc.mask, typ: linkStub, metric: cost}
+		switch ifc.state {
+		case IfacePointToPoint:
+			for _, n := range sortedNeighbors(ifc) {
+				if n.state == nbrFull {
+					links = append(links, routerLink{id: n.id, data: ifc.addr, typ: linkPointToPoint, metric: cost})
+				}
+			}
+		case IfaceDR, IfaceBackup, IfaceDROther:
+			transit := ifc.state == IfaceDR && fullNeighbors(ifc) > 0
+			for _, n := range ifc.nbrs {
+				if n.addr == ifc.dr && n.state == nbrFull {
+					transit = true
+				}
+			}
+			if transit {
+				stub = routerLink{id: ifc.dr, data: ifc.addr, typ: linkTransit, metric: cost}
+			}
+		}
+		links = append(links, stub)
+	}
+	return encodeRouterLinks(links)
+}
+
+func (r *Router) networkBody(ifc *iface) []byte {
+	attached := []uint32{r.id}
+	for _, n := range sortedNeighbors(ifc) {
+		if n.state == nbrFull {
+			attached = append(attached, n.id)
+		}
+	}
+	return encodeNetwork(ifc.mask, attached)
+}
+
+func (r *Router) computeRoutes() map[string]routing.Route {
+	now := time.Now()
+	routers := map[uint32]*lsa{}
+	networks := map[uint32][]*lsa{}
+	for _, l := range r.lsdb {
+		if l.flushed || l.currentAge(now) >= maxAge {
+			continue
+		}
+		switch l.typ {
+		case LSARouter:
+			if l.id == l.advRouter {
+				routers[l.id] = l
+			}
+		case LSANetwork:
+			networks[l.id] = append(networks[l.id], l)
+		}
+	}
+	root := routers[r.id]
+	if root == nil {
+		return nil
+	}
+	tree := map[lsaKey]*vertex{}
+	candidates := map[lsaKey]*vertex{root.key(): {key: root.key(), l: root}}
+	for len(candidates) > 0 {
+		var v *vertex
+		for _, c := range candidates {
+			if v == nil || c.dist < v.dist ||
+				(c.dist == v.dist && c.key.typ > v.key.typ) ||
+				(c.dist == v.dist && c.key.typ == v.key.typ && c.key.id < v.key.id) {
+				v = c
+			}
+		}
+		delete(candidates, v.key)
+		tree[v.key] = v
+		relax := func(w *lsa, cost int, hops []spfHop) {
+			key := w.key()
+			if _, done := tree[key]; done || len(hops) == 0 {
+				return
+			}
+			c := candidates[key]
+			if c == nil || cost < c.dist {
+				candidates[key] = &vertex{key: key, l: w, dist: cost, hops: hops, direct: v.l == root && w.typ == LSANetwork}
+				return
+			}
+			if cost == c.dist {
+				c.hops = mergeHops(c.hops, hops)
+			}
+		}
+		if v.key.typ == LSARouter {
+			links, err := decodeRouterLinks(v.l.body)
+			if err != nil {
+				continue
+			}
+			for _, link := range links {
+				switch link.typ {
+				case linkPointToPoint:
+					w := routers[link.id]
+					if w == nil || !hasLink(w, linkPointToPoint, v.l.id) {
+						continue
+					}
+					var hops []spfHop
+					if v.l == root {
+						if ifc := r.ifaceByAddr(link.data); ifc != nil {
+							if n := ifc.nbrs[link.id]; n != nil {
+								hops = []spfHop{{iface: ifc.cfg.Name, gateway: n.addr}}
+							}
+						}
+					} else {
+						hops = v.hops
+					}
+					relax(w, v.dist+int(link.metric), hops)
+				case linkTransit:
+					w := networkFor(networks[link.id], v.l.id)
+					if w == nil {
+						continue
+					}
+					var hops []spfHop
+					if v.l == root {
+						if ifc := r.ifaceByAddr(link.data); ifc != nil {
+							hops = []spfHop{{iface: ifc.cfg.Name}}
+						}
+					} else {
+						hops = v.hops
+					}
+					relax(w, v.dist+int(link.metric), hops)
+				}
+			}
+			continue
+		}
+		_, attached, err := decodeNetwork(v.l.body)
+		if err != nil {
+			continue
+		}
+		for _, id := range attached {
+			w := routers[id]
+			if w == nil || w == root {
+				continue
+			}
+			gateway, ok := transitAddress(w, v.l.id)
+			if !ok {
+				continue
+			}
+			hops := v.hops
+			if v.direct {
+				hops = nil
+				for _, hop := range v.hops {
+					hops = append(hops, spfHop{iface: hop.iface, gateway: gateway})
+				}
+			}
+			relax(w, v.dist, hops)
+		}
+	}
+
+	type result struct {
+		prefix net.IPNet
+		cost   int
+		hops   []spfHop
+	}
+	results := map[string]*result{}
+	add := func(prefix net.IPNet, cost int, hops []spfHop) {
+		key := prefix.String()
+		cur := results[key]
+		if cur == nil || cost < cur.cost {
+			results[key] = &result{prefix: prefix, cost: cost, hops: hops}
+			return
+		}
+		if cost == cur.cost {
+			cur.hops = mergeHops(cur.hops, hops)
+		}
+	}
+	connected := map[string]struct{}{}
+	for _, ifc := range r.ifaces {
+		prefix := prefixFor(ifc.addr&ifc.mask, ifc.mask)
+		connected[prefix.String()] = struct{}{}
+	}
+	for _, v := range tree {
+		if v.l == root {
+			continue
+		}
+		if v.key.typ == LSANetwork {
+			mask, _, err := decodeNetwork(v.l.body)
+			if err == nil && !v.direct {
+				add(prefixFor(v.l.id&mask, mask), v.dist, v.hops)
+			}
+			continue
+		}
+		links, err := decodeRouterLinks(v.l.body)
+		if err != nil {
+			continue
+		}
+		for _, link := range links {
+			if link.typ == linkStub {
+				add(prefixFor(link.id&link.data, link.data), v.dist+int(link.metric), v.hops)
+			}
+		}
+	}
+	out := map[string]routing.Route{}
+	for key, res := range results {
+		if _, ok := connected[key]; ok || len(res.hops) == 0 {
+			continue
+		}
+		out[key] = buildRoute(res.prefix, res.cost, res.hops)
+	}
+	return out
+}
+
+func (r *Router) install(desired map[string]routing.Route) {
+	for key, old := range r.installed {
+		if _, ok := desired[key]; !ok {
+			r.table.RemoveRoute(old)
+			delete(r.installed, key)
+		}
+	}
+	for key, route := range desired {
+		old, ok := r.installed[key]
+		switch {
+		case !ok:
+			r.table.Add(route)
+		case routeSignature(old) == routeSignature(route):
+			continue
+		case !r.table.UpdateRoute(old, route):
+			r.table.Add(route)
+		}
+		r.installed[key] = route
+	}
+}
+
+func buildRoute(prefix net.IPNet, cost int, hops []spfHop) routing.Route {
+	sort.Slice(hops, func(i, j int) bool {
+		if hops[i].iface != hops[j].iface {
+			return hops[i].iface < hops[j].iface
+		}
+		return hops[i].gateway < hops[j].gateway
+	})
+	route := routing.Route{
+		Destination: prefix,
+		Metric:      cost,
+		Distance:    Distance,
+		Source:      Source,
+	}
+	if len(hops) == 1 {
+		route.Gateway = u32ToIP(hops[0].gateway)
+		route.Interface = hops[0].iface
+		return route
+	}
+	for _, hop := range hops {
+		route.NextHops = append(route.NextHops, routing.NextHop{
+			Gateway:   u32ToIP(hop.gateway),
+			Interface: hop.iface,
+			Weight:    1,
+		})
+	}
+	return route
+}
+
+func routeSignature(route routing.Route) string {
+	sig := fmt.Sprintf("%s|%d|%s|%s", route.Destination.String(), route.Metric, route.Gateway, route.Interface)
+	for _, hop := range route.NextHops {
+		sig += fmt.Sprintf("|%s/%s", hop.Gateway, hop.Interface)
+	}
+	return sig
+}
+
+func (r *Router) packet(typ uint8, body []byte) []byte {
+	return encodePacket(typ, r.id, r.area, body)
+}
+
+func (r *Router) send(ifc *iface, dst net.IP, pkt []byte) {
+	if ifc.tr == nil || pkt == nil {
+		return
+	}
+	_ = ifc.tr.Send(dst, pkt)
+}
+
+func (r *Router) sendHello(ifc *iface) {
+	h := hello{
+		mask:          ifc.mask,
+		helloInterval: uint16(ifc.cfg.HelloInterval / time.Second),
+		options:       optionE,
+		priority:      uint8(ifc.cfg.Priority),
+		deadInterval:  uint32(ifc.cfg.DeadInterval / time.Second),
+		dr:            ifc.dr,
+		bdr:           ifc.bdr,
+	}
+	for _, n := range sortedNeighbors(ifc) {
+		if n.state >= nbrInit {
+			h.neighbors = append(h.neighbors, n.id)
+		}
+	}
+	r.send(ifc, AllSPFRouters, r.packet(typeHello, encodeHello(h)))
+}
+
+func (r *Router) sendUpdate(ifc *iface, dst net.IP, lsas []*lsa, now time.Time) {
+	for len(lsas) > 0 {
+		count := len(lsas)
+		if count > maxLSAsPerUpdate {
+			count = maxLSAsPerUpdate
+		}
+		raws := make([][]byte, 0, count)
+		for _, l := range lsas[:count] {
+			raws = append(raws, l.wire(now))
+		}
+		r.send(ifc, dst, r.packet(typeLSU, encodeLSU(raws)))
+		lsas = lsas[count:]
+	}
+}
+
+func (r *Router) retransmitting(key lsaKey) bool {
+	for _, ifc := range r.ifaces {
+		for _, n := range ifc.nbrs {
+			if _, ok := n.rxmt[key]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Router) exchanging() bool {
+	for _, ifc := range r.ifaces {
+		for _, n := range ifc.nbrs {
+			if n.state == nbrExchange || n.state == nbrLoading {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r *Router) ifaceByAddr(addr uint32) *iface {
+	for _, ifc := range r.ifaces {
+		if ifc.addr == addr {
+			return ifc
+		}
+	}
+	return nil
+}
+
+func (r *Router) sortedKeys() []lsaKey {
+	keys := make([]lsaKey, 0, len(r.lsdb))
+	for key := range r.lsdb {
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+	return keys
+}
+
+func sortKeys(keys []lsaKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].typ != keys[j].typ {
+			return keys[i].typ < keys[j].typ
+		}
+		if keys[i].id != keys[j].id {
+			return keys[i].id < keys[j].id
+		}
+		return keys[i].advRouter < keys[j].advRouter
+	})
+}
+
+func sortedNeighbors(ifc *iface) []*neighbor {
+	out := make([]*neighbor, 0, len(ifc.nbrs))
+	for _, n := range ifc.nbrs {
+		out = append(out, n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].id < out[j].id })
+	return out
+}
+
+func fullNeighbors(ifc *iface) int {
+	count := 0
+	for _, n := range ifc.nbrs {
+		if n.state == nbrFull {
+			count++
+		}
+	}
+	return count
+}
+
+func hasLink(l *lsa, typ uint8, id uint32) bool {
+	links, err := decodeRouterLinks(l.body)
+	if err != nil {
+		return false
+	}
+	for _, link := range links {
+		if link.typ == typ && link.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+func transitAddress(l *lsa, dr uint32) (uint32, bool) {
+	links, err := decodeRouterLinks(l.body)
+	if err != nil {
+		return 0, false
+	}
+	for _, link := range links {
+		if link.typ == linkTransit && link.id == dr {
+			return link.data, true
+		}
+	}
+	return 0, false
+}
+
+func networkFor(candidates []*lsa, router uint32) *lsa {
+	for _, l := range candidates {
+		_, attached, err := decodeNetwork(l.body)
+		if err != nil {
+			continue
+		}
+		for _, id := range attached {
+			if id == router {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+func mergeHops(a []spfHop, b []spfHop) []spfHop {
+	out := append([]spfHop(nil), a...)
+	for _, hop := range b {
+		found := false
+		for _, existing := range out {
+			if existing == hop {
+				found = true
+				break
+			}
+		}
+		if !found {
+			out = append(out, hop)
+		}
+	}
+	return out
+}
+
+func prefixFor(addr uint32, mask uint32) net.IPNet {
+	return net.IPNet{IP: u32ToIP(addr), Mask: net.IPMask(u32ToIP(mask))}
+}
+
+func addrString(v uint32) string {
+	if v == 0 {
+		return ""
+	}
+	return u32ToIP(v).String()
+}
+
+func linkTypeName(typ uint8) string {
+	switch typ {
+	case linkPointToPoint:
+		return "point-to-point"
+	case linkTransit:
+		return "transit"
+	case linkStub:
+		return "stub"
+	}
+	return fmt.Sprintf("%d", typ)
+}
@@ -0,0 +1,236 @@
+package ospf
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"router-go/pkg/routing"
+)
+
+type segment struct {
+	mu      sync.Mutex
+	members map[string]*memTransport
+}
+
+type memPacket struct {
+	src net.IP
+	pkt []byte
+}
+
+type memTransport struct {
+	seg    *segment
+	ip     net.IP
+	ch     chan memPacket
+	done   chan struct{}
+	closed sync.Once
+}
+
+type fabric struct {
+	segments map[string]*segment
+}
+
+func newFabric() *fabric {
+	return &fabric{segments: map[string]*segment{}}
+}
+
+func (f *fabric) transport(cfg InterfaceConfig) (Transport, error) {
+	prefix := net.IPNet{IP: cfg.Address.IP.Mask(cfg.Address.Mask), Mask: cfg.Address.Mask}
+	seg := f.segments[prefix.String()]
+	if seg == nil {
+		seg = &segment{members: map[string]*memTransport{}}
+		f.segments[prefix.String()] = seg
+	}
+	tr := &memTransport{seg: seg, ip: cfg.Address.IP, ch: make(chan memPacket, 512), done: make(chan struct{})}
+	seg.mu.Lock()
+	seg.members[tr.ip.String()] = tr
+	seg.mu.Unlock()
+	return tr, nil
+}
+
+func (t *memTransport) Send(dst net.IP, pkt []byte) error {
+	t.seg.mu.Lock()
+	defer t.seg.mu.Unlock()
+	for ip, member := range t.seg.members {
+		if member == t || (!dst.IsMulticast() && ip != dst.String()) {
+			continue
+		}
+		select {
+		case member.ch <- memPacket{src: t.ip, pkt: append([]byte(nil), pkt...)}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (t *memTransport) Receive() (net.IP, []byte, error) {
+	select {
+	case p := <-t.ch:
+		return p.src, p.pkt, nil
+	case <-t.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (t *memTransport) Close() error {
+	t.closed.Do(func() {
+		t.seg.mu.Lock()
+		delete(t.seg.members, t.ip.String())
+		t.seg.mu.Unlock()
+		close(t.done)
+	})
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func mustIface(t *testing.T, name string, cidr string, network string, cost int, priority int, passive bool) InterfaceConfig {
+	t.Helper()
+	ip, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cidr, err)
+	}
+	return InterfaceConfig{
+		Name:               name,
+		Address:            net.IPNet{IP: ip, Mask: prefix.Mask},
+		Network:            network,
+		Cost:               cost,
+		Priority:           priority,
+		HelloInterval:      20 * time.Millisecond,
+		DeadInterval:       200 * time.Millisecond,
+		RetransmitInterval: 50 * time.Millisecond,
+		Passive:            passive,
+	}
+}
+
+func startRouter(t *testing.T, ctx context.Context, f *fabric, id string, ifaces ...InterfaceConfig) (*Router, *routing.Table) {
+	t.Helper()
+	table := routing.NewTable(nil)
+	r, err := NewRouter(Config{RouterID: net.ParseIP(id), Interfaces: ifaces, Tick: 5 * time.Millisecond}, table)
+	if err != nil {
+		t.Fatalf("new router %s: %v", id, err)
+	}
+	r.SetTransport(f.transport)
+	if err := r.Start(ctx); err != nil {
+		t.Fatalf("start router %s: %v", id, err)
+	}
+	return r, table
+}
+
+func neighborState(r *Router, id string) string {
+	for _, n := range r.Neighbors() {
+		if n.RouterID == id {
+			return n.State
+		}
+	}
+	return ""
+}
+
+func routeTo(table *routing.Table, dst string) (routing.Route, bool) {
+	for _, route := range table.Routes() {
+		if route.Destination.String() == dst {
+			return route, true
+		}
+	}
+	return routing.Route{}, false
+}
+
+func TestRoutersFormAdjacenciesAndInstallSPFRoutes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFabric()
+	r1, table1 := startRouter(t, ctx, f, "1.1.1.1",
+		mustIface(t, "lan", "10.0.12.1/24", NetworkBroadcast, 10, 1, false),
+		mustIface(t, "users", "192.168.1.1/24", NetworkBroadcast, 1, 1, true),
+	)
+	r2, table2 := startRouter(t, ctx, f, "2.2.2.2",
+		mustIface(t, "lan", "10.0.12.2/24", NetworkBroadcast, 10, 1, false),
+		mustIface(t, "p2p", "10.0.24.1/30", NetworkPointToPoint, 5, 1, false),
+	)
+	r3, _ := startRouter(t, ctx, f, "3.3.3.3",
+		mustIface(t, "lan", "10.0.12.3/24", NetworkBroadcast, 10, 1, false),
+	)
+	r5, _ := startRouter(t, ctx, f, "5.5.5.5",
+		mustIface(t, "lan", "10.0.12.5/24", NetworkBroadcast, 10, 0, false),
+	)
+	r4ctx, stopR4 := context.WithCancel(ctx)
+	r4, table4 := startRouter(t, r4ctx, f, "4.4.4.4",
+		mustIface(t, "p2p", "10.0.24.2/30", NetworkPointToPoint, 5, 1, false),
+		mustIface(t, "branch", "192.168.4.1/24", NetworkBroadcast, 1, 1, true),
+	)
+
+	waitFor(t, "full adjacencies", func() bool {
+		return neighborState(r1, "3.3.3.3") == "full" &&
+			neighborState(r1, "2.2.2.2") == "full" &&
+			neighborState(r2, "4.4.4.4") == "full" &&
+			neighborState(r5, "3.3.3.3") == "full" &&
+			neighborState(r3, "5.5.5.5") == "full"
+	})
+	waitFor(t, "ospf routes on r1 and r4", func() bool {
+		_, ok1 := routeTo(table1, "192.168.4.0/24")
+		_, ok4 := routeTo(table4, "192.168.1.0/24")
+		return ok1 && ok4
+	})
+
+	if state := neighborState(r1, "5.5.5.5"); state != "2-way" {
+		t.Fatalf("expected dr_other peers to stay 2-way, got %q", state)
+	}
+	for _, status := range r3.Interfaces() {
+		if status.State != IfaceDR || status.BDR != "10.0.12.2" {
+			t.Fatalf("expected r3 to be dr with r2 as backup: %+v", status)
+		}
+	}
+
+	route, _ := routeTo(table1, "192.168.4.0/24")
+	if !route.Gateway.Equal(net.ParseIP("10.0.12.2")) || route.Interface != "lan" || route.Metric != 16 || route.Distance != Distance || route.Source != Source {
+		t.Fatalf("unexpected route on r1: %+v", route)
+	}
+	route, _ = routeTo(table1, "10.0.24.0/30")
+	if route.Metric != 15 {
+		t.Fatalf("expected p2p subnet at cost 15, got %+v", route)
+	}
+	route, _ = routeTo(table4, "192.168.1.0/24")
+	if !route.Gateway.Equal(net.ParseIP("10.0.24.1")) || route.Interface != "p2p" || route.Metric != 16 {
+		t.Fatalf("unexpected route on r4: %+v", route)
+	}
+	if _, ok := routeTo(table2, "10.0.12.0/24"); ok {
+		t.Fatalf("connected networks must not be installed")
+	}
+
+	networkLSA := func(r *Router) (LSAEntry, bool) {
+		for _, entry := range r.LSDB() {
+			if entry.Type == "network" {
+				return entry, true
+			}
+		}
+		return LSAEntry{}, false
+	}
+	waitFor(t, "synchronized lsdb", func() bool {
+		network, ok := networkLSA(r4)
+		return ok && len(network.Attached) == 4 && len(r1.LSDB()) == 6 && len(r4.LSDB()) == 6
+	})
+	if network, _ := networkLSA(r4); network.LinkStateID != "10.0.12.3" || network.AdvRouter != "3.3.3.3" {
+		t.Fatalf("unexpected network lsa: %+v", network)
+	}
+
+	stopR4()
+	waitFor(t, "branch route withdrawn", func() bool {
+		_, ok := routeTo(table1, "192.168.4.0/24")
+		return !ok && neighborState(r2, "4.4.4.4") == ""
+	})
+	if len(table4.Routes()) != 0 {
+		t.Fatalf("stopped router must withdraw its routes: %+v", table4.Routes())
+	}
+}
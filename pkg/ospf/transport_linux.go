@@ -0,0 +1,78 @@
+//go:build linux
+
+package ospf
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type rawTransport struct {
+	conn *net.IPConn
+}
+
+func newRawTransport(cfg InterfaceConfig) (Transport, error) {
+	ifi, err := net.InterfaceByName(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sock := int(fd)
+			mreq := &unix.IPMreqn{Ifindex: int32(ifi.Index)}
+			if sockErr = unix.BindToDevice(sock, cfg.Name); sockErr != nil {
+				return
+			}
+			if sockErr = unix.SetsockoptIPMreqn(sock, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, mreq); sockErr != nil {
+				return
+			}
+			if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, 1); sockErr != nil {
+				return
+			}
+			if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, 0); sockErr != nil {
+				return
+			}
+			if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IP, unix.IP_TOS, 0xc0); sockErr != nil {
+				return
+			}
+			for _, group := range []net.IP{AllSPFRouters, AllDRouters} {
+				join := &unix.IPMreqn{Ifindex: int32(ifi.Index)}
+				copy(join.Multiaddr[:], group)
+				if sockErr = unix.SetsockoptIPMreqn(sock, unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, join); sockErr != nil {
+					return
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	conn, err := lc.ListenPacket(context.Background(), "ip4:89", "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+	return &rawTransport{conn: conn.(*net.IPConn)}, nil
+}
+
+func (t *rawTransport) Send(dst net.IP, pkt []byte) error {
+	_, err := t.conn.WriteToIP(pkt, &net.IPAddr{IP: dst})
+	return err
+}
+
+func (t *rawTransport) Receive() (net.IP, []byte, error) {
+	buf := make([]byte, 65535)
+	n, addr, err := t.conn.ReadFromIP(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return addr.IP, buf[:n], nil
+}
+
+func (t *rawTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package ospf
+
+import "errors"
+
+func newRawTransport(cfg InterfaceConfig) (Transport, error) {
+	return nil, errors.New("ospf raw sockets are only supported on linux")
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"encoding/hex"
 	"net"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -24,8 +25,29 @@ type RouteAdvert struct {
 	Gateway     string `json:"gateway"`
 	Interface   string `json:"interface"`
 	Metric      int    `json:"metric"`
+	Tag         uint32 `json:"tag,omitempty"`
+	Table       string `json:"table,omitempty"`
 }
 
+// FilteredRoute is a prefix currently rejected by the import or export route
+// map, with the trace of the entries that were evaluated.
+type FilteredRoute struct {
+	Direction   string                 `json:"direction"`
+	Destination string                 `json:"destination"`
+	Table       string                 `json:"table,omitempty"`
+	RouteMap    string                 `json:"route_map"`
+	Seq         int                    `json:"seq,omitempty"`
+	Trace       []routing.RouteMapStep `json:"trace"`
+	At          time.Time              `json:"at"`
+}
+
+const (
+	DirectionImport = "import"
+	DirectionExport = "export"
+
+	maxFilteredRoutes = 1024
+)
+
 type Config struct {
 	PeerID        string
 	Discovery     bool
@@ -35,6 +57,16 @@ type Config struct {
 	MulticastAddr string
 	PrivateKey    ed25519.PrivateKey
 	PublicKey     ed25519.PublicKey
+	ImportMap     string
+	ExportMap     string
+}
+
+// importedRoute is a route accepted from a peer: learned as advertised and
+// route as installed after the import route map.
+type importedRoute struct {
+	table   string
+	learned routing.Route
+	route   routing.Route
 }
 
 type Engine struct {
@@ -42,10 +74,13 @@ type Engine struct {
 	cfg         Config
 	peers       map[string]Peer
 	routes      []routing.Route
-	routeSet    map[string]struct{}
+	routeSet    map[string]importedRoute
 	replayGuard map[string]map[uint64]struct{}
 	seq         uint64
 	table       *routing.Table
+	policy      *routing.Policy
+	filters     *routing.RouteFilters
+	filtered    map[string]FilteredRoute
 	transport   Transport
 	onPeer      func()
 	onRouteSync func()
@@ -73,8 +108,9 @@ func NewEngine(cfg Config, table *routing.Table, transport Transport, onPeer fun
 		cfg:         cfg,
 		peers:       map[string]Peer{},
 		routes:      nil,
-		routeSet:    map[string]struct{}{},
+		routeSet:    map[string]importedRoute{},
 		replayGuard: map[string]map[uint64]struct{}{},
+		filtered:    map[string]FilteredRoute{},
 		table:       table,
 		transport:   transport,
 		onPeer:      onPeer,
@@ -83,6 +119,69 @@ func NewEngine(cfg Config, table *routing.Table, transport Transport, onPeer fun
 	}
 }
 
+func (e *Engine) SetPolicy(policy *routing.Policy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policy = policy
+}
+
+func (e *Engine) SetRouteFilters(filters *routing.RouteFilters) {
+	e.mu.Lock()
+	e.filters = filters
+	e.mu.Unlock()
+	e.Refilter()
+}
+
+// Refilter runs the routes already imported from peers through the import
+// route map again, after the route maps changed. Routes it now denies are
+// withdrawn and routes it now rewrites are replaced.
+func (e *Engine) Refilter() {
+	e.mu.Lock()
+	imported := make(map[string]importedRoute, len(e.routeSet))
+	for key, imp := range e.routeSet {
+		imported[key] = imp
+	}
+	e.mu.Unlock()
+	added := 0
+	for key, imp := range imported {
+		route, ok := e.filter(DirectionImport, imp.table, imp.learned)
+		if ok && routeKey(route) == routeKey(imp.route) && route.Tag == imp.route.Tag {
+			continue
+		}
+		table := e.importTable(imp.table)
+		if table != nil {
+			table.RemoveRoute(imp.route)
+		}
+		e.mu.Lock()
+		delete(e.routeSet, key)
+		e.routes = removeRoute(e.routes, imp.route)
+		e.mu.Unlock()
+		if ok && table != nil && e.importRoute(imp.table, table, imp.learned, route) {
+			added++
+		}
+	}
+	e.routesSynced(added)
+}
+
+func (e *Engine) Filtered() []FilteredRoute {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	out := make([]FilteredRoute, 0, len(e.filtered))
+	for _, entry := range e.filtered {
+		out = append(out, entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Direction != out[j].Direction {
+			return out[i].Direction < out[j].Direction
+		}
+		if out[i].Table != out[j].Table {
+			return out[i].Table < out[j].Table
+		}
+		return out[i].Destination < out[j].Destination
+	})
+	return out
+}
+
 func (e *Engine) Start(ctx context.Context) error {
 	if e.transport == nil {
 		t, err := NewUDPTransport(e.cfg.ListenAddr, e.cfg.MulticastAddr)
@@ -96,7 +195,8 @@ func (e *Engine) Start(ctx context.Context) error {
 	if e.cfg.Discovery {
 		go e.helloLoop(ctx)
 	}
-	go e.syncLoop(ctx)
+	changes, stop, _ := e.table.Watch(e.table.Revision())
+	go e.syncLoop(ctx, changes, stop)
 	return nil
 }
 
@@ -123,8 +223,9 @@ func (e *Engine) Reset() {
 	defer e.mu.Unlock()
 	e.peers = map[string]Peer{}
 	e.routes = nil
-	e.routeSet = map[string]struct{}{}
+	e.routeSet = map[string]importedRoute{}
 	e.replayGuard = map[string]map[uint64]struct{}{}
+	e.filtered = map[string]FilteredRoute{}
 }
 
 func (e *Engine) receiveLoop(ctx context.Context) {
@@ -155,9 +256,12 @@ func (e *Engine) helloLoop(ctx context.Context) {
 	}
 }
 
-func (e *Engine) syncLoop(ctx context.Context) {
+// syncLoop advertises routes every SyncInterval and right after the main
+// table changes, so peers do not wait for the next tick.
+func (e *Engine) syncLoop(ctx context.Context, changes <-chan routing.RouteEvent, stop func()) {
 	ticker := time.NewTicker(e.cfg.SyncInterval)
 	defer ticker.Stop()
+	defer func() { stop() }()
 	for {
 		select {
 		case <-ctx.Done():
@@ -165,6 +269,25 @@ func (e *Engine) syncLoop(ctx context.Context) {
 		case <-ticker.C:
 			_ = e.sendRoutes()
 			e.prunePeers(e.nowFunc())
+		case _, ok := <-changes:
+			if !ok {
+				changes, stop, _ = e.table.Watch(e.table.Revision())
+			}
+			drainEvents(changes)
+			_ = e.sendRoutes()
+		}
+	}
+}
+
+func drainEvents(ch <-chan routing.RouteEvent) {
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		default:
+			return
 		}
 	}
 }
@@ -180,12 +303,31 @@ func (e *Engine) sendRoutes() error {
 	routes := e.table.Routes()
 	adverts := make([]RouteAdvert, 0, len(routes))
 	for _, route := range routes {
-		adverts = append(adverts, RouteAdvert{
-			Destination: route.Destination.String(),
-			Gateway:     route.Gateway.String(),
-			Interface:   route.Interface,
-			Metric:      route.Metric,
-		})
+		if !advertisable(route) {
+			continue
+		}
+		if route, ok := e.filter(DirectionExport, "", route); ok {
+			adverts = append(adverts, routeAdvert(route, ""))
+		}
+	}
+	if policy := e.currentPolicy(); policy != nil {
+		for _, name := range policy.TableNames() {
+			if name == routing.MainTable {
+				continue
+			}
+			table, ok := policy.Table(name)
+			if !ok {
+				continue
+			}
+			for _, route := range table.Routes() {
+				if !advertisable(route) {
+					continue
+				}
+				if route, ok := e.filter(DirectionExport, name, route); ok {
+					adverts = append(adverts, routeAdvert(route, name))
+				}
+			}
+		}
 	}
 	return e.sendMessage(message{
 		Type:   "ROUTES",
@@ -260,30 +402,103 @@ func (e *Engine) applyRoutes(adverts []RouteAdvert) {
 		if err != nil {
 			continue
 		}
+		table := e.importTable(adv.Table)
+		if table == nil {
+			continue
+		}
 		gw := net.ParseIP(adv.Gateway)
 		route := routing.Route{
 			Destination: *dst,
 			Gateway:     gw,
 			Interface:   adv.Interface,
 			Metric:      adv.Metric,
+			Distance:    routing.DefaultDistance(routing.SourceP2P),
+			Source:      routing.SourceP2P,
+			Tag:         adv.Tag,
 		}
-		if !routeExists(e.table.Routes(), route) {
-			e.table.Add(route)
-			added++
+		learned := route
+		route, ok := e.filter(DirectionImport, adv.Table, route)
+		if !ok {
+			continue
 		}
-		key := routeKey(route)
-		e.mu.Lock()
-		if _, exists := e.routeSet[key]; !exists {
-			e.routeSet[key] = struct{}{}
-			e.routes = append(e.routes, route)
+		if e.importRoute(adv.Table, table, learned, route) {
+			added++
 		}
-		e.mu.Unlock()
 	}
-	if added > 0 && e.onRouteSync != nil {
-		for i := 0; i < added; i++ {
-			e.onRouteSync()
+	e.routesSynced(added)
+}
+
+// importRoute installs a route that passed the import map and remembers it
+// with the route as learned, so Refilter can evaluate it again. It reports
+// whether the table gained a route.
+func (e *Engine) importRoute(tableName string, table *routing.Table, learned routing.Route, route routing.Route) bool {
+	added := false
+	if !routeExists(table.Routes(), route) {
+		table.Add(route)
+		added = true
+	}
+	key := tableName + "|" + routeKey(route)
+	e.mu.Lock()
+	if _, exists := e.routeSet[key]; !exists {
+		e.routeSet[key] = importedRoute{table: tableName, learned: learned, route: route}
+		e.routes = append(e.routes, route)
+	}
+	e.mu.Unlock()
+	return added
+}
+
+func (e *Engine) routesSynced(added int) {
+	if e.onRouteSync == nil {
+		return
+	}
+	for i := 0; i < added; i++ {
+		e.onRouteSync()
+	}
+}
+
+// importTable returns the table adverts for the named table go to; nil when
+// a named table is advertised but policy routing is not set up.
+func (e *Engine) importTable(name string) *routing.Table {
+	if name == "" || name == routing.MainTable {
+		return e.table
+	}
+	policy := e.currentPolicy()
+	if policy == nil {
+		return nil
+	}
+	return policy.EnsureTable(name)
+}
+
+// filter applies the import or export route map and remembers rejected
+// prefixes so they can be inspected through Filtered.
+func (e *Engine) filter(direction string, table string, route routing.Route) (routing.Route, bool) {
+	e.mu.Lock()
+	filters := e.filters
+	e.mu.Unlock()
+	name := e.cfg.ImportMap
+	if direction == DirectionExport {
+		name = e.cfg.ExportMap
+	}
+	result := filters.Apply(name, route)
+	key := direction + "|" + table + "|" + route.Destination.String()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if result.Permit {
+		delete(e.filtered, key)
+		return result.Route, true
+	}
+	if _, exists := e.filtered[key]; exists || len(e.filtered) < maxFilteredRoutes {
+		e.filtered[key] = FilteredRoute{
+			Direction:   direction,
+			Destination: route.Destination.String(),
+			Table:       table,
+			RouteMap:    name,
+			Seq:         result.Seq,
+			Trace:       result.Trace,
+			At:          e.nowFunc().UTC(),
 		}
 	}
+	return route, false
 }
 
 func (e *Engine) prunePeers(now time.Time) {
@@ -358,16 +573,51 @@ func signaturePayload(msg message) []byte {
 	return b
 }
 
+func (e *Engine) currentPolicy() *routing.Policy {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.policy
+}
+
+// advertisable limits adverts to configured routes. Connected subnets only
+// make sense on the router that owns them, leaked routes belong to another
+// VRF, and routes learned from BGP, OSPF, RIP or other peers are not
+// redistributed.
+func advertisable(route routing.Route) bool {
+	return route.TargetVRF == "" && route.Configured()
+}
+
+func routeAdvert(route routing.Route, table string) RouteAdvert {
+	return RouteAdvert{
+		Destination: route.Destination.String(),
+		Gateway:     route.Gateway.String(),
+		Interface:   route.Interface,
+		Metric:      route.Metric,
+		Tag:         route.Tag,
+		Table:       table,
+	}
+}
+
 func routeKey(route routing.Route) string {
 	return route.Destination.String() + "|" + route.Gateway.String() + "|" + route.Interface + "|" + strconv.Itoa(route.Metric)
 }
 
+func removeRoute(routes []routing.Route, route routing.Route) []routing.Route {
+	for i, r := range routes {
+		if routeKey(r) == routeKey(route) {
+			return append(routes[:i:i], routes[i+1:]...)
+		}
+	}
+	return routes
+}
+
 func routeExists(routes []routing.Route, route routing.Route) bool {
 	for _, r := range routes {
 		if r.Destination.String() == route.Destination.String() &&
 			r.Gateway.String() == route.Gateway.String() &&
 			r.Interface == route.Interface &&
-			r.Metric == route.Metric {
+			r.Metric == route.Metric &&
+			r.Distance == route.Distance {
 			return true
 		}
 	}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"encoding/json"
+	"net"
 	"testing"
 	"time"
 
@@ -17,7 +18,7 @@ func TestEngineReset(t *testing.T) {
 
 	engine.peers["node-2"] = Peer{ID: "node-2", Addr: "127.0.0.1:10000", LastSeen: time.Now()}
 	engine.routes = []routing.Route{{Interface: "eth0"}}
-	engine.routeSet["r1"] = struct{}{}
+	engine.routeSet["r1"] = importedRoute{}
 	engine.replayGuard["node-2"] = map[uint64]struct{}{1: {}}
 
 	engine.Reset()
@@ -130,6 +131,182 @@ func TestRoutesDedup(t *testing.T) {
 	}
 }
 
+func TestAppliedRoutesCarryP2PSource(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/24")
+	_, ospf, _ := net.ParseCIDR("10.3.0.0/16")
+	_, static, _ := net.ParseCIDR("10.4.0.0/16")
+	table := routing.NewTable([]routing.Route{
+		{Destination: *lan, Interface: "eth0", Source: routing.SourceConnected},
+		{Destination: *ospf, Gateway: net.ParseIP("10.0.0.2"), Interface: "eth0", Source: "ospf", Distance: 110},
+		{Destination: *static, Gateway: net.ParseIP("10.0.0.3"), Interface: "eth0", Source: routing.SourceStatic, Distance: 1},
+	})
+	engine := NewEngine(Config{PeerID: "self"}, table, nil, nil, nil)
+	engine.applyRoutes([]RouteAdvert{
+		{Destination: "10.1.0.0/16", Gateway: "192.168.1.1", Interface: "eth0"},
+	})
+	route, ok := table.Lookup(net.ParseIP("10.1.2.3"))
+	if !ok || route.Source != routing.SourceP2P || route.Distance != 150 {
+		t.Fatalf("unexpected imported route: %+v", route)
+	}
+
+	mt := &mockTransport{}
+	engine.transport = mt
+	if err := engine.sendRoutes(); err != nil {
+		t.Fatalf("send routes: %v", err)
+	}
+	if len(mt.sent) != 1 || !bytes.Contains(mt.sent[0], []byte("10.4.0.0/16")) {
+		t.Fatalf("expected static route to be advertised")
+	}
+	for _, prefix := range []string{"10.0.0.0/24", "10.1.0.0/16", "10.3.0.0/16"} {
+		if bytes.Contains(mt.sent[0], []byte(prefix)) {
+			t.Fatalf("expected %s not to be advertised: %s", prefix, mt.sent[0])
+		}
+	}
+	if bytes.Contains(mt.sent[0], []byte("distance")) {
+		t.Fatalf("expected distance not to be sent: %s", mt.sent[0])
+	}
+}
+
+func TestRouteMapsFilterImportAndExport(t *testing.T) {
+	_, any4, _ := net.ParseCIDR("0.0.0.0/0")
+	_, lan, _ := net.ParseCIDR("192.168.10.0/24")
+	filters := routing.NewRouteFilters()
+	if err := filters.SetPrefixList(routing.PrefixList{Name: "default", Entries: []routing.PrefixListEntry{
+		{Seq: 10, Action: routing.ActionPermit, Prefix: *any4},
+	}}); err != nil {
+		t.Fatalf("prefix list: %v", err)
+	}
+	tag := uint32(7)
+	if err := filters.SetRouteMap(routing.RouteMap{Name: "in", Entries: []routing.RouteMapEntry{
+		{Seq: 10, Action: routing.ActionDeny, MatchPrefixList: "default"},
+		{Seq: 20, Action: routing.ActionPermit, SetTag: &tag},
+	}}); err != nil {
+		t.Fatalf("route map: %v", err)
+	}
+	if err := filters.SetRouteMap(routing.RouteMap{Name: "out", Entries: []routing.RouteMapEntry{
+		{Seq: 10, Action: routing.ActionPermit, MatchSource: routing.SourceStatic},
+	}}); err != nil {
+		t.Fatalf("route map: %v", err)
+	}
+
+	table := routing.NewTable([]routing.Route{
+		{Destination: *lan, Gateway: net.ParseIP("10.0.0.1"), Source: routing.SourceStatic, Distance: 1},
+	})
+	engine := NewEngine(Config{PeerID: "self", ImportMap: "in", ExportMap: "out"}, table, nil, nil, nil)
+	engine.SetRouteFilters(filters)
+	engine.applyRoutes([]RouteAdvert{
+		{Destination: "0.0.0.0/0", Gateway: "192.168.1.1", Interface: "eth0"},
+		{Destination: "10.5.0.0/16", Gateway: "192.168.1.1", Interface: "eth0"},
+	})
+	if _, ok := table.Lookup(net.ParseIP("203.0.113.1")); ok {
+		t.Fatalf("expected default route to be rejected")
+	}
+	route, ok := table.Lookup(net.ParseIP("10.5.1.1"))
+	if !ok || route.Tag != 7 {
+		t.Fatalf("expected tagged route, got %+v", route)
+	}
+	filtered := engine.Filtered()
+	if len(filtered) != 1 || filtered[0].Direction != DirectionImport || filtered[0].Destination != "0.0.0.0/0" || filtered[0].Seq != 10 {
+		t.Fatalf("unexpected filtered routes: %+v", filtered)
+	}
+
+	_, api, _ := net.ParseCIDR("10.6.0.0/16")
+	table.Add(routing.Route{Destination: *api, Gateway: net.ParseIP("10.0.0.1"), Source: routing.SourceAPI, Distance: 1})
+	mt := &mockTransport{}
+	engine.transport = mt
+	if err := engine.sendRoutes(); err != nil {
+		t.Fatalf("send routes: %v", err)
+	}
+	if len(mt.sent) != 1 || !bytes.Contains(mt.sent[0], []byte("192.168.10.0/24")) || bytes.Contains(mt.sent[0], []byte("10.5.0.0/16")) || bytes.Contains(mt.sent[0], []byte("10.6.0.0/16")) {
+		t.Fatalf("expected only the static route to be exported: %s", mt.sent)
+	}
+	if len(engine.Filtered()) != 2 {
+		t.Fatalf("expected export rejection to be recorded: %+v", engine.Filtered())
+	}
+}
+
+func TestRouteMapChangeWithdrawsImportedRoutes(t *testing.T) {
+	filters := routing.NewRouteFilters()
+	if err := filters.SetRouteMap(routing.RouteMap{Name: "in", Entries: []routing.RouteMapEntry{
+		{Seq: 10, Action: routing.ActionPermit},
+	}}); err != nil {
+		t.Fatalf("route map: %v", err)
+	}
+	table := routing.NewTable(nil)
+	engine := NewEngine(Config{PeerID: "self", ImportMap: "in"}, table, nil, nil, nil)
+	engine.SetRouteFilters(filters)
+	filters.SetOnChange(engine.Refilter)
+	engine.applyRoutes([]RouteAdvert{
+		{Destination: "10.5.0.0/16", Gateway: "192.168.1.1", Interface: "eth0"},
+		{Destination: "10.7.0.0/16", Gateway: "192.168.1.1", Interface: "eth0"},
+	})
+	if len(engine.Routes()) != 2 {
+		t.Fatalf("expected both routes to be imported, got %+v", engine.Routes())
+	}
+
+	_, blocked, _ := net.ParseCIDR("10.5.0.0/16")
+	if err := filters.SetPrefixList(routing.PrefixList{Name: "blocked", Entries: []routing.PrefixListEntry{
+		{Seq: 10, Action: routing.ActionPermit, Prefix: *blocked},
+	}}); err != nil {
+		t.Fatalf("prefix list: %v", err)
+	}
+	tag := uint32(9)
+	if err := filters.SetRouteMap(routing.RouteMap{Name: "in", Entries: []routing.RouteMapEntry{
+		{Seq: 10, Action: routing.ActionDeny, MatchPrefixList: "blocked"},
+		{Seq: 20, Action: routing.ActionPermit, SetTag: &tag},
+	}}); err != nil {
+		t.Fatalf("route map: %v", err)
+	}
+	if _, ok := table.Lookup(net.ParseIP("10.5.1.1")); ok {
+		t.Fatalf("expected denied route to be withdrawn")
+	}
+	if route, ok := table.Lookup(net.ParseIP("10.7.1.1")); !ok || route.Tag != 9 {
+		t.Fatalf("expected kept route to be rewritten by the new map, got %+v", route)
+	}
+	routes := engine.Routes()
+	if len(routes) != 1 || routes[0].Destination.String() != "10.7.0.0/16" || len(table.Routes()) != 1 {
+		t.Fatalf("expected only the permitted route to stay, got %+v", routes)
+	}
+	if filtered := engine.Filtered(); len(filtered) != 1 || filtered[0].Destination != "10.5.0.0/16" {
+		t.Fatalf("expected the withdrawn route to be recorded as filtered, got %+v", filtered)
+	}
+}
+
+func TestRoutesSyncIntoPolicyTables(t *testing.T) {
+	table := routing.NewTable(nil)
+	policy := routing.NewPolicy(table)
+	engine := NewEngine(Config{PeerID: "self"}, table, nil, nil, nil)
+	engine.applyRoutes([]RouteAdvert{
+		{Destination: "0.0.0.0/0", Gateway: "198.51.100.1", Interface: "wan2", Table: "isp2"},
+	})
+	if len(table.Routes()) != 0 || len(engine.Routes()) != 0 {
+		t.Fatalf("expected table advert to be ignored without policy")
+	}
+
+	engine.SetPolicy(policy)
+	engine.applyRoutes([]RouteAdvert{
+		{Destination: "0.0.0.0/0", Gateway: "198.51.100.1", Interface: "wan2", Table: "isp2"},
+	})
+	isp2, ok := policy.Table("isp2")
+	if !ok || len(isp2.Routes()) != 1 || len(table.Routes()) != 0 {
+		t.Fatalf("expected route in isp2 table only")
+	}
+
+	_, lan, _ := net.ParseCIDR("192.168.20.0/24")
+	isp2.Add(routing.Route{Destination: *lan, Gateway: net.ParseIP("10.0.0.1"), Source: routing.SourceStatic, Distance: 1})
+	mt := &mockTransport{}
+	engine.transport = mt
+	if err := engine.sendRoutes(); err != nil {
+		t.Fatalf("send routes: %v", err)
+	}
+	if len(mt.sent) != 1 || !bytes.Contains(mt.sent[0], []byte(`"destination":"192.168.20.0/24"`)) || !bytes.Contains(mt.sent[0], []byte(`"table":"isp2"`)) {
+		t.Fatalf("expected isp2 routes to be advertised")
+	}
+	if bytes.Contains(mt.sent[0], []byte("0.0.0.0/0")) {
+		t.Fatalf("expected learned route not to be advertised again: %s", mt.sent[0])
+	}
+}
+
 func TestReplayRejected(t *testing.T) {
 	table := routing.NewTable(nil)
 	peerPub, peerPriv, _ := ed25519.GenerateKey(nil)
@@ -195,3 +372,42 @@ func TestStartUsesExistingTransport(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+type notifyTransport struct {
+	sent chan []byte
+}
+
+func (n *notifyTransport) Send(data []byte) error {
+	n.sent <- append([]byte(nil), data...)
+	return nil
+}
+
+func (n *notifyTransport) Receive(ctx context.Context) ([]byte, string, error) {
+	<-ctx.Done()
+	return nil, "", ctx.Err()
+}
+
+func (n *notifyTransport) Close() error {
+	return nil
+}
+
+func TestTableChangeTriggersRouteSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nt := &notifyTransport{sent: make(chan []byte, 8)}
+	table := routing.NewTable(nil)
+	engine := NewEngine(Config{PeerID: "node-1", SyncInterval: time.Hour}, table, nt, nil, nil)
+	if err := engine.Start(ctx); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	_, dst, _ := net.ParseCIDR("10.44.0.0/16")
+	table.Add(routing.Route{Destination: *dst, Gateway: net.ParseIP("10.0.0.1"), Source: routing.SourceStatic})
+	select {
+	case data := <-nt.sent:
+		if !bytes.Contains(data, []byte("10.44.0.0/16")) {
+			t.Fatalf("expected new route in triggered sync: %s", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected routes to be sent after table change")
+	}
+}
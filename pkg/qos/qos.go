@@ -5,14 +5,24 @@ import (
 	"sync"
 	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
+	"router-go/pkg/schedule"
 )
 
+// Class selects packets by protocol, ports and ip sets. SrcSet/DstSet name an
+// ip set, "!name" negates. A class with a Schedule only classifies packets
+// while the schedule is active.
 type Class struct {
 	Name          string
 	Protocol      string
 	SrcPort       int
 	DstPort       int
+	SrcPorts      network.PortMatch
+	DstPorts      network.PortMatch
+	SrcSet        string
+	DstSet        string
+	Schedule      string
 	RateLimitKbps int
 	Priority      int
 	MaxQueue      int
@@ -24,31 +34,43 @@ type Classifier struct {
 }
 
 func NewClassifier(classes []Class) *Classifier {
-	return &Classifier{classes: classes}
+	out := make([]Class, 0, len(classes))
+	for _, cl := range classes {
+		out = append(out, normalizeClass(cl))
+	}
+	return &Classifier{classes: out}
 }
 
 func (c *Classifier) Classify(pkt network.Packet) *Class {
 	for i := range c.classes {
 		cl := &c.classes[i]
-		if cl.Protocol != "" && !strings.EqualFold(cl.Protocol, pkt.Metadata.Protocol) {
-			continue
-		}
-		if cl.SrcPort != 0 && cl.SrcPort != pkt.Metadata.SrcPort {
-			continue
-		}
-		if cl.DstPort != 0 && cl.DstPort != pkt.Metadata.DstPort {
-			continue
+		if cl.matches(nil, nil, pkt) {
+			return cl
 		}
-		return cl
 	}
 	return nil
 }
 
+func (cl *Class) matches(sets *ipset.Registry, schedules *schedule.Registry, pkt network.Packet) bool {
+	if cl.Protocol != "" && !strings.EqualFold(cl.Protocol, pkt.Metadata.Protocol) {
+		return false
+	}
+	if !cl.SrcPorts.Matches(pkt.Metadata.SrcPort) || !cl.DstPorts.Matches(pkt.Metadata.DstPort) {
+		return false
+	}
+	if !sets.Match(cl.SrcSet, pkt.Metadata.SrcIP) || !sets.Match(cl.DstSet, pkt.Metadata.DstIP) {
+		return false
+	}
+	return cl.Schedule == "" || schedules.Active(cl.Schedule)
+}
+
 type QueueManager struct {
-	mu      sync.Mutex
-	classes []Class
-	queues  map[string][]network.Packet
-	buckets map[string]*TokenBucket
+	mu        sync.Mutex
+	classes   []Class
+	queues    map[string][]network.Packet
+	buckets   map[string]*TokenBucket
+	sets      *ipset.Registry
+	schedules *schedule.Registry
 }
 
 func NewQueueManager(classes []Class) *QueueManager {
@@ -60,6 +82,21 @@ func NewQueueManager(classes []Class) *QueueManager {
 	}
 }
 
+// SetIPSets attaches the registry that SrcSet/DstSet references resolve
+// against.
+func (q *QueueManager) SetIPSets(sets *ipset.Registry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sets = sets
+}
+
+// SetSchedules attaches the registry Schedule references resolve against.
+func (q *QueueManager) SetSchedules(schedules *schedule.Registry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.schedules = schedules
+}
+
 func (q *QueueManager) Enqueue(pkt network.Packet) (bool, bool, string) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -197,16 +234,9 @@ func (q *QueueManager) classify(pkt network.Packet) Class {
 		if cl.Name == "default" {
 			continue
 		}
-		if cl.Protocol != "" && !strings.EqualFold(cl.Protocol, pkt.Metadata.Protocol) {
-			continue
-		}
-		if cl.SrcPort != 0 && cl.SrcPort != pkt.Metadata.SrcPort {
-			continue
-		}
-		if cl.DstPort != 0 && cl.DstPort != pkt.Metadata.DstPort {
-			continue
+		if cl.matches(q.sets, q.schedules, pkt) {
+			return cl
 		}
-		return cl
 	}
 	for _, cl := range q.classes {
 		if cl.Name == "default" {
@@ -255,7 +285,9 @@ func normalizeDropPolicy(value string) string {
 
 func normalizeClasses(classes []Class) []Class {
 	out := make([]Class, 0, len(classes)+1)
-	out = append(out, classes...)
+	for _, cl := range classes {
+		out = append(out, normalizeClass(cl))
+	}
 	hasDefault := false
 	for _, cl := range out {
 		if cl.Name == "default" {
@@ -277,6 +309,23 @@ func normalizeClasses(classes []Class) []Class {
 	return out
 }
 
+// normalizeClass folds the single port fields into the port matchers and
+// canonicalises set references.
+func normalizeClass(cl Class) Class {
+	if cl.SrcPorts.IsZero() {
+		cl.SrcPorts = network.SinglePort(cl.SrcPort)
+	}
+	if cl.DstPorts.IsZero() {
+		cl.DstPorts = network.SinglePort(cl.DstPort)
+	}
+	cl.SrcPort, _ = cl.SrcPorts.Single()
+	cl.DstPort, _ = cl.DstPorts.Single()
+	cl.SrcSet = ipset.NormalizeRef(cl.SrcSet)
+	cl.DstSet = ipset.NormalizeRef(cl.DstSet)
+	cl.Schedule = strings.TrimSpace(cl.Schedule)
+	return cl
+}
+
 func appendOrReplaceClass(classes []Class, class Class) []Class {
 	out := make([]Class, 0, len(classes)+1)
 	replaced := false
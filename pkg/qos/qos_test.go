@@ -1,10 +1,13 @@
 package qos
 
 import (
+	"net"
 	"testing"
 	"time"
 
+	"router-go/pkg/ipset"
 	"router-go/pkg/network"
+	"router-go/pkg/schedule"
 )
 
 func TestClassifierMatch(t *testing.T) {
@@ -29,6 +32,18 @@ func TestClassifierMatch(t *testing.T) {
 	}
 }
 
+func TestClassifierPortRange(t *testing.T) {
+	rtp, _ := network.ParsePortMatch("5060,10000-20000")
+	classifier := NewClassifier([]Class{{Name: "voice", Protocol: "UDP", DstPorts: rtp}})
+
+	for port, want := range map[int]bool{5060: true, 15000: true, 5061: false} {
+		class := classifier.Classify(network.Packet{Metadata: network.PacketMetadata{Protocol: "UDP", DstPort: port}})
+		if (class != nil) != want {
+			t.Fatalf("port %d: expected match=%v", port, want)
+		}
+	}
+}
+
 func TestClassifierNoMatch(t *testing.T) {
 	classifier := NewClassifier([]Class{
 		{
@@ -307,3 +322,37 @@ func containsClass(classes []Class, name string) bool {
 	}
 	return false
 }
+
+func TestQueueManagerIPSetClass(t *testing.T) {
+	sets := ipset.NewRegistry()
+	_ = sets.Create("voip", ipset.TypeHashIP, 0)
+	member, _ := ipset.ParseMember(ipset.TypeHashIP, "10.0.0.50")
+	_ = sets.Add("voip", []ipset.Entry{{Prefix: member}})
+	q := NewQueueManager([]Class{{Name: "phones", SrcSet: "voip", Priority: 5}})
+	q.SetIPSets(sets)
+
+	for src, want := range map[string]string{"10.0.0.50": "phones", "10.0.0.51": "default"} {
+		_, _, class := q.Enqueue(network.Packet{Metadata: network.PacketMetadata{SrcIP: net.ParseIP(src)}})
+		if class != want {
+			t.Fatalf("%s: expected class %s, got %s", src, want, class)
+		}
+	}
+}
+
+func TestQueueManagerScheduledClass(t *testing.T) {
+	office, _ := schedule.ParseRange("09:00-18:00")
+	schedules := schedule.NewRegistry([]schedule.Schedule{{Name: "office", Ranges: []schedule.Range{office}}}, time.UTC)
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	schedules.SetClock(func() time.Time { return now })
+	q := NewQueueManager([]Class{{Name: "backup", Protocol: "TCP", DstPort: 873, Priority: 1, Schedule: "office"}})
+	q.SetSchedules(schedules)
+
+	pkt := network.Packet{Metadata: network.PacketMetadata{Protocol: "TCP", DstPort: 873}}
+	if _, _, class := q.Enqueue(pkt); class != "backup" {
+		t.Fatalf("expected scheduled class during office hours, got %s", class)
+	}
+	now = time.Date(2026, 10, 19, 20, 0, 0, 0, time.UTC)
+	if _, _, class := q.Enqueue(pkt); class != "default" {
+		t.Fatalf("expected default class outside office hours, got %s", class)
+	}
+}
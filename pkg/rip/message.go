@@ -0,0 +1,234 @@
+package rip
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+const (
+	commandRequest  = 1
+	commandResponse = 2
+
+	versionRIPv2 = 2
+	versionRIPng = 1
+
+	afiInet     = 2
+	afiAuth     = 0xffff
+	authMD5     = 3
+	authTrailer = 1
+
+	Infinity = 16
+
+	headerLen      = 4
+	entryLen       = 20
+	md5DigestLen   = 16
+	ngNextHopFlag  = 0xff
+	maxEntriesV2   = 25
+	maxEntriesAuth = 23
+	maxEntriesNG   = 50
+)
+
+type rte struct {
+	prefix  net.IPNet
+	nextHop net.IP
+	metric  int
+	tag     uint16
+}
+
+type message struct {
+	command    uint8
+	entries    []rte
+	wholeTable bool
+	authSeq    uint32
+	authKeyID  uint8
+	authed     bool
+}
+
+type authKey struct {
+	id  uint8
+	key []byte
+}
+
+func newAuthKey(id uint8, key string) *authKey {
+	if key == "" {
+		return nil
+	}
+	padded := make([]byte, md5DigestLen)
+	copy(padded, key)
+	return &authKey{id: id, key: padded}
+}
+
+func encodeV2(command uint8, entries []rte, auth *authKey, seq uint32) []byte {
+	b := make([]byte, headerLen, headerLen+entryLen*(len(entries)+2))
+	b[0] = command
+	b[1] = versionRIPv2
+	if auth != nil {
+		entry := make([]byte, entryLen)
+		binary.BigEndian.PutUint16(entry[0:], afiAuth)
+		binary.BigEndian.PutUint16(entry[2:], authMD5)
+		binary.BigEndian.PutUint16(entry[4:], uint16(headerLen+entryLen*(len(entries)+1)))
+		entry[6] = auth.id
+		entry[7] = 4 + md5DigestLen
+		binary.BigEndian.PutUint32(entry[8:], seq)
+		b = append(b, entry...)
+	}
+	for _, e := range entries {
+		entry := make([]byte, entryLen)
+		if e.prefix.IP != nil {
+			binary.BigEndian.PutUint16(entry[0:], afiInet)
+			copy(entry[4:8], e.prefix.IP.To4())
+			copy(entry[8:12], e.prefix.Mask)
+		}
+		binary.BigEndian.PutUint16(entry[2:], e.tag)
+		if v4 := e.nextHop.To4(); v4 != nil {
+			copy(entry[12:16], v4)
+		}
+		binary.BigEndian.PutUint32(entry[16:], uint32(e.metric))
+		b = append(b, entry...)
+	}
+	if auth != nil {
+		trailer := make([]byte, 4, 4+md5DigestLen)
+		binary.BigEndian.PutUint16(trailer[0:], afiAuth)
+		binary.BigEndian.PutUint16(trailer[2:], authTrailer)
+		b = append(b, trailer...)
+		sum := md5.Sum(append(append([]byte(nil), b...), auth.key...))
+		b = append(b, sum[:]...)
+	}
+	return b
+}
+
+func decodeV2(b []byte, auth *authKey) (message, error) {
+	if len(b) < headerLen {
+		return message{}, errors.New("rip packet too short")
+	}
+	msg := message{command: b[0]}
+	if msg.command != commandRequest && msg.command != commandResponse {
+		return message{}, fmt.Errorf("unknown rip command %d", b[0])
+	}
+	if b[1] != versionRIPv2 {
+		return message{}, fmt.Errorf("unsupported rip version %d", b[1])
+	}
+	body := b[headerLen:]
+	if len(body) >= entryLen && binary.BigEndian.Uint16(body) == afiAuth {
+		if binary.BigEndian.Uint16(body[2:]) != authMD5 {
+			return message{}, errors.New("unsupported rip authentication type")
+		}
+		if auth == nil {
+			return message{}, errors.New("unexpected rip authentication")
+		}
+		trailerAt := int(binary.BigEndian.Uint16(body[4:]))
+		if trailerAt < headerLen+entryLen || trailerAt+4+md5DigestLen > len(b) || (trailerAt-headerLen)%entryLen != 0 {
+			return message{}, errors.New("invalid rip authentication length")
+		}
+		if body[6] != auth.id {
+			return message{}, fmt.Errorf("unknown rip key id %d", body[6])
+		}
+		if binary.BigEndian.Uint16(b[trailerAt:]) != afiAuth || binary.BigEndian.Uint16(b[trailerAt+2:]) != authTrailer {
+			return message{}, errors.New("missing rip authentication trailer")
+		}
+		sum := md5.Sum(append(append([]byte(nil), b[:trailerAt+4]...), auth.key...))
+		if !bytes.Equal(sum[:], b[trailerAt+4:trailerAt+4+md5DigestLen]) {
+			return message{}, errors.New("rip authentication failed")
+		}
+		msg.authed = true
+		msg.authKeyID = body[6]
+		msg.authSeq = binary.BigEndian.Uint32(body[8:])
+		body = b[headerLen+entryLen : trailerAt]
+	} else if auth != nil {
+		return message{}, errors.New("rip authentication required")
+	}
+	if len(body)%entryLen != 0 {
+		return message{}, errors.New("invalid rip entry length")
+	}
+	for off := 0; off < len(body); off += entryLen {
+		entry := body[off : off+entryLen]
+		afi := binary.BigEndian.Uint16(entry)
+		metric := int(binary.BigEndian.Uint32(entry[16:]))
+		if msg.command == commandRequest && afi == 0 && metric == Infinity && len(body) == entryLen {
+			msg.wholeTable = true
+			continue
+		}
+		if afi != afiInet {
+			continue
+		}
+		e := rte{
+			prefix: net.IPNet{
+				IP:   net.IP(append([]byte(nil), entry[4:8]...)),
+				Mask: net.IPMask(append([]byte(nil), entry[8:12]...)),
+			},
+			tag:    binary.BigEndian.Uint16(entry[2:]),
+			metric: metric,
+		}
+		if nh := net.IP(entry[12:16]); !nh.Equal(net.IPv4zero) {
+			e.nextHop = append(net.IP(nil), nh...)
+		}
+		msg.entries = append(msg.entries, e)
+	}
+	return msg, nil
+}
+
+func encodeNG(command uint8, entries []rte) []byte {
+	b := make([]byte, headerLen, headerLen+entryLen*len(entries))
+	b[0] = command
+	b[1] = versionRIPng
+	for _, e := range entries {
+		entry := make([]byte, entryLen)
+		if e.prefix.IP != nil {
+			copy(entry[0:16], e.prefix.IP.To16())
+		}
+		binary.BigEndian.PutUint16(entry[16:], e.tag)
+		ones, _ := e.prefix.Mask.Size()
+		entry[18] = byte(ones)
+		entry[19] = byte(e.metric)
+		b = append(b, entry...)
+	}
+	return b
+}
+
+func decodeNG(b []byte) (message, error) {
+	if len(b) < headerLen {
+		return message{}, errors.New("ripng packet too short")
+	}
+	msg := message{command: b[0]}
+	if msg.command != commandRequest && msg.command != commandResponse {
+		return message{}, fmt.Errorf("unknown ripng command %d", b[0])
+	}
+	if b[1] != versionRIPng {
+		return message{}, fmt.Errorf("unsupported ripng version %d", b[1])
+	}
+	body := b[headerLen:]
+	if len(body)%entryLen != 0 {
+		return message{}, errors.New("invalid ripng entry length")
+	}
+	var nextHop net.IP
+	for off := 0; off < len(body); off += entryLen {
+		entry := body[off : off+entryLen]
+		ip := net.IP(append([]byte(nil), entry[0:16]...))
+		plen, metric := int(entry[18]), int(entry[19])
+		if metric == ngNextHopFlag {
+			nextHop = nil
+			if !ip.IsUnspecified() && ip.IsLinkLocalUnicast() {
+				nextHop = ip
+			}
+			continue
+		}
+		if msg.command == commandRequest && ip.IsUnspecified() && plen == 0 && metric == Infinity && len(body) == entryLen {
+			msg.wholeTable = true
+			continue
+		}
+		if plen > 128 {
+			continue
+		}
+		msg.entries = append(msg.entries, rte{
+			prefix:  net.IPNet{IP: ip, Mask: net.CIDRMask(plen, 128)},
+			nextHop: nextHop,
+			tag:     binary.BigEndian.Uint16(entry[16:]),
+			metric:  metric,
+		})
+	}
+	return msg, nil
+}
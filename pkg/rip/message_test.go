@@ -0,0 +1,72 @@
+package rip
+
+import (
+	"net"
+	"testing"
+)
+
+func mustPrefix(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cidr, err)
+	}
+	return *prefix
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	key := newAuthKey(7, "secret")
+	entries := []rte{
+		{prefix: mustPrefix(t, "192.168.1.0/24"), metric: 2, tag: 100},
+		{prefix: mustPrefix(t, "10.0.0.0/8"), nextHop: net.ParseIP("10.0.12.9"), metric: 16},
+	}
+	raw := encodeV2(commandResponse, entries, key, 42)
+	msg, err := decodeV2(raw, key)
+	if err != nil {
+		t.Fatalf("decode v2: %v", err)
+	}
+	if !msg.authed || msg.authSeq != 42 || msg.authKeyID != 7 || len(msg.entries) != 2 {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	if got := msg.entries[0]; got.prefix.String() != "192.168.1.0/24" || got.metric != 2 || got.tag != 100 || got.nextHop != nil {
+		t.Fatalf("unexpected entry: %+v", got)
+	}
+	if got := msg.entries[1]; !got.nextHop.Equal(net.ParseIP("10.0.12.9")) || got.metric != Infinity {
+		t.Fatalf("unexpected entry: %+v", got)
+	}
+
+	if _, err := decodeV2(raw, newAuthKey(7, "other")); err == nil {
+		t.Fatalf("expected digest mismatch")
+	}
+	if _, err := decodeV2(raw, nil); err == nil {
+		t.Fatalf("expected unexpected authentication error")
+	}
+	if _, err := decodeV2(encodeV2(commandResponse, entries, nil, 0), key); err == nil {
+		t.Fatalf("expected missing authentication error")
+	}
+	raw[headerLen+entryLen+8] ^= 0x01
+	if _, err := decodeV2(raw, key); err == nil {
+		t.Fatalf("expected tampered packet to fail")
+	}
+
+	req, err := decodeV2(encodeV2(commandRequest, []rte{{metric: Infinity}}, nil, 0), nil)
+	if err != nil || !req.wholeTable {
+		t.Fatalf("expected whole table request: %+v %v", req, err)
+	}
+
+	ng := encodeNG(commandResponse, []rte{{prefix: mustPrefix(t, "2001:db8:1::/48"), metric: 3, tag: 5}})
+	ng = append(ng, make([]byte, entryLen)...)
+	copy(ng[headerLen+entryLen:], net.ParseIP("fe80::1"))
+	ng[len(ng)-1] = ngNextHopFlag
+	ng = append(ng, encodeNG(commandResponse, []rte{{prefix: mustPrefix(t, "2001:db8:2::/64"), metric: 1}})[headerLen:]...)
+	msg, err = decodeNG(ng)
+	if err != nil {
+		t.Fatalf("decode ng: %v", err)
+	}
+	if len(msg.entries) != 2 || msg.entries[0].prefix.String() != "2001:db8:1::/48" || msg.entries[0].metric != 3 || msg.entries[0].nextHop != nil {
+		t.Fatalf("unexpected ripng entries: %+v", msg.entries)
+	}
+	if !msg.entries[1].nextHop.Equal(net.ParseIP("fe80::1")) || msg.entries[1].prefix.String() != "2001:db8:2::/64" {
+		t.Fatalf("expected next hop rte to apply: %+v", msg.entries[1])
+	}
+}
@@ -0,0 +1,739 @@
+package rip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"router-go/pkg/routing"
+)
+
+const (
+	SourceRIP   = "rip"
+	SourceRIPng = "ripng"
+	Distance    = 120
+
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
+
+	SplitHorizonNone          = "none"
+	SplitHorizonSimple        = "simple"
+	SplitHorizonPoisonReverse = "poison_reverse"
+
+	PortRIP   = 520
+	PortRIPng = 521
+)
+
+var (
+	GroupRIPv2 = net.IPv4(224, 0, 0, 9).To4()
+	GroupRIPng = net.ParseIP("ff02::9")
+)
+
+type Config struct {
+	Interfaces        []InterfaceConfig
+	Networks          []net.IPNet
+	UpdateInterval    time.Duration
+	Timeout           time.Duration
+	GarbageCollection time.Duration
+	TriggerDelay      time.Duration
+	Tick              time.Duration
+}
+
+type InterfaceConfig struct {
+	Name         string
+	Family       string
+	Address      net.IPNet
+	Cost         int
+	SplitHorizon string
+	Passive      bool
+	AuthKey      string
+	AuthKeyID    uint8
+}
+
+type Transport interface {
+	Send(dst *net.UDPAddr, pkt []byte) error
+	Receive() (*net.UDPAddr, []byte, error)
+	Close() error
+}
+
+type TransportFunc func(InterfaceConfig) (Transport, error)
+
+type RouteStatus struct {
+	Prefix    string
+	Family    string
+	Metric    int
+	NextHop   string
+	Interface string
+	From      string
+	Tag       uint16
+	Local     bool
+	State     string
+	Expires   time.Time
+}
+
+type NeighborStatus struct {
+	Interface  string
+	Family     string
+	Address    string
+	LastUpdate time.Time
+	Routes     int
+	BadPackets int
+	BadRoutes  int
+}
+
+type Router struct {
+	cfg       Config
+	table     *routing.Table
+	transport TransportFunc
+	mu        sync.Mutex
+	links     []*link
+	routes    map[string]*entry
+	peers     map[string]*peer
+	rnd       *rand.Rand
+	seq       uint32
+
+	nextUpdate    time.Time
+	nextTriggered time.Time
+	triggered     bool
+}
+
+type link struct {
+	cfg  InterfaceConfig
+	v6   bool
+	auth *authKey
+	tr   Transport
+}
+
+type entry struct {
+	prefix    net.IPNet
+	v6        bool
+	metric    int
+	nextHop   net.IP
+	link      *link
+	from      net.IP
+	tag       uint16
+	local     bool
+	changed   bool
+	expires   time.Time
+	garbageAt time.Time
+	installed *routing.Route
+}
+
+type peer struct {
+	link       *link
+	addr       net.IP
+	lastUpdate time.Time
+	authSeq    uint32
+	badPackets int
+	badRoutes  int
+}
+
+type inbound struct {
+	link *link
+	src  *net.UDPAddr
+	pkt  []byte
+}
+
+func NewRouter(cfg Config, table *routing.Table) (*Router, error) {
+	if cfg.UpdateInterval <= 0 {
+		cfg.UpdateInterval = 30 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 6 * cfg.UpdateInterval
+	}
+	if cfg.GarbageCollection <= 0 {
+		cfg.GarbageCollection = 4 * cfg.UpdateInterval
+	}
+	if cfg.TriggerDelay <= 0 {
+		cfg.TriggerDelay = time.Second
+	}
+	if cfg.Tick <= 0 {
+		cfg.Tick = 100 * time.Millisecond
+	}
+	r := &Router{
+		cfg:       cfg,
+		table:     table,
+		transport: newUDPTransport,
+		routes:    map[string]*entry{},
+		peers:     map[string]*peer{},
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	seen := map[string]struct{}{}
+	for _, ic := range cfg.Interfaces {
+		if ic.Name == "" {
+			return nil, errors.New("rip interface name is required")
+		}
+		if ic.Family == "" {
+			ic.Family = FamilyIPv4
+		}
+		if ic.Family != FamilyIPv4 && ic.Family != FamilyIPv6 {
+			return nil, fmt.Errorf("rip interface %s has unknown family %q", ic.Name, ic.Family)
+		}
+		key := ic.Name + "/" + ic.Family
+		if _, ok := seen[key]; ok {
+			return nil, fmt.Errorf("rip interface %s is duplicated", key)
+		}
+		seen[key] = struct{}{}
+		if ic.Cost <= 0 {
+			ic.Cost = 1
+		}
+		if ic.Cost >= Infinity {
+			return nil, fmt.Errorf("rip interface %s cost must be below %d", ic.Name, Infinity)
+		}
+		switch ic.SplitHorizon {
+		case "":
+			ic.SplitHorizon = SplitHorizonPoisonReverse
+		case SplitHorizonNone, SplitHorizonSimple, SplitHorizonPoisonReverse:
+		default:
+			return nil, fmt.Errorf("rip interface %s has unknown split horizon %q", ic.Name, ic.SplitHorizon)
+		}
+		l := &link{cfg: ic, v6: ic.Family == FamilyIPv6}
+		if ic.AuthKey != "" {
+			if l.v6 {
+				return nil, fmt.Errorf("rip interface %s: ripng does not support md5 authentication", ic.Name)
+			}
+			if len(ic.AuthKey) > md5DigestLen {
+				return nil, fmt.Errorf("rip interface %s auth key is longer than %d bytes", ic.Name, md5DigestLen)
+			}
+			l.auth = newAuthKey(ic.AuthKeyID, ic.AuthKey)
+		}
+		r.links = append(r.links, l)
+		if prefix, ok := connectedPrefix(ic); ok {
+			r.addLocal(prefix)
+		}
+	}
+	for _, prefix := range cfg.Networks {
+		r.addLocal(prefix)
+	}
+	return r, nil
+}
+
+func (r *Router) SetTransport(fn TransportFunc) {
+	r.mu.Lock()
+	r.transport = fn
+	r.mu.Unlock()
+}
+
+func (r *Router) Start(ctx context.Context) error {
+	r.mu.Lock()
+	for _, l := range r.links {
+		tr, err := r.transport(l.cfg)
+		if err != nil {
+			for _, opened := range r.links {
+				if opened.tr != nil {
+					_ = opened.tr.Close()
+					opened.tr = nil
+				}
+			}
+			r.mu.Unlock()
+			return fmt.Errorf("rip interface %s: %w", l.cfg.Name, err)
+		}
+		l.tr = tr
+	}
+	now := time.Now()
+	for _, l := range r.links {
+		if !l.cfg.Passive {
+			r.send(l, groupAddr(l), r.encode(l, commandRequest, []rte{{metric: Infinity}}))
+		}
+	}
+	r.nextUpdate = now
+	r.mu.Unlock()
+
+	in := make(chan inbound, 256)
+	for _, l := range r.links {
+		go r.receive(ctx, l, in)
+	}
+	go r.run(ctx, in)
+	return nil
+}
+
+func (r *Router) Routes() []RouteStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]RouteStatus, 0, len(r.routes))
+	for _, e := range r.routes {
+		st := RouteStatus{
+			Prefix: e.prefix.String(),
+			Family: FamilyIPv4,
+			Metric: e.metric,
+			Tag:    e.tag,
+			Local:  e.local,
+			State:  "active",
+		}
+		if e.v6 {
+			st.Family = FamilyIPv6
+		}
+		if e.link != nil {
+			st.Interface = e.link.cfg.Name
+		}
+		if e.nextHop != nil {
+			st.NextHop = e.nextHop.String()
+		}
+		if e.from != nil {
+			st.From = e.from.String()
+		}
+		switch {
+		case e.local:
+		case e.metric >= Infinity:
+			st.State = "garbage"
+			st.Expires = e.garbageAt
+		default:
+			st.Expires = e.expires
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Prefix < out[j].Prefix })
+	return out
+}
+
+func (r *Router) Neighbors() []NeighborStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]NeighborStatus, 0, len(r.peers))
+	for _, p := range r.peers {
+		count := 0
+		for _, e := range r.routes {
+			if e.link == p.link && e.from.Equal(p.addr) && e.metric < Infinity {
+				count++
+			}
+		}
+		out = append(out, NeighborStatus{
+			Interface:  p.link.cfg.Name,
+			Family:     p.link.cfg.Family,
+			Address:    p.addr.String(),
+			LastUpdate: p.lastUpdate,
+			Routes:     count,
+			BadPackets: p.badPackets,
+			BadRoutes:  p.badRoutes,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Interface != out[j].Interface {
+			return out[i].Interface < out[j].Interface
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
+
+func (r *Router) receive(ctx context.Context, l *link, in chan<- inbound) {
+	for {
+		src, pkt, err := l.tr.Receive()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		select {
+		case in <- inbound{link: l, src: src, pkt: pkt}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Router) run(ctx context.Context, in <-chan inbound) {
+	ticker := time.NewTicker(r.cfg.Tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.shutdown()
+			return
+		case msg := <-in:
+			r.mu.Lock()
+			r.handle(msg, time.Now())
+			r.mu.Unlock()
+		case now := <-ticker.C:
+			r.mu.Lock()
+			r.tick(now)
+			r.mu.Unlock()
+		}
+	}
+}
+
+func (r *Router) shutdown() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.routes {
+		if e.local {
+			e.metric = Infinity
+			e.changed = true
+		}
+	}
+	r.sendUpdates(true)
+	for _, l := range r.links {
+		if l.tr != nil {
+			_ = l.tr.Close()
+		}
+	}
+	for _, e := range r.routes {
+		r.uninstall(e)
+	}
+}
+
+func (r *Router) tick(now time.Time) {
+	for key, e := range r.routes {
+		if e.local {
+			continue
+		}
+		if e.metric < Infinity && now.After(e.expires) {
+			r.expire(e, now)
+			continue
+		}
+		if e.metric >= Infinity && now.After(e.garbageAt) {
+			delete(r.routes, key)
+		}
+	}
+	if !now.Before(r.nextUpdate) {
+		r.sendUpdates(false)
+		jitter := time.Duration(r.rnd.Int63n(int64(r.cfg.UpdateInterval)*3/10+1)) - r.cfg.UpdateInterval*15/100
+		r.nextUpdate = now.Add(r.cfg.UpdateInterval + jitter)
+		r.triggered = false
+		return
+	}
+	if r.triggered && !now.Before(r.nextTriggered) {
+		r.sendUpdates(true)
+		r.triggered = false
+		r.nextTriggered = now.Add(r.cfg.TriggerDelay + time.Duration(r.rnd.Int63n(int64(4*r.cfg.TriggerDelay)+1)))
+	}
+}
+
+func (r *Router) handle(msg inbound, now time.Time) {
+	l := msg.link
+	var (
+		m   message
+		err error
+	)
+	if l.v6 {
+		m, err = decodeNG(msg.pkt)
+	} else {
+		m, err = decodeV2(msg.pkt, l.auth)
+	}
+	if r.isLocalAddress(msg.src.IP) {
+		return
+	}
+	if err != nil {
+		r.peer(l, msg.src.IP).badPackets++
+		return
+	}
+	if m.command == commandRequest {
+		r.answer(l, msg.src, m)
+		return
+	}
+	if msg.src.Port != listenPort(l) || !onLink(l, msg.src.IP) {
+		return
+	}
+	p := r.peer(l, msg.src.IP)
+	if m.authed {
+		if m.authSeq < p.authSeq {
+			p.badPackets++
+			return
+		}
+		p.authSeq = m.authSeq
+	}
+	p.lastUpdate = now
+	for _, e := range m.entries {
+		if !r.learn(l, p, e, now) {
+			p.badRoutes++
+		}
+	}
+}
+
+func (r *Router) learn(l *link, p *peer, e rte, now time.Time) bool {
+	if e.metric < 1 || e.metric > Infinity || !validPrefix(e.prefix, l.v6) {
+		return false
+	}
+	prefix := net.IPNet{IP: e.prefix.IP.Mask(e.prefix.Mask), Mask: e.prefix.Mask}
+	if !l.v6 {
+		prefix.IP = prefix.IP.To4()
+	}
+	metric := e.metric + l.cfg.Cost
+	if metric > Infinity {
+		metric = Infinity
+	}
+	nextHop := p.addr
+	if e.nextHop != nil && onLink(l, e.nextHop) && !r.isLocalAddress(e.nextHop) {
+		nextHop = e.nextHop
+	}
+	key := prefix.String()
+	cur := r.routes[key]
+	if cur != nil && cur.local {
+		return true
+	}
+	if cur == nil {
+		if metric >= Infinity {
+			return true
+		}
+		cur = &entry{prefix: prefix, v6: l.v6}
+		r.routes[key] = cur
+		r.adopt(cur, l, p, nextHop, metric, e.tag, now)
+		return true
+	}
+	if cur.link == l && cur.from.Equal(p.addr) {
+		if metric < Infinity {
+			cur.expires = now.Add(r.cfg.Timeout)
+		}
+		if metric == cur.metric && nextHop.Equal(cur.nextHop) {
+			return true
+		}
+		if metric >= Infinity {
+			if cur.metric < Infinity {
+				r.expire(cur, now)
+			}
+			return true
+		}
+		r.adopt(cur, l, p, nextHop, metric, e.tag, now)
+		return true
+	}
+	if metric < cur.metric {
+		r.adopt(cur, l, p, nextHop, metric, e.tag, now)
+	}
+	return true
+}
+
+func (r *Router) adopt(e *entry, l *link, p *peer, nextHop net.IP, metric int, tag uint16, now time.Time) {
+	e.link = l
+	e.from = p.addr
+	e.nextHop = nextHop
+	e.metric = metric
+	e.tag = tag
+	e.expires = now.Add(r.cfg.Timeout)
+	e.garbageAt = time.Time{}
+	r.markChanged(e)
+	r.install(e)
+}
+
+func (r *Router) expire(e *entry, now time.Time) {
+	e.metric = Infinity
+	e.garbageAt = now.Add(r.cfg.GarbageCollection)
+	r.markChanged(e)
+	r.uninstall(e)
+}
+
+func (r *Router) markChanged(e *entry) {
+	e.changed = true
+	r.triggered = true
+}
+
+func (r *Router) install(e *entry) {
+	source := SourceRIP
+	if e.v6 {
+		source = SourceRIPng
+	}
+	route := routing.Route{
+		Destination: e.prefix,
+		Gateway:     e.nextHop,
+		Interface:   e.link.cfg.Name,
+		Metric:      e.metric,
+		Distance:    Distance,
+		Source:      source,
+	}
+	if e.installed != nil && r.table.UpdateRoute(*e.installed, route) {
+		e.installed = &route
+		return
+	}
+	r.table.Add(route)
+	e.installed = &route
+}
+
+func (r *Router) uninstall(e *entry) {
+	if e.installed == nil {
+		return
+	}
+	r.table.RemoveRoute(*e.installed)
+	e.installed = nil
+}
+
+func (r *Router) answer(l *link, src *net.UDPAddr, m message) {
+	if m.wholeTable {
+		r.sendTable(l, src, r.advertised(l, false))
+		return
+	}
+	entries := make([]rte, 0, len(m.entries))
+	for _, req := range m.entries {
+		req.metric = Infinity
+		prefix := net.IPNet{IP: req.prefix.IP.Mask(req.prefix.Mask), Mask: req.prefix.Mask}
+		if e := r.routes[prefix.String()]; e != nil && e.v6 == l.v6 {
+			req.metric = e.metric
+			if e.local {
+				req.metric = 1
+			}
+		}
+		entries = append(entries, req)
+	}
+	r.sendTable(l, src, entries)
+}
+
+func (r *Router) sendUpdates(changedOnly bool) {
+	for _, l := range r.links {
+		if l.cfg.Passive || l.tr == nil {
+			continue
+		}
+		entries := r.advertised(l, changedOnly)
+		if len(entries) == 0 {
+			continue
+		}
+		r.sendTable(l, groupAddr(l), entries)
+	}
+	for _, e := range r.routes {
+		e.changed = false
+	}
+}
+
+func (r *Router) advertised(l *link, changedOnly bool) []rte {
+	keys := make([]string, 0, len(r.routes))
+	for key, e := range r.routes {
+		if e.v6 == l.v6 && (!changedOnly || e.changed) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	out := make([]rte, 0, len(keys))
+	for _, key := range keys {
+		e := r.routes[key]
+		metric := e.metric
+		if e.local && metric < Infinity {
+			metric = 1
+		}
+		if !e.local && e.link == l {
+			switch l.cfg.SplitHorizon {
+			case SplitHorizonSimple:
+				continue
+			case SplitHorizonPoisonReverse:
+				metric = Infinity
+			}
+		}
+		out = append(out, rte{prefix: e.prefix, metric: metric, tag: e.tag})
+	}
+	return out
+}
+
+func (r *Router) sendTable(l *link, dst *net.UDPAddr, entries []rte) {
+	limit := maxEntriesV2
+	switch {
+	case l.v6:
+		limit = maxEntriesNG
+	case l.auth != nil:
+		limit = maxEntriesAuth
+	}
+	for len(entries) > 0 {
+		count := len(entries)
+		if count > limit {
+			count = limit
+		}
+		r.send(l, dst, r.encode(l, commandResponse, entries[:count]))
+		entries = entries[count:]
+	}
+}
+
+func (r *Router) encode(l *link, command uint8, entries []rte) []byte {
+	if l.v6 {
+		return encodeNG(command, entries)
+	}
+	seq := uint32(time.Now().Unix())
+	if seq <= r.seq {
+		seq = r.seq
+	}
+	r.seq = seq
+	return encodeV2(command, entries, l.auth, seq)
+}
+
+func (r *Router) send(l *link, dst *net.UDPAddr, pkt []byte) {
+	if l.tr == nil {
+		return
+	}
+	_ = l.tr.Send(dst, pkt)
+}
+
+func (r *Router) peer(l *link, addr net.IP) *peer {
+	key := l.cfg.Name + "|" + addr.String()
+	p := r.peers[key]
+	if p == nil {
+		p = &peer{link: l, addr: append(net.IP(nil), addr...)}
+		r.peers[key] = p
+	}
+	return p
+}
+
+func (r *Router) addLocal(prefix net.IPNet) {
+	v6 := prefix.IP.To4() == nil
+	if !v6 {
+		prefix = net.IPNet{IP: prefix.IP.To4().Mask(prefix.Mask), Mask: prefix.Mask}
+	} else {
+		prefix = net.IPNet{IP: prefix.IP.Mask(prefix.Mask), Mask: prefix.Mask}
+	}
+	r.routes[prefix.String()] = &entry{prefix: prefix, v6: v6, metric: 1, local: true}
+}
+
+func (r *Router) isLocalAddress(ip net.IP) bool {
+	for _, l := range r.links {
+		if l.cfg.Address.IP != nil && l.cfg.Address.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func connectedPrefix(ic InterfaceConfig) (net.IPNet, bool) {
+	if ic.Address.IP == nil || ic.Address.Mask == nil {
+		return net.IPNet{}, false
+	}
+	v4 := ic.Address.IP.To4() != nil
+	if v4 != (ic.Family == FamilyIPv4) || ic.Address.IP.IsLinkLocalUnicast() {
+		return net.IPNet{}, false
+	}
+	return ic.Address, true
+}
+
+func onLink(l *link, ip net.IP) bool {
+	if l.v6 {
+		return ip.IsLinkLocalUnicast()
+	}
+	if ip.To4() == nil {
+		return false
+	}
+	if l.cfg.Address.IP == nil {
+		return true
+	}
+	return l.cfg.Address.Contains(ip)
+}
+
+func validPrefix(prefix net.IPNet, v6 bool) bool {
+	ip := prefix.IP
+	if v6 {
+		if ip.To4() != nil || len(ip) != net.IPv6len {
+			return false
+		}
+		return !ip.IsMulticast() && !ip.IsLinkLocalUnicast() && !ip.IsLoopback()
+	}
+	ip = ip.To4()
+	if ip == nil {
+		return false
+	}
+	ones, bits := prefix.Mask.Size()
+	if bits != 32 || (ones == 0 && !ip.Equal(net.IPv4zero)) {
+		return false
+	}
+	return !ip.IsMulticast() && !ip.IsLoopback() && ip[0] < 240 && (ip[0] != 0 || ones == 0)
+}
+
+func listenPort(l *link) int {
+	if l.v6 {
+		return PortRIPng
+	}
+	return PortRIP
+}
+
+func groupAddr(l *link) *net.UDPAddr {
+	if l.v6 {
+		return &net.UDPAddr{IP: GroupRIPng, Port: PortRIPng, Zone: l.cfg.Name}
+	}
+	return &net.UDPAddr{IP: GroupRIPv2, Port: PortRIP}
+}
@@ -0,0 +1,285 @@
+package rip
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"router-go/pkg/routing"
+)
+
+type segment struct {
+	mu      sync.Mutex
+	members map[string]*memTransport
+}
+
+type memPacket struct {
+	src *net.UDPAddr
+	pkt []byte
+}
+
+type memTransport struct {
+	seg    *segment
+	src    *net.UDPAddr
+	ch     chan memPacket
+	done   chan struct{}
+	closed sync.Once
+	muted  atomic.Bool
+}
+
+type fabric struct {
+	mu       sync.Mutex
+	segments map[string]*segment
+}
+
+func newFabric() *fabric {
+	return &fabric{segments: map[string]*segment{}}
+}
+
+func (f *fabric) transport(cfg InterfaceConfig) (Transport, error) {
+	return f.join(cfg), nil
+}
+
+func (f *fabric) join(cfg InterfaceConfig) *memTransport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := cfg.Name + "/" + cfg.Family
+	seg := f.segments[key]
+	if seg == nil {
+		seg = &segment{members: map[string]*memTransport{}}
+		f.segments[key] = seg
+	}
+	src := &net.UDPAddr{IP: cfg.Address.IP, Port: PortRIP}
+	if cfg.Family == FamilyIPv6 {
+		ll := net.ParseIP("fe80::")
+		copy(ll[8:], cfg.Address.IP.To16()[8:])
+		src = &net.UDPAddr{IP: ll, Port: PortRIPng}
+	}
+	tr := &memTransport{seg: seg, src: src, ch: make(chan memPacket, 512), done: make(chan struct{})}
+	seg.mu.Lock()
+	seg.members[src.IP.String()] = tr
+	seg.mu.Unlock()
+	return tr
+}
+
+func (t *memTransport) Send(dst *net.UDPAddr, pkt []byte) error {
+	if t.muted.Load() {
+		return nil
+	}
+	t.seg.mu.Lock()
+	defer t.seg.mu.Unlock()
+	for ip, member := range t.seg.members {
+		if member == t || (!dst.IP.IsMulticast() && ip != dst.IP.String()) {
+			continue
+		}
+		select {
+		case member.ch <- memPacket{src: t.src, pkt: append([]byte(nil), pkt...)}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (t *memTransport) Receive() (*net.UDPAddr, []byte, error) {
+	select {
+	case p := <-t.ch:
+		return p.src, p.pkt, nil
+	case <-t.done:
+		return nil, nil, net.ErrClosed
+	}
+}
+
+func (t *memTransport) Close() error {
+	t.closed.Do(func() {
+		t.seg.mu.Lock()
+		delete(t.seg.members, t.src.IP.String())
+		t.seg.mu.Unlock()
+		close(t.done)
+	})
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func mustIface(t *testing.T, name string, cidr string) InterfaceConfig {
+	t.Helper()
+	ip, prefix, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cidr, err)
+	}
+	family := FamilyIPv4
+	if ip.To4() == nil {
+		family = FamilyIPv6
+	}
+	return InterfaceConfig{Name: name, Family: family, Address: net.IPNet{IP: ip, Mask: prefix.Mask}}
+}
+
+func startRouter(t *testing.T, ctx context.Context, f *fabric, networks []string, ifaces ...InterfaceConfig) (*Router, *routing.Table) {
+	t.Helper()
+	cfg := Config{
+		Interfaces:        ifaces,
+		UpdateInterval:    100 * time.Millisecond,
+		Timeout:           400 * time.Millisecond,
+		GarbageCollection: 200 * time.Millisecond,
+		TriggerDelay:      10 * time.Millisecond,
+		Tick:              5 * time.Millisecond,
+	}
+	for _, network := range networks {
+		cfg.Networks = append(cfg.Networks, mustPrefix(t, network))
+	}
+	table := routing.NewTable(nil)
+	r, err := NewRouter(cfg, table)
+	if err != nil {
+		t.Fatalf("new router: %v", err)
+	}
+	r.SetTransport(f.transport)
+	if err := r.Start(ctx); err != nil {
+		t.Fatalf("start router: %v", err)
+	}
+	return r, table
+}
+
+func routeTo(table *routing.Table, dst string) (routing.Route, bool) {
+	for _, route := range table.Routes() {
+		if route.Destination.String() == dst {
+			return route, true
+		}
+	}
+	return routing.Route{}, false
+}
+
+func TestRoutersExchangeRoutesWithPoisonReverse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFabric()
+	_, table1 := startRouter(t, ctx, f, []string{"192.168.1.0/24"},
+		mustIface(t, "a", "10.0.12.1/24"),
+		mustIface(t, "a", "2001:db8:12::1/64"),
+	)
+	r2, table2 := startRouter(t, ctx, f, []string{"2001:db8:2::/48"},
+		mustIface(t, "a", "10.0.12.2/24"),
+		mustIface(t, "a", "2001:db8:12::2/64"),
+		mustIface(t, "b", "10.0.23.2/24"),
+	)
+	r3ctx, stopR3 := context.WithCancel(ctx)
+	r3, _ := startRouter(t, r3ctx, f, []string{"192.168.3.0/24"},
+		mustIface(t, "b", "10.0.23.3/24"),
+	)
+
+	waitFor(t, "rip routes on r1", func() bool {
+		_, ok3 := routeTo(table1, "192.168.3.0/24")
+		_, ok6 := routeTo(table1, "2001:db8:2::/48")
+		return ok3 && ok6
+	})
+	route, _ := routeTo(table1, "192.168.3.0/24")
+	if !route.Gateway.Equal(net.ParseIP("10.0.12.2")) || route.Interface != "a" || route.Metric != 3 || route.Distance != Distance || route.Source != SourceRIP {
+		t.Fatalf("unexpected route on r1: %+v", route)
+	}
+	route, _ = routeTo(table1, "10.0.23.0/24")
+	if route.Metric != 2 {
+		t.Fatalf("expected r2 transit subnet at metric 2: %+v", route)
+	}
+	route, _ = routeTo(table1, "2001:db8:2::/48")
+	if !route.Gateway.Equal(net.ParseIP("fe80::2")) || route.Metric != 2 || route.Source != SourceRIPng {
+		t.Fatalf("unexpected ripng route on r1: %+v", route)
+	}
+	if _, ok := routeTo(table2, "10.0.12.0/24"); ok {
+		t.Fatalf("connected networks must not be installed")
+	}
+
+	sniffer := f.join(mustIface(t, "a", "10.0.12.99/24"))
+	defer sniffer.Close()
+	deadline := time.After(time.Second)
+	poisoned := false
+	for !poisoned {
+		select {
+		case p := <-sniffer.ch:
+			if !p.src.IP.Equal(net.ParseIP("10.0.12.2")) {
+				continue
+			}
+			msg, err := decodeV2(p.pkt, nil)
+			if err != nil || msg.command != commandResponse {
+				continue
+			}
+			for _, e := range msg.entries {
+				if e.prefix.String() == "192.168.1.0/24" {
+					if e.metric != Infinity {
+						t.Fatalf("expected poison reverse towards r1, got metric %d", e.metric)
+					}
+					poisoned = true
+				}
+			}
+		case <-deadline:
+			t.Fatalf("no update from r2 seen on link a")
+		}
+	}
+
+	if n := r2.Neighbors(); len(n) != 3 {
+		t.Fatalf("expected three rip neighbors on r2, got %+v", n)
+	}
+
+	for _, l := range r3.links {
+		l.tr.(*memTransport).muted.Store(true)
+	}
+	waitFor(t, "timed out route withdrawn", func() bool {
+		_, ok := routeTo(table1, "192.168.3.0/24")
+		_, ok2 := routeTo(table2, "192.168.3.0/24")
+		return !ok && !ok2
+	})
+	waitFor(t, "garbage collected", func() bool {
+		for _, st := range r2.Routes() {
+			if st.Prefix == "192.168.3.0/24" {
+				return false
+			}
+		}
+		return true
+	})
+	stopR3()
+}
+
+func TestRoutersRejectMismatchedAuthentication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	f := newFabric()
+	keyed := func(cidr string, key string) InterfaceConfig {
+		ic := mustIface(t, "a", cidr)
+		ic.AuthKey, ic.AuthKeyID = key, 1
+		return ic
+	}
+	_, table1 := startRouter(t, ctx, f, []string{"192.168.1.0/24"}, keyed("10.0.12.1/24", "secret"))
+	r2, table2 := startRouter(t, ctx, f, []string{"192.168.2.0/24"}, keyed("10.0.12.2/24", "wrong"))
+	_, table3 := startRouter(t, ctx, f, []string{"192.168.3.0/24"}, keyed("10.0.12.3/24", "secret"))
+
+	waitFor(t, "authenticated routes", func() bool {
+		_, ok1 := routeTo(table1, "192.168.3.0/24")
+		_, ok3 := routeTo(table3, "192.168.1.0/24")
+		return ok1 && ok3
+	})
+	waitFor(t, "bad packets counted", func() bool {
+		for _, n := range r2.Neighbors() {
+			if n.BadPackets == 0 {
+				return false
+			}
+		}
+		return len(r2.Neighbors()) == 2
+	})
+	if _, ok := routeTo(table1, "192.168.2.0/24"); ok {
+		t.Fatalf("route from router with wrong key must be rejected")
+	}
+	if len(table2.Routes()) != 0 {
+		t.Fatalf("router with wrong key must not learn routes: %+v", table2.Routes())
+	}
+}
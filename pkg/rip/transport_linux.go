@@ -0,0 +1,105 @@
+//go:build linux
+
+package rip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+type udpTransport struct {
+	conn *net.UDPConn
+	zone string
+}
+
+func newUDPTransport(cfg InterfaceConfig) (Transport, error) {
+	ifi, err := net.InterfaceByName(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	v6 := cfg.Family == FamilyIPv6
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sock := int(fd)
+			if sockErr = unix.SetsockoptInt(sock, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); sockErr != nil {
+				return
+			}
+			if sockErr = unix.BindToDevice(sock, cfg.Name); sockErr != nil {
+				return
+			}
+			if v6 {
+				if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_IF, ifi.Index); sockErr != nil {
+					return
+				}
+				if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_LOOP, 0); sockErr != nil {
+					return
+				}
+				if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 255); sockErr != nil {
+					return
+				}
+				join := &unix.IPv6Mreq{Interface: uint32(ifi.Index)}
+				copy(join.Multiaddr[:], GroupRIPng.To16())
+				sockErr = unix.SetsockoptIPv6Mreq(sock, unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, join)
+				return
+			}
+			mreq := &unix.IPMreqn{Ifindex: int32(ifi.Index)}
+			if sockErr = unix.SetsockoptIPMreqn(sock, unix.IPPROTO_IP, unix.IP_MULTICAST_IF, mreq); sockErr != nil {
+				return
+			}
+			if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IP, unix.IP_MULTICAST_TTL, 1); sockErr != nil {
+				return
+			}
+			if sockErr = unix.SetsockoptInt(sock, unix.IPPROTO_IP, unix.IP_MULTICAST_LOOP, 0); sockErr != nil {
+				return
+			}
+			join := &unix.IPMreqn{Ifindex: int32(ifi.Index)}
+			copy(join.Multiaddr[:], GroupRIPv2)
+			sockErr = unix.SetsockoptIPMreqn(sock, unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, join)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}}
+	network, address := "udp4", fmt.Sprintf("0.0.0.0:%d", PortRIP)
+	if v6 {
+		network, address = "udp6", fmt.Sprintf("[::]:%d", PortRIPng)
+	}
+	conn, err := lc.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	t := &udpTransport{conn: conn.(*net.UDPConn)}
+	if v6 {
+		t.zone = cfg.Name
+	}
+	return t, nil
+}
+
+func (t *udpTransport) Send(dst *net.UDPAddr, pkt []byte) error {
+	if t.zone != "" && dst.Zone == "" {
+		addr := *dst
+		addr.Zone = t.zone
+		dst = &addr
+	}
+	_, err := t.conn.WriteToUDP(pkt, dst)
+	return err
+}
+
+func (t *udpTransport) Receive() (*net.UDPAddr, []byte, error) {
+	buf := make([]byte, 65535)
+	n, addr, err := t.conn.ReadFromUDP(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	return addr, buf[:n], nil
+}
+
+func (t *udpTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package rip
+
+import "errors"
+
+func newUDPTransport(cfg InterfaceConfig) (Transport, error) {
+	return nil, errors.New("rip sockets are only supported on linux")
+}
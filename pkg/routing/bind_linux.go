@@ -0,0 +1,25 @@
+//go:build linux
+
+package routing
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	if iface == "" {
+		return nil
+	}
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = unix.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
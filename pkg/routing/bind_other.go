@@ -0,0 +1,9 @@
+//go:build !linux
+
+package routing
+
+import "syscall"
+
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
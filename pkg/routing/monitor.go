@@ -0,0 +1,191 @@
+package routing
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default number of consecutive failed or successful probes before a next
+// hop is marked down or back up.
+const (
+	DefaultProbeFall = 3
+	DefaultProbeRise = 2
+)
+
+// probeStreak counts consecutive probe results for one next hop.
+type probeStreak struct {
+	fails  int
+	passes int
+}
+
+type Monitor struct {
+	table     *Table
+	routes    func() []Route
+	interval  time.Duration
+	timeout   time.Duration
+	linkState func(name string) (bool, bool)
+	probe     func(ctx context.Context, addr string, timeout time.Duration) error
+	onChange  func(target string, up bool)
+	seen      map[string]struct{}
+	fall      int
+	rise      int
+	streaks   map[string]probeStreak
+}
+
+func NewMonitor(table *Table, interval time.Duration, timeout time.Duration) *Monitor {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Monitor{
+		table:     table,
+		routes:    table.Routes,
+		interval:  interval,
+		timeout:   timeout,
+		linkState: systemLinkState,
+		probe:     tcpProbe,
+		seen:      map[string]struct{}{},
+		fall:      DefaultProbeFall,
+		rise:      DefaultProbeRise,
+		streaks:   map[string]probeStreak{},
+	}
+}
+
+// SetThresholds sets how many consecutive failed probes mark a next hop down
+// and how many successful ones bring it back; values below 1 keep the
+// current setting.
+func (m *Monitor) SetThresholds(fall int, rise int) {
+	if fall > 0 {
+		m.fall = fall
+	}
+	if rise > 0 {
+		m.rise = rise
+	}
+}
+
+func (m *Monitor) SetOnChange(fn func(target string, up bool)) {
+	m.onChange = fn
+}
+
+func (m *Monitor) SetRoutes(fn func() []Route) {
+	m.routes = fn
+}
+
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	m.Check(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx)
+		}
+	}
+}
+
+func (m *Monitor) Check(ctx context.Context) {
+	interfaces := map[string]struct{}{}
+	probes := map[string]NextHop{}
+	for _, route := range m.routes() {
+		for _, hop := range route.Paths() {
+			if hop.Interface != "" {
+				interfaces[hop.Interface] = struct{}{}
+			}
+			if hop.Probe != "" {
+				probes[hopKey(hop)+"|"+hop.Probe] = hop
+			}
+		}
+	}
+	for name := range interfaces {
+		up, known := m.linkState(name)
+		if !known {
+			if _, ok := m.seen[name]; !ok {
+				continue
+			}
+			up = false
+		} else {
+			m.seen[name] = struct{}{}
+		}
+		if m.table.SetInterfaceUp(name, up) && m.onChange != nil {
+			m.onChange("interface "+name, up)
+		}
+	}
+
+	// Probes run in parallel so one slow next hop does not delay the
+	// others; results are applied once all of them have finished.
+	results := make(map[string]bool, len(probes))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for key, hop := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok := m.probe(ctx, probeAddr(hop), m.timeout) == nil
+			mu.Lock()
+			results[key] = ok
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	streaks := make(map[string]probeStreak, len(probes))
+	for key, hop := range probes {
+		streak := m.streaks[key]
+		if results[key] {
+			streak.passes++
+			streak.fails = 0
+		} else {
+			streak.fails++
+			streak.passes = 0
+		}
+		streaks[key] = streak
+		var changed, up bool
+		switch {
+		case streak.fails >= m.fall:
+			changed = m.table.SetNextHopUp(hop, false)
+		case streak.passes >= m.rise:
+			changed, up = m.table.SetNextHopUp(hop, true), true
+		}
+		if changed && m.onChange != nil {
+			m.onChange("next hop "+hopKey(hop)+" probe "+probeAddr(hop), up)
+		}
+	}
+	m.streaks = streaks
+}
+
+func probeAddr(hop NextHop) string {
+	host, port, err := net.SplitHostPort(hop.Probe)
+	if err != nil {
+		return hop.Probe
+	}
+	if host == "" && hop.Gateway != nil {
+		host = hop.Gateway.String()
+	}
+	return net.JoinHostPort(host, port)
+}
+
+func systemLinkState(name string) (bool, bool) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return false, false
+	}
+	return iface.Flags&net.FlagUp != 0, true
+}
+
+func tcpProbe(ctx context.Context, addr string, timeout time.Duration) error {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	_ = conn.Close()
+	return nil
+}
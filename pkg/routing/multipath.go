@@ -0,0 +1,195 @@
+package routing
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"router-go/pkg/network"
+)
+
+type NextHop struct {
+	Gateway   net.IP
+	Interface string
+	Weight    int
+	Probe     string
+}
+
+type NextHopStats struct {
+	NextHop
+	Up      bool
+	Packets uint64
+}
+
+type pathSet struct {
+	hops    []NextHop
+	seeds   []uint64
+	packets []atomic.Uint64
+}
+
+type pathState struct {
+	downInterfaces map[string]struct{}
+	downHops       map[string]struct{}
+}
+
+func (r Route) Paths() []NextHop {
+	if len(r.NextHops) > 0 {
+		out := make([]NextHop, len(r.NextHops))
+		copy(out, r.NextHops)
+		return out
+	}
+	return []NextHop{{Gateway: r.Gateway, Interface: r.Interface, Weight: 1}}
+}
+
+func (r Route) Multipath() bool {
+	return len(r.NextHops) > 1
+}
+
+func newPathSet(route Route) *pathSet {
+	hops := route.Paths()
+	set := &pathSet{
+		hops:    hops,
+		seeds:   make([]uint64, len(hops)),
+		packets: make([]atomic.Uint64, len(hops)),
+	}
+	for i, hop := range hops {
+		set.seeds[i] = hashString(hopKey(hop))
+	}
+	return set
+}
+
+func hopKey(hop NextHop) string {
+	gw := ""
+	if hop.Gateway != nil {
+		gw = hop.Gateway.String()
+	}
+	return gw + "|" + hop.Interface
+}
+
+func (s *pathState) hopUp(hop NextHop) bool {
+	if s == nil {
+		return true
+	}
+	if hop.Interface != "" {
+		if _, down := s.downInterfaces[hop.Interface]; down {
+			return false
+		}
+	}
+	_, down := s.downHops[hopKey(hop)]
+	return !down
+}
+
+func (s *pathState) clone() *pathState {
+	out := &pathState{
+		downInterfaces: map[string]struct{}{},
+		downHops:       map[string]struct{}{},
+	}
+	if s == nil {
+		return out
+	}
+	for name := range s.downInterfaces {
+		out.downInterfaces[name] = struct{}{}
+	}
+	for key := range s.downHops {
+		out.downHops[key] = struct{}{}
+	}
+	return out
+}
+
+func (s *pathSet) selectHop(state *pathState, hash uint64) int {
+	best := -1
+	bestScore := 0.0
+	for i, hop := range s.hops {
+		if !state.hopUp(hop) {
+			continue
+		}
+		weight := hop.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		score := rendezvousScore(hash, s.seeds[i], weight)
+		if best < 0 || score > bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	return best
+}
+
+func rendezvousScore(hash uint64, seed uint64, weight int) float64 {
+	h := mix64(hash ^ seed)
+	u := (float64(h>>11) + 0.5) / (1 << 53)
+	return float64(weight) / -math.Log(u)
+}
+
+func FlowHash(meta network.PacketMetadata) uint64 {
+	a, aPort := meta.SrcIP.To16(), meta.SrcPort
+	b, bPort := meta.DstIP.To16(), meta.DstPort
+	if cmp := bytes.Compare(a, b); cmp > 0 || (cmp == 0 && aPort > bPort) {
+		a, b = b, a
+		aPort, bPort = bPort, aPort
+	}
+	h := uint64(fnvOffset)
+	h = fnvBytes(h, a)
+	h = fnvUint(h, uint64(aPort))
+	h = fnvBytes(h, b)
+	h = fnvUint(h, uint64(bPort))
+	if meta.ProtocolNum != 0 {
+		h = fnvUint(h, uint64(meta.ProtocolNum))
+	} else {
+		h = fnvBytes(h, []byte(strings.ToUpper(meta.Protocol)))
+	}
+	return h
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+func fnvBytes(h uint64, data []byte) uint64 {
+	for _, b := range data {
+		h ^= uint64(b)
+		h *= fnvPrime
+	}
+	return h
+}
+
+func fnvUint(h uint64, v uint64) uint64 {
+	for i := 0; i < 8; i++ {
+		h ^= v & 0xff
+		h *= fnvPrime
+		v >>= 8
+	}
+	return h
+}
+
+func hashString(s string) uint64 {
+	return mix64(fnvBytes(fnvOffset, []byte(s)))
+}
+
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb3f42cb44d8b
+	x ^= x >> 33
+	return x
+}
+
+func nextHopsEqual(a []NextHop, b []NextHop) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Interface != b[i].Interface || a[i].Weight != b[i].Weight || a[i].Probe != b[i].Probe {
+			return false
+		}
+		if !ipEqual(a[i].Gateway, b[i].Gateway) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,289 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"router-go/pkg/network"
+)
+
+func multipathTable(t *testing.T) *Table {
+	t.Helper()
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	return NewTable([]Route{{
+		Destination: *dst,
+		NextHops: []NextHop{
+			{Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Weight: 1},
+			{Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2", Weight: 1},
+		},
+	}})
+}
+
+func flowMeta(i int) network.PacketMetadata {
+	return network.PacketMetadata{
+		SrcIP:       net.IPv4(10, 0, byte(i>>8), byte(i)),
+		DstIP:       net.IPv4(203, 0, 113, byte(i%250+1)),
+		Protocol:    "TCP",
+		ProtocolNum: 6,
+		SrcPort:     10000 + i,
+		DstPort:     443,
+	}
+}
+
+func TestFlowHashSymmetric(t *testing.T) {
+	meta := flowMeta(7)
+	reverse := network.PacketMetadata{
+		SrcIP:       meta.DstIP,
+		DstIP:       meta.SrcIP,
+		Protocol:    meta.Protocol,
+		ProtocolNum: meta.ProtocolNum,
+		SrcPort:     meta.DstPort,
+		DstPort:     meta.SrcPort,
+	}
+	if FlowHash(meta) != FlowHash(reverse) {
+		t.Fatalf("expected symmetric hash")
+	}
+	other := meta
+	other.SrcPort++
+	if FlowHash(meta) == FlowHash(other) {
+		t.Fatalf("expected different flows to hash differently")
+	}
+}
+
+func TestLookupFlowUsesAllPathsAndSticks(t *testing.T) {
+	table := multipathTable(t)
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		_, hop, ok := table.LookupFlow(flowMeta(i))
+		if !ok {
+			t.Fatalf("expected route match")
+		}
+		counts[hop.Interface]++
+		_, again, _ := table.LookupFlow(flowMeta(i))
+		if again.Interface != hop.Interface {
+			t.Fatalf("expected flow %d to stick to %s, got %s", i, hop.Interface, again.Interface)
+		}
+	}
+	if counts["wan1"] < 350 || counts["wan2"] < 350 {
+		t.Fatalf("expected balanced distribution, got %v", counts)
+	}
+
+	stats := table.PathStats(table.Routes()[0])
+	if len(stats) != 2 {
+		t.Fatalf("expected 2 next hop stats, got %d", len(stats))
+	}
+	if stats[0].Packets+stats[1].Packets != 2000 {
+		t.Fatalf("expected 2000 packets counted, got %+v", stats)
+	}
+}
+
+func TestLookupFlowRespectsWeights(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	table := NewTable([]Route{{
+		Destination: *dst,
+		NextHops: []NextHop{
+			{Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Weight: 3},
+			{Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2", Weight: 1},
+		},
+	}})
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		_, hop, _ := table.LookupFlow(flowMeta(i))
+		counts[hop.Interface]++
+	}
+	if counts["wan1"] < 2700 || counts["wan1"] > 3300 {
+		t.Fatalf("expected ~3000 flows on wan1, got %v", counts)
+	}
+}
+
+func TestNextHopDropsOutWhenInterfaceDown(t *testing.T) {
+	table := multipathTable(t)
+	moved := 0
+	before := make([]string, 200)
+	for i := range before {
+		_, hop, _ := table.LookupFlow(flowMeta(i))
+		before[i] = hop.Interface
+	}
+	if !table.SetInterfaceUp("wan1", false) {
+		t.Fatalf("expected state change")
+	}
+	if table.SetInterfaceUp("wan1", false) {
+		t.Fatalf("expected no change on repeated down")
+	}
+	for i := range before {
+		_, hop, ok := table.LookupFlow(flowMeta(i))
+		if !ok || hop.Interface != "wan2" {
+			t.Fatalf("expected wan2 while wan1 is down, got %+v", hop)
+		}
+	}
+	table.SetInterfaceUp("wan1", true)
+	for i := range before {
+		_, hop, _ := table.LookupFlow(flowMeta(i))
+		if hop.Interface != before[i] {
+			moved++
+		}
+	}
+	if moved != 0 {
+		t.Fatalf("expected flows to return to original paths, %d moved", moved)
+	}
+}
+
+func TestLookupFallsBackWhenAllNextHopsDown(t *testing.T) {
+	_, defNet, _ := net.ParseCIDR("0.0.0.0/0")
+	_, lanNet, _ := net.ParseCIDR("10.0.0.0/8")
+	table := NewTable([]Route{
+		{Destination: *defNet, Interface: "wan1"},
+		{Destination: *lanNet, Interface: "lan1", Metric: 10},
+		{Destination: *lanNet, Interface: "lan2", Metric: 20},
+	})
+	table.SetInterfaceUp("lan1", false)
+	route, ok := table.Lookup(net.ParseIP("10.1.1.1"))
+	if !ok || route.Interface != "lan2" {
+		t.Fatalf("expected lan2 backup, got %+v", route)
+	}
+	table.SetNextHopUp(NextHop{Interface: "lan2"}, false)
+	route, ok = table.Lookup(net.ParseIP("10.1.1.1"))
+	if !ok || route.Interface != "wan1" {
+		t.Fatalf("expected default route, got %+v", route)
+	}
+}
+
+func TestMonitorMarksNextHops(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	table := NewTable([]Route{{
+		Destination: *dst,
+		NextHops: []NextHop{
+			{Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Probe: ":443"},
+			{Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2"},
+		},
+	}})
+	mon := NewMonitor(table, time.Second, time.Second)
+	links := map[string]bool{"wan1": true, "wan2": true}
+	mon.linkState = func(name string) (bool, bool) {
+		up, ok := links[name]
+		return up, ok
+	}
+	var probed []string
+	mon.probe = func(ctx context.Context, addr string, timeout time.Duration) error {
+		probed = append(probed, addr)
+		return errors.New("unreachable")
+	}
+	var changes []string
+	mon.SetOnChange(func(target string, up bool) {
+		changes = append(changes, target)
+	})
+
+	for i := 0; i < DefaultProbeFall; i++ {
+		if stats := table.PathStats(table.Routes()[0]); !stats[0].Up {
+			t.Fatalf("expected wan1 to stay up after %d failed probes", i)
+		}
+		mon.Check(context.Background())
+	}
+	if len(probed) != DefaultProbeFall || probed[0] != "192.0.2.1:443" {
+		t.Fatalf("unexpected probes: %v", probed)
+	}
+	stats := table.PathStats(table.Routes()[0])
+	if stats[0].Up || !stats[1].Up {
+		t.Fatalf("expected wan1 down by probe, got %+v", stats)
+	}
+
+	delete(links, "wan2")
+	mon.Check(context.Background())
+	stats = table.PathStats(table.Routes()[0])
+	if stats[1].Up {
+		t.Fatalf("expected vanished interface to be down")
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %v", changes)
+	}
+	if _, _, ok := table.LookupFlow(flowMeta(1)); ok {
+		t.Fatalf("expected no usable path")
+	}
+}
+
+func TestMonitorProbesInParallelAndRecovers(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("0.0.0.0/0")
+	table := NewTable([]Route{{
+		Destination: *dst,
+		NextHops: []NextHop{
+			{Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Probe: ":443"},
+			{Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2", Probe: ":443"},
+		},
+	}})
+	mon := NewMonitor(table, time.Second, time.Second)
+	mon.linkState = func(name string) (bool, bool) { return true, true }
+	mon.SetThresholds(1, 2)
+
+	// Each probe waits for the other one, so the check only completes when
+	// they run at the same time.
+	started := make(chan struct{}, 2)
+	var fail atomic.Bool
+	fail.Store(true)
+	mon.probe = func(ctx context.Context, addr string, timeout time.Duration) error {
+		started <- struct{}{}
+		deadline := time.After(time.Second)
+		for len(started) < 2 {
+			select {
+			case <-deadline:
+				return errors.New("probes ran one at a time")
+			default:
+				time.Sleep(time.Millisecond)
+			}
+		}
+		if fail.Load() && addr == "192.0.2.1:443" {
+			return errors.New("unreachable")
+		}
+		return nil
+	}
+	reset := func() {
+		for len(started) > 0 {
+			<-started
+		}
+	}
+
+	mon.Check(context.Background())
+	stats := table.PathStats(table.Routes()[0])
+	if stats[0].Up || !stats[1].Up {
+		t.Fatalf("expected only wan1 down, got %+v", stats)
+	}
+	fail.Store(false)
+	reset()
+	mon.Check(context.Background())
+	if stats := table.PathStats(table.Routes()[0]); stats[0].Up {
+		t.Fatalf("expected wan1 to need two successful probes")
+	}
+	reset()
+	mon.Check(context.Background())
+	if stats := table.PathStats(table.Routes()[0]); !stats[0].Up {
+		t.Fatalf("expected wan1 back up")
+	}
+}
+
+func TestReplaceRoutesKeepsPathCounters(t *testing.T) {
+	table := multipathTable(t)
+	for i := 0; i < 10; i++ {
+		table.LookupFlow(flowMeta(i))
+	}
+	routes := table.Routes()
+	_, extra, _ := net.ParseCIDR("10.9.0.0/16")
+	table.ReplaceRoutes(append(routes, Route{Destination: *extra, Interface: "lan1"}))
+
+	total := uint64(0)
+	for _, stat := range table.PathStats(table.Routes()[0]) {
+		total += stat.Packets
+	}
+	if total != 10 {
+		t.Fatalf("expected counters to survive a rebuild, got %d", total)
+	}
+
+	changed := table.Routes()[0]
+	changed.NextHops = changed.NextHops[:1]
+	table.ReplaceRoutes([]Route{changed})
+	if stats := table.PathStats(table.Routes()[0]); stats[0].Packets != 0 {
+		t.Fatalf("expected new next hops to start from zero, got %+v", stats)
+	}
+}
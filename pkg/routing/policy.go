@@ -0,0 +1,338 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"router-go/pkg/network"
+)
+
+const MainTable = "main"
+
+var (
+	ErrTableExists   = errors.New("routing table already exists")
+	ErrTableNotFound = errors.New("routing table not found")
+	ErrTableInUse    = errors.New("routing table is referenced by a rule")
+	ErrMainTable     = errors.New("main routing table cannot be changed")
+	ErrRuleExists    = errors.New("policy rule priority already exists")
+	ErrRuleNotFound  = errors.New("policy rule not found")
+	ErrRuleTable     = errors.New("policy rule table is required")
+)
+
+type PolicyRule struct {
+	Priority    int
+	SrcNet      *net.IPNet
+	DstNet      *net.IPNet
+	InInterface string
+	Protocol    string
+	SrcPort     int
+	DstPort     int
+	DSCP        uint8
+	MatchDSCP   bool
+	Mark        uint32
+	MarkMask    uint32
+	Table       string
+}
+
+type Policy struct {
+	mu      sync.Mutex
+	main    *Table
+	current atomic.Pointer[policySnapshot]
+}
+
+type policySnapshot struct {
+	rules  []PolicyRule
+	tables map[string]*Table
+}
+
+func NewPolicy(main *Table) *Policy {
+	if main == nil {
+		main = NewTable(nil)
+	}
+	p := &Policy{main: main}
+	p.current.Store(&policySnapshot{tables: map[string]*Table{MainTable: main}})
+	return p
+}
+
+func (p *Policy) Main() *Table {
+	return p.main
+}
+
+func (p *Policy) Table(name string) (*Table, bool) {
+	if name == "" {
+		name = MainTable
+	}
+	table, ok := p.current.Load().tables[name]
+	return table, ok
+}
+
+func (p *Policy) TableNames() []string {
+	snap := p.current.Load()
+	out := make([]string, 0, len(snap.tables))
+	for name := range snap.tables {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (p *Policy) AddTable(name string, routes []Route) (*Table, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	if _, ok := snap.tables[name]; ok {
+		return nil, ErrTableExists
+	}
+	table := newTableWithHealth(routes, p.main.health)
+	next := snap.clone()
+	next.tables[name] = table
+	p.current.Store(next)
+	return table, nil
+}
+
+func (p *Policy) EnsureTable(name string) *Table {
+	if table, ok := p.Table(name); ok {
+		return table
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	if table, ok := snap.tables[name]; ok {
+		return table
+	}
+	table := newTableWithHealth(nil, p.main.health)
+	next := snap.clone()
+	next.tables[name] = table
+	p.current.Store(next)
+	return table
+}
+
+func (p *Policy) RemoveTable(name string) error {
+	if name == MainTable {
+		return ErrMainTable
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	if _, ok := snap.tables[name]; !ok {
+		return ErrTableNotFound
+	}
+	for _, rule := range snap.rules {
+		if rule.Table == name {
+			return ErrTableInUse
+		}
+	}
+	next := snap.clone()
+	delete(next.tables, name)
+	p.current.Store(next)
+	return nil
+}
+
+func (p *Policy) ReplaceTables(tables map[string][]Route) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	next := &policySnapshot{
+		rules:  snap.rules,
+		tables: map[string]*Table{MainTable: p.main},
+	}
+	for name, routes := range tables {
+		if name == MainTable {
+			continue
+		}
+		if existing, ok := snap.tables[name]; ok {
+			existing.ReplaceRoutes(routes)
+			next.tables[name] = existing
+			continue
+		}
+		next.tables[name] = newTableWithHealth(routes, p.main.health)
+	}
+	p.current.Store(next)
+}
+
+func (p *Policy) Rules() []PolicyRule {
+	snap := p.current.Load()
+	out := make([]PolicyRule, len(snap.rules))
+	copy(out, snap.rules)
+	return out
+}
+
+func (p *Policy) AddRule(rule PolicyRule) error {
+	if rule.Table == "" {
+		return ErrRuleTable
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	if _, ok := snap.tables[rule.Table]; !ok {
+		return ErrTableNotFound
+	}
+	for _, existing := range snap.rules {
+		if existing.Priority == rule.Priority {
+			return ErrRuleExists
+		}
+	}
+	next := snap.clone()
+	next.rules = append(next.rules, normalizeRule(rule))
+	sortRules(next.rules)
+	p.current.Store(next)
+	return nil
+}
+
+func (p *Policy) UpdateRule(priority int, rule PolicyRule) error {
+	if rule.Table == "" {
+		return ErrRuleTable
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	if _, ok := snap.tables[rule.Table]; !ok {
+		return ErrTableNotFound
+	}
+	index := -1
+	for i, existing := range snap.rules {
+		if existing.Priority == priority {
+			index = i
+		} else if existing.Priority == rule.Priority {
+			return ErrRuleExists
+		}
+	}
+	if index < 0 {
+		return ErrRuleNotFound
+	}
+	next := snap.clone()
+	next.rules[index] = normalizeRule(rule)
+	sortRules(next.rules)
+	p.current.Store(next)
+	return nil
+}
+
+func (p *Policy) RemoveRule(priority int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	snap := p.current.Load()
+	for i, existing := range snap.rules {
+		if existing.Priority != priority {
+			continue
+		}
+		next := snap.clone()
+		next.rules = append(next.rules[:i], next.rules[i+1:]...)
+		p.current.Store(next)
+		return true
+	}
+	return false
+}
+
+func (p *Policy) ReplaceRules(rules []PolicyRule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	next := p.current.Load().clone()
+	next.rules = make([]PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		next.rules = append(next.rules, normalizeRule(rule))
+	}
+	sortRules(next.rules)
+	p.current.Store(next)
+}
+
+func (p *Policy) AllRoutes() []Route {
+	snap := p.current.Load()
+	var out []Route
+	for _, table := range snap.tables {
+		out = append(out, table.Routes()...)
+	}
+	return out
+}
+
+func (p *Policy) Lookup(pkt network.Packet) (Route, string, bool) {
+	route, _, table, ok := p.lookup(pkt, false)
+	return route, table, ok
+}
+
+func (p *Policy) LookupFlow(pkt network.Packet) (Route, NextHop, string, bool) {
+	return p.lookup(pkt, true)
+}
+
+func (p *Policy) lookup(pkt network.Packet, flow bool) (Route, NextHop, string, bool) {
+	snap := p.current.Load()
+	for i := range snap.rules {
+		rule := &snap.rules[i]
+		if !rule.Matches(pkt) {
+			continue
+		}
+		table, ok := snap.tables[rule.Table]
+		if !ok {
+			continue
+		}
+		if route, hop, ok := lookupTable(table, pkt.Metadata, flow); ok {
+			return route, hop, rule.Table, true
+		}
+	}
+	route, hop, ok := lookupTable(p.main, pkt.Metadata, flow)
+	return route, hop, MainTable, ok
+}
+
+func lookupTable(table *Table, meta network.PacketMetadata, flow bool) (Route, NextHop, bool) {
+	if flow {
+		return table.LookupFlow(meta)
+	}
+	route, ok := table.Lookup(meta.DstIP)
+	return route, NextHop{}, ok
+}
+
+func (r *PolicyRule) Matches(pkt network.Packet) bool {
+	meta := pkt.Metadata
+	if r.SrcNet != nil && (meta.SrcIP == nil || !r.SrcNet.Contains(meta.SrcIP)) {
+		return false
+	}
+	if r.DstNet != nil && (meta.DstIP == nil || !r.DstNet.Contains(meta.DstIP)) {
+		return false
+	}
+	if r.InInterface != "" && r.InInterface != pkt.IngressInterface {
+		return false
+	}
+	if r.Protocol != "" && !strings.EqualFold(r.Protocol, meta.Protocol) {
+		return false
+	}
+	if r.SrcPort != 0 && r.SrcPort != meta.SrcPort {
+		return false
+	}
+	if r.DstPort != 0 && r.DstPort != meta.DstPort {
+		return false
+	}
+	if r.MatchDSCP && r.DSCP != meta.DSCP {
+		return false
+	}
+	if r.MarkMask != 0 && pkt.Mark&r.MarkMask != r.Mark&r.MarkMask {
+		return false
+	}
+	return true
+}
+
+func (s *policySnapshot) clone() *policySnapshot {
+	out := &policySnapshot{
+		rules:  append([]PolicyRule(nil), s.rules...),
+		tables: make(map[string]*Table, len(s.tables)),
+	}
+	for name, table := range s.tables {
+		out.tables[name] = table
+	}
+	return out
+}
+
+func normalizeRule(rule PolicyRule) PolicyRule {
+	if rule.Mark != 0 && rule.MarkMask == 0 {
+		rule.MarkMask = ^uint32(0)
+	}
+	return rule
+}
+
+func sortRules(rules []PolicyRule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+}
@@ -0,0 +1,134 @@
+package routing
+
+import (
+	"net"
+	"testing"
+
+	"router-go/pkg/network"
+)
+
+func policyPacket(src string, dst string, iface string) network.Packet {
+	return network.Packet{
+		IngressInterface: iface,
+		Metadata: network.PacketMetadata{
+			SrcIP:    net.ParseIP(src),
+			DstIP:    net.ParseIP(dst),
+			Protocol: "TCP",
+			SrcPort:  40000,
+			DstPort:  443,
+		},
+	}
+}
+
+func newTestPolicy(t *testing.T) *Policy {
+	t.Helper()
+	_, def, _ := net.ParseCIDR("0.0.0.0/0")
+	policy := NewPolicy(NewTable([]Route{{Destination: *def, Interface: "wan1"}}))
+	if _, err := policy.AddTable("isp2", []Route{{Destination: *def, Interface: "wan2"}}); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	return policy
+}
+
+func TestPolicySelectsTableBySource(t *testing.T) {
+	policy := newTestPolicy(t)
+	_, guests, _ := net.ParseCIDR("10.0.2.0/24")
+	if err := policy.AddRule(PolicyRule{Priority: 100, SrcNet: guests, Table: "isp2"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+
+	route, hop, table, ok := policy.LookupFlow(policyPacket("10.0.2.5", "8.8.8.8", "lan"))
+	if !ok || table != "isp2" || route.Interface != "wan2" || hop.Interface != "wan2" {
+		t.Fatalf("expected isp2/wan2, got %s %+v", table, route)
+	}
+	route, table, ok = policy.Lookup(policyPacket("10.0.1.5", "8.8.8.8", "lan"))
+	if !ok || table != MainTable || route.Interface != "wan1" {
+		t.Fatalf("expected main/wan1, got %s %+v", table, route)
+	}
+}
+
+func TestPolicyRuleOrderAndFallthrough(t *testing.T) {
+	policy := newTestPolicy(t)
+	if _, err := policy.AddTable("empty", nil); err != nil {
+		t.Fatalf("add table: %v", err)
+	}
+	if err := policy.AddRule(PolicyRule{Priority: 200, Protocol: "tcp", Table: "isp2"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	if err := policy.AddRule(PolicyRule{Priority: 100, InInterface: "lan", Table: "empty"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	rules := policy.Rules()
+	if rules[0].Priority != 100 || rules[1].Priority != 200 {
+		t.Fatalf("expected rules sorted by priority, got %+v", rules)
+	}
+	_, table, ok := policy.Lookup(policyPacket("10.0.1.5", "8.8.8.8", "lan"))
+	if !ok || table != "isp2" {
+		t.Fatalf("expected fallthrough from empty table to isp2, got %s", table)
+	}
+}
+
+func TestPolicyRuleMatchesDSCPAndMark(t *testing.T) {
+	rule := PolicyRule{DSCP: 46, MatchDSCP: true, Mark: 0x10, MarkMask: 0xf0, Table: "voice"}
+	pkt := policyPacket("10.0.0.1", "10.0.0.2", "lan")
+	pkt.Metadata.DSCP = 46
+	pkt.Mark = 0x1f
+	if !rule.Matches(pkt) {
+		t.Fatalf("expected dscp/mark match")
+	}
+	pkt.Mark = 0x20
+	if rule.Matches(pkt) {
+		t.Fatalf("expected mark mismatch")
+	}
+	pkt.Mark = 0x10
+	pkt.Metadata.DSCP = 0
+	if rule.Matches(pkt) {
+		t.Fatalf("expected dscp mismatch")
+	}
+}
+
+func TestPolicyRuleCRUD(t *testing.T) {
+	policy := newTestPolicy(t)
+	if err := policy.AddRule(PolicyRule{Priority: 10, Table: "missing"}); err != ErrTableNotFound {
+		t.Fatalf("expected ErrTableNotFound, got %v", err)
+	}
+	if err := policy.AddRule(PolicyRule{Priority: 10, Mark: 7, Table: "isp2"}); err != nil {
+		t.Fatalf("add rule: %v", err)
+	}
+	if policy.Rules()[0].MarkMask != ^uint32(0) {
+		t.Fatalf("expected full mark mask by default")
+	}
+	if err := policy.AddRule(PolicyRule{Priority: 10, Table: "isp2"}); err != ErrRuleExists {
+		t.Fatalf("expected ErrRuleExists, got %v", err)
+	}
+	if err := policy.RemoveTable("isp2"); err != ErrTableInUse {
+		t.Fatalf("expected ErrTableInUse, got %v", err)
+	}
+	if err := policy.UpdateRule(10, PolicyRule{Priority: 20, Table: MainTable}); err != nil {
+		t.Fatalf("update rule: %v", err)
+	}
+	if err := policy.UpdateRule(10, PolicyRule{Priority: 10, Table: MainTable}); err != ErrRuleNotFound {
+		t.Fatalf("expected ErrRuleNotFound, got %v", err)
+	}
+	if !policy.RemoveRule(20) || policy.RemoveRule(20) {
+		t.Fatalf("expected single removal")
+	}
+	if err := policy.RemoveTable("isp2"); err != nil {
+		t.Fatalf("remove table: %v", err)
+	}
+	if err := policy.RemoveTable(MainTable); err != ErrMainTable {
+		t.Fatalf("expected ErrMainTable, got %v", err)
+	}
+}
+
+func TestPolicyTablesShareNextHopState(t *testing.T) {
+	policy := newTestPolicy(t)
+	_, def, _ := net.ParseCIDR("0.0.0.0/0")
+	isp2, _ := policy.Table("isp2")
+	isp2.Add(Route{Destination: *def, Interface: "wan3", Metric: 10})
+	policy.Main().SetInterfaceUp("wan2", false)
+	route, ok := isp2.Lookup(net.ParseIP("8.8.8.8"))
+	if !ok || route.Interface != "wan3" {
+		t.Fatalf("expected wan3 after wan2 down, got %+v", route)
+	}
+}
@@ -0,0 +1,323 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	ActionPermit = "permit"
+	ActionDeny   = "deny"
+)
+
+var (
+	ErrPrefixListNotFound = errors.New("prefix list not found")
+	ErrPrefixListInUse    = errors.New("prefix list is referenced by a route map")
+	ErrRouteMapNotFound   = errors.New("route map not found")
+)
+
+type PrefixListEntry struct {
+	Seq    int
+	Action string
+	Prefix net.IPNet
+	GE     int
+	LE     int
+}
+
+type PrefixList struct {
+	Name    string
+	Entries []PrefixListEntry
+}
+
+type RouteMapEntry struct {
+	Seq             int
+	Action          string
+	MatchPrefixList string
+	MatchSource     string
+	MatchMetric     *int
+	SetMetric       *int
+	SetTag          *uint32
+}
+
+type RouteMap struct {
+	Name    string
+	Entries []RouteMapEntry
+}
+
+// RouteMapStep records how one route map entry was evaluated.
+type RouteMapStep struct {
+	Seq     int    `json:"seq"`
+	Action  string `json:"action"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type RouteMapResult struct {
+	Map    string
+	Permit bool
+	Seq    int
+	Route  Route
+	Trace  []RouteMapStep
+}
+
+// RouteFilters holds the named prefix lists and route maps shared by the
+// routing protocols that import or export routes.
+type RouteFilters struct {
+	mu          sync.RWMutex
+	prefixLists map[string]PrefixList
+	routeMaps   map[string]RouteMap
+	onChange    func()
+}
+
+func NewRouteFilters() *RouteFilters {
+	return &RouteFilters{
+		prefixLists: map[string]PrefixList{},
+		routeMaps:   map[string]RouteMap{},
+	}
+}
+
+// SetOnChange is called after a prefix list or route map is set or deleted,
+// so importers can run the routes they already accepted through it again.
+func (f *RouteFilters) SetOnChange(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onChange = fn
+}
+
+func (f *RouteFilters) notifyChange() {
+	f.mu.RLock()
+	fn := f.onChange
+	f.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (f *RouteFilters) SetPrefixList(list PrefixList) error {
+	if err := list.validate(); err != nil {
+		return err
+	}
+	list.Entries = append([]PrefixListEntry(nil), list.Entries...)
+	sort.SliceStable(list.Entries, func(i, j int) bool { return list.Entries[i].Seq < list.Entries[j].Seq })
+	f.mu.Lock()
+	f.prefixLists[list.Name] = list
+	f.mu.Unlock()
+	f.notifyChange()
+	return nil
+}
+
+func (f *RouteFilters) DeletePrefixList(name string) error {
+	if err := f.deletePrefixList(name); err != nil {
+		return err
+	}
+	f.notifyChange()
+	return nil
+}
+
+func (f *RouteFilters) deletePrefixList(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.prefixLists[name]; !ok {
+		return ErrPrefixListNotFound
+	}
+	for _, m := range f.routeMaps {
+		for _, entry := range m.Entries {
+			if entry.MatchPrefixList == name {
+				return fmt.Errorf("%w %q", ErrPrefixListInUse, m.Name)
+			}
+		}
+	}
+	delete(f.prefixLists, name)
+	return nil
+}
+
+func (f *RouteFilters) PrefixLists() []PrefixList {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]PrefixList, 0, len(f.prefixLists))
+	for _, list := range f.prefixLists {
+		out = append(out, list)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (f *RouteFilters) SetRouteMap(m RouteMap) error {
+	if err := m.validate(); err != nil {
+		return err
+	}
+	m.Entries = append([]RouteMapEntry(nil), m.Entries...)
+	sort.SliceStable(m.Entries, func(i, j int) bool { return m.Entries[i].Seq < m.Entries[j].Seq })
+	f.mu.Lock()
+	for _, entry := range m.Entries {
+		if entry.MatchPrefixList == "" {
+			continue
+		}
+		if _, ok := f.prefixLists[entry.MatchPrefixList]; !ok {
+			f.mu.Unlock()
+			return fmt.Errorf("route map %s seq %d: %w: %s", m.Name, entry.Seq, ErrPrefixListNotFound, entry.MatchPrefixList)
+		}
+	}
+	f.routeMaps[m.Name] = m
+	f.mu.Unlock()
+	f.notifyChange()
+	return nil
+}
+
+func (f *RouteFilters) DeleteRouteMap(name string) bool {
+	f.mu.Lock()
+	_, ok := f.routeMaps[name]
+	delete(f.routeMaps, name)
+	f.mu.Unlock()
+	if ok {
+		f.notifyChange()
+	}
+	return ok
+}
+
+func (f *RouteFilters) RouteMaps() []RouteMap {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]RouteMap, 0, len(f.routeMaps))
+	for _, m := range f.routeMaps {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Apply runs route through the named route map. An empty name or a nil
+// receiver permits every route unchanged; a missing map denies everything.
+func (f *RouteFilters) Apply(name string, route Route) RouteMapResult {
+	result := RouteMapResult{Map: name, Route: route}
+	if f == nil || name == "" {
+		result.Permit = true
+		return result
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	m, ok := f.routeMaps[name]
+	if !ok {
+		result.Trace = append(result.Trace, RouteMapStep{Action: ActionDeny, Reason: ErrRouteMapNotFound.Error()})
+		return result
+	}
+	for _, entry := range m.Entries {
+		step := RouteMapStep{Seq: entry.Seq, Action: entry.Action}
+		step.Matched, step.Reason = f.matchEntry(entry, route)
+		result.Trace = append(result.Trace, step)
+		if !step.Matched {
+			continue
+		}
+		result.Seq = entry.Seq
+		if entry.Action == ActionDeny {
+			return result
+		}
+		if entry.SetMetric != nil {
+			result.Route.Metric = *entry.SetMetric
+		}
+		if entry.SetTag != nil {
+			result.Route.Tag = *entry.SetTag
+		}
+		result.Permit = true
+		return result
+	}
+	result.Trace = append(result.Trace, RouteMapStep{Action: ActionDeny, Reason: "no entry matched"})
+	return result
+}
+
+func (f *RouteFilters) matchEntry(entry RouteMapEntry, route Route) (bool, string) {
+	if entry.MatchPrefixList != "" {
+		list, ok := f.prefixLists[entry.MatchPrefixList]
+		if !ok {
+			return false, "prefix list " + entry.MatchPrefixList + " not found"
+		}
+		if !list.Permits(route.Destination) {
+			return false, "prefix list " + entry.MatchPrefixList + " denied " + route.Destination.String()
+		}
+	}
+	if entry.MatchSource != "" && entry.MatchSource != route.Source {
+		return false, "source " + route.Source + " does not match " + entry.MatchSource
+	}
+	if entry.MatchMetric != nil && *entry.MatchMetric != route.Metric {
+		return false, fmt.Sprintf("metric %d does not match %d", route.Metric, *entry.MatchMetric)
+	}
+	return true, ""
+}
+
+// Permits reports whether the first entry covering prefix permits it. Prefixes
+// not covered by any entry are denied.
+func (l PrefixList) Permits(prefix net.IPNet) bool {
+	for _, entry := range l.Entries {
+		if entry.matches(prefix) {
+			return entry.Action == ActionPermit
+		}
+	}
+	return false
+}
+
+func (e PrefixListEntry) matches(prefix net.IPNet) bool {
+	ones, bits := prefix.Mask.Size()
+	entryOnes, entryBits := e.Prefix.Mask.Size()
+	if bits != entryBits || ones < entryOnes || !e.Prefix.Contains(prefix.IP) {
+		return false
+	}
+	if e.GE == 0 && e.LE == 0 {
+		return ones == entryOnes
+	}
+	minLen, maxLen := entryOnes, bits
+	if e.GE != 0 {
+		minLen = e.GE
+	}
+	if e.LE != 0 {
+		maxLen = e.LE
+	}
+	return ones >= minLen && ones <= maxLen
+}
+
+func (l PrefixList) validate() error {
+	if strings.TrimSpace(l.Name) == "" {
+		return errors.New("prefix list name is required")
+	}
+	seen := map[int]struct{}{}
+	for _, entry := range l.Entries {
+		if _, dup := seen[entry.Seq]; dup {
+			return fmt.Errorf("prefix list %s: duplicate seq %d", l.Name, entry.Seq)
+		}
+		seen[entry.Seq] = struct{}{}
+		if entry.Action != ActionPermit && entry.Action != ActionDeny {
+			return fmt.Errorf("prefix list %s seq %d: action must be permit or deny", l.Name, entry.Seq)
+		}
+		ones, bits := entry.Prefix.Mask.Size()
+		if bits == 0 {
+			return fmt.Errorf("prefix list %s seq %d: invalid prefix", l.Name, entry.Seq)
+		}
+		if entry.GE != 0 && (entry.GE < ones || entry.GE > bits) || entry.LE != 0 && (entry.LE < ones || entry.LE > bits) || entry.GE != 0 && entry.LE != 0 && entry.GE > entry.LE {
+			return fmt.Errorf("prefix list %s seq %d: ge/le out of range", l.Name, entry.Seq)
+		}
+	}
+	return nil
+}
+
+func (m RouteMap) validate() error {
+	if strings.TrimSpace(m.Name) == "" {
+		return errors.New("route map name is required")
+	}
+	seen := map[int]struct{}{}
+	for _, entry := range m.Entries {
+		if _, dup := seen[entry.Seq]; dup {
+			return fmt.Errorf("route map %s: duplicate seq %d", m.Name, entry.Seq)
+		}
+		seen[entry.Seq] = struct{}{}
+		if entry.Action != ActionPermit && entry.Action != ActionDeny {
+			return fmt.Errorf("route map %s seq %d: action must be permit or deny", m.Name, entry.Seq)
+		}
+		if entry.SetMetric != nil && *entry.SetMetric < 0 {
+			return fmt.Errorf("route map %s seq %d: set metric must be >= 0", m.Name, entry.Seq)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func mustPrefix(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cidr, err)
+	}
+	return *n
+}
+
+func TestPrefixListGeLe(t *testing.T) {
+	list := PrefixList{Name: "lan", Entries: []PrefixListEntry{
+		{Seq: 5, Action: ActionDeny, Prefix: mustPrefix(t, "10.0.0.0/8")},
+		{Seq: 10, Action: ActionPermit, Prefix: mustPrefix(t, "10.0.0.0/8"), GE: 16, LE: 24},
+		{Seq: 20, Action: ActionPermit, Prefix: mustPrefix(t, "2001:db8::/32"), LE: 48},
+	}}
+	cases := map[string]bool{
+		"10.0.0.0/8":      false,
+		"10.1.0.0/16":     true,
+		"10.1.2.0/24":     true,
+		"10.1.2.128/25":   false,
+		"192.168.0.0/16":  false,
+		"2001:db8::/32":   true,
+		"2001:db8:1::/48": true,
+		"2001:db8:1::/64": false,
+		"0.0.0.0/0":       false,
+	}
+	for cidr, want := range cases {
+		if got := list.Permits(mustPrefix(t, cidr)); got != want {
+			t.Fatalf("%s: expected %v, got %v", cidr, want, got)
+		}
+	}
+}
+
+func TestRouteMapApply(t *testing.T) {
+	filters := NewRouteFilters()
+	if err := filters.SetRouteMap(RouteMap{Name: "bad", Entries: []RouteMapEntry{{Seq: 10, Action: ActionPermit, MatchPrefixList: "missing"}}}); !errors.Is(err, ErrPrefixListNotFound) {
+		t.Fatalf("expected missing prefix list error, got %v", err)
+	}
+	if err := filters.SetPrefixList(PrefixList{Name: "default", Entries: []PrefixListEntry{
+		{Seq: 10, Action: ActionPermit, Prefix: mustPrefix(t, "0.0.0.0/0")},
+	}}); err != nil {
+		t.Fatalf("set prefix list: %v", err)
+	}
+	metric, tag, hundred := 50, uint32(65000), 100
+	err := filters.SetRouteMap(RouteMap{Name: "import", Entries: []RouteMapEntry{
+		{Seq: 30, Action: ActionPermit, MatchSource: SourceP2P, SetMetric: &metric, SetTag: &tag},
+		{Seq: 10, Action: ActionDeny, MatchPrefixList: "default"},
+		{Seq: 20, Action: ActionDeny, MatchMetric: &hundred},
+	}})
+	if err != nil {
+		t.Fatalf("set route map: %v", err)
+	}
+
+	res := filters.Apply("import", Route{Destination: mustPrefix(t, "0.0.0.0/0"), Source: SourceP2P})
+	if res.Permit || res.Seq != 10 || len(res.Trace) != 1 {
+		t.Fatalf("expected default route denied by seq 10, got %+v", res)
+	}
+	res = filters.Apply("import", Route{Destination: mustPrefix(t, "10.1.0.0/16"), Source: SourceP2P, Metric: 100})
+	if res.Permit || res.Seq != 20 {
+		t.Fatalf("expected metric 100 denied by seq 20, got %+v", res)
+	}
+	res = filters.Apply("import", Route{Destination: mustPrefix(t, "10.1.0.0/16"), Source: SourceP2P, Metric: 7})
+	if !res.Permit || res.Seq != 30 || res.Route.Metric != 50 || res.Route.Tag != 65000 || len(res.Trace) != 3 {
+		t.Fatalf("expected seq 30 to rewrite route, got %+v", res)
+	}
+	res = filters.Apply("import", Route{Destination: mustPrefix(t, "10.1.0.0/16"), Source: SourceStatic})
+	if res.Permit || res.Seq != 0 || res.Trace[len(res.Trace)-1].Reason != "no entry matched" {
+		t.Fatalf("expected implicit deny, got %+v", res)
+	}
+	if res := filters.Apply("missing", Route{}); res.Permit {
+		t.Fatalf("expected missing route map to deny")
+	}
+	if res := filters.Apply("", Route{Metric: 3}); !res.Permit || res.Route.Metric != 3 {
+		t.Fatalf("expected empty map name to permit")
+	}
+
+	if err := filters.DeletePrefixList("default"); !errors.Is(err, ErrPrefixListInUse) {
+		t.Fatalf("expected prefix list in use, got %v", err)
+	}
+	if !filters.DeleteRouteMap("import") || filters.DeletePrefixList("default") != nil {
+		t.Fatalf("expected route map and prefix list to be deleted")
+	}
+}
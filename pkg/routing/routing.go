@@ -3,102 +3,245 @@ package routing
 import (
 	"net"
 	"sync"
+	"sync/atomic"
+
+	"router-go/pkg/network"
+)
+
+const (
+	SourceConnected = "connected"
+	SourceStatic    = "static"
+	SourceAPI       = "api"
+	SourceP2P       = "p2p"
 )
 
+var defaultDistances = map[string]int{
+	SourceConnected: 0,
+	SourceStatic:    1,
+	SourceAPI:       1,
+	SourceP2P:       150,
+}
+
+// DefaultDistance returns the administrative distance used for routes from
+// source when none is configured explicitly.
+func DefaultDistance(source string) int {
+	return defaultDistances[source]
+}
+
 type Route struct {
 	Destination net.IPNet
 	Gateway     net.IP
 	Interface   string
 	Metric      int
+	Distance    int
+	Source      string
+	Tag         uint32
+	NextHops    []NextHop
+	TargetVRF   string
+	paths       *pathSet
+}
+
+// RIBEntry is a candidate route together with whether it is the one
+// currently used for forwarding its prefix.
+type RIBEntry struct {
+	Route
+	Selected bool
 }
 
 type Table struct {
-	mu     sync.RWMutex
-	routes []Route
-	sorted []Route
+	mu     sync.Mutex
+	fib    atomic.Pointer[fib]
+	health *hopHealth
+	watch  watchState
+}
+
+type hopHealth struct {
+	mu    sync.Mutex
+	state atomic.Pointer[pathState]
 }
 
 func NewTable(routes []Route) *Table {
-	table := &Table{routes: routes}
-	table.rebuildSorted()
+	return newTableWithHealth(routes, &hopHealth{})
+}
+
+func (t *Table) NewSibling(routes []Route) *Table {
+	return newTableWithHealth(routes, t.health)
+}
+
+func newTableWithHealth(routes []Route, health *hopHealth) *Table {
+	table := &Table{health: health}
+	table.fib.Store(buildFIB(routes, nil))
 	return table
 }
 
 func (t *Table) Add(route Route) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.routes = append(t.routes, route)
-	t.rebuildSorted()
+	next := t.snapshot()
+	if next.insert(route, true, nil) {
+		t.fib.Store(&next)
+		t.publish(RouteEvent{Type: EventAdd, Route: route})
+	}
 }
 
 func (t *Table) Routes() []Route {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	out := make([]Route, 0, len(t.routes))
-	out = append(out, t.routes...)
-	return out
+	return t.fib.Load().routes()
+}
+
+func (t *Table) RIB() []RIBEntry {
+	return t.fib.Load().rib(t.pathState())
 }
 
 func (t *Table) Lookup(dst net.IP) (Route, bool) {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
-	for _, route := range t.sorted {
-		if route.Destination.Contains(dst) {
-			return route, true
+	route, _, ok := t.fib.Load().lookup(dst, t.pathState(), 0)
+	return route, ok
+}
+
+func (t *Table) LookupFlow(meta network.PacketMetadata) (Route, NextHop, bool) {
+	route, hop, ok := t.fib.Load().lookup(meta.DstIP, t.pathState(), FlowHash(meta))
+	if !ok {
+		return Route{}, NextHop{}, false
+	}
+	route.paths.packets[hop].Add(1)
+	return route, route.paths.hops[hop], true
+}
+
+func (t *Table) PathStats(route Route) []NextHopStats {
+	if route.paths == nil {
+		return nil
+	}
+	state := t.pathState()
+	out := make([]NextHopStats, 0, len(route.paths.hops))
+	for i, hop := range route.paths.hops {
+		out = append(out, NextHopStats{
+			NextHop: hop,
+			Up:      state.hopUp(hop),
+			Packets: route.paths.packets[i].Load(),
+		})
+	}
+	return out
+}
+
+func (t *Table) SetInterfaceUp(name string, up bool) bool {
+	return t.updateHealth(func(state *pathState) map[string]struct{} {
+		return state.downInterfaces
+	}, name, up)
+}
+
+func (t *Table) SetNextHopUp(hop NextHop, up bool) bool {
+	return t.updateHealth(func(state *pathState) map[string]struct{} {
+		return state.downHops
+	}, hopKey(hop), up)
+}
+
+func (t *Table) updateHealth(set func(*pathState) map[string]struct{}, key string, up bool) bool {
+	if t.health == nil {
+		return false
+	}
+	t.health.mu.Lock()
+	defer t.health.mu.Unlock()
+	current := t.health.state.Load()
+	if current != nil {
+		if _, down := set(current)[key]; down != up {
+			return false
 		}
+	} else if up {
+		return false
+	}
+	next := current.clone()
+	if up {
+		delete(set(next), key)
+	} else {
+		set(next)[key] = struct{}{}
+	}
+	t.health.state.Store(next)
+	return true
+}
+
+func (t *Table) pathState() *pathState {
+	if t.health == nil {
+		return nil
 	}
-	return Route{}, false
+	return t.health.state.Load()
 }
 
 func (t *Table) ReplaceRoutes(routes []Route) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.routes = routes
-	t.rebuildSorted()
+	current := t.fib.Load()
+	before := current.routes()
+	next := buildFIB(routes, current)
+	t.fib.Store(next)
+	t.publish(diffRoutes(before, next.routes())...)
 }
 
 func (t *Table) RemoveRoute(match Route) bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	for i, route := range t.routes {
-		if routesEqual(route, match) {
-			t.routes = append(t.routes[:i], t.routes[i+1:]...)
-			t.rebuildSorted()
-			return true
-		}
+	next := t.snapshot()
+	if !next.remove(match) {
+		return false
 	}
-	return false
+	t.fib.Store(&next)
+	t.publish(RouteEvent{Type: EventRemove, Route: match})
+	return true
 }
 
 func (t *Table) UpdateRoute(old Route, updated Route) bool {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	for i, route := range t.routes {
-		if routesEqual(route, old) {
-			t.routes[i] = updated
-			t.rebuildSorted()
-			return true
-		}
+	if _, ok := routePrefix(updated); !ok {
+		return false
+	}
+	current := t.fib.Load()
+	next := t.snapshot()
+	if !next.remove(old) {
+		return false
 	}
-	return false
+	next.insert(updated, true, current)
+	t.fib.Store(&next)
+	t.publish(RouteEvent{Type: EventUpdate, Route: updated, Previous: &old})
+	return true
 }
 
-func (t *Table) rebuildSorted() {
-	t.sorted = make([]Route, 0, len(t.routes))
-	t.sorted = append(t.sorted, t.routes...)
-	for i := 0; i < len(t.sorted)-1; i++ {
-		for j := i + 1; j < len(t.sorted); j++ {
-			ai, _ := t.sorted[i].Destination.Mask.Size()
-			aj, _ := t.sorted[j].Destination.Mask.Size()
-			if aj > ai || (aj == ai && t.sorted[j].Metric < t.sorted[i].Metric) {
-				t.sorted[i], t.sorted[j] = t.sorted[j], t.sorted[i]
-			}
-		}
+func (t *Table) snapshot() fib {
+	if current := t.fib.Load(); current != nil {
+		return *current
+	}
+	return fib{}
+}
+
+// buildFIB indexes routes; routes that prev already has with the same next
+// hops keep their per-hop counters.
+func buildFIB(routes []Route, prev *fib) *fib {
+	out := &fib{}
+	for _, route := range routes {
+		out.insert(route, false, prev)
+	}
+	return out
+}
+
+// Configured reports whether the route comes from configuration or the API
+// rather than from a routing protocol or an attached interface.
+func (r Route) Configured() bool {
+	return r.Source == "" || r.Source == SourceStatic || r.Source == SourceAPI
+}
+
+// Matches reports whether r is the route described by want. An empty Source
+// and a zero Distance in want match any value.
+func (r Route) Matches(want Route) bool {
+	if want.Source != "" && want.Source != r.Source {
+		return false
+	}
+	if want.Distance != 0 && want.Distance != r.Distance {
+		return false
 	}
+	want.Source, want.Distance = r.Source, r.Distance
+	return routesEqual(r, want)
 }
 
 func routesEqual(a Route, b Route) bool {
-	if a.Interface != b.Interface || a.Metric != b.Metric {
+	if a.Interface != b.Interface || a.Metric != b.Metric || a.Distance != b.Distance || a.Source != b.Source || a.Tag != b.Tag || a.TargetVRF != b.TargetVRF {
 		return false
 	}
 	if !ipNetEqual(a.Destination, b.Destination) {
@@ -107,7 +250,7 @@ func routesEqual(a Route, b Route) bool {
 	if !ipEqual(a.Gateway, b.Gateway) {
 		return false
 	}
-	return true
+	return nextHopsEqual(a.NextHops, b.NextHops)
 }
 
 func ipNetEqual(a net.IPNet, b net.IPNet) bool {
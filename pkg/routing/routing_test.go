@@ -142,4 +142,63 @@ func TestUpdateRoute(t *testing.T) {
 	if table.UpdateRoute(Route{Destination: *aNet, Interface: "eth0"}, Route{Destination: *aNet}) {
 		t.Fatalf("expected update to fail for missing route")
 	}
+	revision := table.Revision()
+	current := routes[0]
+	if table.UpdateRoute(current, Route{Interface: "eth2"}) {
+		t.Fatalf("expected update to fail for invalid destination")
+	}
+	if routes := table.Routes(); len(routes) != 1 || routes[0].Interface != "eth1" {
+		t.Fatalf("expected failed update to keep the route, got %+v", routes)
+	}
+	if table.Revision() != revision {
+		t.Fatalf("expected failed update not to publish an event")
+	}
+}
+
+func TestRIBSelectsLowestDistance(t *testing.T) {
+	_, aNet, _ := net.ParseCIDR("10.1.0.0/24")
+	table := NewTable([]Route{
+		{Destination: *aNet, Interface: "eth2", Distance: 110, Source: "ospf", Gateway: net.ParseIP("192.0.2.9")},
+		{Destination: *aNet, Interface: "eth1", Metric: 50, Distance: DefaultDistance(SourceStatic), Source: SourceStatic, Gateway: net.ParseIP("192.0.2.1")},
+		{Destination: *aNet, Interface: "eth0", Distance: DefaultDistance(SourceConnected), Source: SourceConnected},
+	})
+
+	route, ok := table.Lookup(net.ParseIP("10.1.0.7"))
+	if !ok || route.Source != SourceConnected {
+		t.Fatalf("expected connected route, got %+v", route)
+	}
+	rib := table.RIB()
+	if len(rib) != 3 || !rib[0].Selected || rib[1].Selected || rib[2].Selected {
+		t.Fatalf("unexpected rib: %+v", rib)
+	}
+	if rib[1].Source != SourceStatic || rib[2].Source != "ospf" {
+		t.Fatalf("expected candidates ordered by distance, got %+v", rib)
+	}
+
+	table.SetInterfaceUp("eth0", false)
+	route, _ = table.Lookup(net.ParseIP("10.1.0.7"))
+	if route.Source != SourceStatic {
+		t.Fatalf("expected static route after interface down, got %+v", route)
+	}
+	rib = table.RIB()
+	if rib[0].Selected || !rib[1].Selected {
+		t.Fatalf("expected static route selected, got %+v", rib)
+	}
+}
+
+func TestRouteMatchesIgnoresUnsetSourceAndDistance(t *testing.T) {
+	_, aNet, _ := net.ParseCIDR("10.1.0.0/24")
+	route := Route{Destination: *aNet, Interface: "eth1", Metric: 5, Distance: 1, Source: SourceAPI}
+	if !route.Matches(Route{Destination: *aNet, Interface: "eth1", Metric: 5}) {
+		t.Fatalf("expected match without source and distance")
+	}
+	if route.Matches(Route{Destination: *aNet, Interface: "eth1", Metric: 5, Distance: 20}) {
+		t.Fatalf("expected distance mismatch")
+	}
+	if route.Matches(Route{Destination: *aNet, Interface: "eth1", Metric: 5, Source: SourceStatic}) {
+		t.Fatalf("expected source mismatch")
+	}
+	if !route.Configured() || (Route{Source: SourceConnected}).Configured() {
+		t.Fatalf("unexpected configured flags")
+	}
 }
@@ -0,0 +1,448 @@
+package routing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TrackICMP = "icmp"
+	TrackTCP  = "tcp"
+	TrackHTTP = "http"
+	TrackBFD  = "bfd"
+)
+
+type TrackProbe struct {
+	Type          string
+	Target        string
+	Interval      time.Duration
+	Timeout       time.Duration
+	FailThreshold int
+	RiseThreshold int
+}
+
+type TrackEvent struct {
+	Route  Route
+	Type   string
+	Target string
+	Up     bool
+	Err    string
+}
+
+type TrackStatus struct {
+	Route       Route
+	Type        string
+	Target      string
+	Up          bool
+	Failures    int
+	Successes   int
+	Transitions int
+	LastError   string
+	LastCheck   time.Time
+}
+
+type trackProbeFunc func(ctx context.Context, target string, iface string, timeout time.Duration) error
+
+type trackEntry struct {
+	route       Route
+	probe       TrackProbe
+	target      string
+	iface       string
+	up          bool
+	failures    int
+	successes   int
+	transitions int
+	lastError   string
+	lastCheck   time.Time
+}
+
+type Tracker struct {
+	table    *Table
+	mu       sync.Mutex
+	entries  []*trackEntry
+	probes   map[string]trackProbeFunc
+	onChange func(TrackEvent)
+}
+
+func NewTracker(table *Table) *Tracker {
+	return &Tracker{
+		table: table,
+		probes: map[string]trackProbeFunc{
+			TrackICMP: icmpProbe,
+			TrackTCP:  tcpTrackProbe,
+			TrackHTTP: httpProbe,
+		},
+	}
+}
+
+func (t *Tracker) SetOnChange(fn func(TrackEvent)) {
+	t.mu.Lock()
+	t.onChange = fn
+	t.mu.Unlock()
+}
+
+func (t *Tracker) Add(route Route, probe TrackProbe) error {
+	probe.Type = strings.ToLower(strings.TrimSpace(probe.Type))
+	if probe.Type == "" {
+		probe.Type = TrackICMP
+	}
+	if _, ok := t.probes[probe.Type]; !ok && probe.Type != TrackBFD {
+		return fmt.Errorf("unknown track type %q", probe.Type)
+	}
+	if probe.Interval <= 0 {
+		probe.Interval = 5 * time.Second
+	}
+	if probe.Timeout <= 0 {
+		probe.Timeout = 2 * time.Second
+	}
+	if probe.FailThreshold <= 0 {
+		probe.FailThreshold = 3
+	}
+	if probe.RiseThreshold <= 0 {
+		probe.RiseThreshold = 2
+	}
+	target, err := trackTarget(route, probe)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, &trackEntry{
+		route:  route,
+		probe:  probe,
+		target: target,
+		iface:  trackInterface(route),
+		up:     true,
+	})
+	return nil
+}
+
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+func (t *Tracker) Status() []TrackStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]TrackStatus, 0, len(t.entries))
+	for _, entry := range t.entries {
+		out = append(out, TrackStatus{
+			Route:       entry.route,
+			Type:        entry.probe.Type,
+			Target:      entry.target,
+			Up:          entry.up,
+			Failures:    entry.failures,
+			Successes:   entry.successes,
+			Transitions: entry.transitions,
+			LastError:   entry.lastError,
+			LastCheck:   entry.lastCheck,
+		})
+	}
+	return out
+}
+
+func (t *Tracker) Run(ctx context.Context) {
+	t.mu.Lock()
+	entries := append([]*trackEntry(nil), t.entries...)
+	t.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		if entry.probe.Type == TrackBFD {
+			continue
+		}
+		wg.Add(1)
+		go func(entry *trackEntry) {
+			defer wg.Done()
+			ticker := time.NewTicker(entry.probe.Interval)
+			defer ticker.Stop()
+			t.checkEntry(ctx, entry)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					t.checkEntry(ctx, entry)
+				}
+			}
+		}(entry)
+	}
+	wg.Wait()
+}
+
+func (t *Tracker) Check(ctx context.Context) {
+	t.mu.Lock()
+	entries := append([]*trackEntry(nil), t.entries...)
+	t.mu.Unlock()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		if entry.probe.Type == TrackBFD {
+			continue
+		}
+		t.checkEntry(ctx, entry)
+	}
+}
+
+func (t *Tracker) checkEntry(ctx context.Context, entry *trackEntry) {
+	err := t.probes[entry.probe.Type](ctx, entry.target, entry.iface, entry.probe.Timeout)
+	if ctx.Err() != nil {
+		return
+	}
+
+	t.mu.Lock()
+	entry.lastCheck = time.Now()
+	changed := false
+	if err != nil {
+		entry.lastError = err.Error()
+		entry.successes = 0
+		entry.failures++
+		if entry.up && entry.failures >= entry.probe.FailThreshold {
+			entry.up = false
+			changed = true
+		}
+	} else {
+		entry.lastError = ""
+		entry.failures = 0
+		entry.successes++
+		if !entry.up && entry.successes >= entry.probe.RiseThreshold {
+			entry.up = true
+			changed = true
+		}
+	}
+	if !changed {
+		if !entry.up {
+			t.table.RemoveRoute(entry.route)
+		}
+		t.mu.Unlock()
+		return
+	}
+	entry.transitions++
+	if entry.up {
+		t.table.Add(entry.route)
+	} else {
+		t.table.RemoveRoute(entry.route)
+	}
+	event := TrackEvent{
+		Route:  entry.route,
+		Type:   entry.probe.Type,
+		Target: entry.target,
+		Up:     entry.up,
+		Err:    entry.lastError,
+	}
+	onChange := t.onChange
+	t.mu.Unlock()
+	if onChange != nil {
+		onChange(event)
+	}
+}
+
+// SetBFDState applies a BFD session transition to every route tracked over
+// peer. BFD does its own failure detection, so thresholds are not applied.
+func (t *Tracker) SetBFDState(peer string, up bool, reason string) {
+	ip := net.ParseIP(peer)
+	t.mu.Lock()
+	var events []TrackEvent
+	for _, entry := range t.entries {
+		if entry.probe.Type != TrackBFD || !ip.Equal(net.ParseIP(entry.target)) {
+			continue
+		}
+		entry.lastCheck = time.Now()
+		if up {
+			entry.lastError = ""
+			entry.failures, entry.successes = 0, 1
+		} else {
+			entry.lastError = reason
+			entry.failures, entry.successes = 1, 0
+		}
+		if entry.up == up {
+			continue
+		}
+		entry.up = up
+		entry.transitions++
+		if up {
+			t.table.Add(entry.route)
+		} else {
+			t.table.RemoveRoute(entry.route)
+		}
+		events = append(events, TrackEvent{
+			Route:  entry.route,
+			Type:   entry.probe.Type,
+			Target: entry.target,
+			Up:     up,
+			Err:    entry.lastError,
+		})
+	}
+	onChange := t.onChange
+	t.mu.Unlock()
+	if onChange == nil {
+		return
+	}
+	for _, ev := range events {
+		onChange(ev)
+	}
+}
+
+func trackTarget(route Route, probe TrackProbe) (string, error) {
+	target := strings.TrimSpace(probe.Target)
+	gateway := trackGateway(route)
+	switch probe.Type {
+	case TrackICMP, TrackBFD:
+		if target == "" {
+			if gateway == nil {
+				return "", fmt.Errorf("%s track requires a target or gateway", probe.Type)
+			}
+			return gateway.String(), nil
+		}
+		if net.ParseIP(target) == nil {
+			return "", fmt.Errorf("invalid %s track target %q", probe.Type, target)
+		}
+		return target, nil
+	case TrackTCP:
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return "", fmt.Errorf("invalid tcp track target %q", target)
+		}
+		if host == "" {
+			if gateway == nil {
+				return "", errors.New("tcp track target without host requires a gateway")
+			}
+			host = gateway.String()
+		}
+		return net.JoinHostPort(host, port), nil
+	default:
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return "", fmt.Errorf("invalid http track target %q", target)
+		}
+		return target, nil
+	}
+}
+
+func trackGateway(route Route) net.IP {
+	for _, hop := range route.Paths() {
+		if hop.Gateway != nil {
+			return hop.Gateway
+		}
+	}
+	return nil
+}
+
+func trackInterface(route Route) string {
+	for _, hop := range route.Paths() {
+		if hop.Interface != "" {
+			return hop.Interface
+		}
+	}
+	return ""
+}
+
+func tcpTrackProbe(ctx context.Context, target string, iface string, timeout time.Duration) error {
+	dialer := net.Dialer{Timeout: timeout, Control: bindToDevice(iface)}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return err
+	}
+	_ = conn.Close()
+	return nil
+}
+
+func httpProbe(ctx context.Context, target string, iface string, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout, Control: bindToDevice(iface)}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, DisableKeepAlives: true},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("http status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func icmpProbe(ctx context.Context, target string, iface string, timeout time.Duration) error {
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return fmt.Errorf("invalid icmp target %q", target)
+	}
+	network, echoType, replyType := "ip4:icmp", byte(8), byte(0)
+	if ip.To4() == nil {
+		network, echoType, replyType = "ip6:ipv6-icmp", 128, 129
+	}
+	dialer := net.Dialer{Timeout: timeout, Control: bindToDevice(iface)}
+	conn, err := dialer.DialContext(ctx, network, ip.String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	id := uint16(os.Getpid())
+	seq := uint16(time.Now().UnixNano())
+	msg := make([]byte, 16)
+	msg[0] = echoType
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], "routergo")
+	if echoType == 8 {
+		binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	ipConn, ok := conn.(*net.IPConn)
+	if !ok {
+		return errors.New("icmp: unexpected connection type")
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := ipConn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if n < 8 || buf[0] != replyType {
+			continue
+		}
+		if echoType == 8 && binary.BigEndian.Uint16(buf[4:]) != id {
+			continue
+		}
+		if binary.BigEndian.Uint16(buf[6:]) == seq {
+			return nil
+		}
+	}
+}
+
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,130 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTrackerFailoverAndRestore(t *testing.T) {
+	_, defNet, _ := net.ParseCIDR("0.0.0.0/0")
+	primary := Route{Destination: *defNet, Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Metric: 10}
+	backup := Route{Destination: *defNet, Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2", Metric: 100}
+	table := NewTable([]Route{primary, backup})
+
+	tracker := NewTracker(table)
+	healthy := true
+	var probed []string
+	tracker.probes[TrackTCP] = func(ctx context.Context, target string, iface string, timeout time.Duration) error {
+		probed = append(probed, target+"@"+iface)
+		if healthy {
+			return nil
+		}
+		return errors.New("connection refused")
+	}
+	var events []TrackEvent
+	tracker.SetOnChange(func(ev TrackEvent) {
+		events = append(events, ev)
+	})
+	if err := tracker.Add(primary, TrackProbe{Type: "tcp", Target: ":443", FailThreshold: 2, RiseThreshold: 2}); err != nil {
+		t.Fatalf("add track: %v", err)
+	}
+
+	ctx := context.Background()
+	dst := net.ParseIP("8.8.8.8")
+	tracker.Check(ctx)
+	if probed[0] != "192.0.2.1:443@wan1" {
+		t.Fatalf("expected probe via gateway on wan1, got %s", probed[0])
+	}
+
+	healthy = false
+	tracker.Check(ctx)
+	if route, ok := table.Lookup(dst); !ok || route.Interface != "wan1" {
+		t.Fatalf("route must stay until fail threshold, got %+v", route)
+	}
+	tracker.Check(ctx)
+	if route, ok := table.Lookup(dst); !ok || route.Interface != "wan2" {
+		t.Fatalf("expected failover to backup, got %+v ok=%v", route, ok)
+	}
+	if len(events) != 1 || events[0].Up || events[0].Err == "" {
+		t.Fatalf("expected one down event, got %+v", events)
+	}
+
+	healthy = true
+	tracker.Check(ctx)
+	if route, _ := table.Lookup(dst); route.Interface != "wan2" {
+		t.Fatalf("route must stay withdrawn until rise threshold")
+	}
+	tracker.Check(ctx)
+	if route, _ := table.Lookup(dst); route.Interface != "wan1" {
+		t.Fatalf("expected primary restored, got %+v", route)
+	}
+	if len(events) != 2 || !events[1].Up {
+		t.Fatalf("expected up event, got %+v", events)
+	}
+	status := tracker.Status()
+	if len(status) != 1 || !status[0].Up || status[0].Transitions != 2 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
+
+func TestTrackerBFDState(t *testing.T) {
+	_, defNet, _ := net.ParseCIDR("0.0.0.0/0")
+	primary := Route{Destination: *defNet, Gateway: net.ParseIP("192.0.2.1"), Interface: "wan1", Metric: 10}
+	backup := Route{Destination: *defNet, Gateway: net.ParseIP("198.51.100.1"), Interface: "wan2", Metric: 100}
+	table := NewTable([]Route{primary, backup})
+	tracker := NewTracker(table)
+	var events []TrackEvent
+	tracker.SetOnChange(func(ev TrackEvent) {
+		events = append(events, ev)
+	})
+	if err := tracker.Add(primary, TrackProbe{Type: TrackBFD}); err != nil {
+		t.Fatalf("add track: %v", err)
+	}
+	tracker.Check(context.Background())
+
+	dst := net.ParseIP("8.8.8.8")
+	tracker.SetBFDState("198.51.100.1", false, "other peer")
+	if route, _ := table.Lookup(dst); route.Interface != "wan1" {
+		t.Fatalf("unrelated bfd peer must not affect the route, got %+v", route)
+	}
+	tracker.SetBFDState("192.0.2.1", false, "control_detection_time_expired")
+	if route, _ := table.Lookup(dst); route.Interface != "wan2" {
+		t.Fatalf("expected immediate failover on bfd down, got %+v", route)
+	}
+	tracker.SetBFDState("192.0.2.1", true, "")
+	if route, _ := table.Lookup(dst); route.Interface != "wan1" {
+		t.Fatalf("expected primary restored on bfd up, got %+v", route)
+	}
+	if len(events) != 2 || events[0].Up || events[0].Type != TrackBFD || events[0].Err != "control_detection_time_expired" || !events[1].Up {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+}
+
+func TestTrackerTargetValidation(t *testing.T) {
+	_, dst, _ := net.ParseCIDR("10.0.0.0/8")
+	tracker := NewTracker(NewTable(nil))
+	if err := tracker.Add(Route{Destination: *dst, Interface: "wan1"}, TrackProbe{Type: "icmp"}); err == nil {
+		t.Fatalf("expected error for icmp track without target or gateway")
+	}
+	if err := tracker.Add(Route{Destination: *dst}, TrackProbe{Type: "http", Target: "example.com"}); err == nil {
+		t.Fatalf("expected error for http track without url")
+	}
+	if err := tracker.Add(Route{Destination: *dst}, TrackProbe{Type: "dns"}); err == nil {
+		t.Fatalf("expected error for unknown track type")
+	}
+	if err := tracker.Add(Route{Destination: *dst}, TrackProbe{Type: "icmp", Target: "203.0.113.1"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestICMPChecksum(t *testing.T) {
+	msg := []byte{8, 0, 0, 0, 0x12, 0x34, 0x00, 0x01}
+	sum := icmpChecksum(msg)
+	msg[2], msg[3] = byte(sum>>8), byte(sum)
+	if icmpChecksum(msg) != 0 {
+		t.Fatalf("checksum does not verify")
+	}
+}
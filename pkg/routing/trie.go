@@ -0,0 +1,350 @@
+package routing
+
+import "net"
+
+type trieNode struct {
+	key    [16]byte
+	bits   int
+	child  [2]*trieNode
+	routes []Route
+}
+
+type fib struct {
+	v4   *trieNode
+	v6   *trieNode
+	size int
+}
+
+type prefixKey struct {
+	key  [16]byte
+	bits int
+	v6   bool
+}
+
+func routePrefix(route Route) (prefixKey, bool) {
+	ones, size := route.Destination.Mask.Size()
+	var out prefixKey
+	switch size {
+	case 32:
+		ip4 := route.Destination.IP.To4()
+		if ip4 == nil {
+			return out, false
+		}
+		copy(out.key[:], ip4)
+	case 128:
+		ip16 := route.Destination.IP.To16()
+		if ip16 == nil {
+			return out, false
+		}
+		copy(out.key[:], ip16)
+		out.v6 = true
+	default:
+		return out, false
+	}
+	out.bits = ones
+	maskKey(&out.key, ones)
+	return out, true
+}
+
+func lookupKey(ip net.IP) ([16]byte, int, bool) {
+	var key [16]byte
+	if ip4 := ip.To4(); ip4 != nil {
+		copy(key[:], ip4)
+		return key, 32, false
+	}
+	if len(ip) == net.IPv6len {
+		copy(key[:], ip)
+		return key, 128, true
+	}
+	return key, 0, false
+}
+
+func (f *fib) root(v6 bool) *trieNode {
+	if v6 {
+		return f.v6
+	}
+	return f.v4
+}
+
+func (f *fib) setRoot(v6 bool, n *trieNode) {
+	if v6 {
+		f.v6 = n
+	} else {
+		f.v4 = n
+	}
+}
+
+// insert adds route, reusing the path set of a matching route in prev so
+// the per-hop counters carry over.
+func (f *fib) insert(route Route, cow bool, prev *fib) bool {
+	p, ok := routePrefix(route)
+	if !ok {
+		return false
+	}
+	route.paths = prev.pathsAt(p, route)
+	if route.paths == nil {
+		route.paths = newPathSet(route)
+	}
+	f.setRoot(p.v6, trieInsert(f.root(p.v6), p, route, cow))
+	f.size++
+	return true
+}
+
+// pathsAt returns the path set of the route for p with the same source and
+// next hops as route, or nil.
+func (f *fib) pathsAt(p prefixKey, route Route) *pathSet {
+	if f == nil {
+		return nil
+	}
+	n := f.root(p.v6)
+	for n != nil && n.bits <= p.bits && prefixMatch(n.key, p.key, n.bits) {
+		if n.bits == p.bits {
+			hops := route.Paths()
+			for _, existing := range n.routes {
+				if existing.Source == route.Source && nextHopsEqual(existing.paths.hops, hops) {
+					return existing.paths
+				}
+			}
+			return nil
+		}
+		n = n.child[bitAt(p.key, n.bits)]
+	}
+	return nil
+}
+
+func (f *fib) remove(match Route) bool {
+	p, ok := routePrefix(match)
+	if !ok {
+		return false
+	}
+	root, removed := trieRemove(f.root(p.v6), p, match)
+	if !removed {
+		return false
+	}
+	f.setRoot(p.v6, root)
+	f.size--
+	return true
+}
+
+func (f *fib) routes() []Route {
+	if f == nil {
+		return []Route{}
+	}
+	out := make([]Route, 0, f.size)
+	out = walkRoutes(f.v4, out)
+	return walkRoutes(f.v6, out)
+}
+
+func walkRoutes(n *trieNode, out []Route) []Route {
+	if n == nil {
+		return out
+	}
+	out = append(out, n.routes...)
+	out = walkRoutes(n.child[0], out)
+	return walkRoutes(n.child[1], out)
+}
+
+func (f *fib) rib(state *pathState) []RIBEntry {
+	if f == nil {
+		return []RIBEntry{}
+	}
+	out := make([]RIBEntry, 0, f.size)
+	out = walkRIB(f.v4, state, out)
+	return walkRIB(f.v6, state, out)
+}
+
+func walkRIB(n *trieNode, state *pathState, out []RIBEntry) []RIBEntry {
+	if n == nil {
+		return out
+	}
+	selected := false
+	for _, route := range n.routes {
+		entry := RIBEntry{Route: route}
+		if !selected && route.paths.selectHop(state, 0) >= 0 {
+			entry.Selected = true
+			selected = true
+		}
+		out = append(out, entry)
+	}
+	out = walkRIB(n.child[0], state, out)
+	return walkRIB(n.child[1], state, out)
+}
+
+func (f *fib) lookup(ip net.IP, state *pathState, hash uint64) (Route, int, bool) {
+	if f == nil || ip == nil {
+		return Route{}, -1, false
+	}
+	key, maxBits, v6 := lookupKey(ip)
+	if maxBits == 0 {
+		return Route{}, -1, false
+	}
+	var matched [129]*trieNode
+	depth := 0
+	n := f.root(v6)
+	for n != nil {
+		if n.bits > maxBits || !prefixMatch(n.key, key, n.bits) {
+			break
+		}
+		if len(n.routes) > 0 {
+			matched[depth] = n
+			depth++
+		}
+		if n.bits == maxBits {
+			break
+		}
+		n = n.child[bitAt(key, n.bits)]
+	}
+	for i := depth - 1; i >= 0; i-- {
+		for _, route := range matched[i].routes {
+			if hop := route.paths.selectHop(state, hash); hop >= 0 {
+				return route, hop, true
+			}
+		}
+	}
+	return Route{}, -1, false
+}
+
+func trieInsert(n *trieNode, p prefixKey, route Route, cow bool) *trieNode {
+	if n == nil {
+		return &trieNode{key: p.key, bits: p.bits, routes: []Route{route}}
+	}
+	limit := n.bits
+	if p.bits < limit {
+		limit = p.bits
+	}
+	common := commonBits(n.key, p.key, limit)
+	switch {
+	case common == n.bits && common == p.bits:
+		c := cloneNode(n, cow)
+		c.routes = insertCandidate(c.routes, route)
+		return c
+	case common == n.bits:
+		c := cloneNode(n, cow)
+		b := bitAt(p.key, n.bits)
+		c.child[b] = trieInsert(n.child[b], p, route, cow)
+		return c
+	case common == p.bits:
+		leaf := &trieNode{key: p.key, bits: p.bits, routes: []Route{route}}
+		leaf.child[bitAt(n.key, p.bits)] = n
+		return leaf
+	default:
+		glue := &trieNode{key: p.key, bits: common}
+		maskKey(&glue.key, common)
+		glue.child[bitAt(p.key, common)] = &trieNode{key: p.key, bits: p.bits, routes: []Route{route}}
+		glue.child[bitAt(n.key, common)] = n
+		return glue
+	}
+}
+
+func trieRemove(n *trieNode, p prefixKey, match Route) (*trieNode, bool) {
+	if n == nil || n.bits > p.bits || !prefixMatch(n.key, p.key, n.bits) {
+		return n, false
+	}
+	if n.bits == p.bits {
+		for i, route := range n.routes {
+			if routesEqual(route, match) {
+				c := cloneNode(n, true)
+				c.routes = make([]Route, 0, len(n.routes)-1)
+				c.routes = append(c.routes, n.routes[:i]...)
+				c.routes = append(c.routes, n.routes[i+1:]...)
+				return compactNode(c), true
+			}
+		}
+		return n, false
+	}
+	b := bitAt(p.key, n.bits)
+	child, ok := trieRemove(n.child[b], p, match)
+	if !ok {
+		return n, false
+	}
+	c := cloneNode(n, true)
+	c.child[b] = child
+	return compactNode(c), true
+}
+
+func compactNode(n *trieNode) *trieNode {
+	if len(n.routes) > 0 {
+		return n
+	}
+	switch {
+	case n.child[0] == nil && n.child[1] == nil:
+		return nil
+	case n.child[0] == nil:
+		return n.child[1]
+	case n.child[1] == nil:
+		return n.child[0]
+	default:
+		return n
+	}
+}
+
+func cloneNode(n *trieNode, cow bool) *trieNode {
+	if !cow {
+		return n
+	}
+	c := *n
+	return &c
+}
+
+func insertCandidate(routes []Route, route Route) []Route {
+	pos := len(routes)
+	for i, existing := range routes {
+		if betterRoute(route, existing) {
+			pos = i
+			break
+		}
+	}
+	out := make([]Route, 0, len(routes)+1)
+	out = append(out, routes[:pos]...)
+	out = append(out, route)
+	return append(out, routes[pos:]...)
+}
+
+func betterRoute(a Route, b Route) bool {
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+	return a.Metric < b.Metric
+}
+
+func bitAt(key [16]byte, pos int) int {
+	return int(key[pos/8]>>(7-uint(pos%8))) & 1
+}
+
+func prefixMatch(a [16]byte, b [16]byte, bits int) bool {
+	return commonBits(a, b, bits) == bits
+}
+
+func commonBits(a [16]byte, b [16]byte, limit int) int {
+	n := 0
+	for i := 0; i < 16 && n < limit; i++ {
+		x := a[i] ^ b[i]
+		if x == 0 {
+			n += 8
+			continue
+		}
+		for x&0x80 == 0 {
+			n++
+			x <<= 1
+		}
+		break
+	}
+	if n > limit {
+		return limit
+	}
+	return n
+}
+
+func maskKey(key *[16]byte, bits int) {
+	for i := range key {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		case bits > 0:
+			key[i] &= byte(0xff << uint(8-bits))
+			bits = 0
+		default:
+			key[i] = 0
+		}
+	}
+}
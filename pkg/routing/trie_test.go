@@ -0,0 +1,201 @@
+package routing
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+)
+
+func TestLookupIPv6LongestPrefix(t *testing.T) {
+	_, aNet, _ := net.ParseCIDR("2001:db8::/32")
+	_, bNet, _ := net.ParseCIDR("2001:db8:1::/48")
+	_, v4Net, _ := net.ParseCIDR("0.0.0.0/0")
+	table := NewTable([]Route{
+		{Destination: *aNet, Interface: "eth0"},
+		{Destination: *bNet, Interface: "eth1"},
+		{Destination: *v4Net, Interface: "eth2"},
+	})
+
+	route, ok := table.Lookup(net.ParseIP("2001:db8:1::10"))
+	if !ok || route.Interface != "eth1" {
+		t.Fatalf("expected eth1, got %+v (ok=%v)", route, ok)
+	}
+	route, ok = table.Lookup(net.ParseIP("2001:db8:2::10"))
+	if !ok || route.Interface != "eth0" {
+		t.Fatalf("expected eth0, got %+v (ok=%v)", route, ok)
+	}
+	if _, ok := table.Lookup(net.ParseIP("2001:db9::1")); ok {
+		t.Fatalf("expected ipv4 default route not to match ipv6 destination")
+	}
+}
+
+func TestRemoveRouteFallsBackToShorterPrefix(t *testing.T) {
+	_, aNet, _ := net.ParseCIDR("10.0.0.0/8")
+	_, bNet, _ := net.ParseCIDR("10.1.0.0/16")
+	table := NewTable([]Route{
+		{Destination: *aNet, Interface: "eth0"},
+		{Destination: *bNet, Interface: "eth1"},
+	})
+
+	if !table.RemoveRoute(Route{Destination: *bNet, Interface: "eth1"}) {
+		t.Fatalf("expected route to be removed")
+	}
+	route, ok := table.Lookup(net.ParseIP("10.1.2.3"))
+	if !ok || route.Interface != "eth0" {
+		t.Fatalf("expected fallback to eth0, got %+v (ok=%v)", route, ok)
+	}
+}
+
+func TestLookupHostRoute(t *testing.T) {
+	_, host, _ := net.ParseCIDR("192.0.2.1/32")
+	_, subnet, _ := net.ParseCIDR("192.0.2.0/24")
+	table := NewTable([]Route{
+		{Destination: *subnet, Interface: "eth0"},
+		{Destination: *host, Interface: "eth1"},
+	})
+	route, ok := table.Lookup(net.ParseIP("192.0.2.1"))
+	if !ok || route.Interface != "eth1" {
+		t.Fatalf("expected host route, got %+v", route)
+	}
+	route, ok = table.Lookup(net.ParseIP("192.0.2.2"))
+	if !ok || route.Interface != "eth0" {
+		t.Fatalf("expected subnet route, got %+v", route)
+	}
+}
+
+func TestSnapshotIsolation(t *testing.T) {
+	_, aNet, _ := net.ParseCIDR("10.0.0.0/8")
+	_, bNet, _ := net.ParseCIDR("10.1.0.0/16")
+	table := NewTable([]Route{{Destination: *aNet, Interface: "eth0"}})
+
+	before := table.fib.Load()
+	table.Add(Route{Destination: *bNet, Interface: "eth1"})
+
+	route, _, ok := before.lookup(net.ParseIP("10.1.2.3"), nil, 0)
+	if !ok || route.Interface != "eth0" {
+		t.Fatalf("expected old snapshot to be unchanged, got %+v", route)
+	}
+	route, ok = table.Lookup(net.ParseIP("10.1.2.3"))
+	if !ok || route.Interface != "eth1" {
+		t.Fatalf("expected new snapshot to see added route, got %+v", route)
+	}
+}
+
+func TestTrieMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	routes := randomRoutes(rng, 2000)
+	table := NewTable(nil)
+	for _, route := range routes {
+		table.Add(route)
+	}
+	for i := 0; i < 200; i++ {
+		table.RemoveRoute(routes[rng.Intn(len(routes))])
+	}
+	remaining := table.Routes()
+	for i := 0; i < 5000; i++ {
+		ip := randomIPv4(rng)
+		got, gotOK := table.Lookup(ip)
+		want, wantOK := linearLookup(remaining, ip)
+		if gotOK != wantOK {
+			t.Fatalf("lookup %s: got ok=%v, want ok=%v", ip, gotOK, wantOK)
+		}
+		if !gotOK {
+			continue
+		}
+		gotLen, _ := got.Destination.Mask.Size()
+		wantLen, _ := want.Destination.Mask.Size()
+		if gotLen != wantLen || got.Metric != want.Metric || !got.Destination.IP.Equal(want.Destination.IP) {
+			t.Fatalf("lookup %s: got %s metric %d, want %s metric %d", ip, got.Destination.String(), got.Metric, want.Destination.String(), want.Metric)
+		}
+	}
+}
+
+func BenchmarkLookup500k(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	table := NewTable(randomRoutes(rng, 500000))
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ips[i] = randomIPv4(rng)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		table.Lookup(ips[i&1023])
+	}
+}
+
+func BenchmarkLookup500kParallel(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	table := NewTable(randomRoutes(rng, 500000))
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ips[i] = randomIPv4(rng)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			table.Lookup(ips[i&1023])
+			i++
+		}
+	})
+}
+
+func BenchmarkBuild500k(b *testing.B) {
+	routes := randomRoutes(rand.New(rand.NewSource(1)), 500000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NewTable(routes)
+	}
+}
+
+func BenchmarkAddRemove500k(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	table := NewTable(randomRoutes(rng, 500000))
+	extra := randomRoutes(rng, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		route := extra[i&1023]
+		table.Add(route)
+		table.RemoveRoute(route)
+	}
+}
+
+func randomRoutes(rng *rand.Rand, n int) []Route {
+	routes := make([]Route, 0, n)
+	for i := 0; i < n; i++ {
+		bits := 8 + rng.Intn(25)
+		ip := randomIPv4(rng)
+		mask := net.CIDRMask(bits, 32)
+		routes = append(routes, Route{
+			Destination: net.IPNet{IP: ip.Mask(mask), Mask: mask},
+			Interface:   "eth0",
+			Metric:      rng.Intn(4),
+		})
+	}
+	return routes
+}
+
+func randomIPv4(rng *rand.Rand) net.IP {
+	v := rng.Uint32()
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).To4()
+}
+
+func linearLookup(routes []Route, ip net.IP) (Route, bool) {
+	var best Route
+	bestLen := -1
+	for _, route := range routes {
+		if !route.Destination.Contains(ip) {
+			continue
+		}
+		ones, _ := route.Destination.Mask.Size()
+		if ones > bestLen || (ones == bestLen && route.Metric < best.Metric) {
+			best = route
+			bestLen = ones
+		}
+	}
+	return best, bestLen >= 0
+}
@@ -0,0 +1,143 @@
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	EventAdd    = "add"
+	EventRemove = "remove"
+	EventUpdate = "update"
+
+	watchHistory = 1024
+	watchBuffer  = 256
+)
+
+var ErrRevisionCompacted = errors.New("route revision is no longer available")
+
+// RouteEvent describes one change to a table. Revisions increase by one per
+// event, so a watcher can resume from the last revision it has seen.
+type RouteEvent struct {
+	Revision uint64
+	Type     string
+	Route    Route
+	Previous *Route
+}
+
+// watchState keeps the last watchHistory events in a ring: history grows
+// until it is full and is then overwritten from head, the oldest event.
+type watchState struct {
+	revision uint64
+	history  []RouteEvent
+	head     int
+	watchers map[chan RouteEvent]struct{}
+}
+
+func (t *Table) Revision() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.watch.revision
+}
+
+// Watch replays the events after revision since and then streams new ones.
+// A revision that is no longer kept, or ahead of the table, returns
+// ErrRevisionCompacted.
+// The channel is closed by stop, or when the watcher falls too far behind; in
+// that case it should resume with the last revision it received.
+func (t *Table) Watch(since uint64) (<-chan RouteEvent, func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := &t.watch
+	if since > w.revision {
+		// A revision from before a restart; the client must resync.
+		return nil, nil, fmt.Errorf("%w: %d is ahead of %d", ErrRevisionCompacted, since, w.revision)
+	}
+	oldest := w.revision - uint64(len(w.history))
+	if since < oldest {
+		return nil, nil, fmt.Errorf("%w: %d < %d", ErrRevisionCompacted, since, oldest)
+	}
+	n := len(w.history)
+	replay := int(w.revision - since)
+	ch := make(chan RouteEvent, replay+watchBuffer)
+	for i := n - replay; i < n; i++ {
+		ch <- w.history[(w.head+i)%n]
+	}
+	if w.watchers == nil {
+		w.watchers = map[chan RouteEvent]struct{}{}
+	}
+	w.watchers[ch] = struct{}{}
+	stop := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.watch.watchers[ch]; ok {
+			delete(t.watch.watchers, ch)
+			close(ch)
+		}
+	}
+	return ch, stop, nil
+}
+
+// publish must be called with t.mu held so events are ordered by revision.
+func (t *Table) publish(events ...RouteEvent) {
+	w := &t.watch
+	for _, ev := range events {
+		w.revision++
+		ev.Revision = w.revision
+		ev.Route.paths = nil
+		if ev.Previous != nil {
+			prev := *ev.Previous
+			prev.paths = nil
+			ev.Previous = &prev
+		}
+		if len(w.history) < watchHistory {
+			w.history = append(w.history, ev)
+		} else {
+			w.history[w.head] = ev
+			w.head = (w.head + 1) % watchHistory
+		}
+		for ch := range w.watchers {
+			select {
+			case ch <- ev:
+			default:
+				delete(w.watchers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+func diffRoutes(before []Route, after []Route) []RouteEvent {
+	remaining := make(map[string]int, len(before))
+	for _, route := range before {
+		remaining[routeIdentity(route)]++
+	}
+	var added []RouteEvent
+	for _, route := range after {
+		key := routeIdentity(route)
+		if remaining[key] > 0 {
+			remaining[key]--
+			continue
+		}
+		added = append(added, RouteEvent{Type: EventAdd, Route: route})
+	}
+	var events []RouteEvent
+	for _, route := range before {
+		key := routeIdentity(route)
+		if remaining[key] > 0 {
+			remaining[key]--
+			events = append(events, RouteEvent{Type: EventRemove, Route: route})
+		}
+	}
+	return append(events, added...)
+}
+
+func routeIdentity(r Route) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s|%s|%s|%d|%d|%s|%d|%s", r.Destination.String(), r.Gateway.String(), r.Interface, r.Metric, r.Distance, r.Source, r.Tag, r.TargetVRF)
+	for _, hop := range r.NextHops {
+		fmt.Fprintf(&b, "|%s,%s,%d,%s", hop.Gateway.String(), hop.Interface, hop.Weight, hop.Probe)
+	}
+	return b.String()
+}
@@ -0,0 +1,112 @@
+package routing
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestWatchStreamsAndResumes(t *testing.T) {
+	_, aNet, _ := net.ParseCIDR("10.0.0.0/24")
+	_, bNet, _ := net.ParseCIDR("10.1.0.0/24")
+	table := NewTable([]Route{{Destination: *aNet, Interface: "eth0", Source: SourceConnected}})
+	events, stop, err := table.Watch(table.Revision())
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	defer stop()
+
+	static := Route{Destination: *bNet, Gateway: net.ParseIP("10.0.0.1"), Distance: 1, Source: SourceStatic}
+	table.Add(static)
+	updated := static
+	updated.Metric = 20
+	table.UpdateRoute(static, updated)
+	table.RemoveRoute(updated)
+	table.ReplaceRoutes([]Route{{Destination: *aNet, Interface: "eth1", Source: SourceConnected}})
+
+	want := []struct {
+		typ   string
+		iface string
+	}{{EventAdd, ""}, {EventUpdate, ""}, {EventRemove, ""}, {EventRemove, "eth0"}, {EventAdd, "eth1"}}
+	for i, w := range want {
+		ev := <-events
+		if ev.Revision != uint64(i+1) || ev.Type != w.typ || ev.Route.Interface != w.iface {
+			t.Fatalf("event %d: unexpected %+v", i, ev)
+		}
+		if ev.Type == EventUpdate && (ev.Previous == nil || ev.Previous.Metric != 0 || ev.Route.Metric != 20) {
+			t.Fatalf("unexpected update event: %+v", ev)
+		}
+	}
+	if table.Revision() != 5 {
+		t.Fatalf("expected revision 5, got %d", table.Revision())
+	}
+
+	resumed, stopResumed, err := table.Watch(3)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	defer stopResumed()
+	if ev := <-resumed; ev.Revision != 4 || ev.Type != EventRemove {
+		t.Fatalf("expected replay from revision 4, got %+v", ev)
+	}
+	if ev := <-resumed; ev.Revision != 5 {
+		t.Fatalf("expected replay of revision 5, got %+v", ev)
+	}
+}
+
+func TestWatchCompactionAndSlowWatcher(t *testing.T) {
+	table := NewTable(nil)
+	slow, _, err := table.Watch(0)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	for i := 0; i < watchHistory+watchBuffer+1; i++ {
+		_, dst, _ := net.ParseCIDR("10.0.0.0/8")
+		dst.IP[1], dst.IP[2] = byte(i>>8), byte(i)
+		dst.Mask = net.CIDRMask(24, 32)
+		table.Add(Route{Destination: *dst, Interface: "eth0"})
+	}
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != watchBuffer {
+		t.Fatalf("expected slow watcher to be closed after %d events, got %d", watchBuffer, received)
+	}
+	if _, _, err := table.Watch(0); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected compacted error, got %v", err)
+	}
+	since := table.Revision() - watchHistory
+	resumed, stop, err := table.Watch(since)
+	if err != nil {
+		t.Fatalf("expected oldest revision to be resumable: %v", err)
+	}
+	stop()
+	want := since + 1
+	for ev := range resumed {
+		if ev.Revision != want {
+			t.Fatalf("expected replay in revision order, got %d want %d", ev.Revision, want)
+		}
+		want++
+	}
+	if want != table.Revision()+1 {
+		t.Fatalf("expected %d replayed events, got %d", watchHistory, want-since-1)
+	}
+}
+
+func TestWatchRejectsRevisionAheadOfTable(t *testing.T) {
+	table := NewTable(nil)
+	_, dst, _ := net.ParseCIDR("10.0.0.0/24")
+	table.Add(Route{Destination: *dst, Interface: "eth0"})
+	if _, _, err := table.Watch(table.Revision() + 5); !errors.Is(err, ErrRevisionCompacted) {
+		t.Fatalf("expected revision ahead of table to need a resync, got %v", err)
+	}
+	events, stop, err := table.Watch(table.Revision())
+	if err != nil {
+		t.Fatalf("expected current revision to be resumable: %v", err)
+	}
+	stop()
+	if _, ok := <-events; ok {
+		t.Fatalf("expected no replay from the current revision")
+	}
+}
@@ -0,0 +1,253 @@
+package schedule
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+// Schedule is a named weekly time window. Empty Days means every day and
+// empty Ranges the whole day.
+type Schedule struct {
+	Name   string
+	Days   []time.Weekday
+	Ranges []Range
+}
+
+// Range is a time of day window in minutes since midnight, End exclusive.
+// A range with End before Start runs past midnight: it starts on a listed
+// day and ends on the following one.
+type Range struct {
+	Start int
+	End   int
+}
+
+func (r Range) Wraps() bool {
+	return r.End <= r.Start
+}
+
+func (r Range) String() string {
+	return FormatClock(r.Start) + "-" + FormatClock(r.End)
+}
+
+var dayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+// ParseDays accepts three letter day names, full names and the "weekdays"
+// and "weekend" groups. The result is sorted from Sunday.
+func ParseDays(values []string) ([]time.Weekday, error) {
+	seen := map[time.Weekday]bool{}
+	for _, value := range values {
+		key := strings.ToLower(strings.TrimSpace(value))
+		days, ok := dayNames[key]
+		if !ok && len(key) > 3 {
+			days, ok = dayNames[key[:3]]
+			ok = ok && len(days) == 1 && strings.EqualFold(days[0].String(), key)
+		}
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", value)
+		}
+		for _, day := range days {
+			seen[day] = true
+		}
+	}
+	out := make([]time.Weekday, 0, len(seen))
+	for day := range seen {
+		out = append(out, day)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out, nil
+}
+
+// ParseRange parses "HH:MM-HH:MM"; "24:00" is accepted as an end of day.
+func ParseRange(value string) (Range, error) {
+	start, end, ok := strings.Cut(strings.TrimSpace(value), "-")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid time range %q", value)
+	}
+	from, err := parseClock(start)
+	if err != nil || from == minutesPerDay {
+		return Range{}, fmt.Errorf("invalid time range %q", value)
+	}
+	to, err := parseClock(end)
+	if err != nil || from == to {
+		return Range{}, fmt.Errorf("invalid time range %q", value)
+	}
+	return Range{Start: from, End: to}, nil
+}
+
+func parseClock(value string) (int, error) {
+	hh, mm, ok := strings.Cut(strings.TrimSpace(value), ":")
+	if !ok || len(mm) != 2 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	hours, err := strconv.Atoi(hh)
+	if err != nil || hours < 0 || hours > 24 {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	minutes, err := strconv.Atoi(mm)
+	if err != nil || minutes < 0 || minutes > 59 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	return hours*60 + minutes, nil
+}
+
+func FormatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func ValidName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ActiveAt reports whether t, already in the schedule's location, falls in
+// the schedule.
+func (s Schedule) ActiveAt(t time.Time) bool {
+	day := t.Weekday()
+	minute := t.Hour()*60 + t.Minute()
+	if len(s.Ranges) == 0 {
+		return s.onDay(day)
+	}
+	for _, r := range s.Ranges {
+		if !r.Wraps() {
+			if minute >= r.Start && minute < r.End && s.onDay(day) {
+				return true
+			}
+			continue
+		}
+		if minute >= r.Start && s.onDay(day) {
+			return true
+		}
+		if minute < r.End && s.onDay((day+6)%7) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Schedule) onDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry holds the named schedules rules refer to and the clock and
+// timezone they are evaluated in.
+type Registry struct {
+	mu        sync.RWMutex
+	schedules map[string]Schedule
+	loc       *time.Location
+	now       func() time.Time
+}
+
+// NewRegistry builds a registry evaluating schedules in loc; nil means UTC.
+func NewRegistry(schedules []Schedule, loc *time.Location) *Registry {
+	if loc == nil {
+		loc = time.UTC
+	}
+	r := &Registry{loc: loc, now: time.Now}
+	r.Replace(schedules)
+	return r
+}
+
+// SetClock replaces the time source, mainly for tests.
+func (r *Registry) SetClock(now func() time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = now
+}
+
+func (r *Registry) Replace(schedules []Schedule) {
+	next := make(map[string]Schedule, len(schedules))
+	for _, s := range schedules {
+		next[s.Name] = s
+	}
+	r.mu.Lock()
+	r.schedules = next
+	r.mu.Unlock()
+}
+
+func (r *Registry) Location() *time.Location {
+	if r == nil {
+		return time.UTC
+	}
+	return r.loc
+}
+
+func (r *Registry) Get(name string) (Schedule, bool) {
+	if r == nil {
+		return Schedule{}, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	s, ok := r.schedules[name]
+	return s, ok
+}
+
+func (r *Registry) Has(name string) bool {
+	_, ok := r.Get(name)
+	return ok
+}
+
+func (r *Registry) List() []Schedule {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	out := make([]Schedule, 0, len(r.schedules))
+	for _, s := range r.schedules {
+		out = append(out, s)
+	}
+	r.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Active reports whether the named schedule is active now. An empty name is
+// always active; an unknown schedule never is.
+func (r *Registry) Active(name string) bool {
+	if name == "" {
+		return true
+	}
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	s, ok := r.schedules[name]
+	now := r.now
+	r.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	return s.ActiveAt(now().In(r.loc))
+}
@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func mustSchedule(t *testing.T, name string, days []string, ranges ...string) Schedule {
+	t.Helper()
+	parsedDays, err := ParseDays(days)
+	if err != nil {
+		t.Fatalf("days: %v", err)
+	}
+	s := Schedule{Name: name, Days: parsedDays}
+	for _, value := range ranges {
+		r, err := ParseRange(value)
+		if err != nil {
+			t.Fatalf("range: %v", err)
+		}
+		s.Ranges = append(s.Ranges, r)
+	}
+	return s
+}
+
+func TestRegistryActive(t *testing.T) {
+	loc := time.FixedZone("MSK", 3*60*60)
+	reg := NewRegistry([]Schedule{
+		mustSchedule(t, "office", []string{"weekdays"}, "09:00-18:00"),
+		mustSchedule(t, "night", []string{"fri", "Saturday"}, "22:00-06:00"),
+		mustSchedule(t, "weekend", []string{"weekend"}),
+	}, loc)
+	// 2026-10-16 is a Friday.
+	now := time.Date(2026, 10, 16, 6, 30, 0, 0, time.UTC)
+	reg.SetClock(func() time.Time { return now })
+
+	cases := []struct {
+		at   time.Time
+		name string
+		want bool
+	}{
+		{time.Date(2026, 10, 16, 9, 30, 0, 0, loc), "office", true},
+		{time.Date(2026, 10, 16, 18, 0, 0, 0, loc), "office", false},
+		{time.Date(2026, 10, 17, 10, 0, 0, 0, loc), "office", false},
+		{time.Date(2026, 10, 16, 23, 0, 0, 0, loc), "night", true},
+		{time.Date(2026, 10, 17, 5, 59, 0, 0, loc), "night", true},
+		{time.Date(2026, 10, 18, 5, 0, 0, 0, loc), "night", true},
+		{time.Date(2026, 10, 16, 5, 0, 0, 0, loc), "night", false},
+		{time.Date(2026, 10, 19, 5, 0, 0, 0, loc), "night", false},
+		{time.Date(2026, 10, 18, 12, 0, 0, 0, loc), "weekend", true},
+		{time.Date(2026, 10, 18, 12, 0, 0, 0, loc), "missing", false},
+		{time.Date(2026, 10, 18, 12, 0, 0, 0, loc), "", true},
+	}
+	for _, tc := range cases {
+		now = tc.at
+		if got := reg.Active(tc.name); got != tc.want {
+			t.Fatalf("Active(%q) at %s = %v, want %v", tc.name, tc.at, got, tc.want)
+		}
+	}
+
+	// 06:30 UTC is 09:30 in the registry timezone.
+	now = time.Date(2026, 10, 16, 6, 30, 0, 0, time.UTC)
+	if !reg.Active("office") {
+		t.Fatalf("expected schedule to be evaluated in the registry timezone")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	for _, value := range []string{"9:00", "08:00-08:00", "25:00-26:00", "24:00-06:00", "08:60-09:00", "08:00-24:01"} {
+		if _, err := ParseRange(value); err == nil {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+	r, err := ParseRange("18:30-24:00")
+	if err != nil || r.Start != 18*60+30 || r.End != minutesPerDay || r.Wraps() {
+		t.Fatalf("unexpected range %+v %v", r, err)
+	}
+	if _, err := ParseDays([]string{"mon", "funday"}); err == nil {
+		t.Fatalf("expected invalid day to be rejected")
+	}
+	days, err := ParseDays([]string{"weekend", "Monday", "sun"})
+	if err != nil || len(days) != 3 || days[0] != time.Sunday || days[1] != time.Monday || days[2] != time.Saturday {
+		t.Fatalf("unexpected days %v %v", days, err)
+	}
+}
@@ -0,0 +1,247 @@
+package vrf
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+
+	"router-go/pkg/firewall"
+	"router-go/pkg/nat"
+	"router-go/pkg/network"
+	"router-go/pkg/routing"
+)
+
+const Default = "default"
+
+var (
+	ErrExists       = errors.New("vrf already exists")
+	ErrNotFound     = errors.New("vrf not found")
+	ErrLeakExists   = errors.New("route leak already exists")
+	ErrLeakNotFound = errors.New("route leak not found")
+	ErrLeakSelf     = errors.New("route leak must target another vrf")
+)
+
+type Instance struct {
+	Name       string
+	Routes     *routing.Table
+	Policy     *routing.Policy
+	Firewall   *firewall.Engine
+	NAT        *nat.Table
+	Interfaces []string
+	LocalIPs   []net.IP
+}
+
+type Leak struct {
+	From        string
+	To          string
+	Destination net.IPNet
+	Metric      int
+}
+
+type Manager struct {
+	mu        sync.RWMutex
+	instances map[string]*Instance
+	ifaceVRF  map[string]string
+	leaks     []Leak
+}
+
+func NewManager(defaultInstance *Instance) *Manager {
+	if defaultInstance == nil {
+		defaultInstance = &Instance{}
+	}
+	defaultInstance.Name = Default
+	fillInstance(defaultInstance)
+	m := &Manager{
+		instances: map[string]*Instance{},
+		ifaceVRF:  map[string]string{},
+	}
+	m.instances[Default] = defaultInstance
+	for _, iface := range defaultInstance.Interfaces {
+		m.ifaceVRF[iface] = Default
+	}
+	return m
+}
+
+func (m *Manager) Add(inst *Instance) error {
+	if inst == nil || inst.Name == "" {
+		return ErrNotFound
+	}
+	fillInstance(inst)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.instances[inst.Name]; ok {
+		return ErrExists
+	}
+	m.instances[inst.Name] = inst
+	for _, iface := range inst.Interfaces {
+		m.ifaceVRF[iface] = inst.Name
+	}
+	return nil
+}
+
+func (m *Manager) Get(name string) (*Instance, bool) {
+	if name == "" {
+		name = Default
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	inst, ok := m.instances[name]
+	return inst, ok
+}
+
+func (m *Manager) Default() *Instance {
+	inst, _ := m.Get(Default)
+	return inst
+}
+
+func (m *Manager) ForInterface(iface string) *Instance {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if name, ok := m.ifaceVRF[iface]; ok {
+		return m.instances[name]
+	}
+	return m.instances[Default]
+}
+
+func (m *Manager) Names() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]string, 0, len(m.instances))
+	for name := range m.instances {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (m *Manager) AllRoutes() []routing.Route {
+	m.mu.RLock()
+	instances := make([]*Instance, 0, len(m.instances))
+	for _, inst := range m.instances {
+		instances = append(instances, inst)
+	}
+	m.mu.RUnlock()
+	var out []routing.Route
+	for _, inst := range instances {
+		out = append(out, inst.Policy.AllRoutes()...)
+	}
+	return out
+}
+
+func (m *Manager) Leaks() []Leak {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Leak, len(m.leaks))
+	copy(out, m.leaks)
+	return out
+}
+
+func (m *Manager) AddLeak(leak Leak) error {
+	if leak.From == "" {
+		leak.From = Default
+	}
+	if leak.To == "" {
+		leak.To = Default
+	}
+	if leak.From == leak.To {
+		return ErrLeakSelf
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, ok := m.instances[leak.From]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := m.instances[leak.To]; !ok {
+		return ErrNotFound
+	}
+	for _, existing := range m.leaks {
+		if leakEqual(existing, leak) {
+			return ErrLeakExists
+		}
+	}
+	m.leaks = append(m.leaks, leak)
+	from.Routes.Add(leakRoute(leak))
+	return nil
+}
+
+func (m *Manager) RemoveLeak(leak Leak) error {
+	if leak.From == "" {
+		leak.From = Default
+	}
+	if leak.To == "" {
+		leak.To = Default
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.leaks {
+		if !leakEqual(existing, leak) {
+			continue
+		}
+		if from, ok := m.instances[existing.From]; ok {
+			from.Routes.RemoveRoute(leakRoute(existing))
+		}
+		m.leaks = append(m.leaks[:i], m.leaks[i+1:]...)
+		return nil
+	}
+	return ErrLeakNotFound
+}
+
+func (m *Manager) LookupFlow(inst *Instance, pkt network.Packet) (routing.Route, routing.NextHop, *Instance, bool) {
+	route, hop, ok := lookupInstance(inst, pkt)
+	if !ok {
+		return routing.Route{}, routing.NextHop{}, inst, false
+	}
+	if route.TargetVRF == "" || route.TargetVRF == inst.Name {
+		return route, hop, inst, true
+	}
+	target, exists := m.Get(route.TargetVRF)
+	if !exists {
+		return routing.Route{}, routing.NextHop{}, inst, false
+	}
+	route, hop, ok = lookupInstance(target, pkt)
+	if !ok || route.TargetVRF != "" {
+		return routing.Route{}, routing.NextHop{}, target, false
+	}
+	return route, hop, target, true
+}
+
+func lookupInstance(inst *Instance, pkt network.Packet) (routing.Route, routing.NextHop, bool) {
+	if inst.Policy != nil {
+		route, hop, _, ok := inst.Policy.LookupFlow(pkt)
+		return route, hop, ok
+	}
+	return inst.Routes.LookupFlow(pkt.Metadata)
+}
+
+func fillInstance(inst *Instance) {
+	if inst.Routes == nil {
+		if inst.Policy != nil {
+			inst.Routes = inst.Policy.Main()
+		} else {
+			inst.Routes = routing.NewTable(nil)
+		}
+	}
+	if inst.Policy == nil {
+		inst.Policy = routing.NewPolicy(inst.Routes)
+	}
+	if inst.Firewall == nil {
+		inst.Firewall = firewall.NewEngine(nil)
+	}
+	if inst.NAT == nil {
+		inst.NAT = nat.NewTable(nil)
+	}
+}
+
+func leakRoute(leak Leak) routing.Route {
+	return routing.Route{
+		Destination: leak.Destination,
+		Metric:      leak.Metric,
+		TargetVRF:   leak.To,
+	}
+}
+
+func leakEqual(a Leak, b Leak) bool {
+	return a.From == b.From && a.To == b.To && a.Metric == b.Metric && a.Destination.String() == b.Destination.String()
+}
@@ -0,0 +1,117 @@
+package vrf
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"router-go/pkg/network"
+	"router-go/pkg/routing"
+)
+
+func mustCIDR(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, parsed, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("parse %s: %v", cidr, err)
+	}
+	return *parsed
+}
+
+func packetTo(dst string) network.Packet {
+	return network.Packet{Metadata: network.PacketMetadata{
+		SrcIP:    net.ParseIP("10.0.0.2"),
+		DstIP:    net.ParseIP(dst),
+		Protocol: "TCP",
+		SrcPort:  40000,
+		DstPort:  443,
+	}}
+}
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	m := NewManager(&Instance{
+		Routes:     routing.NewTable([]routing.Route{{Destination: mustCIDR(t, "0.0.0.0/0"), Interface: "wan"}}),
+		Interfaces: []string{"lan"},
+	})
+	err := m.Add(&Instance{
+		Name:       "tenant",
+		Routes:     routing.NewTable([]routing.Route{{Destination: mustCIDR(t, "192.168.50.0/24"), Interface: "tenant-lan"}}),
+		Interfaces: []string{"tenant0"},
+	})
+	if err != nil {
+		t.Fatalf("add vrf: %v", err)
+	}
+	return m
+}
+
+func TestVRFIsolation(t *testing.T) {
+	m := newTestManager(t)
+	tenant := m.ForInterface("tenant0")
+	if tenant.Name != "tenant" {
+		t.Fatalf("expected tenant vrf for tenant0, got %s", tenant.Name)
+	}
+	if _, _, _, ok := m.LookupFlow(tenant, packetTo("8.8.8.8")); ok {
+		t.Fatalf("tenant vrf must not see default routes")
+	}
+	if inst := m.ForInterface("unknown0"); inst.Name != Default {
+		t.Fatalf("expected unassigned interface in default vrf, got %s", inst.Name)
+	}
+	if _, _, _, ok := m.LookupFlow(m.Default(), packetTo("192.168.50.10")); !ok {
+		t.Fatalf("expected default route to cover tenant prefix in default vrf")
+	}
+	if err := m.Add(&Instance{Name: "tenant"}); !errors.Is(err, ErrExists) {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+}
+
+func TestRouteLeakResolvesInTargetVRF(t *testing.T) {
+	m := newTestManager(t)
+	tenant := m.ForInterface("tenant0")
+	leak := Leak{From: "tenant", To: Default, Destination: mustCIDR(t, "8.8.8.0/24")}
+	if err := m.AddLeak(leak); err != nil {
+		t.Fatalf("add leak: %v", err)
+	}
+	if err := m.AddLeak(leak); !errors.Is(err, ErrLeakExists) {
+		t.Fatalf("expected ErrLeakExists, got %v", err)
+	}
+	_, hop, target, ok := m.LookupFlow(tenant, packetTo("8.8.8.8"))
+	if !ok || hop.Interface != "wan" || target.Name != Default {
+		t.Fatalf("expected leak via default/wan, got ok=%v hop=%+v target=%s", ok, hop, target.Name)
+	}
+	if _, _, _, ok := m.LookupFlow(tenant, packetTo("1.1.1.1")); ok {
+		t.Fatalf("only the leaked prefix should be reachable")
+	}
+
+	if err := m.RemoveLeak(leak); err != nil {
+		t.Fatalf("remove leak: %v", err)
+	}
+	if _, _, _, ok := m.LookupFlow(tenant, packetTo("8.8.8.8")); ok {
+		t.Fatalf("expected no route after leak removal")
+	}
+	if err := m.RemoveLeak(leak); !errors.Is(err, ErrLeakNotFound) {
+		t.Fatalf("expected ErrLeakNotFound, got %v", err)
+	}
+}
+
+func TestRouteLeaksDoNotChain(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.Add(&Instance{Name: "transit", Interfaces: []string{"transit0"}}); err != nil {
+		t.Fatalf("add vrf: %v", err)
+	}
+	if err := m.AddLeak(Leak{From: "tenant", To: "transit", Destination: mustCIDR(t, "0.0.0.0/0")}); err != nil {
+		t.Fatalf("add leak: %v", err)
+	}
+	if err := m.AddLeak(Leak{From: "transit", To: Default, Destination: mustCIDR(t, "0.0.0.0/0")}); err != nil {
+		t.Fatalf("add leak: %v", err)
+	}
+	if _, _, _, ok := m.LookupFlow(m.ForInterface("tenant0"), packetTo("8.8.8.8")); ok {
+		t.Fatalf("leaks must not be followed transitively")
+	}
+	if err := m.AddLeak(Leak{From: "tenant", To: "tenant", Destination: mustCIDR(t, "10.0.0.0/8")}); !errors.Is(err, ErrLeakSelf) {
+		t.Fatalf("expected ErrLeakSelf, got %v", err)
+	}
+	if err := m.AddLeak(Leak{From: "tenant", To: "missing", Destination: mustCIDR(t, "10.0.0.0/8")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}